@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// defaultAccessLogSampleRate is the share of the requests logged once the traffic passes
+// the sampling threshold.
+const defaultAccessLogSampleRate = 0.1
+
+// accessLogWriter records the status and the size of the response written by a handler.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (aw *accessLogWriter) WriteHeader(status int) {
+	if aw.status == 0 {
+		aw.status = status
+	}
+	aw.ResponseWriter.WriteHeader(status)
+}
+
+func (aw *accessLogWriter) Write(b []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	n, err := aw.ResponseWriter.Write(b)
+	aw.bytes += n
+	return n, err
+}
+
+// Flush keeps the streaming endpoints working behind the access log.
+func (aw *accessLogWriter) Flush() {
+	if flusher, ok := aw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack keeps the WebSocket upgrade working behind the access log.
+func (aw *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := aw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && aw.status == 0 {
+		aw.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer.
+func (aw *accessLogWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
+
+// accessLogSampler decides which requests are logged once the traffic passes the
+// per second threshold.
+type accessLogSampler struct {
+	// after is the number of requests logged in full every second; 0 logs all of them.
+	after int64
+	// rate is the share of the requests past the threshold that are logged.
+	rate float64
+
+	second atomic.Int64
+	count  atomic.Int64
+}
+
+// sample reports whether the request finishing now is logged.
+func (s *accessLogSampler) sample(now time.Time) bool {
+	if s.after == 0 {
+		return true
+	}
+
+	// Start Counting Again Every Second
+	if second := now.Unix(); s.second.Swap(second) != second {
+		s.count.Store(0)
+	}
+	if s.count.Add(1) <= s.after {
+		return true
+	}
+	return rand.Float64() < s.rate
+}
+
+// withAccessLog logs every request served by next with its method, path, status,
+// latency, and response size, and the request ID set by withRequestID.
+//
+// The paths listed in cfg.Exclude are not logged; by default these are /healthz,
+// /readyz, and /metrics. At high volume only the first cfg.SampleAfter requests of
+// every second are logged in full, and of the rest a share of cfg.SampleRate (between
+// 0 and 1). With cfg.SampleAfter at 0 nothing is sampled. Server errors are always
+// logged.
+//
+// Parameters:
+//   - next: The handler whose requests are logged.
+//   - cfg: The access log settings.
+//
+// Returns:
+//   - http.Handler: The handler logging the requests.
+func withAccessLog(next http.Handler, cfg AccessLogConfig) http.Handler {
+	excluded := make(map[string]bool)
+	for _, path := range cfg.Exclude {
+		excluded[path] = true
+	}
+
+	sampler := &accessLogSampler{after: int64(cfg.SampleAfter), rate: cfg.SampleRate}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if excluded[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		aw := &accessLogWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if !sampler.sample(time.Now()) {
+			return
+		}
+
+		slog.LogAttrs(r.Context(), level, "HTTP Request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+			slog.Int("bytes", aw.bytes),
+		)
+	})
+}
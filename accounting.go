@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moabdelazem/gobank/money"
+)
+
+// Default Chart Of Accounts Of The Journal Export
+const (
+	defaultCustomerDepositsAccount = "Customer Deposits"
+	defaultCashAccount             = "Cash"
+	defaultCardClearingAccount     = "Card Clearing"
+	defaultTransferClearingAccount = "Transfer Clearing"
+	defaultSuspenseAccount         = "Suspense"
+	defaultInterestExpenseAccount  = "Interest Expense"
+)
+
+// Journal Export Formats
+const (
+	// JournalFormatCSV is one row per journal line, with debit and credit columns, for
+	// the generic ledger imports, such as those of Xero or QuickBooks Online.
+	JournalFormatCSV = "csv"
+	// JournalFormatIIF is the Intuit Interchange Format of QuickBooks Desktop, one
+	// GENERAL JOURNAL transaction per entry.
+	JournalFormatIIF = "iif"
+)
+
+// AuditActionJournalExport records an export of the journal of the bank.
+const AuditActionJournalExport = "accounting.journal_export"
+
+// journalCSVHeader is the header row of the CSV journal export.
+var journalCSVHeader = []string{"entry", "date", "account", "debit", "credit", "memo", "account_id", "transaction_id", "currency"}
+
+// journalIIFHeader declares the columns of the transaction and split lines of the IIF
+// journal export.
+var journalIIFHeader = []string{
+	"!TRNS\tTRNSID\tTRNSTYPE\tDATE\tACCNT\tNAME\tAMOUNT\tDOCNUM\tMEMO",
+	"!SPL\tSPLID\tTRNSTYPE\tDATE\tACCNT\tNAME\tAMOUNT\tDOCNUM\tMEMO",
+	"!ENDTRNS",
+}
+
+// JournalLine is one side of a journal entry: an amount debited or credited to an
+// account of the chart of accounts.
+type JournalLine struct {
+	Account string
+	// Debit and Credit are in the currency of the ledger entry, one of them zero.
+	Debit  money.Money
+	Credit money.Money
+	// AccountID is the bank account of a customer deposits line, zero for the others.
+	AccountID int
+}
+
+// JournalEntry is the balanced double-entry booking of a ledger entry.
+type JournalEntry struct {
+	TransactionID int
+	Date          time.Time
+	Memo          string
+	// Lines are the debit first, then the credit.
+	Lines []JournalLine
+}
+
+// journalEntry books a ledger entry of a bank account against the customer deposits
+// liability: the money an account receives is owed to its holder, credited to the
+// deposits and debited to where it came from, and the money it sends the reverse.
+// Each side of a transfer is booked against the transfer clearing account, so every
+// entry balances on its own.
+func journalEntry(cfg AccountingConfig, t *Transaction) *JournalEntry {
+	offset := cfg.SuspenseAccount
+	memo := t.Type
+	switch t.Type {
+	case TransactionTypeTransfer:
+		offset = cfg.TransferClearingAccount
+		memo = fmt.Sprintf("transfer from account %d", t.CounterpartyID)
+		if t.Amount.IsNegative() {
+			memo = fmt.Sprintf("transfer to account %d", t.CounterpartyID)
+		}
+	case TransactionTypeDeposit:
+		offset = cfg.CashAccount
+	case TransactionTypeCardTopUp:
+		offset = cfg.CardClearingAccount
+		memo = "card top-up"
+	case TransactionTypeInterest:
+		offset = cfg.InterestExpenseAccount
+	}
+	if t.Reference != "" {
+		memo += " " + t.Reference
+	}
+	if t.Category != "" {
+		memo += ", " + t.Category
+	}
+
+	entry := &JournalEntry{TransactionID: t.ID, Date: t.CreatedAt.UTC(), Memo: memo}
+	zero := money.Zero(t.Currency)
+	deposits := JournalLine{Account: cfg.CustomerDepositsAccount, AccountID: t.AccountID, Debit: zero, Credit: zero}
+	counter := JournalLine{Account: offset, Debit: zero, Credit: zero}
+	if !t.Amount.IsNegative() {
+		counter.Debit, deposits.Credit = t.Amount, t.Amount
+		entry.Lines = []JournalLine{counter, deposits}
+	} else {
+		deposits.Debit, counter.Credit = t.Amount.Neg(), t.Amount.Neg()
+		entry.Lines = []JournalLine{deposits, counter}
+	}
+	return entry
+}
+
+// journalWriter writes the journal entries of an export in one of the formats.
+type journalWriter interface {
+	// Header writes what comes before the first entry.
+	Header() error
+	Entry(*JournalEntry) error
+	Flush() error
+}
+
+// journalContentTypes maps the journal export formats to their media type.
+var journalContentTypes = map[string]string{
+	JournalFormatCSV: csvContentType + "; charset=utf-8",
+	JournalFormatIIF: "application/x-iif; charset=utf-8",
+}
+
+// newJournalWriter creates the writer of a format of journalContentTypes, writing the
+// amounts with the decimals of their currency.
+func newJournalWriter(format string, w io.Writer) journalWriter {
+	if format == JournalFormatIIF {
+		return &iifJournalWriter{bw: bufio.NewWriter(w)}
+	}
+	return &csvJournalWriter{cw: csv.NewWriter(w)}
+}
+
+// csvJournalWriter writes the journal as one CSV row per line.
+type csvJournalWriter struct {
+	cw *csv.Writer
+}
+
+func (jw *csvJournalWriter) Header() error {
+	return jw.cw.Write(journalCSVHeader)
+}
+
+func (jw *csvJournalWriter) Entry(entry *JournalEntry) error {
+	for _, line := range entry.Lines {
+		row := []string{
+			strconv.Itoa(entry.TransactionID),
+			entry.Date.Format(time.DateOnly),
+			line.Account,
+			"", "",
+			entry.Memo,
+			"",
+			strconv.Itoa(entry.TransactionID),
+			line.Debit.Currency,
+		}
+		if !line.Debit.IsZero() {
+			row[3] = line.Debit.Decimal()
+		}
+		if !line.Credit.IsZero() {
+			row[4] = line.Credit.Decimal()
+		}
+		if line.AccountID != 0 {
+			row[6] = strconv.Itoa(line.AccountID)
+		}
+		if err := jw.cw.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (jw *csvJournalWriter) Flush() error {
+	jw.cw.Flush()
+	return jw.cw.Error()
+}
+
+// iifJournalWriter writes the journal as IIF general journal transactions: the debit
+// line as the TRNS line with a positive amount, the credit as its SPL line with a
+// negative one.
+type iifJournalWriter struct {
+	bw *bufio.Writer
+}
+
+func (jw *iifJournalWriter) Header() error {
+	for _, line := range journalIIFHeader {
+		if _, err := jw.bw.WriteString(line + "\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (jw *iifJournalWriter) Entry(entry *JournalEntry) error {
+	docNum := strconv.Itoa(entry.TransactionID)
+	// The Fields Are Tab Separated, So The Memo Must Not Hold Tabs Or Line Breaks
+	memo := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ", "\"", "'").Replace(entry.Memo)
+	for i, line := range entry.Lines {
+		kind, amount := "TRNS", line.Debit
+		if i > 0 {
+			kind, amount = "SPL", line.Credit.Neg()
+		}
+		lineMemo := memo
+		if line.AccountID != 0 {
+			lineMemo = fmt.Sprintf("account %d: %s", line.AccountID, memo)
+		}
+		fields := []string{kind, "", "GENERAL JOURNAL", entry.Date.Format("01/02/2006"), line.Account, "", amount.Decimal(), docNum, lineMemo}
+		if _, err := jw.bw.WriteString(strings.Join(fields, "\t") + "\r\n"); err != nil {
+			return err
+		}
+	}
+	_, err := jw.bw.WriteString("ENDTRNS\r\n")
+	return err
+}
+
+func (jw *iifJournalWriter) Flush() error {
+	return jw.bw.Flush()
+}
+
+// writeJournal books the ledger entries of every account created in [from, to) and
+// writes them with jw, flushing it at the end.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - jw: The writer of the export format.
+//   - from: The first time included.
+//   - to: The first time excluded.
+//   - begin: Called before the header, once no error can happen before the first entry.
+//
+// Returns:
+//   - error: An error if the ledger cannot be read or written.
+func (as *APIServer) writeJournal(ctx context.Context, jw journalWriter, from, to time.Time, begin func()) error {
+	started := false
+	start := func() error {
+		started = true
+		begin()
+		return jw.Header()
+	}
+
+	cfg := as.config.Accounting
+	err := as.store.StreamLedger(ctx, from, to, func(t *Transaction) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return jw.Entry(journalEntry(cfg, t))
+	})
+	if err != nil {
+		// Send The Entries Written So Far, The Export Is Cut Short
+		jw.Flush()
+		return err
+	}
+
+	// An Empty Period Still Gets A Header
+	if !started {
+		if err := start(); err != nil {
+			return err
+		}
+	}
+
+	return jw.Flush()
+}
+
+// handleAdminExportJournal exports the books of the bank for a period as double-entry
+// journal lines, for finance to import into their accounting system. The period is
+// given with the "from" and "to" query parameters, as in the transaction export, and
+// the format with "format", csv by default or iif. The entries are dated in UTC. With a
+// document storage, the export is stored there and the client is redirected to it with
+// a 303 See Other. Every export is recorded in the audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to stream the journal to.
+//   - r: *http.Request containing the period and the format.
+//
+// Returns:
+//   - error: A validation error for a missing or invalid period or an unknown format, or
+//     an error if the ledger cannot be read before the first entry is written.
+func (as *APIServer) handleAdminExportJournal(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	var fieldErrs []FieldError
+
+	from, err := parseCSVTime(query.Get("from"), false)
+	if err != nil || from.IsZero() {
+		fieldErrs = append(fieldErrs, FieldError{Field: "from", Code: CodeInvalid, Message: "from must be a date or an RFC 3339 timestamp"})
+	}
+	to, err := parseCSVTime(query.Get("to"), true)
+	if err != nil || to.IsZero() {
+		fieldErrs = append(fieldErrs, FieldError{Field: "to", Code: CodeInvalid, Message: "to must be a date or an RFC 3339 timestamp"})
+	} else if !from.IsZero() && !from.Before(to) {
+		fieldErrs = append(fieldErrs, FieldError{Field: "to", Code: CodeInvalid, Message: "to must be after from"})
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = JournalFormatCSV
+	}
+	contentType, ok := journalContentTypes[format]
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "format", Code: CodeInvalid, Message: fmt.Sprintf("format must be %s or %s", JournalFormatCSV, JournalFormatIIF)})
+	}
+	if len(fieldErrs) > 0 {
+		return newValidationError(fieldErrs)
+	}
+
+	as.audit(r, AuditActionJournalExport, "journal", map[string]string{"from": from.Format(time.RFC3339), "to": to.Format(time.RFC3339), "format": format})
+
+	name := fmt.Sprintf("journal-%s-%s.%s", from.Format("20060102T150405Z"), to.Format("20060102T150405Z"), format)
+	disposition := fmt.Sprintf("attachment; filename=%q", name)
+
+	if as.documents != nil {
+		// Every Export Gets Its Own Key, Entries Back-Dated By An Import May Have Been Added
+		key := fmt.Sprintf("exports/accounting/%s-%s-%s", time.Now().UTC().Format("20060102T150405Z"), newRequestID(), name)
+		doc := DocumentInfo{ContentType: contentType, ContentDisposition: disposition}
+
+		link, err := as.storeDocument(r.Context(), key, doc, false, func(dst io.Writer) error {
+			return as.writeJournal(r.Context(), newJournalWriter(format, dst), from, to, func() {})
+		})
+		if err != nil {
+			return err
+		}
+		return redirectToDocument(w, r, link)
+	}
+
+	started := false
+
+	// Write The Headers Lazily So Early Errors Can Still Be Reported As JSON
+	err = as.writeJournal(r.Context(), newJournalWriter(format, w), from, to, func() {
+		started = true
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", disposition)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if err != nil && !started {
+		return err
+	}
+
+	return nil
+}
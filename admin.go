@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/moabdelazem/gobank/money"
+)
+
+// Audit Actions
+const (
+	AuditActionFreezeAccount   = "account.freeze"
+	AuditActionUnfreezeAccount = "account.unfreeze"
+	AuditActionSetLimits       = "account.limits"
+	AuditActionImport          = "admin.import"
+)
+
+// AuditEntry is a single record of the audit log.
+type AuditEntry struct {
+	ID        int       `json:"id"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AccountLimitsRequest is the body of PUT /admin/accounts/{id}/limits.
+type AccountLimitsRequest struct {
+	// TransferLimit is in the minor units of the currency of the account, which the
+	// handler sets once it has loaded it.
+	TransferLimit money.Money `json:"transfer_limit"`
+}
+
+// Validate checks the fields of an account limits request.
+func (req *AccountLimitsRequest) Validate() []FieldError {
+	if req.TransferLimit.IsNegative() {
+		return []FieldError{{Field: "transfer_limit", Code: CodeInvalid, Message: "transfer_limit must not be negative"}}
+	}
+	return nil
+}
+
+// withAdminAuth protects the operator endpoints with the credential of a staff member,
+// see authenticateStaff: the static admin token (ADMIN_TOKEN) in the X-Admin-Token
+// header, or a staff token of POST /admin/login. An admin reaches every route, a teller
+// only tellerRoutes. When neither credential is configured every request is rejected,
+// so the admin endpoints are disabled by default.
+func (as *APIServer) withAdminAuth(tellerRoutes map[*mux.Route]bool, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, err := as.authenticateStaff(r)
+		if errors.Is(err, errAdminDisabled) {
+			WriteError(w, http.StatusForbidden, localize(r, "admin.disabled", "admin endpoints are disabled"))
+			return
+		}
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, localize(r, "admin.invalid_token", "invalid admin token"))
+			return
+		}
+		if !staff.HasRole(RoleAdmin) && !(staff.HasRole(RoleTeller) && tellerRoutes[mux.CurrentRoute(r)]) {
+			WriteError(w, http.StatusForbidden, localize(r, "admin.role_required", "your staff role does not allow this action"))
+			return
+		}
+
+		recordRequestAdmin(r.Context(), staff.Username)
+		ctx := context.WithValue(r.Context(), staffIdentityKey{}, staff)
+		handler(w, r.WithContext(withLogAttrs(ctx, slog.String("admin_actor", staff.Username))))
+	}
+}
+
+// adminActor returns the operator name recorded in the audit log: the staff member of
+// the authenticated admin request, otherwise the X-Admin-Actor header sent with the
+// admin token.
+func adminActor(r *http.Request) string {
+	if staff := staffFromContext(r.Context()); staff != nil {
+		return staff.Username
+	}
+	if actor := r.Header.Get("X-Admin-Actor"); actor != "" {
+		return actor
+	}
+	return "admin"
+}
+
+// audit records an operator action. Failing to write the audit log is logged but does
+// not fail the action, which has already been applied.
+func (as *APIServer) audit(r *http.Request, action, target string, details interface{}) {
+	entry := &AuditEntry{Actor: adminActor(r), Action: action, Target: target}
+
+	if details != nil {
+		raw, err := json.Marshal(details)
+		if err == nil {
+			entry.Details = string(raw)
+		}
+	}
+
+	if err := as.store.CreateAuditEntry(context.WithoutCancel(r.Context()), entry); err != nil {
+		slog.ErrorContext(r.Context(), "Error Writing Audit Log", "action", action, "target", target, "error", err)
+	}
+}
+
+// registerAdminRoutes registers the operator endpoints on an /admin subrouter that
+// requires the credential of a staff member on every route, and the staff login when a
+// staff auth provider is configured.
+//
+// Routes:
+// - POST /admin/login: Authenticates a staff member against the directory and returns their staff token.
+// - GET /admin/accounts: Lists all accounts in a pagination envelope, deleted ones too with ?include_deleted=true; tellers too.
+// - POST /admin/accounts/{id:[0-9]+}/freeze: Freezes an account; tellers too.
+// - POST /admin/accounts/{id:[0-9]+}/unfreeze: Unfreezes an account; tellers too.
+// - PUT /admin/accounts/{id:[0-9]+}/limits: Adjusts the transfer limit of an account.
+// - GET /admin/audit-log: Lists the audit log in a pagination envelope.
+// - POST /admin/import: Bulk imports legacy accounts and transactions.
+// - GET /admin/accounting/journal: Exports the double-entry journal of a period as CSV or QuickBooks IIF.
+// - POST /admin/outbox/replay: Re-publishes the published events of an account or a period to the event bus.
+// - /admin/iso20022/pain.001: ISO 20022 payment initiation export, registered by registerPaymentInitiationRoutes.
+// - /admin/webhooks/secrets/...: Webhook secret endpoints, registered by registerWebhookSecretRoutes.
+// - /admin/jobs/...: Scheduled job endpoints, registered by registerSchedulerRoutes.
+// - /admin/features/...: Feature flag endpoints, registered by registerFeatureRoutes.
+// - /admin/maintenance: Maintenance mode endpoints, registered by registerMaintenanceRoutes.
+// - /admin/log-level: Log level endpoints, registered by registerLogLevelRoutes.
+// - /admin/debug/pprof/...: Profiling endpoints, registered by registerProfilingRoutes.
+func (as *APIServer) registerAdminRoutes(router *mux.Router) {
+	// The Login Is Matched Before The Protected Subrouter
+	if as.staffAuth != nil {
+		router.HandleFunc("/admin/login", makeHTTPHandlerFunc(as.handleStaffLogin)).Methods(http.MethodPost)
+	}
+
+	// The Routes Open To The Tellers, The Others Need The Admin Role
+	tellerRoutes := map[*mux.Route]bool{}
+	teller := func(route *mux.Route) { tellerRoutes[route] = true }
+
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(func(next http.Handler) http.Handler {
+		return as.withAdminAuth(tellerRoutes, next.ServeHTTP)
+	})
+
+	teller(admin.HandleFunc("/accounts", makeHTTPHandlerFunc(as.handleAdminListAccounts)).Methods(http.MethodGet))
+	teller(admin.HandleFunc("/accounts/{id:[0-9]+}/freeze", makeHTTPHandlerFunc(as.handleAdminFreezeAccount(true))).Methods(http.MethodPost))
+	teller(admin.HandleFunc("/accounts/{id:[0-9]+}/unfreeze", makeHTTPHandlerFunc(as.handleAdminFreezeAccount(false))).Methods(http.MethodPost))
+	admin.HandleFunc("/accounts/{id:[0-9]+}/limits", makeHTTPHandlerFunc(as.handleAdminSetLimits)).Methods(http.MethodPut)
+	admin.HandleFunc("/audit-log", makeHTTPHandlerFunc(as.handleAdminAuditLog)).Methods(http.MethodGet)
+	admin.HandleFunc("/import", makeHTTPHandlerFunc(as.withMoneyMovement(as.handleImport))).Methods(http.MethodPost)
+	admin.HandleFunc("/accounting/journal", makeHTTPHandlerFunc(as.handleAdminExportJournal)).Methods(http.MethodGet)
+	admin.HandleFunc("/outbox/replay", makeHTTPHandlerFunc(as.handleAdminReplayOutbox)).Methods(http.MethodPost)
+
+	as.registerWebhookSecretRoutes(admin)
+	as.registerSchedulerRoutes(admin)
+	as.registerPaymentInitiationRoutes(admin)
+	as.registerFeatureRoutes(admin)
+	as.registerMaintenanceRoutes(admin)
+	as.registerLogLevelRoutes(admin)
+	registerProfilingRoutes(admin)
+}
+
+// handleAdminListAccounts returns a page of all accounts wrapped in a PageEnvelope.
+// The page is selected with the "limit" and "offset" query parameters, and soft-deleted
+// accounts are listed too when "include_deleted" is true.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the pagination query parameters.
+//
+// Returns:
+//   - error: A TypedError if the query parameters are invalid or the accounts cannot be retrieved.
+func (as *APIServer) handleAdminListAccounts(w http.ResponseWriter, r *http.Request) error {
+	limit, offset, err := as.parsePagination(r)
+	if err != nil {
+		return err
+	}
+
+	var includeDeleted bool
+	if value := r.URL.Query().Get("include_deleted"); value != "" {
+		if includeDeleted, err = strconv.ParseBool(value); err != nil {
+			return NewTypedError(http.StatusBadRequest, "bad_request", "include_deleted must be true or false")
+		}
+	}
+
+	accs, total, err := as.store.GetAccountsPage(r.Context(), limit, offset, includeDeleted)
+	if err != nil {
+		return NewTypedError(http.StatusInternalServerError, "internal_error", "could not list accounts")
+	}
+
+	return WriteResponse(w, r, http.StatusOK, PageEnvelope{
+		Data: newAccountResources(r, accs),
+		Page: newOffsetPage(r, limit, offset, total),
+	})
+}
+
+// handleAdminFreezeAccount returns the handler that freezes (or unfreezes) an account,
+// alerts its holder, and records the action in the audit log.
+//
+// Parameters:
+//   - frozen: Whether the handler freezes or unfreezes the account.
+//
+// Returns:
+//   - apiFunc: The handler for the account in the request URL.
+func (as *APIServer) handleAdminFreezeAccount(frozen bool) apiFunc {
+	action, reason := AuditActionFreezeAccount, SecurityAccountFrozen
+	if !frozen {
+		action, reason = AuditActionUnfreezeAccount, SecurityAccountUnfrozen
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) error {
+		// Get The ID From The URL
+		id := getId(w, r)
+
+		// Freeze The Account And Store Its Security Alert Together
+		err := as.store.WithTx(r.Context(), func(tx Storage) error {
+			if err := tx.SetAccountFrozen(r.Context(), id, frozen); err != nil {
+				return err
+			}
+
+			event, err := securityAlert(id, reason, 0)
+			if err != nil {
+				return err
+			}
+			return tx.AddOutboxEvents(r.Context(), []*OutboxEvent{event})
+		})
+		if err != nil {
+			return err
+		}
+
+		as.audit(r, action, fmt.Sprintf("account:%d", id), nil)
+
+		acc, err := as.store.GetAccountById(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		return WriteResponse(w, r, http.StatusOK, newAccountResource(r, acc))
+	}
+}
+
+// handleAdminSetLimits changes the transfer limit of an account and records the
+// previous and new values in the audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the account ID and the new limits.
+//
+// Returns:
+//   - error: An error if the body is invalid or the account cannot be updated, otherwise nil.
+func (as *APIServer) handleAdminSetLimits(w http.ResponseWriter, r *http.Request) error {
+	limitsReq := new(AccountLimitsRequest)
+
+	if err := bindJSON(w, r, limitsReq); err != nil {
+		return err
+	}
+
+	// Get The ID From The URL
+	id := getId(w, r)
+
+	acc, err := as.store.GetAccountById(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	// The Limit Is In The Currency Of The Account
+	limitsReq.TransferLimit.Currency = acc.Currency
+	if err := as.store.SetTransferLimit(r.Context(), id, limitsReq.TransferLimit); err != nil {
+		return err
+	}
+
+	as.audit(r, AuditActionSetLimits, fmt.Sprintf("account:%d", id), map[string]int64{
+		"previous_transfer_limit": acc.TransferLimit.Amount,
+		"transfer_limit":          limitsReq.TransferLimit.Amount,
+	})
+
+	if acc, err = as.store.GetAccountById(r.Context(), id); err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusOK, newAccountResource(r, acc))
+}
+
+// handleAdminAuditLog returns a page of the audit log, newest first, wrapped in a PageEnvelope.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the pagination query parameters.
+//
+// Returns:
+//   - error: A TypedError if the pagination parameters are invalid or the log cannot be retrieved.
+func (as *APIServer) handleAdminAuditLog(w http.ResponseWriter, r *http.Request) error {
+	limit, offset, err := as.parsePagination(r)
+	if err != nil {
+		return err
+	}
+
+	entries, total, err := as.store.GetAuditLog(r.Context(), limit, offset)
+	if err != nil {
+		return NewTypedError(http.StatusInternalServerError, "internal_error", "could not read the audit log")
+	}
+
+	return WriteResponse(w, r, http.StatusOK, PageEnvelope{
+		Data: entries,
+		Page: newOffsetPage(r, limit, offset, total),
+	})
+}
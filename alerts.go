@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Alert Types, Each Routed To Its Slack Channel By alerts.routes
+const (
+	// AlertFraud is raised when the fraud rules flag a transfer.
+	AlertFraud = "fraud"
+	// AlertBreakerOpen is raised when the database circuit breaker opens.
+	AlertBreakerOpen = "breaker_open"
+	// AlertReconciliationMismatch is raised when a payment provider reports what the
+	// ledger contradicts, such as a settlement of a transfer already failed.
+	AlertReconciliationMismatch = "reconciliation_mismatch"
+)
+
+// alertTypes lists the alert types, for the validation of the routes.
+var alertTypes = []string{AlertFraud, AlertBreakerOpen, AlertReconciliationMismatch}
+
+// Default Alert Settings
+const (
+	defaultAlertsTimeout = 10 * time.Second
+	// alertRouteOff is the route muting an alert type.
+	alertRouteOff = "off"
+	// alertQueueSize bounds the alerts waiting to be posted; the alerts raised while it
+	// is full are only logged.
+	alertQueueSize = 64
+)
+
+// alertsSent and alertFailures count the alerts posted and the ones that could not be,
+// registered by newMetricsRegistry.
+var (
+	alertsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Subsystem: "alerts", Name: "sent_total",
+		Help: "Number of operational alerts posted to Slack, by type.",
+	}, []string{"type"})
+	alertFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Subsystem: "alerts", Name: "failures_total",
+		Help: "Number of operational alerts that could not be posted or were dropped, by type.",
+	}, []string{"type"})
+)
+
+// Alert is an operational event the operators should look at.
+type Alert struct {
+	Type  string
+	Title string
+	// Fields are the details of the alert, shown in order.
+	Fields []AlertField
+	At     time.Time
+}
+
+// AlertField is a detail of an alert, such as the ID of the transfer concerned.
+type AlertField struct {
+	Name  string
+	Value string
+}
+
+// alerter posts the alerts to the Slack incoming webhook of their type, in the
+// background, so that raising one never blocks the request or the storage call it
+// comes from.
+type alerter struct {
+	// webhooks maps an alert type to its incoming webhook; the muted types are absent.
+	webhooks map[string]string
+	client   *http.Client
+	alerts   chan Alert
+}
+
+// newAlerter resolves the webhook of every alert type from cfg.
+//
+// Parameters:
+//   - cfg: The alert settings, with the default webhook and the routes.
+//
+// Returns:
+//   - *alerter: The alerter, nil when no alert type has a webhook.
+func newAlerter(cfg AlertsConfig) *alerter {
+	webhooks := map[string]string{}
+	for _, alertType := range alertTypes {
+		webhook, routed := cfg.Routes[alertType]
+		if !routed {
+			webhook = cfg.SlackWebhookURL
+		}
+		if webhook != "" && webhook != alertRouteOff {
+			webhooks[alertType] = webhook
+		}
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	return &alerter{
+		webhooks: webhooks,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		alerts:   make(chan Alert, alertQueueSize),
+	}
+}
+
+// Raise queues an alert for its webhook, without waiting for it to be posted. It does
+// nothing on a nil alerter or for a muted type, and drops the alert when the queue is
+// full, as while Slack is down.
+func (a *alerter) Raise(alert Alert) {
+	if a == nil {
+		return
+	}
+	if _, routed := a.webhooks[alert.Type]; !routed {
+		return
+	}
+	if alert.At.IsZero() {
+		alert.At = time.Now().UTC()
+	}
+
+	select {
+	case a.alerts <- alert:
+	default:
+		alertFailures.WithLabelValues(alert.Type).Inc()
+		slog.Warn("Alert Queue Full, Alert Not Posted", "type", alert.Type, "title", alert.Title)
+	}
+}
+
+// Run posts the queued alerts until ctx is cancelled; the alerts still queued then are
+// not posted.
+func (a *alerter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alert := <-a.alerts:
+			if err := a.post(ctx, alert); err != nil {
+				alertFailures.WithLabelValues(alert.Type).Inc()
+				slog.Error("Error Posting Alert", "type", alert.Type, "title", alert.Title, "error", err)
+				continue
+			}
+			alertsSent.WithLabelValues(alert.Type).Inc()
+		}
+	}
+}
+
+// slackMessage is the payload of a Slack incoming webhook, with the details of the
+// alert in a colored attachment.
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Fields []slackField `json:"fields"`
+	Footer string       `json:"footer"`
+	TS     int64        `json:"ts"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// post sends an alert to the incoming webhook of its type.
+//
+// Parameters:
+//   - ctx: The context of the request to Slack.
+//   - alert: The alert to post.
+//
+// Returns:
+//   - error: An error if Slack cannot be reached or refuses the message.
+func (a *alerter) post(ctx context.Context, alert Alert) error {
+	attachment := slackAttachment{Color: "danger", Footer: "gobank " + alert.Type, TS: alert.At.Unix()}
+	for _, field := range alert.Fields {
+		attachment.Fields = append(attachment.Fields, slackField{Title: field.Name, Value: field.Value, Short: len(field.Value) <= 40})
+	}
+	body, err := json.Marshal(slackMessage{Text: alert.Title, Attachments: []slackAttachment{attachment}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.webhooks[alert.Type], bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		// Slack Names The Problem In Plain Text, Such As invalid_payload Or channel_not_found
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("slack refused the alert with status %d: %s", resp.StatusCode, bytes.TrimSpace(reason))
+	}
+	return nil
+}
+
+// alertReconciliationMismatch raises a reconciliation_mismatch alert for what a payment
+// provider reported against the ledger.
+//
+// Parameters:
+//   - title: What disagrees, such as "Gateway Settled A Failed Transfer".
+//   - fields: The references to look the mismatch up with, as name and value pairs.
+func (as *APIServer) alertReconciliationMismatch(title string, fields ...string) {
+	alert := Alert{Type: AlertReconciliationMismatch, Title: title}
+	for i := 0; i+1 < len(fields); i += 2 {
+		alert.Fields = append(alert.Fields, AlertField{Name: fields[i], Value: fields[i+1]})
+	}
+	as.alerts.Raise(alert)
+}
+
+// watchCircuitBreaker raises a breaker_open alert whenever the database circuit
+// breaker of store opens, when a ResilientStorage is among its layers.
+func (as *APIServer) watchCircuitBreaker(store Storage) {
+	for layer := store; layer != nil; layer = unwrapOnce(layer) {
+		if resilient, ok := layer.(*ResilientStorage); ok {
+			resilient.breaker.OnOpen(func(failures int, cooldown time.Duration, err error) {
+				as.alerts.Raise(Alert{Type: AlertBreakerOpen, Title: "Database Unreachable, Failing Storage Calls Fast", Fields: []AlertField{
+					{Name: "failures", Value: fmt.Sprint(failures)},
+					{Name: "retry_in", Value: cooldown.String()},
+					{Name: "error", Value: fmt.Sprint(err)},
+				}})
+			})
+			return
+		}
+	}
+}
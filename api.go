@@ -1,130 +1,359 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// * Use The JWT Secret From The Environment Variables
+// * The JWT Settings, Set From The Configuration At Startup
 var (
-	JWTSecret      = os.Getenv("JWT_SECRET")
-	JWTTokenExpire = os.Getenv("JWT_TOKEN_EXPIRE")
+	JWTSecret      string
+	JWTTokenExpire string
 )
 
 // API Server
 type APIServer struct {
-	listenAddr string
-	store      Storage
+	config    *Config
+	store     Storage
+	events    *EventBroker
+	transfers transferQueue
+
+	metrics      *prometheus.Registry
+	dbHealth     *dbHealthMonitor
+	featureFlags *featureFlags
+	maintenance  *maintenanceMode
+	runtimeLevel *runtimeLogLevel
+	// publisher publishes the domain events to the external event bus, nil for none,
+	// opened by Run.
+	publisher EventPublisher
+	// moneyGate refuses the transfers while the server drains, see drain.go.
+	moneyGate *moneyMovementGate
+	// configLoader reloads the configuration on SIGHUP, see reload.go.
+	configLoader configLoader
+	// notifier sends the notifications of the published events, nil without an SMTP server, SMS provider, or push provider.
+	notifier *notifier
+	// rates serves the exchange rates, nil without fx.api_url.
+	rates RateProvider
+	// documents keeps the statement PDFs and the CSV exports, nil without documents.bucket.
+	documents DocumentStore
+	// stripe creates the payment intents of the card top-ups, nil without stripe.secret_key.
+	stripe *StripeClient
+	// alerts posts the operational alerts to Slack, nil without a webhook, see alerts.go.
+	alerts *alerter
+	// staffAuth authenticates the staff logins, nil without auth.staff.provider.
+	staffAuth AuthProvider
+	// kyc checks the identity of the new account holders, nil without kyc.provider.
+	kyc KYCProvider
+	// kycNudge wakes the KYC sync up when a check is started, see runKYCSync.
+	kycNudge chan struct{}
+	// settlement settles the external transfers, nil without settlement.endpoint.
+	settlement *SettlementClient
+	// webhookKeys encrypts the stored webhook secrets, nil without webhooks.encryption_key.
+	webhookKeys *webhookKeyring
+	// scheduler runs the scheduled jobs, see scheduler.go.
+	scheduler *scheduler
+
+	asyncTransferThreshold int64
+	pageLimits             pageLimits
+	startedAt              time.Time
+
+	router       *mux.Router
+	server       *http.Server
+	ready        atomic.Bool
+	shutdownDone chan struct{}
 }
 
 // Create New API Server
-// listenAddr: The Address To Listen On
+// cfg: The Configuration, Listen Address Included
 // store: The Storage Interface
-func NewAPIServer(listenAddr string, store Storage) *APIServer {
-	return &APIServer{
-		listenAddr: listenAddr,
-		store:      store,
+func NewAPIServer(cfg *Config, store Storage) *APIServer {
+	as := &APIServer{
+		config: cfg,
+		store:  store,
+		events: NewEventBroker(),
+
+		dbHealth:     newDBHealthMonitor(store, cfg.Database.HealthInterval, cfg.Database.HealthTimeout),
+		featureFlags: newFeatureFlags(cfg.Features, store),
+		maintenance:  newMaintenanceMode(store),
+		moneyGate:    newMoneyMovementGate(),
+
+		asyncTransferThreshold: cfg.Server.AsyncTransferThreshold,
+		pageLimits:             newPageLimits(cfg.Server),
+		startedAt:              time.Now().UTC(),
+
+		shutdownDone: make(chan struct{}),
+	}
+
+	as.metrics = newMetricsRegistry(store, as.dbHealth)
+
+	// Validate Accepted The Level Already
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Logging.Level))
+	as.runtimeLevel = newRuntimeLogLevel(level, as.auditLogLevelRevert)
+
+	// Queue The Async Transfers In RabbitMQ When Configured, So They Survive Restarts
+	if cfg.RabbitMQ.URL != "" {
+		as.transfers = newRabbitTransferQueue(cfg.RabbitMQ, cfg.Server.TransferWorkers, store.GetTransfer, as.processAsyncTransfer)
+	} else {
+		as.transfers = newTransferWorkerPool(
+			cfg.Server.TransferWorkers,
+			cfg.Server.TransferQueueSize,
+			as.processAsyncTransfer,
+		)
+	}
+
+	// Notify The Account Holders On The Configured Channels
+	senders := map[string]NotificationSender{}
+	if cfg.Notifications.SMTP.Host != "" {
+		senders[notificationChannelEmail] = NewSMTPSender(cfg.Notifications.SMTP)
+	}
+	if cfg.Notifications.SMS.Twilio.AccountSID != "" {
+		senders[notificationChannelSMS] = newSMSSender(cfg.Notifications.SMS)
+	}
+	push, err := newPushSender(cfg.Notifications.Push)
+	if err != nil {
+		fatal("Error Loading The Push Credentials", "error", err)
+	}
+	if push != nil {
+		senders[notificationChannelPush] = push
+	}
+	if len(senders) > 0 {
+		as.notifier = newNotifier(store, senders)
+	}
+
+	// Cache The Exchange Rates Of The Provider
+	if cfg.FX.APIURL != "" {
+		as.rates = newCachedRateProvider(NewFrankfurterProvider(cfg.FX), cfg.FX)
+	}
+
+	// Authenticate The Staff Against The Corporate Directory
+	staffAuth, err := newAuthProvider(cfg.Auth.Staff)
+	if err != nil {
+		fatal("Error Configuring The Staff Auth Provider", "provider", cfg.Auth.Staff.Provider, "error", err)
+	}
+	as.staffAuth = staffAuth
+
+	// Charge The Card Top-Ups Through Stripe
+	if cfg.Stripe.SecretKey != "" {
+		as.stripe = NewStripeClient(cfg.Stripe)
+	}
+
+	// Check The Identity Of The New Account Holders
+	as.kyc = newKYCProvider(cfg.KYC)
+	as.kycNudge = make(chan struct{}, 1)
+
+	// Settle The External Transfers With The Settlement Service
+	if cfg.Settlement.Endpoint != "" {
+		settlement, err := NewSettlementClient(cfg.Settlement)
+		if err != nil {
+			fatal("Error Configuring The Settlement Client", "endpoint", cfg.Settlement.Endpoint, "error", err)
+		}
+		as.settlement = settlement
+	}
+
+	// Keep The Webhook Secrets In The Database, Encrypted
+	webhookKeys, err := newWebhookKeyring(cfg.Webhooks.EncryptionKey)
+	if err != nil {
+		fatal("Error Configuring The Webhook Secrets", "error", err)
+	}
+	as.webhookKeys = webhookKeys
+
+	// Alert The Operators On Slack When The Database Breaker Opens Or The Ledger Disagrees
+	if as.alerts = newAlerter(cfg.Alerts); as.alerts != nil {
+		as.watchCircuitBreaker(store)
 	}
-}
 
-// handleAccount handles HTTP requests for account-related operations.
-// It delegates the request to the appropriate handler based on the HTTP method:
-// - GET: Calls handleGetAccounts to retrieve account information.
-// - POST: Calls handleCreateAccount to create a new account.
-// - DELETE: Calls handleDeleteAccount to delete an existing account.
-// If the HTTP method is not supported, it returns an error indicating that the method is not allowed.
-func (as *APIServer) handleAccount(w http.ResponseWriter, r *http.Request) error {
-	// Check The Method and Call The Right Handler
-	switch r.Method {
-	case "GET":
-		return as.handleGetAccounts(w, r)
-	case "POST":
-		return as.handleCreateAccount(w, r)
-	case "DELETE":
-		return as.handleDeleteAccount(w, r)
-	default:
-		return fmt.Errorf("method is not allowed: %s", r.Method)
+	// Hand Out The Generated Documents From The Object Storage
+	if cfg.Documents.Bucket != "" {
+		documents, err := NewS3DocumentStore(cfg.Documents)
+		if err != nil {
+			fatal("Error Configuring The Document Storage", "error", err)
+		}
+		as.documents = documents
 	}
+
+	// Run The Interest Accrual, Statements, Dormancy Check, And Archival On Their Schedules
+	as.scheduler = newScheduler(cfg.Jobs, store, as.scheduledJobs())
+
+	return as
 }
 
-// handleGetAccounts handles the HTTP request to retrieve all accounts.
-// It fetches all accounts from the store and writes them as a JSON response.
-// If an error occurs while fetching the accounts, it returns the error.
+// defaultAccountCurrency is the currency of the accounts opened without one, unless
+// accounts.default_currency says otherwise.
+const defaultAccountCurrency = "USD"
+
+// createAccountAttempts is how many account numbers are drawn before creating an account fails.
+const createAccountAttempts = 5
+
+// createAccount stores a new account together with its account.created event and, with
+// a KYC provider, its pending KYC check, which the KYC sync then submits.
 //
 // Parameters:
-//   - w: The HTTP response writer to send the response.
-//   - r: The HTTP request received from the client.
+//   - r: *http.Request whose context bounds the database work.
+//   - acc: The account to store; its ID, timestamps, and version are filled in.
 //
 // Returns:
-//   - error: An error if there is an issue retrieving the accounts or writing the response.
-func (as *APIServer) handleGetAccounts(w http.ResponseWriter, r *http.Request) error {
-	// Check The Method
-	if r.Method != http.MethodGet {
-		return fmt.Errorf("method not allowed: %s", r.Method)
+//   - error: ErrAccountNumberTaken if the number of the account is taken, another error if it cannot be stored.
+func (as *APIServer) createAccount(r *http.Request, acc *Account) error {
+	if as.kyc != nil {
+		acc.KYCStatus = KYCStatusPending
 	}
-	// Get All Accounts From The Store
-	accs, err := as.store.GetAccounts()
 
+	// Store The Account, Its account.created Event, And Its KYC Check Together
+	err := as.store.WithTx(r.Context(), func(tx Storage) error {
+		if err := tx.CreateAccount(r.Context(), acc); err != nil {
+			return err
+		}
+		if as.kyc != nil {
+			if err := tx.CreateKYCCheck(r.Context(), &KYCCheck{AccountID: acc.ID, Provider: as.kyc.Name(), Status: KYCStatusPending}); err != nil {
+				return err
+			}
+		}
+
+		event, err := newOutboxEvent(EventAccountCreated, acc.ID, acc)
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(r.Context(), []*OutboxEvent{event})
+	})
 	if err != nil {
 		return err
 	}
 
-	return WriteJSON(w, http.StatusOK, accs)
+	slog.DebugContext(r.Context(), "Account Created", "account_number", acc.Number)
+
+	if as.kyc != nil {
+		as.nudgeKYCSync()
+	}
+	return nil
 }
 
 // handleCreateAccount handles the creation of a new account.
 // It decodes the request body into a CreateAccountRequest, creates a new account,
 // stores it in the database, and writes the created account as a JSON response.
+// A request with the external ID of an account created for the same holder and currency
+// is answered with that account and 200 OK instead, so a client retrying a create never
+// opens a second account for its customer.
 //
 // Parameters:
 //   - w: http.ResponseWriter to write the response.
 //   - r: *http.Request containing the request data.
 //
 // Returns:
-//   - error: An error if the request body cannot be decoded, the account cannot be created,
+//   - error: An error if the request body cannot be decoded or is invalid, the account cannot be created,
 //     or the account cannot be stored in the database.
 func (as *APIServer) handleCreateAccount(w http.ResponseWriter, r *http.Request) error {
 	// Decode The Request Body To CreateAccountRequest
 	accReq := new(CreateAccountRequest)
 
-	if err := json.NewDecoder(r.Body).Decode(accReq); err != nil {
+	if err := bindJSON(w, r, accReq); err != nil {
 		return err
 	}
-	defer r.Body.Close()
-
-	// Create The Account
-	acc := NewAccount(accReq.FirstName, accReq.LastName)
 
-	// Create The JWT Token
-	token, err := createJWT(acc)
+	// Create The Account, In The Default Currency Unless Another Is Asked For
+	currency := accReq.Currency
+	if currency == "" {
+		currency = as.config.Accounts.DefaultCurrency
+	}
+	acc := NewAccount(accReq.FirstName, accReq.LastName, currency)
+	acc.ExternalID = accReq.ExternalID
+	if err := acc.SetPassword(accReq.Password); err != nil {
+		return err
+	}
 
+	// Answer A Repeated Create With The Account It Opened
+	existing, err := as.accountByExternalID(r, acc)
 	if err != nil {
 		return err
 	}
+	if existing != nil {
+		return WriteResponse(w, r, http.StatusOK, newAccountResource(r, existing))
+	}
+
+	// Draw Another Number While The Drawn One Is Taken
+	for attempt := 1; ; attempt++ {
+		err := as.createAccount(r, acc)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, ErrExternalIDTaken) {
+			// A Concurrent Create With The Same External ID Won
+			existing, lookupErr := as.accountByExternalID(r, acc)
+			if lookupErr != nil {
+				return lookupErr
+			}
+			if existing == nil {
+				return externalIDTakenError()
+			}
+			return WriteResponse(w, r, http.StatusOK, newAccountResource(r, existing))
+		}
+		if !errors.Is(err, ErrAccountNumberTaken) {
+			return err
+		}
+		if attempt == createAccountAttempts {
+			return NewTypedError(http.StatusConflict, "account_number_taken", "no free account number was found, retry later")
+		}
+		acc.Number = NewAccount(acc.FirstName, acc.LastName, acc.Currency).Number
+	}
 
-	// !TESTING
-	// !DELETE THIS IN PRODUCTION
-	fmt.Printf("JWT Token: %s\nUser : %d", token, acc.Number)
+	return WriteResponse(w, r, http.StatusCreated, newAccountResource(r, acc))
+}
 
-	// Store The Account in The Database
-	if err := as.store.CreateAccount(acc); err != nil {
-		return err
+// accountByExternalID finds the account an earlier create opened under the external ID
+// of acc.
+//
+// Parameters:
+//   - r: *http.Request whose context bounds the database work.
+//   - acc: The account to create.
+//
+// Returns:
+//   - *Account: The account, nil if acc has no external ID or no active account has it.
+//   - error: A 409 TypedError if the account is that of another holder or in another
+//     currency, the error of the store, otherwise nil.
+func (as *APIServer) accountByExternalID(r *http.Request, acc *Account) (*Account, error) {
+	if acc.ExternalID == "" {
+		return nil, nil
+	}
+
+	existing, err := as.store.GetAccountByExternalID(r.Context(), acc.ExternalID)
+	if errors.Is(err, ErrAccountNotFound) {
+		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	if existing.FirstName != acc.FirstName || existing.LastName != acc.LastName || existing.Currency != acc.Currency {
+		return nil, externalIDTakenError()
+	}
+	return existing, nil
+}
 
-	return WriteJSON(w, http.StatusCreated, acc)
+// externalIDTakenError is returned for a create whose external ID belongs to an account
+// that is not the one asked for, such as that of another holder or a deleted one.
+func externalIDTakenError() *TypedError {
+	return NewTypedError(http.StatusConflict, "external_id_taken", "external_id belongs to another account")
 }
 
 // handleGetAccountById handles the HTTP request to retrieve an account by its ID.
 // It extracts the account ID from the URL, fetches the account details from the store,
 // and writes the account information as a JSON response.
+// Conditional requests with If-None-Match or If-Modified-Since are answered with
+// 304 Not Modified while the account is unchanged.
 //
 // Parameters:
 //   - w: http.ResponseWriter to write the response.
@@ -137,97 +366,368 @@ func (as *APIServer) handleGetAccountById(w http.ResponseWriter, r *http.Request
 	id := getId(w, r)
 
 	// Get The Account By ID
-	acc, err := as.store.GetAccountById(id)
+	acc, err := as.store.GetAccountById(r.Context(), id)
 
 	if err != nil {
 		return err
 	}
 
-	return WriteJSON(w, http.StatusOK, acc)
+	if checkNotModified(w, r, accountETag(acc), acc.UpdatedAt) {
+		return nil
+	}
+
+	return WriteResponse(w, r, http.StatusOK, newAccountResource(r, acc))
 }
 
-// handleDeleteAccount handles the HTTP request for deleting an account.
-// It extracts the account ID from the URL, deletes the account from the store,
-// and writes a JSON response indicating the deleted account ID.
+// handleGetTransactions handles the HTTP request to retrieve the transaction history of an account.
+// It extracts the account ID from the URL, fetches the ledger entries from the store,
+// and writes them as a JSON response, newest first. The entries can be narrowed with the
+// query parameters described at parseTransactionFilter. Requests preferring text/csv
+// are served by handleExportTransactionsCSV.
 //
 // Parameters:
-//   - w: http.ResponseWriter to write the HTTP response.
-//   - r: *http.Request containing the HTTP request details.
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the request details.
 //
 // Returns:
-//   - error: An error if the account deletion fails, otherwise nil.
-func (as *APIServer) handleDeleteAccount(w http.ResponseWriter, r *http.Request) error {
+//   - error: An error if the transactions cannot be retrieved, otherwise nil.
+func (as *APIServer) handleGetTransactions(w http.ResponseWriter, r *http.Request) error {
+	// Spreadsheet Clients Ask For CSV
+	if prefersCSV(r) {
+		return as.handleExportTransactionsCSV(w, r)
+	}
+
+	filter, err := parseTransactionFilter(r)
+	if err != nil {
+		return err
+	}
+
 	// Get The ID From The URL
 	id := getId(w, r)
 
-	// Delete The Account By ID from The Store
-	if err := as.store.DeleteAccount(id); err != nil {
+	// Get The Transactions Of The Account
+	txns, err := as.store.GetTransactions(r.Context(), id, filter)
+
+	if err != nil {
 		return err
 	}
 
-	return WriteJSON(w, http.StatusOK, map[string]int{
-		"deleted": id,
-	})
+	return WriteResponse(w, r, http.StatusOK, newTransactionResources(r, txns))
 }
 
-// handleTransfer handles the transfer request by decoding the JSON payload
-// from the request body into a TransferRequest struct. It returns an error
-// if the decoding fails. The function writes the transfer request back as a
-// JSON response with an HTTP status of 200 OK.
+// handleDeleteAccount handles the HTTP request for deleting an account.
+// It extracts the account ID from the URL, deletes the account from the store along
+// with its account.closed event, and writes a JSON response indicating the deleted account ID.
 //
 // Parameters:
-// - w: http.ResponseWriter to write the response.
-// - r: *http.Request containing the transfer request.
+//   - w: http.ResponseWriter to write the HTTP response.
+//   - r: *http.Request containing the HTTP request details.
 //
 // Returns:
-// - error: An error if the JSON decoding fails.
-func (as *APIServer) handleTransfer(w http.ResponseWriter, r *http.Request) error {
-	transferReq := TransferRequest{}
+//   - error: An error if the account deletion fails, otherwise nil.
+func (as *APIServer) handleDeleteAccount(w http.ResponseWriter, r *http.Request) error {
+	// Get The ID From The URL
+	id := getId(w, r)
+
+	// Delete The Account By ID And Store Its account.closed Event Together
+	err := as.store.WithTx(r.Context(), func(tx Storage) error {
+		if err := tx.DeleteAccount(r.Context(), id); err != nil {
+			return err
+		}
 
-	if err := json.NewDecoder(r.Body).Decode(&transferReq); err != nil {
+		event, err := newOutboxEvent(EventAccountClosed, id, AccountClosedEvent{AccountID: id, ClosedAt: time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(r.Context(), []*OutboxEvent{event})
+	})
+	if err != nil {
 		return err
 	}
-	defer r.Body.Close()
-
-	// TODO: Implement the transfer logic here
-	// For now, just return the transfer request as a response
 
-	return WriteJSON(w, http.StatusOK, transferReq)
+	return WriteResponse(w, r, http.StatusOK, map[string]int{
+		"deleted": id,
+	})
 }
 
-// Run initializes the API server, sets up the router and sub-router with the appropriate
-// routes for handling account and transfer operations, and starts the HTTP server.
+// routes sets up the router and sub-routers with the appropriate routes for
+// handling account and transfer operations.
 //
 // Routes:
+// - POST /api/v1/login: Returns the token of an account for its number and password.
 // - POST /api/v1/account: Handles account creation.
 // - GET /api/v1/account/{id:[0-9]+}: Retrieves account details by ID.
+// - PUT/PATCH /api/v1/account/{id:[0-9]+}: Updates an account by ID, requires If-Match.
 // - DELETE /api/v1/account/{id:[0-9]+}: Deletes an account by ID.
+// - GET /api/v1/account/{id:[0-9]+}/transactions: Retrieves the transaction history of an account.
+// - GET /api/v1/account/{id:[0-9]+}/transactions.csv: Exports the transaction history as CSV.
+// - GET /api/v1/account/{id:[0-9]+}/statements/{period}: Retrieves the monthly statement of an account.
+// - GET /api/v1/account/{id:[0-9]+}/statements/{period}.pdf: Downloads the monthly statement as PDF.
+// - GET /api/v1/account/{id:[0-9]+}/statements/{period}.xml: The monthly statement as ISO 20022 camt.053, see registerISO20022Routes.
+// - GET /api/v1/account/{id:[0-9]+}/events: Streams account activity as Server-Sent Events.
+// - /api/v1/account/{id:[0-9]+}/notifications...: Notification preferences and phone verification, see registerNotificationRoutes.
+// - /api/v1/account/{id:[0-9]+}/devices...: Push notification devices, see registerPushRoutes.
+// - /api/v1/account/{id:[0-9]+}/top-ups...: Card top-ups, see registerCardTopUpRoutes.
+// - /api/v1/account/{id:[0-9]+}/consents...: Open banking consents, see registerOpenBankingRoutes.
+// - /api/v1/account/{id:[0-9]+}/kyc: The KYC check of an account, see registerKYCRoutes.
 // - POST /api/v1/transfer: Handles money transfers between accounts.
+// - GET /api/v1/transfer/{transfer:[0-9]+}: Retrieves the state of a transfer, with long-poll support.
+// - POST /api/v1/batch: Executes several sub-requests in one round trip.
+// - /api/v1/fx/...: Exchange rates and conversions, see registerFXRoutes.
+// - GET /api/v1/status: Reports the service version, build commit, uptime, and features.
+// - /admin/...: Operator endpoints and the staff login, registered by registerAdminRoutes.
+// - /open-banking/v1/...: Read-only account data for the aggregators, see registerOpenBankingRoutes.
+// - POST /webhooks/gateway: Payment gateway callbacks, see registerGatewayRoutes.
+// - POST /webhooks/stripe: Stripe events crediting the card top-ups, see registerCardTopUpRoutes.
+// - POST /webhooks/kyc: KYC provider events carrying the check results, see registerKYCRoutes.
+// - GET /ws: WebSocket channel with live balance and transfer status updates.
+// - GET /version: Reports the version, commit, and build date of the binary.
+// - GET /healthz: Reports that the process is up.
+// - GET /readyz: Reports whether the server can serve traffic.
+// - GET /metrics: Exposes the server metrics to Prometheus.
 //
-// The server listens on the address specified in the APIServer's listenAddr field.
-func (as *APIServer) Run() {
+// All /api/v1 responses carry Deprecation and Sunset headers; the /api/v2 routes
+// are registered by registerV2Routes. Requests with an unsupported method get a 405
+// with an Allow header, and OPTIONS requests are answered with the supported methods.
+// Account creation and transfers honor the Idempotency-Key header, and the transfers
+// are refused with a 503 while the server shuts down, see withMoneyMovement. Every
+// POST, PUT, PATCH, and DELETE request is recorded in the audit log, see withRequestAudit,
+// and refused with a 503 while the API is under maintenance, see withMaintenance.
+func (as *APIServer) routes() *mux.Router {
 	// Create The Router and SubRouter
 	router := mux.NewRouter()
+	router.MethodNotAllowedHandler = methodNotAllowedHandler(router)
+	router.Use(withRouteSpan, withRequestLogging, withRequestActor, as.withRequestAudit, as.withMaintenance, withPanicReporting, withSlowRequestLogging(as.config.Server.SlowRequestThreshold))
 	subRouter := router.PathPrefix("/api/v1").Subrouter()
 
+	// Mark v1 As Deprecated In Favour Of v2
+	subRouter.Use(withDeprecation("/api/v2", as.config.Server.V1Sunset))
+
+	// Handle The Login Route
+	subRouter.HandleFunc("/login", makeHTTPHandlerFunc(handleLogin(as.store))).Methods(http.MethodPost)
+
 	// Handle The Accounts Routes
-	subRouter.HandleFunc("/account", makeHTTPHandlerFunc(as.handleAccount))
+	subRouter.HandleFunc("/account", as.withIdempotency(makeHTTPHandlerFunc(as.handleCreateAccount))).Methods(http.MethodPost)
 	subRouter.HandleFunc("/account/{id:[0-9]+}", withJWTAuth(makeHTTPHandlerFunc(as.handleGetAccountById), as.store)).Methods(http.MethodGet)
-	subRouter.HandleFunc("/account/{id:[0-9]+}", makeHTTPHandlerFunc(as.handleDeleteAccount)).Methods(http.MethodDelete)
+	subRouter.HandleFunc("/account/{id:[0-9]+}", withJWTAuth(makeHTTPHandlerFunc(as.handleUpdateAccount), as.store)).Methods(http.MethodPut, http.MethodPatch)
+	subRouter.HandleFunc("/account/{id:[0-9]+}", withJWTAuth(makeHTTPHandlerFunc(as.handleDeleteAccount), as.store)).Methods(http.MethodDelete)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/transactions", withJWTAuth(makeHTTPHandlerFunc(as.handleGetTransactions), as.store)).Methods(http.MethodGet)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/transactions.csv", withJWTAuth(makeHTTPHandlerFunc(as.handleExportTransactionsCSV), as.store)).Methods(http.MethodGet)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/statements/{period:[0-9]{4}-[0-9]{2}}", withJWTAuth(makeHTTPHandlerFunc(as.handleGetStatement), as.store)).Methods(http.MethodGet)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/statements/{period:[0-9]{4}-[0-9]{2}}.pdf", withJWTAuth(makeHTTPHandlerFunc(as.handleGetStatementPDF), as.store)).Methods(http.MethodGet)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/events", withJWTAuth(makeHTTPHandlerFunc(as.handleAccountEvents), as.store)).Methods(http.MethodGet)
+	as.registerISO20022Routes(subRouter)
+	as.registerNotificationRoutes(subRouter)
+	as.registerPushRoutes(subRouter)
+	as.registerCardTopUpRoutes(router, subRouter)
+	as.registerOpenBankingRoutes(router, subRouter)
+	as.registerKYCRoutes(router, subRouter)
 
 	// Handle The Transfer Route
-	subRouter.HandleFunc("/transfer", makeHTTPHandlerFunc(as.handleTransfer)).Methods(http.MethodPost)
+	subRouter.HandleFunc("/transfer", withJWTAuth(as.withIdempotency(makeHTTPHandlerFunc(as.withMoneyMovement(as.handleTransfer))), as.store)).Methods(http.MethodPost)
+	subRouter.HandleFunc("/transfer/{transfer:[0-9]+}", withJWTAuth(makeHTTPHandlerFunc(as.handleGetTransfer), as.store)).Methods(http.MethodGet)
+
+	// Handle The Batch Route
+	subRouter.HandleFunc("/batch", makeHTTPHandlerFunc(as.handleBatch)).Methods(http.MethodPost)
+
+	// Handle The Exchange Rate Routes
+	as.registerFXRoutes(subRouter)
+
+	// Handle The Status Route
+	subRouter.HandleFunc("/status", makeHTTPHandlerFunc(as.handleStatus)).Methods(http.MethodGet)
+
+	// Handle The v2 Routes
+	as.registerV2Routes(router)
+
+	// Handle The Admin Routes
+	as.registerAdminRoutes(router)
+
+	// Handle The Payment Gateway Callbacks
+	as.registerGatewayRoutes(router)
+
+	// Handle The WebSocket Route
+	router.HandleFunc("/ws", withWebSocketToken(withJWTAuth(as.handleWebSocket, as.store))).Methods(http.MethodGet)
+
+	// Handle The Version Route
+	router.HandleFunc("/version", makeHTTPHandlerFunc(as.handleVersion)).Methods(http.MethodGet)
+
+	// Handle The Health Routes
+	router.HandleFunc("/healthz", as.handleHealthz).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", as.handleReadyz).Methods(http.MethodGet)
+	router.Handle("/metrics", as.handleMetrics()).Methods(http.MethodGet)
+
+	return router
+}
+
+// Default HTTP Server Limits
+const (
+	defaultHTTPReadHeaderTimeout = 5 * time.Second
+	defaultHTTPReadTimeout       = 30 * time.Second
+	defaultHTTPWriteTimeout      = 90 * time.Second
+	defaultHTTPIdleTimeout       = 120 * time.Second
+	defaultHTTPMaxHeaderBytes    = 64 << 10
+)
+
+// newHTTPServer creates the HTTP server of the API. Slow clients cannot hold on to
+// connections: the request headers must arrive within the read header timeout and the
+// whole request within the read timeout, the response must be written within the
+// write timeout, idle keep-alive connections are closed after the idle timeout, and
+// the request headers may not exceed the max header bytes. The long polls and the
+// event streams set their own write deadlines.
+//
+// Parameters:
+//   - cfg: The server settings, the listen address included.
+//   - handler: The handler serving the requests.
+//
+// Returns:
+//   - *http.Server: The configured server.
+func newHTTPServer(cfg ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// Run initializes the API server, sets up its routes, and starts the HTTP server.
+// The server listens on the address of the server configuration
+// and shuts down gracefully on SIGINT or SIGTERM, while SIGHUP reloads the log level
+// and the feature flags, see reloadConfig. Every request is written to the
+// access log and traced under its request ID, see withAccessLog, withTracing, and
+// withRequestID.
+func (as *APIServer) Run() {
+	as.router = as.routes()
 
 	// Run The HTTPServer
+	as.server = newHTTPServer(as.config.Server, withTracing(withRequestID(withAccessLog(as.router, as.config.Logging.AccessLog))))
+
+	// Shutdown On SIGINT / SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		as.GracefulShutdown()
+	}()
+
+	// Start The Async Transfer Workers
+	as.transfers.Start()
+	as.resumePendingTransfers(ctx)
+
+	// Publish The Committed Domain Events, To The Configured Event Bus Too
+	publisher, err := newEventPublisher(as.config.Events)
+	if err != nil {
+		fatal("Error Connecting To The Event Bus", "transport", as.config.Events.Transport, "error", err)
+	}
+	as.publisher = publisher
+	go as.runOutboxRelay(ctx)
+
+	// Notify The Account Holders Of The Published Events
+	if as.notifier != nil {
+		go as.notifier.Run(ctx)
+	}
+
+	// Post The Operational Alerts
+	if as.alerts != nil {
+		go as.alerts.Run(ctx)
+	}
+
+	// Submit The KYC Checks And Poll Their Results
+	if as.kyc != nil {
+		go as.runKYCSync(ctx)
+	}
+
+	// Keep The Upcoming Transaction Partitions Created
+	go as.runPartitionMaintenance(ctx)
+
+	// Delete The Expired Idempotency Keys
+	go as.runIdempotencySweep(ctx)
+
+	// Run The Scheduled Jobs When Due
+	go as.scheduler.Run(ctx)
+
+	// Load The Feature Flag Overrides, Then Keep Them Fresh
+	if err := as.featureFlags.Refresh(ctx); err != nil {
+		slog.Error("Error Loading Feature Flags", "error", err)
+	}
+	go as.featureFlags.Run(ctx, as.config.Jobs.FeatureRefreshInterval)
+
+	// Load The Maintenance Mode, Then Keep It Fresh
+	if err := as.maintenance.Refresh(ctx); err != nil {
+		slog.Error("Error Loading The Maintenance Mode", "error", err)
+	}
+	go as.maintenance.Run(ctx, as.config.Jobs.FeatureRefreshInterval)
+
+	// Apply The Reloadable Settings On SIGHUP
+	go as.runConfigReload(ctx)
+
+	// Watch The Database, Starting With A Check Before Becoming Ready
+	as.dbHealth.check(ctx)
+	go as.dbHealth.Run(ctx)
 
-	log.Println("API Server is Runing On Port: ", as.listenAddr)
+	slog.Info("API Server Is Running", "addr", as.config.Server.Addr)
+
+	as.ready.Store(true)
+
+	if err := as.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fatal("API Server Failed", "error", err)
+	}
 
-	http.ListenAndServe(as.listenAddr, router)
+	// Wait For The In-Flight Requests To Finish
+	<-as.shutdownDone
 }
 
 // GracefulShutdown performs a graceful shutdown of the API server.
+// It first flips readiness to failing and refuses new transfers with a 503, while the
+// reads are still served, then waits for shutdownReadinessDelay so load balancers stop
+// routing new traffic, and for the transfers in progress to finish. It then stops
+// accepting connections and waits for in-flight requests to finish, all within
+// shutdownTimeout. Queued async transfers are processed, unless a durable queue keeps
+// them for the next start, and the settlement client and the event publisher are
+// closed before it returns.
 func (as *APIServer) GracefulShutdown() {
-	// TODO: Implement graceful shutdown
+	defer close(as.shutdownDone)
+
+	slog.Info("Shutting Down API Server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Refuse New Transfers At Once, The Drain Window Lasts At Least The Readiness Delay
+	as.ready.Store(false)
+	drained := make(chan int, 1)
+	go func() {
+		drained <- as.moneyGate.Drain(ctx)
+	}()
+	time.Sleep(shutdownReadinessDelay)
+
+	if active := <-drained; active > 0 {
+		slog.Error("Transfers Still In Progress At The Shutdown Timeout", "transfers", active)
+	}
+
+	if err := as.server.Shutdown(ctx); err != nil {
+		slog.Error("Error Shutting Down API Server", "error", err)
+	}
+
+	// Let The Workers Finish The Accepted Transfers
+	as.transfers.Stop()
+
+	if as.settlement != nil {
+		if err := as.settlement.Close(); err != nil {
+			slog.Error("Error Closing The Settlement Client", "error", err)
+		}
+	}
+
+	// The Events Still In The Outbox Are Published On The Next Start
+	if as.publisher != nil {
+		if err := as.publisher.Close(); err != nil {
+			slog.Error("Error Closing The Event Publisher", "error", err)
+		}
+	}
 }
 
 // apiFunc is a type definition for a function that takes an http.ResponseWriter
@@ -237,15 +737,19 @@ type apiFunc func(http.ResponseWriter, *http.Request) error
 
 // APIError is a struct that represents an error response in the API.
 // It contains an "error" field that holds the error message to be returned
-// to the client in the response body.
+// to the client in the response body, and the per-field details of validation errors.
 type APIError struct {
-	Error string `json:"error"`
+	Error     string       `json:"error"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
 }
 
 // makeHTTPHandlerFunc wraps an apiFunc with an http.HandlerFunc.
 // It executes the provided apiFunc and handles any errors by writing
-// a JSON response with a status code of http.StatusBadRequest and an
-// APIError containing the error message.
+// a JSON response with a status code of http.StatusBadRequest (or the
+// status of a TypedError, or of a storage error translated by storageTypedError)
+// and an APIError containing the error message.
+// TypedError messages are localized according to the Accept-Language header.
 //
 // Parameters:
 //   - f: The apiFunc to be wrapped.
@@ -255,7 +759,28 @@ type APIError struct {
 func makeHTTPHandlerFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
-			WriteError(w, http.StatusBadRequest, err.Error())
+			status := http.StatusBadRequest
+
+			body := APIError{Error: err.Error(), RequestID: requestIDFromContext(r.Context())}
+
+			// Honor The Status Of Typed Errors And Storage Errors
+			var typedErr *TypedError
+			if !errors.As(err, &typedErr) {
+				typedErr = storageTypedError(err)
+			}
+			if typedErr != nil {
+				localizeTypedError(r, typedErr)
+				status = typedErr.Status
+				body.Error = typedErr.Message
+				body.Fields = typedErr.Fields
+			}
+
+			if status >= http.StatusInternalServerError {
+				reportError(r, err)
+			} else {
+				slog.DebugContext(r.Context(), "Request Failed", "status", status, "error", err)
+			}
+			writeErrorResponse(w, r, status, body)
 		}
 	}
 }
@@ -307,18 +832,34 @@ func WriteError(w http.ResponseWriter, status int, message string) {
 	WriteJSON(w, status, APIError{Error: message})
 }
 
-func withJWTAuth(handler http.HandlerFunc, store Storage) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Load The Environment Variables
-		if err := godotenv.Load(); err != nil {
-			log.Fatalf("error loading environment variables: %s", err)
-		}
+// authAccountKey is the context key of the account authenticated by withJWTAuth.
+type authAccountKey struct{}
+
+// authAccountFromContext returns the account authenticated by withJWTAuth, nil outside it.
+func authAccountFromContext(ctx context.Context) *Account {
+	account, _ := ctx.Value(authAccountKey{}).(*Account)
+	return account
+}
 
+// withJWTAuth lets a request through only with the JWT token of an account. On the routes
+// of an account, with an {id} in the path, the token must be that of the account; on the
+// others, such as the transfer routes, the token names the account, and the handler
+// checks what it may do. Either way the account is in the context of the request, see
+// authAccountFromContext.
+//
+// Parameters:
+//   - handler: The endpoint to protect.
+//   - users: The store the accounts of the tokens are read from.
+//
+// Returns:
+//   - http.HandlerFunc: The endpoint, answering with a 401 for a missing or invalid token.
+func withJWTAuth(handler http.HandlerFunc, users UserRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		// Get The Token From The Authorization Header
 		tokenString := r.Header.Get("Authorization")
 
 		if tokenString == "" {
-			WriteError(w, http.StatusUnauthorized, "missing authorization header")
+			WriteError(w, http.StatusUnauthorized, localize(r, "auth.missing_header", "missing authorization header"))
 			return
 		}
 
@@ -330,34 +871,82 @@ func withJWTAuth(handler http.HandlerFunc, store Storage) http.HandlerFunc {
 		token, err := validateJWTToken(tokenString)
 
 		if err != nil {
-			WriteError(w, http.StatusUnauthorized, "invalid token")
+			WriteError(w, http.StatusUnauthorized, localize(r, "auth.invalid_token", "invalid token"))
 			return
 		}
 		if !token.Valid {
-			WriteError(w, http.StatusUnauthorized, "invalid token")
+			WriteError(w, http.StatusUnauthorized, localize(r, "auth.invalid_token", "invalid token"))
 			return
 		}
 
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok || claims["account_number"] == nil {
-			WriteError(w, http.StatusUnauthorized, "invalid token claims")
+			WriteError(w, http.StatusUnauthorized, localize(r, "auth.invalid_claims", "invalid token claims"))
+			return
+		}
+
+		number, ok := claims["account_number"].(float64)
+		if !ok {
+			WriteError(w, http.StatusUnauthorized, localize(r, "auth.invalid_claims", "invalid token claims"))
 			return
 		}
 
-		usrId := getId(w, r)
-		account, err := store.GetAccountById(usrId)
+		// The Routes Of An Account Take The Token Of That Account Only
+		var account *Account
+		if _, ok := mux.Vars(r)["id"]; ok {
+			account, err = users.GetAccountById(r.Context(), getId(w, r))
+		} else {
+			account, err = users.GetAccountByNumber(r.Context(), int64(number))
+		}
 
 		if err != nil {
-			WriteError(w, http.StatusUnauthorized, "account not found")
+			WriteError(w, http.StatusUnauthorized, localize(r, "auth.account_not_found", "account not found"))
 			return
 		}
 
-		if account.Number != int64(claims["account_number"].(float64)) {
-			WriteError(w, http.StatusUnauthorized, "permission denied")
+		if account.Number != int64(number) {
+			WriteError(w, http.StatusUnauthorized, localize(r, "auth.permission_denied", "permission denied"))
 			return
 		}
 
-		handler(w, r)
+		// Log The Authenticated Account With Everything The Request Logs
+		recordRequestAccount(r.Context(), account.ID)
+		ctx := context.WithValue(withLogAttrs(r.Context(), slog.Int("account_id", account.ID)), authAccountKey{}, account)
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// handleLogin authenticates the holder of an account with its number and password and
+// returns the token of the account, which the routes behind withJWTAuth require.
+//
+// Parameters:
+//   - users: The repository the account of the number is read from.
+//
+// Returns:
+//   - apiFunc: The handler, answering with a 401 for an unknown number or a wrong
+//     password alike, or with the error of the repository.
+func handleLogin(users UserRepository) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		req := new(LoginRequest)
+		if err := bindJSON(w, r, req); err != nil {
+			return err
+		}
+
+		acc, err := users.GetAccountByNumber(r.Context(), req.Number)
+		if err != nil && !errors.Is(err, ErrAccountNotFound) {
+			return err
+		}
+		if err != nil || !acc.ValidPassword(req.Password) {
+			slog.WarnContext(r.Context(), "Rejected Account Login", "account_number", req.Number)
+			return NewTypedError(http.StatusUnauthorized, "invalid_account_credentials", "invalid account number or password")
+		}
+
+		token, err := createJWT(acc)
+		if err != nil {
+			return err
+		}
+
+		return WriteResponse(w, r, http.StatusOK, LoginResponse{Number: acc.Number, Token: token})
 	}
 }
 
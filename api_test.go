@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/moabdelazem/gobank/money"
+)
+
+// testPassword is the password of the accounts opened by the handler tests.
+const testPassword = "correct horse"
+
+// newTestAPIServer returns the router of an API server on top of an in-memory store,
+// together with the store.
+func newTestAPIServer(t *testing.T) (http.Handler, *MemoryStorage) {
+	t.Helper()
+
+	JWTSecret = "test-secret"
+	store := NewMemoryStorage()
+	as := NewAPIServer(defaultConfig(), store)
+	return as.routes(), store
+}
+
+// serveJSON sends a request with body encoded as JSON, and a bearer token when not
+// empty, and decodes the response into out when not nil.
+func serveJSON(t *testing.T, router http.Handler, method, path, token string, body, out any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encoding the request: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if out != nil && rec.Code < http.StatusBadRequest {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("decoding the response of %s %s: %v", method, path, err)
+		}
+	}
+	return rec
+}
+
+// openTestAccount opens an account through the API, funds it with balance minor units,
+// and logs in to it.
+func openTestAccount(t *testing.T, router http.Handler, store *MemoryStorage, balance int64) (*Account, string) {
+	t.Helper()
+
+	var acc Account
+	req := CreateAccountRequest{FirstName: "Test", LastName: "Holder", Currency: "USD", Password: testPassword}
+	if rec := serveJSON(t, router, http.MethodPost, "/api/v1/account", "", req, &acc); rec.Code != http.StatusCreated {
+		t.Fatalf("POST /api/v1/account = %d %s, want %d", rec.Code, rec.Body, http.StatusCreated)
+	}
+
+	if balance > 0 {
+		deposit := &Transaction{AccountID: acc.ID, Type: TransactionTypeDeposit, Amount: money.New(balance, acc.Currency)}
+		if err := store.DepositFunds(context.Background(), deposit); err != nil {
+			t.Fatalf("depositing on account %d: %v", acc.ID, err)
+		}
+	}
+
+	var login LoginResponse
+	if rec := serveJSON(t, router, http.MethodPost, "/api/v1/login", "", LoginRequest{Number: acc.Number, Password: testPassword}, &login); rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/v1/login = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	return &acc, login.Token
+}
+
+func TestHandleCreateAccount(t *testing.T) {
+	router, store := newTestAPIServer(t)
+
+	acc, _ := openTestAccount(t, router, store, 0)
+	if acc.ID == 0 || acc.Number == 0 {
+		t.Fatalf("created account = %+v, want an ID and a number", acc)
+	}
+	if acc.FirstName != "Test" || acc.LastName != "Holder" || acc.Currency != "USD" || acc.Balance.Amount != 0 {
+		t.Errorf("created account = %+v, want an empty USD account of Test Holder", acc)
+	}
+
+	stored, err := store.GetAccountById(context.Background(), acc.ID)
+	if err != nil {
+		t.Fatalf("reading the created account: %v", err)
+	}
+	if stored.Number != acc.Number || !stored.ValidPassword(testPassword) {
+		t.Errorf("stored account = %+v, want number %d with the password", stored, acc.Number)
+	}
+}
+
+func TestHandleCreateAccountInvalid(t *testing.T) {
+	router, _ := newTestAPIServer(t)
+
+	req := CreateAccountRequest{FirstName: "Test", Currency: "USD", Password: testPassword}
+	if rec := serveJSON(t, router, http.MethodPost, "/api/v1/account", "", req, nil); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("POST /api/v1/account without a last name = %d %s, want %d", rec.Code, rec.Body, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestHandleTransfer(t *testing.T) {
+	router, store := newTestAPIServer(t)
+	from, token := openTestAccount(t, router, store, 1000)
+	to, _ := openTestAccount(t, router, store, 500)
+
+	var transfer Transfer
+	req := TransferRequest{FromAccountID: from.ID, ToAccountID: to.ID, Amount: money.New(300, "USD")}
+	if rec := serveJSON(t, router, http.MethodPost, "/api/v1/transfer", token, req, &transfer); rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/v1/transfer = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	if transfer.ID == 0 || transfer.Status != TransferStatusCompleted || transfer.Amount.Amount != 300 {
+		t.Errorf("transfer = %+v, want a completed transfer of 300", transfer)
+	}
+
+	for id, want := range map[int]int64{from.ID: 700, to.ID: 800} {
+		acc, err := store.GetAccountById(context.Background(), id)
+		if err != nil {
+			t.Fatalf("reading account %d: %v", id, err)
+		}
+		if acc.Balance.Amount != want {
+			t.Errorf("balance of account %d = %d, want %d", id, acc.Balance.Amount, want)
+		}
+	}
+}
+
+func TestHandleTransferRefused(t *testing.T) {
+	router, store := newTestAPIServer(t)
+	from, token := openTestAccount(t, router, store, 100)
+	to, toToken := openTestAccount(t, router, store, 50)
+
+	tests := []struct {
+		name   string
+		token  string
+		req    TransferRequest
+		status int
+	}{
+		{"without a token", "", TransferRequest{FromAccountID: from.ID, ToAccountID: to.ID, Amount: money.New(10, "USD")}, http.StatusUnauthorized},
+		{"from another account", toToken, TransferRequest{FromAccountID: from.ID, ToAccountID: to.ID, Amount: money.New(10, "USD")}, http.StatusForbidden},
+		{"past the balance", token, TransferRequest{FromAccountID: from.ID, ToAccountID: to.ID, Amount: money.New(101, "USD")}, http.StatusUnprocessableEntity},
+		{"in another currency", token, TransferRequest{FromAccountID: from.ID, ToAccountID: to.ID, Amount: money.New(10, "USD"), Currency: "EUR"}, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serveJSON(t, router, http.MethodPost, "/api/v1/transfer", tt.token, tt.req, nil); rec.Code != tt.status {
+				t.Errorf("POST /api/v1/transfer = %d %s, want %d", rec.Code, rec.Body, tt.status)
+			}
+		})
+	}
+
+	for id, want := range map[int]int64{from.ID: 100, to.ID: 50} {
+		acc, err := store.GetAccountById(context.Background(), id)
+		if err != nil {
+			t.Fatalf("reading account %d: %v", id, err)
+		}
+		if acc.Balance.Amount != want {
+			t.Errorf("balance of account %d = %d, want %d", id, acc.Balance.Amount, want)
+		}
+	}
+}
+
+func TestHandleGetTransfer(t *testing.T) {
+	router, store := newTestAPIServer(t)
+	from, fromToken := openTestAccount(t, router, store, 1000)
+	to, toToken := openTestAccount(t, router, store, 0)
+	_, otherToken := openTestAccount(t, router, store, 0)
+
+	var sent Transfer
+	req := TransferRequest{FromAccountID: from.ID, ToAccountID: to.ID, Amount: money.New(300, "USD")}
+	if rec := serveJSON(t, router, http.MethodPost, "/api/v1/transfer", fromToken, req, &sent); rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/v1/transfer = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	path := fmt.Sprintf("/api/v1/transfer/%d", sent.ID)
+
+	// Both Sides Of The Transfer See It
+	for _, token := range []string{fromToken, toToken} {
+		var got Transfer
+		if rec := serveJSON(t, router, http.MethodGet, path, token, nil, &got); rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d %s, want %d", path, rec.Code, rec.Body, http.StatusOK)
+		}
+		if got.ID != sent.ID || got.Status != TransferStatusCompleted || got.FromAccountID != from.ID || got.ToAccountID != to.ID {
+			t.Errorf("GET %s = %+v, want the completed transfer %d", path, got, sent.ID)
+		}
+	}
+
+	// Anyone Else Does Not
+	if rec := serveJSON(t, router, http.MethodGet, path, otherToken, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET %s from another account = %d, want %d", path, rec.Code, http.StatusNotFound)
+	}
+	if rec := serveJSON(t, router, http.MethodGet, "/api/v1/transfer/999999", fromToken, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET of a missing transfer = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
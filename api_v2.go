@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/moabdelazem/gobank/money"
+)
+
+// defaultV1Sunset is the date after which /api/v1 may be removed,
+// used when the API_V1_SUNSET environment variable is not set.
+var defaultV1Sunset = time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)
+
+// TypedError is the error returned by v2 handlers. It carries the HTTP status
+// and a stable machine readable code alongside the human readable message.
+type TypedError struct {
+	Status  int          `json:"-"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+func (e *TypedError) Error() string {
+	return e.Message
+}
+
+// NewTypedError creates a TypedError with the given status, code, and message.
+func NewTypedError(status int, code, message string) *TypedError {
+	return &TypedError{Status: status, Code: code, Message: message}
+}
+
+// storageTypedError translates the sentinel errors of the storage layer into the
+// TypedError reported to the client, so that a missing account is a 404 and a
+// conflicting write a 409 rather than a generic 400.
+//
+// Parameters:
+//   - err: The error returned by a handler.
+//
+// Returns:
+//   - *TypedError: The matching TypedError, or nil if err is not a storage error.
+func storageTypedError(err error) *TypedError {
+	switch {
+	case errors.Is(err, ErrAccountNotFound):
+		return NewTypedError(http.StatusNotFound, "account_not_found", "account not found")
+	case errors.Is(err, ErrTransferNotFound):
+		return NewTypedError(http.StatusNotFound, "transfer_not_found", "transfer not found")
+	case errors.Is(err, ErrPushDeviceNotFound):
+		return NewTypedError(http.StatusNotFound, "push_device_not_found", "push device not found")
+	case errors.Is(err, ErrConsentNotFound):
+		return NewTypedError(http.StatusNotFound, "consent_not_found", "consent not found")
+	case errors.Is(err, ErrWebhookSecretNotFound):
+		return NewTypedError(http.StatusNotFound, "webhook_secret_not_found", "webhook secret not found")
+	case errors.Is(err, ErrScheduledJobNotFound):
+		return NewTypedError(http.StatusNotFound, "scheduled_job_not_found", "scheduled job not found")
+	case errors.Is(err, ErrScheduledJobLocked):
+		return NewTypedError(http.StatusConflict, "job_running", "the job is running")
+	case errors.Is(err, ErrKYCCheckNotFound):
+		return NewTypedError(http.StatusNotFound, "kyc_check_not_found", "kyc check not found")
+	case errors.Is(err, ErrCardTopUpNotFound):
+		return NewTypedError(http.StatusNotFound, "card_top_up_not_found", "card top-up not found")
+	case errors.Is(err, ErrProviderReferenceTaken):
+		return NewTypedError(http.StatusConflict, "provider_reference_taken", "another transfer has this provider reference")
+	case errors.Is(err, ErrAccountNumberTaken):
+		return NewTypedError(http.StatusConflict, "account_number_taken", "account number is already taken")
+	case errors.Is(err, ErrExternalIDTaken):
+		return externalIDTakenError()
+	case errors.Is(err, ErrInsufficientFunds):
+		return NewTypedError(http.StatusUnprocessableEntity, "insufficient_funds", "the account does not have enough funds")
+	case errors.Is(err, money.ErrCurrencyMismatch):
+		return NewTypedError(http.StatusUnprocessableEntity, "currency_mismatch", "the amounts are in different currencies")
+	case errors.Is(err, money.ErrOverflow):
+		return NewTypedError(http.StatusUnprocessableEntity, "amount_out_of_range", "the amount is out of range")
+	case errors.Is(err, ErrAccountVersionConflict):
+		return NewTypedError(http.StatusConflict, "version_conflict", "account was modified by another request")
+	case errors.Is(err, ErrDatabaseUnavailable):
+		return NewTypedError(http.StatusServiceUnavailable, "database_unavailable", "the database is unavailable, retry later")
+	}
+	return nil
+}
+
+// APIErrorV2 is the v2 error response body.
+type APIErrorV2 struct {
+	Error     *TypedError `json:"error"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// makeHTTPHandlerFuncV2 wraps an apiFunc with an http.HandlerFunc that writes
+// errors in the v2 typed error shape. Errors that are not a TypedError are
+// reported as a 400 with the "bad_request" code, except for the storage errors
+// translated by storageTypedError. Messages are localized according
+// to the Accept-Language header.
+//
+// Parameters:
+//   - f: The apiFunc to be wrapped.
+//
+// Returns:
+//   - An http.HandlerFunc that executes the provided apiFunc and handles errors.
+func makeHTTPHandlerFuncV2(f apiFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := f(w, r); err != nil {
+			var typedErr *TypedError
+			if !errors.As(err, &typedErr) {
+				if typedErr = storageTypedError(err); typedErr == nil {
+					typedErr = NewTypedError(http.StatusBadRequest, "bad_request", err.Error())
+				}
+			}
+			localizeTypedError(r, typedErr)
+			if typedErr.Status >= http.StatusInternalServerError {
+				reportError(r, err)
+			} else {
+				slog.DebugContext(r.Context(), "Request Failed", "status", typedErr.Status, "error", err)
+			}
+			writeErrorResponse(w, r, typedErr.Status, APIErrorV2{Error: typedErr, RequestID: requestIDFromContext(r.Context())})
+		}
+	}
+}
+
+// withDeprecation marks every response of the wrapped router as deprecated by
+// setting the Deprecation, Sunset, and Link headers pointing to the successor version.
+//
+// Parameters:
+//   - successor: The path prefix of the API version replacing this one.
+//   - sunset: The date the deprecated version is removed.
+//
+// Returns:
+//   - mux.MiddlewareFunc: The middleware to attach to the deprecated router.
+func withDeprecation(successor string, sunset time.Time) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Sunset", sunset.Format(http.TimeFormat))
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// registerV2Routes registers the /api/v2 routes on the given router.
+//
+// Routes:
+// - POST /api/v2/login: Returns the token of an account for its number and password.
+// - POST /api/v2/accounts: Handles account creation.
+// - GET /api/v2/accounts/{id:[0-9]+}: Retrieves account details by ID.
+// - PUT/PATCH /api/v2/accounts/{id:[0-9]+}: Updates an account by ID, requires If-Match.
+// - DELETE /api/v2/accounts/{id:[0-9]+}: Deletes an account by ID.
+// - GET /api/v2/accounts/{id:[0-9]+}/transactions: Lists the transactions of an account with cursor pagination.
+// - GET /api/v2/accounts/{id:[0-9]+}/events: Streams account activity as Server-Sent Events.
+// - POST /api/v2/transfers: Handles money transfers from the account of the token.
+// - GET /api/v2/transfers/{transfer:[0-9]+}: Retrieves the state of a transfer, with long-poll support.
+func (as *APIServer) registerV2Routes(router *mux.Router) {
+	v2 := router.PathPrefix("/api/v2").Subrouter()
+
+	v2.HandleFunc("/login", makeHTTPHandlerFuncV2(handleLogin(as.store))).Methods(http.MethodPost)
+	v2.HandleFunc("/accounts", as.withIdempotency(makeHTTPHandlerFuncV2(as.handleCreateAccount))).Methods(http.MethodPost)
+	v2.HandleFunc("/accounts/{id:[0-9]+}", withJWTAuth(makeHTTPHandlerFuncV2(as.handleGetAccountById), as.store)).Methods(http.MethodGet)
+	v2.HandleFunc("/accounts/{id:[0-9]+}", withJWTAuth(makeHTTPHandlerFuncV2(as.handleUpdateAccount), as.store)).Methods(http.MethodPut, http.MethodPatch)
+	v2.HandleFunc("/accounts/{id:[0-9]+}", withJWTAuth(makeHTTPHandlerFuncV2(as.handleDeleteAccount), as.store)).Methods(http.MethodDelete)
+	v2.HandleFunc("/accounts/{id:[0-9]+}/transactions", withJWTAuth(makeHTTPHandlerFuncV2(as.handleListTransactionsV2), as.store)).Methods(http.MethodGet)
+	v2.HandleFunc("/accounts/{id:[0-9]+}/events", withJWTAuth(makeHTTPHandlerFuncV2(as.handleAccountEvents), as.store)).Methods(http.MethodGet)
+	v2.HandleFunc("/transfers", withJWTAuth(as.withIdempotency(makeHTTPHandlerFuncV2(as.withMoneyMovement(as.handleTransfer))), as.store)).Methods(http.MethodPost)
+	v2.HandleFunc("/transfers/{transfer:[0-9]+}", withJWTAuth(makeHTTPHandlerFuncV2(as.handleGetTransfer), as.store)).Methods(http.MethodGet)
+}
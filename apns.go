@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Default APNs Settings
+const (
+	defaultAPNsAPIURL = "https://api.push.apple.com"
+	// apnsTokenRefresh renews the provider token: Apple rejects the ones older than an
+	// hour, and the ones renewed more often than every 20 minutes.
+	apnsTokenRefresh = 50 * time.Minute
+)
+
+// apnsInvalidTokenReasons are the reasons APNs gives for a device token that will
+// never be accepted again.
+var apnsInvalidTokenReasons = map[string]bool{
+	"BadDeviceToken":         true,
+	"Unregistered":           true,
+	"DeviceTokenNotForTopic": true,
+}
+
+// APNsSender pushes the notifications to iOS devices through the HTTP/2 API of the
+// Apple Push Notification service, authenticated with a provider token signed by the
+// .p8 key of the team.
+type APNsSender struct {
+	cfg    APNsConfig
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// NewAPNsSender creates a sender authenticated with the signing key file of cfg.
+//
+// Parameters:
+//   - cfg: The APNs settings, with the path of the key and its key and team IDs.
+//   - timeout: The timeout of a request to Apple.
+//
+// Returns:
+//   - *APNsSender: The sender.
+//   - error: An error if the key file cannot be read or parsed.
+func NewAPNsSender(cfg APNsConfig, timeout time.Duration) (*APNsSender, error) {
+	data, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := jwt.ParseECPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", cfg.KeyFile, err)
+	}
+
+	// The Default Transport Negotiates HTTP/2, Which APNs Requires, Over TLS
+	return &APNsSender{cfg: cfg, key: key, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Send pushes a notification to the device of its device token, as an alert.
+//
+// Parameters:
+//   - ctx: The context of the request to Apple.
+//   - notification: The notification, with its token, title, and body.
+//
+// Returns:
+//   - error: errPushTokenInvalid if the token is no longer valid for the app, or an
+//     error if APNs cannot be reached or refuses the notification.
+func (s *APNsSender) Send(ctx context.Context, notification *Notification) error {
+	token, err := s.providerToken()
+	if err != nil {
+		return fmt.Errorf("signing the APNs provider token: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"aps": map[string]any{
+			"alert": map[string]string{"title": notification.Subject, "body": notification.Body},
+			"sound": "default",
+		},
+		"kind": notification.Kind,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.APIURL+"/3/device/"+notification.To, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Apns-Topic", s.cfg.Topic)
+	req.Header.Set("Apns-Push-Type", "alert")
+	req.Header.Set("Apns-Priority", "10")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	var apiErr struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+
+	switch {
+	case resp.StatusCode == http.StatusGone || apnsInvalidTokenReasons[apiErr.Reason]:
+		return errPushTokenInvalid
+	case apiErr.Reason == "ExpiredProviderToken":
+		// Sign A New Provider Token For The Next Notification
+		s.mu.Lock()
+		s.token = ""
+		s.mu.Unlock()
+	}
+	return fmt.Errorf("apns refused the notification with status %d: %s", resp.StatusCode, apiErr.Reason)
+}
+
+// providerToken returns the provider token, signing a new one once it is due.
+func (s *APNsSender) providerToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.token != "" && now.Sub(s.issuedAt) < apnsTokenRefresh {
+		return s.token, nil
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": s.cfg.TeamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = s.cfg.KeyID
+
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		return "", err
+	}
+
+	s.token, s.issuedAt = signed, now
+	return s.token, nil
+}
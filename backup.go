@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// backupFormatVersion is the version of the backup file layout, checked on restore.
+const backupFormatVersion = 1
+
+// backupMagic starts every encrypted backup file, so restore can tell them from plain ones.
+const backupMagic = "GOBANKENC1"
+
+// Backup Encryption Settings
+const (
+	backupSaltSize = 16
+	backupKeySize  = 32
+	// scrypt cost parameters for the key derived from BACKUP_PASSPHRASE.
+	backupScryptN = 1 << 15
+	backupScryptR = 8
+	backupScryptP = 1
+)
+
+// ErrBackupPassphrase is returned when an encrypted backup cannot be opened with the given passphrase.
+var ErrBackupPassphrase = errors.New("wrong backup passphrase or corrupted backup")
+
+// backupRecord is one line of a backup file. The first line holds the header, every
+// following line a single account or transaction.
+type backupRecord struct {
+	Header  *backupHeader `json:"header,omitempty"`
+	Account *Account      `json:"account,omitempty"`
+	// AccountPassword is the EncryptedPassword of the account of the line, which the
+	// JSON of an account leaves out.
+	AccountPassword string       `json:"account_password,omitempty"`
+	Transaction     *Transaction `json:"transaction,omitempty"`
+}
+
+// backupHeader describes the backup file.
+type backupHeader struct {
+	Format    int       `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BackupStats counts the rows written to or loaded from a backup.
+type BackupStats struct {
+	Accounts     int `json:"accounts"`
+	Transactions int `json:"transactions"`
+}
+
+// Restore Queries
+const (
+	sqlCountBackupRows = `SELECT (SELECT COUNT(*) FROM accounts) + (SELECT COUNT(*) FROM transactions)`
+
+	// The Backups Written Before The Currencies Are In USD, The Default Of Their Rows
+	sqlRestoreAccount = `INSERT INTO accounts (` + accountColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, COALESCE(NULLIF($12, ''), 'not_started'), $13, COALESCE(NULLIF($14, ''), 'USD'), NULLIF($15, ''), $16)`
+
+	sqlRestoreTransaction = `INSERT INTO transactions (` + transactionColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE(NULLIF($10, ''), 'USD'))`
+
+	sqlResetAccountsSequence     = `SELECT setval('accounts_id_seq', COALESCE(MAX(id), 0) + 1, false) FROM accounts`
+	sqlResetTransactionsSequence = `SELECT setval('transactions_id_seq', COALESCE(MAX(id), 0) + 1, false) FROM transactions`
+
+	// Move The Restored History Out Of The Default Partition
+	sqlPartitionRestoredMonths = `SELECT create_transactions_partition(month::date)
+	FROM generate_series(
+		(SELECT date_trunc('month', MIN(created_at)) FROM transactions_default),
+		(SELECT date_trunc('month', MAX(created_at)) FROM transactions_default),
+		INTERVAL '1 month'
+	) AS month`
+)
+
+// Backup writes every account, soft-deleted ones included, and every transaction to w
+// as gzip-compressed JSON lines. The rows are read in one read-only REPEATABLE READ
+// transaction, so the backup is a consistent snapshot even while the server is running.
+//
+// Parameters:
+//   - ctx: The context of the database work.
+//   - w: The writer the backup is written to.
+//
+// Returns:
+//   - *BackupStats: The number of rows written.
+//   - error: An error if the rows cannot be read or written.
+func (s *PostgresStorage) Backup(ctx context.Context, w io.Writer) (*BackupStats, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	stats := &BackupStats{}
+
+	if err := enc.Encode(backupRecord{Header: &backupHeader{Format: backupFormatVersion, CreatedAt: time.Now().UTC()}}); err != nil {
+		return nil, err
+	}
+
+	// Accounts First, So The Transactions Can Reference Them On Restore
+	rows, err := tx.QueryContext(ctx, `SELECT `+accountColumns+` FROM accounts ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := enc.Encode(backupRecord{Account: account, AccountPassword: account.EncryptedPassword}); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.Accounts++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT `+transactionColumns+` FROM transactions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		t, err := scanIntoTransaction(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := enc.Encode(backupRecord{Transaction: t}); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.Transactions++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, gz.Close()
+}
+
+// Restore loads a backup written by Backup into an empty database, keeping the IDs of
+// the accounts and transactions, and moves the sequences past the restored IDs. The
+// whole backup is loaded in one transaction, so a failed restore leaves nothing behind.
+//
+// Parameters:
+//   - ctx: The context of the database work.
+//   - r: The reader the backup is read from.
+//
+// Returns:
+//   - *BackupStats: The number of rows loaded.
+//   - error: An error if the database is not empty, the backup is invalid, or a row cannot be inserted.
+func (s *PostgresStorage) Restore(ctx context.Context, r io.Reader) (*BackupStats, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+	defer gz.Close()
+
+	stats := &BackupStats{}
+	err = s.withTx(ctx, func(tx *PostgresStorage) error {
+		var existing int
+		if err := tx.q.QueryRowContext(ctx, sqlCountBackupRows).Scan(&existing); err != nil {
+			return err
+		}
+		if existing > 0 {
+			return fmt.Errorf("the database is not empty, restore only into a freshly migrated database")
+		}
+
+		dec := json.NewDecoder(bufio.NewReader(gz))
+
+		var header backupRecord
+		if err := dec.Decode(&header); err != nil {
+			return fmt.Errorf("invalid backup: %w", err)
+		}
+		if header.Header == nil || header.Header.Format != backupFormatVersion {
+			return fmt.Errorf("unsupported backup format")
+		}
+
+		for {
+			var rec backupRecord
+			if err := dec.Decode(&rec); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("invalid backup: %w", err)
+			}
+
+			switch {
+			case rec.Account != nil:
+				a := rec.Account
+				if _, err := tx.q.ExecContext(ctx, sqlRestoreAccount, a.ID, a.FirstName, a.LastName, a.Number, a.Balance, a.CreatedAt, a.Version, a.Frozen, a.TransferLimit, a.UpdatedAt, a.DeletedAt, a.KYCStatus, a.DormantAt, a.Currency, a.ExternalID, rec.AccountPassword); err != nil {
+					return fmt.Errorf("account %d: %w", a.ID, constraintError(err))
+				}
+				stats.Accounts++
+			case rec.Transaction != nil:
+				t := rec.Transaction
+				if _, err := tx.q.ExecContext(ctx, sqlRestoreTransaction, t.ID, t.AccountID, t.CounterpartyID, t.Type, t.Amount, t.BalanceAfter, t.CreatedAt, t.Category, t.Reference, t.Currency); err != nil {
+					return fmt.Errorf("transaction %d: %w", t.ID, constraintError(err))
+				}
+				stats.Transactions++
+			}
+		}
+
+		for _, query := range []string{sqlResetAccountsSequence, sqlResetTransactionsSequence, sqlPartitionRestoredMonths} {
+			if _, err := tx.q.ExecContext(ctx, query); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// sealBackup encrypts a backup with AES-256-GCM under a key derived from the passphrase
+// with scrypt. The result is the backup magic, the salt, the nonce, and the ciphertext.
+//
+// Parameters:
+//   - plain: The backup to encrypt.
+//   - passphrase: The passphrase the key is derived from.
+//
+// Returns:
+//   - []byte: The encrypted backup.
+//   - error: An error if the key cannot be derived or no random salt can be generated.
+func sealBackup(plain []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := append([]byte(backupMagic), salt...)
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, plain, []byte(backupMagic)), nil
+}
+
+// openBackup decrypts a backup encrypted by sealBackup.
+//
+// Parameters:
+//   - sealed: The encrypted backup, starting with the backup magic.
+//   - passphrase: The passphrase the backup was encrypted with.
+//
+// Returns:
+//   - []byte: The decrypted backup.
+//   - error: ErrBackupPassphrase if the passphrase is wrong or the backup was altered.
+func openBackup(sealed []byte, passphrase string) ([]byte, error) {
+	sealed = bytes.TrimPrefix(sealed, []byte(backupMagic))
+	if len(sealed) < backupSaltSize {
+		return nil, ErrBackupPassphrase
+	}
+
+	aead, err := backupCipher(passphrase, sealed[:backupSaltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	sealed = sealed[backupSaltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrBackupPassphrase
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(backupMagic))
+	if err != nil {
+		return nil, ErrBackupPassphrase
+	}
+	return plain, nil
+}
+
+// backupCipher derives the backup key from the passphrase and salt and returns its AES-GCM cipher.
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, backupScryptN, backupScryptR, backupScryptP, backupKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// runBackupCommand runs "gobank backup" or "gobank restore".
+//
+// Usage:
+//   - gobank backup [-file path] [-encrypt]: Writes the backup to the file, or to stdout when it is "-".
+//     With -encrypt the backup is encrypted with the passphrase in BACKUP_PASSPHRASE.
+//   - gobank restore [-file path]: Loads the backup from the file, or from stdin when it is "-",
+//     into an empty database. Encrypted backups are detected and opened with BACKUP_PASSPHRASE.
+//
+// Parameters:
+//   - store: The database to back up or restore.
+//   - command: "backup" or "restore".
+//   - args: The arguments following the command.
+//   - passphrase: The passphrase of the encrypted backups, empty when none is configured.
+//
+// Returns:
+//   - error: An error if the arguments are invalid or the backup or restore fails.
+func runBackupCommand(store *PostgresStorage, command string, args []string, passphrase string) error {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	file := flags.String("file", "gobank.backup", `The backup file, "-" for stdout or stdin`)
+	encrypt := flags.Bool("encrypt", false, "Encrypt the backup with the passphrase in BACKUP_PASSPHRASE")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch command {
+	case "backup":
+		if *encrypt && passphrase == "" {
+			return fmt.Errorf("-encrypt needs the passphrase in BACKUP_PASSPHRASE")
+		}
+
+		var buf bytes.Buffer
+		stats, err := store.Backup(ctx, &buf)
+		if err != nil {
+			return err
+		}
+
+		data := buf.Bytes()
+		if *encrypt {
+			if data, err = sealBackup(data, passphrase); err != nil {
+				return err
+			}
+		}
+
+		if *file == "-" {
+			_, err = os.Stdout.Write(data)
+		} else {
+			err = os.WriteFile(*file, data, 0o600)
+		}
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Backup Written", "file", *file, "accounts", stats.Accounts, "transactions", stats.Transactions)
+	case "restore":
+		var data []byte
+		var err error
+		if *file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(*file)
+		}
+		if err != nil {
+			return err
+		}
+
+		if bytes.HasPrefix(data, []byte(backupMagic)) {
+			if passphrase == "" {
+				return fmt.Errorf("the backup is encrypted, set BACKUP_PASSPHRASE to restore it")
+			}
+			if data, err = openBackup(data, passphrase); err != nil {
+				return err
+			}
+		}
+
+		stats, err := store.Restore(ctx, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Backup Restored", "file", *file, "accounts", stats.Accounts, "transactions", stats.Transactions)
+	}
+
+	return nil
+}
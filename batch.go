@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// maxBatchSize is the maximum number of sub-requests in one batch.
+const maxBatchSize = 20
+
+// batchForwardedHeaders are copied from the batch request onto every sub-request.
+var batchForwardedHeaders = []string{"Authorization", "Accept-Language"}
+
+// BatchItem is a single sub-request of a batch.
+type BatchItem struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchRequest is the body of POST /api/v1/batch.
+type BatchRequest struct {
+	Requests []BatchItem `json:"requests"`
+}
+
+// BatchResult is the outcome of a single sub-request. Body holds the JSON response
+// as-is, or a JSON string for non-JSON responses.
+type BatchResult struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// Validate checks the number of sub-requests and that every one of them can be run in a batch.
+// Streaming endpoints and nested batches are rejected.
+func (req *BatchRequest) Validate() []FieldError {
+	errs := []FieldError{}
+
+	if len(req.Requests) == 0 {
+		return append(errs, FieldError{Field: "requests", Code: CodeRequired, Message: "requests is required"})
+	}
+	if len(req.Requests) > maxBatchSize {
+		return append(errs, FieldError{Field: "requests", Code: CodeTooLong, Message: fmt.Sprintf("a batch may contain at most %d requests", maxBatchSize)})
+	}
+
+	for i, item := range req.Requests {
+		field := fmt.Sprintf("requests[%d]", i)
+
+		if item.Method == "" {
+			errs = append(errs, FieldError{Field: field + ".method", Code: CodeRequired, Message: "method is required"})
+		}
+
+		// Match The Route On The Path The Router Sees, Without The Query
+		target, err := url.Parse(item.Path)
+		if err != nil {
+			errs = append(errs, FieldError{Field: field + ".path", Code: CodeInvalid, Message: "path must be a valid URL path"})
+			continue
+		}
+		routePath := path.Clean(target.Path)
+
+		switch {
+		case !strings.HasPrefix(routePath, "/api/"):
+			errs = append(errs, FieldError{Field: field + ".path", Code: CodeInvalid, Message: "path must start with /api/"})
+		case strings.HasSuffix(routePath, "/batch"), strings.HasSuffix(routePath, "/events"):
+			errs = append(errs, FieldError{Field: field + ".path", Code: CodeInvalid, Message: "batch and streaming endpoints cannot be batched"})
+		}
+	}
+
+	return errs
+}
+
+// handleBatch executes up to maxBatchSize sub-requests sequentially against the router
+// and returns the status, headers, and body of each, in order. A failing sub-request
+// does not stop the batch. The Authorization header of the batch applies to every
+// sub-request unless the sub-request sets its own.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the batch results to.
+//   - r: *http.Request containing the sub-requests.
+//
+// Returns:
+//   - error: An error if the batch request is invalid, otherwise nil.
+func (as *APIServer) handleBatch(w http.ResponseWriter, r *http.Request) error {
+	batchReq := new(BatchRequest)
+
+	if err := bindJSON(w, r, batchReq); err != nil {
+		return err
+	}
+
+	results := make([]BatchResult, 0, len(batchReq.Requests))
+
+	for _, item := range batchReq.Requests {
+		subReq, err := http.NewRequestWithContext(r.Context(), strings.ToUpper(item.Method), item.Path, bytes.NewReader(item.Body))
+		if err != nil {
+			results = append(results, batchErrorResult(http.StatusBadRequest, err.Error()))
+			continue
+		}
+
+		for _, header := range batchForwardedHeaders {
+			if value := r.Header.Get(header); value != "" {
+				subReq.Header.Set(header, value)
+			}
+		}
+		for key, value := range item.Headers {
+			subReq.Header.Set(key, value)
+		}
+		subReq.Header.Set("Accept", "application/json")
+
+		// The Sub-Requests Run Under The ID Of The Batch
+		subReq.Header.Set(requestIDHeader, requestIDFromContext(r.Context()))
+		if len(item.Body) > 0 {
+			subReq.Header.Set("Content-Type", "application/json")
+		}
+
+		rec := httptest.NewRecorder()
+		as.router.ServeHTTP(rec, subReq)
+
+		results = append(results, newBatchResult(rec))
+	}
+
+	return WriteResponse(w, r, http.StatusOK, results)
+}
+
+// newBatchResult converts a recorded sub-response into a BatchResult.
+func newBatchResult(rec *httptest.ResponseRecorder) BatchResult {
+	result := BatchResult{Status: rec.Code, Headers: map[string]string{}}
+
+	for _, header := range []string{"Content-Type", "ETag", "Location"} {
+		if value := rec.Header().Get(header); value != "" {
+			result.Headers[header] = value
+		}
+	}
+
+	body := bytes.TrimSpace(rec.Body.Bytes())
+	if len(body) == 0 {
+		return result
+	}
+
+	if json.Valid(body) {
+		result.Body = body
+	} else {
+		result.Body, _ = json.Marshal(string(body))
+	}
+
+	return result
+}
+
+// batchErrorResult builds the result of a sub-request that could not be dispatched.
+func batchErrorResult(status int, message string) BatchResult {
+	body, _ := json.Marshal(APIError{Error: message})
+	return BatchResult{Status: status, Body: body}
+}
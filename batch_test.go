@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestBatchRequestValidatePath(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		valid bool
+	}{
+		{"account", "/api/v1/account/1", true},
+		{"account with query", "/api/v1/account/1/transactions?limit=10", true},
+		{"outside the api", "/admin/accounts", false},
+		{"batch", "/api/v1/batch", false},
+		{"batch with query", "/api/v1/batch?x=1", false},
+		{"batch with fragment", "/api/v1/batch#x", false},
+		{"batch with trailing slash", "/api/v1/batch/", false},
+		{"batch with dot segment", "/api/v1/./batch", false},
+		{"events", "/api/v1/account/1/events", false},
+		{"events with query", "/api/v2/accounts/1/events?since=0", false},
+		{"invalid url", "/api/v1/%zz", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &BatchRequest{Requests: []BatchItem{{Method: "GET", Path: tt.path}}}
+			errs := req.Validate()
+			if valid := len(errs) == 0; valid != tt.valid {
+				t.Errorf("Validate(%q) = %v, want valid %v", tt.path, errs, tt.valid)
+			}
+		})
+	}
+}
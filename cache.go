@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/moabdelazem/gobank/money"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultAccountCacheTTL is how long a cached account is served before it is read again.
+const defaultAccountCacheTTL = time.Minute
+
+// CachedStorage is a Storage decorator that caches GetAccountById in Redis. Every method
+// that changes an account drops its cached copy once the change is stored.
+type CachedStorage struct {
+	Storage
+
+	client *redis.Client
+	ttl    time.Duration
+
+	hits   prometheus.Counter
+	misses prometheus.Counter
+
+	// pending collects the accounts changed inside WithTx, which are only dropped
+	// from the cache once the transaction is committed; nil outside a transaction.
+	pending *pendingInvalidations
+}
+
+// pendingInvalidations is the set of accounts changed by a running transaction.
+type pendingInvalidations struct {
+	mu  sync.Mutex
+	ids []int
+}
+
+// NewCachedStorage wraps store with a Redis account cache when a Redis URL is
+// configured, with cached accounts expiring after the account TTL.
+//
+// Parameters:
+//   - store: The storage whose account reads are cached.
+//   - cfg: The cache settings.
+//   - hooks: The hooks of the Redis client, such as the fault injection ones.
+//
+// Returns:
+//   - Storage: The cached storage, or store itself when no Redis URL is configured.
+//   - error: An error if the Redis URL is invalid or Redis cannot be reached.
+func NewCachedStorage(store Storage, cfg CacheConfig, hooks ...redis.Hook) (Storage, error) {
+	if cfg.RedisURL == "" {
+		return store, nil
+	}
+
+	opts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	for _, hook := range hooks {
+		client.AddHook(hook)
+	}
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	counter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "account_cache", Name: name, Help: help,
+		})
+	}
+
+	return &CachedStorage{
+		Storage: store,
+		client:  client,
+		ttl:     cfg.AccountTTL,
+		hits:    counter("hits_total", "Number of account reads served from the cache."),
+		misses:  counter("misses_total", "Number of account reads that went to the database."),
+	}, nil
+}
+
+// accountCacheKey returns the Redis key of a cached account.
+func accountCacheKey(id int) string {
+	return fmt.Sprintf("gobank:account:%d", id)
+}
+
+// GetAccountById returns the cached account, reading and caching it on a miss. Inside a
+// transaction the cache is bypassed so the transaction sees its own changes.
+func (s *CachedStorage) GetAccountById(ctx context.Context, id int) (*Account, error) {
+	if s.pending != nil {
+		return s.Storage.GetAccountById(ctx, id)
+	}
+
+	// Serve The Cached Copy, Unless It Was Cached Before The Accounts Had A Currency
+	raw, err := s.client.Get(ctx, accountCacheKey(id)).Bytes()
+	if err == nil {
+		acc := &Account{}
+		if err := json.Unmarshal(raw, acc); err == nil && acc.Currency != "" {
+			acc.denominate()
+			s.hits.Inc()
+			return acc, nil
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		slog.WarnContext(ctx, "Error Reading Account From The Cache", "account_id", id, "error", err)
+	}
+	s.misses.Inc()
+
+	acc, err := s.Storage.GetAccountById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw, err := json.Marshal(acc); err == nil {
+		if err := s.client.Set(ctx, accountCacheKey(id), raw, s.ttl).Err(); err != nil {
+			slog.WarnContext(ctx, "Error Caching Account", "account_id", id, "error", err)
+		}
+	}
+
+	return acc, nil
+}
+
+// invalidate drops the cached copies of the given accounts, or records them to be
+// dropped when the running transaction commits.
+func (s *CachedStorage) invalidate(ctx context.Context, ids ...int) {
+	if s.pending != nil {
+		s.pending.mu.Lock()
+		s.pending.ids = append(s.pending.ids, ids...)
+		s.pending.mu.Unlock()
+		return
+	}
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, accountCacheKey(id))
+	}
+
+	// A Stale Entry Only Lives Until Its TTL If This Fails
+	if err := s.client.Del(context.WithoutCancel(ctx), keys...).Err(); err != nil {
+		slog.WarnContext(ctx, "Error Invalidating Cached Accounts", "account_ids", ids, "error", err)
+	}
+}
+
+// DeleteAccount deletes the account and drops its cached copy.
+func (s *CachedStorage) DeleteAccount(ctx context.Context, id int) error {
+	if err := s.Storage.DeleteAccount(ctx, id); err != nil {
+		return err
+	}
+	s.invalidate(ctx, id)
+	return nil
+}
+
+// UpdateAccount updates the account and drops its cached copy.
+func (s *CachedStorage) UpdateAccount(ctx context.Context, account *Account) error {
+	if err := s.Storage.UpdateAccount(ctx, account); err != nil {
+		return err
+	}
+	s.invalidate(ctx, account.ID)
+	return nil
+}
+
+// TransferFunds moves the funds and drops the cached copies of both accounts.
+func (s *CachedStorage) TransferFunds(ctx context.Context, fromID, toID int, amount money.Money) ([]*Transaction, error) {
+	txns, err := s.Storage.TransferFunds(ctx, fromID, toID, amount)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate(ctx, fromID, toID)
+	return txns, nil
+}
+
+// DepositFunds credits the account and drops its cached copy.
+func (s *CachedStorage) DepositFunds(ctx context.Context, deposit *Transaction) error {
+	if err := s.Storage.DepositFunds(ctx, deposit); err != nil {
+		return err
+	}
+	s.invalidate(ctx, deposit.AccountID)
+	return nil
+}
+
+// SetAccountFrozen freezes or unfreezes the account and drops its cached copy.
+func (s *CachedStorage) SetAccountFrozen(ctx context.Context, id int, frozen bool) error {
+	if err := s.Storage.SetAccountFrozen(ctx, id, frozen); err != nil {
+		return err
+	}
+	s.invalidate(ctx, id)
+	return nil
+}
+
+// SetAccountKYCStatus changes the KYC status of the account and drops its cached copy.
+func (s *CachedStorage) SetAccountKYCStatus(ctx context.Context, id int, status string) error {
+	if err := s.Storage.SetAccountKYCStatus(ctx, id, status); err != nil {
+		return err
+	}
+	s.invalidate(ctx, id)
+	return nil
+}
+
+// UpdateDormantAccounts updates the dormancy of the accounts and drops the cached copies
+// of the ones changed.
+func (s *CachedStorage) UpdateDormantAccounts(ctx context.Context, inactiveSince time.Time) (dormant, reactivated []int, err error) {
+	dormant, reactivated, err = s.Storage.UpdateDormantAccounts(ctx, inactiveSince)
+	if err != nil {
+		return nil, nil, err
+	}
+	if changed := append(slices.Clone(dormant), reactivated...); len(changed) > 0 {
+		s.invalidate(ctx, changed...)
+	}
+	return dormant, reactivated, nil
+}
+
+// SetTransferLimit changes the transfer limit of the account and drops its cached copy.
+func (s *CachedStorage) SetTransferLimit(ctx context.Context, id int, limit money.Money) error {
+	if err := s.Storage.SetTransferLimit(ctx, id, limit); err != nil {
+		return err
+	}
+	s.invalidate(ctx, id)
+	return nil
+}
+
+// WithTx runs fn in a transaction of the wrapped storage, dropping the cached copies of
+// the accounts it changed only after the transaction is committed.
+func (s *CachedStorage) WithTx(ctx context.Context, fn func(Storage) error) error {
+	// Join The Running Transaction
+	if s.pending != nil {
+		return s.Storage.WithTx(ctx, func(tx Storage) error {
+			return fn(&CachedStorage{Storage: tx, client: s.client, ttl: s.ttl, hits: s.hits, misses: s.misses, pending: s.pending})
+		})
+	}
+
+	pending := &pendingInvalidations{}
+	err := s.Storage.WithTx(ctx, func(tx Storage) error {
+		return fn(&CachedStorage{Storage: tx, client: s.client, ttl: s.ttl, hits: s.hits, misses: s.misses, pending: pending})
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(pending.ids) > 0 {
+		s.invalidate(ctx, pending.ids...)
+	}
+	return nil
+}
+
+// Unwrap returns the cached storage.
+func (s *CachedStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+// Collectors returns the cache hit and miss counters, for the metrics registry.
+func (s *CachedStorage) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{s.hits, s.misses}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// CreateAccount creates a new account. The request carries an Idempotency-Key so it
+// is safely retried on transient failures.
+func (c *Client) CreateAccount(ctx context.Context, req CreateAccountRequest) (*Account, error) {
+	acc := new(Account)
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v2/accounts", body: req, idempotent: true}, acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// GetAccount retrieves an account. The returned account carries the ETag needed by UpdateAccount.
+func (c *Client) GetAccount(ctx context.Context, id int) (*Account, error) {
+	acc := new(Account)
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v2/accounts/%d", id)}, acc)
+	if err != nil {
+		return nil, err
+	}
+	acc.ETag = resp.header.Get("ETag")
+	return acc, nil
+}
+
+// UpdateAccount changes the fields of an account that are set in req. The update only
+// applies if the account still matches etag (as returned by GetAccount); otherwise an
+// Error with status 412 is returned.
+func (c *Client) UpdateAccount(ctx context.Context, id int, etag string, req UpdateAccountRequest) (*Account, error) {
+	acc := new(Account)
+	resp, err := c.do(ctx, request{
+		method:  http.MethodPatch,
+		path:    fmt.Sprintf("/api/v2/accounts/%d", id),
+		body:    req,
+		headers: map[string]string{"If-Match": etag},
+	}, acc)
+	if err != nil {
+		return nil, err
+	}
+	acc.ETag = resp.header.Get("ETag")
+	return acc, nil
+}
+
+// DeleteAccount deletes an account.
+func (c *Client) DeleteAccount(ctx context.Context, id int) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/api/v2/accounts/%d", id)}, nil)
+	return err
+}
+
+// ListTransactions returns one page of the transactions of an account, newest first.
+// Pass an empty cursor for the first page and the NextCursor() of the previous page after that.
+func (c *Client) ListTransactions(ctx context.Context, accountID int, cursor string, limit int) (*TransactionPage, error) {
+	query := url.Values{}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	path := fmt.Sprintf("/api/v2/accounts/%d/transactions", accountID)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	page := new(TransactionPage)
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: path}, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
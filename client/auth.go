@@ -0,0 +1,26 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// loginRequest is the body of a login.
+type loginRequest struct {
+	Number   int64  `json:"number"`
+	Password string `json:"password"`
+}
+
+// Login authenticates as the holder of an account with its number and password, and
+// sends the token of the account with every later request. A wrong number or password
+// is an Error with status 401.
+func (c *Client) Login(ctx context.Context, number int64, password string) (*LoginResponse, error) {
+	login := new(LoginResponse)
+	req := request{method: http.MethodPost, path: "/api/v2/login", body: loginRequest{Number: number, Password: password}}
+	if _, err := c.do(ctx, req, login); err != nil {
+		return nil, err
+	}
+
+	c.SetToken(login.Token)
+	return login, nil
+}
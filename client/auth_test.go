@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogin(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v2/login", func(w http.ResponseWriter, r *http.Request) {
+		var req loginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding the login: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if req.Number != 1234567890 || req.Password != "hunter2hunter2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"code":"invalid_account_credentials","message":"invalid account number or password"}}`))
+			return
+		}
+		w.Write([]byte(`{"number":1234567890,"token":"account-token"}`))
+	})
+	mux.HandleFunc("GET /api/v2/accounts/1", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer account-token" {
+			t.Errorf("Authorization = %q, want the token of the login", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1,"number":1234567890}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	c := New(server.URL, WithRetries(0, 0))
+
+	_, err := c.Login(ctx, 1234567890, "wrong password")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Code != "invalid_account_credentials" {
+		t.Fatalf("Login with a wrong password error = %v, want a 401 invalid_account_credentials", err)
+	}
+	if c.Token() != "" {
+		t.Errorf("Token() = %q after a failed login, want none", c.Token())
+	}
+
+	login, err := c.Login(ctx, 1234567890, "hunter2hunter2")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if login.Number != 1234567890 || login.Token != "account-token" {
+		t.Errorf("Login = %+v, want the number and token of the account", login)
+	}
+	if c.Token() != "account-token" {
+		t.Errorf("Token() = %q, want the token of the login", c.Token())
+	}
+
+	if _, err := c.GetAccount(ctx, 1); err != nil {
+		t.Fatalf("GetAccount after the login: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+// Package client is a typed Go client for the GoBank HTTP API (v2).
+//
+// It handles authentication, retries of transient failures with exponential backoff,
+// and idempotency keys, so that retried account creations and transfers are never
+// applied twice.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default Client Settings
+const (
+	DefaultTimeout    = 30 * time.Second
+	DefaultMaxRetries = 3
+	DefaultRetryWait  = 200 * time.Millisecond
+)
+
+// Client talks to a GoBank server. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	mu         sync.RWMutex
+	token      string
+	httpClient *http.Client
+	maxRetries int
+	retryWait  time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken sets the JWT token sent in the Authorization header.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient replaces the underlying http.Client.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a failed request is retried and the initial wait
+// between attempts, which doubles after every attempt.
+func WithRetries(maxRetries int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryWait = wait
+	}
+}
+
+// New creates a Client for the server at baseURL (e.g. "http://localhost:8080").
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+		maxRetries: DefaultMaxRetries,
+		retryWait:  DefaultRetryWait,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// SetToken changes the JWT token used by later requests.
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// Token returns the JWT token sent with the requests, as set by WithToken, SetToken, or Login.
+func (c *Client) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// Error is returned when the server answers with an error status.
+type Error struct {
+	StatusCode int          `json:"-"`
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+	Fields     []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError describes why a single field of a request was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gobank: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is an Error with status 404.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// request describes a single API call.
+type request struct {
+	method  string
+	path    string
+	body    interface{}
+	headers map[string]string
+	// idempotent marks POST requests that carry an Idempotency-Key and may be retried.
+	idempotent bool
+}
+
+// response is the decoded outcome of a successful API call.
+type response struct {
+	status int
+	header http.Header
+}
+
+// do executes the request, retrying network errors, 429, and 5xx responses for
+// idempotent requests, and decodes a successful JSON response into out.
+func (c *Client) do(ctx context.Context, req request, out interface{}) (*response, error) {
+	var payload []byte
+	if req.body != nil {
+		var err error
+		if payload, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+
+	retryable := req.idempotent || req.method != http.MethodPost
+	if req.idempotent {
+		if req.headers == nil {
+			req.headers = map[string]string{}
+		}
+		if req.headers["Idempotency-Key"] == "" {
+			req.headers["Idempotency-Key"] = newIdempotencyKey()
+		}
+	}
+
+	wait := c.retryWait
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req, payload, out)
+		if err == nil {
+			return resp, nil
+		}
+
+		if !retryable || attempt >= c.maxRetries || !isTransient(err) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// send performs a single HTTP attempt.
+func (c *Client) send(ctx context.Context, req request, payload []byte, out interface{}) (*response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, c.baseURL+req.path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Accept", "application/json")
+	if payload != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if token := c.Token(); token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+	for name, value := range req.headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &transientError{err: err}
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode >= http.StatusBadRequest {
+		apiErr := decodeError(httpResp)
+		if httpResp.StatusCode == http.StatusTooManyRequests || httpResp.StatusCode >= http.StatusInternalServerError {
+			return nil, &transientError{err: apiErr}
+		}
+		return nil, apiErr
+	}
+
+	if out != nil && httpResp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(httpResp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("gobank: decoding response: %w", err)
+		}
+	}
+
+	return &response{status: httpResp.StatusCode, header: httpResp.Header}, nil
+}
+
+// decodeError reads the v2 error body ({"error": {...}}), tolerating the v1 shape
+// ({"error": "message"}) and non-JSON bodies.
+func decodeError(httpResp *http.Response) *Error {
+	raw, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	apiErr := &Error{StatusCode: httpResp.StatusCode, Code: "unknown", Message: strings.TrimSpace(string(raw))}
+
+	var v2 struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(raw, &v2) != nil || len(v2.Error) == 0 {
+		return apiErr
+	}
+
+	if json.Unmarshal(v2.Error, apiErr) != nil {
+		var message string
+		if json.Unmarshal(v2.Error, &message) == nil {
+			apiErr.Message = message
+		}
+	}
+	apiErr.StatusCode = httpResp.StatusCode
+
+	return apiErr
+}
+
+// transientError wraps failures that are worth retrying.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// isTransient reports whether err may succeed on retry.
+func isTransient(err error) bool {
+	var transient *transientError
+	return errors.As(err, &transient)
+}
+
+// newIdempotencyKey generates a random Idempotency-Key.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
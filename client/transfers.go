@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// CreateTransfer submits a transfer. Small transfers complete synchronously; large ones
+// are accepted and returned in the pending state, to be followed with WaitForTransfer.
+// The request carries an Idempotency-Key so it is safely retried on transient failures.
+func (c *Client) CreateTransfer(ctx context.Context, req TransferRequest) (*Transfer, error) {
+	transfer := new(Transfer)
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v2/transfers", body: req, idempotent: true}, transfer); err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// GetTransfer retrieves the current state of a transfer.
+func (c *Client) GetTransfer(ctx context.Context, id int) (*Transfer, error) {
+	transfer := new(Transfer)
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v2/transfers/%d", id)}, transfer); err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// WaitForTransfer long-polls a transfer until it is completed or failed, or ctx is done.
+func (c *Client) WaitForTransfer(ctx context.Context, id int) (*Transfer, error) {
+	const pollWait = 30 * time.Second
+
+	for {
+		transfer := new(Transfer)
+		path := fmt.Sprintf("/api/v2/transfers/%d?wait=%s", id, pollWait)
+		if _, err := c.do(ctx, request{method: http.MethodGet, path: path}, transfer); err != nil {
+			return nil, err
+		}
+
+		if transfer.IsFinal() {
+			return transfer, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return transfer, err
+		}
+	}
+}
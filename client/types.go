@@ -0,0 +1,144 @@
+package client
+
+import (
+	"net/url"
+	"time"
+)
+
+// Link is a single hypermedia link of a resource.
+type Link struct {
+	Href   string `json:"href"`
+	Method string `json:"method,omitempty"`
+}
+
+// Account is a bank account.
+type Account struct {
+	ID            int             `json:"id"`
+	FirstName     string          `json:"first_name"`
+	LastName      string          `json:"last_name"`
+	Number        int64           `json:"number"`
+	Balance       int64           `json:"balance"`
+	Currency      string          `json:"currency"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Version       int             `json:"version"`
+	Frozen        bool            `json:"frozen"`
+	TransferLimit int64           `json:"transfer_limit"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	DeletedAt     *time.Time      `json:"deleted_at,omitempty"`
+	KYCStatus     string          `json:"kyc_status"`
+	DormantAt     *time.Time      `json:"dormant_at,omitempty"`
+	ExternalID    string          `json:"external_id,omitempty"`
+	Links         map[string]Link `json:"_links,omitempty"`
+
+	// ETag is the entity tag returned with the account, used for conditional updates.
+	ETag string `json:"-"`
+}
+
+// CreateAccountRequest is the input of CreateAccount.
+type CreateAccountRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	// Currency is the ISO 4217 code of the account, the default currency of the server when empty.
+	Currency string `json:"currency,omitempty"`
+	// ExternalID is the reference of the customer in the systems of the caller; creating
+	// an account again with it returns the account created the first time.
+	ExternalID string `json:"external_id,omitempty"`
+	// Password is what the holder logs in with, at least 8 characters.
+	Password string `json:"password"`
+}
+
+// LoginResponse is the output of Login.
+type LoginResponse struct {
+	Number int64  `json:"number"`
+	Token  string `json:"token"`
+}
+
+// UpdateAccountRequest is the input of UpdateAccount. Nil fields are left unchanged.
+type UpdateAccountRequest struct {
+	FirstName *string `json:"first_name,omitempty"`
+	LastName  *string `json:"last_name,omitempty"`
+}
+
+// Transaction is a single ledger entry of an account.
+type Transaction struct {
+	ID             int             `json:"id"`
+	AccountID      int             `json:"account_id"`
+	CounterpartyID int             `json:"counterparty_id"`
+	Type           string          `json:"type"`
+	Amount         int64           `json:"amount"`
+	BalanceAfter   int64           `json:"balance_after"`
+	Currency       string          `json:"currency"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Category       string          `json:"category,omitempty"`
+	Reference      string          `json:"reference,omitempty"`
+	Links          map[string]Link `json:"_links,omitempty"`
+}
+
+// Page describes the position of a page inside a list response.
+// Next is the URL of the following page and is empty on the last page.
+type Page struct {
+	Limit  int    `json:"limit"`
+	Offset *int   `json:"offset,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+	Total  *int   `json:"total,omitempty"`
+	Next   string `json:"next,omitempty"`
+}
+
+// TransactionPage is one page of a transaction listing.
+type TransactionPage struct {
+	Data []*Transaction `json:"data"`
+	Page Page           `json:"page"`
+}
+
+// NextCursor returns the cursor of the following page, or "" on the last page.
+func (p *TransactionPage) NextCursor() string {
+	next, err := url.Parse(p.Page.Next)
+	if err != nil {
+		return ""
+	}
+	return next.Query().Get("cursor")
+}
+
+// Transfer Statuses
+const (
+	TransferStatusPending    = "pending"
+	TransferStatusProcessing = "processing"
+	TransferStatusCompleted  = "completed"
+	TransferStatusFailed     = "failed"
+)
+
+// TransferRequest is the input of CreateTransfer.
+type TransferRequest struct {
+	FromAccountID int   `json:"from_account_id"`
+	ToAccountID   int   `json:"to_account_id"`
+	Amount        int64 `json:"amount"`
+	// Currency, when set, must be the currency of both accounts.
+	Currency string `json:"currency,omitempty"`
+}
+
+// TransferStateChange records when a transfer entered a state.
+type TransferStateChange struct {
+	Status string    `json:"status"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Transfer is a money movement between two accounts.
+type Transfer struct {
+	ID            int                   `json:"id"`
+	FromAccountID int                   `json:"from_account_id"`
+	ToAccountID   int                   `json:"to_account_id"`
+	Amount        int64                 `json:"amount"`
+	Currency      string                `json:"currency"`
+	Status        string                `json:"status"`
+	FailureReason string                `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time             `json:"created_at"`
+	UpdatedAt     time.Time             `json:"updated_at"`
+	History       []TransferStateChange `json:"history"`
+	Links         map[string]Link       `json:"_links,omitempty"`
+}
+
+// IsFinal reports whether the transfer reached a state it can no longer leave.
+func (t *Transfer) IsFinal() bool {
+	return t.Status == TransferStatusCompleted || t.Status == TransferStatusFailed
+}
@@ -0,0 +1,1162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moabdelazem/gobank/money"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultListenAddr is the address the API listens on unless LISTEN_ADDR or -addr say otherwise.
+const defaultListenAddr = ":8080"
+
+// Config is the configuration of the server. It is loaded by loadConfig from, in
+// increasing order of precedence, the defaults of defaultConfig, an optional YAML file,
+// the environment variables named by the env tags, and the command line flags.
+// config.example.yaml documents every setting with its default.
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Cache    CacheConfig    `yaml:"cache"`
+	Logging  LoggingConfig  `yaml:"logging"`
+	Jobs     JobsConfig     `yaml:"jobs"`
+	Backup   BackupConfig   `yaml:"backup"`
+
+	ErrorReporting ErrorReportingConfig `yaml:"error_reporting"`
+	FaultInjection FaultInjectionConfig `yaml:"fault_injection"`
+	Events         EventsConfig         `yaml:"events"`
+	RabbitMQ       RabbitMQConfig       `yaml:"rabbitmq"`
+	Notifications  NotificationsConfig  `yaml:"notifications"`
+	Gateway        GatewayConfig        `yaml:"gateway"`
+	Webhooks       WebhooksConfig       `yaml:"webhooks"`
+	Settlement     SettlementConfig     `yaml:"settlement"`
+	Stripe         StripeConfig         `yaml:"stripe"`
+	OpenBanking    OpenBankingConfig    `yaml:"open_banking"`
+	FX             FXConfig             `yaml:"fx"`
+	Documents      DocumentsConfig      `yaml:"documents"`
+	Alerts         AlertsConfig         `yaml:"alerts"`
+	KYC            KYCConfig            `yaml:"kyc"`
+	Accounts       AccountsConfig       `yaml:"accounts"`
+	Accounting     AccountingConfig     `yaml:"accounting"`
+	ISO20022       ISO20022Config       `yaml:"iso20022"`
+
+	// Features sets the feature flags, see features.go; FEATURE_FLAGS lists them as
+	// name=true,name=false. The overrides stored through /admin/features win over them.
+	Features map[string]bool `yaml:"features" env:"FEATURE_FLAGS"`
+}
+
+// ServerConfig configures the HTTP server and the request limits.
+type ServerConfig struct {
+	Addr              string        `yaml:"addr" env:"LISTEN_ADDR"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" env:"HTTP_MAX_HEADER_BYTES"`
+	// SlowRequestThreshold is the duration from which a request is logged as slow.
+	SlowRequestThreshold time.Duration `yaml:"slow_request_threshold" env:"HTTP_SLOW_THRESHOLD"`
+	// AsyncTransferThreshold is the amount from which transfers are processed in the background.
+	AsyncTransferThreshold int64 `yaml:"async_transfer_threshold" env:"ASYNC_TRANSFER_THRESHOLD"`
+	TransferWorkers        int   `yaml:"transfer_workers" env:"TRANSFER_WORKERS"`
+	TransferQueueSize      int   `yaml:"transfer_queue_size" env:"TRANSFER_QUEUE_SIZE"`
+	PageDefaultLimit       int   `yaml:"page_default_limit" env:"PAGE_DEFAULT_LIMIT"`
+	PageMaxLimit           int   `yaml:"page_max_limit" env:"PAGE_MAX_LIMIT"`
+	// V1Sunset is the date announced in the Sunset header of the /api/v1 responses.
+	V1Sunset time.Time `yaml:"v1_sunset" env:"API_V1_SUNSET"`
+}
+
+// DatabaseConfig selects the storage backend and configures its connections.
+type DatabaseConfig struct {
+	// Backend is "postgres" or "mongo".
+	Backend string `yaml:"backend" env:"STORAGE_BACKEND"`
+	// URL is the Postgres connection string; when empty it is built from the fields below, see ConnString.
+	URL      string `yaml:"url" env:"DB_URL"`
+	Host     string `yaml:"host" env:"DB_HOST"`
+	Port     int    `yaml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" env:"DB_USER"`
+	Password string `yaml:"password" env:"DB_PASSWORD"`
+	Name     string `yaml:"name" env:"DB_NAME"`
+	SSLMode  string `yaml:"sslmode" env:"DB_SSLMODE"`
+
+	MaxConns        int           `yaml:"max_conns" env:"DB_MAX_CONNS"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time" env:"DB_MAX_CONN_IDLE_TIME"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime" env:"DB_MAX_CONN_LIFETIME"`
+	// StatementTimeout cancels the statements running longer; 0 applies none.
+	StatementTimeout  time.Duration `yaml:"statement_timeout" env:"DB_STATEMENT_TIMEOUT"`
+	ConnectTimeout    time.Duration `yaml:"connect_timeout" env:"DB_CONNECT_TIMEOUT"`
+	ConnectBackoff    time.Duration `yaml:"connect_backoff" env:"DB_CONNECT_BACKOFF"`
+	ConnectMaxBackoff time.Duration `yaml:"connect_max_backoff" env:"DB_CONNECT_MAX_BACKOFF"`
+
+	// ReplicaURLs are the connection strings of the read replicas, comma separated in the environment.
+	ReplicaURLs       []string      `yaml:"replica_urls" env:"DB_REPLICA_URLS"`
+	ReplicaRetryAfter time.Duration `yaml:"replica_retry_after" env:"DB_REPLICA_RETRY_AFTER"`
+
+	HealthInterval time.Duration `yaml:"health_interval" env:"DB_HEALTH_INTERVAL"`
+	HealthTimeout  time.Duration `yaml:"health_timeout" env:"DB_HEALTH_TIMEOUT"`
+	SlowThreshold  time.Duration `yaml:"slow_threshold" env:"STORAGE_SLOW_THRESHOLD"`
+	// SlowQueryThreshold is the duration from which a single SQL statement is logged as slow.
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold" env:"DB_SLOW_QUERY_THRESHOLD"`
+
+	// RetryAttempts bounds the attempts of a call failing for a transient reason, see
+	// ResilientStorage, and of a transfer rolled back by a concurrent one, see retryTx.
+	RetryAttempts int           `yaml:"retry_attempts" env:"DB_RETRY_ATTEMPTS"`
+	RetryBackoff  time.Duration `yaml:"retry_backoff" env:"DB_RETRY_BACKOFF"`
+	// BreakerThreshold is the number of calls in a row failing to reach the database
+	// that opens the circuit breaker, for BreakerCooldown.
+	BreakerThreshold int           `yaml:"breaker_threshold" env:"DB_BREAKER_THRESHOLD"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown" env:"DB_BREAKER_COOLDOWN"`
+
+	MongoURL      string `yaml:"mongo_url" env:"MONGO_URL"`
+	MongoDatabase string `yaml:"mongo_database" env:"MONGO_DATABASE"`
+}
+
+// AuthConfig holds the secrets of the account tokens and the admin endpoints.
+type AuthConfig struct {
+	JWTSecret      string `yaml:"jwt_secret" env:"JWT_SECRET"`
+	JWTTokenExpire string `yaml:"jwt_token_expire" env:"JWT_TOKEN_EXPIRE"`
+	// AdminToken enables the /admin endpoints with full access; they are disabled when
+	// it is empty and no staff auth provider is configured.
+	AdminToken string `yaml:"admin_token" env:"ADMIN_TOKEN"`
+	// Staff authenticates the tellers and the admins against a corporate directory.
+	Staff StaffAuthConfig `yaml:"staff"`
+}
+
+// StaffAuthConfig configures the staff logins of POST /admin/login, see staffauth.go,
+// enabled by Provider.
+type StaffAuthConfig struct {
+	// Provider is the directory the staff authenticate against: ldap, or none when empty.
+	Provider string `yaml:"provider" env:"STAFF_AUTH_PROVIDER"`
+	// TokenExpiry is how long a staff token works, and so how long the roles it carries
+	// outlive a change of the groups of their holder.
+	TokenExpiry time.Duration `yaml:"token_expiry" env:"STAFF_TOKEN_EXPIRY"`
+	LDAP        LDAPConfig    `yaml:"ldap"`
+}
+
+// LDAPConfig configures the LDAP or Active Directory staff auth provider, see ldap.go.
+type LDAPConfig struct {
+	// URL is the directory, as ldaps://dc.example.com:636 or ldap://dc.example.com:389.
+	URL string `yaml:"url" env:"LDAP_URL"`
+	// StartTLS upgrades an ldap:// connection to TLS before binding.
+	StartTLS bool `yaml:"start_tls" env:"LDAP_START_TLS"`
+	// BindDN and BindPassword are the service account looking the users up; the search
+	// is anonymous when BindDN is empty.
+	BindDN       string `yaml:"bind_dn" env:"LDAP_BIND_DN"`
+	BindPassword string `yaml:"bind_password" env:"LDAP_BIND_PASSWORD"`
+	// BaseDN is the subtree the users are searched in.
+	BaseDN string `yaml:"base_dn" env:"LDAP_BASE_DN"`
+	// UserFilter finds a user by the username of the login, its %s.
+	UserFilter string `yaml:"user_filter" env:"LDAP_USER_FILTER"`
+	// GroupAttribute lists the groups of a user, memberOf for Active Directory.
+	GroupAttribute string `yaml:"group_attribute" env:"LDAP_GROUP_ATTRIBUTE"`
+	// GroupRoles maps a group, by DN or common name, to the staff role it grants;
+	// LDAP_GROUP_ROLES sets them as name=role,name=role, by common name.
+	GroupRoles map[string]string `yaml:"group_roles" env:"LDAP_GROUP_ROLES"`
+	// Timeout is how long connecting and every directory call may take.
+	Timeout time.Duration `yaml:"timeout" env:"LDAP_TIMEOUT"`
+}
+
+// CacheConfig configures the Redis account cache, enabled by RedisURL.
+type CacheConfig struct {
+	RedisURL   string        `yaml:"redis_url" env:"REDIS_URL"`
+	AccountTTL time.Duration `yaml:"account_ttl" env:"ACCOUNT_CACHE_TTL"`
+}
+
+// LoggingConfig configures the logger and the access log.
+type LoggingConfig struct {
+	// Level is debug, info, warn, or error.
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+	// Format is text or json.
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+	// Output is stderr, stdout, or the path of a file the logs are appended to.
+	Output    string          `yaml:"output" env:"LOG_OUTPUT"`
+	AccessLog AccessLogConfig `yaml:"access_log"`
+}
+
+// AccessLogConfig configures the access log, see withAccessLog.
+type AccessLogConfig struct {
+	// Exclude lists the paths left out of the access log; an empty ACCESS_LOG_EXCLUDE logs every path.
+	Exclude []string `yaml:"exclude" env:"ACCESS_LOG_EXCLUDE"`
+	// SampleAfter is the number of requests logged in full every second; 0 logs all of them.
+	SampleAfter int `yaml:"sample_after" env:"ACCESS_LOG_SAMPLE_AFTER"`
+	// SampleRate is the share of the requests past SampleAfter that are logged.
+	SampleRate float64 `yaml:"sample_rate" env:"ACCESS_LOG_SAMPLE_RATE"`
+}
+
+// JobsConfig configures the background jobs.
+type JobsConfig struct {
+	OutboxPollInterval           time.Duration `yaml:"outbox_poll_interval" env:"OUTBOX_POLL_INTERVAL"`
+	PartitionMaintenanceInterval time.Duration `yaml:"partition_maintenance_interval" env:"PARTITION_MAINTENANCE_INTERVAL"`
+	PartitionMonthsAhead         int           `yaml:"partition_months_ahead" env:"PARTITION_MONTHS_AHEAD"`
+	FeatureRefreshInterval       time.Duration `yaml:"feature_refresh_interval" env:"FEATURE_REFRESH_INTERVAL"`
+	// IdempotencySweepInterval is how often the expired idempotency keys are deleted, see idempotency.go.
+	IdempotencySweepInterval time.Duration `yaml:"idempotency_sweep_interval" env:"IDEMPOTENCY_SWEEP_INTERVAL"`
+	// SchedulerPollInterval is how often the scheduler looks for the jobs due, see scheduler.go.
+	SchedulerPollInterval time.Duration `yaml:"scheduler_poll_interval" env:"SCHEDULER_POLL_INTERVAL"`
+	// SchedulerLockTTL is how long an instance leases a job it runs, and the longest a run may take.
+	SchedulerLockTTL time.Duration `yaml:"scheduler_lock_ttl" env:"SCHEDULER_LOCK_TTL"`
+	// InterestAnnualRateBps is the yearly interest rate paid on the balances, in basis
+	// points; the interest accrual job is off when it is 0.
+	InterestAnnualRateBps int `yaml:"interest_annual_rate_bps" env:"INTEREST_ANNUAL_RATE_BPS"`
+	// DormancyAfter is how long an account goes without transactions before the dormancy
+	// check marks it dormant; the check is off when it is 0.
+	DormancyAfter time.Duration `yaml:"dormancy_after" env:"DORMANCY_AFTER"`
+	// OutboxRetention is how long the published outbox events are kept before the
+	// archival job moves them to the document storage; the job is off when it is 0.
+	OutboxRetention time.Duration `yaml:"outbox_retention" env:"OUTBOX_RETENTION"`
+}
+
+// BackupConfig configures "gobank backup" and "gobank restore".
+type BackupConfig struct {
+	// Passphrase encrypts and decrypts the backups.
+	Passphrase string `yaml:"passphrase" env:"BACKUP_PASSPHRASE"`
+}
+
+// ErrorReportingConfig configures the reports of the unexpected errors, enabled by DSN.
+type ErrorReportingConfig struct {
+	// DSN is the Sentry-compatible endpoint the reports are sent to.
+	DSN         string `yaml:"dsn" env:"SENTRY_DSN"`
+	Environment string `yaml:"environment" env:"SENTRY_ENVIRONMENT"`
+	// SampleRate is the share of the errors reported, between 0 (excluded) and 1.
+	SampleRate float64 `yaml:"sample_rate" env:"SENTRY_SAMPLE_RATE"`
+}
+
+// EventsConfig configures the external event bus the domain events are published to,
+// see EventPublisher.
+type EventsConfig struct {
+	// Transport is none, kafka, or nats.
+	Transport string      `yaml:"transport" env:"EVENT_TRANSPORT"`
+	Kafka     KafkaConfig `yaml:"kafka"`
+	NATS      NATSConfig  `yaml:"nats"`
+}
+
+// KafkaConfig configures the publishing of the domain events to Kafka.
+type KafkaConfig struct {
+	// Brokers are the addresses of the bootstrap brokers, comma separated in the environment.
+	Brokers []string `yaml:"brokers" env:"KAFKA_BROKERS"`
+	// TopicPrefix is prepended to the event type to name its topic.
+	TopicPrefix  string        `yaml:"topic_prefix" env:"KAFKA_TOPIC_PREFIX"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"KAFKA_WRITE_TIMEOUT"`
+}
+
+// NATSConfig configures the publishing of the domain events to NATS.
+type NATSConfig struct {
+	URL string `yaml:"url" env:"NATS_URL"`
+	// SubjectPrefix is prepended to the event type to name its subject.
+	SubjectPrefix  string        `yaml:"subject_prefix" env:"NATS_SUBJECT_PREFIX"`
+	PublishTimeout time.Duration `yaml:"publish_timeout" env:"NATS_PUBLISH_TIMEOUT"`
+	// JetStream publishes to the streams capturing the subjects, which acknowledge the events.
+	JetStream bool `yaml:"jetstream" env:"NATS_JETSTREAM"`
+}
+
+// RabbitMQConfig configures the RabbitMQ queue of the async transfers, enabled by URL,
+// see rabbitTransferQueue. Without it the transfers are queued in memory.
+type RabbitMQConfig struct {
+	URL string `yaml:"url" env:"RABBITMQ_URL"`
+	// TransferQueue is the name of the queue; the dead-lettered jobs go to TransferQueue.dead.
+	TransferQueue  string        `yaml:"transfer_queue" env:"RABBITMQ_TRANSFER_QUEUE"`
+	PublishTimeout time.Duration `yaml:"publish_timeout" env:"RABBITMQ_PUBLISH_TIMEOUT"`
+}
+
+// NotificationsConfig configures the notifications of the account holders, see notifier.
+type NotificationsConfig struct {
+	SMTP SMTPConfig `yaml:"smtp"`
+	SMS  SMSConfig  `yaml:"sms"`
+	Push PushConfig `yaml:"push"`
+}
+
+// SMTPConfig configures the SMTP server the notification emails are sent through,
+// enabled by Host. Without it no notification is sent.
+type SMTPConfig struct {
+	Host string `yaml:"host" env:"SMTP_HOST"`
+	Port int    `yaml:"port" env:"SMTP_PORT"`
+	// Username and Password authenticate with PLAIN, only over TLS or to localhost.
+	Username string `yaml:"username" env:"SMTP_USERNAME"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD"`
+	// From is the sender of the emails, such as "GoBank <no-reply@gobank.example>".
+	From    string        `yaml:"from" env:"SMTP_FROM"`
+	Timeout time.Duration `yaml:"timeout" env:"SMTP_TIMEOUT"`
+}
+
+// SMSConfig configures the SMS, sent through Twilio, enabled by Twilio.AccountSID.
+// Without it no SMS is sent and the phone numbers cannot be verified.
+type SMSConfig struct {
+	// RateLimit is how many SMS a phone number receives at most per RateWindow.
+	RateLimit  int           `yaml:"rate_limit" env:"SMS_RATE_LIMIT"`
+	RateWindow time.Duration `yaml:"rate_window" env:"SMS_RATE_WINDOW"`
+	// Countries enables or disables the SMS by calling code, such as 1=true,44=false in
+	// the environment; "*" sets the unlisted countries, which are enabled by default.
+	Countries map[string]bool `yaml:"countries" env:"SMS_COUNTRIES"`
+	Twilio    TwilioConfig    `yaml:"twilio"`
+}
+
+// TwilioConfig configures the Twilio account the SMS are sent with, see TwilioSender.
+type TwilioConfig struct {
+	AccountSID string `yaml:"account_sid" env:"TWILIO_ACCOUNT_SID"`
+	AuthToken  string `yaml:"auth_token" env:"TWILIO_AUTH_TOKEN"`
+	// From is the sending phone number, in E.164 format, or the SID of a messaging service.
+	From    string        `yaml:"from" env:"TWILIO_FROM"`
+	APIURL  string        `yaml:"api_url" env:"TWILIO_API_URL"`
+	Timeout time.Duration `yaml:"timeout" env:"TWILIO_TIMEOUT"`
+}
+
+// PushConfig configures the push notifications of the registered devices, through FCM
+// and APNs, each enabled by its credentials. Without them no device can be registered.
+type PushConfig struct {
+	FCM     FCMConfig     `yaml:"fcm"`
+	APNs    APNsConfig    `yaml:"apns"`
+	Timeout time.Duration `yaml:"timeout" env:"PUSH_TIMEOUT"`
+}
+
+// FCMConfig configures the Firebase project the pushes to its apps are sent with, see
+// FCMSender, enabled by CredentialsFile.
+type FCMConfig struct {
+	// CredentialsFile is the path of the JSON key file of a service account of the project.
+	CredentialsFile string `yaml:"credentials_file" env:"FCM_CREDENTIALS_FILE"`
+	// ProjectID overrides the project of the key file.
+	ProjectID string `yaml:"project_id" env:"FCM_PROJECT_ID"`
+	APIURL    string `yaml:"api_url" env:"FCM_API_URL"`
+}
+
+// APNsConfig configures the Apple developer team the pushes to its iOS app are sent
+// with, see APNsSender, enabled by KeyFile.
+type APNsConfig struct {
+	// KeyFile is the path of the .p8 signing key, of ID KeyID, of the team of ID TeamID.
+	KeyFile string `yaml:"key_file" env:"APNS_KEY_FILE"`
+	KeyID   string `yaml:"key_id" env:"APNS_KEY_ID"`
+	TeamID  string `yaml:"team_id" env:"APNS_TEAM_ID"`
+	// Topic is the bundle ID of the app.
+	Topic string `yaml:"topic" env:"APNS_TOPIC"`
+	// APIURL is the production API, or https://api.sandbox.push.apple.com for the
+	// development builds of the app.
+	APIURL string `yaml:"api_url" env:"APNS_API_URL"`
+}
+
+// GatewayConfig configures the callbacks of the payment gateway settling the external
+// transfers, see gateway.go, enabled by WebhookSecret or the stored webhook secrets:
+// without them or a settlement service no transfer can be sent with a provider reference.
+type GatewayConfig struct {
+	// WebhookSecret is the secret the gateway signs its callbacks with.
+	WebhookSecret string `yaml:"webhook_secret" env:"GATEWAY_WEBHOOK_SECRET"`
+	// WebhookTolerance is how far the signing time of a callback may be from now.
+	WebhookTolerance time.Duration `yaml:"webhook_tolerance" env:"GATEWAY_WEBHOOK_TOLERANCE"`
+}
+
+// WebhooksConfig configures the signing secrets of the inbound webhooks stored in the
+// database, see webhooks.go, enabled by EncryptionKey. The stored secrets of a provider
+// verify its webhooks next to the secret of its own settings, which may then be empty.
+type WebhooksConfig struct {
+	// EncryptionKey is the base64 AES-256 key the stored secrets are encrypted with, 32
+	// bytes, such as the output of openssl rand -base64 32.
+	EncryptionKey string `yaml:"encryption_key" env:"WEBHOOK_ENCRYPTION_KEY"`
+}
+
+// SettlementConfig configures the gRPC client of the settlement service the workers
+// settle the external transfers with, see settlement.go, enabled by Endpoint.
+type SettlementConfig struct {
+	// Endpoint is the host:port of the service.
+	Endpoint string `yaml:"endpoint" env:"SETTLEMENT_ENDPOINT"`
+	// CertFile and KeyFile are the PEM client certificate and key gobank authenticates
+	// with to the service.
+	CertFile string `yaml:"cert_file" env:"SETTLEMENT_CERT_FILE"`
+	KeyFile  string `yaml:"key_file" env:"SETTLEMENT_KEY_FILE"`
+	// CAFile is the PEM CA the certificate of the service is verified against, the
+	// system roots when empty.
+	CAFile string `yaml:"ca_file" env:"SETTLEMENT_CA_FILE"`
+	// ServerName is the name verified in the certificate of the service, the host of
+	// Endpoint when empty.
+	ServerName string `yaml:"server_name" env:"SETTLEMENT_SERVER_NAME"`
+	// Insecure connects without TLS, only for a local service.
+	Insecure bool `yaml:"insecure" env:"SETTLEMENT_INSECURE"`
+	// Timeout is how long one attempt may take.
+	Timeout time.Duration `yaml:"timeout" env:"SETTLEMENT_TIMEOUT"`
+	// RetryAttempts is how many times a transfer is sent before its outcome is given up
+	// as unknown, 1 disables the retries of the transient failures.
+	RetryAttempts int `yaml:"retry_attempts" env:"SETTLEMENT_RETRY_ATTEMPTS"`
+	// RetryBackoff is the delay before the first retry, doubled on every attempt.
+	RetryBackoff time.Duration `yaml:"retry_backoff" env:"SETTLEMENT_RETRY_BACKOFF"`
+}
+
+// StripeConfig configures the card top-ups paid through Stripe, see topups.go, enabled
+// by SecretKey.
+type StripeConfig struct {
+	// SecretKey authenticates the requests to the API of Stripe, sk_live_... or sk_test_....
+	SecretKey string `yaml:"secret_key" env:"STRIPE_SECRET_KEY"`
+	// WebhookSecret is the signing secret of the webhook endpoint, whsec_....
+	WebhookSecret string `yaml:"webhook_secret" env:"STRIPE_WEBHOOK_SECRET"`
+	// WebhookTolerance is how far the signing time of an event may be from now.
+	WebhookTolerance time.Duration `yaml:"webhook_tolerance" env:"STRIPE_WEBHOOK_TOLERANCE"`
+	// Currency is the lowercase ISO code the cards are charged in; only the accounts in
+	// that currency can be topped up.
+	Currency string `yaml:"currency" env:"STRIPE_CURRENCY"`
+	// MinAmount and MaxAmount bound the amount of one top-up, in the smallest unit of Currency.
+	MinAmount int64  `yaml:"min_amount" env:"STRIPE_MIN_AMOUNT"`
+	MaxAmount int64  `yaml:"max_amount" env:"STRIPE_MAX_AMOUNT"`
+	APIURL    string `yaml:"api_url" env:"STRIPE_API_URL"`
+	// Timeout is how long one request to Stripe may take.
+	Timeout time.Duration `yaml:"timeout" env:"STRIPE_TIMEOUT"`
+}
+
+// OpenBankingConfig configures the consents of the open banking API, see openbanking.go,
+// enabled per account by the open_banking feature.
+type OpenBankingConfig struct {
+	// MaxConsentLifetime is how long a consent may last before the account holder
+	// gives it again.
+	MaxConsentLifetime time.Duration `yaml:"max_consent_lifetime" env:"OPEN_BANKING_MAX_CONSENT_LIFETIME"`
+}
+
+// AlertsConfig configures the operational alerts posted to Slack, see alerts.go,
+// enabled by SlackWebhookURL or by a route.
+type AlertsConfig struct {
+	// SlackWebhookURL is the incoming webhook of the channel receiving the alerts of the
+	// types without a route.
+	SlackWebhookURL string `yaml:"slack_webhook_url" env:"SLACK_WEBHOOK_URL"`
+	// Routes sends the alerts of a type to another incoming webhook, or nowhere with off;
+	// ALERT_ROUTES sets them as type=url,type=off.
+	Routes map[string]string `yaml:"routes" env:"ALERT_ROUTES"`
+	// Timeout is how long posting one alert may take.
+	Timeout time.Duration `yaml:"timeout" env:"ALERTS_TIMEOUT"`
+}
+
+// KYCConfig configures the identity verification of the new account holders, see
+// kyc.go, enabled by Provider.
+type KYCConfig struct {
+	// Provider is the KYC provider checking the documents, onfido; none when empty.
+	Provider string `yaml:"provider" env:"KYC_PROVIDER"`
+	// PollInterval is how often the open checks are submitted, and polled when no
+	// webhook arrived for that long.
+	PollInterval time.Duration `yaml:"poll_interval" env:"KYC_POLL_INTERVAL"`
+	Onfido       OnfidoConfig  `yaml:"onfido"`
+}
+
+// OnfidoConfig configures the Onfido provider, see onfido.go.
+type OnfidoConfig struct {
+	// APIToken authenticates the requests to the API of Onfido.
+	APIToken string `yaml:"api_token" env:"ONFIDO_API_TOKEN"`
+	// WorkflowID is the Onfido Studio workflow run for every applicant, such as a
+	// document and selfie check.
+	WorkflowID string `yaml:"workflow_id" env:"ONFIDO_WORKFLOW_ID"`
+	// WebhookToken signs the webhook events of Onfido, in X-SHA2-Signature.
+	WebhookToken string `yaml:"webhook_token" env:"ONFIDO_WEBHOOK_TOKEN"`
+	// APIURL is the API of the region of the Onfido account, EU by default.
+	APIURL string `yaml:"api_url" env:"ONFIDO_API_URL"`
+	// Timeout is how long one request to Onfido may take.
+	Timeout time.Duration `yaml:"timeout" env:"ONFIDO_TIMEOUT"`
+}
+
+// AccountsConfig configures the accounts opened through the API.
+type AccountsConfig struct {
+	// DefaultCurrency is the uppercase ISO 4217 code of the accounts opened without one,
+	// such as USD. An account keeps the currency it was opened in.
+	DefaultCurrency string `yaml:"default_currency" env:"ACCOUNTS_DEFAULT_CURRENCY"`
+}
+
+// AccountingConfig configures the chart of accounts the ledger is booked against in
+// the journal export, see accounting.go. The names are those of the accounting system,
+// with a colon for a sub-account, such as Liabilities:Customer Deposits.
+type AccountingConfig struct {
+	// CustomerDepositsAccount is the liability account holding the balances of all the accounts.
+	CustomerDepositsAccount string `yaml:"customer_deposits_account" env:"ACCOUNTING_CUSTOMER_DEPOSITS_ACCOUNT"`
+	// CashAccount is the asset account funding the opening deposits.
+	CashAccount string `yaml:"cash_account" env:"ACCOUNTING_CASH_ACCOUNT"`
+	// CardClearingAccount is the asset account of the card top-ups until Stripe pays them out.
+	CardClearingAccount string `yaml:"card_clearing_account" env:"ACCOUNTING_CARD_CLEARING_ACCOUNT"`
+	// TransferClearingAccount books both sides of the transfers between accounts,
+	// netting to zero once both are exported.
+	TransferClearingAccount string `yaml:"transfer_clearing_account" env:"ACCOUNTING_TRANSFER_CLEARING_ACCOUNT"`
+	// SuspenseAccount books the entries of the other types, such as imported ones, for
+	// finance to reclassify.
+	SuspenseAccount string `yaml:"suspense_account" env:"ACCOUNTING_SUSPENSE_ACCOUNT"`
+	// InterestExpenseAccount is the expense account of the interest credited to the accounts.
+	InterestExpenseAccount string `yaml:"interest_expense_account" env:"ACCOUNTING_INTEREST_EXPENSE_ACCOUNT"`
+}
+
+// ISO20022Config configures the ISO 20022 messages of the bank, see iso20022.go: the
+// pain.001 payment initiation of the external transfers and the camt.053 statements,
+// enabled by BIC. The amounts are in the currency of their account, with the decimals
+// of its minor unit in ISO 4217.
+type ISO20022Config struct {
+	// BIC identifies the bank as the agent of its accounts, such as GOBKUS33 or GOBKUS33XXX.
+	BIC string `yaml:"bic" env:"ISO20022_BIC"`
+	// BankName names the bank as the initiating party and the servicer of the accounts.
+	BankName string `yaml:"bank_name" env:"ISO20022_BANK_NAME"`
+}
+
+// FXConfig configures the exchange rate provider, see fx.go, enabled by APIURL.
+type FXConfig struct {
+	// APIURL is the base URL of the Frankfurter API, or of a compatible one.
+	APIURL string `yaml:"api_url" env:"FX_API_URL"`
+	// RateTTL is how long the rates of a currency are served before being fetched again.
+	RateTTL time.Duration `yaml:"rate_ttl" env:"FX_RATE_TTL"`
+	// MaxStaleness is how old the last known rates may be, when served while the provider is down.
+	MaxStaleness time.Duration `yaml:"max_staleness" env:"FX_MAX_STALENESS"`
+	Timeout      time.Duration `yaml:"timeout" env:"FX_TIMEOUT"`
+}
+
+// DocumentsConfig configures the object storage keeping the statement PDFs and the
+// CSV exports, see documents.go, enabled by Bucket: without it they are streamed by
+// the API servers.
+type DocumentsConfig struct {
+	Bucket string `yaml:"bucket" env:"S3_BUCKET"`
+	// Endpoint is the host, and port, of the S3 API, such as localhost:9000 for MinIO.
+	Endpoint string `yaml:"endpoint" env:"S3_ENDPOINT"`
+	Region   string `yaml:"region" env:"S3_REGION"`
+	// AccessKeyID and SecretAccessKey are the static keys; without them the keys are
+	// taken from the AWS or MinIO environment variables, or from the IAM role.
+	AccessKeyID     string `yaml:"access_key_id" env:"S3_ACCESS_KEY_ID"`
+	SecretAccessKey string `yaml:"secret_access_key" env:"S3_SECRET_ACCESS_KEY"`
+	UseSSL          bool   `yaml:"use_ssl" env:"S3_USE_SSL"`
+	// PathStyle addresses the bucket in the path instead of the host name, as MinIO
+	// needs unless it is set up with a domain.
+	PathStyle bool `yaml:"path_style" env:"S3_PATH_STYLE"`
+	// URLExpiry is how long the presigned download links work.
+	URLExpiry time.Duration `yaml:"url_expiry" env:"DOCUMENTS_URL_EXPIRY"`
+	// Timeout is how long one request to the object storage may take.
+	Timeout time.Duration `yaml:"timeout" env:"DOCUMENTS_TIMEOUT"`
+}
+
+// FaultInjectionConfig configures the faults injected into the storage and cache calls
+// for resilience testing, see faults.go, enabled by Latency or ErrorRate.
+type FaultInjectionConfig struct {
+	// Latency delays the share LatencyRate of the calls.
+	Latency     time.Duration `yaml:"latency" env:"FAULT_LATENCY"`
+	LatencyRate float64       `yaml:"latency_rate" env:"FAULT_LATENCY_RATE"`
+	// ErrorRate is the share of the calls failing with an Error error.
+	ErrorRate float64 `yaml:"error_rate" env:"FAULT_ERROR_RATE"`
+	Error     string  `yaml:"error" env:"FAULT_ERROR"`
+	// Targets are the calls injected into: storage, cache, or both.
+	Targets []string `yaml:"targets" env:"FAULT_TARGETS"`
+}
+
+// defaultConfig returns the configuration used for every setting left unset.
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:                   defaultListenAddr,
+			ReadHeaderTimeout:      defaultHTTPReadHeaderTimeout,
+			ReadTimeout:            defaultHTTPReadTimeout,
+			WriteTimeout:           defaultHTTPWriteTimeout,
+			IdleTimeout:            defaultHTTPIdleTimeout,
+			MaxHeaderBytes:         defaultHTTPMaxHeaderBytes,
+			SlowRequestThreshold:   defaultSlowRequestThreshold,
+			AsyncTransferThreshold: defaultAsyncThreshold,
+			TransferWorkers:        defaultTransferWorkers,
+			TransferQueueSize:      defaultTransferQueueSize,
+			PageDefaultLimit:       defaultPageLimit,
+			PageMaxLimit:           maxPageLimit,
+			V1Sunset:               defaultV1Sunset,
+		},
+		Database: DatabaseConfig{
+			Backend:            storageBackendPostgres,
+			Host:               "localhost",
+			Port:               5432,
+			MaxConns:           defaultDBMaxConns,
+			MaxConnIdleTime:    defaultDBMaxConnIdleTime,
+			MaxConnLifetime:    defaultDBMaxConnLifetime,
+			ConnectTimeout:     defaultDBConnectTimeout,
+			ConnectBackoff:     defaultDBConnectBackoff,
+			ConnectMaxBackoff:  defaultDBConnectMaxBackoff,
+			ReplicaRetryAfter:  defaultReplicaRetryAfter,
+			HealthInterval:     defaultDBHealthInterval,
+			HealthTimeout:      defaultDBHealthTimeout,
+			SlowThreshold:      defaultStorageSlowThreshold,
+			SlowQueryThreshold: defaultSlowQueryThreshold,
+			RetryAttempts:      defaultDBRetryAttempts,
+			RetryBackoff:       defaultDBRetryBackoff,
+			BreakerThreshold:   defaultDBBreakerThreshold,
+			BreakerCooldown:    defaultDBBreakerCooldown,
+			MongoURL:           defaultMongoURL,
+			MongoDatabase:      defaultMongoDatabase,
+		},
+		Auth: AuthConfig{
+			Staff: StaffAuthConfig{
+				TokenExpiry: defaultStaffTokenExpiry,
+				LDAP: LDAPConfig{
+					UserFilter:     defaultLDAPUserFilter,
+					GroupAttribute: defaultLDAPGroupAttribute,
+					GroupRoles:     map[string]string{},
+					Timeout:        defaultLDAPTimeout,
+				},
+			},
+		},
+		Cache: CacheConfig{
+			AccountTTL: defaultAccountCacheTTL,
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: logFormatText,
+			Output: logOutputStderr,
+			AccessLog: AccessLogConfig{
+				Exclude:    []string{"/healthz", "/readyz", "/metrics"},
+				SampleRate: defaultAccessLogSampleRate,
+			},
+		},
+		Jobs: JobsConfig{
+			OutboxPollInterval:           defaultOutboxPollInterval,
+			PartitionMaintenanceInterval: defaultPartitionMaintenanceInterval,
+			PartitionMonthsAhead:         defaultPartitionMonthsAhead,
+			FeatureRefreshInterval:       defaultFeatureRefreshInterval,
+			IdempotencySweepInterval:     defaultIdempotencySweepInterval,
+			SchedulerPollInterval:        defaultSchedulerPollInterval,
+			SchedulerLockTTL:             defaultSchedulerLockTTL,
+			DormancyAfter:                defaultDormancyAfter,
+		},
+		ErrorReporting: ErrorReportingConfig{
+			Environment: "production",
+			SampleRate:  1,
+		},
+		FaultInjection: FaultInjectionConfig{
+			LatencyRate: 1,
+			Error:       faultUnavailable,
+			Targets:     []string{faultTargetStorage, faultTargetCache},
+		},
+		Events: EventsConfig{
+			Transport: eventTransportNone,
+			Kafka: KafkaConfig{
+				TopicPrefix:  defaultKafkaTopicPrefix,
+				WriteTimeout: defaultKafkaWriteTimeout,
+			},
+			NATS: NATSConfig{
+				URL:            defaultNATSURL,
+				SubjectPrefix:  defaultNATSSubjectPrefix,
+				PublishTimeout: defaultNATSPublishTimeout,
+			},
+		},
+		RabbitMQ: RabbitMQConfig{
+			TransferQueue:  defaultRabbitMQTransferQueue,
+			PublishTimeout: defaultRabbitMQPublishTimeout,
+		},
+		Notifications: NotificationsConfig{
+			SMTP: SMTPConfig{
+				Port:    defaultSMTPPort,
+				Timeout: defaultSMTPTimeout,
+			},
+			SMS: SMSConfig{
+				RateLimit:  defaultSMSRateLimit,
+				RateWindow: defaultSMSRateWindow,
+				Countries:  map[string]bool{},
+				Twilio: TwilioConfig{
+					APIURL:  defaultTwilioAPIURL,
+					Timeout: defaultTwilioTimeout,
+				},
+			},
+			Push: PushConfig{
+				FCM:     FCMConfig{APIURL: defaultFCMAPIURL},
+				APNs:    APNsConfig{APIURL: defaultAPNsAPIURL},
+				Timeout: defaultPushTimeout,
+			},
+		},
+		Gateway: GatewayConfig{
+			WebhookTolerance: defaultGatewayWebhookTolerance,
+		},
+		Settlement: SettlementConfig{
+			Timeout:       defaultSettlementTimeout,
+			RetryAttempts: defaultSettlementRetryAttempts,
+			RetryBackoff:  defaultSettlementRetryBackoff,
+		},
+		Stripe: StripeConfig{
+			WebhookTolerance: defaultStripeWebhookTolerance,
+			Currency:         defaultStripeCurrency,
+			MinAmount:        defaultStripeMinAmount,
+			MaxAmount:        defaultStripeMaxAmount,
+			APIURL:           defaultStripeAPIURL,
+			Timeout:          defaultStripeTimeout,
+		},
+		OpenBanking: OpenBankingConfig{
+			MaxConsentLifetime: defaultMaxConsentLifetime,
+		},
+		FX: FXConfig{
+			APIURL:       defaultFXAPIURL,
+			RateTTL:      defaultFXRateTTL,
+			MaxStaleness: defaultFXMaxStaleness,
+			Timeout:      defaultFXTimeout,
+		},
+		Documents: DocumentsConfig{
+			Endpoint:  defaultDocumentsEndpoint,
+			Region:    defaultDocumentsRegion,
+			UseSSL:    true,
+			URLExpiry: defaultDocumentsURLExpiry,
+			Timeout:   defaultDocumentsTimeout,
+		},
+		Alerts: AlertsConfig{
+			Routes:  map[string]string{},
+			Timeout: defaultAlertsTimeout,
+		},
+		Accounts: AccountsConfig{
+			DefaultCurrency: defaultAccountCurrency,
+		},
+		Accounting: AccountingConfig{
+			CustomerDepositsAccount: defaultCustomerDepositsAccount,
+			CashAccount:             defaultCashAccount,
+			CardClearingAccount:     defaultCardClearingAccount,
+			TransferClearingAccount: defaultTransferClearingAccount,
+			SuspenseAccount:         defaultSuspenseAccount,
+			InterestExpenseAccount:  defaultInterestExpenseAccount,
+		},
+		ISO20022: ISO20022Config{
+			BankName: defaultISO20022BankName,
+		},
+		KYC: KYCConfig{
+			PollInterval: defaultKYCPollInterval,
+			Onfido: OnfidoConfig{
+				APIURL:  defaultOnfidoAPIURL,
+				Timeout: defaultOnfidoTimeout,
+			},
+		},
+		Features: map[string]bool{},
+	}
+}
+
+// loadConfig loads the configuration: the defaults, overridden by the YAML file at path
+// when one is given, by the environment, and finally by override, which applies the
+// command line flags. The result is validated.
+//
+// Parameters:
+//   - path: The YAML file to read, empty for none.
+//   - override: Applies the settings given on the command line.
+//
+// Returns:
+//   - *Config: The configuration.
+//   - error: An error if the file cannot be read, a value cannot be parsed, or a setting is invalid.
+func loadConfig(path string, override func(*Config)) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading the config file: %w", err)
+		}
+
+		// Reject Unknown Keys, So A Typo Does Not Go Unnoticed
+		decoder := yaml.NewDecoder(bytes.NewReader(raw))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parsing the config file %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+
+	if override != nil {
+		override(cfg)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv sets the fields of the struct v from the environment variables named by their
+// env tags, recursing into the nested sections. Empty variables are ignored, except for
+// lists, which they clear.
+func applyEnv(v reflect.Value) error {
+	var errs []error
+
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+
+		if value.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}) {
+			errs = append(errs, applyEnv(value))
+			continue
+		}
+
+		name := field.Tag.Get("env")
+		raw, ok := os.LookupEnv(name)
+		if name == "" || !ok || (raw == "" && value.Kind() != reflect.Slice) {
+			continue
+		}
+
+		if err := setConfigValue(value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q: %w", name, raw, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// setConfigValue parses raw into a configuration field.
+func setConfigValue(value reflect.Value, raw string) error {
+	switch value.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	case time.Time:
+		t, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return fmt.Errorf("want a date such as 2026-12-31")
+		}
+		value.Set(reflect.ValueOf(t))
+		return nil
+	case []string:
+		list := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		value.Set(reflect.ValueOf(list))
+		return nil
+	case map[string]bool:
+		// Merge Into The Flags Of The File, A Name Alone Turns The Flag On
+		flags := value.Interface().(map[string]bool)
+		if flags == nil {
+			flags = map[string]bool{}
+			value.Set(reflect.ValueOf(flags))
+		}
+		for _, item := range strings.Split(raw, ",") {
+			name, setting, found := strings.Cut(strings.TrimSpace(item), "=")
+			if name == "" {
+				continue
+			}
+			enabled := true
+			if found {
+				var err error
+				if enabled, err = strconv.ParseBool(setting); err != nil {
+					return fmt.Errorf("want name=true or name=false for %s", name)
+				}
+			}
+			flags[name] = enabled
+		}
+		return nil
+	case map[string]string:
+		// Merge Into The Entries Of The File
+		entries := value.Interface().(map[string]string)
+		if entries == nil {
+			entries = map[string]string{}
+			value.Set(reflect.ValueOf(entries))
+		}
+		for _, item := range strings.Split(raw, ",") {
+			name, setting, found := strings.Cut(strings.TrimSpace(item), "=")
+			if name == "" {
+				continue
+			}
+			if !found {
+				return fmt.Errorf("want name=value for %s", name)
+			}
+			entries[name] = setting
+		}
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("want an integer")
+		}
+		value.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("want a number")
+		}
+		value.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("want true or false")
+		}
+		value.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", value.Type())
+	}
+	return nil
+}
+
+// Validate checks that every setting is usable, reporting all the invalid ones at once.
+// Whether a database is configured is only checked when it is connected to, see
+// ConnString, so the demo mode runs without one.
+//
+// Returns:
+//   - error: The invalid settings, or nil.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr must not be empty")
+	for name, d := range map[string]time.Duration{
+		"server.read_header_timeout":          c.Server.ReadHeaderTimeout,
+		"server.read_timeout":                 c.Server.ReadTimeout,
+		"server.write_timeout":                c.Server.WriteTimeout,
+		"server.idle_timeout":                 c.Server.IdleTimeout,
+		"server.slow_request_threshold":       c.Server.SlowRequestThreshold,
+		"database.max_conn_idle_time":         c.Database.MaxConnIdleTime,
+		"database.max_conn_lifetime":          c.Database.MaxConnLifetime,
+		"database.connect_timeout":            c.Database.ConnectTimeout,
+		"database.connect_backoff":            c.Database.ConnectBackoff,
+		"database.connect_max_backoff":        c.Database.ConnectMaxBackoff,
+		"database.replica_retry_after":        c.Database.ReplicaRetryAfter,
+		"database.health_interval":            c.Database.HealthInterval,
+		"database.health_timeout":             c.Database.HealthTimeout,
+		"database.slow_threshold":             c.Database.SlowThreshold,
+		"database.slow_query_threshold":       c.Database.SlowQueryThreshold,
+		"database.retry_backoff":              c.Database.RetryBackoff,
+		"database.breaker_cooldown":           c.Database.BreakerCooldown,
+		"cache.account_ttl":                   c.Cache.AccountTTL,
+		"jobs.outbox_poll_interval":           c.Jobs.OutboxPollInterval,
+		"jobs.partition_maintenance_interval": c.Jobs.PartitionMaintenanceInterval,
+		"jobs.feature_refresh_interval":       c.Jobs.FeatureRefreshInterval,
+		"jobs.idempotency_sweep_interval":     c.Jobs.IdempotencySweepInterval,
+		"jobs.scheduler_poll_interval":        c.Jobs.SchedulerPollInterval,
+		"jobs.scheduler_lock_ttl":             c.Jobs.SchedulerLockTTL,
+		"events.kafka.write_timeout":          c.Events.Kafka.WriteTimeout,
+		"events.nats.publish_timeout":         c.Events.NATS.PublishTimeout,
+		"rabbitmq.publish_timeout":            c.RabbitMQ.PublishTimeout,
+		"notifications.smtp.timeout":          c.Notifications.SMTP.Timeout,
+		"notifications.sms.rate_window":       c.Notifications.SMS.RateWindow,
+		"notifications.sms.twilio.timeout":    c.Notifications.SMS.Twilio.Timeout,
+		"notifications.push.timeout":          c.Notifications.Push.Timeout,
+		"gateway.webhook_tolerance":           c.Gateway.WebhookTolerance,
+		"settlement.timeout":                  c.Settlement.Timeout,
+		"settlement.retry_backoff":            c.Settlement.RetryBackoff,
+		"stripe.webhook_tolerance":            c.Stripe.WebhookTolerance,
+		"stripe.timeout":                      c.Stripe.Timeout,
+		"open_banking.max_consent_lifetime":   c.OpenBanking.MaxConsentLifetime,
+		"fx.rate_ttl":                         c.FX.RateTTL,
+		"fx.max_staleness":                    c.FX.MaxStaleness,
+		"fx.timeout":                          c.FX.Timeout,
+		"documents.url_expiry":                c.Documents.URLExpiry,
+		"documents.timeout":                   c.Documents.Timeout,
+		"alerts.timeout":                      c.Alerts.Timeout,
+		"kyc.poll_interval":                   c.KYC.PollInterval,
+		"kyc.onfido.timeout":                  c.KYC.Onfido.Timeout,
+		"auth.staff.token_expiry":             c.Auth.Staff.TokenExpiry,
+		"auth.staff.ldap.timeout":             c.Auth.Staff.LDAP.Timeout,
+	} {
+		check(d > 0, "%s must be positive", name)
+	}
+	for name, n := range map[string]int64{
+		"server.max_header_bytes":         int64(c.Server.MaxHeaderBytes),
+		"server.async_transfer_threshold": c.Server.AsyncTransferThreshold,
+		"server.transfer_workers":         int64(c.Server.TransferWorkers),
+		"server.transfer_queue_size":      int64(c.Server.TransferQueueSize),
+		"server.page_default_limit":       int64(c.Server.PageDefaultLimit),
+		"server.page_max_limit":           int64(c.Server.PageMaxLimit),
+		"database.max_conns":              int64(c.Database.MaxConns),
+		"database.retry_attempts":         int64(c.Database.RetryAttempts),
+		"database.breaker_threshold":      int64(c.Database.BreakerThreshold),
+		"settlement.retry_attempts":       int64(c.Settlement.RetryAttempts),
+		"jobs.partition_months_ahead":     int64(c.Jobs.PartitionMonthsAhead),
+		"notifications.sms.rate_limit":    int64(c.Notifications.SMS.RateLimit),
+	} {
+		check(n > 0, "%s must be positive", name)
+	}
+	check(c.Server.PageDefaultLimit <= c.Server.PageMaxLimit, "server.page_default_limit must not exceed server.page_max_limit")
+	check(c.Jobs.InterestAnnualRateBps >= 0 && c.Jobs.InterestAnnualRateBps <= maxInterestAnnualRateBps, "jobs.interest_annual_rate_bps must be between 0 and %d", maxInterestAnnualRateBps)
+	check(c.Jobs.DormancyAfter >= 0, "jobs.dormancy_after must not be negative")
+	check(c.Jobs.OutboxRetention >= 0, "jobs.outbox_retention must not be negative")
+
+	check(c.Database.Backend == storageBackendPostgres || c.Database.Backend == storageBackendMongo,
+		"database.backend must be %q or %q", storageBackendPostgres, storageBackendMongo)
+	check(c.Database.Port > 0 && c.Database.Port < 1<<16, "database.port must be a port number")
+	check(c.Database.StatementTimeout >= 0, "database.statement_timeout must not be negative")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Logging.Level)) == nil, "logging.level must be debug, info, warn, or error")
+	check(c.Logging.Format == logFormatText || c.Logging.Format == logFormatJSON, "logging.format must be %q or %q", logFormatText, logFormatJSON)
+	check(c.Logging.Output != "", "logging.output must be %q, %q, or the path of a file", logOutputStderr, logOutputStdout)
+	check(c.Logging.AccessLog.SampleAfter >= 0, "logging.access_log.sample_after must not be negative")
+	check(c.Logging.AccessLog.SampleRate >= 0 && c.Logging.AccessLog.SampleRate <= 1, "logging.access_log.sample_rate must be between 0 and 1")
+
+	check(c.ErrorReporting.SampleRate > 0 && c.ErrorReporting.SampleRate <= 1, "error_reporting.sample_rate must be above 0 and at most 1")
+
+	check(c.FaultInjection.Latency >= 0, "fault_injection.latency must not be negative")
+	check(c.FaultInjection.LatencyRate >= 0 && c.FaultInjection.LatencyRate <= 1, "fault_injection.latency_rate must be between 0 and 1")
+	check(c.FaultInjection.ErrorRate >= 0 && c.FaultInjection.ErrorRate <= 1, "fault_injection.error_rate must be between 0 and 1")
+	check(slices.Contains(faultErrorKinds, c.FaultInjection.Error), "fault_injection.error must be one of %s", strings.Join(faultErrorKinds, ", "))
+	for _, target := range c.FaultInjection.Targets {
+		check(slices.Contains(faultTargets, target), "fault_injection.targets: unknown target %q, known targets are %s", target, strings.Join(faultTargets, ", "))
+	}
+
+	check(slices.Contains(eventTransports, c.Events.Transport), "events.transport must be one of %s", strings.Join(eventTransports, ", "))
+	check(c.Events.Transport != eventTransportKafka || len(c.Events.Kafka.Brokers) > 0, "events.kafka.brokers must not be empty with the kafka transport")
+	check(c.Events.Transport != eventTransportNATS || c.Events.NATS.URL != "", "events.nats.url must not be empty with the nats transport")
+
+	check(c.RabbitMQ.TransferQueue != "", "rabbitmq.transfer_queue must not be empty")
+
+	if c.Notifications.SMTP.Host != "" {
+		check(c.Notifications.SMTP.Port > 0 && c.Notifications.SMTP.Port < 1<<16, "notifications.smtp.port must be a port number")
+		_, err := mail.ParseAddress(c.Notifications.SMTP.From)
+		check(err == nil, "notifications.smtp.from must be an email address, such as \"GoBank <no-reply@gobank.example>\"")
+	}
+	for code := range c.Notifications.SMS.Countries {
+		valid := code == smsCountryUnlisted || (len(code) <= smsCallingCodeMaxDigits && code != "" && strings.Trim(code, "0123456789") == "" && code[0] != '0')
+		check(valid, "notifications.sms.countries: %q is not a calling code, such as 1 or 44, nor %q", code, smsCountryUnlisted)
+	}
+	if c.Notifications.SMS.Twilio.AccountSID != "" {
+		check(c.Notifications.SMS.Twilio.AuthToken != "", "notifications.sms.twilio.auth_token must not be empty with an account SID")
+		check(c.Notifications.SMS.Twilio.From != "", "notifications.sms.twilio.from must not be empty with an account SID")
+		_, err := url.ParseRequestURI(c.Notifications.SMS.Twilio.APIURL)
+		check(err == nil, "notifications.sms.twilio.api_url must be a URL")
+	}
+	if c.Notifications.Push.FCM.CredentialsFile != "" {
+		_, err := url.ParseRequestURI(c.Notifications.Push.FCM.APIURL)
+		check(err == nil, "notifications.push.fcm.api_url must be a URL")
+	}
+	if c.Notifications.Push.APNs.KeyFile != "" {
+		check(c.Notifications.Push.APNs.KeyID != "", "notifications.push.apns.key_id must not be empty with a key file")
+		check(c.Notifications.Push.APNs.TeamID != "", "notifications.push.apns.team_id must not be empty with a key file")
+		check(c.Notifications.Push.APNs.Topic != "", "notifications.push.apns.topic must not be empty with a key file")
+		_, err := url.ParseRequestURI(c.Notifications.Push.APNs.APIURL)
+		check(err == nil, "notifications.push.apns.api_url must be a URL")
+	}
+
+	if c.Stripe.SecretKey != "" {
+		check(c.Stripe.WebhookSecret != "" || c.Webhooks.EncryptionKey != "", "stripe.webhook_secret must not be empty with a secret key, the top-ups are credited by the webhook, unless its secrets are stored with webhooks.encryption_key")
+		check(c.Stripe.Currency == strings.ToLower(c.Stripe.Currency) && money.IsCurrencyCode(strings.ToUpper(c.Stripe.Currency)), "stripe.currency must be a lowercase ISO 4217 currency code, such as usd")
+		check(c.Stripe.MinAmount > 0, "stripe.min_amount must be positive")
+		check(c.Stripe.MaxAmount >= c.Stripe.MinAmount, "stripe.max_amount must not be below stripe.min_amount")
+		_, err := url.ParseRequestURI(c.Stripe.APIURL)
+		check(err == nil, "stripe.api_url must be a URL")
+	}
+
+	if c.FX.APIURL != "" {
+		_, err := url.ParseRequestURI(c.FX.APIURL)
+		check(err == nil, "fx.api_url must be a URL")
+		check(c.FX.MaxStaleness >= c.FX.RateTTL, "fx.max_staleness must not be shorter than fx.rate_ttl")
+	}
+
+	if c.Webhooks.EncryptionKey != "" {
+		_, err := parseWebhookEncryptionKey(c.Webhooks.EncryptionKey)
+		check(err == nil, "webhooks.encryption_key must be the base64 of %d random bytes: %v", webhookEncryptionKeySize, err)
+	}
+
+	if settlement := c.Settlement; settlement.Endpoint != "" {
+		_, _, err := net.SplitHostPort(settlement.Endpoint)
+		check(err == nil, "settlement.endpoint must be a host:port, without a scheme")
+		check((settlement.CertFile == "") == (settlement.KeyFile == ""), "settlement.cert_file and settlement.key_file must be set together")
+		if settlement.Insecure {
+			check(settlement.CertFile == "" && settlement.CAFile == "", "settlement.insecure connects without TLS, the certificate files would be ignored")
+		} else {
+			check(settlement.CertFile != "", "settlement.cert_file must not be empty, the settlement service authenticates gobank with its client certificate; settlement.insecure is for a local service")
+		}
+	}
+
+	if c.Documents.Bucket != "" {
+		check(c.Documents.Endpoint != "" && !strings.Contains(c.Documents.Endpoint, "/"), "documents.endpoint must be a host, such as s3.amazonaws.com or localhost:9000, without a scheme")
+		check(c.Documents.URLExpiry <= documentsMaxURLExpiry, "documents.url_expiry must be at most %s", documentsMaxURLExpiry)
+		check((c.Documents.AccessKeyID == "") == (c.Documents.SecretAccessKey == ""), "documents.access_key_id and documents.secret_access_key must be set together")
+	}
+
+	if c.Auth.Staff.Provider != "" {
+		check(slices.Contains(staffAuthProviders, c.Auth.Staff.Provider), "auth.staff.provider must be empty or one of %s", strings.Join(staffAuthProviders, ", "))
+		check(c.Auth.JWTSecret != "", "auth.jwt_secret must not be empty with a staff auth provider, it signs the staff tokens")
+	}
+	if ldapCfg := c.Auth.Staff.LDAP; c.Auth.Staff.Provider == staffAuthProviderLDAP {
+		parsed, err := url.Parse(ldapCfg.URL)
+		check(err == nil && parsed.Host != "" && (parsed.Scheme == "ldap" || parsed.Scheme == "ldaps"), "auth.staff.ldap.url must be an ldap:// or ldaps:// URL")
+		check(!ldapCfg.StartTLS || parsed == nil || parsed.Scheme == "ldap", "auth.staff.ldap.start_tls needs an ldap:// URL, ldaps:// is encrypted already")
+		check(ldapCfg.BaseDN != "", "auth.staff.ldap.base_dn must not be empty with the ldap provider")
+		check(strings.Count(ldapCfg.UserFilter, "%s") == 1 && strings.Count(ldapCfg.UserFilter, "%") == 1, "auth.staff.ldap.user_filter must hold the username as one %%s, such as (uid=%%s)")
+		check(ldapCfg.GroupAttribute != "", "auth.staff.ldap.group_attribute must not be empty with the ldap provider")
+		check(len(ldapCfg.GroupRoles) > 0, "auth.staff.ldap.group_roles must map a group to a role, or no staff member can log in")
+		for group, role := range ldapCfg.GroupRoles {
+			check(slices.Contains(staffRoles, role), "auth.staff.ldap.group_roles: group %q maps to unknown role %q, known roles are %s", group, role, strings.Join(staffRoles, ", "))
+		}
+	}
+
+	if c.KYC.Provider != "" {
+		check(slices.Contains(kycProviders, c.KYC.Provider), "kyc.provider must be empty or one of %s", strings.Join(kycProviders, ", "))
+	}
+	if onfido := c.KYC.Onfido; c.KYC.Provider == kycProviderOnfido {
+		check(onfido.APIToken != "", "kyc.onfido.api_token must not be empty with the onfido provider")
+		check(onfido.WorkflowID != "", "kyc.onfido.workflow_id must not be empty with the onfido provider")
+		check(onfido.WebhookToken != "" || c.Webhooks.EncryptionKey != "", "kyc.onfido.webhook_token must not be empty with the onfido provider, the results are delivered by the webhook, unless its secrets are stored with webhooks.encryption_key")
+		_, err := url.ParseRequestURI(onfido.APIURL)
+		check(err == nil, "kyc.onfido.api_url must be a URL")
+	}
+
+	for name, account := range map[string]string{
+		"accounting.customer_deposits_account": c.Accounting.CustomerDepositsAccount,
+		"accounting.cash_account":              c.Accounting.CashAccount,
+		"accounting.card_clearing_account":     c.Accounting.CardClearingAccount,
+		"accounting.transfer_clearing_account": c.Accounting.TransferClearingAccount,
+		"accounting.suspense_account":          c.Accounting.SuspenseAccount,
+		"accounting.interest_expense_account":  c.Accounting.InterestExpenseAccount,
+	} {
+		check(strings.TrimSpace(account) != "" && !strings.ContainsAny(account, "\t\r\n\""), "%s must be an account name, without tabs, line breaks, or quotes", name)
+	}
+	check(money.IsCurrencyCode(c.Accounts.DefaultCurrency), "accounts.default_currency must be an uppercase ISO 4217 currency code, such as USD")
+
+	if iso := c.ISO20022; iso.BIC != "" {
+		check(bicPattern.MatchString(iso.BIC), "iso20022.bic must be a BIC of 8 or 11 uppercase characters, such as GOBKUS33")
+		check(strings.TrimSpace(iso.BankName) != "" && len(iso.BankName) <= 140, "iso20022.bank_name must not be empty, and be at most 140 characters")
+	}
+
+	if c.Alerts.SlackWebhookURL != "" {
+		_, err := url.ParseRequestURI(c.Alerts.SlackWebhookURL)
+		check(err == nil, "alerts.slack_webhook_url must be a URL")
+	}
+	for alertType, route := range c.Alerts.Routes {
+		check(slices.Contains(alertTypes, alertType), "alerts.routes: unknown alert type %q, known types are %s", alertType, strings.Join(alertTypes, ", "))
+		if route != alertRouteOff {
+			_, err := url.ParseRequestURI(route)
+			check(err == nil, "alerts.routes.%s must be a URL or %s", alertType, alertRouteOff)
+		}
+	}
+
+	for name := range c.Features {
+		check(slices.Contains(knownFeatures, name), "features: unknown flag %q, known flags are %s", name, strings.Join(knownFeatures, ", "))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ConnString returns the connection string of the primary Postgres database: URL when
+// set, otherwise a URL built from Host, Port, User, Password, Name, and SSLMode.
+//
+// Returns:
+//   - string: The connection string.
+//   - error: An error if neither URL nor Name is configured.
+func (c *DatabaseConfig) ConnString() (string, error) {
+	if c.URL != "" {
+		return c.URL, nil
+	}
+	if c.Name == "" {
+		return "", fmt.Errorf("no database configured, set DB_URL or DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, and DB_NAME")
+	}
+
+	u := &url.URL{
+		Scheme: "postgres",
+		Host:   net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:   "/" + c.Name,
+	}
+	if c.User != "" {
+		if c.Password != "" {
+			u.User = url.UserPassword(c.User, c.Password)
+		} else {
+			u.User = url.User(c.User)
+		}
+	}
+	if c.SSLMode != "" {
+		u.RawQuery = url.Values{"sslmode": {c.SSLMode}}.Encode()
+	}
+
+	return u.String(), nil
+}
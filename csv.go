@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvContentType is the media type of the CSV transaction export.
+const csvContentType = "text/csv"
+
+// transactionCSVHeader is the header row of the CSV transaction export.
+var transactionCSVHeader = []string{"id", "account_id", "counterparty_id", "type", "amount", "balance_after", "created_at", "category", "currency"}
+
+// handleExportTransactionsCSV streams the transaction history of an account as CSV.
+// The rows can be limited with the "from" and "to" query parameters, given either as
+// a date (2024-01-31, "to" is inclusive of the whole day) or as an RFC 3339 timestamp.
+// With a document storage, the export is stored there and the client is redirected to
+// it with a 303 See Other.
+//
+// Parameters:
+//   - w: http.ResponseWriter to stream the CSV rows to.
+//   - r: *http.Request containing the account ID and the optional date range.
+//
+// Returns:
+//   - error: An error if the date range is invalid or the transactions cannot be read
+//     before the first row is written.
+func (as *APIServer) handleExportTransactionsCSV(w http.ResponseWriter, r *http.Request) error {
+	from, err := parseCSVTime(r.URL.Query().Get("from"), false)
+	if err != nil {
+		return fmt.Errorf("invalid from: %s", err)
+	}
+
+	to, err := parseCSVTime(r.URL.Query().Get("to"), true)
+	if err != nil {
+		return fmt.Errorf("invalid to: %s", err)
+	}
+
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return fmt.Errorf("from must be before to")
+	}
+
+	// Get The ID From The URL
+	id := getId(w, r)
+	disposition := fmt.Sprintf("attachment; filename=\"account-%d-transactions.csv\"", id)
+
+	if as.documents != nil {
+		// Every Export Gets Its Own Key, The History Keeps Growing
+		key := fmt.Sprintf("exports/%d/transactions-%s-%s.csv", id, time.Now().UTC().Format("20060102T150405Z"), newRequestID())
+		doc := DocumentInfo{ContentType: csvContentType + "; charset=utf-8", ContentDisposition: disposition}
+
+		link, err := as.storeDocument(r.Context(), key, doc, false, func(dst io.Writer) error {
+			return as.writeTransactionsCSV(r.Context(), csv.NewWriter(dst), id, from, to, func() {})
+		})
+		if err != nil {
+			return err
+		}
+		return redirectToDocument(w, r, link)
+	}
+
+	started := false
+
+	// Write The Headers Lazily So Early Errors Can Still Be Reported As JSON
+	err = as.writeTransactionsCSV(r.Context(), csv.NewWriter(w), id, from, to, func() {
+		started = true
+		w.Header().Set("Content-Type", csvContentType+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", disposition)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if err != nil && !started {
+		return err
+	}
+
+	return nil
+}
+
+// writeTransactionsCSV writes the header row and the transactions of an account in the
+// range [from, to) to cw, flushing it at the end.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - cw: The CSV writer of the export.
+//   - id: The ID of the account.
+//   - from: The first time included, zero for no bound.
+//   - to: The first time excluded, zero for no bound.
+//   - begin: Called before the header row, once no error can happen before the first row.
+//
+// Returns:
+//   - error: An error if the transactions cannot be read or written.
+func (as *APIServer) writeTransactionsCSV(ctx context.Context, cw *csv.Writer, id int, from, to time.Time, begin func()) error {
+	started := false
+	start := func() error {
+		started = true
+		begin()
+		return cw.Write(transactionCSVHeader)
+	}
+
+	err := as.store.StreamTransactions(ctx, id, from, to, func(t *Transaction) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		return cw.Write([]string{
+			strconv.Itoa(t.ID),
+			strconv.Itoa(t.AccountID),
+			strconv.Itoa(t.CounterpartyID),
+			t.Type,
+			strconv.FormatInt(t.Amount.Amount, 10),
+			strconv.FormatInt(t.BalanceAfter.Amount, 10),
+			t.CreatedAt.UTC().Format(time.RFC3339),
+			t.Category,
+			t.Currency,
+		})
+	})
+	if err != nil {
+		// Send The Rows Written So Far, The Export Is Cut Short
+		cw.Flush()
+		return err
+	}
+
+	// An Empty History Still Gets A Header Row
+	if !started {
+		if err := start(); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseCSVTime parses a date range bound given as a date or an RFC 3339 timestamp.
+// Empty values yield the zero time (no bound). When endOfDay is set a plain date is
+// moved to the start of the next day so that it can be used as an exclusive upper bound.
+func parseCSVTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// prefersCSV reports whether the Accept header ranks text/csv above every other media type.
+func prefersCSV(r *http.Request) bool {
+	best, bestQ := "", 0.0
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, q := parseAcceptPart(part)
+		if q > bestQ {
+			best, bestQ = mediaType, q
+		}
+	}
+
+	return best == csvContentType
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TransactionCursor is the keyset position of a transaction in a newest-first listing.
+type TransactionCursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// Encode returns the opaque string form of the cursor handed out to clients.
+func (c *TransactionCursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeTransactionCursor parses a cursor previously returned by Encode.
+func decodeTransactionCursor(value string) (*TransactionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	txID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &TransactionCursor{CreatedAt: time.Unix(0, unixNano).UTC(), ID: txID}, nil
+}
+
+// handleListTransactionsV2 returns a cursor paginated page of the transactions of an
+// account, newest first. The page is selected with the "limit" and "cursor" query
+// parameters; the next page is requested by following page.next of the previous response.
+// The filter query parameters described at parseTransactionFilter narrow the listing.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the account ID and the pagination query parameters.
+//
+// Returns:
+//   - error: A TypedError if the pagination parameters are invalid or the transactions cannot be retrieved.
+func (as *APIServer) handleListTransactionsV2(w http.ResponseWriter, r *http.Request) error {
+	if prefersCSV(r) {
+		return as.handleExportTransactionsCSV(w, r)
+	}
+
+	limit, _, err := as.parsePagination(r)
+	if err != nil {
+		return err
+	}
+
+	filter, err := parseTransactionFilter(r)
+	if err != nil {
+		return err
+	}
+
+	var after *TransactionCursor
+	cursor := r.URL.Query().Get("cursor")
+	if cursor != "" {
+		if after, err = decodeTransactionCursor(cursor); err != nil {
+			return NewTypedError(http.StatusBadRequest, "invalid_cursor", err.Error())
+		}
+	}
+
+	// Get The ID From The URL
+	id := getId(w, r)
+
+	// Fetch One Extra Row To Know Whether Another Page Exists
+	txns, err := as.store.GetTransactionsPage(r.Context(), id, filter, after, limit+1)
+	if err != nil {
+		return NewTypedError(http.StatusInternalServerError, "internal_error", "could not list transactions")
+	}
+
+	var nextCursor string
+	if len(txns) > limit {
+		txns = txns[:limit]
+		last := txns[len(txns)-1]
+		nextCursor = (&TransactionCursor{CreatedAt: last.CreatedAt, ID: last.ID}).Encode()
+	}
+
+	return WriteResponse(w, r, http.StatusOK, PageEnvelope{
+		Data: newTransactionResources(r, txns),
+		Page: newCursorPage(r, limit, cursor, nextCursor),
+	})
+}
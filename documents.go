@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Default Document Storage Settings
+const (
+	defaultDocumentsEndpoint  = "s3.amazonaws.com"
+	defaultDocumentsRegion    = "us-east-1"
+	defaultDocumentsURLExpiry = 15 * time.Minute
+	defaultDocumentsTimeout   = 30 * time.Second
+	// documentPartSize is the part size of the uploads of unknown size, such as the CSV
+	// exports, the smallest S3 accepts; the documents below it are uploaded at once.
+	documentPartSize = 5 << 20
+	// documentsMaxURLExpiry is the longest lifetime S3 accepts for a presigned URL.
+	documentsMaxURLExpiry = 7 * 24 * time.Hour
+)
+
+// DocumentStore keeps the generated documents, the statement PDFs and the CSV exports,
+// and hands out time-limited links to download them, so that the clients download
+// them from the store instead of from the API servers.
+type DocumentStore interface {
+	// Exists reports whether a document is stored under key.
+	Exists(ctx context.Context, key string) (bool, error)
+	// Put stores the document read from r under key; size is -1 when unknown.
+	Put(ctx context.Context, key string, doc DocumentInfo, r io.Reader, size int64) error
+	// URL returns a link downloading the document of key, for a limited time.
+	URL(ctx context.Context, key string) (*DocumentLink, error)
+}
+
+// DocumentInfo describes a document to its downloaders.
+type DocumentInfo struct {
+	ContentType string
+	// ContentDisposition names the file downloaded, such as attachment; filename="a.csv".
+	ContentDisposition string
+}
+
+// DocumentLink is a presigned link downloading a stored document.
+type DocumentLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// S3DocumentStore keeps the documents in a bucket of Amazon S3 or of an S3-compatible
+// object storage, such as MinIO.
+type S3DocumentStore struct {
+	client  *minio.Client
+	bucket  string
+	expiry  time.Duration
+	timeout time.Duration
+}
+
+// NewS3DocumentStore creates a store for the bucket of cfg. Without static keys, the
+// credentials are taken from the AWS or MinIO environment variables, or from the IAM
+// role of the instance.
+//
+// Parameters:
+//   - cfg: The document storage settings, with the endpoint and the bucket.
+//
+// Returns:
+//   - *S3DocumentStore: The store; the bucket is not checked until the first document.
+//   - error: An error if the endpoint is invalid.
+func NewS3DocumentStore(cfg DocumentsConfig) (*S3DocumentStore, error) {
+	creds := credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	if cfg.AccessKeyID == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.IAM{Client: &http.Client{Timeout: cfg.Timeout}},
+		})
+	}
+
+	lookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       cfg.UseSSL,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", cfg.Endpoint, err)
+	}
+
+	return &S3DocumentStore{client: client, bucket: cfg.Bucket, expiry: cfg.URLExpiry, timeout: cfg.Timeout}, nil
+}
+
+// Exists reports whether an object is stored under key.
+func (s *S3DocumentStore) Exists(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	_, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return false, fmt.Errorf("looking up %s in the document storage: %w", key, err)
+}
+
+// Put uploads the document read from r under key. A document of unknown size is
+// uploaded in parts, unless it fits in one, which is then sent at once.
+//
+// Parameters:
+//   - ctx: The context of the upload.
+//   - key: The object key, such as statements/1/2024-01-abc.pdf.
+//   - doc: The headers the document is downloaded with.
+//   - r: The content of the document.
+//   - size: The size of the content, -1 when unknown.
+//
+// Returns:
+//   - error: An error if reading r or the upload fails.
+func (s *S3DocumentStore) Put(ctx context.Context, key string, doc DocumentInfo, r io.Reader, size int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if size < 0 {
+		head := make([]byte, documentPartSize)
+		n, err := io.ReadFull(r, head)
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			r, size = bytes.NewReader(head[:n]), int64(n)
+		case err != nil:
+			return err
+		default:
+			r = io.MultiReader(bytes.NewReader(head), r)
+		}
+	}
+
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{
+		ContentType:        doc.ContentType,
+		ContentDisposition: doc.ContentDisposition,
+		PartSize:           documentPartSize,
+	})
+	if err != nil {
+		return fmt.Errorf("uploading %s to the document storage: %w", key, err)
+	}
+	return nil
+}
+
+// URL presigns a GET of the object of key, valid for the configured expiry.
+func (s *S3DocumentStore) URL(ctx context.Context, key string) (*DocumentLink, error) {
+	// Presigning Is Local, Unless The Region Must Be Looked Up First
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	expiresAt := time.Now().UTC().Add(s.expiry).Truncate(time.Second)
+	signed, err := s.client.PresignedGetObject(ctx, s.bucket, key, s.expiry, url.Values{})
+	if err != nil {
+		return nil, fmt.Errorf("presigning %s: %w", key, err)
+	}
+	return &DocumentLink{URL: signed.String(), ExpiresAt: expiresAt}, nil
+}
+
+// storeDocument stores a generated document and returns a link to download it. A
+// document kept under a key derived from its content is generated once: when reuse is
+// set and the key is already stored, write is not called.
+//
+// Parameters:
+//   - ctx: The context of the upload.
+//   - key: The object key of the document.
+//   - doc: The headers the document is downloaded with.
+//   - reuse: Whether a document stored under key already is the same document.
+//   - write: Writes the content of the document; its error aborts the upload.
+//
+// Returns:
+//   - *DocumentLink: The presigned link to the document.
+//   - error: The error of write, or a 503 if the document storage fails.
+func (as *APIServer) storeDocument(ctx context.Context, key string, doc DocumentInfo, reuse bool, write func(io.Writer) error) (*DocumentLink, error) {
+	exists := false
+	if reuse {
+		var err error
+		if exists, err = as.documents.Exists(ctx, key); err != nil {
+			return nil, documentsFailed(ctx, err)
+		}
+	}
+
+	if !exists {
+		// Stream The Document To The Store, Without Holding It In Memory
+		pr, pw := io.Pipe()
+		var writeErr error
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			writeErr = write(pw)
+			pw.CloseWithError(writeErr)
+		}()
+
+		err := as.documents.Put(ctx, key, doc, pr, -1)
+		// Unblock The Writer When The Upload Stopped Reading
+		pr.CloseWithError(err)
+		<-written
+
+		// The Error Of The Document Comes First, Unless The Upload Failed It
+		if writeErr != nil && (err == nil || !errors.Is(writeErr, err)) {
+			return nil, writeErr
+		}
+		if err != nil {
+			return nil, documentsFailed(ctx, err)
+		}
+	}
+
+	link, err := as.documents.URL(ctx, key)
+	if err != nil {
+		return nil, documentsFailed(ctx, err)
+	}
+	return link, nil
+}
+
+// documentsFailed logs a failed request to the document storage, whose details are not
+// shown to the client.
+func documentsFailed(ctx context.Context, err error) error {
+	slog.ErrorContext(ctx, "Document Storage Request Failed", "error", err)
+	return NewTypedError(http.StatusServiceUnavailable, "documents_unavailable", "the document storage is unavailable, retry later")
+}
+
+// redirectToDocument sends the client to the presigned link of a stored document. The
+// redirect is not cached, since the link expires.
+func redirectToDocument(w http.ResponseWriter, r *http.Request, link *DocumentLink) error {
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.Redirect(w, r, link.URL, http.StatusSeeOther)
+	return nil
+}
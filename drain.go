@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// drainRetryAfter is the Retry-After of the money movements refused while draining, by
+// then the load balancers send the retry to another instance.
+const drainRetryAfter = shutdownReadinessDelay
+
+// moneyMovementGate tracks the money movements in progress and refuses new ones once
+// the server drains, so a shutdown never cuts a transfer off halfway.
+type moneyMovementGate struct {
+	mu       sync.Mutex
+	draining bool
+	active   int
+	// idle is closed when the gate drains and the last money movement left.
+	idle chan struct{}
+}
+
+// newMoneyMovementGate creates an open gate.
+func newMoneyMovementGate() *moneyMovementGate {
+	return &moneyMovementGate{idle: make(chan struct{})}
+}
+
+// Enter registers a money movement, or reports false when the server drains.
+func (g *moneyMovementGate) Enter() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.draining {
+		return false
+	}
+	g.active++
+	return true
+}
+
+// Leave unregisters a money movement registered by Enter.
+func (g *moneyMovementGate) Leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.active--
+	if g.draining && g.active == 0 {
+		close(g.idle)
+	}
+}
+
+// Drain refuses the new money movements and waits for those in progress to finish, or
+// for ctx to be done.
+//
+// Parameters:
+//   - ctx: Bounds the wait.
+//
+// Returns:
+//   - int: The number of money movements still in progress, 0 unless ctx ended the wait.
+func (g *moneyMovementGate) Drain(ctx context.Context) int {
+	g.mu.Lock()
+	if !g.draining {
+		g.draining = true
+		if g.active == 0 {
+			close(g.idle)
+		}
+	}
+	active := g.active
+	g.mu.Unlock()
+
+	if active > 0 {
+		slog.Info("Waiting For The Transfers In Progress", "transfers", active)
+	}
+
+	select {
+	case <-g.idle:
+		return 0
+	case <-ctx.Done():
+		return g.Active()
+	}
+}
+
+// Active returns the number of money movements in progress.
+func (g *moneyMovementGate) Active() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.active
+}
+
+// withMoneyMovement runs a handler moving money through the gate of the server: while
+// the server drains the request is refused with a 503 and a Retry-After header, and
+// otherwise the shutdown waits for the handler to return. Reads are left alone.
+//
+// Parameters:
+//   - f: The handler moving money.
+//
+// Returns:
+//   - apiFunc: The guarded handler.
+func (as *APIServer) withMoneyMovement(f apiFunc) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if !as.moneyGate.Enter() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(drainRetryAfter/time.Second)))
+			return NewTypedError(http.StatusServiceUnavailable, "shutting_down", "the server is shutting down, retry later")
+		}
+		defer as.moneyGate.Leave()
+
+		return f(w, r)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoder serializes response bodies for a single media type.
+type Encoder interface {
+	// ContentType returns the media type written in the Content-Type header.
+	ContentType() string
+	// Encode writes v to w.
+	Encode(w io.Writer, v interface{}) error
+}
+
+// encoderRegistry holds the encoders available for content negotiation, keyed by
+// media type. The first registered encoder is the default when the client accepts anything.
+type encoderRegistry struct {
+	order    []string
+	encoders map[string]Encoder
+}
+
+// Register adds an encoder to the registry under its content type.
+func (er *encoderRegistry) Register(enc Encoder) {
+	if er.encoders == nil {
+		er.encoders = make(map[string]Encoder)
+	}
+	if _, ok := er.encoders[enc.ContentType()]; !ok {
+		er.order = append(er.order, enc.ContentType())
+	}
+	er.encoders[enc.ContentType()] = enc
+}
+
+// Negotiate picks the encoder best matching the Accept header, honoring q-values.
+// An empty header selects the default encoder. It returns nil if nothing acceptable is registered.
+func (er *encoderRegistry) Negotiate(accept string) Encoder {
+	if strings.TrimSpace(accept) == "" {
+		return er.encoders[er.order[0]]
+	}
+
+	var best Encoder
+	bestQ := 0.0
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, q := parseAcceptPart(part)
+		if q <= bestQ {
+			continue
+		}
+
+		if enc := er.match(mediaType); enc != nil {
+			best, bestQ = enc, q
+		}
+	}
+
+	return best
+}
+
+// match returns the encoder for a single media range, supporting "*/*" and "type/*".
+func (er *encoderRegistry) match(mediaRange string) Encoder {
+	if enc, ok := er.encoders[mediaRange]; ok {
+		return enc
+	}
+
+	if mediaRange == "*/*" {
+		return er.encoders[er.order[0]]
+	}
+
+	if prefix, ok := strings.CutSuffix(mediaRange, "/*"); ok {
+		for _, contentType := range er.order {
+			if strings.HasPrefix(contentType, prefix+"/") {
+				return er.encoders[contentType]
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseAcceptPart splits one element of an Accept header into its media range and q-value.
+func parseAcceptPart(part string) (string, float64) {
+	params := strings.Split(part, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+	q := 1.0
+
+	for _, param := range params[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.TrimSpace(key) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+
+	return mediaType, q
+}
+
+// encoders is the registry used by WriteResponse. JSON is registered first and is the default.
+var encoders = func() *encoderRegistry {
+	er := &encoderRegistry{}
+	er.Register(jsonEncoder{})
+	er.Register(xmlEncoder{})
+	er.Register(msgpackEncoder{})
+	return er
+}()
+
+// WriteResponse writes data with the given HTTP status code, serialized with the encoder
+// negotiated from the request's Accept header. Handlers should use it instead of WriteJSON.
+// When the request carries a "fields" query parameter only the listed fields are written.
+//
+// Parameters:
+//   - w: The http.ResponseWriter to write the response to.
+//   - r: The *http.Request whose Accept header selects the encoder.
+//   - status: The HTTP status code to set for the response.
+//   - data: The data to encode and write.
+//
+// Returns:
+//   - error: A TypedError with 406 if no acceptable encoder exists, or an error if encoding fails.
+func WriteResponse(w http.ResponseWriter, r *http.Request, status int, data interface{}) error {
+	if fields := parseFields(r); fields != nil {
+		selected, err := selectFields(data, fields)
+		if err != nil {
+			return err
+		}
+		data = selected
+	}
+
+	return writeNegotiated(w, r, status, data)
+}
+
+// writeNegotiated writes data with the encoder negotiated from the Accept header.
+func writeNegotiated(w http.ResponseWriter, r *http.Request, status int, data interface{}) error {
+	enc := encoders.Negotiate(r.Header.Get("Accept"))
+	if enc == nil {
+		return NewTypedError(http.StatusNotAcceptable, "not_acceptable", fmt.Sprintf("none of the requested media types are supported: %s", strings.Join(encoders.order, ", ")))
+	}
+
+	w.Header().Set("Content-Type", enc.ContentType())
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+
+	return enc.Encode(w, data)
+}
+
+// jsonEncoder encodes responses as JSON.
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return "application/json" }
+
+func (jsonEncoder) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// msgpackEncoder encodes responses as MessagePack, reusing the json struct tags
+// so field names are identical to the JSON representation.
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) ContentType() string { return "application/msgpack" }
+
+func (msgpackEncoder) Encode(w io.Writer, v interface{}) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(v)
+}
+
+// xmlEncoder encodes responses as XML. The value is first converted through its
+// JSON representation so element names match the JSON field names and maps are supported.
+// The document root is a <response> element; list items are <item> elements.
+type xmlEncoder struct{}
+
+func (xmlEncoder) ContentType() string { return "application/xml" }
+
+func (xmlEncoder) Encode(w io.Writer, v interface{}) error {
+	tree, err := toGeneric(v)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	if err := writeXMLElement(enc, "response", tree); err != nil {
+		return err
+	}
+
+	return enc.Flush()
+}
+
+// writeXMLElement writes a decoded JSON value as an XML element with the given name.
+func writeXMLElement(enc *xml.Encoder, name string, value interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if err := writeXMLElement(enc, key, v[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := writeXMLElement(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// writeErrorResponse writes an error body using the negotiated encoder, falling back
+// to JSON when the client accepts none of the registered media types. Error bodies
+// are never reduced by the "fields" query parameter.
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+	if encoders.Negotiate(r.Header.Get("Accept")) == nil {
+		WriteJSON(w, status, body)
+		return
+	}
+
+	writeNegotiated(w, r, status, body)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errorReportingFlushTimeout bounds the delivery of the buffered error reports on exit.
+const errorReportingFlushTimeout = 5 * time.Second
+
+// scrubbedHeaders are left out of the reports on top of the credentials the SDK drops.
+var scrubbedHeaders = []string{"X-Admin-Token"}
+
+// setupErrorReporting sends the unexpected errors to the Sentry-compatible service of
+// cfg.DSN, tagged with the release built and cfg.Environment. Without a DSN nothing is
+// reported and reportError and withPanicReporting only log.
+//
+// Parameters:
+//   - cfg: The error reporting settings.
+//
+// Returns:
+//   - func(): Delivers the buffered reports; to be called before the process exits.
+//   - error: An error if the DSN is invalid.
+func setupErrorReporting(cfg ErrorReportingConfig) (func(), error) {
+	if cfg.DSN == "" {
+		return func() {}, nil
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:              cfg.DSN,
+		Environment:      cfg.Environment,
+		Release:          "gobank@" + Version,
+		SampleRate:       cfg.SampleRate,
+		AttachStacktrace: true,
+		BeforeSend: func(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+			if event.Request != nil {
+				for _, header := range scrubbedHeaders {
+					delete(event.Request.Headers, header)
+				}
+			}
+			return event
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("configuring the error reporting: %w", err)
+	}
+	slog.Info("Reporting Errors", "environment", cfg.Environment)
+
+	return func() {
+		sentry.Flush(errorReportingFlushTimeout)
+	}, nil
+}
+
+// requestHub returns a hub whose reports carry the request, its ID, its trace, and the
+// log fields of its context, such as the route and the authenticated account.
+func requestHub(r *http.Request) *sentry.Hub {
+	hub := sentry.CurrentHub().Clone()
+
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		scope.SetRequest(r)
+		if id := requestIDFromContext(r.Context()); id != "" {
+			scope.SetTag("request_id", id)
+		}
+		if spanCtx := trace.SpanContextFromContext(r.Context()); spanCtx.IsValid() {
+			scope.SetTag("trace_id", spanCtx.TraceID().String())
+		}
+		if attrs, ok := r.Context().Value(logAttrsKey{}).([]slog.Attr); ok {
+			for _, attr := range attrs {
+				scope.SetTag(attr.Key, attr.Value.String())
+			}
+		}
+	})
+
+	return hub
+}
+
+// reportError reports the error behind a 5xx response.
+func reportError(r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "Request Failed With A Server Error", "error", err)
+	requestHub(r).CaptureException(err)
+}
+
+// withPanicReporting recovers from a panic of next, reports it with its stack trace,
+// and answers 500 instead of dropping the connection. http.ErrAbortHandler, used to
+// abort a response on purpose, is passed on to the server.
+func withPanicReporting(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			slog.ErrorContext(r.Context(), "Request Panicked", "panic", p)
+			requestHub(r).RecoverWithContext(r.Context(), p)
+
+			writeErrorResponse(w, r, http.StatusInternalServerError, APIError{
+				Error:     localize(r, "error.internal_error", "internal server error"),
+				RequestID: requestIDFromContext(r.Context()),
+			})
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// accountETag derives the entity tag of an account from its version column.
+func accountETag(acc *Account) string {
+	return fmt.Sprintf("\"%d-%d\"", acc.ID, acc.Version)
+}
+
+// matchesIfMatch reports whether the If-Match header value matches the given entity tag.
+// The header may contain several comma separated tags or the "*" wildcard.
+func matchesIfMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesIfNoneMatch reports whether the If-None-Match header value matches the given entity tag.
+// Unlike If-Match, the comparison is weak: a "W/" prefix on either side is ignored.
+func matchesIfNoneMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNotModified sets the ETag and Last-Modified validators of a representation and
+// evaluates the conditional headers of a GET request against them. If-None-Match takes
+// precedence over If-Modified-Since, as required by RFC 9110.
+// When the client's copy is still current, a 304 Not Modified response is written and
+// the handler must not write a body.
+//
+// Parameters:
+//   - w: http.ResponseWriter to set the validators on.
+//   - r: *http.Request carrying the conditional headers.
+//   - etag: The entity tag of the representation, or "" if it has none.
+//   - lastModified: The modification time of the representation, or the zero time if unknown.
+//
+// Returns:
+//   - bool: true if a 304 response has been written, otherwise false.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if !isNotModified(r, etag, lastModified) {
+		return false
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// isNotModified reports whether the conditional headers of r show that the client
+// already holds the representation described by etag and lastModified.
+func isNotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && matchesIfNoneMatch(inm, etag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified.IsZero() {
+		return false
+	}
+
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+
+	// HTTP Dates Have Second Precision
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// handleUpdateAccount handles PUT and PATCH requests on an account.
+// The request must carry an If-Match header with the ETag returned by GET; the update
+// is rejected with 412 Precondition Failed when the account changed in the meantime.
+// PUT replaces both names while PATCH only changes the fields present in the body.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the account ID, the If-Match header, and the new details.
+//
+// Returns:
+//   - error: A TypedError if the precondition is missing or fails, or an error if the
+//     body cannot be decoded or the account cannot be updated.
+func (as *APIServer) handleUpdateAccount(w http.ResponseWriter, r *http.Request) error {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return NewTypedError(http.StatusPreconditionRequired, "precondition_required", "If-Match header is required")
+	}
+
+	// Decode The Request Body To UpdateAccountRequest
+	updateReq := new(UpdateAccountRequest)
+
+	if err := bindJSON(w, r, updateReq); err != nil {
+		return err
+	}
+
+	// PUT Replaces The Whole Account
+	if r.Method == http.MethodPut {
+		fieldErrs := []FieldError{}
+		if updateReq.FirstName == nil {
+			fieldErrs = append(fieldErrs, FieldError{Field: "first_name", Code: CodeRequired, Message: "first_name is required"})
+		}
+		if updateReq.LastName == nil {
+			fieldErrs = append(fieldErrs, FieldError{Field: "last_name", Code: CodeRequired, Message: "last_name is required"})
+		}
+		if len(fieldErrs) > 0 {
+			return newValidationError(fieldErrs)
+		}
+	}
+
+	// Get The ID From The URL
+	id := getId(w, r)
+
+	acc, err := as.store.GetAccountById(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	if !matchesIfMatch(ifMatch, accountETag(acc)) {
+		return NewTypedError(http.StatusPreconditionFailed, "precondition_failed", "account has been modified")
+	}
+
+	// Apply The Changes
+	if updateReq.FirstName != nil {
+		acc.FirstName = *updateReq.FirstName
+	}
+	if updateReq.LastName != nil {
+		acc.LastName = *updateReq.LastName
+	}
+
+	if err := as.store.UpdateAccount(r.Context(), acc); err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", accountETag(acc))
+
+	return WriteResponse(w, r, http.StatusOK, newAccountResource(r, acc))
+}
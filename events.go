@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/moabdelazem/gobank/money"
+)
+
+// Account Event Types
+const (
+	EventBalanceChanged     = "balance.changed"
+	EventTransactionCreated = "transaction.created"
+	EventTransferStatus     = "transfer.status"
+
+	// Published Through The Outbox
+	EventAccountCreated    = "account.created"
+	EventAccountClosed     = "account.closed"
+	EventTransferCompleted = "transfer.completed"
+	EventSecurityAlert     = "security.alert"
+	// EventCardTopUpSucceeded carries the CardTopUp credited to the account.
+	EventCardTopUpSucceeded = "card_top_up.succeeded"
+	// EventKYCStatusChanged carries the KYCCheck whose result changed the KYC status of
+	// the account, with the status it had before.
+	EventKYCStatusChanged = "account.kyc_status_changed"
+	// EventInterestCredited carries the Transaction of the interest credited to the account.
+	EventInterestCredited = "interest.credited"
+	// EventAccountDormant and EventAccountReactivated carry an AccountDormancyEvent.
+	EventAccountDormant     = "account.dormant"
+	EventAccountReactivated = "account.reactivated"
+)
+
+// Security Alert Reasons
+const (
+	SecurityDeviceRegistered = "device_registered"
+	SecurityContactChanged   = "contact_changed"
+	SecurityAccountFrozen    = "account_frozen"
+	SecurityAccountUnfrozen  = "account_unfrozen"
+	SecurityConsentGranted   = "consent_granted"
+)
+
+// SecurityAlertEvent is the payload of a security.alert event, a change of the account
+// its holder should know of.
+type SecurityAlertEvent struct {
+	Reason string `json:"reason"`
+	// DeviceID is the device that was registered, which is not alerted.
+	DeviceID int       `json:"device_id,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// AccountClosedEvent is the payload of an account.closed event.
+type AccountClosedEvent struct {
+	AccountID int       `json:"account_id"`
+	ClosedAt  time.Time `json:"closed_at"`
+}
+
+// TransferStatusEvent is the payload of a transfer.status event.
+type TransferStatusEvent struct {
+	TransferID    int         `json:"transfer_id"`
+	FromAccountID int         `json:"from_account_id"`
+	ToAccountID   int         `json:"to_account_id"`
+	Amount        money.Money `json:"amount"`
+	Currency      string      `json:"currency"`
+	Status        string      `json:"status"`
+	Reason        string      `json:"reason,omitempty"`
+}
+
+// AccountEvent represents a single change on an account that is pushed to
+// live subscribers (SSE streams, WebSocket clients, ...).
+type AccountEvent struct {
+	Type      string      `json:"type"`
+	AccountID int         `json:"account_id"`
+	Data      interface{} `json:"data"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// EventBroker fans out account events to in-process subscribers.
+// Subscribers register per account and receive every event published for it.
+type EventBroker struct {
+	mu          sync.RWMutex
+	subscribers map[int]map[chan AccountEvent]struct{}
+}
+
+// NewEventBroker creates an empty EventBroker ready to accept subscribers.
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		subscribers: make(map[int]map[chan AccountEvent]struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber for the given account and returns the
+// channel the events are delivered on. The caller must call Unsubscribe when done.
+func (b *EventBroker) Subscribe(accountID int) chan AccountEvent {
+	ch := make(chan AccountEvent, 16)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers[accountID] == nil {
+		b.subscribers[accountID] = make(map[chan AccountEvent]struct{})
+	}
+	b.subscribers[accountID][ch] = struct{}{}
+
+	return ch
+}
+
+// Unsubscribe removes the subscriber channel for the given account and closes it.
+func (b *EventBroker) Unsubscribe(accountID int, ch chan AccountEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs, ok := b.subscribers[accountID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[ch]; !ok {
+		return
+	}
+
+	delete(subs, ch)
+	close(ch)
+
+	if len(subs) == 0 {
+		delete(b.subscribers, accountID)
+	}
+}
+
+// Publish delivers the event to every subscriber of the event's account.
+// Slow subscribers whose buffer is full miss the event instead of blocking the publisher.
+func (b *EventBroker) Publish(event AccountEvent) {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers[event.AccountID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
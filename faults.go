@@ -0,0 +1,809 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/moabdelazem/gobank/money"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// Calls Faults Are Injected Into
+const (
+	faultTargetStorage = "storage"
+	faultTargetCache   = "cache"
+)
+
+// Kinds Of Injected Errors
+const (
+	// faultUnavailable fails like a dropped database connection, opening the circuit breaker.
+	faultUnavailable = "unavailable"
+	// faultSerialization fails like a transaction rolled back by a concurrent one, which is retried.
+	faultSerialization = "serialization"
+	// faultTimeout fails like a call that ran out of time.
+	faultTimeout = "timeout"
+)
+
+// Known Fault Targets And Error Kinds, For Validate
+var (
+	faultTargets    = []string{faultTargetStorage, faultTargetCache}
+	faultErrorKinds = []string{faultUnavailable, faultSerialization, faultTimeout}
+)
+
+// errInjectedFault is the cause of every injected error.
+var errInjectedFault = errors.New("injected fault")
+
+// faultInjector delays and fails a share of the storage and cache calls, to check how the
+// timeouts, the retries, the circuit breaker, and the clients cope. It is meant for test
+// environments only; a nil faultInjector injects nothing.
+type faultInjector struct {
+	cfg      FaultInjectionConfig
+	injected *prometheus.CounterVec
+}
+
+// newFaultInjector creates the injector of cfg, or returns nil when cfg injects neither
+// latency nor errors.
+func newFaultInjector(cfg FaultInjectionConfig) *faultInjector {
+	if cfg.Latency == 0 && cfg.ErrorRate == 0 {
+		return nil
+	}
+
+	return &faultInjector{
+		cfg: cfg,
+		injected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "faults", Name: "injected_total",
+			Help: "Number of faults injected, by target and fault (latency, or the kind of error).",
+		}, []string{"target", "fault"}),
+	}
+}
+
+// strike delays the call op of target and decides whether it fails, according to the
+// rates of the configuration.
+//
+// Parameters:
+//   - ctx: The context of the call; the delay ends early when it is done.
+//   - target: faultTargetStorage or faultTargetCache.
+//   - op: The name of the call, for the error message.
+//
+// Returns:
+//   - error: The injected error, ctx.Err() if ctx was done during the delay, or nil.
+func (f *faultInjector) strike(ctx context.Context, target, op string) error {
+	if f == nil || !slices.Contains(f.cfg.Targets, target) {
+		return nil
+	}
+
+	if f.cfg.Latency > 0 && rand.Float64() < f.cfg.LatencyRate {
+		f.injected.WithLabelValues(target, "latency").Inc()
+
+		timer := time.NewTimer(f.cfg.Latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if rand.Float64() >= f.cfg.ErrorRate {
+		return nil
+	}
+	f.injected.WithLabelValues(target, f.cfg.Error).Inc()
+
+	switch f.cfg.Error {
+	case faultSerialization:
+		return &pgconn.PgError{Severity: "ERROR", Code: pgCodeSerializationFailure, Message: fmt.Sprintf("%s: %v", op, errInjectedFault)}
+	case faultTimeout:
+		return fmt.Errorf("%s: %w: %w", op, errInjectedFault, context.DeadlineExceeded)
+	}
+	return &net.OpError{Op: "read", Net: "tcp", Err: fmt.Errorf("%s: %w", op, errInjectedFault)}
+}
+
+// redisHooks returns the hooks injecting the faults into the cache client.
+func (f *faultInjector) redisHooks() []redis.Hook {
+	if f == nil {
+		return nil
+	}
+	return []redis.Hook{faultRedisHook{faults: f}}
+}
+
+// faultRedisHook injects faults into the commands of a Redis client.
+type faultRedisHook struct {
+	faults *faultInjector
+}
+
+// DialHook leaves the connections alone.
+func (h faultRedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+// ProcessHook injects a fault before a command.
+func (h faultRedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if err := h.faults.strike(ctx, faultTargetCache, cmd.Name()); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+// ProcessPipelineHook injects a fault before a pipeline, failing all of its commands.
+func (h faultRedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if err := h.faults.strike(ctx, faultTargetCache, "pipeline"); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// FaultyStorage is a Storage decorator injecting the faults of a faultInjector into every
+// call of the wrapped storage. It sits right on top of the backend, so the retries, the
+// circuit breaker, and the storage metrics see the faults as database failures.
+type FaultyStorage struct {
+	next   Storage
+	faults *faultInjector
+}
+
+// NewFaultyStorage wraps store with the faults of faults, when there are any.
+//
+// Parameters:
+//   - store: The storage to inject faults into.
+//   - faults: The fault injector, nil to inject none.
+//
+// Returns:
+//   - Storage: The faulty storage, or store itself when faults is nil.
+func NewFaultyStorage(store Storage, faults *faultInjector) Storage {
+	if faults == nil {
+		return store
+	}
+
+	slog.Warn("Injecting Faults Into The Storage And Cache Calls, For Testing Only",
+		"latency", faults.cfg.Latency, "latency_rate", faults.cfg.LatencyRate,
+		"error_rate", faults.cfg.ErrorRate, "error", faults.cfg.Error, "targets", faults.cfg.Targets)
+	return &FaultyStorage{next: store, faults: faults}
+}
+
+// Unwrap returns the storage the faults are injected into.
+func (s *FaultyStorage) Unwrap() Storage {
+	return s.next
+}
+
+// Collectors returns the injected fault counter, for the metrics registry.
+func (s *FaultyStorage) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{s.faults.injected}
+}
+
+// strike injects a fault into the call method.
+func (s *FaultyStorage) strike(ctx context.Context, method string) error {
+	return s.faults.strike(ctx, faultTargetStorage, method)
+}
+
+// WithTx injects a fault into the transaction, and into the calls made inside it as well.
+func (s *FaultyStorage) WithTx(ctx context.Context, fn func(Storage) error) error {
+	if err := s.strike(ctx, "WithTx"); err != nil {
+		return err
+	}
+	return s.next.WithTx(ctx, func(tx Storage) error {
+		return fn(&FaultyStorage{next: tx, faults: s.faults})
+	})
+}
+
+// Ping injects a fault into Ping of the wrapped storage.
+func (s *FaultyStorage) Ping(ctx context.Context) error {
+	if err := s.strike(ctx, "Ping"); err != nil {
+		return err
+	}
+	return s.next.Ping(ctx)
+}
+
+// CreateAccount injects a fault into CreateAccount of the wrapped storage.
+func (s *FaultyStorage) CreateAccount(ctx context.Context, account *Account) error {
+	if err := s.strike(ctx, "CreateAccount"); err != nil {
+		return err
+	}
+	return s.next.CreateAccount(ctx, account)
+}
+
+// CreateAccounts injects a fault into CreateAccounts of the wrapped storage.
+func (s *FaultyStorage) CreateAccounts(ctx context.Context, accounts []*Account) error {
+	if err := s.strike(ctx, "CreateAccounts"); err != nil {
+		return err
+	}
+	return s.next.CreateAccounts(ctx, accounts)
+}
+
+// DeleteAccount injects a fault into DeleteAccount of the wrapped storage.
+func (s *FaultyStorage) DeleteAccount(ctx context.Context, id int) error {
+	if err := s.strike(ctx, "DeleteAccount"); err != nil {
+		return err
+	}
+	return s.next.DeleteAccount(ctx, id)
+}
+
+// UpdateAccount injects a fault into UpdateAccount of the wrapped storage.
+func (s *FaultyStorage) UpdateAccount(ctx context.Context, account *Account) error {
+	if err := s.strike(ctx, "UpdateAccount"); err != nil {
+		return err
+	}
+	return s.next.UpdateAccount(ctx, account)
+}
+
+// GetAccounts injects a fault into GetAccounts of the wrapped storage.
+func (s *FaultyStorage) GetAccounts(ctx context.Context) (accounts []*Account, err error) {
+	if err = s.strike(ctx, "GetAccounts"); err != nil {
+		return accounts, err
+	}
+	return s.next.GetAccounts(ctx)
+}
+
+// GetAccountsPage injects a fault into GetAccountsPage of the wrapped storage.
+func (s *FaultyStorage) GetAccountsPage(ctx context.Context, limit, offset int, includeDeleted bool) (accounts []*Account, total int, err error) {
+	if err = s.strike(ctx, "GetAccountsPage"); err != nil {
+		return accounts, total, err
+	}
+	return s.next.GetAccountsPage(ctx, limit, offset, includeDeleted)
+}
+
+// GetAccountById injects a fault into GetAccountById of the wrapped storage.
+func (s *FaultyStorage) GetAccountById(ctx context.Context, id int) (account *Account, err error) {
+	if err = s.strike(ctx, "GetAccountById"); err != nil {
+		return account, err
+	}
+	return s.next.GetAccountById(ctx, id)
+}
+
+// GetAccountByNumber injects a fault into GetAccountByNumber of the wrapped storage.
+func (s *FaultyStorage) GetAccountByNumber(ctx context.Context, number int64) (account *Account, err error) {
+	if err = s.strike(ctx, "GetAccountByNumber"); err != nil {
+		return account, err
+	}
+	return s.next.GetAccountByNumber(ctx, number)
+}
+
+// GetAccountByExternalID injects a fault into GetAccountByExternalID of the wrapped storage.
+func (s *FaultyStorage) GetAccountByExternalID(ctx context.Context, externalID string) (account *Account, err error) {
+	if err = s.strike(ctx, "GetAccountByExternalID"); err != nil {
+		return account, err
+	}
+	return s.next.GetAccountByExternalID(ctx, externalID)
+}
+
+// GetTransactions injects a fault into GetTransactions of the wrapped storage.
+func (s *FaultyStorage) GetTransactions(ctx context.Context, accountID int, filter *TransactionFilter) (txns []*Transaction, err error) {
+	if err = s.strike(ctx, "GetTransactions"); err != nil {
+		return txns, err
+	}
+	return s.next.GetTransactions(ctx, accountID, filter)
+}
+
+// GetTransactionsPage injects a fault into GetTransactionsPage of the wrapped storage.
+func (s *FaultyStorage) GetTransactionsPage(ctx context.Context, accountID int, filter *TransactionFilter, after *TransactionCursor, limit int) (txns []*Transaction, err error) {
+	if err = s.strike(ctx, "GetTransactionsPage"); err != nil {
+		return txns, err
+	}
+	return s.next.GetTransactionsPage(ctx, accountID, filter, after, limit)
+}
+
+// CreateTransactions injects a fault into CreateTransactions of the wrapped storage.
+func (s *FaultyStorage) CreateTransactions(ctx context.Context, txns []*Transaction) error {
+	if err := s.strike(ctx, "CreateTransactions"); err != nil {
+		return err
+	}
+	return s.next.CreateTransactions(ctx, txns)
+}
+
+// GetBalanceAt injects a fault into GetBalanceAt of the wrapped storage.
+func (s *FaultyStorage) GetBalanceAt(ctx context.Context, accountID int, at time.Time) (balance money.Money, err error) {
+	if err = s.strike(ctx, "GetBalanceAt"); err != nil {
+		return balance, err
+	}
+	return s.next.GetBalanceAt(ctx, accountID, at)
+}
+
+// StreamLedger injects a fault into StreamLedger of the wrapped storage.
+func (s *FaultyStorage) StreamLedger(ctx context.Context, from, to time.Time, fn func(*Transaction) error) error {
+	if err := s.strike(ctx, "StreamLedger"); err != nil {
+		return err
+	}
+	return s.next.StreamLedger(ctx, from, to, fn)
+}
+
+// StreamTransactions injects a fault into StreamTransactions of the wrapped storage.
+func (s *FaultyStorage) StreamTransactions(ctx context.Context, accountID int, from, to time.Time, fn func(*Transaction) error) error {
+	if err := s.strike(ctx, "StreamTransactions"); err != nil {
+		return err
+	}
+	return s.next.StreamTransactions(ctx, accountID, from, to, fn)
+}
+
+// ImportRecords injects a fault into ImportRecords of the wrapped storage.
+func (s *FaultyStorage) ImportRecords(ctx context.Context, records []*ImportRecord) (errs []error, err error) {
+	if err = s.strike(ctx, "ImportRecords"); err != nil {
+		return errs, err
+	}
+	return s.next.ImportRecords(ctx, records)
+}
+
+// TransferFunds injects a fault into TransferFunds of the wrapped storage.
+func (s *FaultyStorage) TransferFunds(ctx context.Context, fromID, toID int, amount money.Money) (txns []*Transaction, err error) {
+	if err = s.strike(ctx, "TransferFunds"); err != nil {
+		return txns, err
+	}
+	return s.next.TransferFunds(ctx, fromID, toID, amount)
+}
+
+// CreateTransfer injects a fault into CreateTransfer of the wrapped storage.
+func (s *FaultyStorage) CreateTransfer(ctx context.Context, transfer *Transfer) error {
+	if err := s.strike(ctx, "CreateTransfer"); err != nil {
+		return err
+	}
+	return s.next.CreateTransfer(ctx, transfer)
+}
+
+// UpdateTransferStatus injects a fault into UpdateTransferStatus of the wrapped storage.
+func (s *FaultyStorage) UpdateTransferStatus(ctx context.Context, transfer *Transfer, status, reason string) error {
+	if err := s.strike(ctx, "UpdateTransferStatus"); err != nil {
+		return err
+	}
+	return s.next.UpdateTransferStatus(ctx, transfer, status, reason)
+}
+
+// GetTransfer injects a fault into GetTransfer of the wrapped storage.
+func (s *FaultyStorage) GetTransfer(ctx context.Context, id int) (transfer *Transfer, err error) {
+	if err = s.strike(ctx, "GetTransfer"); err != nil {
+		return transfer, err
+	}
+	return s.next.GetTransfer(ctx, id)
+}
+
+// GetTransfersByStatus injects a fault into GetTransfersByStatus of the wrapped storage.
+func (s *FaultyStorage) GetTransfersByStatus(ctx context.Context, status string) (transfers []*Transfer, err error) {
+	if err = s.strike(ctx, "GetTransfersByStatus"); err != nil {
+		return transfers, err
+	}
+	return s.next.GetTransfersByStatus(ctx, status)
+}
+
+// GetTransferByProviderReference injects a fault into GetTransferByProviderReference of the wrapped storage.
+func (s *FaultyStorage) GetTransferByProviderReference(ctx context.Context, reference string) (transfer *Transfer, err error) {
+	if err = s.strike(ctx, "GetTransferByProviderReference"); err != nil {
+		return transfer, err
+	}
+	return s.next.GetTransferByProviderReference(ctx, reference)
+}
+
+// SetAccountFrozen injects a fault into SetAccountFrozen of the wrapped storage.
+func (s *FaultyStorage) SetAccountFrozen(ctx context.Context, id int, frozen bool) error {
+	if err := s.strike(ctx, "SetAccountFrozen"); err != nil {
+		return err
+	}
+	return s.next.SetAccountFrozen(ctx, id, frozen)
+}
+
+// SetTransferLimit injects a fault into SetTransferLimit of the wrapped storage.
+func (s *FaultyStorage) SetTransferLimit(ctx context.Context, id int, limit money.Money) error {
+	if err := s.strike(ctx, "SetTransferLimit"); err != nil {
+		return err
+	}
+	return s.next.SetTransferLimit(ctx, id, limit)
+}
+
+// CreateAuditEntry injects a fault into CreateAuditEntry of the wrapped storage.
+func (s *FaultyStorage) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	if err := s.strike(ctx, "CreateAuditEntry"); err != nil {
+		return err
+	}
+	return s.next.CreateAuditEntry(ctx, entry)
+}
+
+// GetAuditLog injects a fault into GetAuditLog of the wrapped storage.
+func (s *FaultyStorage) GetAuditLog(ctx context.Context, limit, offset int) (entries []*AuditEntry, total int, err error) {
+	if err = s.strike(ctx, "GetAuditLog"); err != nil {
+		return entries, total, err
+	}
+	return s.next.GetAuditLog(ctx, limit, offset)
+}
+
+// GetFeatureFlags injects a fault into GetFeatureFlags of the wrapped storage.
+func (s *FaultyStorage) GetFeatureFlags(ctx context.Context) (flags []*FeatureFlag, err error) {
+	if err = s.strike(ctx, "GetFeatureFlags"); err != nil {
+		return flags, err
+	}
+	return s.next.GetFeatureFlags(ctx)
+}
+
+// SetFeatureFlag injects a fault into SetFeatureFlag of the wrapped storage.
+func (s *FaultyStorage) SetFeatureFlag(ctx context.Context, flag *FeatureFlag) error {
+	if err := s.strike(ctx, "SetFeatureFlag"); err != nil {
+		return err
+	}
+	return s.next.SetFeatureFlag(ctx, flag)
+}
+
+// DeleteFeatureFlag injects a fault into DeleteFeatureFlag of the wrapped storage.
+func (s *FaultyStorage) DeleteFeatureFlag(ctx context.Context, name string) error {
+	if err := s.strike(ctx, "DeleteFeatureFlag"); err != nil {
+		return err
+	}
+	return s.next.DeleteFeatureFlag(ctx, name)
+}
+
+// GetMaintenance injects a fault into GetMaintenance of the wrapped storage.
+func (s *FaultyStorage) GetMaintenance(ctx context.Context) (maintenance *Maintenance, err error) {
+	if err = s.strike(ctx, "GetMaintenance"); err != nil {
+		return maintenance, err
+	}
+	return s.next.GetMaintenance(ctx)
+}
+
+// SetMaintenance injects a fault into SetMaintenance of the wrapped storage.
+func (s *FaultyStorage) SetMaintenance(ctx context.Context, maintenance *Maintenance) error {
+	if err := s.strike(ctx, "SetMaintenance"); err != nil {
+		return err
+	}
+	return s.next.SetMaintenance(ctx, maintenance)
+}
+
+// ClearMaintenance injects a fault into ClearMaintenance of the wrapped storage.
+func (s *FaultyStorage) ClearMaintenance(ctx context.Context) error {
+	if err := s.strike(ctx, "ClearMaintenance"); err != nil {
+		return err
+	}
+	return s.next.ClearMaintenance(ctx)
+}
+
+// GetNotificationPreferences injects a fault into GetNotificationPreferences of the wrapped storage.
+func (s *FaultyStorage) GetNotificationPreferences(ctx context.Context, accountID int) (*NotificationPreferences, error) {
+	if err := s.strike(ctx, "GetNotificationPreferences"); err != nil {
+		return nil, err
+	}
+	return s.next.GetNotificationPreferences(ctx, accountID)
+}
+
+// SetNotificationPreferences injects a fault into SetNotificationPreferences of the wrapped storage.
+func (s *FaultyStorage) SetNotificationPreferences(ctx context.Context, prefs *NotificationPreferences) error {
+	if err := s.strike(ctx, "SetNotificationPreferences"); err != nil {
+		return err
+	}
+	return s.next.SetNotificationPreferences(ctx, prefs)
+}
+
+// RegisterPushDevice injects a fault into RegisterPushDevice of the wrapped storage.
+func (s *FaultyStorage) RegisterPushDevice(ctx context.Context, device *PushDevice) error {
+	if err := s.strike(ctx, "RegisterPushDevice"); err != nil {
+		return err
+	}
+	return s.next.RegisterPushDevice(ctx, device)
+}
+
+// GetPushDevices injects a fault into GetPushDevices of the wrapped storage.
+func (s *FaultyStorage) GetPushDevices(ctx context.Context, accountID int) ([]*PushDevice, error) {
+	if err := s.strike(ctx, "GetPushDevices"); err != nil {
+		return nil, err
+	}
+	return s.next.GetPushDevices(ctx, accountID)
+}
+
+// DeletePushDevice injects a fault into DeletePushDevice of the wrapped storage.
+func (s *FaultyStorage) DeletePushDevice(ctx context.Context, accountID, id int) error {
+	if err := s.strike(ctx, "DeletePushDevice"); err != nil {
+		return err
+	}
+	return s.next.DeletePushDevice(ctx, accountID, id)
+}
+
+// RecordGatewayEvent injects a fault into RecordGatewayEvent of the wrapped storage.
+func (s *FaultyStorage) RecordGatewayEvent(ctx context.Context, event *GatewayEvent) error {
+	if err := s.strike(ctx, "RecordGatewayEvent"); err != nil {
+		return err
+	}
+	return s.next.RecordGatewayEvent(ctx, event)
+}
+
+// DepositFunds injects a fault into DepositFunds of the wrapped storage.
+func (s *FaultyStorage) DepositFunds(ctx context.Context, deposit *Transaction) error {
+	if err := s.strike(ctx, "DepositFunds"); err != nil {
+		return err
+	}
+	return s.next.DepositFunds(ctx, deposit)
+}
+
+// CreateCardTopUp injects a fault into CreateCardTopUp of the wrapped storage.
+func (s *FaultyStorage) CreateCardTopUp(ctx context.Context, topUp *CardTopUp) error {
+	if err := s.strike(ctx, "CreateCardTopUp"); err != nil {
+		return err
+	}
+	return s.next.CreateCardTopUp(ctx, topUp)
+}
+
+// GetCardTopUp injects a fault into GetCardTopUp of the wrapped storage.
+func (s *FaultyStorage) GetCardTopUp(ctx context.Context, accountID, id int) (topUp *CardTopUp, err error) {
+	if err = s.strike(ctx, "GetCardTopUp"); err != nil {
+		return nil, err
+	}
+	return s.next.GetCardTopUp(ctx, accountID, id)
+}
+
+// GetCardTopUpByPaymentIntent injects a fault into GetCardTopUpByPaymentIntent of the wrapped storage.
+func (s *FaultyStorage) GetCardTopUpByPaymentIntent(ctx context.Context, paymentIntentID string) (topUp *CardTopUp, err error) {
+	if err = s.strike(ctx, "GetCardTopUpByPaymentIntent"); err != nil {
+		return nil, err
+	}
+	return s.next.GetCardTopUpByPaymentIntent(ctx, paymentIntentID)
+}
+
+// UpdateCardTopUp injects a fault into UpdateCardTopUp of the wrapped storage.
+func (s *FaultyStorage) UpdateCardTopUp(ctx context.Context, topUp *CardTopUp, fromStatus string) error {
+	if err := s.strike(ctx, "UpdateCardTopUp"); err != nil {
+		return err
+	}
+	return s.next.UpdateCardTopUp(ctx, topUp, fromStatus)
+}
+
+// CreateConsent injects a fault into CreateConsent of the wrapped storage.
+func (s *FaultyStorage) CreateConsent(ctx context.Context, consent *Consent) error {
+	if err := s.strike(ctx, "CreateConsent"); err != nil {
+		return err
+	}
+	return s.next.CreateConsent(ctx, consent)
+}
+
+// GetConsent injects a fault into GetConsent of the wrapped storage.
+func (s *FaultyStorage) GetConsent(ctx context.Context, id int) (consent *Consent, err error) {
+	if err = s.strike(ctx, "GetConsent"); err != nil {
+		return nil, err
+	}
+	return s.next.GetConsent(ctx, id)
+}
+
+// GetConsents injects a fault into GetConsents of the wrapped storage.
+func (s *FaultyStorage) GetConsents(ctx context.Context, accountID int) (consents []*Consent, err error) {
+	if err = s.strike(ctx, "GetConsents"); err != nil {
+		return nil, err
+	}
+	return s.next.GetConsents(ctx, accountID)
+}
+
+// RevokeConsent injects a fault into RevokeConsent of the wrapped storage.
+func (s *FaultyStorage) RevokeConsent(ctx context.Context, accountID, id int) error {
+	if err := s.strike(ctx, "RevokeConsent"); err != nil {
+		return err
+	}
+	return s.next.RevokeConsent(ctx, accountID, id)
+}
+
+// SetAccountKYCStatus injects a fault into SetAccountKYCStatus of the wrapped storage.
+func (s *FaultyStorage) SetAccountKYCStatus(ctx context.Context, id int, status string) error {
+	if err := s.strike(ctx, "SetAccountKYCStatus"); err != nil {
+		return err
+	}
+	return s.next.SetAccountKYCStatus(ctx, id, status)
+}
+
+// UpdateDormantAccounts injects a fault into UpdateDormantAccounts of the wrapped storage.
+func (s *FaultyStorage) UpdateDormantAccounts(ctx context.Context, inactiveSince time.Time) (dormant []int, reactivated []int, err error) {
+	if err = s.strike(ctx, "UpdateDormantAccounts"); err != nil {
+		return dormant, reactivated, err
+	}
+	return s.next.UpdateDormantAccounts(ctx, inactiveSince)
+}
+
+// CreateKYCCheck injects a fault into CreateKYCCheck of the wrapped storage.
+func (s *FaultyStorage) CreateKYCCheck(ctx context.Context, check *KYCCheck) error {
+	if err := s.strike(ctx, "CreateKYCCheck"); err != nil {
+		return err
+	}
+	return s.next.CreateKYCCheck(ctx, check)
+}
+
+// GetKYCCheck injects a fault into GetKYCCheck of the wrapped storage.
+func (s *FaultyStorage) GetKYCCheck(ctx context.Context, accountID int) (check *KYCCheck, err error) {
+	if err = s.strike(ctx, "GetKYCCheck"); err != nil {
+		return nil, err
+	}
+	return s.next.GetKYCCheck(ctx, accountID)
+}
+
+// GetKYCCheckByProviderID injects a fault into GetKYCCheckByProviderID of the wrapped storage.
+func (s *FaultyStorage) GetKYCCheckByProviderID(ctx context.Context, providerCheckID string) (check *KYCCheck, err error) {
+	if err = s.strike(ctx, "GetKYCCheckByProviderID"); err != nil {
+		return nil, err
+	}
+	return s.next.GetKYCCheckByProviderID(ctx, providerCheckID)
+}
+
+// GetOpenKYCChecks injects a fault into GetOpenKYCChecks of the wrapped storage.
+func (s *FaultyStorage) GetOpenKYCChecks(ctx context.Context, limit int) (checks []*KYCCheck, err error) {
+	if err = s.strike(ctx, "GetOpenKYCChecks"); err != nil {
+		return nil, err
+	}
+	return s.next.GetOpenKYCChecks(ctx, limit)
+}
+
+// UpdateKYCCheck injects a fault into UpdateKYCCheck of the wrapped storage.
+func (s *FaultyStorage) UpdateKYCCheck(ctx context.Context, check *KYCCheck, fromStatus string) error {
+	if err := s.strike(ctx, "UpdateKYCCheck"); err != nil {
+		return err
+	}
+	return s.next.UpdateKYCCheck(ctx, check, fromStatus)
+}
+
+// AddOutboxEvents injects a fault into AddOutboxEvents of the wrapped storage.
+func (s *FaultyStorage) AddOutboxEvents(ctx context.Context, events []*OutboxEvent) error {
+	if err := s.strike(ctx, "AddOutboxEvents"); err != nil {
+		return err
+	}
+	return s.next.AddOutboxEvents(ctx, events)
+}
+
+// GetUnpublishedOutboxEvents injects a fault into GetUnpublishedOutboxEvents of the wrapped storage.
+func (s *FaultyStorage) GetUnpublishedOutboxEvents(ctx context.Context, limit int) (events []*OutboxEvent, err error) {
+	if err = s.strike(ctx, "GetUnpublishedOutboxEvents"); err != nil {
+		return events, err
+	}
+	return s.next.GetUnpublishedOutboxEvents(ctx, limit)
+}
+
+// MarkOutboxEventsPublished injects a fault into MarkOutboxEventsPublished of the wrapped storage.
+func (s *FaultyStorage) MarkOutboxEventsPublished(ctx context.Context, ids []int) error {
+	if err := s.strike(ctx, "MarkOutboxEventsPublished"); err != nil {
+		return err
+	}
+	return s.next.MarkOutboxEventsPublished(ctx, ids)
+}
+
+// CreateWebhookSecret injects a fault into CreateWebhookSecret of the wrapped storage.
+func (s *FaultyStorage) CreateWebhookSecret(ctx context.Context, secret *WebhookSecret) error {
+	if err := s.strike(ctx, "CreateWebhookSecret"); err != nil {
+		return err
+	}
+	return s.next.CreateWebhookSecret(ctx, secret)
+}
+
+// GetWebhookSecrets injects a fault into GetWebhookSecrets of the wrapped storage.
+func (s *FaultyStorage) GetWebhookSecrets(ctx context.Context, provider string) (secrets []*WebhookSecret, err error) {
+	if err = s.strike(ctx, "GetWebhookSecrets"); err != nil {
+		return nil, err
+	}
+	return s.next.GetWebhookSecrets(ctx, provider)
+}
+
+// ExpireWebhookSecret injects a fault into ExpireWebhookSecret of the wrapped storage.
+func (s *FaultyStorage) ExpireWebhookSecret(ctx context.Context, id int, at time.Time) (secret *WebhookSecret, err error) {
+	if err = s.strike(ctx, "ExpireWebhookSecret"); err != nil {
+		return nil, err
+	}
+	return s.next.ExpireWebhookSecret(ctx, id, at)
+}
+
+// GetPublishedOutboxEvents injects a fault into GetPublishedOutboxEvents of the wrapped storage.
+func (s *FaultyStorage) GetPublishedOutboxEvents(ctx context.Context, filter OutboxEventFilter, afterID, limit int) (events []*OutboxEvent, err error) {
+	if err = s.strike(ctx, "GetPublishedOutboxEvents"); err != nil {
+		return events, err
+	}
+	return s.next.GetPublishedOutboxEvents(ctx, filter, afterID, limit)
+}
+
+// DeletePublishedOutboxEvents injects a fault into DeletePublishedOutboxEvents of the wrapped storage.
+func (s *FaultyStorage) DeletePublishedOutboxEvents(ctx context.Context, before time.Time, throughID int) (n int, err error) {
+	if err = s.strike(ctx, "DeletePublishedOutboxEvents"); err != nil {
+		return n, err
+	}
+	return s.next.DeletePublishedOutboxEvents(ctx, before, throughID)
+}
+
+// RegisterScheduledJob injects a fault into RegisterScheduledJob of the wrapped storage.
+func (s *FaultyStorage) RegisterScheduledJob(ctx context.Context, job *ScheduledJob) error {
+	if err := s.strike(ctx, "RegisterScheduledJob"); err != nil {
+		return err
+	}
+	return s.next.RegisterScheduledJob(ctx, job)
+}
+
+// GetScheduledJobs injects a fault into GetScheduledJobs of the wrapped storage.
+func (s *FaultyStorage) GetScheduledJobs(ctx context.Context) (jobs []*ScheduledJob, err error) {
+	if err = s.strike(ctx, "GetScheduledJobs"); err != nil {
+		return jobs, err
+	}
+	return s.next.GetScheduledJobs(ctx)
+}
+
+// GetScheduledJob injects a fault into GetScheduledJob of the wrapped storage.
+func (s *FaultyStorage) GetScheduledJob(ctx context.Context, name string) (job *ScheduledJob, err error) {
+	if err = s.strike(ctx, "GetScheduledJob"); err != nil {
+		return job, err
+	}
+	return s.next.GetScheduledJob(ctx, name)
+}
+
+// UpdateScheduledJob injects a fault into UpdateScheduledJob of the wrapped storage.
+func (s *FaultyStorage) UpdateScheduledJob(ctx context.Context, job *ScheduledJob) error {
+	if err := s.strike(ctx, "UpdateScheduledJob"); err != nil {
+		return err
+	}
+	return s.next.UpdateScheduledJob(ctx, job)
+}
+
+// LockScheduledJob injects a fault into LockScheduledJob of the wrapped storage.
+func (s *FaultyStorage) LockScheduledJob(ctx context.Context, name, owner string, now, until time.Time) (job *ScheduledJob, err error) {
+	if err = s.strike(ctx, "LockScheduledJob"); err != nil {
+		return job, err
+	}
+	return s.next.LockScheduledJob(ctx, name, owner, now, until)
+}
+
+// UnlockScheduledJob injects a fault into UnlockScheduledJob of the wrapped storage.
+func (s *FaultyStorage) UnlockScheduledJob(ctx context.Context, name, owner string, lastRunAt, nextRunAt *time.Time) error {
+	if err := s.strike(ctx, "UnlockScheduledJob"); err != nil {
+		return err
+	}
+	return s.next.UnlockScheduledJob(ctx, name, owner, lastRunAt, nextRunAt)
+}
+
+// CreateJobRun injects a fault into CreateJobRun of the wrapped storage.
+func (s *FaultyStorage) CreateJobRun(ctx context.Context, run *JobRun) error {
+	if err := s.strike(ctx, "CreateJobRun"); err != nil {
+		return err
+	}
+	return s.next.CreateJobRun(ctx, run)
+}
+
+// FinishJobRun injects a fault into FinishJobRun of the wrapped storage.
+func (s *FaultyStorage) FinishJobRun(ctx context.Context, run *JobRun) error {
+	if err := s.strike(ctx, "FinishJobRun"); err != nil {
+		return err
+	}
+	return s.next.FinishJobRun(ctx, run)
+}
+
+// GetJobRuns injects a fault into GetJobRuns of the wrapped storage.
+func (s *FaultyStorage) GetJobRuns(ctx context.Context, job string, limit int) (runs []*JobRun, err error) {
+	if err = s.strike(ctx, "GetJobRuns"); err != nil {
+		return runs, err
+	}
+	return s.next.GetJobRuns(ctx, job, limit)
+}
+
+// ReserveIdempotencyKey injects a fault into ReserveIdempotencyKey of the wrapped storage.
+func (s *FaultyStorage) ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) (stored *IdempotencyRecord, err error) {
+	if err = s.strike(ctx, "ReserveIdempotencyKey"); err != nil {
+		return stored, err
+	}
+	return s.next.ReserveIdempotencyKey(ctx, record)
+}
+
+// CompleteIdempotencyKey injects a fault into CompleteIdempotencyKey of the wrapped storage.
+func (s *FaultyStorage) CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	if err := s.strike(ctx, "CompleteIdempotencyKey"); err != nil {
+		return err
+	}
+	return s.next.CompleteIdempotencyKey(ctx, record)
+}
+
+// ReleaseIdempotencyKey injects a fault into ReleaseIdempotencyKey of the wrapped storage.
+func (s *FaultyStorage) ReleaseIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	if err := s.strike(ctx, "ReleaseIdempotencyKey"); err != nil {
+		return err
+	}
+	return s.next.ReleaseIdempotencyKey(ctx, record)
+}
+
+// DeleteExpiredIdempotencyKeys injects a fault into DeleteExpiredIdempotencyKeys of the wrapped storage.
+func (s *FaultyStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (n int, err error) {
+	if err = s.strike(ctx, "DeleteExpiredIdempotencyKeys"); err != nil {
+		return n, err
+	}
+	return s.next.DeleteExpiredIdempotencyKeys(ctx, now)
+}
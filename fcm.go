@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Default FCM Settings
+const (
+	defaultFCMAPIURL = "https://fcm.googleapis.com"
+	fcmScope         = "https://www.googleapis.com/auth/firebase.messaging"
+	// fcmTokenLifetime is the lifetime asked for the access tokens, the longest Google grants.
+	fcmTokenLifetime = time.Hour
+	// fcmTokenMargin renews an access token that far ahead of its expiry.
+	fcmTokenMargin = time.Minute
+)
+
+// fcmCredentials are the fields of a service account key file used to authenticate.
+type fcmCredentials struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// FCMSender pushes the notifications to Android devices (and web or iOS apps using
+// Firebase) through the HTTP v1 API of Firebase Cloud Messaging, authenticated as a
+// service account.
+type FCMSender struct {
+	cfg    FCMConfig
+	creds  fcmCredentials
+	key    *rsa.PrivateKey
+	client *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCMSender creates a sender authenticated with the service account key file of cfg.
+//
+// Parameters:
+//   - cfg: The FCM settings, with the path of the service account key file.
+//   - timeout: The timeout of a request to Google.
+//
+// Returns:
+//   - *FCMSender: The sender.
+//   - error: An error if the key file cannot be read or parsed.
+func NewFCMSender(cfg FCMConfig, timeout time.Duration) (*FCMSender, error) {
+	data, err := os.ReadFile(cfg.CredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds fcmCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", cfg.CredentialsFile, err)
+	}
+	if creds.ClientEmail == "" || creds.PrivateKey == "" || creds.TokenURI == "" {
+		return nil, fmt.Errorf("%s is not a service account key file", cfg.CredentialsFile)
+	}
+	if cfg.ProjectID == "" {
+		cfg.ProjectID = creds.ProjectID
+	}
+	if cfg.ProjectID == "" {
+		return nil, fmt.Errorf("%s names no project, set notifications.push.fcm.project_id", cfg.CredentialsFile)
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(creds.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("parsing the private key of %s: %w", cfg.CredentialsFile, err)
+	}
+
+	return &FCMSender{cfg: cfg, creds: creds, key: key, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Send pushes a notification to the device of its registration token.
+//
+// Parameters:
+//   - ctx: The context of the requests to Google.
+//   - notification: The notification, with its token, title, and body.
+//
+// Returns:
+//   - error: errPushTokenInvalid if the token is no longer registered, or an error if
+//     FCM cannot be reached or refuses the message.
+func (s *FCMSender) Send(ctx context.Context, notification *Notification) error {
+	token, err := s.token(ctx)
+	if err != nil {
+		return fmt.Errorf("authenticating with FCM: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"message": map[string]any{
+			"token":        notification.To,
+			"notification": map[string]string{"title": notification.Subject, "body": notification.Body},
+			"data":         map[string]string{"kind": notification.Kind},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := s.cfg.APIURL + "/v1/projects/" + url.PathEscape(s.cfg.ProjectID) + "/messages:send"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	var apiErr struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || apiErr.Error.Status == "UNREGISTERED":
+		return errPushTokenInvalid
+	case resp.StatusCode == http.StatusUnauthorized:
+		// Drop The Access Token, Revoked Or Expired Early
+		s.mu.Lock()
+		s.accessToken = ""
+		s.mu.Unlock()
+	}
+	return fmt.Errorf("fcm refused the message with status %d: %s (%s)", resp.StatusCode, apiErr.Error.Message, apiErr.Error.Status)
+}
+
+// token returns an OAuth 2.0 access token of the service account, exchanging a signed
+// assertion for a new one when the cached token is about to expire.
+func (s *FCMSender) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.accessToken != "" && now.Add(fcmTokenMargin).Before(s.expiresAt) {
+		return s.accessToken, nil
+	}
+
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   s.creds.ClientEmail,
+		"scope": fcmScope,
+		"aud":   s.creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(fcmTokenLifetime).Unix(),
+	}).SignedString(s.key)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.creds.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var grant struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&grant); err != nil && resp.StatusCode/100 == 2 {
+		return "", fmt.Errorf("decoding the access token: %w", err)
+	}
+	if resp.StatusCode/100 != 2 || grant.AccessToken == "" {
+		return "", fmt.Errorf("the token endpoint answered with status %d: %s %s", resp.StatusCode, grant.Error, grant.ErrorDescription)
+	}
+
+	s.accessToken = grant.AccessToken
+	s.expiresAt = now.Add(time.Duration(grant.ExpiresIn) * time.Second)
+	return s.accessToken, nil
+}
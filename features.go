@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Feature Flags, Off Unless Enabled In The Configuration Or By An Override
+const (
+	FeatureExternalTransfers = "external_transfers"
+	FeatureFeeEngine         = "fee_engine"
+	// FeatureOpenBanking lets the account holders give the aggregators access, see openbanking.go.
+	FeatureOpenBanking = "open_banking"
+)
+
+// knownFeatures lists the flags that can be configured and overridden, so a typo in a
+// flag name is rejected instead of silently doing nothing.
+var knownFeatures = []string{FeatureExternalTransfers, FeatureFeeEngine, FeatureOpenBanking}
+
+// Feature Flag Sources
+const (
+	FeatureSourceConfig   = "config"
+	FeatureSourceOverride = "override"
+)
+
+// Audit Actions Of The Feature Flags
+const (
+	AuditActionSetFeature   = "feature.set"
+	AuditActionResetFeature = "feature.reset"
+)
+
+// defaultFeatureRefreshInterval is how often the overrides are reloaded from the store,
+// picking up the changes made through the other instances.
+const defaultFeatureRefreshInterval = 30 * time.Second
+
+// FeatureFlag is an override of a flag stored in the database. It takes precedence over
+// the configuration and enables the flag for RolloutPercent percent of the accounts.
+type FeatureFlag struct {
+	Name           string    `json:"name"`
+	Enabled        bool      `json:"enabled"`
+	RolloutPercent int       `json:"rollout_percent"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// FeatureFlagStatus is the effective state of a flag, as listed by GET /admin/features.
+type FeatureFlagStatus struct {
+	Name           string     `json:"name"`
+	Enabled        bool       `json:"enabled"`
+	RolloutPercent int        `json:"rollout_percent"`
+	Source         string     `json:"source"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+// FeatureFlagRequest is the body of PUT /admin/features/{name}.
+type FeatureFlagRequest struct {
+	Enabled *bool `json:"enabled"`
+	// RolloutPercent is the share of the accounts the flag is enabled for, 100 when omitted.
+	RolloutPercent *int `json:"rollout_percent"`
+}
+
+// Validate checks the fields of a feature flag request.
+func (req *FeatureFlagRequest) Validate() []FieldError {
+	var errs []FieldError
+	if req.Enabled == nil {
+		errs = append(errs, FieldError{Field: "enabled", Code: CodeRequired, Message: "enabled is required"})
+	}
+	if req.RolloutPercent != nil && (*req.RolloutPercent < 0 || *req.RolloutPercent > 100) {
+		errs = append(errs, FieldError{Field: "rollout_percent", Code: CodeInvalid, Message: "rollout_percent must be between 0 and 100"})
+	}
+	return errs
+}
+
+// featureFlags answers whether a feature is enabled. The configured values apply unless
+// the store holds an override, which is kept in memory and reloaded periodically, so
+// checking a flag never waits on the database.
+type featureFlags struct {
+	store FeatureFlagRepository
+
+	mu        sync.RWMutex
+	defaults  map[string]bool
+	overrides map[string]FeatureFlag
+}
+
+// newFeatureFlags creates the flags with the configured values and the store of the
+// overrides. The overrides are loaded by Refresh.
+func newFeatureFlags(defaults map[string]bool, store FeatureFlagRepository) *featureFlags {
+	return &featureFlags{defaults: defaults, store: store, overrides: map[string]FeatureFlag{}}
+}
+
+// Enabled reports whether a feature is enabled for an account. A flag rolled out to part
+// of the accounts is enabled for the same accounts on every instance, and for more of
+// them, never others, as the percentage grows.
+//
+// Parameters:
+//   - name: The flag, one of the Feature constants.
+//   - accountID: The account the feature is used for.
+//
+// Returns:
+//   - bool: Whether the feature is enabled.
+func (f *featureFlags) Enabled(name string, accountID int) bool {
+	f.mu.RLock()
+	enabled := f.defaults[name]
+	override, ok := f.overrides[name]
+	f.mu.RUnlock()
+
+	if !ok {
+		return enabled
+	}
+	if !override.Enabled {
+		return false
+	}
+	return override.RolloutPercent >= 100 || rolloutBucket(name, accountID) < override.RolloutPercent
+}
+
+// rolloutBucket places an account in one of 100 buckets of a flag.
+func rolloutBucket(name string, accountID int) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + strconv.Itoa(accountID)))
+	return int(h.Sum32() % 100)
+}
+
+// Statuses returns the effective state of every known flag.
+func (f *featureFlags) Statuses() []*FeatureFlagStatus {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	statuses := make([]*FeatureFlagStatus, 0, len(knownFeatures))
+	for _, name := range knownFeatures {
+		status := &FeatureFlagStatus{Name: name, Enabled: f.defaults[name], Source: FeatureSourceConfig}
+		if status.Enabled {
+			status.RolloutPercent = 100
+		}
+		if override, ok := f.overrides[name]; ok {
+			status.Enabled, status.RolloutPercent, status.Source = override.Enabled, override.RolloutPercent, FeatureSourceOverride
+			status.UpdatedAt = &override.UpdatedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// SetDefaults replaces the configured values, when the configuration is reloaded.
+func (f *featureFlags) SetDefaults(defaults map[string]bool) {
+	f.mu.Lock()
+	f.defaults = defaults
+	f.mu.Unlock()
+}
+
+// Refresh reloads the overrides from the store.
+func (f *featureFlags) Refresh(ctx context.Context) error {
+	flags, err := f.store.GetFeatureFlags(ctx)
+	if err != nil {
+		return err
+	}
+
+	overrides := make(map[string]FeatureFlag, len(flags))
+	for _, flag := range flags {
+		overrides[flag.Name] = *flag
+	}
+
+	f.mu.Lock()
+	f.overrides = overrides
+	f.mu.Unlock()
+	return nil
+}
+
+// Run reloads the overrides every interval until ctx is cancelled.
+func (f *featureFlags) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := f.Refresh(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error Loading Feature Flags", "error", err)
+		}
+	}
+}
+
+// Set stores an override and applies it on this instance at once.
+func (f *featureFlags) Set(ctx context.Context, flag *FeatureFlag) error {
+	if err := f.store.SetFeatureFlag(ctx, flag); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.overrides[flag.Name] = *flag
+	f.mu.Unlock()
+	return nil
+}
+
+// Reset removes the override of a flag, so the configured value applies again.
+func (f *featureFlags) Reset(ctx context.Context, name string) error {
+	if err := f.store.DeleteFeatureFlag(ctx, name); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	delete(f.overrides, name)
+	f.mu.Unlock()
+	return nil
+}
+
+// registerFeatureRoutes registers the feature flag endpoints on the admin subrouter.
+//
+// Routes:
+// - GET /admin/features: Lists the effective state of every flag.
+// - PUT /admin/features/{name}: Overrides a flag, for a share of the accounts with rollout_percent.
+// - DELETE /admin/features/{name}: Removes the override of a flag.
+func (as *APIServer) registerFeatureRoutes(admin *mux.Router) {
+	admin.HandleFunc("/features", makeHTTPHandlerFunc(as.handleAdminListFeatures)).Methods(http.MethodGet)
+	admin.HandleFunc("/features/{name}", makeHTTPHandlerFunc(as.handleAdminSetFeature)).Methods(http.MethodPut)
+	admin.HandleFunc("/features/{name}", makeHTTPHandlerFunc(as.handleAdminResetFeature)).Methods(http.MethodDelete)
+}
+
+// handleAdminListFeatures returns the effective state of every flag and where it comes from.
+func (as *APIServer) handleAdminListFeatures(w http.ResponseWriter, r *http.Request) error {
+	return WriteResponse(w, r, http.StatusOK, as.featureFlags.Statuses())
+}
+
+// handleAdminSetFeature overrides a flag and records the change in the audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the flag name and the override.
+//
+// Returns:
+//   - error: A TypedError if the flag is unknown, the body is invalid, or the override cannot be stored.
+func (as *APIServer) handleAdminSetFeature(w http.ResponseWriter, r *http.Request) error {
+	name, err := featureFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	flagReq := new(FeatureFlagRequest)
+	if err := bindJSON(w, r, flagReq); err != nil {
+		return err
+	}
+
+	flag := &FeatureFlag{Name: name, Enabled: *flagReq.Enabled, RolloutPercent: 100}
+	if flagReq.RolloutPercent != nil {
+		flag.RolloutPercent = *flagReq.RolloutPercent
+	}
+
+	if err := as.featureFlags.Set(r.Context(), flag); err != nil {
+		return NewTypedError(http.StatusInternalServerError, "internal_error", "could not store the feature flag")
+	}
+
+	as.audit(r, AuditActionSetFeature, "feature:"+name, map[string]interface{}{
+		"enabled":         flag.Enabled,
+		"rollout_percent": flag.RolloutPercent,
+	})
+
+	return WriteResponse(w, r, http.StatusOK, flag)
+}
+
+// handleAdminResetFeature removes the override of a flag and records it in the audit log.
+func (as *APIServer) handleAdminResetFeature(w http.ResponseWriter, r *http.Request) error {
+	name, err := featureFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	if err := as.featureFlags.Reset(r.Context(), name); err != nil {
+		return NewTypedError(http.StatusInternalServerError, "internal_error", "could not reset the feature flag")
+	}
+
+	as.audit(r, AuditActionResetFeature, "feature:"+name, nil)
+
+	return WriteResponse(w, r, http.StatusOK, map[string]string{
+		"reset": name,
+	})
+}
+
+// featureFromRequest returns the flag named in the request URL, if it is known.
+func featureFromRequest(r *http.Request) (string, error) {
+	name := mux.Vars(r)["name"]
+	if !slices.Contains(knownFeatures, name) {
+		return "", NewTypedError(http.StatusNotFound, "feature_not_found", fmt.Sprintf("unknown feature flag %q", name))
+	}
+	return name, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// parseFields returns the set of fields requested with the "fields" query parameter
+// (e.g. ?fields=id,balance), or nil when all fields should be returned.
+func parseFields(r *http.Request) map[string]bool {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil
+	}
+
+	fields := make(map[string]bool)
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields[field] = true
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return fields
+}
+
+// selectFields reduces a response body to the requested fields. It works on the JSON
+// representation, so field names are the public ones. Lists are reduced item by item
+// and pagination envelopes keep their page metadata while their data is reduced.
+//
+// Parameters:
+//   - data: The response body.
+//   - fields: The set of field names to keep.
+//
+// Returns:
+//   - interface{}: The reduced body, made of maps, slices, and scalar values.
+//   - error: An error if the body cannot be converted.
+func selectFields(data interface{}, fields map[string]bool) (interface{}, error) {
+	tree, err := toGeneric(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pagination Envelopes Keep Their Metadata
+	if envelope, ok := tree.(map[string]interface{}); ok {
+		if _, hasPage := envelope["page"]; hasPage {
+			if inner, hasData := envelope["data"]; hasData {
+				envelope["data"] = projectFields(inner, fields)
+				return envelope, nil
+			}
+		}
+	}
+
+	return projectFields(tree, fields), nil
+}
+
+// projectFields keeps only the requested keys of an object, or of every object in a list.
+func projectFields(value interface{}, fields map[string]bool) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		for i, item := range v {
+			v[i] = projectFields(item, fields)
+		}
+		return v
+	case map[string]interface{}:
+		for key := range v {
+			if !fields[key] {
+				delete(v, key)
+			}
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// toGeneric converts a value to its JSON shape of maps, slices, and scalars.
+// Integers stay int64 so large account numbers do not lose precision.
+func toGeneric(data interface{}) (interface{}, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	return normalizeNumbers(tree), nil
+}
+
+// normalizeNumbers replaces json.Number values with int64 or float64 so every encoder
+// (not only JSON) serializes them as numbers.
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+		return v
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeNumbers(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFilterValues is the maximum number of values a list filter such as "type" accepts.
+const maxFilterValues = 20
+
+// TransactionFilter narrows a transaction listing. Unset fields do not filter, set
+// fields are combined with AND. Amounts are compared as signed ledger amounts, so
+// credits are positive and debits negative.
+type TransactionFilter struct {
+	AmountGte      *int64
+	AmountLte      *int64
+	Types          []string
+	Categories     []string
+	CounterpartyID *int
+	// CreatedAfter is the inclusive lower bound of the creation time.
+	CreatedAfter time.Time
+	// CreatedBefore is the exclusive upper bound of the creation time.
+	CreatedBefore time.Time
+}
+
+// parseTransactionFilter reads the transaction filter from the query parameters:
+//
+//   - amount_gte, amount_lte: Bounds on the amount.
+//   - type, category: One or more values, repeated or comma separated.
+//   - counterparty_id: The ID of the other account of a transfer.
+//   - created_after, created_before: A date (2024-01-31) or an RFC 3339 timestamp.
+//
+// Other query parameters are left to the caller.
+//
+// Parameters:
+//   - r: *http.Request containing the filter query parameters.
+//
+// Returns:
+//   - *TransactionFilter: The parsed filter, or nil if the request does not filter.
+//   - error: A TypedError listing every invalid parameter.
+func parseTransactionFilter(r *http.Request) (*TransactionFilter, error) {
+	query := r.URL.Query()
+	filter := &TransactionFilter{}
+	fieldErrs := []FieldError{}
+	filtered := false
+
+	invalid := func(field, message string) {
+		fieldErrs = append(fieldErrs, FieldError{Field: field, Code: CodeInvalid, Message: message})
+	}
+
+	// Amount Bounds
+	for _, bound := range []struct {
+		name string
+		dst  **int64
+	}{{"amount_gte", &filter.AmountGte}, {"amount_lte", &filter.AmountLte}} {
+		name, dst := bound.name, bound.dst
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			invalid(name, fmt.Sprintf("%s must be an integer", name))
+			continue
+		}
+		*dst = &parsed
+		filtered = true
+	}
+
+	// Value Lists
+	for _, list := range []struct {
+		name string
+		dst  *[]string
+	}{{"type", &filter.Types}, {"category", &filter.Categories}} {
+		name, dst := list.name, list.dst
+		values := filterValues(query[name])
+		if len(values) > maxFilterValues {
+			invalid(name, fmt.Sprintf("%s accepts at most %d values", name, maxFilterValues))
+			continue
+		}
+		if len(values) > 0 {
+			*dst = values
+			filtered = true
+		}
+	}
+
+	if value := query.Get("counterparty_id"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			invalid("counterparty_id", "counterparty_id must be a positive integer")
+		} else {
+			filter.CounterpartyID = &parsed
+			filtered = true
+		}
+	}
+
+	// Time Range
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+	}{{"created_after", &filter.CreatedAfter}, {"created_before", &filter.CreatedBefore}} {
+		name, dst := bound.name, bound.dst
+		parsed, err := parseCSVTime(query.Get(name), false)
+		if err != nil {
+			invalid(name, fmt.Sprintf("%s must be a date (YYYY-MM-DD) or an RFC 3339 timestamp", name))
+			continue
+		}
+		if !parsed.IsZero() {
+			*dst = parsed
+			filtered = true
+		}
+	}
+
+	if filter.AmountGte != nil && filter.AmountLte != nil && *filter.AmountGte > *filter.AmountLte {
+		invalid("amount_gte", "amount_gte must not be greater than amount_lte")
+	}
+	if !filter.CreatedAfter.IsZero() && !filter.CreatedBefore.IsZero() && !filter.CreatedAfter.Before(filter.CreatedBefore) {
+		invalid("created_after", "created_after must be before created_before")
+	}
+
+	if len(fieldErrs) > 0 {
+		typedErr := NewTypedError(http.StatusBadRequest, "invalid_filter", "the transaction filter is invalid")
+		typedErr.Fields = fieldErrs
+		return nil, typedErr
+	}
+
+	if !filtered {
+		return nil, nil
+	}
+
+	return filter, nil
+}
+
+// filterValues splits repeated and comma separated query values, dropping empty ones.
+func filterValues(raw []string) []string {
+	values := []string{}
+	for _, item := range raw {
+		for _, value := range strings.Split(item, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// matches reports whether the transaction meets every condition of the filter.
+// A nil filter matches every transaction.
+func (f *TransactionFilter) matches(t *Transaction) bool {
+	if f == nil {
+		return true
+	}
+	if f.AmountGte != nil && t.Amount.Amount < *f.AmountGte {
+		return false
+	}
+	if f.AmountLte != nil && t.Amount.Amount > *f.AmountLte {
+		return false
+	}
+	if len(f.Types) > 0 && !slices.Contains(f.Types, t.Type) {
+		return false
+	}
+	if len(f.Categories) > 0 && !slices.Contains(f.Categories, t.Category) {
+		return false
+	}
+	if f.CounterpartyID != nil && t.CounterpartyID != *f.CounterpartyID {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && t.CreatedAt.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !t.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+	"github.com/moabdelazem/gobank/money"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Default Exchange Rate Settings
+const (
+	// defaultFXAPIURL is the Frankfurter API, serving the reference rates of the European
+	// Central Bank, updated once per working day, without an API key.
+	defaultFXAPIURL       = "https://api.frankfurter.dev/v1"
+	defaultFXRateTTL      = time.Hour
+	defaultFXMaxStaleness = 24 * time.Hour
+	defaultFXTimeout      = 10 * time.Second
+)
+
+// ErrRatesUnavailable is returned when the rates can neither be fetched nor served from
+// a cache younger than the staleness limit.
+var ErrRatesUnavailable = errors.New("exchange rates are unavailable")
+
+// errUnknownCurrency is returned by the rate providers for a currency they have no rates for.
+var errUnknownCurrency = errors.New("unknown currency")
+
+// fxProviderUp and fxRateFallbacks report the health of the rate provider, registered
+// by newMetricsRegistry.
+var (
+	fxProviderUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace, Subsystem: "fx", Name: "provider_up",
+		Help: "Whether the last request to the exchange rate provider succeeded.",
+	})
+	fxRateFallbacks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Subsystem: "fx", Name: "fallbacks_total",
+		Help: "Number of times the last known rates were served because the provider was down.",
+	})
+)
+
+// ExchangeRates are the rates of one unit of a base currency in the other currencies.
+type ExchangeRates struct {
+	Base string `json:"base"`
+	// Date is the day the provider published the rates for.
+	Date      string             `json:"date"`
+	Rates     map[string]float64 `json:"rates"`
+	FetchedAt time.Time          `json:"fetched_at"`
+	// Stale is set on the last known rates, served while the provider is down.
+	Stale bool `json:"stale"`
+}
+
+// RateProvider fetches the current exchange rates of a base currency.
+type RateProvider interface {
+	Rates(ctx context.Context, base string) (*ExchangeRates, error)
+}
+
+// ConversionResponse is the response of GET /api/v1/fx/convert.
+type ConversionResponse struct {
+	From      string      `json:"from"`
+	To        string      `json:"to"`
+	Amount    money.Money `json:"amount"`
+	Converted money.Money `json:"converted"`
+	Rate      float64     `json:"rate"`
+	Date      string      `json:"date"`
+	Stale     bool        `json:"stale"`
+}
+
+// FrankfurterProvider fetches the rates from the Frankfurter API, or a compatible one.
+type FrankfurterProvider struct {
+	apiURL string
+	client *http.Client
+}
+
+// NewFrankfurterProvider creates a provider for the configured API.
+func NewFrankfurterProvider(cfg FXConfig) *FrankfurterProvider {
+	return &FrankfurterProvider{apiURL: strings.TrimSuffix(cfg.APIURL, "/"), client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// Rates fetches the latest rates of base.
+//
+// Parameters:
+//   - ctx: The context of the request to the API.
+//   - base: The ISO code of the base currency, such as USD.
+//
+// Returns:
+//   - *ExchangeRates: The rates, fetched now.
+//   - error: errUnknownCurrency if the API has no rates for base, or an error if it
+//     cannot be reached or answers unexpectedly.
+func (p *FrankfurterProvider) Rates(ctx context.Context, base string) (*ExchangeRates, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiURL+"/latest?base="+url.QueryEscape(base), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity:
+		return nil, fmt.Errorf("%w: %s", errUnknownCurrency, base)
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("the exchange rate API answered with status %d", resp.StatusCode)
+	}
+
+	rates := &ExchangeRates{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(rates); err != nil {
+		return nil, fmt.Errorf("decoding the exchange rates: %w", err)
+	}
+	if rates.Base != base || len(rates.Rates) == 0 {
+		return nil, fmt.Errorf("the exchange rate API sent no rates for %s", base)
+	}
+
+	rates.FetchedAt = time.Now().UTC()
+	return rates, nil
+}
+
+// cachedRateProvider keeps the rates of every base currency for ttl. When the provider
+// is down, it falls back to the last known rates until they are maxStaleness old, and
+// alerts the operators once per outage.
+type cachedRateProvider struct {
+	next         RateProvider
+	ttl          time.Duration
+	maxStaleness time.Duration
+
+	// mu is held while fetching, so concurrent requests wait for one fetch.
+	mu      sync.Mutex
+	entries map[string]*ExchangeRates
+	down    bool
+}
+
+// newCachedRateProvider wraps a provider with the cache and fallback of cfg.
+func newCachedRateProvider(next RateProvider, cfg FXConfig) *cachedRateProvider {
+	fxProviderUp.Set(1)
+	return &cachedRateProvider{next: next, ttl: cfg.RateTTL, maxStaleness: cfg.MaxStaleness, entries: map[string]*ExchangeRates{}}
+}
+
+// Rates returns the cached rates of base while they are fresh, fetching them otherwise.
+//
+// Parameters:
+//   - ctx: The context of the request to the provider.
+//   - base: The ISO code of the base currency.
+//
+// Returns:
+//   - *ExchangeRates: A copy of the rates, Stale when they are the last known ones.
+//   - error: errUnknownCurrency from the provider, or ErrRatesUnavailable when the
+//     provider is down and no rates younger than the staleness limit are known.
+func (c *cachedRateProvider) Rates(ctx context.Context, base string) (*ExchangeRates, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.entries[base]
+	if ok && time.Since(cached.FetchedAt) < c.ttl {
+		return cached.copy(false), nil
+	}
+
+	rates, err := c.next.Rates(ctx, base)
+	switch {
+	case err == nil:
+		c.recovered(ctx)
+		c.entries[base] = rates
+		return rates.copy(false), nil
+	case errors.Is(err, errUnknownCurrency):
+		c.recovered(ctx)
+		return nil, err
+	case errors.Is(err, context.Canceled):
+		return nil, err
+	}
+
+	c.failed(ctx, base, err)
+	if ok && time.Since(cached.FetchedAt) < c.maxStaleness {
+		fxRateFallbacks.Inc()
+		return cached.copy(true), nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrRatesUnavailable, err)
+}
+
+// failed records a failed fetch, alerting the operators when the provider goes down.
+func (c *cachedRateProvider) failed(ctx context.Context, base string, err error) {
+	fxProviderUp.Set(0)
+	if c.down {
+		slog.WarnContext(ctx, "Exchange Rate Provider Still Down", "base", base, "error", err)
+		return
+	}
+
+	c.down = true
+	slog.ErrorContext(ctx, "Exchange Rate Provider Down, Serving The Last Known Rates", "base", base, "max_staleness", c.maxStaleness, "error", err)
+	sentry.CaptureMessage(fmt.Sprintf("exchange rate provider down: %v", err))
+}
+
+// recovered records a successful request to the provider.
+func (c *cachedRateProvider) recovered(ctx context.Context) {
+	fxProviderUp.Set(1)
+	if c.down {
+		c.down = false
+		slog.InfoContext(ctx, "Exchange Rate Provider Reachable Again")
+	}
+}
+
+// copy returns a copy of the rates that shares no mutable data with them.
+func (rates *ExchangeRates) copy(stale bool) *ExchangeRates {
+	c := *rates
+	c.Rates = make(map[string]float64, len(rates.Rates))
+	for currency, rate := range rates.Rates {
+		c.Rates[currency] = rate
+	}
+	c.Stale = stale
+	return &c
+}
+
+// currencyParam reads an ISO currency code from the query, upper-cased.
+func currencyParam(r *http.Request, name string) (string, *FieldError) {
+	code := strings.ToUpper(r.URL.Query().Get(name))
+	if code == "" {
+		return "", &FieldError{Field: name, Code: CodeRequired, Message: name + " is required"}
+	}
+	if !money.IsCurrencyCode(code) {
+		return "", &FieldError{Field: name, Code: CodeInvalid, Message: name + " must be an ISO 4217 currency code, such as USD"}
+	}
+	return code, nil
+}
+
+// rateError translates the errors of the rate provider into the responses of the fx endpoints.
+func rateError(err error) error {
+	switch {
+	case errors.Is(err, errUnknownCurrency):
+		return NewTypedError(http.StatusNotFound, "currency_not_found", "no exchange rates are known for this currency")
+	case errors.Is(err, ErrRatesUnavailable):
+		return NewTypedError(http.StatusServiceUnavailable, "rates_unavailable", "exchange rates are unavailable, retry later")
+	}
+	return err
+}
+
+// handleGetRates returns the exchange rates of the base currency of ?base=.
+func (as *APIServer) handleGetRates(w http.ResponseWriter, r *http.Request) error {
+	base, fieldErr := currencyParam(r, "base")
+	if fieldErr != nil {
+		return newValidationError([]FieldError{*fieldErr})
+	}
+
+	rates, err := as.rates.Rates(r.Context(), base)
+	if err != nil {
+		return rateError(err)
+	}
+
+	return WriteResponse(w, r, http.StatusOK, rates)
+}
+
+// handleConvert converts ?amount=, in the smallest unit of ?from=, into the smallest
+// unit of ?to=, accounting for the decimals of both in ISO 4217. The rate is applied as
+// the exact decimal the provider sent, and the result rounded half away from zero.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the currencies and the amount.
+//
+// Returns:
+//   - error: A validation error for missing or invalid parameters, a 404 for a currency
+//     without rates, a 422 for a converted amount out of range, or a 503 if the rates are
+//     unavailable.
+func (as *APIServer) handleConvert(w http.ResponseWriter, r *http.Request) error {
+	var fieldErrs []FieldError
+	from, fieldErr := currencyParam(r, "from")
+	if fieldErr != nil {
+		fieldErrs = append(fieldErrs, *fieldErr)
+	}
+	to, fieldErr := currencyParam(r, "to")
+	if fieldErr != nil {
+		fieldErrs = append(fieldErrs, *fieldErr)
+	}
+	amount, err := strconv.ParseInt(r.URL.Query().Get("amount"), 10, 64)
+	if err != nil || amount < 0 {
+		fieldErrs = append(fieldErrs, FieldError{Field: "amount", Code: CodeInvalid, Message: "amount must be a non-negative integer"})
+	}
+	if len(fieldErrs) > 0 {
+		return newValidationError(fieldErrs)
+	}
+
+	conversion := ConversionResponse{From: from, To: to, Amount: money.New(amount, from), Converted: money.New(amount, to), Rate: 1}
+	if from != to {
+		rates, err := as.rates.Rates(r.Context(), from)
+		if err != nil {
+			return rateError(err)
+		}
+		rate, ok := rates.Rates[to]
+		if !ok {
+			return rateError(fmt.Errorf("%w: %s", errUnknownCurrency, to))
+		}
+
+		conversion.Rate, conversion.Date, conversion.Stale = rate, rates.Date, rates.Stale
+
+		// The Shortest Decimal Of The Float Is The Rate As Published
+		exact, ok := new(big.Rat).SetString(strconv.FormatFloat(rate, 'g', -1, 64))
+		if !ok {
+			return rateError(fmt.Errorf("%w: invalid rate %v from %s to %s", ErrRatesUnavailable, rate, from, to))
+		}
+		if conversion.Converted, err = conversion.Amount.Convert(to, exact, money.RoundHalfUp); err != nil {
+			return err
+		}
+	}
+
+	return WriteResponse(w, r, http.StatusOK, conversion)
+}
+
+// registerFXRoutes registers the exchange rate endpoints on the /api/v1 subrouter,
+// enabled by fx.api_url.
+//
+// Routes:
+// - GET /api/v1/fx/rates?base=: Returns the exchange rates of a base currency.
+// - GET /api/v1/fx/convert?from=&to=&amount=: Converts an amount between two currencies.
+func (as *APIServer) registerFXRoutes(subRouter *mux.Router) {
+	if as.rates == nil {
+		return
+	}
+
+	subRouter.HandleFunc("/fx/rates", makeHTTPHandlerFunc(as.handleGetRates)).Methods(http.MethodGet)
+	subRouter.HandleFunc("/fx/convert", makeHTTPHandlerFunc(as.handleConvert)).Methods(http.MethodGet)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Gateway Callback Types, Each Moving The Transfer Of Its Reference To A State
+const (
+	GatewayEventTransferProcessing = "transfer.processing"
+	GatewayEventTransferSettled    = "transfer.settled"
+	GatewayEventTransferFailed     = "transfer.failed"
+)
+
+// gatewayTransferStatuses maps the callback types to the transfer state they move to.
+var gatewayTransferStatuses = map[string]string{
+	GatewayEventTransferProcessing: TransferStatusProcessing,
+	GatewayEventTransferSettled:    TransferStatusCompleted,
+	GatewayEventTransferFailed:     TransferStatusFailed,
+}
+
+// Gateway Callback Results, Reported To The Gateway And Counted By gatewayCallbacks
+const (
+	GatewayCallbackProcessed = "processed"
+	GatewayCallbackDuplicate = "duplicate"
+	GatewayCallbackIgnored   = "ignored"
+)
+
+// Default Gateway Settings
+const (
+	defaultGatewayWebhookTolerance = 5 * time.Minute
+	// gatewaySignatureHeader carries the time and the signatures of a callback, in the
+	// timestamped scheme of webhooks.go; while the secret is rotated, the gateway sends
+	// one v1 signature per secret.
+	gatewaySignatureHeader = "Gateway-Signature"
+)
+
+// Gateway Callback Constraints
+const (
+	gatewayCallbackMaxSize  = 64 << 10
+	gatewayEventIDMaxLen    = 255
+	providerReferenceMaxLen = 255
+)
+
+// gatewayCallbacks counts the callbacks of the payment gateway, registered by newMetricsRegistry.
+var gatewayCallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace, Subsystem: "gateway", Name: "callbacks_total",
+	Help: "Number of payment gateway callbacks received, by type and result.",
+}, []string{"type", "result"})
+
+// GatewayCallback is the body of POST /webhooks/gateway. The callbacks of other types
+// are acknowledged and ignored.
+type GatewayCallback struct {
+	// ID identifies the callback, the same across the deliveries of one callback.
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Reference string `json:"reference"`
+	// FailureReason explains a transfer.failed callback.
+	FailureReason string `json:"failure_reason"`
+}
+
+// Validate checks the fields of a gateway callback.
+func (c *GatewayCallback) Validate() []FieldError {
+	var errs []FieldError
+	if c.ID == "" {
+		errs = append(errs, FieldError{Field: "id", Code: CodeRequired, Message: "id is required"})
+	} else if len(c.ID) > gatewayEventIDMaxLen {
+		errs = append(errs, FieldError{Field: "id", Code: CodeTooLong, Message: fmt.Sprintf("id must be at most %d characters", gatewayEventIDMaxLen)})
+	}
+	if c.Type == "" {
+		errs = append(errs, FieldError{Field: "type", Code: CodeRequired, Message: "type is required"})
+	}
+	if c.Reference == "" {
+		errs = append(errs, FieldError{Field: "reference", Code: CodeRequired, Message: "reference is required"})
+	}
+	return errs
+}
+
+// GatewayEvent is a callback of the payment gateway, recorded so that its repeated
+// deliveries are applied once.
+type GatewayEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	TransferID int       `json:"transfer_id"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// GatewayCallbackResponse is the response of POST /webhooks/gateway.
+type GatewayCallbackResponse struct {
+	Result     string `json:"result"`
+	TransferID int    `json:"transfer_id,omitempty"`
+	Status     string `json:"status,omitempty"`
+}
+
+// handleGatewayCallback applies a callback of the payment gateway to the transfer of
+// its reference: transfer.processing marks the pending transfer processing,
+// transfer.settled moves its funds and completes it, and transfer.failed fails it. The
+// callback and the transition are stored together, so a callback delivered again is
+// acknowledged as a duplicate without effect, and one arriving after a later state,
+// such as transfer.processing after transfer.settled, changes nothing.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the callback, verified by withWebhookSignature.
+//
+// Returns:
+//   - error: A 404 if no transfer has the reference,
+//     a 409 if the callback contradicts the final state of the transfer, or the error
+//     of the store, for the gateway to deliver the callback again.
+func (as *APIServer) handleGatewayCallback(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unknown Fields Are Allowed, The Gateway May Add Some At Any Time
+	callback := new(GatewayCallback)
+	if err := json.Unmarshal(body, callback); err != nil {
+		return NewTypedError(http.StatusBadRequest, "invalid_json", fmt.Sprintf("invalid request body: %s", err))
+	}
+	if fieldErrs := callback.Validate(); len(fieldErrs) > 0 {
+		return newValidationError(fieldErrs)
+	}
+
+	status, known := gatewayTransferStatuses[callback.Type]
+	if !known {
+		gatewayCallbacks.WithLabelValues(callback.Type, GatewayCallbackIgnored).Inc()
+		return WriteResponse(w, r, http.StatusOK, GatewayCallbackResponse{Result: GatewayCallbackIgnored})
+	}
+
+	transfer, err := as.store.GetTransferByProviderReference(r.Context(), callback.Reference)
+	if err != nil {
+		return err
+	}
+
+	event := &GatewayEvent{ID: callback.ID, Type: callback.Type, TransferID: transfer.ID}
+	result := GatewayCallbackProcessed
+	err = as.applyGatewayEvent(context.WithoutCancel(r.Context()), transfer, event, status, callback.FailureReason)
+	if errors.Is(err, ErrGatewayEventDuplicate) {
+		result = GatewayCallbackDuplicate
+	} else if err != nil {
+		return err
+	}
+
+	gatewayCallbacks.WithLabelValues(callback.Type, result).Inc()
+	return WriteResponse(w, r, http.StatusOK, GatewayCallbackResponse{Result: result, TransferID: transfer.ID, Status: transfer.Status})
+}
+
+// applyGatewayEvent records a gateway callback and moves its transfer to status, in one
+// transaction. A settled transfer whose funds cannot be moved is failed instead, with
+// the reason, since the callback was received all the same. Both that and a callback
+// contradicting the final state of the transfer raise a reconciliation_mismatch alert.
+//
+// Parameters:
+//   - ctx: The context of the database work, which should outlive the callback request.
+//   - transfer: The transfer of the callback; it is reloaded and updated in place.
+//   - event: The callback to record.
+//   - status: The state the callback moves the transfer to.
+//   - reason: The failure reason of a transfer.failed callback.
+//
+// Returns:
+//   - error: ErrGatewayEventDuplicate if the callback was already applied, a 409 if the
+//     transfer already reached the other final state, or the error of the store.
+func (as *APIServer) applyGatewayEvent(ctx context.Context, transfer *Transfer, event *GatewayEvent, status, reason string) error {
+	if status == TransferStatusFailed && reason == "" {
+		reason = "the payment gateway failed the transfer"
+	}
+
+	var txns []*Transaction
+	var moveErr error
+	changed := false
+
+	err := as.store.WithTx(ctx, func(tx Storage) error {
+		if err := tx.RecordGatewayEvent(ctx, event); err != nil {
+			return err
+		}
+
+		// Reload The Transfer, Another Callback May Have Moved It Meanwhile
+		current, err := tx.GetTransfer(ctx, transfer.ID)
+		if err != nil {
+			return err
+		}
+		*transfer = *current
+
+		switch {
+		case transfer.Status == status:
+			return nil
+		case transfer.IsFinal() && status != TransferStatusProcessing:
+			return NewTypedError(http.StatusConflict, "transfer_state_conflict", fmt.Sprintf("the transfer is already %s", transfer.Status))
+		case transfer.IsFinal():
+			// A Late Callback Of An Earlier State
+			return nil
+		}
+
+		changed = true
+		if status != TransferStatusCompleted {
+			return tx.UpdateTransferStatus(ctx, transfer, status, reason)
+		}
+		if txns, err = completeTransfer(ctx, tx, transfer); err != nil {
+			moveErr = err
+		}
+		return err
+	})
+
+	if moveErr != nil {
+		slog.ErrorContext(ctx, "Settled Transfer Could Not Be Completed", "transfer_id", transfer.ID, "event_id", event.ID, "error", moveErr)
+		as.alertReconciliationMismatch("Gateway Settled A Transfer The Ledger Could Not Complete",
+			"transfer_id", strconv.Itoa(transfer.ID), "event_id", event.ID, "error", moveErr.Error())
+		return as.applyGatewayEvent(ctx, transfer, event, TransferStatusFailed, moveErr.Error())
+	}
+	var conflict *TypedError
+	if errors.As(err, &conflict) && conflict.Code == "transfer_state_conflict" {
+		as.alertReconciliationMismatch("Gateway Callback Contradicts The Final State Of A Transfer", "transfer_id", strconv.Itoa(transfer.ID),
+			"provider_reference", transfer.ProviderReference, "event_id", event.ID, "ledger_status", transfer.Status, "gateway_status", status)
+	}
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		as.publishTransferStatus(transfer)
+		as.publishTransactions(txns)
+	}
+	return nil
+}
+
+// registerGatewayRoutes registers the callback endpoint of the payment gateway, enabled
+// by gateway.webhook_secret or the stored webhook secrets. The callbacks authenticate
+// with their signature, and moving money they are refused with a 503 while the server
+// shuts down.
+//
+// Routes:
+// - POST /webhooks/gateway: Applies a callback of the payment gateway to its transfer.
+func (as *APIServer) registerGatewayRoutes(router *mux.Router) {
+	if !as.webhookEnabled(WebhookProviderGateway) {
+		return
+	}
+
+	router.HandleFunc("/webhooks/gateway", makeHTTPHandlerFunc(as.withMoneyMovement(as.withWebhookSignature(WebhookProviderGateway, as.handleGatewayCallback)))).Methods(http.MethodPost)
+}
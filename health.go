@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Shutdown Settings
+const (
+	// shutdownReadinessDelay is how long /readyz reports failure before the server
+	// stops accepting connections, giving load balancers time to take it out of rotation.
+	shutdownReadinessDelay = 5 * time.Second
+	// shutdownTimeout bounds how long in-flight requests may take to finish.
+	shutdownTimeout = 30 * time.Second
+)
+
+// Default Database Health Check Settings
+const (
+	defaultDBHealthInterval = 5 * time.Second
+	defaultDBHealthTimeout  = 2 * time.Second
+)
+
+// HealthStatus is the response body of the health endpoints.
+type HealthStatus struct {
+	Status   string            `json:"status"`
+	Checks   map[string]string `json:"checks,omitempty"`
+	Database *DatabaseHealth   `json:"database,omitempty"`
+}
+
+// DatabaseHealth is the result of the latest database health check.
+type DatabaseHealth struct {
+	Status    string      `json:"status"`
+	LatencyMs float64     `json:"latency_ms"`
+	CheckedAt time.Time   `json:"checked_at"`
+	Pool      *PoolHealth `json:"pool,omitempty"`
+}
+
+// PoolHealth is the utilization of the database connection pool.
+type PoolHealth struct {
+	MaxConns      int32   `json:"max_conns"`
+	TotalConns    int32   `json:"total_conns"`
+	AcquiredConns int32   `json:"acquired_conns"`
+	IdleConns     int32   `json:"idle_conns"`
+	Utilization   float64 `json:"utilization"`
+}
+
+// dbHealthMonitor pings the database in the background and keeps the latest result,
+// so /readyz and the metrics report the database state without pinging it themselves.
+type dbHealthMonitor struct {
+	store    Storage
+	interval time.Duration
+	timeout  time.Duration
+
+	mu        sync.RWMutex
+	err       error
+	latency   time.Duration
+	checkedAt time.Time
+}
+
+// newDBHealthMonitor creates a monitor that pings the database every interval,
+// failing pings that take longer than timeout.
+func newDBHealthMonitor(store Storage, interval, timeout time.Duration) *dbHealthMonitor {
+	return &dbHealthMonitor{
+		store:    store,
+		interval: interval,
+		timeout:  timeout,
+	}
+}
+
+// Run checks the database every interval until ctx is cancelled.
+// Changes between reachable and unreachable are logged.
+func (m *dbHealthMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check(ctx)
+		}
+	}
+}
+
+// check pings the database once and records the result.
+func (m *dbHealthMonitor) check(ctx context.Context) {
+	pingCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := m.store.Ping(pingCtx)
+	latency := time.Since(start)
+
+	m.mu.Lock()
+	wasUp := m.checkedAt.IsZero() || m.err == nil
+	m.err, m.latency, m.checkedAt = err, latency, time.Now().UTC()
+	m.mu.Unlock()
+
+	if err != nil && wasUp {
+		slog.ErrorContext(ctx, "Database Unreachable", "error", err)
+	} else if err == nil && !wasUp {
+		slog.InfoContext(ctx, "Database Reachable Again")
+	}
+}
+
+// status returns the latency and time of the latest check, and its error, nil if the database answered.
+func (m *dbHealthMonitor) status() (latency time.Duration, checkedAt time.Time, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.latency, m.checkedAt, m.err
+}
+
+// up reports whether the database answered the latest check.
+func (m *dbHealthMonitor) up() bool {
+	_, _, err := m.status()
+	return err == nil
+}
+
+// report returns the latest check result together with the pool utilization, when the store has a pool.
+func (m *dbHealthMonitor) report() *DatabaseHealth {
+	latency, checkedAt, err := m.status()
+
+	health := &DatabaseHealth{
+		Status:    "ok",
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+		CheckedAt: checkedAt,
+	}
+	if err != nil {
+		health.Status = err.Error()
+	}
+
+	if pooled, ok := unwrapStorage(m.store).(poolStatser); ok {
+		st := pooled.PoolStats()
+		health.Pool = &PoolHealth{
+			MaxConns:      st.MaxConns(),
+			TotalConns:    st.TotalConns(),
+			AcquiredConns: st.AcquiredConns(),
+			IdleConns:     st.IdleConns(),
+			Utilization:   poolUtilization(st.AcquiredConns(), st.MaxConns()),
+		}
+	}
+
+	return health
+}
+
+// poolUtilization returns the share of the pool connections that are in use.
+func poolUtilization(acquired, max int32) float64 {
+	if max == 0 {
+		return 0
+	}
+	return float64(acquired) / float64(max)
+}
+
+// handleHealthz reports that the process is up. It never touches dependencies,
+// so a slow database does not get the process restarted.
+func (as *APIServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+// handleReadyz reports whether the server should receive traffic: the latest background
+// database check must have succeeded and the server must not be shutting down. The
+// response also carries the latency of that check and the pool utilization.
+func (as *APIServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	status := HealthStatus{Status: "ok", Checks: map[string]string{}}
+	code := http.StatusOK
+
+	if as.ready.Load() {
+		status.Checks["server"] = "ok"
+	} else {
+		status.Checks["server"] = "shutting down"
+		code = http.StatusServiceUnavailable
+	}
+
+	status.Database = as.dbHealth.report()
+	status.Checks["database"] = status.Database.Status
+	if !as.dbHealth.up() {
+		code = http.StatusServiceUnavailable
+	}
+
+	if code != http.StatusOK {
+		status.Status = "unavailable"
+	}
+
+	WriteJSON(w, code, status)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaultHealthcheckTimeout bounds a "gobank healthcheck" run.
+const defaultHealthcheckTimeout = 3 * time.Second
+
+// runHealthcheckCommand runs "gobank healthcheck": it asks the server running on this
+// host whether it is ready and fails unless /readyz answers 200, so a container
+// HEALTHCHECK or a Kubernetes exec probe needs no curl in the image.
+//
+// Usage:
+//   - gobank healthcheck [-path /readyz] [-timeout 3s]
+//
+// Parameters:
+//   - listenAddr: The address the server listens on, as configured.
+//   - args: The arguments following the command.
+//
+// Returns:
+//   - error: An error if the arguments are invalid, or the server is unreachable or not ready.
+func runHealthcheckCommand(listenAddr string, args []string) error {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	path := flags.String("path", "/readyz", "Path of the probe, /healthz only checks that the server is up")
+	timeout := flags.Duration("timeout", defaultHealthcheckTimeout, "How long to wait for the answer")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	url, err := healthcheckURL(listenAddr, *path)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+// healthcheckURL returns the URL of the probe of a server listening on listenAddr. A
+// server listening on every interface, such as ":8080", is reached on localhost.
+func healthcheckURL(listenAddr, path string) (string, error) {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", listenAddr, err)
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+
+	return "http://" + net.JoinHostPort(host, port) + path, nil
+}
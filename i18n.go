@@ -0,0 +1,115 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// localeFiles holds the message catalogs, one JSON file per language.
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalogs maps a language to its messages. supportedLanguages lists the catalog
+// languages with English, the default, first.
+var catalogs, supportedLanguages = loadCatalogs()
+
+// matcher picks the best supported language for an Accept-Language header.
+var matcher = language.NewMatcher(supportedLanguages)
+
+// loadCatalogs reads every embedded catalog. English is always listed first so the
+// matcher falls back to it.
+func loadCatalogs() (map[language.Tag]map[string]string, []language.Tag) {
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		fatal("Error Reading Message Catalogs", "error", err)
+	}
+
+	loaded := make(map[language.Tag]map[string]string)
+	tags := []language.Tag{language.English}
+
+	for _, file := range files {
+		raw, err := localeFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			fatal("Error Reading Message Catalog", "file", file.Name(), "error", err)
+		}
+
+		messages := make(map[string]string)
+		if err := json.Unmarshal(raw, &messages); err != nil {
+			fatal("Error Parsing Message Catalog", "file", file.Name(), "error", err)
+		}
+
+		tag := language.Make(strings.TrimSuffix(file.Name(), ".json"))
+		loaded[tag] = messages
+		if tag != language.English {
+			tags = append(tags, tag)
+		}
+	}
+
+	return loaded, tags
+}
+
+// requestLanguage returns the supported language that best matches the request's Accept-Language header.
+func requestLanguage(r *http.Request) language.Tag {
+	preferred, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	_, index, _ := matcher.Match(preferred...)
+
+	return supportedLanguages[index]
+}
+
+// localize returns the message for key in the language of the request, with every
+// {name} placeholder replaced by the matching value of params (given as name, value pairs).
+// The fallback is used when no catalog has the key.
+//
+// Parameters:
+//   - r: The *http.Request whose Accept-Language header selects the language.
+//   - key: The catalog key of the message.
+//   - fallback: The message used when the key is unknown.
+//   - params: The placeholder names and values, in pairs.
+//
+// Returns:
+//   - string: The localized message.
+func localize(r *http.Request, key, fallback string, params ...string) string {
+	message, ok := catalogs[requestLanguage(r)][key]
+	if !ok {
+		if message, ok = catalogs[language.English][key]; !ok {
+			message = fallback
+		}
+	}
+
+	if len(params) == 0 {
+		return message
+	}
+
+	replacements := make([]string, 0, len(params))
+	for i := 0; i+1 < len(params); i += 2 {
+		replacements = append(replacements, "{"+params[i]+"}", params[i+1])
+	}
+
+	return strings.NewReplacer(replacements...).Replace(message)
+}
+
+// localizeTypedError translates the message and field errors of a TypedError in place.
+// Messages are looked up as "error.<code>"; field errors as "field.<field>.<code>" and
+// then "field.<code>". Unknown keys keep their original English message, and English
+// requests keep the detailed messages built by the handlers.
+func localizeTypedError(r *http.Request, typedErr *TypedError) {
+	if requestLanguage(r) == language.English {
+		return
+	}
+
+	typedErr.Message = localize(r, "error."+typedErr.Code, typedErr.Message)
+
+	for i, fieldErr := range typedErr.Fields {
+		message := localize(r, "field."+fieldErr.Field+"."+fieldErr.Code, "")
+		if message == "" {
+			message = localize(r, "field."+fieldErr.Code, fieldErr.Message, "field", fieldErr.Field)
+		}
+		typedErr.Fields[i].Message = message
+	}
+}
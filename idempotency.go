@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// idempotencyTTL is how long the response of an idempotent request is replayed.
+const idempotencyTTL = 24 * time.Hour
+
+// idempotencyLockTTL is how long the first request of a key holds it. A key whose
+// request never finished, such as on an instance that crashed, is given to the next
+// request after it.
+const idempotencyLockTTL = 5 * time.Minute
+
+// defaultIdempotencySweepInterval is how often the expired keys are deleted, unless
+// jobs.idempotency_sweep_interval says otherwise.
+const defaultIdempotencySweepInterval = 10 * time.Minute
+
+// IdempotencyRecord is a request sent with an Idempotency-Key, and once it is answered,
+// its response, replayed for the later requests with the key.
+type IdempotencyRecord struct {
+	// Key is the SHA-256 of the method, the path, the Authorization header, and the
+	// Idempotency-Key of the request, so no token is stored.
+	Key string
+	// RequestHash is the SHA-256 of the body of the first request, which the later
+	// requests with the key must repeat.
+	RequestHash string
+	// Status is the status of the response, 0 while the first request is in progress.
+	Status int
+	Header http.Header
+	Body   []byte
+	// LockedUntil is when the first request gives the key up if it has not answered.
+	LockedUntil time.Time
+	// ExpiresAt is when the response is no longer replayed.
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// InProgress reports whether the first request of the record has not answered yet.
+func (rec *IdempotencyRecord) InProgress() bool {
+	return rec.Status == 0
+}
+
+// recordingWriter captures the response written by a handler while passing it through.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// withIdempotency makes a POST handler safe to retry: the first response for an
+// Idempotency-Key is stored and replayed (with Idempotent-Replayed: true) for every
+// later request with the same key, on every instance and across restarts. A retry
+// arriving while the first request is still running gets a 409, and a request reusing
+// the key with another body a 422. Requests without the header are passed through
+// unchanged. Server errors are not recorded, so they can be retried.
+//
+// Parameters:
+//   - handler: The endpoint to protect.
+//
+// Returns:
+//   - http.HandlerFunc: The endpoint, answering with a 503 when the keys cannot be read.
+func (as *APIServer) withIdempotency(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			handler(w, r)
+			return
+		}
+
+		// Read The Body For Its Fingerprint, Then Hand It On; bindJSON Refuses One Too Large
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxJSONBodySize+1))
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, localize(r, "error.bad_request", "bad request"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		record := &IdempotencyRecord{
+			Key:         hashHex(r.Method, r.URL.Path, r.Header.Get("Authorization"), key),
+			RequestHash: hashHex(string(body)),
+			LockedUntil: now.Add(idempotencyLockTTL),
+			ExpiresAt:   now.Add(idempotencyTTL),
+			CreatedAt:   now,
+		}
+
+		// The Client Going Away Must Not Leave The Key Held
+		ctx := context.WithoutCancel(r.Context())
+
+		stored, err := as.store.ReserveIdempotencyKey(ctx, record)
+		if err != nil {
+			reportError(r, err)
+			WriteError(w, http.StatusServiceUnavailable, localize(r, "error.database_unavailable", "the database is unavailable, retry later"))
+			return
+		}
+		if stored != nil {
+			switch {
+			case stored.RequestHash != record.RequestHash:
+				WriteError(w, http.StatusUnprocessableEntity, localize(r, "idempotency.key_reused", "the Idempotency-Key was used with another request body"))
+			case stored.InProgress():
+				WriteError(w, http.StatusConflict, localize(r, "idempotency.in_progress", "a request with this Idempotency-Key is already in progress"))
+			default:
+				for name, values := range stored.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Body)
+			}
+			return
+		}
+
+		// Give The Key Up Unless The Response Is Stored, Even When The Handler Panics
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := as.store.ReleaseIdempotencyKey(ctx, record); err != nil {
+				slog.ErrorContext(ctx, "Error Releasing The Idempotency Key", "error", err)
+			}
+		}()
+
+		rw := &recordingWriter{ResponseWriter: w}
+		handler(rw, r)
+
+		if rw.status >= http.StatusInternalServerError {
+			return
+		}
+		record.Status = rw.status
+		record.Header = w.Header().Clone()
+		record.Body = rw.body.Bytes()
+		if err := as.store.CompleteIdempotencyKey(ctx, record); err != nil {
+			slog.ErrorContext(ctx, "Error Storing The Idempotent Response", "error", err)
+			return
+		}
+		completed = true
+	}
+}
+
+// runIdempotencySweep deletes the expired idempotency keys every sweep interval until
+// ctx is cancelled, on one instance of the fleet, see runScheduledJob.
+func (as *APIServer) runIdempotencySweep(ctx context.Context) {
+	as.runScheduledJob(ctx, "idempotency_sweep", as.config.Jobs.IdempotencySweepInterval, func(ctx context.Context) {
+		n, err := as.store.DeleteExpiredIdempotencyKeys(ctx, time.Now().UTC())
+		if err != nil {
+			slog.ErrorContext(ctx, "Error Deleting Expired Idempotency Keys", "error", err)
+			return
+		}
+		if n > 0 {
+			slog.DebugContext(ctx, "Expired Idempotency Keys Deleted", "keys", n)
+		}
+	})
+}
+
+// hashHex returns the hex SHA-256 of the parts, each ended by a NUL byte so that no two
+// lists of parts hash alike.
+func hashHex(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
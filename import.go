@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moabdelazem/gobank/money"
+)
+
+// Import Settings
+const (
+	importBatchSize      = 500
+	importMaxBodySize    = 64 << 20
+	importMaxCategoryLen = 64
+)
+
+// Import Record Kinds
+const (
+	ImportKindAccount     = "account"
+	ImportKindTransaction = "transaction"
+)
+
+// ImportRecord is a single row of a bulk import: either a legacy account or one of
+// its historical transactions. Transactions reference accounts by account number,
+// since the legacy system does not know our IDs. The amounts are in the minor units
+// of the currency of the account, which the stores set once they know it.
+type ImportRecord struct {
+	Kind               string      `json:"kind"`
+	FirstName          string      `json:"first_name"`
+	LastName           string      `json:"last_name"`
+	Number             int64       `json:"number"`
+	Balance            money.Money `json:"balance"`
+	AccountNumber      int64       `json:"account_number"`
+	CounterpartyNumber int64       `json:"counterparty_number"`
+	Type               string      `json:"type"`
+	Amount             money.Money `json:"amount"`
+	BalanceAfter       money.Money `json:"balance_after"`
+	CreatedAt          time.Time   `json:"created_at"`
+	Category           string      `json:"category"`
+	// Currency is that of an account, accounts.default_currency when empty; a transaction
+	// is in the currency of its account, which it may repeat.
+	Currency string `json:"currency"`
+}
+
+// ImportRowError reports why a single row of the import was rejected.
+// Rows are numbered from 1 in the order they appear in the upload.
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ImportReport is the response of a bulk import.
+type ImportReport struct {
+	Total    int              `json:"total"`
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+// Validate checks that the record is complete before it is sent to the database.
+func (rec *ImportRecord) Validate() error {
+	switch rec.Kind {
+	case ImportKindAccount:
+		if rec.FirstName == "" || rec.LastName == "" {
+			return fmt.Errorf("first_name and last_name are required")
+		}
+		if rec.Number <= 0 {
+			return fmt.Errorf("number must be positive")
+		}
+		if rec.Balance.IsNegative() {
+			return fmt.Errorf("balance must not be negative")
+		}
+		if !money.IsCurrencyCode(rec.Currency) {
+			return fmt.Errorf("currency must be an uppercase ISO 4217 currency code, such as USD")
+		}
+	case ImportKindTransaction:
+		if rec.AccountNumber <= 0 {
+			return fmt.Errorf("account_number must be positive")
+		}
+		if rec.Amount.IsZero() {
+			return fmt.Errorf("amount must not be zero")
+		}
+		if rec.Type == "" {
+			return fmt.Errorf("type is required")
+		}
+		if rec.CreatedAt.IsZero() {
+			return fmt.Errorf("created_at is required")
+		}
+		if len(rec.Category) > importMaxCategoryLen {
+			return fmt.Errorf("category must be at most %d characters", importMaxCategoryLen)
+		}
+		if rec.Currency != "" && !money.IsCurrencyCode(rec.Currency) {
+			return fmt.Errorf("currency must be an uppercase ISO 4217 currency code, such as USD")
+		}
+	default:
+		return fmt.Errorf("unknown kind: %q", rec.Kind)
+	}
+
+	return nil
+}
+
+// entryCurrency returns the currency of the ledger entry of a transaction record on an
+// account in the given currency, which the record may only repeat.
+func (rec *ImportRecord) entryCurrency(account string) (string, error) {
+	if rec.Currency != "" && rec.Currency != account {
+		return "", fmt.Errorf("%w: account %d is in %s, not %s", money.ErrCurrencyMismatch, rec.AccountNumber, account, rec.Currency)
+	}
+	return account, nil
+}
+
+// handleImport handles the admin bulk import of legacy accounts and transactions.
+// The body is NDJSON (application/x-ndjson) or CSV with a header row (text/csv).
+// Valid rows are inserted in batches, each batch in its own database transaction;
+// invalid rows are skipped and reported individually with their row number.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the import report to.
+//   - r: *http.Request containing the records to import.
+//
+// Returns:
+//   - error: An error if the body cannot be parsed or a batch cannot be written.
+func (as *APIServer) handleImport(w http.ResponseWriter, r *http.Request) error {
+	body := http.MaxBytesReader(w, r.Body, importMaxBodySize)
+	defer body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var (
+		records []*ImportRecord
+		rowErrs []ImportRowError
+		err     error
+	)
+
+	switch mediaType {
+	case "application/x-ndjson", "application/jsonl":
+		records, rowErrs, err = parseImportNDJSON(body)
+	case "text/csv":
+		records, rowErrs, err = parseImportCSV(body)
+	default:
+		return NewTypedError(http.StatusUnsupportedMediaType, "unsupported_media_type", "content type must be application/x-ndjson or text/csv")
+	}
+	if err != nil {
+		return err
+	}
+
+	report := &ImportReport{Total: len(records), Errors: rowErrs}
+
+	// Validate Every Row Before Touching The Database
+	valid := make([]*ImportRecord, 0, len(records))
+	rows := make([]int, 0, len(records))
+	for i, rec := range records {
+		if rec == nil {
+			continue
+		}
+		if rec.Kind == ImportKindAccount && rec.Currency == "" {
+			rec.Currency = as.config.Accounts.DefaultCurrency
+		}
+		if err := rec.Validate(); err != nil {
+			report.Errors = append(report.Errors, ImportRowError{Row: i + 1, Error: err.Error()})
+			continue
+		}
+		valid = append(valid, rec)
+		rows = append(rows, i+1)
+	}
+
+	// Insert In Batches
+	for start := 0; start < len(valid); start += importBatchSize {
+		end := min(start+importBatchSize, len(valid))
+
+		batchErrs, err := as.store.ImportRecords(r.Context(), valid[start:end])
+		if err != nil {
+			return err
+		}
+
+		for i, batchErr := range batchErrs {
+			if batchErr != nil {
+				report.Errors = append(report.Errors, ImportRowError{Row: rows[start+i], Error: batchErr.Error()})
+			}
+		}
+	}
+
+	report.Failed = len(report.Errors)
+	report.Imported = report.Total - report.Failed
+
+	as.audit(r, AuditActionImport, mediaType, map[string]int{
+		"total":    report.Total,
+		"imported": report.Imported,
+		"failed":   report.Failed,
+	})
+
+	return WriteResponse(w, r, http.StatusOK, report)
+}
+
+// parseImportNDJSON reads one ImportRecord per line. Lines that cannot be decoded are
+// reported as row errors and leave a nil entry so row numbers stay aligned.
+func parseImportNDJSON(body io.Reader) ([]*ImportRecord, []ImportRowError, error) {
+	records := []*ImportRecord{}
+	rowErrs := []ImportRowError{}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		rec := new(ImportRecord)
+		if err := json.Unmarshal([]byte(line), rec); err != nil {
+			rowErrs = append(rowErrs, ImportRowError{Row: len(records) + 1, Error: fmt.Sprintf("invalid json: %s", err)})
+			rec = nil
+		}
+		records = append(records, rec)
+	}
+
+	return records, rowErrs, scanner.Err()
+}
+
+// parseImportCSV reads ImportRecords from CSV. The first row names the columns using
+// the same names as the NDJSON fields; unknown columns are ignored.
+func parseImportCSV(body io.Reader) ([]*ImportRecord, []ImportRowError, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid csv header: %s", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+
+	records := []*ImportRecord{}
+	rowErrs := []ImportRowError{}
+
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rec, err := importRecordFromCSV(columns, fields)
+		if err != nil {
+			rowErrs = append(rowErrs, ImportRowError{Row: len(records) + 1, Error: err.Error()})
+			rec = nil
+		}
+		records = append(records, rec)
+	}
+
+	return records, rowErrs, nil
+}
+
+// importRecordFromCSV maps a CSV row onto an ImportRecord using the header columns.
+func importRecordFromCSV(columns map[string]int, fields []string) (*ImportRecord, error) {
+	get := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+
+	getInt := func(name string) (int64, error) {
+		value := get(name)
+		if value == "" {
+			return 0, nil
+		}
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %s", name, value)
+		}
+		return parsed, nil
+	}
+
+	rec := &ImportRecord{
+		Kind:      get("kind"),
+		FirstName: get("first_name"),
+		LastName:  get("last_name"),
+		Type:      get("type"),
+		Category:  get("category"),
+		Currency:  get("currency"),
+	}
+
+	var err error
+	for name, dst := range map[string]*int64{
+		"number":              &rec.Number,
+		"balance":             &rec.Balance.Amount,
+		"account_number":      &rec.AccountNumber,
+		"counterparty_number": &rec.CounterpartyNumber,
+		"amount":              &rec.Amount.Amount,
+		"balance_after":       &rec.BalanceAfter.Amount,
+	} {
+		if *dst, err = getInt(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if value := get("created_at"); value != "" {
+		if rec.CreatedAt, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("invalid created_at: %s", value)
+		}
+	}
+
+	return rec, nil
+}
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/moabdelazem/gobank/money"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultStorageSlowThreshold is the duration above which a storage call is logged as slow.
+const defaultStorageSlowThreshold = 200 * time.Millisecond
+
+// InstrumentedStorage is a Storage decorator that times and traces every call of the
+// wrapped storage, counts the calls that fail, and logs the ones slower than the
+// slow_threshold setting.
+type InstrumentedStorage struct {
+	next    Storage
+	metrics *storageMetrics
+}
+
+// storageMetrics holds the collectors shared by an InstrumentedStorage and the
+// instrumented storages it hands to WithTx callbacks.
+type storageMetrics struct {
+	duration      *prometheus.HistogramVec
+	errors        *prometheus.CounterVec
+	slowThreshold time.Duration
+}
+
+// NewInstrumentedStorage wraps store with timing, error counting, and slow call logging.
+//
+// Parameters:
+//   - store: The storage to instrument.
+//   - slowThreshold: The duration from which a call is logged as slow.
+//
+// Returns:
+//   - *InstrumentedStorage: The instrumented storage; its collectors are registered by newMetricsRegistry.
+func NewInstrumentedStorage(store Storage, slowThreshold time.Duration) *InstrumentedStorage {
+	return &InstrumentedStorage{
+		next: store,
+		metrics: &storageMetrics{
+			duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: metricsNamespace, Subsystem: "storage", Name: "call_duration_seconds",
+				Help:    "Duration of the storage calls, by method.",
+				Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+			}, []string{"method"}),
+			errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: metricsNamespace, Subsystem: "storage", Name: "call_errors_total",
+				Help: "Number of storage calls that returned an error, by method.",
+			}, []string{"method"}),
+			slowThreshold: slowThreshold,
+		},
+	}
+}
+
+// Unwrap returns the instrumented storage.
+func (s *InstrumentedStorage) Unwrap() Storage {
+	return s.next
+}
+
+// Collectors returns the storage call histogram and error counter, for the metrics registry.
+func (s *InstrumentedStorage) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{s.metrics.duration, s.metrics.errors}
+}
+
+// start begins a storage call by starting its span. It returns the context to make the
+// call with, and a function, deferred with a pointer to the error result, that ends the
+// span and records the call.
+func (s *InstrumentedStorage) start(ctx context.Context, method string) (context.Context, func(*error)) {
+	begin := time.Now()
+	ctx, span := tracer.Start(ctx, "storage."+method, trace.WithAttributes(attribute.String("storage.method", method)))
+
+	return ctx, func(err *error) {
+		endSpan(span, *err)
+		s.observe(ctx, method, begin, *err)
+	}
+}
+
+// observe records a finished storage call with its start time and error result.
+func (s *InstrumentedStorage) observe(ctx context.Context, method string, start time.Time, err error) {
+	elapsed := time.Since(start)
+
+	s.metrics.duration.WithLabelValues(method).Observe(elapsed.Seconds())
+	if err != nil {
+		s.metrics.errors.WithLabelValues(method).Inc()
+	}
+
+	if elapsed >= s.metrics.slowThreshold {
+		slog.WarnContext(ctx, "Slow Storage Call", "method", method, "duration", elapsed, "error", err)
+	}
+}
+
+// Ping times Ping of the wrapped storage.
+func (s *InstrumentedStorage) Ping(ctx context.Context) (err error) {
+	ctx, done := s.start(ctx, "Ping")
+	defer done(&err)
+	return s.next.Ping(ctx)
+}
+
+// CreateAccount times CreateAccount of the wrapped storage.
+func (s *InstrumentedStorage) CreateAccount(ctx context.Context, account *Account) (err error) {
+	ctx, done := s.start(ctx, "CreateAccount")
+	defer done(&err)
+	return s.next.CreateAccount(ctx, account)
+}
+
+// CreateAccounts times CreateAccounts of the wrapped storage.
+func (s *InstrumentedStorage) CreateAccounts(ctx context.Context, accounts []*Account) (err error) {
+	ctx, done := s.start(ctx, "CreateAccounts")
+	defer done(&err)
+	return s.next.CreateAccounts(ctx, accounts)
+}
+
+// DeleteAccount times DeleteAccount of the wrapped storage.
+func (s *InstrumentedStorage) DeleteAccount(ctx context.Context, id int) (err error) {
+	ctx, done := s.start(ctx, "DeleteAccount")
+	defer done(&err)
+	return s.next.DeleteAccount(ctx, id)
+}
+
+// UpdateAccount times UpdateAccount of the wrapped storage.
+func (s *InstrumentedStorage) UpdateAccount(ctx context.Context, account *Account) (err error) {
+	ctx, done := s.start(ctx, "UpdateAccount")
+	defer done(&err)
+	return s.next.UpdateAccount(ctx, account)
+}
+
+// GetAccounts times GetAccounts of the wrapped storage.
+func (s *InstrumentedStorage) GetAccounts(ctx context.Context) (accounts []*Account, err error) {
+	ctx, done := s.start(ctx, "GetAccounts")
+	defer done(&err)
+	return s.next.GetAccounts(ctx)
+}
+
+// GetAccountsPage times GetAccountsPage of the wrapped storage.
+func (s *InstrumentedStorage) GetAccountsPage(ctx context.Context, limit, offset int, includeDeleted bool) (accounts []*Account, total int, err error) {
+	ctx, done := s.start(ctx, "GetAccountsPage")
+	defer done(&err)
+	return s.next.GetAccountsPage(ctx, limit, offset, includeDeleted)
+}
+
+// GetAccountById times GetAccountById of the wrapped storage.
+func (s *InstrumentedStorage) GetAccountById(ctx context.Context, id int) (account *Account, err error) {
+	ctx, done := s.start(ctx, "GetAccountById")
+	defer done(&err)
+	return s.next.GetAccountById(ctx, id)
+}
+
+// GetAccountByNumber times GetAccountByNumber of the wrapped storage.
+func (s *InstrumentedStorage) GetAccountByNumber(ctx context.Context, number int64) (account *Account, err error) {
+	ctx, done := s.start(ctx, "GetAccountByNumber")
+	defer done(&err)
+	return s.next.GetAccountByNumber(ctx, number)
+}
+
+// GetAccountByExternalID times GetAccountByExternalID of the wrapped storage.
+func (s *InstrumentedStorage) GetAccountByExternalID(ctx context.Context, externalID string) (account *Account, err error) {
+	ctx, done := s.start(ctx, "GetAccountByExternalID")
+	defer done(&err)
+	return s.next.GetAccountByExternalID(ctx, externalID)
+}
+
+// GetTransactions times GetTransactions of the wrapped storage.
+func (s *InstrumentedStorage) GetTransactions(ctx context.Context, accountID int, filter *TransactionFilter) (txns []*Transaction, err error) {
+	ctx, done := s.start(ctx, "GetTransactions")
+	defer done(&err)
+	return s.next.GetTransactions(ctx, accountID, filter)
+}
+
+// GetTransactionsPage times GetTransactionsPage of the wrapped storage.
+func (s *InstrumentedStorage) GetTransactionsPage(ctx context.Context, accountID int, filter *TransactionFilter, after *TransactionCursor, limit int) (txns []*Transaction, err error) {
+	ctx, done := s.start(ctx, "GetTransactionsPage")
+	defer done(&err)
+	return s.next.GetTransactionsPage(ctx, accountID, filter, after, limit)
+}
+
+// CreateTransactions times CreateTransactions of the wrapped storage.
+func (s *InstrumentedStorage) CreateTransactions(ctx context.Context, txns []*Transaction) (err error) {
+	ctx, done := s.start(ctx, "CreateTransactions")
+	defer done(&err)
+	return s.next.CreateTransactions(ctx, txns)
+}
+
+// GetBalanceAt times GetBalanceAt of the wrapped storage.
+func (s *InstrumentedStorage) GetBalanceAt(ctx context.Context, accountID int, at time.Time) (balance money.Money, err error) {
+	ctx, done := s.start(ctx, "GetBalanceAt")
+	defer done(&err)
+	return s.next.GetBalanceAt(ctx, accountID, at)
+}
+
+// StreamLedger is timed as a whole, including the time fn spends on every row.
+func (s *InstrumentedStorage) StreamLedger(ctx context.Context, from, to time.Time, fn func(*Transaction) error) (err error) {
+	ctx, done := s.start(ctx, "StreamLedger")
+	defer done(&err)
+	return s.next.StreamLedger(ctx, from, to, fn)
+}
+
+// StreamTransactions is timed as a whole, including the time fn spends on every row.
+func (s *InstrumentedStorage) StreamTransactions(ctx context.Context, accountID int, from, to time.Time, fn func(*Transaction) error) (err error) {
+	ctx, done := s.start(ctx, "StreamTransactions")
+	defer done(&err)
+	return s.next.StreamTransactions(ctx, accountID, from, to, fn)
+}
+
+// ImportRecords times ImportRecords of the wrapped storage.
+func (s *InstrumentedStorage) ImportRecords(ctx context.Context, records []*ImportRecord) (errs []error, err error) {
+	ctx, done := s.start(ctx, "ImportRecords")
+	defer done(&err)
+	return s.next.ImportRecords(ctx, records)
+}
+
+// TransferFunds times TransferFunds of the wrapped storage.
+func (s *InstrumentedStorage) TransferFunds(ctx context.Context, fromID, toID int, amount money.Money) (txns []*Transaction, err error) {
+	ctx, done := s.start(ctx, "TransferFunds")
+	defer done(&err)
+	return s.next.TransferFunds(ctx, fromID, toID, amount)
+}
+
+// CreateTransfer times CreateTransfer of the wrapped storage.
+func (s *InstrumentedStorage) CreateTransfer(ctx context.Context, transfer *Transfer) (err error) {
+	ctx, done := s.start(ctx, "CreateTransfer")
+	defer done(&err)
+	return s.next.CreateTransfer(ctx, transfer)
+}
+
+// UpdateTransferStatus times UpdateTransferStatus of the wrapped storage.
+func (s *InstrumentedStorage) UpdateTransferStatus(ctx context.Context, transfer *Transfer, status, reason string) (err error) {
+	ctx, done := s.start(ctx, "UpdateTransferStatus")
+	defer done(&err)
+	return s.next.UpdateTransferStatus(ctx, transfer, status, reason)
+}
+
+// GetTransfer times GetTransfer of the wrapped storage.
+func (s *InstrumentedStorage) GetTransfer(ctx context.Context, id int) (transfer *Transfer, err error) {
+	ctx, done := s.start(ctx, "GetTransfer")
+	defer done(&err)
+	return s.next.GetTransfer(ctx, id)
+}
+
+// GetTransfersByStatus times GetTransfersByStatus of the wrapped storage.
+func (s *InstrumentedStorage) GetTransfersByStatus(ctx context.Context, status string) (transfers []*Transfer, err error) {
+	ctx, done := s.start(ctx, "GetTransfersByStatus")
+	defer done(&err)
+	return s.next.GetTransfersByStatus(ctx, status)
+}
+
+// GetTransferByProviderReference times GetTransferByProviderReference of the wrapped storage.
+func (s *InstrumentedStorage) GetTransferByProviderReference(ctx context.Context, reference string) (transfer *Transfer, err error) {
+	ctx, done := s.start(ctx, "GetTransferByProviderReference")
+	defer done(&err)
+	return s.next.GetTransferByProviderReference(ctx, reference)
+}
+
+// SetAccountFrozen times SetAccountFrozen of the wrapped storage.
+func (s *InstrumentedStorage) SetAccountFrozen(ctx context.Context, id int, frozen bool) (err error) {
+	ctx, done := s.start(ctx, "SetAccountFrozen")
+	defer done(&err)
+	return s.next.SetAccountFrozen(ctx, id, frozen)
+}
+
+// SetTransferLimit times SetTransferLimit of the wrapped storage.
+func (s *InstrumentedStorage) SetTransferLimit(ctx context.Context, id int, limit money.Money) (err error) {
+	ctx, done := s.start(ctx, "SetTransferLimit")
+	defer done(&err)
+	return s.next.SetTransferLimit(ctx, id, limit)
+}
+
+// CreateAuditEntry times CreateAuditEntry of the wrapped storage.
+func (s *InstrumentedStorage) CreateAuditEntry(ctx context.Context, entry *AuditEntry) (err error) {
+	ctx, done := s.start(ctx, "CreateAuditEntry")
+	defer done(&err)
+	return s.next.CreateAuditEntry(ctx, entry)
+}
+
+// GetAuditLog times GetAuditLog of the wrapped storage.
+func (s *InstrumentedStorage) GetAuditLog(ctx context.Context, limit, offset int) (entries []*AuditEntry, total int, err error) {
+	ctx, done := s.start(ctx, "GetAuditLog")
+	defer done(&err)
+	return s.next.GetAuditLog(ctx, limit, offset)
+}
+
+// GetFeatureFlags times GetFeatureFlags of the wrapped storage.
+func (s *InstrumentedStorage) GetFeatureFlags(ctx context.Context) (flags []*FeatureFlag, err error) {
+	ctx, done := s.start(ctx, "GetFeatureFlags")
+	defer done(&err)
+	return s.next.GetFeatureFlags(ctx)
+}
+
+// SetFeatureFlag times SetFeatureFlag of the wrapped storage.
+func (s *InstrumentedStorage) SetFeatureFlag(ctx context.Context, flag *FeatureFlag) (err error) {
+	ctx, done := s.start(ctx, "SetFeatureFlag")
+	defer done(&err)
+	return s.next.SetFeatureFlag(ctx, flag)
+}
+
+// DeleteFeatureFlag times DeleteFeatureFlag of the wrapped storage.
+func (s *InstrumentedStorage) DeleteFeatureFlag(ctx context.Context, name string) (err error) {
+	ctx, done := s.start(ctx, "DeleteFeatureFlag")
+	defer done(&err)
+	return s.next.DeleteFeatureFlag(ctx, name)
+}
+
+// GetMaintenance times GetMaintenance of the wrapped storage.
+func (s *InstrumentedStorage) GetMaintenance(ctx context.Context) (maintenance *Maintenance, err error) {
+	ctx, done := s.start(ctx, "GetMaintenance")
+	defer done(&err)
+	return s.next.GetMaintenance(ctx)
+}
+
+// SetMaintenance times SetMaintenance of the wrapped storage.
+func (s *InstrumentedStorage) SetMaintenance(ctx context.Context, maintenance *Maintenance) (err error) {
+	ctx, done := s.start(ctx, "SetMaintenance")
+	defer done(&err)
+	return s.next.SetMaintenance(ctx, maintenance)
+}
+
+// ClearMaintenance times ClearMaintenance of the wrapped storage.
+func (s *InstrumentedStorage) ClearMaintenance(ctx context.Context) (err error) {
+	ctx, done := s.start(ctx, "ClearMaintenance")
+	defer done(&err)
+	return s.next.ClearMaintenance(ctx)
+}
+
+// GetNotificationPreferences times GetNotificationPreferences of the wrapped storage.
+func (s *InstrumentedStorage) GetNotificationPreferences(ctx context.Context, accountID int) (prefs *NotificationPreferences, err error) {
+	ctx, done := s.start(ctx, "GetNotificationPreferences")
+	defer done(&err)
+	return s.next.GetNotificationPreferences(ctx, accountID)
+}
+
+// SetNotificationPreferences times SetNotificationPreferences of the wrapped storage.
+func (s *InstrumentedStorage) SetNotificationPreferences(ctx context.Context, prefs *NotificationPreferences) (err error) {
+	ctx, done := s.start(ctx, "SetNotificationPreferences")
+	defer done(&err)
+	return s.next.SetNotificationPreferences(ctx, prefs)
+}
+
+// RegisterPushDevice times RegisterPushDevice of the wrapped storage.
+func (s *InstrumentedStorage) RegisterPushDevice(ctx context.Context, device *PushDevice) (err error) {
+	ctx, done := s.start(ctx, "RegisterPushDevice")
+	defer done(&err)
+	return s.next.RegisterPushDevice(ctx, device)
+}
+
+// GetPushDevices times GetPushDevices of the wrapped storage.
+func (s *InstrumentedStorage) GetPushDevices(ctx context.Context, accountID int) (devices []*PushDevice, err error) {
+	ctx, done := s.start(ctx, "GetPushDevices")
+	defer done(&err)
+	return s.next.GetPushDevices(ctx, accountID)
+}
+
+// DeletePushDevice times DeletePushDevice of the wrapped storage.
+func (s *InstrumentedStorage) DeletePushDevice(ctx context.Context, accountID, id int) (err error) {
+	ctx, done := s.start(ctx, "DeletePushDevice")
+	defer done(&err)
+	return s.next.DeletePushDevice(ctx, accountID, id)
+}
+
+// RecordGatewayEvent times RecordGatewayEvent of the wrapped storage.
+func (s *InstrumentedStorage) RecordGatewayEvent(ctx context.Context, event *GatewayEvent) (err error) {
+	ctx, done := s.start(ctx, "RecordGatewayEvent")
+	defer done(&err)
+	return s.next.RecordGatewayEvent(ctx, event)
+}
+
+// DepositFunds times DepositFunds of the wrapped storage.
+func (s *InstrumentedStorage) DepositFunds(ctx context.Context, deposit *Transaction) (err error) {
+	ctx, done := s.start(ctx, "DepositFunds")
+	defer done(&err)
+	return s.next.DepositFunds(ctx, deposit)
+}
+
+// CreateCardTopUp times CreateCardTopUp of the wrapped storage.
+func (s *InstrumentedStorage) CreateCardTopUp(ctx context.Context, topUp *CardTopUp) (err error) {
+	ctx, done := s.start(ctx, "CreateCardTopUp")
+	defer done(&err)
+	return s.next.CreateCardTopUp(ctx, topUp)
+}
+
+// GetCardTopUp times GetCardTopUp of the wrapped storage.
+func (s *InstrumentedStorage) GetCardTopUp(ctx context.Context, accountID, id int) (topUp *CardTopUp, err error) {
+	ctx, done := s.start(ctx, "GetCardTopUp")
+	defer done(&err)
+	return s.next.GetCardTopUp(ctx, accountID, id)
+}
+
+// GetCardTopUpByPaymentIntent times GetCardTopUpByPaymentIntent of the wrapped storage.
+func (s *InstrumentedStorage) GetCardTopUpByPaymentIntent(ctx context.Context, paymentIntentID string) (topUp *CardTopUp, err error) {
+	ctx, done := s.start(ctx, "GetCardTopUpByPaymentIntent")
+	defer done(&err)
+	return s.next.GetCardTopUpByPaymentIntent(ctx, paymentIntentID)
+}
+
+// UpdateCardTopUp times UpdateCardTopUp of the wrapped storage.
+func (s *InstrumentedStorage) UpdateCardTopUp(ctx context.Context, topUp *CardTopUp, fromStatus string) (err error) {
+	ctx, done := s.start(ctx, "UpdateCardTopUp")
+	defer done(&err)
+	return s.next.UpdateCardTopUp(ctx, topUp, fromStatus)
+}
+
+// CreateConsent times CreateConsent of the wrapped storage.
+func (s *InstrumentedStorage) CreateConsent(ctx context.Context, consent *Consent) (err error) {
+	ctx, done := s.start(ctx, "CreateConsent")
+	defer done(&err)
+	return s.next.CreateConsent(ctx, consent)
+}
+
+// GetConsent times GetConsent of the wrapped storage.
+func (s *InstrumentedStorage) GetConsent(ctx context.Context, id int) (consent *Consent, err error) {
+	ctx, done := s.start(ctx, "GetConsent")
+	defer done(&err)
+	return s.next.GetConsent(ctx, id)
+}
+
+// GetConsents times GetConsents of the wrapped storage.
+func (s *InstrumentedStorage) GetConsents(ctx context.Context, accountID int) (consents []*Consent, err error) {
+	ctx, done := s.start(ctx, "GetConsents")
+	defer done(&err)
+	return s.next.GetConsents(ctx, accountID)
+}
+
+// RevokeConsent times RevokeConsent of the wrapped storage.
+func (s *InstrumentedStorage) RevokeConsent(ctx context.Context, accountID, id int) (err error) {
+	ctx, done := s.start(ctx, "RevokeConsent")
+	defer done(&err)
+	return s.next.RevokeConsent(ctx, accountID, id)
+}
+
+// SetAccountKYCStatus times SetAccountKYCStatus of the wrapped storage.
+func (s *InstrumentedStorage) SetAccountKYCStatus(ctx context.Context, id int, status string) (err error) {
+	ctx, done := s.start(ctx, "SetAccountKYCStatus")
+	defer done(&err)
+	return s.next.SetAccountKYCStatus(ctx, id, status)
+}
+
+// UpdateDormantAccounts times UpdateDormantAccounts of the wrapped storage.
+func (s *InstrumentedStorage) UpdateDormantAccounts(ctx context.Context, inactiveSince time.Time) (dormant []int, reactivated []int, err error) {
+	ctx, done := s.start(ctx, "UpdateDormantAccounts")
+	defer done(&err)
+	return s.next.UpdateDormantAccounts(ctx, inactiveSince)
+}
+
+// CreateKYCCheck times CreateKYCCheck of the wrapped storage.
+func (s *InstrumentedStorage) CreateKYCCheck(ctx context.Context, check *KYCCheck) (err error) {
+	ctx, done := s.start(ctx, "CreateKYCCheck")
+	defer done(&err)
+	return s.next.CreateKYCCheck(ctx, check)
+}
+
+// GetKYCCheck times GetKYCCheck of the wrapped storage.
+func (s *InstrumentedStorage) GetKYCCheck(ctx context.Context, accountID int) (check *KYCCheck, err error) {
+	ctx, done := s.start(ctx, "GetKYCCheck")
+	defer done(&err)
+	return s.next.GetKYCCheck(ctx, accountID)
+}
+
+// GetKYCCheckByProviderID times GetKYCCheckByProviderID of the wrapped storage.
+func (s *InstrumentedStorage) GetKYCCheckByProviderID(ctx context.Context, providerCheckID string) (check *KYCCheck, err error) {
+	ctx, done := s.start(ctx, "GetKYCCheckByProviderID")
+	defer done(&err)
+	return s.next.GetKYCCheckByProviderID(ctx, providerCheckID)
+}
+
+// GetOpenKYCChecks times GetOpenKYCChecks of the wrapped storage.
+func (s *InstrumentedStorage) GetOpenKYCChecks(ctx context.Context, limit int) (checks []*KYCCheck, err error) {
+	ctx, done := s.start(ctx, "GetOpenKYCChecks")
+	defer done(&err)
+	return s.next.GetOpenKYCChecks(ctx, limit)
+}
+
+// UpdateKYCCheck times UpdateKYCCheck of the wrapped storage.
+func (s *InstrumentedStorage) UpdateKYCCheck(ctx context.Context, check *KYCCheck, fromStatus string) (err error) {
+	ctx, done := s.start(ctx, "UpdateKYCCheck")
+	defer done(&err)
+	return s.next.UpdateKYCCheck(ctx, check, fromStatus)
+}
+
+// AddOutboxEvents times AddOutboxEvents of the wrapped storage.
+func (s *InstrumentedStorage) AddOutboxEvents(ctx context.Context, events []*OutboxEvent) (err error) {
+	ctx, done := s.start(ctx, "AddOutboxEvents")
+	defer done(&err)
+	return s.next.AddOutboxEvents(ctx, events)
+}
+
+// GetUnpublishedOutboxEvents times GetUnpublishedOutboxEvents of the wrapped storage.
+func (s *InstrumentedStorage) GetUnpublishedOutboxEvents(ctx context.Context, limit int) (events []*OutboxEvent, err error) {
+	ctx, done := s.start(ctx, "GetUnpublishedOutboxEvents")
+	defer done(&err)
+	return s.next.GetUnpublishedOutboxEvents(ctx, limit)
+}
+
+// MarkOutboxEventsPublished times MarkOutboxEventsPublished of the wrapped storage.
+func (s *InstrumentedStorage) MarkOutboxEventsPublished(ctx context.Context, ids []int) (err error) {
+	ctx, done := s.start(ctx, "MarkOutboxEventsPublished")
+	defer done(&err)
+	return s.next.MarkOutboxEventsPublished(ctx, ids)
+}
+
+// CreateWebhookSecret times CreateWebhookSecret of the wrapped storage.
+func (s *InstrumentedStorage) CreateWebhookSecret(ctx context.Context, secret *WebhookSecret) (err error) {
+	ctx, done := s.start(ctx, "CreateWebhookSecret")
+	defer done(&err)
+	return s.next.CreateWebhookSecret(ctx, secret)
+}
+
+// GetWebhookSecrets times GetWebhookSecrets of the wrapped storage.
+func (s *InstrumentedStorage) GetWebhookSecrets(ctx context.Context, provider string) (secrets []*WebhookSecret, err error) {
+	ctx, done := s.start(ctx, "GetWebhookSecrets")
+	defer done(&err)
+	return s.next.GetWebhookSecrets(ctx, provider)
+}
+
+// ExpireWebhookSecret times ExpireWebhookSecret of the wrapped storage.
+func (s *InstrumentedStorage) ExpireWebhookSecret(ctx context.Context, id int, at time.Time) (secret *WebhookSecret, err error) {
+	ctx, done := s.start(ctx, "ExpireWebhookSecret")
+	defer done(&err)
+	return s.next.ExpireWebhookSecret(ctx, id, at)
+}
+
+// GetPublishedOutboxEvents times GetPublishedOutboxEvents of the wrapped storage.
+func (s *InstrumentedStorage) GetPublishedOutboxEvents(ctx context.Context, filter OutboxEventFilter, afterID, limit int) (events []*OutboxEvent, err error) {
+	ctx, done := s.start(ctx, "GetPublishedOutboxEvents")
+	defer done(&err)
+	return s.next.GetPublishedOutboxEvents(ctx, filter, afterID, limit)
+}
+
+// DeletePublishedOutboxEvents times DeletePublishedOutboxEvents of the wrapped storage.
+func (s *InstrumentedStorage) DeletePublishedOutboxEvents(ctx context.Context, before time.Time, throughID int) (n int, err error) {
+	ctx, done := s.start(ctx, "DeletePublishedOutboxEvents")
+	defer done(&err)
+	return s.next.DeletePublishedOutboxEvents(ctx, before, throughID)
+}
+
+// RegisterScheduledJob times RegisterScheduledJob of the wrapped storage.
+func (s *InstrumentedStorage) RegisterScheduledJob(ctx context.Context, job *ScheduledJob) (err error) {
+	ctx, done := s.start(ctx, "RegisterScheduledJob")
+	defer done(&err)
+	return s.next.RegisterScheduledJob(ctx, job)
+}
+
+// GetScheduledJobs times GetScheduledJobs of the wrapped storage.
+func (s *InstrumentedStorage) GetScheduledJobs(ctx context.Context) (jobs []*ScheduledJob, err error) {
+	ctx, done := s.start(ctx, "GetScheduledJobs")
+	defer done(&err)
+	return s.next.GetScheduledJobs(ctx)
+}
+
+// GetScheduledJob times GetScheduledJob of the wrapped storage.
+func (s *InstrumentedStorage) GetScheduledJob(ctx context.Context, name string) (job *ScheduledJob, err error) {
+	ctx, done := s.start(ctx, "GetScheduledJob")
+	defer done(&err)
+	return s.next.GetScheduledJob(ctx, name)
+}
+
+// UpdateScheduledJob times UpdateScheduledJob of the wrapped storage.
+func (s *InstrumentedStorage) UpdateScheduledJob(ctx context.Context, job *ScheduledJob) (err error) {
+	ctx, done := s.start(ctx, "UpdateScheduledJob")
+	defer done(&err)
+	return s.next.UpdateScheduledJob(ctx, job)
+}
+
+// LockScheduledJob times LockScheduledJob of the wrapped storage.
+func (s *InstrumentedStorage) LockScheduledJob(ctx context.Context, name, owner string, now, until time.Time) (job *ScheduledJob, err error) {
+	ctx, done := s.start(ctx, "LockScheduledJob")
+	defer done(&err)
+	return s.next.LockScheduledJob(ctx, name, owner, now, until)
+}
+
+// UnlockScheduledJob times UnlockScheduledJob of the wrapped storage.
+func (s *InstrumentedStorage) UnlockScheduledJob(ctx context.Context, name, owner string, lastRunAt, nextRunAt *time.Time) (err error) {
+	ctx, done := s.start(ctx, "UnlockScheduledJob")
+	defer done(&err)
+	return s.next.UnlockScheduledJob(ctx, name, owner, lastRunAt, nextRunAt)
+}
+
+// CreateJobRun times CreateJobRun of the wrapped storage.
+func (s *InstrumentedStorage) CreateJobRun(ctx context.Context, run *JobRun) (err error) {
+	ctx, done := s.start(ctx, "CreateJobRun")
+	defer done(&err)
+	return s.next.CreateJobRun(ctx, run)
+}
+
+// FinishJobRun times FinishJobRun of the wrapped storage.
+func (s *InstrumentedStorage) FinishJobRun(ctx context.Context, run *JobRun) (err error) {
+	ctx, done := s.start(ctx, "FinishJobRun")
+	defer done(&err)
+	return s.next.FinishJobRun(ctx, run)
+}
+
+// GetJobRuns times GetJobRuns of the wrapped storage.
+func (s *InstrumentedStorage) GetJobRuns(ctx context.Context, job string, limit int) (runs []*JobRun, err error) {
+	ctx, done := s.start(ctx, "GetJobRuns")
+	defer done(&err)
+	return s.next.GetJobRuns(ctx, job, limit)
+}
+
+// ReserveIdempotencyKey times ReserveIdempotencyKey of the wrapped storage.
+func (s *InstrumentedStorage) ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) (stored *IdempotencyRecord, err error) {
+	ctx, done := s.start(ctx, "ReserveIdempotencyKey")
+	defer done(&err)
+	return s.next.ReserveIdempotencyKey(ctx, record)
+}
+
+// CompleteIdempotencyKey times CompleteIdempotencyKey of the wrapped storage.
+func (s *InstrumentedStorage) CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) (err error) {
+	ctx, done := s.start(ctx, "CompleteIdempotencyKey")
+	defer done(&err)
+	return s.next.CompleteIdempotencyKey(ctx, record)
+}
+
+// ReleaseIdempotencyKey times ReleaseIdempotencyKey of the wrapped storage.
+func (s *InstrumentedStorage) ReleaseIdempotencyKey(ctx context.Context, record *IdempotencyRecord) (err error) {
+	ctx, done := s.start(ctx, "ReleaseIdempotencyKey")
+	defer done(&err)
+	return s.next.ReleaseIdempotencyKey(ctx, record)
+}
+
+// DeleteExpiredIdempotencyKeys times DeleteExpiredIdempotencyKeys of the wrapped storage.
+func (s *InstrumentedStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (n int, err error) {
+	ctx, done := s.start(ctx, "DeleteExpiredIdempotencyKeys")
+	defer done(&err)
+	return s.next.DeleteExpiredIdempotencyKeys(ctx, now)
+}
+
+// WithTx times the whole transaction, and instruments the calls made inside it as well.
+func (s *InstrumentedStorage) WithTx(ctx context.Context, fn func(Storage) error) (err error) {
+	ctx, done := s.start(ctx, "WithTx")
+	defer done(&err)
+	return s.next.WithTx(ctx, func(tx Storage) error {
+		return fn(&InstrumentedStorage{next: tx, metrics: s.metrics})
+	})
+}
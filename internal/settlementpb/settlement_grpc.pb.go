@@ -0,0 +1,130 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: settlement/v1/settlement.proto
+
+// The settlement service moves the money of the external transfers between the banks.
+// gobank is its client, see settlement.go; `make proto` regenerates internal/settlementpb.
+
+package settlementpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SettlementService_Settle_FullMethodName = "/gobank.settlement.v1.SettlementService/Settle"
+)
+
+// SettlementServiceClient is the client API for SettlementService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SettlementServiceClient interface {
+	// Settle settles a transfer with the bank of the creditor. It is idempotent: a request
+	// sent again with the idempotency key of an earlier one returns the outcome of the
+	// earlier one, without settling the transfer twice.
+	Settle(ctx context.Context, in *SettleRequest, opts ...grpc.CallOption) (*SettleResponse, error)
+}
+
+type settlementServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSettlementServiceClient(cc grpc.ClientConnInterface) SettlementServiceClient {
+	return &settlementServiceClient{cc}
+}
+
+func (c *settlementServiceClient) Settle(ctx context.Context, in *SettleRequest, opts ...grpc.CallOption) (*SettleResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SettleResponse)
+	err := c.cc.Invoke(ctx, SettlementService_Settle_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SettlementServiceServer is the server API for SettlementService service.
+// All implementations must embed UnimplementedSettlementServiceServer
+// for forward compatibility.
+type SettlementServiceServer interface {
+	// Settle settles a transfer with the bank of the creditor. It is idempotent: a request
+	// sent again with the idempotency key of an earlier one returns the outcome of the
+	// earlier one, without settling the transfer twice.
+	Settle(context.Context, *SettleRequest) (*SettleResponse, error)
+	mustEmbedUnimplementedSettlementServiceServer()
+}
+
+// UnimplementedSettlementServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSettlementServiceServer struct{}
+
+func (UnimplementedSettlementServiceServer) Settle(context.Context, *SettleRequest) (*SettleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Settle not implemented")
+}
+func (UnimplementedSettlementServiceServer) mustEmbedUnimplementedSettlementServiceServer() {}
+func (UnimplementedSettlementServiceServer) testEmbeddedByValue()                           {}
+
+// UnsafeSettlementServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SettlementServiceServer will
+// result in compilation errors.
+type UnsafeSettlementServiceServer interface {
+	mustEmbedUnimplementedSettlementServiceServer()
+}
+
+func RegisterSettlementServiceServer(s grpc.ServiceRegistrar, srv SettlementServiceServer) {
+	// If the following call pancis, it indicates UnimplementedSettlementServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SettlementService_ServiceDesc, srv)
+}
+
+func _SettlementService_Settle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SettleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SettlementServiceServer).Settle(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SettlementService_Settle_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SettlementServiceServer).Settle(ctx, req.(*SettleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// SettlementService_ServiceDesc is the grpc.ServiceDesc for SettlementService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SettlementService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gobank.settlement.v1.SettlementService",
+	HandlerType: (*SettlementServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Settle",
+			Handler:    _SettlementService_Settle_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "settlement/v1/settlement.proto",
+}
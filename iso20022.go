@@ -0,0 +1,600 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/moabdelazem/gobank/money"
+)
+
+// ISO 20022 Codes
+const (
+	isoPaymentMethodTransfer = "TRF"
+	isoCredit                = "CRDT"
+	isoDebit                 = "DBIT"
+	isoBalanceOpeningBooked  = "OPBD"
+	isoBalanceClosingBooked  = "CLBD"
+	isoBalanceInterimBooked  = "ITBD"
+	isoEntryBooked           = "BOOK"
+	// isoDateLayout and isoDateTimeLayout are the ISODate and ISODateTime of the schemas.
+	isoDateLayout     = "2006-01-02"
+	isoDateTimeLayout = "2006-01-02T15:04:05Z"
+)
+
+// Default ISO 20022 Settings
+const (
+	defaultISO20022BankName = "GoBank"
+)
+
+// isoContentType is the media type of the ISO 20022 documents.
+const isoContentType = "application/xml; charset=utf-8"
+
+// AuditActionPaymentInitiationExport records an export of the external transfers as a
+// pain.001 message.
+const AuditActionPaymentInitiationExport = "iso20022.pain001_export"
+
+// bicPattern matches a BIC: the bank, country, and location codes, and an optional branch.
+var bicPattern = regexp.MustCompile(`^[A-Z]{6}[A-Z2-9][A-NP-Z0-9]([A-Z0-9]{3})?$`)
+
+// paymentInitiationStatuses lists the transfer states exported in a pain.001 message,
+// those of the external transfers not settled yet.
+var paymentInitiationStatuses = []string{TransferStatusPending, TransferStatusProcessing}
+
+// isoAmount is an ActiveOrHistoricCurrencyAndAmount, a decimal amount with its currency.
+type isoAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// isoAccount is a CashAccount identified by its account number, for the accounts
+// without an IBAN.
+type isoAccount struct {
+	ID struct {
+		Other struct {
+			ID string `xml:"Id"`
+		} `xml:"Othr"`
+	} `xml:"Id"`
+	Currency string    `xml:"Ccy,omitempty"`
+	Owner    *isoParty `xml:"Ownr,omitempty"`
+	Servicer *isoAgent `xml:"Svcr,omitempty"`
+}
+
+// isoParty is a PartyIdentification, named.
+type isoParty struct {
+	Name string `xml:"Nm"`
+}
+
+// isoAgent is a BranchAndFinancialInstitutionIdentification, identified by its BIC.
+type isoAgent struct {
+	FinancialInstitution struct {
+		BIC  string `xml:"BICFI"`
+		Name string `xml:"Nm,omitempty"`
+	} `xml:"FinInstnId"`
+}
+
+// newISOAccount returns the account of an account number.
+func newISOAccount(number int64) *isoAccount {
+	acc := &isoAccount{}
+	acc.ID.Other.ID = strconv.FormatInt(number, 10)
+	return acc
+}
+
+// agent returns the agent of the bank, with its name only when named is true.
+func (cfg ISO20022Config) agent(named bool) *isoAgent {
+	agent := &isoAgent{}
+	agent.FinancialInstitution.BIC = cfg.BIC
+	if named {
+		agent.FinancialInstitution.Name = cfg.BankName
+	}
+	return agent
+}
+
+// isoMoney returns an amount as an ISO amount in its currency, with the decimals of its
+// minor unit and without its sign.
+func isoMoney(m money.Money) isoAmount {
+	return isoAmount{Currency: m.Currency, Value: m.Abs().Decimal()}
+}
+
+// creditDebit returns the credit or debit indicator of a signed amount.
+func creditDebit(amount money.Money) string {
+	if amount.IsNegative() {
+		return isoDebit
+	}
+	return isoCredit
+}
+
+// Pain001Document is a CustomerCreditTransferInitiation message, pain.001.001.09:
+// the external transfers the bank asks the rails to carry out.
+type Pain001Document struct {
+	XMLName    xml.Name `xml:"urn:iso:std:iso:20022:tech:xsd:pain.001.001.09 Document"`
+	Initiation struct {
+		GroupHeader        painGroupHeader   `xml:"GrpHdr"`
+		PaymentInformation []painPaymentInfo `xml:"PmtInf"`
+	} `xml:"CstmrCdtTrfInitn"`
+}
+
+// painGroupHeader identifies a pain.001 message and totals its transactions.
+type painGroupHeader struct {
+	MessageID       string   `xml:"MsgId"`
+	CreatedAt       string   `xml:"CreDtTm"`
+	NumberOfTxs     int      `xml:"NbOfTxs"`
+	ControlSum      string   `xml:"CtrlSum"`
+	InitiatingParty isoParty `xml:"InitgPty"`
+}
+
+// painPaymentInfo groups the transfers of one debtor account.
+type painPaymentInfo struct {
+	ID            string `xml:"PmtInfId"`
+	Method        string `xml:"PmtMtd"`
+	NumberOfTxs   int    `xml:"NbOfTxs"`
+	ControlSum    string `xml:"CtrlSum"`
+	RequestedDate struct {
+		Date string `xml:"Dt"`
+	} `xml:"ReqdExctnDt"`
+	Debtor        isoParty             `xml:"Dbtr"`
+	DebtorAccount *isoAccount          `xml:"DbtrAcct"`
+	DebtorAgent   *isoAgent            `xml:"DbtrAgt"`
+	Transactions  []painCreditTransfer `xml:"CdtTrfTxInf"`
+}
+
+// painCreditTransfer is one external transfer of a pain.001 message.
+type painCreditTransfer struct {
+	PaymentID struct {
+		InstructionID string `xml:"InstrId"`
+		EndToEndID    string `xml:"EndToEndId"`
+	} `xml:"PmtId"`
+	Amount struct {
+		Instructed isoAmount `xml:"InstdAmt"`
+	} `xml:"Amt"`
+	CreditorAgent   *isoAgent      `xml:"CdtrAgt"`
+	Creditor        isoParty       `xml:"Cdtr"`
+	CreditorAccount *isoAccount    `xml:"CdtrAcct"`
+	Remittance      *isoRemittance `xml:"RmtInf,omitempty"`
+}
+
+// isoRemittance is the unstructured remittance information of a payment.
+type isoRemittance struct {
+	Unstructured string `xml:"Ustrd"`
+}
+
+// Camt053Document is a BankToCustomerStatement message, camt.053.001.08: the booked
+// entries of an account over a statement period, with its balances.
+type Camt053Document struct {
+	XMLName   xml.Name `xml:"urn:iso:std:iso:20022:tech:xsd:camt.053.001.08 Document"`
+	Statement struct {
+		GroupHeader struct {
+			MessageID string `xml:"MsgId"`
+			CreatedAt string `xml:"CreDtTm"`
+		} `xml:"GrpHdr"`
+		Statement camtStatement `xml:"Stmt"`
+	} `xml:"BkToCstmrStmt"`
+}
+
+// camtStatement is the statement of one account.
+type camtStatement struct {
+	ID        string `xml:"Id"`
+	CreatedAt string `xml:"CreDtTm"`
+	Period    struct {
+		From string `xml:"FrDtTm"`
+		To   string `xml:"ToDtTm"`
+	} `xml:"FrToDt"`
+	Account  *isoAccount    `xml:"Acct"`
+	Balances []camtBalance  `xml:"Bal"`
+	Summary  camtTxsSummary `xml:"TxsSummry"`
+	Entries  []camtEntry    `xml:"Ntry"`
+}
+
+// camtBalance is a booked balance of the account at a date.
+type camtBalance struct {
+	Type struct {
+		CodeOrProprietary struct {
+			Code string `xml:"Cd"`
+		} `xml:"CdOrPrtry"`
+	} `xml:"Tp"`
+	Amount      isoAmount `xml:"Amt"`
+	CreditDebit string    `xml:"CdtDbtInd"`
+	Date        struct {
+		Date string `xml:"Dt"`
+	} `xml:"Dt"`
+}
+
+// camtTxsSummary totals the entries of the statement.
+type camtTxsSummary struct {
+	Total   camtEntriesTotal `xml:"TtlNtries"`
+	Credits camtEntriesTotal `xml:"TtlCdtNtries"`
+	Debits  camtEntriesTotal `xml:"TtlDbtNtries"`
+}
+
+// camtEntriesTotal is the number and the sum of a set of entries.
+type camtEntriesTotal struct {
+	Count int    `xml:"NbOfNtries"`
+	Sum   string `xml:"Sum"`
+}
+
+// camtEntry is a booked ledger entry of the account.
+type camtEntry struct {
+	Reference   string    `xml:"NtryRef"`
+	Amount      isoAmount `xml:"Amt"`
+	CreditDebit string    `xml:"CdtDbtInd"`
+	Status      struct {
+		Code string `xml:"Cd"`
+	} `xml:"Sts"`
+	BookingDate struct {
+		DateTime string `xml:"DtTm"`
+	} `xml:"BookgDt"`
+	ValueDate struct {
+		DateTime string `xml:"DtTm"`
+	} `xml:"ValDt"`
+	ServicerReference string `xml:"AcctSvcrRef"`
+	TransactionCode   struct {
+		Proprietary struct {
+			Code   string `xml:"Cd"`
+			Issuer string `xml:"Issr"`
+		} `xml:"Prtry"`
+	} `xml:"BkTxCd"`
+	Details *camtEntryDetails `xml:"NtryDtls,omitempty"`
+}
+
+// camtEntryDetails carries the counterparty and the remittance of an entry.
+type camtEntryDetails struct {
+	Transaction struct {
+		RelatedParties *camtRelatedParties `xml:"RltdPties,omitempty"`
+		Remittance     *isoRemittance      `xml:"RmtInf,omitempty"`
+	} `xml:"TxDtls"`
+}
+
+// camtRelatedParties is the counterparty account of a transfer: the debtor of an
+// incoming one, the creditor of an outgoing one.
+type camtRelatedParties struct {
+	DebtorAccount   *isoAccount `xml:"DbtrAcct,omitempty"`
+	CreditorAccount *isoAccount `xml:"CdtrAcct,omitempty"`
+}
+
+// accountCache looks the accounts of an export up once each.
+type accountCache struct {
+	store    Storage
+	accounts map[int]*Account
+}
+
+// get returns the account of an ID, from the store the first time.
+func (c *accountCache) get(ctx context.Context, id int) (*Account, error) {
+	if acc, ok := c.accounts[id]; ok {
+		return acc, nil
+	}
+
+	acc, err := c.store.GetAccountById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.accounts[id] = acc
+	return acc, nil
+}
+
+// buildPain001 builds the pain.001 message of external transfers, with one payment
+// information block per debtor account, in the order of their first transfer.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - messageID: The identification of the message, at most 35 characters.
+//   - transfers: The transfers to initiate, oldest first.
+//
+// Returns:
+//   - *Pain001Document: The message.
+//   - error: An error if an account of a transfer cannot be read.
+func (as *APIServer) buildPain001(ctx context.Context, messageID string, transfers []*Transfer) (*Pain001Document, error) {
+	cfg := as.config.ISO20022
+	now := time.Now().UTC()
+	accounts := &accountCache{store: as.store, accounts: map[int]*Account{}}
+
+	doc := &Pain001Document{}
+	header := &doc.Initiation.GroupHeader
+	header.MessageID = messageID
+	header.CreatedAt = now.Format(isoDateTimeLayout)
+	header.InitiatingParty = isoParty{Name: cfg.BankName}
+
+	// The Payment Information Block And Its Sum, By Debtor Account
+	blocks := map[int]int{}
+	sums := map[int]money.Money{}
+	total, decimals := new(big.Rat), 0
+	for _, transfer := range transfers {
+		from, err := accounts.get(ctx, transfer.FromAccountID)
+		if err != nil {
+			return nil, err
+		}
+		to, err := accounts.get(ctx, transfer.ToAccountID)
+		if err != nil {
+			return nil, err
+		}
+
+		i, ok := blocks[from.ID]
+		if !ok {
+			i = len(doc.Initiation.PaymentInformation)
+			blocks[from.ID] = i
+			info := painPaymentInfo{
+				ID:            fmt.Sprintf("%.24s-%d", messageID, i+1),
+				Method:        isoPaymentMethodTransfer,
+				Debtor:        isoParty{Name: from.FirstName + " " + from.LastName},
+				DebtorAccount: newISOAccount(from.Number),
+				DebtorAgent:   cfg.agent(false),
+			}
+			info.DebtorAccount.Currency = from.Currency
+			sums[from.ID] = money.Zero(from.Currency)
+			info.RequestedDate.Date = now.Format(isoDateLayout)
+			doc.Initiation.PaymentInformation = append(doc.Initiation.PaymentInformation, info)
+		}
+
+		tx := painCreditTransfer{
+			CreditorAgent:   cfg.agent(false),
+			Creditor:        isoParty{Name: to.FirstName + " " + to.LastName},
+			CreditorAccount: newISOAccount(to.Number),
+		}
+		tx.PaymentID.InstructionID = strconv.Itoa(transfer.ID)
+		tx.PaymentID.EndToEndID = settlementIdempotencyKey(transfer.ID)
+		tx.Amount.Instructed = isoMoney(transfer.Amount)
+		if transfer.ProviderReference != "" {
+			tx.Remittance = &isoRemittance{Unstructured: transfer.ProviderReference}
+		}
+
+		info := &doc.Initiation.PaymentInformation[i]
+		info.Transactions = append(info.Transactions, tx)
+		info.NumberOfTxs++
+		if sums[from.ID], err = sums[from.ID].Add(transfer.Amount); err != nil {
+			return nil, err
+		}
+		info.ControlSum = sums[from.ID].Decimal()
+
+		// The Message Sum Adds The Amounts Whatever Their Currency, As The Standard Defines
+		total.Add(total, transfer.Amount.Rat())
+		decimals = max(decimals, money.Exponent(transfer.Amount.Currency))
+	}
+
+	header.NumberOfTxs = len(transfers)
+	header.ControlSum = total.FloatString(decimals)
+	return doc, nil
+}
+
+// buildCamt053 builds the camt.053 message of a statement. The closing balance is
+// booked at the end of a closed period, and an interim one at the generation time of
+// the statement of the running month.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - stmt: The statement, as built by buildStatement.
+//
+// Returns:
+//   - *Camt053Document: The message.
+//   - error: An error if a counterparty account cannot be read.
+func (as *APIServer) buildCamt053(ctx context.Context, stmt *Statement) (*Camt053Document, error) {
+	cfg := as.config.ISO20022
+	accounts := &accountCache{store: as.store, accounts: map[int]*Account{}}
+
+	doc := &Camt053Document{}
+	id := fmt.Sprintf("%d-%s", stmt.AccountNumber, stmt.Period)
+	doc.Statement.GroupHeader.MessageID = "STMT-" + id
+	doc.Statement.GroupHeader.CreatedAt = stmt.GeneratedAt.Format(isoDateTimeLayout)
+
+	s := &doc.Statement.Statement
+	s.ID = id
+	s.CreatedAt = stmt.GeneratedAt.Format(isoDateTimeLayout)
+	s.Period.From = stmt.PeriodStart.Format(isoDateTimeLayout)
+	s.Period.To = stmt.PeriodEnd.Add(-time.Second).Format(isoDateTimeLayout)
+	s.Account = newISOAccount(stmt.AccountNumber)
+	s.Account.Currency = stmt.Currency
+	s.Account.Owner = &isoParty{Name: stmt.AccountHolder}
+	s.Account.Servicer = cfg.agent(true)
+
+	closingCode, closingDate := isoBalanceClosingBooked, stmt.PeriodEnd.AddDate(0, 0, -1)
+	if !stmt.isClosed() {
+		closingCode, closingDate = isoBalanceInterimBooked, stmt.GeneratedAt
+	}
+	s.Balances = []camtBalance{
+		camtBookedBalance(isoBalanceOpeningBooked, stmt.OpeningBalance, stmt.PeriodStart),
+		camtBookedBalance(closingCode, stmt.ClosingBalance, closingDate),
+	}
+
+	credits, debits := 0, 0
+	for _, t := range stmt.Transactions {
+		entry := camtEntry{
+			Reference:         strconv.Itoa(t.ID),
+			Amount:            isoMoney(t.Amount),
+			CreditDebit:       creditDebit(t.Amount),
+			ServicerReference: strconv.Itoa(t.ID),
+		}
+		entry.Status.Code = isoEntryBooked
+		entry.BookingDate.DateTime = t.CreatedAt.UTC().Format(isoDateTimeLayout)
+		entry.ValueDate.DateTime = entry.BookingDate.DateTime
+		entry.TransactionCode.Proprietary.Code = t.Type
+		entry.TransactionCode.Proprietary.Issuer = cfg.BankName
+
+		var details camtEntryDetails
+		if t.Type == TransactionTypeTransfer && t.CounterpartyID != 0 {
+			counterparty, err := accounts.get(ctx, t.CounterpartyID)
+			if err != nil {
+				return nil, err
+			}
+			parties := &camtRelatedParties{}
+			if t.Amount.IsNegative() {
+				parties.CreditorAccount = newISOAccount(counterparty.Number)
+			} else {
+				parties.DebtorAccount = newISOAccount(counterparty.Number)
+			}
+			details.Transaction.RelatedParties = parties
+		}
+		if t.Reference != "" {
+			details.Transaction.Remittance = &isoRemittance{Unstructured: t.Reference}
+		}
+		if details.Transaction.RelatedParties != nil || details.Transaction.Remittance != nil {
+			entry.Details = &details
+		}
+
+		if t.Amount.IsNegative() {
+			debits++
+		} else {
+			credits++
+		}
+		s.Entries = append(s.Entries, entry)
+	}
+
+	turnover, err := stmt.TotalCredits.Add(stmt.TotalDebits)
+	if err != nil {
+		return nil, err
+	}
+	s.Summary.Total = camtEntriesTotal{Count: len(stmt.Transactions), Sum: turnover.Decimal()}
+	s.Summary.Credits = camtEntriesTotal{Count: credits, Sum: stmt.TotalCredits.Decimal()}
+	s.Summary.Debits = camtEntriesTotal{Count: debits, Sum: stmt.TotalDebits.Decimal()}
+	return doc, nil
+}
+
+// camtBookedBalance returns a booked balance of the type code at a date.
+func camtBookedBalance(code string, amount money.Money, at time.Time) camtBalance {
+	bal := camtBalance{Amount: isoMoney(amount), CreditDebit: creditDebit(amount)}
+	bal.Type.CodeOrProprietary.Code = code
+	bal.Date.Date = at.UTC().Format(isoDateLayout)
+	return bal
+}
+
+// writeISODocument writes an ISO 20022 message as an XML document.
+func writeISODocument(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// handleGetStatementCamt053 handles the HTTP request to download the monthly statement
+// of an account as an ISO 20022 camt.053 message, for the accounting software and the
+// banks that import statements. Conditional requests are answered with 304 Not Modified
+// while the statement is unchanged.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the XML document to.
+//   - r: *http.Request containing the account ID and the period.
+//
+// Returns:
+//   - error: An error if the statement cannot be generated, otherwise nil.
+func (as *APIServer) handleGetStatementCamt053(w http.ResponseWriter, r *http.Request) error {
+	// Get The ID From The URL
+	id := getId(w, r)
+
+	stmt, err := as.buildStatement(r.Context(), id, mux.Vars(r)["period"])
+	if err != nil {
+		return err
+	}
+
+	setStatementCacheHeaders(w, stmt)
+	if checkNotModified(w, r, statementETag(stmt, "camt053"), stmt.lastModified()) {
+		return nil
+	}
+
+	doc, err := as.buildCamt053(r.Context(), stmt)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", isoContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"statement-%d-%s.xml\"", stmt.AccountNumber, stmt.Period))
+	w.WriteHeader(http.StatusOK)
+	return writeISODocument(w, doc)
+}
+
+// handleAdminExportPain001 exports the external transfers awaiting settlement, those
+// sent with a provider reference that are still pending or processing, as an ISO 20022
+// pain.001 message for the payment rails; the "status" query parameter narrows them to
+// one of the states. The transfers are identified end to end by their settlement
+// idempotency key, gobank-transfer-<id>, and the message by the request ID. Without
+// transfers to initiate the response is a 204 No Content. Every export is recorded in
+// the audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the XML document to.
+//   - r: *http.Request containing the optional status.
+//
+// Returns:
+//   - error: A validation error for an unknown status, or an error if the transfers or
+//     their accounts cannot be read.
+func (as *APIServer) handleAdminExportPain001(w http.ResponseWriter, r *http.Request) error {
+	statuses := paymentInitiationStatuses
+	if status := r.URL.Query().Get("status"); status != "" {
+		if !slices.Contains(paymentInitiationStatuses, status) {
+			return newValidationError([]FieldError{{Field: "status", Code: CodeInvalid, Message: fmt.Sprintf("status must be %s or %s", TransferStatusPending, TransferStatusProcessing)}})
+		}
+		statuses = []string{status}
+	}
+
+	var transfers []*Transfer
+	for _, status := range statuses {
+		found, err := as.store.GetTransfersByStatus(r.Context(), status)
+		if err != nil {
+			return err
+		}
+		for _, transfer := range found {
+			if transfer.ProviderReference != "" {
+				transfers = append(transfers, transfer)
+			}
+		}
+	}
+	slices.SortFunc(transfers, func(a, b *Transfer) int { return a.ID - b.ID })
+
+	messageID := requestIDFromContext(r.Context())
+	if messageID == "" {
+		messageID = newRequestID()
+	}
+	as.audit(r, AuditActionPaymentInitiationExport, "transfers", map[string]interface{}{"message_id": messageID, "transfers": len(transfers), "statuses": statuses})
+
+	if len(transfers) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	doc, err := as.buildPain001(r.Context(), messageID, transfers)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", isoContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"pain001-%s.xml\"", messageID))
+	w.WriteHeader(http.StatusOK)
+	return writeISODocument(w, doc)
+}
+
+// registerISO20022Routes registers the ISO 20022 statement of the accounts, enabled by
+// iso20022.bic.
+//
+// Routes:
+// - GET /api/v1/account/{id:[0-9]+}/statements/{period}.xml: Downloads the monthly statement as camt.053.
+func (as *APIServer) registerISO20022Routes(subRouter *mux.Router) {
+	if as.config.ISO20022.BIC == "" {
+		return
+	}
+
+	subRouter.HandleFunc("/account/{id:[0-9]+}/statements/{period:[0-9]{4}-[0-9]{2}}.xml", withJWTAuth(makeHTTPHandlerFunc(as.handleGetStatementCamt053), as.store)).Methods(http.MethodGet)
+}
+
+// registerPaymentInitiationRoutes registers the ISO 20022 payment initiation export on
+// the admin subrouter, enabled by iso20022.bic.
+//
+// Routes:
+// - GET /admin/iso20022/pain.001: Exports the external transfers awaiting settlement as pain.001.
+func (as *APIServer) registerPaymentInitiationRoutes(admin *mux.Router) {
+	if as.config.ISO20022.BIC == "" {
+		return
+	}
+
+	admin.HandleFunc("/iso20022/pain.001", makeHTTPHandlerFunc(as.handleAdminExportPain001)).Methods(http.MethodGet)
+}
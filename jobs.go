@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log/slog"
+	"time"
+)
+
+// jobLockNamespace is the first key of the advisory locks of the background jobs, the
+// second is the hash of the job name; migrationLockID uses the single key form.
+const jobLockNamespace = 72653408
+
+// jobLocker is implemented by the stores able to elect the instance running a job.
+type jobLocker interface {
+	// TryJobLock takes the lock of a job, or returns nil when another instance holds it.
+	TryJobLock(ctx context.Context, job string) (jobLock, error)
+}
+
+// jobLock is the lock of a job, held until it is released or its connection is lost.
+type jobLock interface {
+	// Held reports whether the lock is still held.
+	Held(ctx context.Context) bool
+	// Release gives the lock up, for another instance to take.
+	Release()
+}
+
+// runScheduledJob runs fn at once and then every interval until ctx is cancelled, on a
+// single instance of the fleet when the store can coordinate them: the instance holding
+// the advisory lock of the job runs it, and the others take the lock over when that
+// instance stops or loses its database connection. Stores without locks, such as the
+// in-memory one, run the job on every instance.
+//
+// Parameters:
+//   - ctx: Stops the job and releases its lock when cancelled.
+//   - job: The name of the job, which names its lock.
+//   - interval: How often the job runs.
+//   - fn: The job.
+func (as *APIServer) runScheduledJob(ctx context.Context, job string, interval time.Duration, fn func(ctx context.Context)) {
+	ctx = withLogAttrs(ctx, slog.String("job", job))
+	locker, coordinated := unwrapStorage(as.store).(jobLocker)
+
+	var lock jobLock
+	defer func() {
+		if lock != nil {
+			lock.Release()
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if coordinated {
+			lock = holdJobLock(ctx, locker, lock, job)
+		}
+		if !coordinated || lock != nil {
+			fn(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// holdJobLock returns the lock of a job, checking that the lock already held is still
+// held, or trying to take it. It returns nil when another instance runs the job.
+func holdJobLock(ctx context.Context, locker jobLocker, lock jobLock, job string) jobLock {
+	if lock != nil {
+		if lock.Held(ctx) {
+			return lock
+		}
+		slog.WarnContext(ctx, "Lost The Job Lock")
+		lock.Release()
+	}
+
+	lock, err := locker.TryJobLock(ctx, job)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error Taking The Job Lock", "error", err)
+		return nil
+	}
+	if lock != nil {
+		slog.InfoContext(ctx, "Running The Job On This Instance")
+	}
+	return lock
+}
+
+// TryJobLock takes the advisory lock of a job on a connection of its own, which the
+// lock belongs to until it is released.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - job: The name of the job.
+//
+// Returns:
+//   - jobLock: The lock, nil when another instance holds it.
+//   - error: An error object if the lock cannot be queried, otherwise nil.
+func (s *PostgresStorage) TryJobLock(ctx context.Context, job string) (jobLock, error) {
+	// Advisory Locks Belong To A Session, So Keep One Connection
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1::int, hashtext($2))`, jobLockNamespace, job).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+
+	return &postgresJobLock{conn: conn, job: job}, nil
+}
+
+// postgresJobLock is an advisory lock held by a connection taken out of the pool.
+type postgresJobLock struct {
+	conn *sql.Conn
+	job  string
+}
+
+// Held reports whether the connection holding the lock is still alive.
+func (l *postgresJobLock) Held(ctx context.Context) bool {
+	return l.conn.PingContext(ctx) == nil
+}
+
+// Release unlocks the job and gives the connection back to the pool, or closes it when
+// unlocking failed, which drops the lock with the session.
+func (l *postgresJobLock) Release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := l.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1::int, hashtext($2))`, jobLockNamespace, l.job); err != nil {
+		l.conn.Raw(func(any) error {
+			return driver.ErrBadConn
+		})
+	}
+	l.conn.Close()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Default Kafka Settings
+const (
+	defaultKafkaTopicPrefix  = "gobank."
+	defaultKafkaWriteTimeout = 10 * time.Second
+)
+
+// kafkaBatchTimeout bounds how long the writer waits to fill a batch. The relay writes
+// its batches whole, so waiting for more messages would only delay them.
+const kafkaBatchTimeout = 10 * time.Millisecond
+
+// KafkaPublisher publishes the outbox events to Kafka, on one topic per event type:
+// the topic prefix followed by the type, such as gobank.account.created. The messages
+// are keyed by account ID, so the events of an account keep their order within its
+// partition.
+type KafkaPublisher struct {
+	writer *kafka.Writer
+	prefix string
+}
+
+// NewKafkaPublisher creates a publisher writing to the configured brokers. The brokers
+// are connected to on the first write, and a write succeeds once every in-sync replica
+// has the messages.
+//
+// Parameters:
+//   - cfg: The brokers, the topic prefix, and the write timeout.
+//
+// Returns:
+//   - *KafkaPublisher: The publisher, to be closed on shutdown.
+func NewKafkaPublisher(cfg KafkaConfig) *KafkaPublisher {
+	slog.Info("Publishing Domain Events To Kafka", "brokers", cfg.Brokers, "topic_prefix", cfg.TopicPrefix)
+
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: kafkaBatchTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		},
+		prefix: cfg.TopicPrefix,
+	}
+}
+
+// Publish writes a batch of events to their topics, all of them or, on error, possibly
+// only some: the relay publishes the whole batch again, so consumers must expect an
+// event more than once and can tell the copies apart by the dedupe_key header, which a
+// replay keeps too.
+//
+// Parameters:
+//   - ctx: The context of the write.
+//   - events: The events to publish, oldest first.
+//
+// Returns:
+//   - error: An error if an event could not be encoded or written.
+func (p *KafkaPublisher) Publish(ctx context.Context, events []*OutboxEvent) error {
+	messages := make([]kafka.Message, 0, len(events))
+	for _, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		headers := []kafka.Header{
+			{Key: "event_id", Value: []byte(strconv.Itoa(event.ID))},
+			{Key: "event_type", Value: []byte(event.Type)},
+			{Key: "dedupe_key", Value: []byte(event.DedupeKey())},
+		}
+		if event.ReplayID != "" {
+			headers = append(headers, kafka.Header{Key: "replay_id", Value: []byte(event.ReplayID)})
+		}
+
+		messages = append(messages, kafka.Message{
+			Topic:   p.prefix + event.Type,
+			Key:     []byte(strconv.Itoa(event.AccountID)),
+			Value:   value,
+			Headers: headers,
+		})
+	}
+
+	if err := p.writer.WriteMessages(ctx, messages...); err != nil {
+		eventPublishFailures.WithLabelValues(eventTransportKafka).Inc()
+		return err
+	}
+
+	for _, event := range events {
+		eventsPublished.WithLabelValues(eventTransportKafka, event.Type).Inc()
+	}
+	return nil
+}
+
+// Close flushes the pending writes and closes the connections to the brokers.
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// KYC Statuses, Of The Checks And Of The Accounts They Verify
+const (
+	// KYCStatusNotStarted is an account that was never checked, such as one created
+	// before the KYC provider was configured.
+	KYCStatusNotStarted = "not_started"
+	// KYCStatusPending is a check waiting to be submitted, or for the documents of the
+	// account holder.
+	KYCStatusPending = "pending"
+	// KYCStatusReview is a check the provider hands to a human analyst.
+	KYCStatusReview   = "review"
+	KYCStatusApproved = "approved"
+	KYCStatusDeclined = "declined"
+	// KYCStatusFailed is a check that ended without a verdict, as when the account holder
+	// abandoned it; the holder may start another one.
+	KYCStatusFailed = "failed"
+)
+
+// kycOpenStatuses are the statuses of the checks still waiting for a result.
+var kycOpenStatuses = []string{KYCStatusPending, KYCStatusReview}
+
+// KYC Providers
+const (
+	kycProviderOnfido = "onfido"
+)
+
+// kycProviders lists the valid values of kyc.provider; none when empty.
+var kycProviders = []string{kycProviderOnfido}
+
+// Default KYC Settings
+const (
+	defaultKYCPollInterval = 10 * time.Minute
+	// kycSyncBatchSize bounds the open checks submitted or polled in one round.
+	kycSyncBatchSize = 100
+	// kycWebhookMaxSize bounds the events of the webhook, which name one check.
+	kycWebhookMaxSize = 64 << 10
+)
+
+// ErrKYCApplicantRejected is returned by a KYCProvider refusing the data of an applicant,
+// which would be refused again; the check fails instead of being submitted again.
+var ErrKYCApplicantRejected = errors.New("kyc provider rejected the applicant")
+
+// kycResults counts the KYC results applied to the accounts, registered by newMetricsRegistry.
+var kycResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace, Subsystem: "kyc", Name: "results_total",
+	Help: "Number of KYC check results changing the status of an account, by provider and status.",
+}, []string{"provider", "status"})
+
+// KYCProvider verifies the identity of the account holders, the plug-in point of
+// kyc.provider. A check is submitted when the account is created, and its result
+// arrives later, through the webhook of the provider or by polling it.
+type KYCProvider interface {
+	// Name names the provider in the checks, the logs, and the metrics, such as onfido.
+	Name() string
+	// Submit starts the check of an applicant; ErrKYCApplicantRejected when the provider
+	// refuses their data.
+	Submit(ctx context.Context, applicant KYCApplicant) (*KYCSubmission, error)
+	// Status fetches the result of a check from the provider.
+	Status(ctx context.Context, providerCheckID string) (*KYCResult, error)
+	// ParseWebhook returns the ID of the check a webhook event is about, empty for an
+	// event about something else. The signature of the event is verified before, by the
+	// webhookSpec of the provider.
+	ParseWebhook(body []byte) (string, error)
+}
+
+// newKYCProvider creates the KYC provider of cfg.
+//
+// Parameters:
+//   - cfg: The KYC settings, with the provider and its settings.
+//
+// Returns:
+//   - KYCProvider: The provider, nil when none is configured.
+func newKYCProvider(cfg KYCConfig) KYCProvider {
+	switch cfg.Provider {
+	case kycProviderOnfido:
+		return newOnfidoKYCProvider(cfg.Onfido)
+	}
+	return nil
+}
+
+// KYCApplicant is the account holder submitted to the provider.
+type KYCApplicant struct {
+	AccountID int
+	FirstName string
+	LastName  string
+}
+
+// KYCSubmission is a check started at the provider.
+type KYCSubmission struct {
+	ProviderCheckID string
+	// URL is where the account holder uploads their documents, empty when the provider
+	// collects them otherwise.
+	URL    string
+	Status string
+}
+
+// KYCResult is the state of a check at the provider.
+type KYCResult struct {
+	Status string
+	// Reason explains a declined or failed check.
+	Reason string
+}
+
+// KYCCheck is the identity check of an account holder at the KYC provider, whose
+// result drives the KYC status of the account.
+type KYCCheck struct {
+	ID        int    `json:"id"`
+	AccountID int    `json:"account_id"`
+	Provider  string `json:"provider"`
+	// ProviderCheckID is the check at the provider, such as an Onfido workflow run;
+	// empty until the check is submitted.
+	ProviderCheckID string `json:"provider_check_id,omitempty"`
+	Status          string `json:"status"`
+	// URL is where the account holder uploads their documents.
+	URL string `json:"url,omitempty"`
+	// FailureReason explains a declined or failed check.
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Open reports whether the check is still waiting for a result.
+func (c *KYCCheck) Open() bool {
+	return slices.Contains(kycOpenStatuses, c.Status)
+}
+
+// KYCStatusChange is the payload of an account.kyc_status_changed event.
+type KYCStatusChange struct {
+	*KYCCheck
+	PreviousStatus string `json:"previous_status"`
+}
+
+// KYCWebhookResponse is the response of POST /webhooks/kyc.
+type KYCWebhookResponse struct {
+	Result    string `json:"result"`
+	AccountID int    `json:"account_id,omitempty"`
+	Status    string `json:"status,omitempty"`
+}
+
+// nudgeKYCSync wakes the KYC sync up to submit a new check, without waiting for it.
+func (as *APIServer) nudgeKYCSync() {
+	select {
+	case as.kycNudge <- struct{}{}:
+	default:
+	}
+}
+
+// runKYCSync submits the new checks to the provider and polls the open ones until ctx
+// is cancelled, every kyc.poll_interval and whenever a check is started. Polling
+// catches the results whose webhook was lost; a check is only polled when nothing
+// updated it for a poll interval.
+func (as *APIServer) runKYCSync(ctx context.Context) {
+	ticker := time.NewTicker(as.config.KYC.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		as.syncKYCChecks(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-as.kycNudge:
+		}
+	}
+}
+
+// syncKYCChecks submits or polls one batch of open checks, the unsubmitted ones first.
+func (as *APIServer) syncKYCChecks(ctx context.Context) {
+	checks, err := as.store.GetOpenKYCChecks(ctx, kycSyncBatchSize)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error Loading The Open KYC Checks", "error", err)
+		return
+	}
+
+	for _, check := range checks {
+		if ctx.Err() != nil {
+			return
+		}
+		if check.ProviderCheckID == "" {
+			as.submitKYCCheck(ctx, check)
+			continue
+		}
+		// The Rest Were Updated Even More Recently
+		if time.Since(check.UpdatedAt) < as.config.KYC.PollInterval {
+			return
+		}
+
+		result, err := as.kyc.Status(ctx, check.ProviderCheckID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error Polling KYC Check", "check_id", check.ID, "provider", check.Provider, "error", err)
+			continue
+		}
+		if err := as.applyKYCResult(ctx, check, result); err != nil && !errors.Is(err, ErrKYCCheckStatusChanged) {
+			slog.ErrorContext(ctx, "Error Applying KYC Result", "check_id", check.ID, "status", result.Status, "error", err)
+		}
+	}
+}
+
+// submitKYCCheck submits a new check with the holder of its account, and stores the
+// check at the provider. A check the provider cannot take now is submitted again on
+// the next round; one it refuses fails.
+func (as *APIServer) submitKYCCheck(ctx context.Context, check *KYCCheck) {
+	account, err := as.store.GetAccountById(ctx, check.AccountID)
+	if errors.Is(err, ErrAccountNotFound) {
+		// The Account Was Deleted Before Its Check Was Submitted
+		check.Status, check.FailureReason = KYCStatusFailed, "the account was deleted"
+		if err := as.store.UpdateKYCCheck(ctx, check, KYCStatusPending); err != nil {
+			slog.ErrorContext(ctx, "Error Failing The KYC Check Of A Deleted Account", "check_id", check.ID, "account_id", check.AccountID, "error", err)
+		}
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error Loading The Account Of A KYC Check", "check_id", check.ID, "account_id", check.AccountID, "error", err)
+		return
+	}
+
+	submission, err := as.kyc.Submit(ctx, KYCApplicant{AccountID: account.ID, FirstName: account.FirstName, LastName: account.LastName})
+	if errors.Is(err, ErrKYCApplicantRejected) {
+		slog.WarnContext(ctx, "KYC Provider Rejected The Applicant", "check_id", check.ID, "account_id", check.AccountID, "error", err)
+		if err := as.applyKYCResult(ctx, check, &KYCResult{Status: KYCStatusFailed, Reason: err.Error()}); err != nil {
+			slog.ErrorContext(ctx, "Error Applying KYC Result", "check_id", check.ID, "status", KYCStatusFailed, "error", err)
+		}
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error Submitting KYC Check", "check_id", check.ID, "provider", check.Provider, "error", err)
+		return
+	}
+
+	// A Webhook Arriving Meanwhile Finds No Check, And Is Delivered Again
+	check.ProviderCheckID, check.URL = submission.ProviderCheckID, submission.URL
+	if err := as.store.UpdateKYCCheck(ctx, check, KYCStatusPending); err != nil {
+		slog.ErrorContext(ctx, "Error Storing Submitted KYC Check", "check_id", check.ID, "provider_check_id", submission.ProviderCheckID, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "KYC Check Submitted", "check_id", check.ID, "account_id", check.AccountID, "provider_check_id", check.ProviderCheckID)
+
+	if submission.Status == check.Status {
+		return
+	}
+	if err := as.applyKYCResult(ctx, check, &KYCResult{Status: submission.Status}); err != nil {
+		slog.ErrorContext(ctx, "Error Applying KYC Result", "check_id", check.ID, "status", submission.Status, "error", err)
+	}
+}
+
+// applyKYCResult moves an open check, and the KYC status of its account, to the result
+// of the provider in one transaction, publishing an account.kyc_status_changed event
+// through the outbox. An unchanged result only marks the check as polled.
+//
+// Parameters:
+//   - ctx: The context of the database work.
+//   - check: The open check; it is updated in place.
+//   - result: The result of the provider.
+//
+// Returns:
+//   - error: ErrKYCCheckStatusChanged if the check moved meanwhile, as when the webhook
+//     and the poll race, or the error of the store.
+func (as *APIServer) applyKYCResult(ctx context.Context, check *KYCCheck, result *KYCResult) error {
+	if result.Status == check.Status {
+		return as.store.UpdateKYCCheck(ctx, check, check.Status)
+	}
+
+	// Change A Copy, The Check Stays As Stored If The Transaction Rolls Back
+	previous := check.Status
+	updated := *check
+	updated.Status, updated.FailureReason = result.Status, result.Reason
+	err := as.store.WithTx(ctx, func(tx Storage) error {
+		if err := tx.UpdateKYCCheck(ctx, &updated, previous); err != nil {
+			return err
+		}
+		if err := tx.SetAccountKYCStatus(ctx, updated.AccountID, updated.Status); err != nil {
+			return err
+		}
+
+		event, err := newOutboxEvent(EventKYCStatusChanged, updated.AccountID, &KYCStatusChange{KYCCheck: &updated, PreviousStatus: previous})
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(ctx, []*OutboxEvent{event})
+	})
+	if err != nil {
+		return err
+	}
+
+	*check = updated
+	kycResults.WithLabelValues(check.Provider, check.Status).Inc()
+	slog.InfoContext(ctx, "KYC Status Changed", "check_id", check.ID, "account_id", check.AccountID, "status", check.Status, "previous_status", previous)
+	return nil
+}
+
+// handleGetKYCCheck returns the latest KYC check of an account, with the link where the
+// account holder uploads their documents.
+func (as *APIServer) handleGetKYCCheck(w http.ResponseWriter, r *http.Request) error {
+	check, err := as.store.GetKYCCheck(r.Context(), getId(w, r))
+	if err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusOK, check)
+}
+
+// handleStartKYCCheck starts a new KYC check of an account never checked, or whose last
+// check failed, and wakes the KYC sync up to submit it.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request for the account.
+//
+// Returns:
+//   - error: A 409 if the account has an open, approved, or declined check.
+func (as *APIServer) handleStartKYCCheck(w http.ResponseWriter, r *http.Request) error {
+	account, err := as.store.GetAccountById(r.Context(), getId(w, r))
+	if err != nil {
+		return err
+	}
+
+	latest, err := as.store.GetKYCCheck(r.Context(), account.ID)
+	if err != nil && !errors.Is(err, ErrKYCCheckNotFound) {
+		return err
+	}
+	if latest != nil && latest.Status != KYCStatusFailed {
+		return NewTypedError(http.StatusConflict, "kyc_check_exists", "the account has a kyc check already, only a failed one can be started again")
+	}
+
+	check := &KYCCheck{AccountID: account.ID, Provider: as.kyc.Name(), Status: KYCStatusPending}
+	err = as.store.WithTx(r.Context(), func(tx Storage) error {
+		if err := tx.CreateKYCCheck(r.Context(), check); err != nil {
+			return err
+		}
+		if err := tx.SetAccountKYCStatus(r.Context(), account.ID, KYCStatusPending); err != nil {
+			return err
+		}
+
+		event, err := newOutboxEvent(EventKYCStatusChanged, account.ID, &KYCStatusChange{KYCCheck: check, PreviousStatus: account.KYCStatus})
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(r.Context(), []*OutboxEvent{event})
+	})
+	if err != nil {
+		return err
+	}
+
+	as.nudgeKYCSync()
+	return WriteResponse(w, r, http.StatusCreated, check)
+}
+
+// handleKYCWebhook applies the result of a check the provider notifies. The result is
+// fetched from the provider rather than read from the event, so that a replayed or
+// reordered event cannot move a check backwards, and a check already closed is
+// acknowledged as a duplicate.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the event, verified by withWebhookSignature.
+//
+// Returns:
+//   - error: A 404 if no check has the ID of the
+//     event, a 503 if the provider cannot be reached, or the error of the store, for the
+//     provider to deliver the event again.
+func (as *APIServer) handleKYCWebhook(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	providerCheckID, err := as.kyc.ParseWebhook(body)
+	if err != nil {
+		return NewTypedError(http.StatusBadRequest, "invalid_json", fmt.Sprintf("invalid request body: %s", err))
+	}
+	if providerCheckID == "" {
+		return WriteResponse(w, r, http.StatusOK, KYCWebhookResponse{Result: GatewayCallbackIgnored})
+	}
+
+	check, err := as.store.GetKYCCheckByProviderID(r.Context(), providerCheckID)
+	if err != nil {
+		return err
+	}
+	if !check.Open() {
+		return WriteResponse(w, r, http.StatusOK, KYCWebhookResponse{Result: GatewayCallbackDuplicate, AccountID: check.AccountID, Status: check.Status})
+	}
+
+	result, err := as.kyc.Status(r.Context(), providerCheckID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error Fetching KYC Result", "check_id", check.ID, "provider", check.Provider, "error", err)
+		return NewTypedError(http.StatusServiceUnavailable, "kyc_unavailable", "the KYC provider is unavailable, retry later")
+	}
+
+	outcome := GatewayCallbackProcessed
+	err = as.applyKYCResult(context.WithoutCancel(r.Context()), check, result)
+	if errors.Is(err, ErrKYCCheckStatusChanged) {
+		outcome = GatewayCallbackDuplicate
+	} else if err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusOK, KYCWebhookResponse{Result: outcome, AccountID: check.AccountID, Status: check.Status})
+}
+
+// registerKYCRoutes registers the KYC check endpoints and the webhook of the provider,
+// enabled by kyc.provider. The webhook authenticates with its signature.
+//
+// Routes:
+// - GET /api/v1/account/{id:[0-9]+}/kyc: Retrieves the latest KYC check of an account.
+// - POST /api/v1/account/{id:[0-9]+}/kyc: Starts a new KYC check after a failed one.
+// - POST /webhooks/kyc: Applies the result of a check the KYC provider notifies.
+func (as *APIServer) registerKYCRoutes(router, subRouter *mux.Router) {
+	if as.kyc == nil {
+		return
+	}
+
+	subRouter.HandleFunc("/account/{id:[0-9]+}/kyc", withJWTAuth(makeHTTPHandlerFunc(as.handleGetKYCCheck), as.store)).Methods(http.MethodGet)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/kyc", withJWTAuth(makeHTTPHandlerFunc(as.handleStartKYCCheck), as.store)).Methods(http.MethodPost)
+	router.HandleFunc("/webhooks/kyc", makeHTTPHandlerFunc(as.withWebhookSignature(as.kyc.Name(), as.handleKYCWebhook))).Methods(http.MethodPost)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Default LDAP Settings
+const (
+	// defaultLDAPUserFilter finds an Active Directory user by their logon name; OpenLDAP
+	// directories use (uid=%s).
+	defaultLDAPUserFilter     = "(&(objectClass=user)(sAMAccountName=%s))"
+	defaultLDAPGroupAttribute = "memberOf"
+	defaultLDAPTimeout        = 10 * time.Second
+)
+
+// ldapAuthProvider authenticates the staff against an LDAP directory, such as Active
+// Directory: it looks the user up with the service account, binds as the user with
+// their password, and maps the groups of the user to staff roles.
+type ldapAuthProvider struct {
+	cfg LDAPConfig
+	// groupRoles maps a group, by DN or common name in lowercase, to the role it grants.
+	groupRoles map[string]string
+}
+
+// newLDAPAuthProvider creates the LDAP provider of cfg. The directory is not reached
+// until the first login.
+func newLDAPAuthProvider(cfg LDAPConfig) *ldapAuthProvider {
+	groupRoles := make(map[string]string, len(cfg.GroupRoles))
+	for group, role := range cfg.GroupRoles {
+		groupRoles[strings.ToLower(strings.TrimSpace(group))] = role
+	}
+	return &ldapAuthProvider{cfg: cfg, groupRoles: groupRoles}
+}
+
+// Name returns ldap.
+func (p *ldapAuthProvider) Name() string {
+	return staffAuthProviderLDAP
+}
+
+// Authenticate checks the password of a user of the directory and returns the roles of
+// their groups. The directory calls are bounded by the timeout of the settings rather
+// than by ctx, which the LDAP client does not take.
+//
+// Parameters:
+//   - ctx: The context of the login request.
+//   - username: The logon name of the user, escaped into the user filter.
+//   - password: The password of the user; an empty one is refused, since the directory
+//     would accept it as an anonymous bind.
+//
+// Returns:
+//   - *StaffIdentity: The user, with the roles of their groups, possibly none.
+//   - error: ErrInvalidCredentials for an unknown user or a wrong password, another
+//     error if the directory cannot be reached or refuses the service account.
+func (p *ldapAuthProvider) Authenticate(ctx context.Context, username, password string) (*StaffIdentity, error) {
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Look The User Up With The Service Account, Or Anonymously Without One
+	if p.cfg.BindDN != "" {
+		if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("binding as the service account: %w", err)
+		}
+	}
+
+	search := ldap.NewSearchRequest(
+		p.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		// Two Entries Are Enough To Tell An Ambiguous Filter
+		2, int(p.cfg.Timeout/time.Second), false,
+		fmt.Sprintf(p.cfg.UserFilter, ldap.EscapeFilter(username)),
+		[]string{p.cfg.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(search)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("searching for the user: %w", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("binding as the user: %w", err)
+	}
+
+	staff := &StaffIdentity{Username: username}
+	for _, group := range entry.GetAttributeValues(p.cfg.GroupAttribute) {
+		if role, ok := p.groupRole(group); ok && !staff.HasRole(role) {
+			staff.Roles = append(staff.Roles, role)
+		}
+	}
+	slices.Sort(staff.Roles)
+	return staff, nil
+}
+
+// groupRole returns the role granted by a group of a user, looked up by its DN, then by
+// its common name, the value of its first RDN.
+func (p *ldapAuthProvider) groupRole(group string) (string, bool) {
+	if role, ok := p.groupRoles[strings.ToLower(group)]; ok {
+		return role, true
+	}
+	dn, err := ldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return "", false
+	}
+	role, ok := p.groupRoles[strings.ToLower(dn.RDNs[0].Attributes[0].Value)]
+	return role, ok
+}
+
+// dial connects to the directory, upgrading an ldap:// connection with StartTLS when
+// configured.
+func (p *ldapAuthProvider) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(p.cfg.URL, ldap.DialWithDialer(&net.Dialer{Timeout: p.cfg.Timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", p.cfg.URL, err)
+	}
+	conn.SetTimeout(p.cfg.Timeout)
+
+	if p.cfg.StartTLS {
+		parsed, _ := url.Parse(p.cfg.URL)
+		if err := conn.StartTLS(&tls.Config{ServerName: parsed.Hostname()}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starting TLS with %s: %w", p.cfg.URL, err)
+		}
+	}
+	return conn, nil
+}
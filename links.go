@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Link is a single hypermedia link in a response's _links block.
+type Link struct {
+	Href   string `json:"href"`
+	Method string `json:"method,omitempty"`
+}
+
+// Links maps a link relation (self, transactions, transfer, ...) to its Link.
+type Links map[string]Link
+
+// AccountResource is an Account enriched with hypermedia links.
+type AccountResource struct {
+	*Account
+	Links Links `json:"_links"`
+}
+
+// TransactionResource is a Transaction enriched with hypermedia links.
+type TransactionResource struct {
+	*Transaction
+	Links Links `json:"_links"`
+}
+
+// TransferResource is a Transfer enriched with hypermedia links.
+type TransferResource struct {
+	*Transfer
+	Links Links `json:"_links"`
+}
+
+// linkTemplates holds the URL layout of one API version.
+type linkTemplates struct {
+	account  string
+	transfer string
+}
+
+var (
+	v1Links = linkTemplates{account: "/api/v1/account/%d", transfer: "/api/v1/transfer"}
+	v2Links = linkTemplates{account: "/api/v2/accounts/%d", transfer: "/api/v2/transfers"}
+)
+
+// linksFor returns the URL layout matching the API version of the request.
+func linksFor(r *http.Request) linkTemplates {
+	if strings.HasPrefix(r.URL.Path, "/api/v2") {
+		return v2Links
+	}
+	return v1Links
+}
+
+// accountLinks builds the _links block of an account.
+func (lt linkTemplates) accountLinks(acc *Account) Links {
+	self := fmt.Sprintf(lt.account, acc.ID)
+
+	return Links{
+		"self":         {Href: self, Method: http.MethodGet},
+		"transactions": {Href: self + "/transactions", Method: http.MethodGet},
+		"events":       {Href: self + "/events", Method: http.MethodGet},
+		"transfer":     {Href: lt.transfer, Method: http.MethodPost},
+	}
+}
+
+// transactionLinks builds the _links block of a transaction.
+func (lt linkTemplates) transactionLinks(t *Transaction) Links {
+	account := fmt.Sprintf(lt.account, t.AccountID)
+
+	links := Links{
+		"account":      {Href: account, Method: http.MethodGet},
+		"transactions": {Href: account + "/transactions", Method: http.MethodGet},
+	}
+	if t.CounterpartyID != 0 {
+		links["counterparty"] = Link{Href: fmt.Sprintf(lt.account, t.CounterpartyID), Method: http.MethodGet}
+	}
+
+	return links
+}
+
+// transferLinks builds the _links block of a transfer.
+func (lt linkTemplates) transferLinks(t *Transfer) Links {
+	return Links{
+		"self":         {Href: fmt.Sprintf("%s/%d", lt.transfer, t.ID), Method: http.MethodGet},
+		"from_account": {Href: fmt.Sprintf(lt.account, t.FromAccountID), Method: http.MethodGet},
+		"to_account":   {Href: fmt.Sprintf(lt.account, t.ToAccountID), Method: http.MethodGet},
+		"transfer":     {Href: lt.transfer, Method: http.MethodPost},
+	}
+}
+
+// newTransferResource wraps a transfer with the links matching the request's API version.
+func newTransferResource(r *http.Request, t *Transfer) *TransferResource {
+	return &TransferResource{Transfer: t, Links: linksFor(r).transferLinks(t)}
+}
+
+// newAccountResource wraps an account with the links matching the request's API version.
+func newAccountResource(r *http.Request, acc *Account) *AccountResource {
+	return &AccountResource{Account: acc, Links: linksFor(r).accountLinks(acc)}
+}
+
+// newAccountResources wraps a list of accounts with their links.
+func newAccountResources(r *http.Request, accs []*Account) []*AccountResource {
+	resources := make([]*AccountResource, 0, len(accs))
+	for _, acc := range accs {
+		resources = append(resources, newAccountResource(r, acc))
+	}
+	return resources
+}
+
+// newTransactionResources wraps a list of transactions with their links.
+func newTransactionResources(r *http.Request, txns []*Transaction) []*TransactionResource {
+	lt := linksFor(r)
+
+	resources := make([]*TransactionResource, 0, len(txns))
+	for _, t := range txns {
+		resources = append(resources, &TransactionResource{Transaction: t, Links: lt.transactionLinks(t)})
+	}
+	return resources
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Log Formats, Selected With LOG_FORMAT
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Log Outputs, Selected With LOG_OUTPUT; Any Other Value Is The Path Of A File
+const (
+	logOutputStderr = "stderr"
+	logOutputStdout = "stdout"
+)
+
+// logFileCheckInterval is how often a log file is checked for having been rotated away.
+const logFileCheckInterval = time.Second
+
+// logLevel is the minimum level of the records written by the default logger,
+// set from the configuration and changeable while the server runs.
+var logLevel = new(slog.LevelVar)
+
+// setupLogging installs the default slog logger: human readable key=value lines, or
+// JSON lines when the format is "json", at the configured level (debug, info, warn, or
+// error). Messages of the standard log package go through it too. The JSON records are
+// meant for log shippers such as Loki or Logstash: their time is in UTC, and every one
+// carries the service and version fields.
+//
+// Parameters:
+//   - w: The writer the records are written to.
+//   - cfg: The logging settings.
+//
+// Returns:
+//   - error: An error if the format or the level has an unknown value.
+func setupLogging(w io.Writer, cfg LoggingConfig) error {
+	if err := logLevel.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return fmt.Errorf("unknown log level %q, use debug, info, warn, or error", cfg.Level)
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+
+	var handler slog.Handler
+	switch format := strings.ToLower(cfg.Format); format {
+	case logFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case logFormatJSON:
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				a.Value = slog.TimeValue(a.Value.Time().UTC())
+			}
+			return a
+		}
+		handler = slog.NewJSONHandler(w, opts).WithAttrs([]slog.Attr{
+			slog.String("service", "gobank"),
+			slog.String("version", Version),
+		})
+	default:
+		return fmt.Errorf("unknown log format %q, use %q or %q", format, logFormatText, logFormatJSON)
+	}
+
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+	return nil
+}
+
+// openLogOutput opens the destination of the logs: the standard error, the standard
+// output, or a file the records are appended to. A file moved away by logrotate is
+// noticed within logFileCheckInterval and created again at its path, and one truncated
+// in place (copytruncate) keeps being appended to, so no signal needs to be sent.
+//
+// Parameters:
+//   - output: "stderr", "stdout", or the path of the file.
+//
+// Returns:
+//   - io.WriteCloser: The destination, to be closed on exit.
+//   - error: An error if the file cannot be opened.
+func openLogOutput(output string) (io.WriteCloser, error) {
+	switch output {
+	case logOutputStderr:
+		return nopWriteCloser{os.Stderr}, nil
+	case logOutputStdout:
+		return nopWriteCloser{os.Stdout}, nil
+	}
+
+	file := &logFile{path: output}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// nopWriteCloser leaves the standard streams open when the logs are closed.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// logFile appends the log records to a file, opening it again when it was rotated away.
+type logFile struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	checked time.Time
+}
+
+// open opens the file at the path, creating it when missing, and replaces the open one.
+func (l *logFile) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("opening the log file: %w", err)
+	}
+
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file, l.checked = file, time.Now()
+	return nil
+}
+
+// Write appends a record, first reopening the file when its path no longer leads to it.
+// Failing to reopen keeps writing to the old file rather than losing the record.
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now := time.Now(); now.Sub(l.checked) >= logFileCheckInterval {
+		l.checked = now
+		if l.rotated() {
+			if err := l.open(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error Reopening The Log File: %v\n", err)
+			}
+		}
+	}
+
+	return l.file.Write(p)
+}
+
+// rotated reports whether the file at the path is missing or another one than the open file.
+func (l *logFile) rotated() bool {
+	current, err := l.file.Stat()
+	if err != nil {
+		return true
+	}
+	atPath, err := os.Stat(l.path)
+	return err != nil || !os.SameFile(current, atPath)
+}
+
+// Close closes the file.
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// fatal logs an error and exits, the slog counterpart of log.Fatalf.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// logAttrsKey is the context key of the log fields of a request.
+type logAttrsKey struct{}
+
+// withLogAttrs returns a context whose log records carry the given fields on top of
+// the ones ctx already has, for every slog call made with the context.
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return context.WithValue(ctx, logAttrsKey{}, append(existing[:len(existing):len(existing)], attrs...))
+}
+
+// contextHandler adds the fields attached with withLogAttrs to every record logged
+// with a context.
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle adds the fields of ctx, and the IDs of the span ctx carries, to the record
+// and passes it on.
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		record.AddAttrs(attrs...)
+	}
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		record.AddAttrs(slog.String("trace_id", spanCtx.TraceID().String()), slog.String("span_id", spanCtx.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs keeps the fields of the context on the derived handler.
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup keeps the fields of the context on the derived handler.
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// withRequestLogging attaches the method and the route template of the request to the
+// log records of the request.
+func withRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := withLogAttrs(r.Context(), slog.String("method", r.Method), slog.String("route", routeTemplate(r)))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// routeTemplate returns the path template of the matched route, such as
+// "/api/v1/account/{id:[0-9]+}", or the path itself when no route matched.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Bounds Of A Temporary Log Level
+const (
+	defaultLogLevelTTL = 15 * time.Minute
+	maxLogLevelTTL     = 24 * time.Hour
+)
+
+// Audit Actions Of The Log Level
+const (
+	AuditActionSetLogLevel    = "log_level.set"
+	AuditActionRevertLogLevel = "log_level.revert"
+)
+
+// LogLevelRequest is the body of PUT /admin/log-level.
+type LogLevelRequest struct {
+	// Level is debug, info, warn, or error.
+	Level string `json:"level"`
+	// TTL is how long the level applies, such as "10m", 15 minutes when omitted.
+	TTL string `json:"ttl"`
+}
+
+// Validate checks the fields of a log level request.
+func (req *LogLevelRequest) Validate() []FieldError {
+	var errs []FieldError
+
+	var level slog.Level
+	if strings.TrimSpace(req.Level) == "" {
+		errs = append(errs, FieldError{Field: "level", Code: CodeRequired, Message: "level is required"})
+	} else if level.UnmarshalText([]byte(req.Level)) != nil {
+		errs = append(errs, FieldError{Field: "level", Code: CodeInvalid, Message: "level must be debug, info, warn, or error"})
+	}
+
+	if req.TTL != "" {
+		if ttl, err := time.ParseDuration(req.TTL); err != nil || ttl <= 0 || ttl > maxLogLevelTTL {
+			errs = append(errs, FieldError{Field: "ttl", Code: CodeInvalid, Message: fmt.Sprintf("ttl must be a duration such as 10m, at most %s", maxLogLevelTTL)})
+		}
+	}
+
+	return errs
+}
+
+// LogLevelStatus is the response body of the /admin/log-level endpoints.
+type LogLevelStatus struct {
+	Level           string `json:"level"`
+	ConfiguredLevel string `json:"configured_level"`
+	// RevertsAt is when a temporary level gives way to the configured one.
+	RevertsAt *time.Time `json:"reverts_at,omitempty"`
+}
+
+// runtimeLogLevel switches logLevel, the level of the default logger, to a temporary
+// level for a while and back to the configured one.
+type runtimeLogLevel struct {
+	mu         sync.Mutex
+	configured slog.Level
+	timer      *time.Timer
+	revertsAt  time.Time
+	// onRevert is called when a temporary level expires.
+	onRevert func(from slog.Level)
+}
+
+// newRuntimeLogLevel creates the runtime level of a configured one.
+func newRuntimeLogLevel(configured slog.Level, onRevert func(from slog.Level)) *runtimeLogLevel {
+	return &runtimeLogLevel{configured: configured, onRevert: onRevert}
+}
+
+// SetConfigured changes the configured level, when the configuration is reloaded. A
+// temporary level stays until it expires.
+func (l *runtimeLogLevel) SetConfigured(level slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.configured = level
+	if l.timer == nil {
+		logLevel.Set(level)
+	}
+}
+
+// Override applies a level for ttl, replacing a temporary level already applied.
+func (l *runtimeLogLevel) Override(level slog.Level, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+
+	logLevel.Set(level)
+	l.revertsAt = time.Now().UTC().Add(ttl)
+
+	var timer *time.Timer
+	timer = time.AfterFunc(ttl, func() {
+		l.mu.Lock()
+		// A Newer Override Or A Reset Replaced This Timer
+		if l.timer != timer {
+			l.mu.Unlock()
+			return
+		}
+		from := logLevel.Level()
+		l.revertLocked()
+		l.mu.Unlock()
+
+		if l.onRevert != nil {
+			l.onRevert(from)
+		}
+	})
+	l.timer = timer
+}
+
+// Reset goes back to the configured level at once.
+func (l *runtimeLogLevel) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.revertLocked()
+}
+
+// revertLocked applies the configured level; l.mu must be held.
+func (l *runtimeLogLevel) revertLocked() {
+	l.timer = nil
+	l.revertsAt = time.Time{}
+	logLevel.Set(l.configured)
+}
+
+// Status returns the level applied and the configured one.
+func (l *runtimeLogLevel) Status() *LogLevelStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	status := &LogLevelStatus{
+		Level:           strings.ToLower(logLevel.Level().String()),
+		ConfiguredLevel: strings.ToLower(l.configured.String()),
+	}
+	if l.timer != nil {
+		revertsAt := l.revertsAt
+		status.RevertsAt = &revertsAt
+	}
+	return status
+}
+
+// auditLogLevelRevert records in the audit log that a temporary level expired.
+func (as *APIServer) auditLogLevelRevert(from slog.Level) {
+	slog.Info("Temporary Log Level Expired", "from", from, "to", logLevel.Level())
+
+	details, _ := json.Marshal(map[string]string{
+		"from": strings.ToLower(from.String()),
+		"to":   strings.ToLower(logLevel.Level().String()),
+	})
+	entry := &AuditEntry{Actor: "system", Action: AuditActionRevertLogLevel, Target: "log_level", Details: string(details)}
+	if err := as.store.CreateAuditEntry(context.Background(), entry); err != nil {
+		slog.Error("Error Writing Audit Log", "action", entry.Action, "error", err)
+	}
+}
+
+// registerLogLevelRoutes registers the log level endpoints on the admin subrouter.
+//
+// Routes:
+// - GET /admin/log-level: Returns the applied and the configured log level.
+// - PUT /admin/log-level: Applies a log level for a while, 15 minutes by default.
+// - DELETE /admin/log-level: Goes back to the configured log level.
+func (as *APIServer) registerLogLevelRoutes(admin *mux.Router) {
+	admin.HandleFunc("/log-level", makeHTTPHandlerFunc(as.handleAdminGetLogLevel)).Methods(http.MethodGet)
+	admin.HandleFunc("/log-level", makeHTTPHandlerFunc(as.handleAdminSetLogLevel)).Methods(http.MethodPut)
+	admin.HandleFunc("/log-level", makeHTTPHandlerFunc(as.handleAdminResetLogLevel)).Methods(http.MethodDelete)
+}
+
+// handleAdminGetLogLevel returns the applied and the configured log level.
+func (as *APIServer) handleAdminGetLogLevel(w http.ResponseWriter, r *http.Request) error {
+	return WriteResponse(w, r, http.StatusOK, as.runtimeLevel.Status())
+}
+
+// handleAdminSetLogLevel applies a log level until its TTL expires and records the
+// change in the audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the level and its TTL.
+//
+// Returns:
+//   - error: A TypedError if the body is invalid, otherwise nil.
+func (as *APIServer) handleAdminSetLogLevel(w http.ResponseWriter, r *http.Request) error {
+	levelReq := new(LogLevelRequest)
+	if err := bindJSON(w, r, levelReq); err != nil {
+		return err
+	}
+
+	// Validate Accepted Both Already
+	var level slog.Level
+	level.UnmarshalText([]byte(levelReq.Level))
+	ttl := defaultLogLevelTTL
+	if levelReq.TTL != "" {
+		ttl, _ = time.ParseDuration(levelReq.TTL)
+	}
+
+	previous := as.runtimeLevel.Status().Level
+	as.runtimeLevel.Override(level, ttl)
+
+	as.audit(r, AuditActionSetLogLevel, "log_level", map[string]string{
+		"previous_level": previous,
+		"level":          strings.ToLower(level.String()),
+		"ttl":            ttl.String(),
+	})
+
+	return WriteResponse(w, r, http.StatusOK, as.runtimeLevel.Status())
+}
+
+// handleAdminResetLogLevel goes back to the configured log level and records it in the audit log.
+func (as *APIServer) handleAdminResetLogLevel(w http.ResponseWriter, r *http.Request) error {
+	as.runtimeLevel.Reset()
+	as.audit(r, AuditActionRevertLogLevel, "log_level", nil)
+
+	return WriteResponse(w, r, http.StatusOK, as.runtimeLevel.Status())
+}
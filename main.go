@@ -1,18 +1,203 @@
 package main
 
-import "log"
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+)
 
 func main() {
-	newStore, err := NewPostgresStorage()
+	demo := flag.Bool("demo", false, "Run with an in-memory store instead of Postgres; all data is lost on exit")
+	withSeed := flag.Bool("seed", false, "Fill the store with fake accounts and transactions before serving")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file, see config.example.yaml; the environment and the flags override it")
+	addr := flag.String("addr", "", "Address to listen on, overrides LISTEN_ADDR")
+	dbURL := flag.String("db-url", "", "Connection string of the database, overrides DB_URL and the DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, and DB_NAME variables")
+	flag.Parse()
 
+	// Load The .env File When There Is One, The Plain Environment Works Too
+	if err := loadDotEnv(); err != nil {
+		fatal("Error Loading The .env File", "error", err)
+	}
+
+	// The Flags Win Over The File And The Environment, On Every Reload Too
+	load := func() (*Config, error) {
+		return loadConfig(*configFile, func(cfg *Config) {
+			if *addr != "" {
+				cfg.Server.Addr = *addr
+			}
+			if *dbURL != "" {
+				cfg.Database.URL = *dbURL
+			}
+		})
+	}
+
+	cfg, err := load()
+	if err != nil {
+		fatal("Invalid Configuration", "error", err)
+	}
+
+	// "gobank healthcheck" Probes The Running Server, Before Anything Is Opened Or Logged
+	if flag.Arg(0) == "healthcheck" {
+		if err := runHealthcheckCommand(cfg.Server.Addr, flag.Args()[1:]); err != nil {
+			fatal("Health Check Failed", "error", err)
+		}
+		return
+	}
+
+	logOutput, err := openLogOutput(cfg.Logging.Output)
+	if err != nil {
+		fatal("Error Opening The Log Output", "error", err)
+	}
+	defer logOutput.Close()
+
+	if err := setupLogging(logOutput, cfg.Logging); err != nil {
+		fatal("Error Configuring The Logger", "error", err)
+	}
+
+	// Tell Which Binary Is Running Before Anything Else
+	info := buildInfo()
+	slog.Info("Starting gobank", "version", info.Version, "commit", info.Commit, "build_date", info.BuildDate, "go_version", info.GoVersion)
+
+	// Export Traces When An OTLP Endpoint Is Configured
+	shutdownTracing, err := setupTracing(context.Background())
 	if err != nil {
-		log.Fatalf("There is Something Wrong With Db : %s", err)
+		fatal("Error Configuring Tracing", "error", err)
 	}
+	defer shutdownTracing()
 
-	// Initialize The Database
-	newStore.Init()
+	// Report Unexpected Errors When A Sentry DSN Is Configured
+	flushErrorReports, err := setupErrorReporting(cfg.ErrorReporting)
+	if err != nil {
+		fatal("Error Configuring The Error Reporting", "error", err)
+	}
+	defer flushErrorReports()
+
+	JWTSecret = cfg.Auth.JWTSecret
+	JWTTokenExpire = cfg.Auth.JWTTokenExpire
+
+	// Inject Faults Into The Storage And Cache Calls When Testing Resilience
+	faults := newFaultInjector(cfg.FaultInjection)
+
+	// The Demo Mode Needs No Database
+	if *demo {
+		slog.Info("Running In Demo Mode With An In-Memory Store")
+
+		// Behind The Retries And The Circuit Breaker Too, For The Injected Faults
+		store := NewResilientStorage(NewInstrumentedStorage(NewFaultyStorage(NewMemoryStorage(), faults), cfg.Database.SlowThreshold), &cfg.Database)
+		if *withSeed {
+			if err := seed(store, cfg.seedOptions()); err != nil {
+				fatal("Error Seeding The Store", "error", err)
+			}
+		}
+
+		apiServer := NewAPIServer(cfg, store)
+		apiServer.configLoader = load
+		apiServer.Run()
+		return
+	}
+
+	// STORAGE_BACKEND=mongo Runs On MongoDB Instead Of Postgres
+	if cfg.Database.Backend == storageBackendMongo {
+		runMongo(cfg, load, faults, *withSeed)
+		return
+	}
+
+	newStore, err := NewPostgresStorage(&cfg.Database)
+
+	if err != nil {
+		fatal("There is Something Wrong With Db", "error", err)
+	}
 
-	apiServer := NewAPIServer(":8080", newStore)
+	// "gobank migrate" Only Manages The Schema, Before Anything Is Applied Automatically
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrateCommand(newStore, flag.Args()[1:]); err != nil {
+			fatal("Error Migrating The Database", "error", err)
+		}
+		return
+	}
+
+	// Bring The Schema Up To Date
+	if _, err := newStore.Migrate(); err != nil {
+		fatal("Error Migrating The Database", "error", err)
+	}
+
+	// "gobank backup" And "gobank restore" Export And Load The Accounts And Transactions
+	if command := flag.Arg(0); command == "backup" || command == "restore" {
+		if err := runBackupCommand(newStore, command, flag.Args()[1:], cfg.Backup.Passphrase); err != nil {
+			fatal("Error Running The Command", "command", command, "error", err)
+		}
+		return
+	}
+
+	// "gobank seed" Only Fills The Database With Fake Data
+	if flag.Arg(0) == "seed" {
+		if err := runSeedCommand(newStore, cfg.seedOptions(), flag.Args()[1:]); err != nil {
+			fatal("Error Seeding The Database", "error", err)
+		}
+		return
+	}
+
+	// Prepare The Hot Path Queries
+	if err := newStore.Prepare(context.Background()); err != nil {
+		fatal("Error Preparing Statements", "error", err)
+	}
+
+	// Cache Account Reads When Redis Is Configured, In Front Of The Retried And Timed Database Calls
+	store, err := NewCachedStorage(NewResilientStorage(NewInstrumentedStorage(NewFaultyStorage(newStore, faults), cfg.Database.SlowThreshold), &cfg.Database), cfg.Cache, faults.redisHooks()...)
+	if err != nil {
+		fatal("Error Connecting To The Account Cache", "error", err)
+	}
+
+	if *withSeed {
+		if err := seed(newStore, cfg.seedOptions()); err != nil {
+			fatal("Error Seeding The Database", "error", err)
+		}
+	}
+
+	apiServer := NewAPIServer(cfg, store)
+	apiServer.configLoader = load
+
+	apiServer.Run()
+}
+
+// runMongo runs the server, or the command given on the command line, on the MongoDB
+// backend at the configured Mongo URL and database. The indexes are created on
+// connect, so "gobank migrate" has nothing left to do, and the backup and restore
+// commands are only available on Postgres. load reloads the configuration on SIGHUP,
+// and faults are injected into the storage and cache calls when not nil.
+func runMongo(cfg *Config, load configLoader, faults *faultInjector, withSeed bool) {
+	mongoStore, err := NewMongoStorage(context.Background(), cfg.Database.MongoURL, cfg.Database.MongoDatabase)
+	if err != nil {
+		fatal("There is Something Wrong With Db", "error", err)
+	}
+
+	switch command := flag.Arg(0); command {
+	case "migrate":
+		slog.Info("MongoDB Indexes Are Up To Date")
+		return
+	case "seed":
+		if err := runSeedCommand(mongoStore, cfg.seedOptions(), flag.Args()[1:]); err != nil {
+			fatal("Error Seeding The Database", "error", err)
+		}
+		return
+	case "backup", "restore":
+		fatal("The Command Needs The Postgres Backend", "command", command)
+	}
+
+	// Cache Account Reads When Redis Is Configured, In Front Of The Retried And Timed Database Calls
+	store, err := NewCachedStorage(NewResilientStorage(NewInstrumentedStorage(NewFaultyStorage(mongoStore, faults), cfg.Database.SlowThreshold), &cfg.Database), cfg.Cache, faults.redisHooks()...)
+	if err != nil {
+		fatal("Error Connecting To The Account Cache", "error", err)
+	}
+
+	if withSeed {
+		if err := seed(mongoStore, cfg.seedOptions()); err != nil {
+			fatal("Error Seeding The Database", "error", err)
+		}
+	}
 
+	apiServer := NewAPIServer(cfg, store)
+	apiServer.configLoader = load
 	apiServer.Run()
 }
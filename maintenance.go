@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Audit Actions Of The Maintenance Mode
+const (
+	AuditActionStartMaintenance = "maintenance.start"
+	AuditActionEndMaintenance   = "maintenance.end"
+)
+
+// Maintenance is the maintenance mode of the API, stored in the database so it survives
+// restarts and applies to every instance.
+type Maintenance struct {
+	Message string `json:"message"`
+	// EndsAt is when the maintenance is expected to end; it stays on until turned off.
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	StartedBy string     `json:"started_by"`
+	StartedAt time.Time  `json:"started_at"`
+}
+
+// MaintenanceRequest is the body of PUT /admin/maintenance.
+type MaintenanceRequest struct {
+	// Message tells the clients why the API is under maintenance.
+	Message string `json:"message"`
+	// EndsAt is the estimated end of the maintenance, optional.
+	EndsAt *time.Time `json:"ends_at"`
+}
+
+// Validate checks the fields of a maintenance request.
+func (req *MaintenanceRequest) Validate() []FieldError {
+	var errs []FieldError
+	if strings.TrimSpace(req.Message) == "" {
+		errs = append(errs, FieldError{Field: "message", Code: CodeRequired, Message: "message is required"})
+	} else if len(req.Message) > 500 {
+		errs = append(errs, FieldError{Field: "message", Code: CodeTooLong, Message: "message must be at most 500 characters"})
+	}
+	if req.EndsAt != nil && !req.EndsAt.After(time.Now()) {
+		errs = append(errs, FieldError{Field: "ends_at", Code: CodeInvalid, Message: "ends_at must be in the future"})
+	}
+	return errs
+}
+
+// MaintenanceStatus is the response body of the /admin/maintenance endpoints.
+type MaintenanceStatus struct {
+	Active      bool         `json:"active"`
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+}
+
+// maintenanceMode answers whether the API is under maintenance. The state is kept in
+// memory and reloaded periodically, so the check never waits on the database.
+type maintenanceMode struct {
+	store MaintenanceRepository
+
+	mu      sync.RWMutex
+	current *Maintenance
+}
+
+// newMaintenanceMode creates the maintenance mode of a store, off until Refresh loads it.
+func newMaintenanceMode(store MaintenanceRepository) *maintenanceMode {
+	return &maintenanceMode{store: store}
+}
+
+// Current returns the maintenance under way, or nil.
+func (m *maintenanceMode) Current() *Maintenance {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current
+}
+
+// Refresh reloads the maintenance mode from the store.
+func (m *maintenanceMode) Refresh(ctx context.Context) error {
+	current, err := m.store.GetMaintenance(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.current = current
+	m.mu.Unlock()
+	return nil
+}
+
+// Run reloads the maintenance mode every interval until ctx is cancelled, picking up the
+// changes made through the other instances.
+func (m *maintenanceMode) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := m.Refresh(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error Loading The Maintenance Mode", "error", err)
+		}
+	}
+}
+
+// Start stores a maintenance and applies it on this instance at once.
+func (m *maintenanceMode) Start(ctx context.Context, maintenance *Maintenance) error {
+	if err := m.store.SetMaintenance(ctx, maintenance); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.current = maintenance
+	m.mu.Unlock()
+	return nil
+}
+
+// End turns the maintenance mode off.
+func (m *maintenanceMode) End(ctx context.Context) error {
+	if err := m.store.ClearMaintenance(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.current = nil
+	m.mu.Unlock()
+	return nil
+}
+
+// maintenanceErrorResponse is the body of a request refused during a maintenance, in
+// the v1 error shape with the maintenance added.
+type maintenanceErrorResponse struct {
+	APIError
+	Maintenance *Maintenance `json:"maintenance"`
+}
+
+// maintenanceErrorResponseV2 is maintenanceErrorResponse in the v2 error shape.
+type maintenanceErrorResponseV2 struct {
+	APIErrorV2
+	Maintenance *Maintenance `json:"maintenance"`
+}
+
+// withMaintenance refuses the POST, PUT, PATCH, and DELETE requests with a 503 while the
+// API is under maintenance, with the maintenance in the body and, when its end is
+// estimated, a Retry-After header. Reads still work, the /admin endpoints stay open to
+// end the maintenance, and a batch is let through so its sub-requests are judged one
+// by one.
+func (as *APIServer) withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		maintenance := as.maintenance.Current()
+		if maintenance == nil || !mutatingMethod(r.Method) ||
+			strings.HasPrefix(r.URL.Path, "/admin/") || r.URL.Path == "/api/v1/batch" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if maintenance.EndsAt != nil {
+			if wait := time.Until(*maintenance.EndsAt); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second)/time.Second)))
+			}
+		}
+
+		message := localize(r, "error.maintenance", "the API is under maintenance, retry later")
+		requestID := requestIDFromContext(r.Context())
+
+		if strings.HasPrefix(r.URL.Path, "/api/v2/") {
+			writeErrorResponse(w, r, http.StatusServiceUnavailable, maintenanceErrorResponseV2{
+				APIErrorV2:  APIErrorV2{Error: &TypedError{Code: "maintenance", Message: message}, RequestID: requestID},
+				Maintenance: maintenance,
+			})
+			return
+		}
+
+		writeErrorResponse(w, r, http.StatusServiceUnavailable, maintenanceErrorResponse{
+			APIError:    APIError{Error: message, RequestID: requestID},
+			Maintenance: maintenance,
+		})
+	})
+}
+
+// mutatingMethod reports whether an HTTP method changes data.
+func mutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// registerMaintenanceRoutes registers the maintenance mode endpoints on the admin subrouter.
+//
+// Routes:
+// - GET /admin/maintenance: Reports whether the API is under maintenance.
+// - PUT /admin/maintenance: Puts the API under maintenance, with a message and an estimated end.
+// - DELETE /admin/maintenance: Ends the maintenance.
+func (as *APIServer) registerMaintenanceRoutes(admin *mux.Router) {
+	admin.HandleFunc("/maintenance", makeHTTPHandlerFunc(as.handleAdminGetMaintenance)).Methods(http.MethodGet)
+	admin.HandleFunc("/maintenance", makeHTTPHandlerFunc(as.handleAdminStartMaintenance)).Methods(http.MethodPut)
+	admin.HandleFunc("/maintenance", makeHTTPHandlerFunc(as.handleAdminEndMaintenance)).Methods(http.MethodDelete)
+}
+
+// maintenanceStatus returns the state of the maintenance mode.
+func (as *APIServer) maintenanceStatus() *MaintenanceStatus {
+	current := as.maintenance.Current()
+	return &MaintenanceStatus{Active: current != nil, Maintenance: current}
+}
+
+// handleAdminGetMaintenance reports whether the API is under maintenance.
+func (as *APIServer) handleAdminGetMaintenance(w http.ResponseWriter, r *http.Request) error {
+	return WriteResponse(w, r, http.StatusOK, as.maintenanceStatus())
+}
+
+// handleAdminStartMaintenance puts the API under maintenance, replacing a maintenance
+// already under way, and records it in the audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the message and the estimated end.
+//
+// Returns:
+//   - error: A TypedError if the body is invalid or the maintenance cannot be stored.
+func (as *APIServer) handleAdminStartMaintenance(w http.ResponseWriter, r *http.Request) error {
+	maintenanceReq := new(MaintenanceRequest)
+	if err := bindJSON(w, r, maintenanceReq); err != nil {
+		return err
+	}
+
+	maintenance := &Maintenance{Message: maintenanceReq.Message, StartedBy: adminActor(r)}
+	if maintenanceReq.EndsAt != nil {
+		endsAt := maintenanceReq.EndsAt.UTC()
+		maintenance.EndsAt = &endsAt
+	}
+
+	if err := as.maintenance.Start(r.Context(), maintenance); err != nil {
+		return NewTypedError(http.StatusInternalServerError, "internal_error", "could not store the maintenance mode")
+	}
+
+	as.audit(r, AuditActionStartMaintenance, "maintenance", maintenance)
+
+	return WriteResponse(w, r, http.StatusOK, as.maintenanceStatus())
+}
+
+// handleAdminEndMaintenance ends the maintenance and records it in the audit log.
+func (as *APIServer) handleAdminEndMaintenance(w http.ResponseWriter, r *http.Request) error {
+	if err := as.maintenance.End(r.Context()); err != nil {
+		return NewTypedError(http.StatusInternalServerError, "internal_error", "could not end the maintenance mode")
+	}
+
+	as.audit(r, AuditActionEndMaintenance, "maintenance", nil)
+
+	return WriteResponse(w, r, http.StatusOK, as.maintenanceStatus())
+}
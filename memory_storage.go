@@ -0,0 +1,1644 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moabdelazem/gobank/money"
+)
+
+// MemoryStorage is a map-backed implementation of the Storage interface. It keeps
+// everything in process memory, so it needs no database and loses its data on exit,
+// which makes it suitable for handler tests and the --demo server mode.
+// It is safe for concurrent use; every method sees and leaves a consistent state.
+type MemoryStorage struct {
+	mu    *sync.Mutex
+	state *memoryState
+	// inTx is set on the Storage handed to a WithTx callback, which already holds mu.
+	inTx bool
+}
+
+// memoryState holds the tables of a MemoryStorage. Rows are stored by value so a
+// snapshot of the state can be restored when a transaction is rolled back.
+type memoryState struct {
+	accounts     map[int]Account
+	transactions []Transaction
+	transfers    map[int]Transfer
+	auditLog     []AuditEntry
+	featureFlags map[string]FeatureFlag
+	maintenance  *Maintenance
+	// notificationPrefs holds the notification preferences by account ID.
+	notificationPrefs map[int]NotificationPreferences
+	pushDevices       map[int]PushDevice
+	// gatewayEvents holds the callbacks of the payment gateway by their gateway ID.
+	gatewayEvents map[string]GatewayEvent
+	cardTopUps    map[int]CardTopUp
+	consents      map[int]Consent
+	kycChecks     map[int]KYCCheck
+	outbox        []OutboxEvent
+
+	webhookSecrets map[int]WebhookSecret
+	scheduledJobs  map[string]ScheduledJob
+	jobRuns        []JobRun
+	// idempotencyKeys holds the idempotency records by their key.
+	idempotencyKeys map[string]IdempotencyRecord
+
+	nextAccountID     int
+	nextTransactionID int
+	nextTransferID    int
+	nextAuditID       int
+	nextPushDeviceID  int
+	nextCardTopUpID   int
+	nextConsentID     int
+	nextKYCCheckID    int
+	nextOutboxID      int
+
+	nextWebhookSecretID int
+	nextJobRunID        int
+}
+
+// NewMemoryStorage creates an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		mu: &sync.Mutex{},
+		state: &memoryState{
+			accounts:     map[int]Account{},
+			transfers:    map[int]Transfer{},
+			featureFlags: map[string]FeatureFlag{},
+
+			notificationPrefs: map[int]NotificationPreferences{},
+			pushDevices:       map[int]PushDevice{},
+			gatewayEvents:     map[string]GatewayEvent{},
+			cardTopUps:        map[int]CardTopUp{},
+			consents:          map[int]Consent{},
+			kycChecks:         map[int]KYCCheck{},
+			webhookSecrets:    map[int]WebhookSecret{},
+			scheduledJobs:     map[string]ScheduledJob{},
+			idempotencyKeys:   map[string]IdempotencyRecord{},
+		},
+	}
+}
+
+// clone returns a copy of the state that shares no mutable data with it.
+func (st *memoryState) clone() *memoryState {
+	c := *st
+	c.accounts = maps.Clone(st.accounts)
+	c.transactions = slices.Clone(st.transactions)
+	c.transfers = maps.Clone(st.transfers)
+	c.auditLog = slices.Clone(st.auditLog)
+	c.featureFlags = maps.Clone(st.featureFlags)
+	c.notificationPrefs = maps.Clone(st.notificationPrefs)
+	c.pushDevices = maps.Clone(st.pushDevices)
+	c.gatewayEvents = maps.Clone(st.gatewayEvents)
+	c.cardTopUps = maps.Clone(st.cardTopUps)
+	c.consents = maps.Clone(st.consents)
+	c.kycChecks = maps.Clone(st.kycChecks)
+	c.outbox = slices.Clone(st.outbox)
+	c.webhookSecrets = maps.Clone(st.webhookSecrets)
+	c.scheduledJobs = maps.Clone(st.scheduledJobs)
+	c.jobRuns = slices.Clone(st.jobRuns)
+	c.idempotencyKeys = maps.Clone(st.idempotencyKeys)
+	return &c
+}
+
+// locked runs fn with exclusive access to the state.
+func (s *MemoryStorage) locked(fn func(st *memoryState) error) error {
+	if !s.inTx {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	return fn(s.state)
+}
+
+// WithTx runs fn with exclusive access to the storage. When fn returns an error or
+// panics, every change it made is discarded. Calling WithTx on the Storage passed to
+// fn joins the running transaction.
+func (s *MemoryStorage) WithTx(ctx context.Context, fn func(Storage) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.state.clone()
+	committed := false
+	defer func() {
+		if !committed {
+			*s.state = *snapshot
+		}
+	}()
+
+	if err := fn(&MemoryStorage{mu: s.mu, state: s.state, inTx: true}); err != nil {
+		return err
+	}
+
+	committed = true
+	return nil
+}
+
+// Ping always succeeds, since there is no database to reach.
+func (s *MemoryStorage) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// CreateAccount stores a new account and fills in its ID, timestamps, and version.
+func (s *MemoryStorage) CreateAccount(ctx context.Context, account *Account) error {
+	return s.locked(func(st *memoryState) error {
+		if st.numberTaken(account.Number) {
+			return ErrAccountNumberTaken
+		}
+		if st.externalIDTaken(account.ExternalID) {
+			return ErrExternalIDTaken
+		}
+
+		now := time.Now().UTC()
+
+		st.nextAccountID++
+		account.ID = st.nextAccountID
+		account.CreatedAt = now
+		account.UpdatedAt = now
+		account.Version = 1
+
+		st.accounts[account.ID] = *account
+		return nil
+	})
+}
+
+// DeleteAccount soft-deletes an account, keeping it and its transactions for admin queries.
+func (s *MemoryStorage) DeleteAccount(ctx context.Context, id int) error {
+	return s.locked(func(st *memoryState) error {
+		acc, ok := st.account(id)
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+		}
+
+		deletedAt := time.Now().UTC()
+		acc.DeletedAt = &deletedAt
+		st.touchAccount(&acc)
+		return nil
+	})
+}
+
+// CreateAccounts stores several accounts at once; if a number or an external ID is taken
+// none of them is stored.
+func (s *MemoryStorage) CreateAccounts(ctx context.Context, accounts []*Account) error {
+	return s.locked(func(st *memoryState) error {
+		numbers, externalIDs := map[int64]bool{}, map[string]bool{}
+		for _, acc := range accounts {
+			if numbers[acc.Number] || st.numberTaken(acc.Number) {
+				return ErrAccountNumberTaken
+			}
+			if externalIDs[acc.ExternalID] || st.externalIDTaken(acc.ExternalID) {
+				return ErrExternalIDTaken
+			}
+			numbers[acc.Number] = true
+			if acc.ExternalID != "" {
+				externalIDs[acc.ExternalID] = true
+			}
+		}
+
+		now := time.Now().UTC()
+		for _, acc := range accounts {
+			st.nextAccountID++
+			acc.ID = st.nextAccountID
+			if acc.CreatedAt.IsZero() {
+				acc.CreatedAt = now
+			}
+			acc.UpdatedAt = now
+			acc.Version = 1
+			if acc.KYCStatus == "" {
+				acc.KYCStatus = KYCStatusNotStarted
+			}
+
+			st.accounts[acc.ID] = *acc
+		}
+		return nil
+	})
+}
+
+// numberTaken reports whether any account, deleted ones included, carries the given number.
+func (st *memoryState) numberTaken(number int64) bool {
+	for _, acc := range st.accounts {
+		if acc.Number == number {
+			return true
+		}
+	}
+	return false
+}
+
+// externalIDTaken reports whether an account, deleted or not, was created under the
+// external ID; accounts without one never collide.
+func (st *memoryState) externalIDTaken(externalID string) bool {
+	if externalID == "" {
+		return false
+	}
+	for _, acc := range st.accounts {
+		if acc.ExternalID == externalID {
+			return true
+		}
+	}
+	return false
+}
+
+// account returns the account with the given ID unless it does not exist or is deleted.
+func (st *memoryState) account(id int) (Account, bool) {
+	acc, ok := st.accounts[id]
+	if !ok || acc.DeletedAt != nil {
+		return Account{}, false
+	}
+	return acc, true
+}
+
+// UpdateAccount changes the name of an account if its version still matches account.Version.
+func (s *MemoryStorage) UpdateAccount(ctx context.Context, account *Account) error {
+	return s.locked(func(st *memoryState) error {
+		stored, ok := st.account(account.ID)
+		if !ok || stored.Version != account.Version {
+			return ErrAccountVersionConflict
+		}
+
+		stored.FirstName = account.FirstName
+		stored.LastName = account.LastName
+		st.touchAccount(&stored)
+
+		account.Version = stored.Version
+		account.UpdatedAt = stored.UpdatedAt
+		return nil
+	})
+}
+
+// touchAccount advances the version and modification time of an account and stores it.
+func (st *memoryState) touchAccount(acc *Account) {
+	acc.Version++
+	acc.UpdatedAt = time.Now().UTC()
+	st.accounts[acc.ID] = *acc
+}
+
+// GetAccounts returns all active accounts ordered by ID.
+func (s *MemoryStorage) GetAccounts(ctx context.Context) ([]*Account, error) {
+	accounts := []*Account{}
+	err := s.locked(func(st *memoryState) error {
+		accounts = st.sortedAccounts(false)
+		return nil
+	})
+	return accounts, err
+}
+
+// GetAccountsPage returns a page of the accounts ordered by ID, together with the total number of accounts.
+// Deleted accounts are left out unless includeDeleted is set.
+func (s *MemoryStorage) GetAccountsPage(ctx context.Context, limit, offset int, includeDeleted bool) ([]*Account, int, error) {
+	var (
+		accounts []*Account
+		total    int
+	)
+	err := s.locked(func(st *memoryState) error {
+		all := st.sortedAccounts(includeDeleted)
+		total = len(all)
+		accounts = pageOf(all, limit, offset)
+		return nil
+	})
+	return accounts, total, err
+}
+
+// sortedAccounts returns copies of the accounts ordered by ID, the deleted ones only if includeDeleted is set.
+func (st *memoryState) sortedAccounts(includeDeleted bool) []*Account {
+	accounts := make([]*Account, 0, len(st.accounts))
+	for _, acc := range st.accounts {
+		if acc.DeletedAt != nil && !includeDeleted {
+			continue
+		}
+		accounts = append(accounts, &acc)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return accounts
+}
+
+// GetAccountById returns the account with the given ID.
+func (s *MemoryStorage) GetAccountById(ctx context.Context, id int) (*Account, error) {
+	var account *Account
+	err := s.locked(func(st *memoryState) error {
+		acc, ok := st.account(id)
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+		}
+		account = &acc
+		return nil
+	})
+	return account, err
+}
+
+// GetAccountByNumber returns the account with the given account number.
+func (s *MemoryStorage) GetAccountByNumber(ctx context.Context, number int64) (*Account, error) {
+	var account *Account
+	err := s.locked(func(st *memoryState) error {
+		acc, ok := st.accountByNumber(number)
+		if !ok {
+			return fmt.Errorf("%w: number %d", ErrAccountNotFound, number)
+		}
+		account = &acc
+		return nil
+	})
+	return account, err
+}
+
+// GetAccountByExternalID returns the active account created under an external ID.
+func (s *MemoryStorage) GetAccountByExternalID(ctx context.Context, externalID string) (*Account, error) {
+	var account *Account
+	err := s.locked(func(st *memoryState) error {
+		for _, acc := range st.sortedAccounts(false) {
+			if acc.ExternalID == externalID {
+				account = acc
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: external id %s", ErrAccountNotFound, externalID)
+	})
+	return account, err
+}
+
+// accountByNumber finds the active account with the lowest ID carrying the given number.
+func (st *memoryState) accountByNumber(number int64) (Account, bool) {
+	for _, acc := range st.sortedAccounts(false) {
+		if acc.Number == number {
+			return *acc, true
+		}
+	}
+	return Account{}, false
+}
+
+// GetTransactions returns the transactions of an account that match the filter, newest first.
+func (s *MemoryStorage) GetTransactions(ctx context.Context, accountID int, filter *TransactionFilter) ([]*Transaction, error) {
+	txns := []*Transaction{}
+	err := s.locked(func(st *memoryState) error {
+		for _, t := range st.newestTransactions(accountID) {
+			if filter.matches(t) {
+				txns = append(txns, t)
+			}
+		}
+		return nil
+	})
+	return txns, err
+}
+
+// GetTransactionsPage returns up to limit transactions of an account that match the
+// filter, newest first, starting right after the given cursor.
+func (s *MemoryStorage) GetTransactionsPage(ctx context.Context, accountID int, filter *TransactionFilter, after *TransactionCursor, limit int) ([]*Transaction, error) {
+	txns := []*Transaction{}
+	err := s.locked(func(st *memoryState) error {
+		for _, t := range st.newestTransactions(accountID) {
+			if len(txns) == limit {
+				break
+			}
+			if after != nil && !t.CreatedAt.Before(after.CreatedAt) && !(t.CreatedAt.Equal(after.CreatedAt) && t.ID < after.ID) {
+				continue
+			}
+			if filter.matches(t) {
+				txns = append(txns, t)
+			}
+		}
+		return nil
+	})
+	return txns, err
+}
+
+// newestTransactions returns copies of the transactions of an account ordered by (created_at, id) descending.
+func (st *memoryState) newestTransactions(accountID int) []*Transaction {
+	txns := []*Transaction{}
+	for _, t := range st.transactions {
+		if t.AccountID == accountID {
+			txns = append(txns, &t)
+		}
+	}
+	sort.Slice(txns, func(i, j int) bool {
+		if !txns[i].CreatedAt.Equal(txns[j].CreatedAt) {
+			return txns[i].CreatedAt.After(txns[j].CreatedAt)
+		}
+		return txns[i].ID > txns[j].ID
+	})
+	return txns
+}
+
+// GetBalanceAt returns the balance of an account right before the given time.
+func (s *MemoryStorage) GetBalanceAt(ctx context.Context, accountID int, at time.Time) (money.Money, error) {
+	var balance money.Money
+	err := s.locked(func(st *memoryState) error {
+		acc, ok := st.account(accountID)
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrAccountNotFound, accountID)
+		}
+		balance = money.Zero(acc.Currency)
+		for _, t := range st.newestTransactions(accountID) {
+			if t.CreatedAt.Before(at) {
+				balance = t.BalanceAfter
+				break
+			}
+		}
+		return nil
+	})
+	return balance, err
+}
+
+// StreamTransactions calls fn for every transaction of an account created in the
+// [from, to) range, oldest first. The transactions are collected before fn is called,
+// so fn may use the storage itself.
+func (s *MemoryStorage) StreamTransactions(ctx context.Context, accountID int, from, to time.Time, fn func(*Transaction) error) error {
+	var txns []*Transaction
+	err := s.locked(func(st *memoryState) error {
+		txns = st.newestTransactions(accountID)
+		slices.Reverse(txns)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range txns {
+		if !from.IsZero() && t.CreatedAt.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !t.CreatedAt.Before(to) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StreamLedger calls fn for every ledger entry of every account created in [from, to), oldest first.
+func (s *MemoryStorage) StreamLedger(ctx context.Context, from, to time.Time, fn func(*Transaction) error) error {
+	var txns []*Transaction
+	err := s.locked(func(st *memoryState) error {
+		for _, t := range st.transactions {
+			if !t.CreatedAt.Before(from) && t.CreatedAt.Before(to) {
+				txns = append(txns, &t)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(txns, func(i, j int) bool {
+		if !txns[i].CreatedAt.Equal(txns[j].CreatedAt) {
+			return txns[i].CreatedAt.Before(txns[j].CreatedAt)
+		}
+		return txns[i].ID < txns[j].ID
+	})
+	for _, t := range txns {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ImportRecords stores imported accounts and transactions, reporting an error per
+// record that cannot be stored while keeping the others.
+func (s *MemoryStorage) ImportRecords(ctx context.Context, records []*ImportRecord) ([]error, error) {
+	errs := make([]error, len(records))
+	err := s.locked(func(st *memoryState) error {
+		for i, rec := range records {
+			errs[i] = st.importRecord(rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// importRecord stores a single imported record.
+func (st *memoryState) importRecord(rec *ImportRecord) error {
+	createdAt := rec.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+
+	if rec.Kind == ImportKindAccount {
+		if st.numberTaken(rec.Number) {
+			return ErrAccountNumberTaken
+		}
+
+		st.nextAccountID++
+		st.accounts[st.nextAccountID] = Account{
+			ID:            st.nextAccountID,
+			FirstName:     rec.FirstName,
+			LastName:      rec.LastName,
+			Number:        rec.Number,
+			Balance:       money.New(rec.Balance.Amount, rec.Currency),
+			Currency:      rec.Currency,
+			TransferLimit: money.Zero(rec.Currency),
+			CreatedAt:     createdAt,
+			UpdatedAt:     time.Now().UTC(),
+			Version:       1,
+			KYCStatus:     KYCStatusNotStarted,
+		}
+		return nil
+	}
+
+	acc, ok := st.accountByNumber(rec.AccountNumber)
+	if !ok {
+		return fmt.Errorf("account with number %d not found", rec.AccountNumber)
+	}
+	currency, err := rec.entryCurrency(acc.Currency)
+	if err != nil {
+		return err
+	}
+
+	var counterpartyID int
+	if rec.CounterpartyNumber != 0 {
+		counterparty, ok := st.accountByNumber(rec.CounterpartyNumber)
+		if !ok {
+			return fmt.Errorf("counterparty with number %d not found", rec.CounterpartyNumber)
+		}
+		counterpartyID = counterparty.ID
+	}
+
+	st.addTransaction(&Transaction{
+		AccountID:      acc.ID,
+		CounterpartyID: counterpartyID,
+		Type:           rec.Type,
+		Amount:         money.New(rec.Amount.Amount, currency),
+		BalanceAfter:   money.New(rec.BalanceAfter.Amount, currency),
+		Currency:       currency,
+		CreatedAt:      createdAt,
+		Category:       rec.Category,
+	})
+	return nil
+}
+
+// CreateTransactions stores several ledger entries at once and fills in their IDs and creation times.
+func (s *MemoryStorage) CreateTransactions(ctx context.Context, txns []*Transaction) error {
+	return s.locked(func(st *memoryState) error {
+		for _, t := range txns {
+			st.addTransaction(t)
+		}
+		return nil
+	})
+}
+
+// addTransaction assigns the next ID to a ledger entry and stores it.
+func (st *memoryState) addTransaction(t *Transaction) {
+	st.nextTransactionID++
+	t.ID = st.nextTransactionID
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now().UTC()
+	}
+	st.transactions = append(st.transactions, *t)
+}
+
+// TransferFunds moves amount from one account to another and records both ledger entries.
+// Nothing changes if any check fails.
+func (s *MemoryStorage) TransferFunds(ctx context.Context, fromID, toID int, amount money.Money) ([]*Transaction, error) {
+	var txns []*Transaction
+	err := s.locked(func(st *memoryState) error {
+		from, ok := st.account(fromID)
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrAccountNotFound, fromID)
+		}
+		to, ok := st.account(toID)
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrAccountNotFound, toID)
+		}
+		if from.Frozen || to.Frozen {
+			return fmt.Errorf("account is frozen")
+		}
+		if err := checkTransferCurrency(amount, from.Currency, to.Currency); err != nil {
+			return err
+		}
+		if from.TransferLimit.IsPositive() && amount.Amount > from.TransferLimit.Amount {
+			return fmt.Errorf("amount exceeds the transfer limit of %s", from.TransferLimit)
+		}
+		fromBalance, err := from.Balance.Sub(amount)
+		if err != nil {
+			return err
+		}
+		if fromBalance.IsNegative() {
+			return fmt.Errorf("%w on account %d", ErrInsufficientFunds, fromID)
+		}
+
+		// Move The Funds
+		from.Balance = fromBalance
+		st.touchAccount(&from)
+
+		to = st.accounts[toID]
+		if to.Balance, err = to.Balance.Add(amount); err != nil {
+			return err
+		}
+		st.touchAccount(&to)
+
+		// Record The Ledger Entries
+		debit := &Transaction{AccountID: fromID, CounterpartyID: toID, Type: TransactionTypeTransfer, Amount: amount.Neg(), BalanceAfter: from.Balance, Currency: amount.Currency}
+		credit := &Transaction{AccountID: toID, CounterpartyID: fromID, Type: TransactionTypeTransfer, Amount: amount, BalanceAfter: to.Balance, Currency: amount.Currency}
+		st.addTransaction(debit)
+		st.addTransaction(credit)
+
+		txns = []*Transaction{debit, credit}
+		return nil
+	})
+	return txns, err
+}
+
+// DepositFunds credits an account with money received from outside the bank and records the ledger entry.
+func (s *MemoryStorage) DepositFunds(ctx context.Context, deposit *Transaction) error {
+	return s.locked(func(st *memoryState) error {
+		acc, ok := st.account(deposit.AccountID)
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrAccountNotFound, deposit.AccountID)
+		}
+
+		if err := checkDepositCurrency(deposit, acc.Currency); err != nil {
+			return err
+		}
+		balance, err := acc.Balance.Add(deposit.Amount)
+		if err != nil {
+			return err
+		}
+		acc.Balance = balance
+		st.touchAccount(&acc)
+
+		deposit.Currency = acc.Currency
+		deposit.BalanceAfter = acc.Balance
+		st.addTransaction(deposit)
+		return nil
+	})
+}
+
+// CreateTransfer records a new pending transfer and its first state change.
+func (s *MemoryStorage) CreateTransfer(ctx context.Context, transfer *Transfer) error {
+	return s.locked(func(st *memoryState) error {
+		if transfer.ProviderReference != "" {
+			for _, stored := range st.transfers {
+				if stored.ProviderReference == transfer.ProviderReference {
+					return fmt.Errorf("%w: %s", ErrProviderReferenceTaken, transfer.ProviderReference)
+				}
+			}
+		}
+
+		now := time.Now().UTC()
+
+		st.nextTransferID++
+		transfer.ID = st.nextTransferID
+		transfer.Status = TransferStatusPending
+		transfer.CreatedAt = now
+		transfer.UpdatedAt = now
+		transfer.History = []TransferStateChange{{Status: transfer.Status, At: now}}
+
+		stored := *transfer
+		stored.History = slices.Clone(transfer.History)
+		st.transfers[transfer.ID] = stored
+		return nil
+	})
+}
+
+// UpdateTransferStatus moves a transfer to a new state and appends the change to its history.
+func (s *MemoryStorage) UpdateTransferStatus(ctx context.Context, transfer *Transfer, status, reason string) error {
+	var stored Transfer
+	err := s.locked(func(st *memoryState) error {
+		var ok bool
+		if stored, ok = st.transfers[transfer.ID]; !ok {
+			return fmt.Errorf("%w: %d", ErrTransferNotFound, transfer.ID)
+		}
+
+		now := time.Now().UTC()
+		stored.Status = status
+		stored.FailureReason = reason
+		stored.UpdatedAt = now
+		// Copy The History So Snapshots Never Share It
+		stored.History = append(slices.Clip(stored.History), TransferStateChange{Status: status, Reason: reason, At: now})
+
+		st.transfers[transfer.ID] = stored
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	transfer.Status = stored.Status
+	transfer.FailureReason = stored.FailureReason
+	transfer.UpdatedAt = stored.UpdatedAt
+	transfer.History = slices.Clone(stored.History)
+	return nil
+}
+
+// GetTransfer returns a transfer and the history of its state changes.
+func (s *MemoryStorage) GetTransfer(ctx context.Context, id int) (*Transfer, error) {
+	var transfer *Transfer
+	err := s.locked(func(st *memoryState) error {
+		stored, ok := st.transfers[id]
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrTransferNotFound, id)
+		}
+		stored.History = slices.Clone(stored.History)
+		transfer = &stored
+		return nil
+	})
+	return transfer, err
+}
+
+// GetTransfersByStatus returns the transfers in the given state, oldest first.
+func (s *MemoryStorage) GetTransfersByStatus(ctx context.Context, status string) ([]*Transfer, error) {
+	transfers := []*Transfer{}
+	err := s.locked(func(st *memoryState) error {
+		for _, stored := range st.transfers {
+			if stored.Status == status {
+				stored.History = slices.Clone(stored.History)
+				transfers = append(transfers, &stored)
+			}
+		}
+		return nil
+	})
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].ID < transfers[j].ID })
+	return transfers, err
+}
+
+// GetTransferByProviderReference returns the transfer settled by the payment gateway
+// under a reference, and the history of its state changes.
+func (s *MemoryStorage) GetTransferByProviderReference(ctx context.Context, reference string) (*Transfer, error) {
+	var transfer *Transfer
+	err := s.locked(func(st *memoryState) error {
+		for _, stored := range st.transfers {
+			if stored.ProviderReference == reference {
+				stored.History = slices.Clone(stored.History)
+				transfer = &stored
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s", ErrTransferNotFound, reference)
+	})
+	return transfer, err
+}
+
+// SetAccountFrozen freezes or unfreezes an account.
+func (s *MemoryStorage) SetAccountFrozen(ctx context.Context, id int, frozen bool) error {
+	return s.locked(func(st *memoryState) error {
+		acc, ok := st.account(id)
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+		}
+		acc.Frozen = frozen
+		st.touchAccount(&acc)
+		return nil
+	})
+}
+
+// SetAccountKYCStatus changes the identity verification status of an account.
+func (s *MemoryStorage) SetAccountKYCStatus(ctx context.Context, id int, status string) error {
+	return s.locked(func(st *memoryState) error {
+		acc, ok := st.account(id)
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+		}
+		acc.KYCStatus = status
+		st.touchAccount(&acc)
+		return nil
+	})
+}
+
+// UpdateDormantAccounts marks the active accounts without transactions since inactiveSince
+// as dormant, and clears the mark of the dormant accounts with a transaction since they
+// were marked.
+func (s *MemoryStorage) UpdateDormantAccounts(ctx context.Context, inactiveSince time.Time) (dormant, reactivated []int, err error) {
+	dormant, reactivated = []int{}, []int{}
+	err = s.locked(func(st *memoryState) error {
+		lastActivity := map[int]time.Time{}
+		for _, t := range st.transactions {
+			if t.CreatedAt.After(lastActivity[t.AccountID]) {
+				lastActivity[t.AccountID] = t.CreatedAt
+			}
+		}
+
+		now := time.Now().UTC()
+		for _, acc := range st.sortedAccounts(false) {
+			last, active := lastActivity[acc.ID]
+			switch {
+			case acc.DormantAt != nil && active && !last.Before(*acc.DormantAt):
+				acc.DormantAt = nil
+				st.touchAccount(acc)
+				reactivated = append(reactivated, acc.ID)
+			case acc.DormantAt == nil && acc.CreatedAt.Before(inactiveSince) && (!active || last.Before(inactiveSince)):
+				acc.DormantAt = &now
+				st.touchAccount(acc)
+				dormant = append(dormant, acc.ID)
+			}
+		}
+		return nil
+	})
+	return dormant, reactivated, err
+}
+
+// SetTransferLimit changes the maximum amount of a single outgoing transfer of an account.
+func (s *MemoryStorage) SetTransferLimit(ctx context.Context, id int, limit money.Money) error {
+	return s.locked(func(st *memoryState) error {
+		acc, ok := st.account(id)
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+		}
+		acc.TransferLimit = limit
+		st.touchAccount(&acc)
+		return nil
+	})
+}
+
+// CreateAuditEntry appends an entry to the audit log and fills in its ID and creation time.
+func (s *MemoryStorage) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	return s.locked(func(st *memoryState) error {
+		st.nextAuditID++
+		entry.ID = st.nextAuditID
+		entry.CreatedAt = time.Now().UTC()
+		st.auditLog = append(st.auditLog, *entry)
+		return nil
+	})
+}
+
+// GetAuditLog returns a page of the audit log, newest first, together with the total number of entries.
+func (s *MemoryStorage) GetAuditLog(ctx context.Context, limit, offset int) ([]*AuditEntry, int, error) {
+	var (
+		entries []*AuditEntry
+		total   int
+	)
+	err := s.locked(func(st *memoryState) error {
+		all := make([]*AuditEntry, 0, len(st.auditLog))
+		for i := len(st.auditLog) - 1; i >= 0; i-- {
+			entry := st.auditLog[i]
+			all = append(all, &entry)
+		}
+		total = len(all)
+		entries = pageOf(all, limit, offset)
+		return nil
+	})
+	return entries, total, err
+}
+
+// GetFeatureFlags returns the overrides of the feature flags, ordered by name.
+func (s *MemoryStorage) GetFeatureFlags(ctx context.Context) ([]*FeatureFlag, error) {
+	var flags []*FeatureFlag
+	err := s.locked(func(st *memoryState) error {
+		flags = make([]*FeatureFlag, 0, len(st.featureFlags))
+		for _, name := range slices.Sorted(maps.Keys(st.featureFlags)) {
+			flag := st.featureFlags[name]
+			flags = append(flags, &flag)
+		}
+		return nil
+	})
+	return flags, err
+}
+
+// SetFeatureFlag creates or replaces the override of a feature flag and fills in its update time.
+func (s *MemoryStorage) SetFeatureFlag(ctx context.Context, flag *FeatureFlag) error {
+	return s.locked(func(st *memoryState) error {
+		flag.UpdatedAt = time.Now().UTC()
+		st.featureFlags[flag.Name] = *flag
+		return nil
+	})
+}
+
+// DeleteFeatureFlag removes the override of a feature flag.
+func (s *MemoryStorage) DeleteFeatureFlag(ctx context.Context, name string) error {
+	return s.locked(func(st *memoryState) error {
+		delete(st.featureFlags, name)
+		return nil
+	})
+}
+
+// GetMaintenance returns the maintenance under way, or nil.
+func (s *MemoryStorage) GetMaintenance(ctx context.Context) (*Maintenance, error) {
+	var maintenance *Maintenance
+	err := s.locked(func(st *memoryState) error {
+		if st.maintenance != nil {
+			current := *st.maintenance
+			maintenance = &current
+		}
+		return nil
+	})
+	return maintenance, err
+}
+
+// SetMaintenance starts a maintenance, replacing the one under way, and fills in its start time.
+func (s *MemoryStorage) SetMaintenance(ctx context.Context, maintenance *Maintenance) error {
+	return s.locked(func(st *memoryState) error {
+		maintenance.StartedAt = time.Now().UTC()
+		stored := *maintenance
+		st.maintenance = &stored
+		return nil
+	})
+}
+
+// ClearMaintenance ends the maintenance under way.
+func (s *MemoryStorage) ClearMaintenance(ctx context.Context) error {
+	return s.locked(func(st *memoryState) error {
+		st.maintenance = nil
+		return nil
+	})
+}
+
+// GetNotificationPreferences returns the notification preferences of an account, or nil.
+func (s *MemoryStorage) GetNotificationPreferences(ctx context.Context, accountID int) (*NotificationPreferences, error) {
+	var prefs *NotificationPreferences
+	err := s.locked(func(st *memoryState) error {
+		if stored, ok := st.notificationPrefs[accountID]; ok {
+			prefs = &stored
+		}
+		return nil
+	})
+	return prefs, err
+}
+
+// SetNotificationPreferences replaces the notification preferences of an account and
+// fills in their modification time.
+func (s *MemoryStorage) SetNotificationPreferences(ctx context.Context, prefs *NotificationPreferences) error {
+	return s.locked(func(st *memoryState) error {
+		prefs.UpdatedAt = time.Now().UTC()
+		st.notificationPrefs[prefs.AccountID] = *prefs
+		return nil
+	})
+}
+
+// RegisterPushDevice stores a push device and fills in its ID and registration time,
+// replacing the device already registered with its token.
+func (s *MemoryStorage) RegisterPushDevice(ctx context.Context, device *PushDevice) error {
+	return s.locked(func(st *memoryState) error {
+		device.ID = 0
+		for id, registered := range st.pushDevices {
+			if registered.Token == device.Token {
+				device.ID = id
+			}
+		}
+		if device.ID == 0 {
+			st.nextPushDeviceID++
+			device.ID = st.nextPushDeviceID
+		}
+		device.CreatedAt = time.Now().UTC()
+		st.pushDevices[device.ID] = *device
+		return nil
+	})
+}
+
+// GetPushDevices returns the push devices of an account, oldest first.
+func (s *MemoryStorage) GetPushDevices(ctx context.Context, accountID int) ([]*PushDevice, error) {
+	devices := []*PushDevice{}
+	err := s.locked(func(st *memoryState) error {
+		for _, device := range st.pushDevices {
+			if device.AccountID == accountID {
+				devices = append(devices, &device)
+			}
+		}
+		return nil
+	})
+	slices.SortFunc(devices, func(a, b *PushDevice) int { return a.ID - b.ID })
+	return devices, err
+}
+
+// DeletePushDevice removes a push device of an account.
+func (s *MemoryStorage) DeletePushDevice(ctx context.Context, accountID, id int) error {
+	return s.locked(func(st *memoryState) error {
+		if device, ok := st.pushDevices[id]; !ok || device.AccountID != accountID {
+			return fmt.Errorf("%w: %d", ErrPushDeviceNotFound, id)
+		}
+		delete(st.pushDevices, id)
+		return nil
+	})
+}
+
+// RecordGatewayEvent stores a callback of the payment gateway and fills in its reception time.
+func (s *MemoryStorage) RecordGatewayEvent(ctx context.Context, event *GatewayEvent) error {
+	return s.locked(func(st *memoryState) error {
+		if _, ok := st.gatewayEvents[event.ID]; ok {
+			return fmt.Errorf("%w: %s", ErrGatewayEventDuplicate, event.ID)
+		}
+		event.ReceivedAt = time.Now().UTC()
+		st.gatewayEvents[event.ID] = *event
+		return nil
+	})
+}
+
+// CreateCardTopUp stores a new card top-up and fills in its ID and timestamps.
+func (s *MemoryStorage) CreateCardTopUp(ctx context.Context, topUp *CardTopUp) error {
+	return s.locked(func(st *memoryState) error {
+		st.nextCardTopUpID++
+		topUp.ID = st.nextCardTopUpID
+		topUp.CreatedAt = time.Now().UTC()
+		topUp.UpdatedAt = topUp.CreatedAt
+
+		stored := *topUp
+		stored.ClientSecret = ""
+		st.cardTopUps[topUp.ID] = stored
+		return nil
+	})
+}
+
+// GetCardTopUp returns a card top-up of an account.
+func (s *MemoryStorage) GetCardTopUp(ctx context.Context, accountID, id int) (*CardTopUp, error) {
+	var topUp *CardTopUp
+	err := s.locked(func(st *memoryState) error {
+		stored, ok := st.cardTopUps[id]
+		if !ok || stored.AccountID != accountID {
+			return fmt.Errorf("%w: %d", ErrCardTopUpNotFound, id)
+		}
+		topUp = &stored
+		return nil
+	})
+	return topUp, err
+}
+
+// GetCardTopUpByPaymentIntent returns the card top-up paid by a Stripe payment intent.
+func (s *MemoryStorage) GetCardTopUpByPaymentIntent(ctx context.Context, paymentIntentID string) (*CardTopUp, error) {
+	var topUp *CardTopUp
+	err := s.locked(func(st *memoryState) error {
+		for _, stored := range st.cardTopUps {
+			if stored.PaymentIntentID == paymentIntentID {
+				topUp = &stored
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s", ErrCardTopUpNotFound, paymentIntentID)
+	})
+	return topUp, err
+}
+
+// UpdateCardTopUp stores the changes of a card top-up, provided it is still in the state fromStatus.
+func (s *MemoryStorage) UpdateCardTopUp(ctx context.Context, topUp *CardTopUp, fromStatus string) error {
+	return s.locked(func(st *memoryState) error {
+		stored, ok := st.cardTopUps[topUp.ID]
+		if !ok || stored.Status != fromStatus {
+			return fmt.Errorf("%w: %d", ErrCardTopUpStatusChanged, topUp.ID)
+		}
+		if topUp.ChargeID != "" {
+			for _, other := range st.cardTopUps {
+				if other.ID != topUp.ID && other.ChargeID == topUp.ChargeID {
+					return fmt.Errorf("%w: %d", ErrCardTopUpStatusChanged, topUp.ID)
+				}
+			}
+		}
+
+		topUp.UpdatedAt = time.Now().UTC()
+		stored = *topUp
+		stored.ClientSecret = ""
+		st.cardTopUps[topUp.ID] = stored
+		return nil
+	})
+}
+
+// CreateConsent stores a new consent and fills in its ID and creation time.
+func (s *MemoryStorage) CreateConsent(ctx context.Context, consent *Consent) error {
+	return s.locked(func(st *memoryState) error {
+		st.nextConsentID++
+		consent.ID = st.nextConsentID
+		consent.CreatedAt = time.Now().UTC()
+		st.consents[consent.ID] = consent.stored()
+		return nil
+	})
+}
+
+// GetConsent returns a consent by ID, whatever its account.
+func (s *MemoryStorage) GetConsent(ctx context.Context, id int) (*Consent, error) {
+	var consent Consent
+	err := s.locked(func(st *memoryState) error {
+		stored, ok := st.consents[id]
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrConsentNotFound, id)
+		}
+		consent = stored.stored()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+// GetConsents returns the consents of an account, oldest first.
+func (s *MemoryStorage) GetConsents(ctx context.Context, accountID int) ([]*Consent, error) {
+	consents := []*Consent{}
+	err := s.locked(func(st *memoryState) error {
+		for _, stored := range st.consents {
+			if stored.AccountID == accountID {
+				consent := stored.stored()
+				consents = append(consents, &consent)
+			}
+		}
+		return nil
+	})
+	slices.SortFunc(consents, func(a, b *Consent) int { return a.ID - b.ID })
+	return consents, err
+}
+
+// RevokeConsent revokes a consent of an account, keeping the time it was first revoked.
+func (s *MemoryStorage) RevokeConsent(ctx context.Context, accountID, id int) error {
+	return s.locked(func(st *memoryState) error {
+		consent, ok := st.consents[id]
+		if !ok || consent.AccountID != accountID {
+			return fmt.Errorf("%w: %d", ErrConsentNotFound, id)
+		}
+		if consent.RevokedAt == nil {
+			now := time.Now().UTC()
+			consent.RevokedAt = &now
+			st.consents[id] = consent
+		}
+		return nil
+	})
+}
+
+// CreateKYCCheck stores a new KYC check and fills in its ID and timestamps.
+func (s *MemoryStorage) CreateKYCCheck(ctx context.Context, check *KYCCheck) error {
+	return s.locked(func(st *memoryState) error {
+		st.nextKYCCheckID++
+		check.ID = st.nextKYCCheckID
+		check.CreatedAt = time.Now().UTC()
+		check.UpdatedAt = check.CreatedAt
+		st.kycChecks[check.ID] = *check
+		return nil
+	})
+}
+
+// GetKYCCheck returns the latest KYC check of an account.
+func (s *MemoryStorage) GetKYCCheck(ctx context.Context, accountID int) (*KYCCheck, error) {
+	return s.findKYCCheck(func(c *KYCCheck) bool { return c.AccountID == accountID }, accountID)
+}
+
+// GetKYCCheckByProviderID returns the KYC check with an ID at the provider.
+func (s *MemoryStorage) GetKYCCheckByProviderID(ctx context.Context, providerCheckID string) (*KYCCheck, error) {
+	return s.findKYCCheck(func(c *KYCCheck) bool { return c.ProviderCheckID != "" && c.ProviderCheckID == providerCheckID }, providerCheckID)
+}
+
+// findKYCCheck returns the latest KYC check matching match, or ErrKYCCheckNotFound for key.
+func (s *MemoryStorage) findKYCCheck(match func(*KYCCheck) bool, key interface{}) (*KYCCheck, error) {
+	var found *KYCCheck
+	err := s.locked(func(st *memoryState) error {
+		for _, stored := range st.kycChecks {
+			if match(&stored) && (found == nil || stored.ID > found.ID) {
+				check := stored
+				found = &check
+			}
+		}
+		if found == nil {
+			return fmt.Errorf("%w: %v", ErrKYCCheckNotFound, key)
+		}
+		return nil
+	})
+	return found, err
+}
+
+// GetOpenKYCChecks returns up to limit KYC checks waiting for a result, the ones not
+// submitted to the provider first, then the least recently updated.
+func (s *MemoryStorage) GetOpenKYCChecks(ctx context.Context, limit int) ([]*KYCCheck, error) {
+	checks := []*KYCCheck{}
+	err := s.locked(func(st *memoryState) error {
+		for _, stored := range st.kycChecks {
+			if stored.Status == KYCStatusPending || stored.Status == KYCStatusReview {
+				check := stored
+				checks = append(checks, &check)
+			}
+		}
+		return nil
+	})
+	slices.SortFunc(checks, func(a, b *KYCCheck) int {
+		switch {
+		case (a.ProviderCheckID == "") != (b.ProviderCheckID == ""):
+			if a.ProviderCheckID == "" {
+				return -1
+			}
+			return 1
+		case !a.UpdatedAt.Equal(b.UpdatedAt):
+			return a.UpdatedAt.Compare(b.UpdatedAt)
+		}
+		return a.ID - b.ID
+	})
+	if len(checks) > limit {
+		checks = checks[:limit]
+	}
+	return checks, err
+}
+
+// UpdateKYCCheck stores the changes of a KYC check, provided it is still in the state fromStatus.
+func (s *MemoryStorage) UpdateKYCCheck(ctx context.Context, check *KYCCheck, fromStatus string) error {
+	return s.locked(func(st *memoryState) error {
+		stored, ok := st.kycChecks[check.ID]
+		if !ok || stored.Status != fromStatus {
+			return fmt.Errorf("%w: %d", ErrKYCCheckStatusChanged, check.ID)
+		}
+		check.UpdatedAt = time.Now().UTC()
+		st.kycChecks[check.ID] = *check
+		return nil
+	})
+}
+
+// stored returns a copy of the consent sharing no mutable data with it, without its token.
+func (c *Consent) stored() Consent {
+	stored := *c
+	stored.Token = ""
+	stored.Scopes = slices.Clone(c.Scopes)
+	if c.RevokedAt != nil {
+		revokedAt := *c.RevokedAt
+		stored.RevokedAt = &revokedAt
+	}
+	return stored
+}
+
+// CreateWebhookSecret stores a new webhook secret and fills in its ID and creation time.
+func (s *MemoryStorage) CreateWebhookSecret(ctx context.Context, secret *WebhookSecret) error {
+	return s.locked(func(st *memoryState) error {
+		st.nextWebhookSecretID++
+		secret.ID = st.nextWebhookSecretID
+		secret.CreatedAt = time.Now().UTC()
+		st.webhookSecrets[secret.ID] = secret.stored()
+		return nil
+	})
+}
+
+// GetWebhookSecrets returns the webhook secrets of a provider, of every provider when it
+// is empty, oldest first.
+func (s *MemoryStorage) GetWebhookSecrets(ctx context.Context, provider string) ([]*WebhookSecret, error) {
+	secrets := []*WebhookSecret{}
+	err := s.locked(func(st *memoryState) error {
+		for _, stored := range st.webhookSecrets {
+			if provider == "" || stored.Provider == provider {
+				secret := stored.stored()
+				secrets = append(secrets, &secret)
+			}
+		}
+		return nil
+	})
+	slices.SortFunc(secrets, func(a, b *WebhookSecret) int { return a.ID - b.ID })
+	return secrets, err
+}
+
+// ExpireWebhookSecret sets the expiry of a webhook secret, keeping an earlier one.
+func (s *MemoryStorage) ExpireWebhookSecret(ctx context.Context, id int, at time.Time) (*WebhookSecret, error) {
+	var secret WebhookSecret
+	err := s.locked(func(st *memoryState) error {
+		stored, ok := st.webhookSecrets[id]
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrWebhookSecretNotFound, id)
+		}
+		if stored.ExpiresAt == nil || at.Before(*stored.ExpiresAt) {
+			at = at.UTC()
+			stored.ExpiresAt = &at
+		}
+		st.webhookSecrets[id] = stored
+		secret = stored.stored()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// stored returns a copy of a webhook secret that shares no mutable data with it, without its plain secret.
+func (w *WebhookSecret) stored() WebhookSecret {
+	stored := *w
+	stored.Secret = ""
+	stored.Sealed = slices.Clone(w.Sealed)
+	if w.ExpiresAt != nil {
+		expiresAt := *w.ExpiresAt
+		stored.ExpiresAt = &expiresAt
+	}
+	return stored
+}
+
+// AddOutboxEvents appends domain events to the outbox and fills in their IDs and creation times.
+func (s *MemoryStorage) AddOutboxEvents(ctx context.Context, events []*OutboxEvent) error {
+	return s.locked(func(st *memoryState) error {
+		for _, event := range events {
+			st.nextOutboxID++
+			event.ID = st.nextOutboxID
+			event.CreatedAt = time.Now().UTC()
+			st.outbox = append(st.outbox, *event)
+		}
+		return nil
+	})
+}
+
+// GetUnpublishedOutboxEvents returns the oldest outbox events that are not published yet.
+func (s *MemoryStorage) GetUnpublishedOutboxEvents(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	events := []*OutboxEvent{}
+	err := s.locked(func(st *memoryState) error {
+		for _, event := range st.outbox {
+			if len(events) == limit {
+				break
+			}
+			if event.PublishedAt == nil {
+				events = append(events, &event)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+// MarkOutboxEventsPublished records that outbox events have been published.
+func (s *MemoryStorage) MarkOutboxEventsPublished(ctx context.Context, ids []int) error {
+	return s.locked(func(st *memoryState) error {
+		publishedAt := time.Now().UTC()
+		for i := range st.outbox {
+			if slices.Contains(ids, st.outbox[i].ID) {
+				st.outbox[i].PublishedAt = &publishedAt
+			}
+		}
+		return nil
+	})
+}
+
+// GetPublishedOutboxEvents returns a batch of the published outbox events selected by
+// filter with an ID above afterID, oldest first.
+func (s *MemoryStorage) GetPublishedOutboxEvents(ctx context.Context, filter OutboxEventFilter, afterID, limit int) ([]*OutboxEvent, error) {
+	events := []*OutboxEvent{}
+	err := s.locked(func(st *memoryState) error {
+		for _, event := range st.outbox {
+			if len(events) == limit {
+				break
+			}
+			if event.PublishedAt != nil && event.ID > afterID && filter.matches(&event) {
+				events = append(events, &event)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+// DeletePublishedOutboxEvents removes the published outbox events created before the
+// given time with an ID up to throughID.
+func (s *MemoryStorage) DeletePublishedOutboxEvents(ctx context.Context, before time.Time, throughID int) (int, error) {
+	deleted := 0
+	err := s.locked(func(st *memoryState) error {
+		n := len(st.outbox)
+		st.outbox = slices.DeleteFunc(st.outbox, func(event OutboxEvent) bool {
+			return event.PublishedAt != nil && event.CreatedAt.Before(before) && event.ID <= throughID
+		})
+		deleted = n - len(st.outbox)
+		return nil
+	})
+	return deleted, err
+}
+
+// RegisterScheduledJob stores a scheduled job unless one already has its name, and fills
+// the job in with the stored one.
+func (s *MemoryStorage) RegisterScheduledJob(ctx context.Context, job *ScheduledJob) error {
+	return s.locked(func(st *memoryState) error {
+		stored, ok := st.scheduledJobs[job.Name]
+		if !ok {
+			job.UpdatedAt = time.Now().UTC()
+			stored = job.stored()
+			st.scheduledJobs[job.Name] = stored
+		}
+		*job = stored.stored()
+		return nil
+	})
+}
+
+// GetScheduledJobs returns every scheduled job by name.
+func (s *MemoryStorage) GetScheduledJobs(ctx context.Context) ([]*ScheduledJob, error) {
+	jobs := []*ScheduledJob{}
+	err := s.locked(func(st *memoryState) error {
+		for _, stored := range st.scheduledJobs {
+			job := stored.stored()
+			jobs = append(jobs, &job)
+		}
+		return nil
+	})
+	slices.SortFunc(jobs, func(a, b *ScheduledJob) int { return strings.Compare(a.Name, b.Name) })
+	return jobs, err
+}
+
+// GetScheduledJob returns a scheduled job by name.
+func (s *MemoryStorage) GetScheduledJob(ctx context.Context, name string) (*ScheduledJob, error) {
+	var job ScheduledJob
+	err := s.locked(func(st *memoryState) error {
+		stored, ok := st.scheduledJobs[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrScheduledJobNotFound, name)
+		}
+		job = stored.stored()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// UpdateScheduledJob stores the schedule, the enabled flag, and the next run of a
+// scheduled job, and fills in the time of the change.
+func (s *MemoryStorage) UpdateScheduledJob(ctx context.Context, job *ScheduledJob) error {
+	return s.locked(func(st *memoryState) error {
+		stored, ok := st.scheduledJobs[job.Name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrScheduledJobNotFound, job.Name)
+		}
+		stored.Schedule = job.Schedule
+		stored.Enabled = job.Enabled
+		stored.NextRunAt = job.NextRunAt
+		stored.UpdatedAt = time.Now().UTC()
+		job.UpdatedAt = stored.UpdatedAt
+		st.scheduledJobs[job.Name] = stored
+		return nil
+	})
+}
+
+// LockScheduledJob leases a scheduled job to an instance, unless another instance holds
+// an unexpired lease.
+func (s *MemoryStorage) LockScheduledJob(ctx context.Context, name, owner string, now, until time.Time) (*ScheduledJob, error) {
+	var job ScheduledJob
+	err := s.locked(func(st *memoryState) error {
+		stored, ok := st.scheduledJobs[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrScheduledJobNotFound, name)
+		}
+		if stored.LockedUntil != nil && !stored.LockedUntil.Before(now) && stored.LockedBy != owner {
+			return fmt.Errorf("%w: %s", ErrScheduledJobLocked, name)
+		}
+		until = until.UTC()
+		stored.LockedBy = owner
+		stored.LockedUntil = &until
+		st.scheduledJobs[name] = stored
+		job = stored.stored()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// UnlockScheduledJob releases the lease of a scheduled job after a run.
+func (s *MemoryStorage) UnlockScheduledJob(ctx context.Context, name, owner string, lastRunAt, nextRunAt *time.Time) error {
+	return s.locked(func(st *memoryState) error {
+		stored, ok := st.scheduledJobs[name]
+		if !ok || stored.LockedBy != owner {
+			return fmt.Errorf("%w: %s", ErrScheduledJobLocked, name)
+		}
+		stored.LockedBy = ""
+		stored.LockedUntil = nil
+		if lastRunAt != nil {
+			last := lastRunAt.UTC()
+			stored.LastRunAt = &last
+		}
+		if nextRunAt != nil {
+			stored.NextRunAt = nextRunAt.UTC()
+		}
+		st.scheduledJobs[name] = stored
+		return nil
+	})
+}
+
+// CreateJobRun records the start of a run of a scheduled job and fills in its ID and start time.
+func (s *MemoryStorage) CreateJobRun(ctx context.Context, run *JobRun) error {
+	return s.locked(func(st *memoryState) error {
+		st.nextJobRunID++
+		run.ID = st.nextJobRunID
+		run.StartedAt = time.Now().UTC()
+		st.jobRuns = append(st.jobRuns, run.stored())
+		return nil
+	})
+}
+
+// FinishJobRun records the outcome of a run of a scheduled job and fills in its end time.
+func (s *MemoryStorage) FinishJobRun(ctx context.Context, run *JobRun) error {
+	return s.locked(func(st *memoryState) error {
+		i := slices.IndexFunc(st.jobRuns, func(stored JobRun) bool { return stored.ID == run.ID })
+		if i < 0 {
+			return fmt.Errorf("job run %d not found", run.ID)
+		}
+		finishedAt := time.Now().UTC()
+		run.FinishedAt = &finishedAt
+		st.jobRuns[i] = run.stored()
+		return nil
+	})
+}
+
+// GetJobRuns returns the most recent runs of a scheduled job, newest first.
+func (s *MemoryStorage) GetJobRuns(ctx context.Context, job string, limit int) ([]*JobRun, error) {
+	runs := []*JobRun{}
+	err := s.locked(func(st *memoryState) error {
+		for i := len(st.jobRuns) - 1; i >= 0 && len(runs) < limit; i-- {
+			if st.jobRuns[i].Job == job {
+				run := st.jobRuns[i].stored()
+				runs = append(runs, &run)
+			}
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// ReserveIdempotencyKey stores the record of a request sent with an Idempotency-Key,
+// unless the key has an unexpired record, which it returns instead.
+func (s *MemoryStorage) ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	var existing *IdempotencyRecord
+	err := s.locked(func(st *memoryState) error {
+		now := record.CreatedAt
+		stored, ok := st.idempotencyKeys[record.Key]
+		free := !ok || !stored.ExpiresAt.After(now) || (stored.InProgress() && !stored.LockedUntil.After(now))
+		if !free {
+			copied := stored.stored()
+			existing = &copied
+			return nil
+		}
+		st.idempotencyKeys[record.Key] = record.stored()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// CompleteIdempotencyKey stores the response of a request sent with an Idempotency-Key.
+func (s *MemoryStorage) CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	return s.locked(func(st *memoryState) error {
+		stored, ok := st.idempotencyKeys[record.Key]
+		if !ok || !stored.InProgress() || !stored.LockedUntil.Equal(record.LockedUntil) {
+			return ErrIdempotencyKeyLost
+		}
+		st.idempotencyKeys[record.Key] = record.stored()
+		return nil
+	})
+}
+
+// ReleaseIdempotencyKey removes the record of a request sent with an Idempotency-Key
+// that was not answered, unless another request has taken the key over.
+func (s *MemoryStorage) ReleaseIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	return s.locked(func(st *memoryState) error {
+		if stored, ok := st.idempotencyKeys[record.Key]; ok && stored.InProgress() && stored.LockedUntil.Equal(record.LockedUntil) {
+			delete(st.idempotencyKeys, record.Key)
+		}
+		return nil
+	})
+}
+
+// DeleteExpiredIdempotencyKeys removes the records of the requests sent with an
+// Idempotency-Key whose response is no longer replayed.
+func (s *MemoryStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	deleted := 0
+	err := s.locked(func(st *memoryState) error {
+		for key, stored := range st.idempotencyKeys {
+			if !stored.ExpiresAt.After(now) {
+				delete(st.idempotencyKeys, key)
+				deleted++
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// stored returns a copy of a scheduled job that shares no mutable data with it.
+func (j *ScheduledJob) stored() ScheduledJob {
+	stored := *j
+	if j.LastRunAt != nil {
+		lastRunAt := *j.LastRunAt
+		stored.LastRunAt = &lastRunAt
+	}
+	if j.LockedUntil != nil {
+		lockedUntil := *j.LockedUntil
+		stored.LockedUntil = &lockedUntil
+	}
+	return stored
+}
+
+// stored returns a copy of a job run that shares no mutable data with it.
+func (r *JobRun) stored() JobRun {
+	stored := *r
+	if r.FinishedAt != nil {
+		finishedAt := *r.FinishedAt
+		stored.FinishedAt = &finishedAt
+	}
+	return stored
+}
+
+// stored returns a copy of an idempotency record that shares no mutable data with it.
+func (rec *IdempotencyRecord) stored() IdempotencyRecord {
+	stored := *rec
+	stored.Header = rec.Header.Clone()
+	stored.Body = slices.Clone(rec.Body)
+	return stored
+}
+
+// pageOf returns the items of a page of an ordered list.
+func pageOf[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	return items[offset:min(offset+limit, len(items))]
+}
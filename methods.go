@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// routeMethods are the methods probed when computing the Allow header of a path.
+var routeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// allowedMethods returns the methods the router serves for the path of the request.
+// OPTIONS is always included since it is answered for every known path.
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	allowed := []string{}
+
+	for _, method := range routeMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+
+	return append(allowed, http.MethodOptions)
+}
+
+// methodNotAllowedHandler is used by the router when a path exists but not for the
+// request's method. OPTIONS requests get a 204 with the supported methods in the Allow
+// header; every other method gets a 405 with the same header.
+func methodNotAllowedHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := strings.Join(allowedMethods(router, r), ", ")
+		w.Header().Set("Allow", allowed)
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		WriteError(w, http.StatusMethodNotAllowed, localize(r, "method.not_allowed", fmt.Sprintf("method %s is not allowed, allowed methods: %s", r.Method, allowed), "method", r.Method, "allowed", allowed))
+	})
+}
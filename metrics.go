@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes the names of all metrics exported by the server.
+const metricsNamespace = "gobank"
+
+// poolStatser is implemented by stores backed by a connection pool.
+type poolStatser interface {
+	PoolStats() *pgxpool.Stat
+}
+
+// newMetricsRegistry creates the Prometheus registry served on /metrics, with the Go
+// runtime and process collectors, the result of the database health checks, the slow
+// request count, the events published to and replayed on the external event bus, the notifications
+// sent, the payment gateway callbacks, the Stripe webhook events, the inbound webhook signatures checked, the operational alerts posted, the staff logins, the KYC results, the settlement requests and retries, the health of the exchange rate provider, the scheduled job runs, the transactions retried after a conflict, the collectors of the storage decorators (account cache, storage call timings,
+// retries and circuit breaker), and, when the store has one, the connection pool
+// statistics and the slow query count.
+func newMetricsRegistry(store Storage, health *dbHealthMonitor) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		slowRequests,
+		eventsPublished,
+		eventPublishFailures,
+		eventsReplayed,
+		notificationsSent,
+		notificationFailures,
+		gatewayCallbacks,
+		stripeWebhooks,
+		webhookVerifications,
+		alertsSent,
+		alertFailures,
+		staffLogins,
+		kycResults,
+		settlementRequests,
+		settlementRetries,
+		fxProviderUp,
+		fxRateFallbacks,
+		jobRuns,
+		jobRunDuration,
+		transactionRetries,
+	)
+
+	registerDBHealthMetrics(reg, health)
+
+	for layer := store; layer != nil; layer = unwrapOnce(layer) {
+		if provider, ok := layer.(collectorsProvider); ok {
+			reg.MustRegister(provider.Collectors()...)
+		}
+	}
+
+	if pooled, ok := unwrapStorage(store).(poolStatser); ok {
+		registerPoolMetrics(reg, pooled)
+		reg.MustRegister(slowQueries)
+	}
+
+	return reg
+}
+
+// storageWrapper is implemented by the Storage decorators.
+type storageWrapper interface {
+	Unwrap() Storage
+}
+
+// collectorsProvider is implemented by the Storage decorators that export metrics.
+type collectorsProvider interface {
+	Collectors() []prometheus.Collector
+}
+
+// unwrapOnce returns the storage wrapped by a decorator, or nil if store is not one.
+func unwrapOnce(store Storage) Storage {
+	if wrapper, ok := store.(storageWrapper); ok {
+		return wrapper.Unwrap()
+	}
+	return nil
+}
+
+// unwrapStorage returns the storage behind all the decorators wrapping store.
+func unwrapStorage(store Storage) Storage {
+	for {
+		inner := unwrapOnce(store)
+		if inner == nil {
+			return store
+		}
+		store = inner
+	}
+}
+
+// registerDBHealthMetrics exports the result of the latest database health check.
+func registerDBHealthMetrics(reg *prometheus.Registry, health *dbHealthMonitor) {
+	reg.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Subsystem: "db", Name: "up",
+			Help: "Whether the database answered the latest health check (1) or not (0).",
+		}, func() float64 {
+			if health.up() {
+				return 1
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Subsystem: "db", Name: "ping_latency_seconds",
+			Help: "Duration of the latest database health check.",
+		}, func() float64 {
+			latency, _, _ := health.status()
+			return latency.Seconds()
+		}),
+	)
+}
+
+// registerPoolMetrics exports the statistics of the database connection pool.
+func registerPoolMetrics(reg *prometheus.Registry, pooled poolStatser) {
+	gauge := func(name, help string, value func(*pgxpool.Stat) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Subsystem: "db_pool", Name: name, Help: help,
+		}, func() float64 { return value(pooled.PoolStats()) })
+	}
+	counter := func(name, help string, value func(*pgxpool.Stat) float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "db_pool", Name: name, Help: help,
+		}, func() float64 { return value(pooled.PoolStats()) })
+	}
+
+	reg.MustRegister(
+		gauge("max_conns", "Maximum number of connections in the pool.", func(st *pgxpool.Stat) float64 { return float64(st.MaxConns()) }),
+		gauge("total_conns", "Number of open connections.", func(st *pgxpool.Stat) float64 { return float64(st.TotalConns()) }),
+		gauge("acquired_conns", "Number of connections currently in use.", func(st *pgxpool.Stat) float64 { return float64(st.AcquiredConns()) }),
+		gauge("idle_conns", "Number of idle connections.", func(st *pgxpool.Stat) float64 { return float64(st.IdleConns()) }),
+		gauge("utilization", "Share of the maximum connections currently in use.", func(st *pgxpool.Stat) float64 { return poolUtilization(st.AcquiredConns(), st.MaxConns()) }),
+		counter("acquires_total", "Number of successful connection acquisitions.", func(st *pgxpool.Stat) float64 { return float64(st.AcquireCount()) }),
+		counter("empty_acquires_total", "Number of acquisitions that had to wait because the pool was empty.", func(st *pgxpool.Stat) float64 { return float64(st.EmptyAcquireCount()) }),
+		counter("canceled_acquires_total", "Number of acquisitions canceled by their context.", func(st *pgxpool.Stat) float64 { return float64(st.CanceledAcquireCount()) }),
+		counter("acquire_duration_seconds_total", "Total time spent waiting for connections.", func(st *pgxpool.Stat) float64 { return st.AcquireDuration().Seconds() }),
+	)
+}
+
+// handleMetrics serves the metrics in the Prometheus exposition format.
+func (as *APIServer) handleMetrics() http.Handler {
+	return promhttp.HandlerFor(as.metrics, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// migrationFiles holds the versioned schema migrations. Each version has an
+// NNNN_name.up.sql file and an NNNN_name.down.sql file that reverts it.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock key held while migrating, so that
+// several instances starting at once never apply the same migration twice.
+const migrationLockID = 7265340862
+
+// migrationFileName matches the names of the migration files.
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadMigrations reads the embedded migration files, ordered by version.
+//
+// Returns:
+//   - []*Migration: The migrations, oldest first.
+//   - error: An error if a file is misnamed, a version is duplicated, or an up migration is missing.
+func loadMigrations() ([]*Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names: %s and %s", version, m.Name, match[2])
+		}
+
+		content, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// MigrationStatus reports whether a known migration is applied to the database.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// withMigrationLock runs fn on a single connection holding the migration advisory lock,
+// after making sure the schema_migrations table exists.
+func (s *PostgresStorage) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	// Advisory Locks Belong To A Session, So Keep One Connection
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// appliedMigrations returns when each applied migration was applied, by version.
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// Migrate applies every pending migration in version order. Each migration runs in its
+// own database transaction together with its row in the schema_migrations table, which
+// records when it was applied. The early migrations only create what is missing, so
+// databases set up before versioned migrations existed are adopted as they are.
+//
+// Returns:
+//   - []*Migration: The migrations applied by this call, empty if the schema was up to date.
+//   - error: An error if the migrations cannot be read or one of them fails.
+func (s *PostgresStorage) Migrate() ([]*Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	done := []*Migration{}
+
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+
+			err := runMigration(ctx, conn, m.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", m.Version, m.Name, err)
+			}
+
+			slog.Info("Applied Migration", "version", m.Version, "name", m.Name)
+			done = append(done, m)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// MigrateDown reverts the given number of applied migrations, newest first, by running
+// their down files. Each migration is reverted in its own database transaction together
+// with the removal of its row from the schema_migrations table.
+//
+// Parameters:
+//   - steps: How many of the applied migrations to revert.
+//
+// Returns:
+//   - []*Migration: The migrations reverted by this call, newest first.
+//   - error: An error if the migrations cannot be read, one has no down file, or one of them fails.
+func (s *PostgresStorage) MigrateDown(steps int) ([]*Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	done := []*Migration{}
+
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+			}
+
+			err := runMigration(ctx, conn, m.Down, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+			if err != nil {
+				return fmt.Errorf("reverting migration %d_%s failed: %w", m.Version, m.Name, err)
+			}
+
+			slog.Info("Reverted Migration", "version", m.Version, "name", m.Name)
+			done = append(done, m)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// runMigration runs a migration file and the statement recording it in one transaction.
+func runMigration(ctx context.Context, conn *sql.Conn, migration, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MigrationStatus lists every known migration, oldest first, with whether and when it
+// was applied to the database.
+//
+// Returns:
+//   - []*MigrationStatus: The status of each migration.
+//   - error: An error if the migrations cannot be read or the schema_migrations table cannot be queried.
+func (s *PostgresStorage) MigrationStatus() ([]*MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	statuses := make([]*MigrationStatus, 0, len(migrations))
+
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			status := &MigrationStatus{Version: m.Version, Name: m.Name}
+			if appliedAt, ok := applied[m.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// schemaVersioner is implemented by stores whose schema is versioned by migrations.
+type schemaVersioner interface {
+	SchemaVersion(ctx context.Context) (int, error)
+}
+
+// SchemaVersion returns the version of the newest applied migration, 0 when none is applied.
+func (s *PostgresStorage) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// runMigrateCommand runs "gobank migrate".
+//
+// Usage:
+//   - gobank migrate [up]: Applies every pending migration.
+//   - gobank migrate down [n]: Reverts the newest n applied migrations, 1 by default.
+//   - gobank migrate status: Lists the migrations and whether they are applied.
+//
+// Parameters:
+//   - store: The database to migrate.
+//   - args: The arguments following the command.
+//
+// Returns:
+//   - error: An error if the arguments are invalid or the migrations fail.
+func runMigrateCommand(store *PostgresStorage, args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		applied, err := store.Migrate()
+		if err != nil {
+			return err
+		}
+		slog.Info("Database Schema Is Up To Date", "applied", len(applied))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("the number of migrations to revert must be a positive integer")
+			}
+			steps = n
+		}
+
+		reverted, err := store.MigrateDown(steps)
+		if err != nil {
+			return err
+		}
+		slog.Info("Migrations Reverted", "reverted", len(reverted))
+	case "status":
+		statuses, err := store.MigrationStatus()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		version := 0
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+				version = status.Version
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		w.Flush()
+		fmt.Printf("\nSchema Version: %d\n", version)
+	default:
+		return fmt.Errorf("unknown migrate action %q, use up, down, or status", action)
+	}
+
+	return nil
+}
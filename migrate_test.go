@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsHaveDownFiles(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+
+	for _, m := range migrations {
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+// schemaSnapshot lists the tables, columns, indexes, and constraints of the public
+// schema, one sorted line each, so that two states of the schema can be compared.
+func schemaSnapshot(t *testing.T, store *PostgresStorage) []string {
+	t.Helper()
+
+	rows, err := store.db.QueryContext(context.Background(), `
+		SELECT 'column ' || table_name || '.' || column_name || ' ' || data_type || ' ' || is_nullable || ' ' || COALESCE(column_default, '')
+		FROM information_schema.columns WHERE table_schema = 'public'
+		UNION ALL
+		SELECT 'index ' || indexname || ' ' || indexdef
+		FROM pg_indexes WHERE schemaname = 'public'
+		UNION ALL
+		SELECT 'constraint ' || conrelid::regclass || '.' || conname || ' ' || pg_get_constraintdef(oid)
+		FROM pg_constraint WHERE connamespace = 'public'::regnamespace
+		ORDER BY 1`)
+	if err != nil {
+		t.Fatalf("reading the schema: %v", err)
+	}
+	defer rows.Close()
+
+	var snapshot []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			t.Fatalf("reading the schema: %v", err)
+		}
+		snapshot = append(snapshot, line)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("reading the schema: %v", err)
+	}
+	return snapshot
+}
+
+// TestMigrationsRoundTrip reverts every migration of a migrated database and applies
+// them again, checking that the down migrations undo the up ones: nothing but the
+// schema_migrations table is left once they are all reverted, and the schema applied
+// again is the one they started from. It drops every table, so TEST_DB_URL must point
+// at a scratch database.
+func TestMigrationsRoundTrip(t *testing.T) {
+	store := newTestPostgresStorage(t)
+	migrated := schemaSnapshot(t, store)
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+
+	reverted, err := store.MigrateDown(len(migrations))
+	if err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if len(reverted) != len(migrations) {
+		t.Errorf("MigrateDown reverted %d migrations, want %d", len(reverted), len(migrations))
+	}
+
+	statuses, err := store.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	for _, status := range statuses {
+		if status.Applied {
+			t.Errorf("migration %d_%s is still applied after reverting them all", status.Version, status.Name)
+		}
+	}
+	for _, line := range schemaSnapshot(t, store) {
+		if !slices.Contains(migrated, line) || !isSchemaMigrationsLine(line) {
+			t.Errorf("left after reverting every migration: %s", line)
+		}
+	}
+
+	applied, err := store.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate after MigrateDown: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("Migrate applied %d migrations again, want %d", len(applied), len(migrations))
+	}
+
+	if again := schemaSnapshot(t, store); !slices.Equal(again, migrated) {
+		for _, line := range migrated {
+			if !slices.Contains(again, line) {
+				t.Errorf("missing once migrated again: %s", line)
+			}
+		}
+		for _, line := range again {
+			if !slices.Contains(migrated, line) {
+				t.Errorf("new once migrated again: %s", line)
+			}
+		}
+	}
+}
+
+// isSchemaMigrationsLine reports whether a line of schemaSnapshot describes the
+// schema_migrations table, which the migrations never drop.
+func isSchemaMigrationsLine(line string) bool {
+	for _, prefix := range []string{"column schema_migrations.", "index schema_migrations_", "constraint schema_migrations."} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS accounts (
+	id SERIAL PRIMARY KEY,
+	first_name TEXT,
+	last_name TEXT,
+	number BIGINT,
+	balance BIGINT,
+	create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS transactions (
+	id SERIAL PRIMARY KEY,
+	account_id INT REFERENCES accounts(id) ON DELETE CASCADE,
+	counterparty_id INT,
+	type TEXT,
+	amount BIGINT,
+	balance_after BIGINT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
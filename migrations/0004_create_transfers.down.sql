@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS transfer_state_changes;
+DROP TABLE IF EXISTS transfers;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS transfers (
+	id SERIAL PRIMARY KEY,
+	from_account_id INT,
+	to_account_id INT,
+	amount BIGINT,
+	status TEXT,
+	failure_reason TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS transfer_state_changes (
+	id SERIAL PRIMARY KEY,
+	transfer_id INT REFERENCES transfers(id) ON DELETE CASCADE,
+	status TEXT,
+	reason TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS outbox (
+	id SERIAL PRIMARY KEY,
+	type TEXT NOT NULL,
+	account_id INT NOT NULL,
+	payload JSONB NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	published_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
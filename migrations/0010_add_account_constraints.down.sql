@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS accounts_number_key;
+
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_balance_non_negative;
+
+ALTER TABLE accounts
+	ALTER COLUMN first_name DROP NOT NULL,
+	ALTER COLUMN last_name DROP NOT NULL,
+	ALTER COLUMN number DROP NOT NULL,
+	ALTER COLUMN balance DROP NOT NULL,
+	ALTER COLUMN balance DROP DEFAULT,
+	ALTER COLUMN create_at DROP NOT NULL;
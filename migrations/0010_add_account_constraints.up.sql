@@ -0,0 +1,12 @@
+ALTER TABLE accounts
+	ALTER COLUMN first_name SET NOT NULL,
+	ALTER COLUMN last_name SET NOT NULL,
+	ALTER COLUMN number SET NOT NULL,
+	ALTER COLUMN balance SET NOT NULL,
+	ALTER COLUMN balance SET DEFAULT 0,
+	ALTER COLUMN create_at SET NOT NULL;
+
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_balance_non_negative;
+ALTER TABLE accounts ADD CONSTRAINT accounts_balance_non_negative CHECK (balance >= 0);
+
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_number_key ON accounts (number);
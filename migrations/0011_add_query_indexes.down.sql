@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS transactions_account_id_created_at_idx;
+DROP INDEX IF EXISTS accounts_last_name_idx;
@@ -0,0 +1,8 @@
+-- accounts(number) is already covered by the unique index accounts_number_key.
+
+-- Account Lookups By Name
+CREATE INDEX IF NOT EXISTS accounts_last_name_idx ON accounts (last_name);
+
+-- Transaction History, Statements, And Balance Lookups Of An Account,
+-- Read In (created_at, id) Order Or Its Reverse
+CREATE INDEX IF NOT EXISTS transactions_account_id_created_at_idx ON transactions (account_id, created_at, id);
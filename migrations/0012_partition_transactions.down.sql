@@ -0,0 +1,23 @@
+CREATE TABLE transactions_unpartitioned (
+	id INT PRIMARY KEY DEFAULT nextval('transactions_id_seq'),
+	account_id INT REFERENCES accounts(id) ON DELETE CASCADE,
+	counterparty_id INT,
+	type TEXT,
+	amount BIGINT,
+	balance_after BIGINT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	category TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO transactions_unpartitioned (id, account_id, counterparty_id, type, amount, balance_after, created_at, category)
+SELECT id, account_id, counterparty_id, type, amount, balance_after, created_at, category
+FROM transactions;
+
+ALTER SEQUENCE transactions_id_seq OWNED BY transactions_unpartitioned.id;
+
+DROP TABLE transactions;
+DROP FUNCTION IF EXISTS create_transactions_partition(DATE);
+
+ALTER TABLE transactions_unpartitioned RENAME TO transactions;
+ALTER TABLE transactions RENAME CONSTRAINT transactions_unpartitioned_pkey TO transactions_pkey;
+CREATE INDEX IF NOT EXISTS transactions_account_id_created_at_idx ON transactions (account_id, created_at, id);
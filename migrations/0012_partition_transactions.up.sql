@@ -0,0 +1,63 @@
+-- Moves the ledger into a transactions table partitioned by month of created_at.
+-- The partition key must be part of the primary key, so it becomes (id, created_at);
+-- ids keep coming from the existing sequence.
+
+ALTER TABLE transactions RENAME TO transactions_unpartitioned;
+ALTER INDEX IF EXISTS transactions_account_id_created_at_idx RENAME TO transactions_unpartitioned_account_id_created_at_idx;
+
+CREATE TABLE transactions (
+	id INT NOT NULL DEFAULT nextval('transactions_id_seq'),
+	account_id INT REFERENCES accounts(id) ON DELETE CASCADE,
+	counterparty_id INT,
+	type TEXT,
+	amount BIGINT,
+	balance_after BIGINT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	category TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+CREATE INDEX transactions_account_id_created_at_idx ON transactions (account_id, created_at, id);
+
+-- Catches the rows of months without a partition yet
+CREATE TABLE transactions_default PARTITION OF transactions DEFAULT;
+
+-- create_transactions_partition creates the partition of the month containing the given
+-- date, named transactions_YYYY_MM, unless it exists. Rows of that month that landed in
+-- the default partition are moved into it. Returns the name of the partition.
+CREATE OR REPLACE FUNCTION create_transactions_partition(month DATE) RETURNS TEXT AS $$
+DECLARE
+	start_at TIMESTAMP := date_trunc('month', month);
+	end_at TIMESTAMP := date_trunc('month', month) + INTERVAL '1 month';
+	partition_name TEXT := 'transactions_' || to_char(month, 'YYYY_MM');
+BEGIN
+	IF to_regclass(partition_name) IS NOT NULL THEN
+		RETURN partition_name;
+	END IF;
+
+	EXECUTE format('CREATE TABLE %I (LIKE transactions INCLUDING DEFAULTS)', partition_name);
+	EXECUTE format(
+		'WITH moved AS (DELETE FROM transactions_default WHERE created_at >= %L AND created_at < %L RETURNING *) INSERT INTO %I SELECT * FROM moved',
+		start_at, end_at, partition_name
+	);
+	EXECUTE format('ALTER TABLE transactions ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)', partition_name, start_at, end_at);
+
+	RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
+
+-- One Partition Per Month Of The Existing History, Up To Next Month
+SELECT create_transactions_partition(month::date)
+FROM generate_series(
+	date_trunc('month', (SELECT COALESCE(MIN(created_at), CURRENT_TIMESTAMP) FROM transactions_unpartitioned)),
+	date_trunc('month', CURRENT_TIMESTAMP) + INTERVAL '1 month',
+	INTERVAL '1 month'
+) AS month;
+
+INSERT INTO transactions (id, account_id, counterparty_id, type, amount, balance_after, created_at, category)
+SELECT id, account_id, counterparty_id, type, amount, balance_after, COALESCE(created_at, CURRENT_TIMESTAMP), category
+FROM transactions_unpartitioned;
+
+ALTER SEQUENCE transactions_id_seq OWNED BY transactions.id;
+
+DROP TABLE transactions_unpartitioned;
@@ -0,0 +1 @@
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS request_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS feature_flags (
+	name TEXT PRIMARY KEY,
+	enabled BOOLEAN NOT NULL,
+	rollout_percent INTEGER NOT NULL DEFAULT 100 CHECK (rollout_percent BETWEEN 0 AND 100),
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
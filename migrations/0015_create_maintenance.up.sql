@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS maintenance (
+	id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+	message TEXT NOT NULL,
+	ends_at TIMESTAMP NULL,
+	started_by TEXT NOT NULL,
+	started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
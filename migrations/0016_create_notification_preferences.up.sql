@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+	account_id INT PRIMARY KEY REFERENCES accounts(id) ON DELETE CASCADE,
+	email TEXT NOT NULL DEFAULT '',
+	transfer_confirmations BOOLEAN NOT NULL DEFAULT FALSE,
+	low_balance_alerts BOOLEAN NOT NULL DEFAULT FALSE,
+	low_balance_threshold BIGINT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS large_transaction_threshold;
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS large_transaction_alerts;
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS phone_verified;
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS phone;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS push_devices (
+	id SERIAL PRIMARY KEY,
+	account_id INT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+	platform TEXT NOT NULL,
+	token TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS push_devices_account_id_idx ON push_devices (account_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS gateway_events;
+ALTER TABLE transfers DROP COLUMN IF EXISTS provider_reference;
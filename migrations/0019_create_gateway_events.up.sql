@@ -0,0 +1,9 @@
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS provider_reference TEXT;
+ALTER TABLE transfers ADD CONSTRAINT transfers_provider_reference_key UNIQUE (provider_reference);
+
+CREATE TABLE IF NOT EXISTS gateway_events (
+	id TEXT PRIMARY KEY,
+	type TEXT NOT NULL,
+	transfer_id INT NOT NULL REFERENCES transfers(id) ON DELETE CASCADE,
+	received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS card_top_ups;
+ALTER TABLE transactions DROP COLUMN IF EXISTS reference;
@@ -0,0 +1,21 @@
+-- The external payment behind a ledger entry, such as the Stripe charge of a card top-up.
+-- The partitions created later copy the column from the parent table.
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reference TEXT NOT NULL DEFAULT '';
+
+-- The card payments funding the accounts through Stripe. The payment intent is created
+-- once the top-up has its ID; a charge credits one account once.
+CREATE TABLE IF NOT EXISTS card_top_ups (
+	id SERIAL PRIMARY KEY,
+	account_id INT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+	amount BIGINT NOT NULL CONSTRAINT card_top_ups_amount_positive CHECK (amount > 0),
+	currency TEXT NOT NULL,
+	status TEXT NOT NULL,
+	payment_intent_id TEXT CONSTRAINT card_top_ups_payment_intent_id_key UNIQUE,
+	charge_id TEXT CONSTRAINT card_top_ups_charge_id_key UNIQUE,
+	transaction_id INT,
+	failure_reason TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS card_top_ups_account_id_idx ON card_top_ups (account_id, id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS consents (
+	id SERIAL PRIMARY KEY,
+	account_id INT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+	client_name TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS consents_account_id_idx ON consents (account_id);
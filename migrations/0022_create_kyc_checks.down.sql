@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS kyc_checks;
+ALTER TABLE accounts DROP COLUMN IF EXISTS kyc_status;
@@ -0,0 +1,21 @@
+-- The identity verification of the account holders, driven by the KYC checks below; the
+-- accounts opened before it, or without a provider, were never checked.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS kyc_status TEXT NOT NULL DEFAULT 'not_started';
+
+-- The document checks of the account holders at the KYC provider. A check is stored with
+-- its account and submitted to the provider afterwards, so a provider down at account
+-- creation only delays it; the open checks are polled until they reach a result.
+CREATE TABLE IF NOT EXISTS kyc_checks (
+	id SERIAL PRIMARY KEY,
+	account_id INT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+	provider TEXT NOT NULL,
+	provider_check_id TEXT CONSTRAINT kyc_checks_provider_check_id_key UNIQUE,
+	status TEXT NOT NULL,
+	url TEXT NOT NULL DEFAULT '',
+	failure_reason TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS kyc_checks_account_id_idx ON kyc_checks (account_id, id);
+CREATE INDEX IF NOT EXISTS kyc_checks_open_idx ON kyc_checks (updated_at) WHERE status IN ('pending', 'review');
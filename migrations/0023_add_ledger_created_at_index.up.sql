@@ -0,0 +1,2 @@
+-- The Journal Export Of The Whole Ledger For A Period, Read In (created_at, id) Order
+CREATE INDEX IF NOT EXISTS transactions_created_at_idx ON transactions (created_at, id);
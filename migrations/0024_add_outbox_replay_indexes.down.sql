@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS outbox_created_at_idx;
+DROP INDEX IF EXISTS outbox_account_idx;
@@ -0,0 +1,3 @@
+-- The Replays Of The Published Events Of An Account, Or Of A Period, Read In id Order
+CREATE INDEX IF NOT EXISTS outbox_account_idx ON outbox (account_id, id);
+CREATE INDEX IF NOT EXISTS outbox_created_at_idx ON outbox (created_at);
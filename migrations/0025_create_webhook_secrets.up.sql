@@ -0,0 +1,14 @@
+-- The signing secrets of the inbound webhooks, by provider, encrypted with the webhook
+-- encryption key. Several secrets of a provider verify its webhooks at once, so that a
+-- secret can be rotated: the new one is added, and the old one expires once the provider
+-- signs with the new one.
+CREATE TABLE IF NOT EXISTS webhook_secrets (
+	id SERIAL PRIMARY KEY,
+	provider TEXT NOT NULL,
+	sealed BYTEA NOT NULL,
+	fingerprint TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_secrets_provider_idx ON webhook_secrets (provider, id);
@@ -0,0 +1,3 @@
+ALTER TABLE accounts DROP COLUMN IF EXISTS dormant_at;
+DROP TABLE IF EXISTS job_runs;
+DROP TABLE IF EXISTS scheduled_jobs;
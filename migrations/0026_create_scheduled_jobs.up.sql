@@ -0,0 +1,33 @@
+-- The jobs of the scheduler, see scheduler.go: their cron schedule, the next time they
+-- are due, and the lease of the instance running them. An instance takes the lease by
+-- setting locked_by and locked_until, so that a job runs on one instance at a time and
+-- is picked up again once the lease of a crashed instance has run out.
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+	name TEXT PRIMARY KEY,
+	schedule TEXT NOT NULL,
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	next_run_at TIMESTAMP NOT NULL,
+	last_run_at TIMESTAMP,
+	locked_by TEXT NOT NULL DEFAULT '',
+	locked_until TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The run history of the scheduled jobs.
+CREATE TABLE IF NOT EXISTS job_runs (
+	id SERIAL PRIMARY KEY,
+	job TEXT NOT NULL,
+	trigger TEXT NOT NULL,
+	status TEXT NOT NULL,
+	instance TEXT NOT NULL,
+	summary TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS job_runs_job_idx ON job_runs (job, id);
+
+-- The time the dormancy check found an account without activity, cleared by the check
+-- once the account has new transactions.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS dormant_at TIMESTAMP;
@@ -0,0 +1,7 @@
+-- The ISO 4217 currency of the amounts: an account holds its balance and its transfer
+-- limit in the currency it was opened in, and its entries and transfers are in that
+-- currency too. The rows written before it are in USD, the currency the top-ups and
+-- the ISO 20022 messages defaulted to.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
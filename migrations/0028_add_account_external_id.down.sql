@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS accounts_external_id_key;
+ALTER TABLE accounts DROP COLUMN IF EXISTS external_id;
@@ -0,0 +1,5 @@
+-- The reference of the customer in the systems of the client, so that a create retried
+-- after a lost response finds the account it opened. NULL for the accounts opened
+-- without one, which the unique index leaves out.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS external_id TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_external_id_key ON accounts (external_id);
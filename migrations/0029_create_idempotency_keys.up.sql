@@ -0,0 +1,15 @@
+-- The requests sent with an Idempotency-Key and their responses, see idempotency.go.
+-- A request holds its key, with status 0, until locked_until; once answered, its
+-- response is replayed until expires_at. The key is a hash, so no token is stored.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key TEXT PRIMARY KEY,
+	request_hash TEXT NOT NULL,
+	status INTEGER NOT NULL DEFAULT 0,
+	header JSONB NOT NULL DEFAULT '{}',
+	body BYTEA NOT NULL DEFAULT '',
+	locked_until TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
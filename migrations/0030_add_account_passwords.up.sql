@@ -0,0 +1,3 @@
+-- The bcrypt hash of the password the holder of an account logs in with, see
+-- handleLogin. Empty for the accounts opened before, or imported, which cannot log in.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS encrypted_password TEXT NOT NULL DEFAULT '';
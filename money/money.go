@@ -0,0 +1,462 @@
+// Package money holds amounts of money as whole minor units of a currency, such as
+// cents, together with that currency, so balances are never held in floats nor added
+// across currencies.
+//
+// The arithmetic is checked: a result out of the range of the amounts is ErrOverflow
+// rather than a wrapped number, and every operation that can end between two minor
+// units, such as applying a rate, takes the RoundingMode to settle on one.
+//
+// A Money is encoded as its bare number of minor units in JSON, MessagePack, and the
+// database, next to a currency stored once for the account, transaction, or transfer
+// it belongs to, so the encoded amounts read as they did before the type existed.
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ErrCurrencyMismatch is returned when two amounts of different currencies are combined.
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// ErrNotMinorUnits is returned when decoding an amount that is not a whole number of
+// minor units, such as 12.5 or "12".
+var ErrNotMinorUnits = errors.New("amount must be a whole number of minor units")
+
+// ErrOverflow is returned when the result of an operation is out of the range of the
+// amounts, from -MaxAmount to MaxAmount.
+var ErrOverflow = errors.New("amount out of range")
+
+// ErrInvalidRatio is returned when an amount is allocated to no ratio, to a negative
+// one, or to ratios that sum to zero.
+var ErrInvalidRatio = errors.New("invalid allocation ratio")
+
+// MaxAmount is the largest amount, in minor units. The range is symmetric, the smallest
+// amount being -MaxAmount, so the negation of an amount always exists.
+const MaxAmount = math.MaxInt64
+
+// RoundingMode tells how a result that falls between two minor units is rounded to one.
+type RoundingMode int
+
+// Rounding Modes
+const (
+	// RoundDown drops the fraction of a minor unit, rounding toward zero, such as for
+	// interest, so no fraction is paid that was not accrued.
+	RoundDown RoundingMode = iota
+	// RoundHalfUp rounds to the nearest minor unit, the halves away from zero.
+	RoundHalfUp
+	// RoundHalfEven rounds to the nearest minor unit, the halves to the even one, so the
+	// sum of many rounded amounts does not drift in either direction.
+	RoundHalfEven
+)
+
+// Money is an amount in the minor units of a currency.
+type Money struct {
+	// Amount is in the smallest unit of Currency, such as cents; negative for debits.
+	Amount int64
+	// Currency is the uppercase ISO 4217 code, such as USD.
+	Currency string
+}
+
+// New returns amount minor units of currency.
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Zero returns no money in currency.
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+// IsZero reports whether m is no money, whatever its currency.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsPositive reports whether m is more than zero.
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// IsNegative reports whether m is less than zero.
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Neg returns m with the opposite sign, such as the debit of a credit. Every amount in
+// range has one.
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Abs returns m without its sign.
+func (m Money) Abs() Money {
+	if m.Amount < 0 {
+		return m.Neg()
+	}
+	return m
+}
+
+// SameCurrency reports whether m and o are in the same currency.
+func (m Money) SameCurrency(o Money) bool {
+	return m.Currency == o.Currency
+}
+
+// Add returns m plus o.
+//
+// Parameters:
+//   - o: The amount to add, in the currency of m.
+//
+// Returns:
+//   - Money: The sum, in the currency of m.
+//   - error: ErrCurrencyMismatch if o is in another currency, ErrOverflow if the sum is
+//     out of range, otherwise nil.
+func (m Money) Add(o Money) (Money, error) {
+	if !m.SameCurrency(o) {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	sum, ok := addMinorUnits(m.Amount, o.Amount)
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %s plus %s", ErrOverflow, m, o)
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// Sub returns m minus o.
+//
+// Parameters:
+//   - o: The amount to subtract, in the currency of m.
+//
+// Returns:
+//   - Money: The difference, in the currency of m.
+//   - error: ErrCurrencyMismatch if o is in another currency, ErrOverflow if the
+//     difference is out of range, otherwise nil.
+func (m Money) Sub(o Money) (Money, error) {
+	if !m.SameCurrency(o) {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	// The Negation Of math.MinInt64 Is Itself, Which addMinorUnits Refuses
+	difference, ok := addMinorUnits(m.Amount, -o.Amount)
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %s minus %s", ErrOverflow, m, o)
+	}
+	return Money{Amount: difference, Currency: m.Currency}, nil
+}
+
+// Mul returns m times a whole factor, such as the total of a fee charged n times.
+//
+// Parameters:
+//   - factor: The number to multiply the amount by.
+//
+// Returns:
+//   - Money: The product, in the currency of m.
+//   - error: ErrOverflow if the product is out of range, otherwise nil.
+func (m Money) Mul(factor int64) (Money, error) {
+	if !inRange(m.Amount) || !inRange(factor) {
+		return Money{}, fmt.Errorf("%w: %s times %d", ErrOverflow, m, factor)
+	}
+	hi, lo := bits.Mul64(absMinorUnits(m.Amount), absMinorUnits(factor))
+	if hi != 0 || lo > MaxAmount {
+		return Money{}, fmt.Errorf("%w: %s times %d", ErrOverflow, m, factor)
+	}
+	product := int64(lo)
+	if (m.Amount < 0) != (factor < 0) {
+		product = -product
+	}
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+// MulRat returns m times an exact rational rate, such as an interest rate or an
+// exchange rate, rounded to a minor unit by mode. The product is computed exactly, so
+// the only loss of precision is the rounding.
+//
+// Parameters:
+//   - rate: The rate to multiply the amount by.
+//   - mode: How a product between two minor units is rounded.
+//
+// Returns:
+//   - Money: The rounded product, in the currency of m.
+//   - error: ErrOverflow if the product is out of range, otherwise nil.
+func (m Money) MulRat(rate *big.Rat, mode RoundingMode) (Money, error) {
+	if !inRange(m.Amount) {
+		return Money{}, fmt.Errorf("%w: %s times %s", ErrOverflow, m, rate.RatString())
+	}
+	product := new(big.Int).Mul(big.NewInt(m.Amount), rate.Num())
+	product = roundQuo(product, rate.Denom(), mode)
+	if !product.IsInt64() || !inRange(product.Int64()) {
+		return Money{}, fmt.Errorf("%w: %s times %s", ErrOverflow, m, rate.RatString())
+	}
+	return Money{Amount: product.Int64(), Currency: m.Currency}, nil
+}
+
+// Convert returns m in another currency at an exact rate, the units of to a unit of the
+// currency of m is worth, rounded to a minor unit of to by mode. The rate is of the
+// major units, so the exponents of both currencies are accounted for: 100 JPY at 0.0067
+// is 67 cents.
+//
+// Parameters:
+//   - to: The currency to convert to.
+//   - rate: The exchange rate from the currency of m to to.
+//   - mode: How a result between two minor units of to is rounded.
+//
+// Returns:
+//   - Money: The converted amount, in to.
+//   - error: ErrOverflow if the converted amount is out of range, otherwise nil.
+func (m Money) Convert(to string, rate *big.Rat, mode RoundingMode) (Money, error) {
+	shift := Exponent(to) - Exponent(m.Currency)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift < 0 {
+		scale.Inv(scale)
+	}
+	converted, err := m.MulRat(new(big.Rat).Mul(rate, scale), mode)
+	if err != nil {
+		return Money{}, err
+	}
+	converted.Currency = to
+	return converted, nil
+}
+
+// Allocate splits m in parts proportional to ratios, in whole minor units, so the parts
+// always sum to m. The minor units lost to rounding down the shares go one each to the
+// first parts of a ratio above zero, so splitting 100 cents in three gives 34, 33, 33.
+//
+// Parameters:
+//   - ratios: The weights of the parts, none negative and not all zero.
+//
+// Returns:
+//   - []Money: The parts, in the order of ratios and in the currency of m.
+//   - error: ErrInvalidRatio if the ratios cannot split an amount, ErrOverflow if m or
+//     the sum of the ratios is out of range, otherwise nil.
+func (m Money) Allocate(ratios ...int64) ([]Money, error) {
+	if !inRange(m.Amount) {
+		return nil, fmt.Errorf("%w: %s", ErrOverflow, m)
+	}
+	var total int64
+	for _, ratio := range ratios {
+		if ratio < 0 {
+			return nil, fmt.Errorf("%w: %d is negative", ErrInvalidRatio, ratio)
+		}
+		var ok bool
+		if total, ok = addMinorUnits(total, ratio); !ok {
+			return nil, fmt.Errorf("%w: the ratios sum past %d", ErrOverflow, int64(MaxAmount))
+		}
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%w: the ratios sum to zero", ErrInvalidRatio)
+	}
+
+	// Each Share Is Rounded Toward Zero, So Its Magnitude Never Exceeds m
+	parts := make([]Money, len(ratios))
+	remainder := m.Amount
+	for i, ratio := range ratios {
+		share := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(ratio))
+		share.Quo(share, big.NewInt(total))
+		parts[i] = Money{Amount: share.Int64(), Currency: m.Currency}
+		remainder -= share.Int64()
+	}
+
+	// Fewer Units Are Left Than Parts Of A Ratio Above Zero
+	unit := int64(1)
+	if remainder < 0 {
+		unit = -1
+	}
+	for i := 0; remainder != 0; i++ {
+		if ratios[i] == 0 {
+			continue
+		}
+		parts[i].Amount += unit
+		remainder -= unit
+	}
+	return parts, nil
+}
+
+// Cmp compares m and o, returning -1 if m is less than o, 0 if they are equal, and +1
+// if m is more.
+//
+// Parameters:
+//   - o: The amount to compare with, in the currency of m.
+//
+// Returns:
+//   - int: The comparison of the amounts.
+//   - error: ErrCurrencyMismatch if o is in another currency, otherwise nil.
+func (m Money) Cmp(o Money) (int, error) {
+	if !m.SameCurrency(o) {
+		return 0, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// inRange reports whether an amount is within -MaxAmount and MaxAmount.
+func inRange(amount int64) bool {
+	return amount != math.MinInt64
+}
+
+// abs returns the magnitude of n.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// absMinorUnits returns the magnitude of an amount in range.
+func absMinorUnits(amount int64) uint64 {
+	if amount < 0 {
+		return uint64(-amount)
+	}
+	return uint64(amount)
+}
+
+// addMinorUnits returns a plus b, and false instead if either or the sum is out of range.
+func addMinorUnits(a, b int64) (int64, bool) {
+	if !inRange(a) || !inRange(b) {
+		return 0, false
+	}
+	if (b > 0 && a > MaxAmount-b) || (b < 0 && a < -MaxAmount-b) {
+		return 0, false
+	}
+	return a + b, true
+}
+
+// roundQuo returns num divided by the positive den, rounded to an integer by mode.
+func roundQuo(num, den *big.Int, mode RoundingMode) *big.Int {
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() == 0 {
+		return quo
+	}
+
+	// Compare The Remainder With Half The Divisor, Both As Magnitudes
+	half := new(big.Int).Lsh(new(big.Int).Abs(rem), 1).Cmp(den)
+	var away bool
+	switch mode {
+	case RoundHalfUp:
+		away = half >= 0
+	case RoundHalfEven:
+		away = half > 0 || (half == 0 && quo.Bit(0) == 1)
+	}
+	if away {
+		// The Quotient Was Truncated Toward Zero
+		if num.Sign() < 0 {
+			return quo.Sub(quo, big.NewInt(1))
+		}
+		return quo.Add(quo, big.NewInt(1))
+	}
+	return quo
+}
+
+// Decimal formats the amount as a decimal number of major units with the decimals of
+// its currency, such as 123.45 for 12345 cents of USD or 12345 for 12345 JPY, without
+// the currency.
+func (m Money) Decimal() string {
+	return FormatMinorUnits(m.Amount, Exponent(m.Currency))
+}
+
+// Rat returns the exact amount in major units, such as 123.45 for 12345 cents of USD,
+// for sums across currencies of different exponents.
+func (m Money) Rat() *big.Rat {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(Exponent(m.Currency))), nil)
+	return new(big.Rat).SetFrac(big.NewInt(m.Amount), unit)
+}
+
+// String formats m as a decimal number of major units followed by its currency, such
+// as 123.45 USD.
+func (m Money) String() string {
+	if m.Currency == "" {
+		return m.Decimal()
+	}
+	return m.Decimal() + " " + m.Currency
+}
+
+// FormatMinorUnits formats an amount in minor units as a decimal with digits decimals,
+// such as 12345 as 123.45.
+func FormatMinorUnits(amount int64, digits int) string {
+	sign := ""
+	magnitude := strconv.FormatUint(uint64(amount), 10)
+	if amount < 0 {
+		sign, magnitude = "-", strconv.FormatUint(-uint64(amount), 10)
+	}
+	if digits <= 0 {
+		return sign + magnitude
+	}
+	if len(magnitude) <= digits {
+		magnitude = strings.Repeat("0", digits-len(magnitude)+1) + magnitude
+	}
+	return sign + magnitude[:len(magnitude)-digits] + "." + magnitude[len(magnitude)-digits:]
+}
+
+// MarshalJSON encodes m as its number of minor units; the currency is encoded once next
+// to the amounts of a resource.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, m.Amount, 10), nil
+}
+
+// UnmarshalJSON decodes a whole number of minor units into the amount of m, keeping its
+// currency. Fractions, exponents, and strings are rejected, so an amount in major units
+// such as 12.50 is never rounded into cents, and so are the amounts out of range.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	amount, err := strconv.ParseInt(string(data), 10, 64)
+	if errors.Is(err, strconv.ErrRange) || (err == nil && !inRange(amount)) {
+		return ErrOverflow
+	}
+	if err != nil {
+		return ErrNotMinorUnits
+	}
+	m.Amount = amount
+	return nil
+}
+
+// EncodeMsgpack encodes m as its number of minor units, as MarshalJSON does.
+func (m Money) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return enc.EncodeInt(m.Amount)
+}
+
+// DecodeMsgpack decodes a number of minor units into the amount of m, keeping its
+// currency.
+func (m *Money) DecodeMsgpack(dec *msgpack.Decoder) error {
+	amount, err := dec.DecodeInt64()
+	if err != nil {
+		return err
+	}
+	m.Amount = amount
+	return nil
+}
+
+// Value stores m as its number of minor units, in a BIGINT column.
+func (m Money) Value() (driver.Value, error) {
+	return m.Amount, nil
+}
+
+// Scan reads a number of minor units into the amount of m, keeping its currency.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		m.Amount = v
+	case []byte:
+		amount, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return fmt.Errorf("money: cannot scan %q: %w", v, err)
+		}
+		m.Amount = amount
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	return nil
+}
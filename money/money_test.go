@@ -0,0 +1,258 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b int64
+		want int64
+		err  error
+	}{
+		{"small", 150, 250, 400, nil},
+		{"negative", -150, 50, -100, nil},
+		{"up to the maximum", MaxAmount - 1, 1, MaxAmount, nil},
+		{"past the maximum", MaxAmount, 1, 0, ErrOverflow},
+		{"down to the minimum", -MaxAmount + 1, -1, -MaxAmount, nil},
+		{"past the minimum", -MaxAmount, -1, 0, ErrOverflow},
+		{"out of range operand", math.MinInt64, 0, 0, ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.a, "USD").Add(New(tt.b, "USD"))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Add(%d, %d) error = %v, want %v", tt.a, tt.b, err, tt.err)
+			}
+			if err == nil && got != New(tt.want, "USD") {
+				t.Errorf("Add(%d, %d) = %v, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSub(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b int64
+		want int64
+		err  error
+	}{
+		{"small", 400, 150, 250, nil},
+		{"below zero", 100, 150, -50, nil},
+		{"down to the minimum", -MaxAmount + 1, 1, -MaxAmount, nil},
+		{"past the minimum", -MaxAmount, 1, 0, ErrOverflow},
+		{"past the maximum", MaxAmount, -1, 0, ErrOverflow},
+		{"minus the minimum", 0, -MaxAmount, MaxAmount, nil},
+		{"minus out of range", 0, math.MinInt64, 0, ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.a, "USD").Sub(New(tt.b, "USD"))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Sub(%d, %d) error = %v, want %v", tt.a, tt.b, err, tt.err)
+			}
+			if err == nil && got != New(tt.want, "USD") {
+				t.Errorf("Sub(%d, %d) = %v, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddSubCurrencyMismatch(t *testing.T) {
+	if _, err := New(1, "USD").Add(New(1, "EUR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add across currencies error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	if _, err := New(1, "USD").Sub(New(1, "EUR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Sub across currencies error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+}
+
+func TestMul(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int64
+		factor int64
+		want   int64
+		err    error
+	}{
+		{"small", 250, 4, 1000, nil},
+		{"negative factor", 250, -4, -1000, nil},
+		{"both negative", -250, -4, 1000, nil},
+		{"by zero", MaxAmount, 0, 0, nil},
+		{"up to the maximum", MaxAmount, 1, MaxAmount, nil},
+		{"up to the minimum", MaxAmount, -1, -MaxAmount, nil},
+		{"past the maximum", MaxAmount/2 + 1, 2, 0, ErrOverflow},
+		{"past the minimum", -(MaxAmount/2 + 1), 2, 0, ErrOverflow},
+		{"past 64 bits", MaxAmount, MaxAmount, 0, ErrOverflow},
+		{"out of range amount", math.MinInt64, 1, 0, ErrOverflow},
+		{"out of range factor", 1, math.MinInt64, 0, ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.amount, "USD").Mul(tt.factor)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Mul(%d, %d) error = %v, want %v", tt.amount, tt.factor, err, tt.err)
+			}
+			if err == nil && got != New(tt.want, "USD") {
+				t.Errorf("Mul(%d, %d) = %v, want %d", tt.amount, tt.factor, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMulRat(t *testing.T) {
+	// MaxAmount Times This Rate Is Half A Minor Unit Past MaxAmount
+	halfAboveOne := new(big.Rat).Add(big.NewRat(1, 1), new(big.Rat).SetFrac(big.NewInt(1), new(big.Int).Lsh(big.NewInt(MaxAmount), 1)))
+
+	tests := []struct {
+		name   string
+		amount int64
+		rate   *big.Rat
+		mode   RoundingMode
+		want   int64
+		err    error
+	}{
+		{"exact", 1000, big.NewRat(1, 4), RoundDown, 250, nil},
+		{"rounded down", 1000, big.NewRat(1, 3), RoundDown, 333, nil},
+		{"rounded half up", 1001, big.NewRat(1, 2), RoundHalfUp, 501, nil},
+		{"rounded half even", 1001, big.NewRat(1, 2), RoundHalfEven, 500, nil},
+		{"negative rounded down", -1000, big.NewRat(1, 3), RoundDown, -333, nil},
+		{"up to the maximum", MaxAmount, big.NewRat(1, 1), RoundDown, MaxAmount, nil},
+		{"past the maximum", MaxAmount, big.NewRat(3, 2), RoundDown, 0, ErrOverflow},
+		{"past the maximum by rounding", MaxAmount, halfAboveOne, RoundHalfUp, 0, ErrOverflow},
+		{"past the minimum", -MaxAmount, big.NewRat(3, 2), RoundDown, 0, ErrOverflow},
+		{"out of range amount", math.MinInt64, big.NewRat(1, 2), RoundDown, 0, ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.amount, "USD").MulRat(tt.rate, tt.mode)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("MulRat(%d, %s) error = %v, want %v", tt.amount, tt.rate, err, tt.err)
+			}
+			if err == nil && got != New(tt.want, "USD") {
+				t.Errorf("MulRat(%d, %s) = %v, want %d", tt.amount, tt.rate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundQuo(t *testing.T) {
+	tests := []struct {
+		num, den int64
+		down     int64
+		halfUp   int64
+		halfEven int64
+	}{
+		{10, 5, 2, 2, 2},
+		{11, 4, 2, 3, 3},
+		{9, 4, 2, 2, 2},
+		{5, 2, 2, 3, 2},
+		{7, 2, 3, 4, 4},
+		{-5, 2, -2, -3, -2},
+		{-7, 2, -3, -4, -4},
+		{-11, 4, -2, -3, -3},
+		{-9, 4, -2, -2, -2},
+		{1, 3, 0, 0, 0},
+		{2, 3, 0, 1, 1},
+	}
+
+	for _, tt := range tests {
+		for mode, want := range map[RoundingMode]int64{RoundDown: tt.down, RoundHalfUp: tt.halfUp, RoundHalfEven: tt.halfEven} {
+			if got := roundQuo(big.NewInt(tt.num), big.NewInt(tt.den), mode); got.Int64() != want {
+				t.Errorf("roundQuo(%d, %d, %d) = %s, want %d", tt.num, tt.den, mode, got, want)
+			}
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int64
+		ratios []int64
+		want   []int64
+		err    error
+	}{
+		{"even", 100, []int64{1, 1}, []int64{50, 50}, nil},
+		{"remainder to the first parts", 100, []int64{1, 1, 1}, []int64{34, 33, 33}, nil},
+		{"two units left", 101, []int64{1, 1, 1}, []int64{34, 34, 33}, nil},
+		{"weighted", 100, []int64{70, 20, 10}, []int64{70, 20, 10}, nil},
+		{"weighted with remainder", 5, []int64{3, 7}, []int64{2, 3}, nil},
+		{"zero ratio skipped", 100, []int64{0, 1, 1, 1}, []int64{0, 34, 33, 33}, nil},
+		{"negative amount", -100, []int64{1, 1, 1}, []int64{-34, -33, -33}, nil},
+		{"maximum amount", MaxAmount, []int64{1, 1}, []int64{MaxAmount/2 + 1, MaxAmount / 2}, nil},
+		{"no ratio", 100, nil, nil, ErrInvalidRatio},
+		{"zero ratios", 100, []int64{0, 0}, nil, ErrInvalidRatio},
+		{"negative ratio", 100, []int64{2, -1}, nil, ErrInvalidRatio},
+		{"ratios past the maximum", 100, []int64{MaxAmount, 1}, nil, ErrOverflow},
+		{"out of range amount", math.MinInt64, []int64{1}, nil, ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := New(tt.amount, "USD").Allocate(tt.ratios...)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Allocate(%d, %v) error = %v, want %v", tt.amount, tt.ratios, err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if len(parts) != len(tt.want) {
+				t.Fatalf("Allocate(%d, %v) = %v, want %v", tt.amount, tt.ratios, parts, tt.want)
+			}
+			var sum int64
+			for i, part := range parts {
+				if part != New(tt.want[i], "USD") {
+					t.Errorf("Allocate(%d, %v)[%d] = %v, want %d", tt.amount, tt.ratios, i, part, tt.want[i])
+				}
+				sum += part.Amount
+			}
+			if sum != tt.amount {
+				t.Errorf("Allocate(%d, %v) sums to %d", tt.amount, tt.ratios, sum)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount Money
+		to     string
+		rate   *big.Rat
+		mode   RoundingMode
+		want   Money
+		err    error
+	}{
+		{"same exponent", New(10000, "USD"), "EUR", big.NewRat(92, 100), RoundHalfUp, New(9200, "EUR"), nil},
+		{"to fewer decimals", New(10000, "USD"), "JPY", big.NewRat(15025, 100), RoundHalfUp, New(15025, "JPY"), nil},
+		{"from fewer decimals", New(100, "JPY"), "USD", big.NewRat(67, 10000), RoundHalfUp, New(67, "USD"), nil},
+		{"to more decimals", New(100, "USD"), "KWD", big.NewRat(307, 1000), RoundHalfUp, New(307, "KWD"), nil},
+		{"from more decimals", New(1000, "KWD"), "USD", big.NewRat(3255, 1000), RoundHalfUp, New(326, "USD"), nil},
+		{"from more decimals rounded down", New(1000, "KWD"), "USD", big.NewRat(3255, 1000), RoundDown, New(325, "USD"), nil},
+		{"from more decimals half even", New(1000, "KWD"), "USD", big.NewRat(3255, 1000), RoundHalfEven, New(326, "USD"), nil},
+		{"four decimals", New(10000, "CLF"), "CLP", big.NewRat(38000, 1), RoundHalfUp, New(38000, "CLP"), nil},
+		{"past the maximum", New(MaxAmount, "JPY"), "USD", big.NewRat(1, 1), RoundDown, Money{}, ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.amount.Convert(tt.to, tt.rate, tt.mode)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Convert(%v, %s, %s) error = %v, want %v", tt.amount, tt.to, tt.rate, err, tt.err)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Convert(%v, %s, %s) = %v, want %v", tt.amount, tt.to, tt.rate, got, tt.want)
+			}
+		})
+	}
+}
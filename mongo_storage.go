@@ -0,0 +1,1909 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/moabdelazem/gobank/money"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+)
+
+// Storage Backends, Selected With STORAGE_BACKEND
+const (
+	storageBackendPostgres = "postgres"
+	storageBackendMongo    = "mongo"
+)
+
+// Default MongoDB Settings
+const (
+	defaultMongoURL      = "mongodb://localhost:27017/?directConnection=true"
+	defaultMongoDatabase = "gobank"
+)
+
+// mongoRecentTransactions is how many of the newest ledger entries are embedded in
+// every account document, so the first page of the history needs no second query.
+const mongoRecentTransactions = 50
+
+// MongoDB Collections
+const (
+	mongoAccounts     = "accounts"
+	mongoTransactions = "transactions"
+	mongoTransfers    = "transfers"
+	mongoAuditLog     = "audit_log"
+	mongoFeatureFlags = "feature_flags"
+	mongoMaintenance  = "maintenance"
+	mongoOutbox       = "outbox"
+	mongoCounters     = "counters"
+
+	mongoNotificationPreferences = "notification_preferences"
+	mongoPushDevices             = "push_devices"
+	mongoGatewayEvents           = "gateway_events"
+	mongoCardTopUps              = "card_top_ups"
+	mongoConsents                = "consents"
+	mongoKYCChecks               = "kyc_checks"
+	mongoWebhookSecrets          = "webhook_secrets"
+	mongoScheduledJobs           = "scheduled_jobs"
+	mongoJobRuns                 = "job_runs"
+	mongoIdempotencyKeys         = "idempotency_keys"
+)
+
+// mongoAccount is the document stored for an account: the account itself and its
+// newest ledger entries, newest first. The documents of all collections use the
+// default BSON keys of the domain types, which are the lowercased field names
+// ("accountid", "createdat", ...), and keep the integer IDs of the API in "id".
+type mongoAccount struct {
+	Account            `bson:",inline"`
+	RecentTransactions []Transaction `bson:"recent_transactions"`
+}
+
+// MongoStorage is a MongoDB implementation of the Storage interface, for deployments
+// without a relational database. Account documents embed their recent transactions,
+// and the full ledger is kept in its own collection for the history queries.
+// WithTx runs on a multi-document transaction, so the server must be a replica set.
+type MongoStorage struct {
+	client *mongo.Client
+	db     *mongo.Database
+	// sess is the session of the running transaction, nil outside WithTx.
+	sess *mongo.Session
+}
+
+// NewMongoStorage connects to the MongoDB server at uri, checks that it answers, and
+// creates the indexes of the collections in the given database.
+//
+// Parameters:
+//   - ctx: The context of the connection checks and the index creation.
+//   - uri: The connection string of the server, such as MONGO_URL.
+//   - database: The name of the database holding the collections.
+//
+// Returns:
+//   - *MongoStorage: The storage.
+//   - error: An error if the server cannot be reached or the indexes cannot be created.
+func NewMongoStorage(ctx context.Context, uri, database string) (*MongoStorage, error) {
+	client, err := mongo.Connect(options.Client().ApplyURI(uri).SetRegistry(mongoRegistry()))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &MongoStorage{client: client, db: client.Database(database)}
+	if err := s.Ping(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	if err := s.ensureIndexes(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	if err := s.ensureValidators(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	if err := s.backfillCurrencies(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// mongoRegistry returns the BSON registry of the documents: the default one, with the
+// amounts of money stored as their int64 number of minor units, so that $inc and the
+// amount filters apply to them, while their currency is stored once per document.
+func mongoRegistry() *bson.Registry {
+	registry := bson.NewRegistry()
+	moneyType := reflect.TypeOf(money.Money{})
+
+	registry.RegisterTypeEncoder(moneyType, bson.ValueEncoderFunc(func(_ bson.EncodeContext, vw bson.ValueWriter, v reflect.Value) error {
+		return vw.WriteInt64(v.Interface().(money.Money).Amount)
+	}))
+	registry.RegisterTypeDecoder(moneyType, bson.ValueDecoderFunc(func(_ bson.DecodeContext, vr bson.ValueReader, v reflect.Value) error {
+		var amount int64
+		switch vr.Type() {
+		case bson.TypeInt64:
+			n, err := vr.ReadInt64()
+			if err != nil {
+				return err
+			}
+			amount = n
+		case bson.TypeInt32:
+			n, err := vr.ReadInt32()
+			if err != nil {
+				return err
+			}
+			amount = int64(n)
+		default:
+			return fmt.Errorf("cannot decode a BSON %s into an amount of minor units", vr.Type())
+		}
+		// The Currency Is Set From The Document Once It Is Decoded
+		v.FieldByName("Amount").SetInt(amount)
+		return nil
+	}))
+
+	return registry
+}
+
+// mongoDocumentValidationFailure is the code of the server error of a write refused by
+// the validator of its collection.
+const mongoDocumentValidationFailure = 121
+
+// mongoNamespaceExists is the code of the server error of creating a collection that exists.
+const mongoNamespaceExists = 48
+
+// accountValidator refuses the account documents with a negative balance, as the
+// accounts_balance_non_negative constraint does on Postgres.
+var accountValidator = bson.D{{Key: "balance", Value: bson.D{{Key: "$gte", Value: 0}}}}
+
+// ensureValidators sets the validators of the collections, creating the collections that
+// do not exist yet with them.
+func (s *MongoStorage) ensureValidators(ctx context.Context) error {
+	err := s.db.CreateCollection(ctx, mongoAccounts, options.CreateCollection().SetValidator(accountValidator))
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(mongoNamespaceExists) {
+		err = s.db.RunCommand(ctx, bson.D{{Key: "collMod", Value: mongoAccounts}, {Key: "validator", Value: accountValidator}}).Err()
+	}
+	return err
+}
+
+// balanceError translates the writes the account validator refused into
+// ErrInsufficientFunds, and returns every other error unchanged.
+func balanceError(err error, accountID int) error {
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(mongoDocumentValidationFailure) {
+		return fmt.Errorf("%w on account %d", ErrInsufficientFunds, accountID)
+	}
+	return err
+}
+
+// backfillCurrencies sets the currency of the accounts, transactions, and transfers
+// stored before they had one to USD, as migration 0027 does on Postgres.
+func (s *MongoStorage) backfillCurrencies(ctx context.Context) error {
+	for _, c := range []string{mongoAccounts, mongoTransactions, mongoTransfers} {
+		_, err := s.collection(c).UpdateMany(ctx, bson.D{{Key: "currency", Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "currency", Value: "USD"}}}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureIndexes creates the indexes of the collections unless they exist. The unique
+// indexes keep the IDs, the account numbers, and the external IDs, deleted accounts
+// included, unique.
+func (s *MongoStorage) ensureIndexes(ctx context.Context) error {
+	unique := options.Index().SetUnique(true)
+	indexes := map[string][]mongo.IndexModel{
+		mongoAccounts: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "number", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "lastname", Value: 1}}},
+			// Only The Accounts Created Under A Client Reference Have An External ID
+			{Keys: bson.D{{Key: "externalid", Value: 1}}, Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "externalid", Value: bson.D{{Key: "$gt", Value: ""}}}})},
+		},
+		mongoTransactions: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "accountid", Value: 1}, {Key: "createdat", Value: -1}, {Key: "id", Value: -1}}},
+			{Keys: bson.D{{Key: "createdat", Value: 1}, {Key: "id", Value: 1}}},
+		},
+		mongoTransfers: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "id", Value: 1}}},
+			// Only The Transfers Settled By The Gateway Have A Reference
+			{Keys: bson.D{{Key: "providerreference", Value: 1}}, Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "providerreference", Value: bson.D{{Key: "$gt", Value: ""}}}})},
+		},
+		mongoAuditLog: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+		},
+		mongoFeatureFlags: {
+			{Keys: bson.D{{Key: "name", Value: 1}}, Options: unique},
+		},
+		mongoNotificationPreferences: {
+			{Keys: bson.D{{Key: "accountid", Value: 1}}, Options: unique},
+		},
+		mongoPushDevices: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "token", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "accountid", Value: 1}, {Key: "id", Value: 1}}},
+		},
+		mongoGatewayEvents: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+		},
+		mongoCardTopUps: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "accountid", Value: 1}, {Key: "id", Value: 1}}},
+			// The Payment Intent Is Set Once Created, The Charge Once Paid
+			{Keys: bson.D{{Key: "paymentintentid", Value: 1}}, Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "paymentintentid", Value: bson.D{{Key: "$gt", Value: ""}}}})},
+			{Keys: bson.D{{Key: "chargeid", Value: 1}}, Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "chargeid", Value: bson.D{{Key: "$gt", Value: ""}}}})},
+		},
+		mongoConsents: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "accountid", Value: 1}, {Key: "id", Value: 1}}},
+		},
+		mongoKYCChecks: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "accountid", Value: 1}, {Key: "id", Value: 1}}},
+			// The Provider ID Is Set Once Submitted
+			{Keys: bson.D{{Key: "providercheckid", Value: 1}}, Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "providercheckid", Value: bson.D{{Key: "$gt", Value: ""}}}})},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updatedat", Value: 1}}},
+		},
+		mongoWebhookSecrets: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "id", Value: 1}}},
+		},
+		mongoScheduledJobs: {
+			{Keys: bson.D{{Key: "name", Value: 1}}, Options: unique},
+		},
+		mongoJobRuns: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "job", Value: 1}, {Key: "id", Value: 1}}},
+		},
+		mongoIdempotencyKeys: {
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "expiresat", Value: 1}}},
+		},
+		mongoOutbox: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "publishedat", Value: 1}, {Key: "id", Value: 1}}},
+			{Keys: bson.D{{Key: "accountid", Value: 1}, {Key: "id", Value: 1}}},
+		},
+	}
+
+	for collection, models := range indexes {
+		if _, err := s.db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("creating the indexes of %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// bind attaches the session of the running transaction to ctx.
+func (s *MongoStorage) bind(ctx context.Context) context.Context {
+	if s.sess == nil {
+		return ctx
+	}
+	return mongo.NewSessionContext(ctx, s.sess)
+}
+
+// collection returns a collection of the database.
+func (s *MongoStorage) collection(name string) *mongo.Collection {
+	return s.db.Collection(name)
+}
+
+// WithTx runs fn with a Storage whose methods all take part in one multi-document
+// transaction, committed when fn returns nil and aborted otherwise. The driver retries
+// fn on transient transaction errors. Calling WithTx on the Storage passed to fn joins
+// the running transaction.
+func (s *MongoStorage) WithTx(ctx context.Context, fn func(Storage) error) error {
+	return s.withTx(ctx, func(tx *MongoStorage) error {
+		return fn(tx)
+	})
+}
+
+// withTx is WithTx for the methods of MongoStorage that need a transaction themselves.
+func (s *MongoStorage) withTx(ctx context.Context, fn func(tx *MongoStorage) error) error {
+	// Join The Running Transaction
+	if s.sess != nil {
+		return fn(s)
+	}
+
+	sess, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	// Ending The Session Aborts A Transaction Left Open By A Panic
+	defer sess.EndSession(context.WithoutCancel(ctx))
+
+	_, err = sess.WithTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, fn(&MongoStorage{client: s.client, db: s.db, sess: sess})
+	})
+	return err
+}
+
+// Ping verifies that the primary is still reachable.
+func (s *MongoStorage) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx, readpref.Primary())
+}
+
+// nextIDs reserves n consecutive integer IDs of a collection and returns the first one.
+func (s *MongoStorage) nextIDs(ctx context.Context, collection string, n int) (int, error) {
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	err := s.collection(mongoCounters).FindOneAndUpdate(s.bind(ctx),
+		bson.D{{Key: "_id", Value: collection}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "seq", Value: n}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Seq - n + 1, nil
+}
+
+// mongoNow returns the current time at the millisecond precision MongoDB stores,
+// so the values written back into the structs match the stored ones.
+func mongoNow() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// activeAccount selects the account with the given ID unless it is deleted.
+func activeAccount(id int) bson.D {
+	return bson.D{{Key: "id", Value: id}, {Key: "deletedat", Value: nil}}
+}
+
+// accountProjection leaves the embedded transactions out of the account reads.
+var accountProjection = bson.D{{Key: "recent_transactions", Value: 0}}
+
+// CreateAccount stores a new account and fills in its ID, timestamps, and version.
+func (s *MongoStorage) CreateAccount(ctx context.Context, account *Account) error {
+	return s.CreateAccounts(ctx, []*Account{account})
+}
+
+// CreateAccounts stores several accounts at once; if a number or an external ID is taken
+// none of them is stored. Accounts without a creation time are created now.
+func (s *MongoStorage) CreateAccounts(ctx context.Context, accounts []*Account) error {
+	if len(accounts) == 0 {
+		return nil
+	}
+
+	return s.withTx(ctx, func(tx *MongoStorage) error {
+		first, err := tx.nextIDs(ctx, mongoAccounts, len(accounts))
+		if err != nil {
+			return err
+		}
+
+		now := mongoNow()
+		docs := make([]interface{}, len(accounts))
+		for i, acc := range accounts {
+			acc.ID = first + i
+			if acc.CreatedAt.IsZero() {
+				acc.CreatedAt = now
+			}
+			acc.CreatedAt = acc.CreatedAt.UTC().Truncate(time.Millisecond)
+			acc.UpdatedAt = now
+			acc.Version = 1
+			if acc.KYCStatus == "" {
+				acc.KYCStatus = KYCStatusNotStarted
+			}
+			docs[i] = mongoAccount{Account: *acc, RecentTransactions: []Transaction{}}
+		}
+
+		_, err = tx.collection(mongoAccounts).InsertMany(tx.bind(ctx), docs)
+		if mongo.IsDuplicateKeyError(err) {
+			// The Message Names The Index Of The Duplicate Key
+			if strings.Contains(err.Error(), "externalid_1") {
+				return ErrExternalIDTaken
+			}
+			return ErrAccountNumberTaken
+		}
+		return err
+	})
+}
+
+// DeleteAccount soft-deletes an account, keeping it and its transactions for admin queries.
+func (s *MongoStorage) DeleteAccount(ctx context.Context, id int) error {
+	now := mongoNow()
+	return s.updateAccount(ctx, id, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "deletedat", Value: now}, {Key: "updatedat", Value: now}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	})
+}
+
+// updateAccount applies an update to an active account.
+func (s *MongoStorage) updateAccount(ctx context.Context, id int, update bson.D) error {
+	res, err := s.collection(mongoAccounts).UpdateOne(s.bind(ctx), activeAccount(id), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+	}
+	return nil
+}
+
+// UpdateAccount changes the name of an account if its version still matches account.Version.
+func (s *MongoStorage) UpdateAccount(ctx context.Context, account *Account) error {
+	filter := append(activeAccount(account.ID), bson.E{Key: "version", Value: account.Version})
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "firstname", Value: account.FirstName}, {Key: "lastname", Value: account.LastName}, {Key: "updatedat", Value: mongoNow()}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+
+	var stored Account
+	err := s.collection(mongoAccounts).FindOneAndUpdate(s.bind(ctx), filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(accountProjection),
+	).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrAccountVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	account.Version = stored.Version
+	account.UpdatedAt = stored.UpdatedAt
+	return nil
+}
+
+// findAccounts returns the accounts matching the filter, ordered by ID.
+func (s *MongoStorage) findAccounts(ctx context.Context, filter bson.D, opts *options.FindOptionsBuilder) ([]*Account, error) {
+	opts = opts.SetSort(bson.D{{Key: "id", Value: 1}}).SetProjection(accountProjection)
+
+	cursor, err := s.collection(mongoAccounts).Find(s.bind(ctx), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []*Account{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		account.denominate()
+	}
+	return accounts, nil
+}
+
+// GetAccounts returns all active accounts ordered by ID.
+func (s *MongoStorage) GetAccounts(ctx context.Context) ([]*Account, error) {
+	return s.findAccounts(ctx, bson.D{{Key: "deletedat", Value: nil}}, options.Find())
+}
+
+// GetAccountsPage returns a page of the accounts ordered by ID, together with the total number of accounts.
+// Deleted accounts are left out unless includeDeleted is set.
+func (s *MongoStorage) GetAccountsPage(ctx context.Context, limit, offset int, includeDeleted bool) ([]*Account, int, error) {
+	filter := bson.D{}
+	if !includeDeleted {
+		filter = bson.D{{Key: "deletedat", Value: nil}}
+	}
+
+	total, err := s.collection(mongoAccounts).CountDocuments(s.bind(ctx), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	accounts, err := s.findAccounts(ctx, filter, options.Find().SetSkip(int64(offset)).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, 0, err
+	}
+	return accounts, int(total), nil
+}
+
+// findAccount returns the active account with the lowest ID matching the filter.
+func (s *MongoStorage) findAccount(ctx context.Context, filter bson.D) (*Account, error) {
+	accounts, err := s.findAccounts(ctx, append(filter, bson.E{Key: "deletedat", Value: nil}), options.Find().SetLimit(1))
+	if err != nil || len(accounts) == 0 {
+		return nil, err
+	}
+	return accounts[0], nil
+}
+
+// GetAccountById returns the account with the given ID.
+func (s *MongoStorage) GetAccountById(ctx context.Context, id int) (*Account, error) {
+	account, err := s.findAccount(ctx, bson.D{{Key: "id", Value: id}})
+	if err == nil && account == nil {
+		err = fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+	}
+	return account, err
+}
+
+// GetAccountByNumber returns the account with the given account number.
+func (s *MongoStorage) GetAccountByNumber(ctx context.Context, number int64) (*Account, error) {
+	account, err := s.findAccount(ctx, bson.D{{Key: "number", Value: number}})
+	if err == nil && account == nil {
+		err = fmt.Errorf("%w: number %d", ErrAccountNotFound, number)
+	}
+	return account, err
+}
+
+// GetAccountByExternalID returns the active account created under an external ID.
+func (s *MongoStorage) GetAccountByExternalID(ctx context.Context, externalID string) (*Account, error) {
+	account, err := s.findAccount(ctx, bson.D{{Key: "externalid", Value: externalID}})
+	if err == nil && account == nil {
+		err = fmt.Errorf("%w: external id %s", ErrAccountNotFound, externalID)
+	}
+	return account, err
+}
+
+// transactionQuery translates a transaction filter into the query of the ledger of an account.
+func transactionQuery(accountID int, f *TransactionFilter) bson.D {
+	query := bson.D{{Key: "accountid", Value: accountID}}
+	if f == nil {
+		return query
+	}
+
+	amount := bson.D{}
+	if f.AmountGte != nil {
+		amount = append(amount, bson.E{Key: "$gte", Value: *f.AmountGte})
+	}
+	if f.AmountLte != nil {
+		amount = append(amount, bson.E{Key: "$lte", Value: *f.AmountLte})
+	}
+	if len(amount) > 0 {
+		query = append(query, bson.E{Key: "amount", Value: amount})
+	}
+
+	if len(f.Types) > 0 {
+		query = append(query, bson.E{Key: "type", Value: bson.D{{Key: "$in", Value: f.Types}}})
+	}
+	if len(f.Categories) > 0 {
+		query = append(query, bson.E{Key: "category", Value: bson.D{{Key: "$in", Value: f.Categories}}})
+	}
+	if f.CounterpartyID != nil {
+		query = append(query, bson.E{Key: "counterpartyid", Value: *f.CounterpartyID})
+	}
+
+	createdAt := bson.D{}
+	if !f.CreatedAfter.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$gte", Value: f.CreatedAfter})
+	}
+	if !f.CreatedBefore.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$lt", Value: f.CreatedBefore})
+	}
+	if len(createdAt) > 0 {
+		query = append(query, bson.E{Key: "createdat", Value: createdAt})
+	}
+
+	return query
+}
+
+// newestFirst orders ledger entries by (created_at, id) descending.
+var newestFirst = bson.D{{Key: "createdat", Value: -1}, {Key: "id", Value: -1}}
+
+// findTransactions returns the ledger entries matching the query.
+func (s *MongoStorage) findTransactions(ctx context.Context, query bson.D, opts *options.FindOptionsBuilder) ([]*Transaction, error) {
+	cursor, err := s.collection(mongoTransactions).Find(s.bind(ctx), query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	txns := []*Transaction{}
+	if err := cursor.All(ctx, &txns); err != nil {
+		return nil, err
+	}
+	for _, t := range txns {
+		t.denominate()
+	}
+	return txns, nil
+}
+
+// GetTransactions returns the transactions of an account that match the filter, newest first.
+func (s *MongoStorage) GetTransactions(ctx context.Context, accountID int, filter *TransactionFilter) ([]*Transaction, error) {
+	return s.findTransactions(ctx, transactionQuery(accountID, filter), options.Find().SetSort(newestFirst))
+}
+
+// GetTransactionsPage returns up to limit transactions of an account that match the
+// filter, newest first, starting right after the given cursor. The first page is
+// served from the transactions embedded in the account when they hold enough matches.
+func (s *MongoStorage) GetTransactionsPage(ctx context.Context, accountID int, filter *TransactionFilter, after *TransactionCursor, limit int) ([]*Transaction, error) {
+	if after == nil && limit <= mongoRecentTransactions {
+		if txns, ok, err := s.recentTransactions(ctx, accountID, filter, limit); err != nil || ok {
+			return txns, err
+		}
+	}
+
+	query := transactionQuery(accountID, filter)
+	if after != nil {
+		query = append(query, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "createdat", Value: bson.D{{Key: "$lt", Value: after.CreatedAt}}}},
+			bson.D{{Key: "createdat", Value: after.CreatedAt}, {Key: "id", Value: bson.D{{Key: "$lt", Value: after.ID}}}},
+		}})
+	}
+
+	return s.findTransactions(ctx, query, options.Find().SetSort(newestFirst).SetLimit(int64(limit)))
+}
+
+// recentTransactions answers the first page of a history from the transactions embedded
+// in the account document. It reports false when they cannot answer it: the account is
+// unknown, or the embedded list is full and holds fewer than limit matches, so older
+// entries may match too.
+func (s *MongoStorage) recentTransactions(ctx context.Context, accountID int, filter *TransactionFilter, limit int) ([]*Transaction, bool, error) {
+	var doc mongoAccount
+	err := s.collection(mongoAccounts).FindOne(s.bind(ctx), bson.D{{Key: "id", Value: accountID}},
+		options.FindOne().SetProjection(bson.D{{Key: "recent_transactions", Value: 1}}),
+	).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	txns := []*Transaction{}
+	for _, t := range doc.RecentTransactions {
+		if len(txns) == limit {
+			break
+		}
+		t.denominate()
+		if filter.matches(&t) {
+			txns = append(txns, &t)
+		}
+	}
+
+	return txns, len(txns) == limit || len(doc.RecentTransactions) < mongoRecentTransactions, nil
+}
+
+// GetBalanceAt returns the balance of an account right before the given time.
+func (s *MongoStorage) GetBalanceAt(ctx context.Context, accountID int, at time.Time) (money.Money, error) {
+	acc, err := s.GetAccountById(ctx, accountID)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	var t Transaction
+	err = s.collection(mongoTransactions).FindOne(s.bind(ctx),
+		bson.D{{Key: "accountid", Value: accountID}, {Key: "createdat", Value: bson.D{{Key: "$lt", Value: at}}}},
+		options.FindOne().SetSort(newestFirst),
+	).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return money.Zero(acc.Currency), nil
+	}
+	if err != nil {
+		return money.Money{}, err
+	}
+	return money.New(t.BalanceAfter.Amount, acc.Currency), nil
+}
+
+// StreamTransactions calls fn for every transaction of an account created in the
+// [from, to) range, oldest first, decoding one document at a time.
+func (s *MongoStorage) StreamTransactions(ctx context.Context, accountID int, from, to time.Time, fn func(*Transaction) error) error {
+	query := transactionQuery(accountID, &TransactionFilter{CreatedAfter: from, CreatedBefore: to})
+
+	cursor, err := s.collection(mongoTransactions).Find(s.bind(ctx), query,
+		options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "id", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		t := &Transaction{}
+		if err := cursor.Decode(t); err != nil {
+			return err
+		}
+		t.denominate()
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// StreamLedger calls fn for every ledger entry of every account created in [from, to), oldest first.
+func (s *MongoStorage) StreamLedger(ctx context.Context, from, to time.Time, fn func(*Transaction) error) error {
+	cursor, err := s.collection(mongoTransactions).Find(s.bind(ctx),
+		bson.D{{Key: "createdat", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lt", Value: to}}}},
+		options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "id", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		t := &Transaction{}
+		if err := cursor.Decode(t); err != nil {
+			return err
+		}
+		t.denominate()
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// ImportRecords stores imported accounts and transactions, reporting an error per
+// record that cannot be stored while keeping the others.
+func (s *MongoStorage) ImportRecords(ctx context.Context, records []*ImportRecord) ([]error, error) {
+	errs := make([]error, len(records))
+	for i, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		errs[i] = s.importRecord(ctx, rec)
+	}
+	return errs, nil
+}
+
+// importRecord stores a single imported record.
+func (s *MongoStorage) importRecord(ctx context.Context, rec *ImportRecord) error {
+	if rec.Kind == ImportKindAccount {
+		return s.CreateAccount(ctx, &Account{
+			FirstName: rec.FirstName,
+			LastName:  rec.LastName,
+			Number:    rec.Number,
+			Balance:   money.New(rec.Balance.Amount, rec.Currency),
+			Currency:  rec.Currency,
+			CreatedAt: rec.CreatedAt,
+		})
+	}
+
+	acc, err := s.findAccount(ctx, bson.D{{Key: "number", Value: rec.AccountNumber}})
+	if err != nil {
+		return err
+	}
+	if acc == nil {
+		return fmt.Errorf("account with number %d not found", rec.AccountNumber)
+	}
+	currency, err := rec.entryCurrency(acc.Currency)
+	if err != nil {
+		return err
+	}
+
+	var counterpartyID int
+	if rec.CounterpartyNumber != 0 {
+		counterparty, err := s.findAccount(ctx, bson.D{{Key: "number", Value: rec.CounterpartyNumber}})
+		if err != nil {
+			return err
+		}
+		if counterparty == nil {
+			return fmt.Errorf("counterparty with number %d not found", rec.CounterpartyNumber)
+		}
+		counterpartyID = counterparty.ID
+	}
+
+	return s.CreateTransactions(ctx, []*Transaction{{
+		AccountID:      acc.ID,
+		CounterpartyID: counterpartyID,
+		Type:           rec.Type,
+		Amount:         money.New(rec.Amount.Amount, currency),
+		BalanceAfter:   money.New(rec.BalanceAfter.Amount, currency),
+		Currency:       currency,
+		CreatedAt:      rec.CreatedAt,
+		Category:       rec.Category,
+	}})
+}
+
+// CreateTransactions stores several ledger entries at once, fills in their IDs and
+// creation times, and embeds them in the recent transactions of their accounts.
+func (s *MongoStorage) CreateTransactions(ctx context.Context, txns []*Transaction) error {
+	if len(txns) == 0 {
+		return nil
+	}
+
+	return s.withTx(ctx, func(tx *MongoStorage) error {
+		first, err := tx.nextIDs(ctx, mongoTransactions, len(txns))
+		if err != nil {
+			return err
+		}
+
+		now := mongoNow()
+		docs := make([]interface{}, len(txns))
+		byAccount := map[int][]Transaction{}
+		for i, t := range txns {
+			t.ID = first + i
+			if t.CreatedAt.IsZero() {
+				t.CreatedAt = now
+			}
+			t.CreatedAt = t.CreatedAt.UTC().Truncate(time.Millisecond)
+			docs[i] = *t
+			byAccount[t.AccountID] = append(byAccount[t.AccountID], *t)
+		}
+
+		if _, err := tx.collection(mongoTransactions).InsertMany(tx.bind(ctx), docs); err != nil {
+			return err
+		}
+
+		// Keep Only The Newest Entries Embedded In Each Account
+		for accountID, recent := range byAccount {
+			_, err := tx.collection(mongoAccounts).UpdateOne(tx.bind(ctx), bson.D{{Key: "id", Value: accountID}}, bson.D{
+				{Key: "$push", Value: bson.D{{Key: "recent_transactions", Value: bson.D{
+					{Key: "$each", Value: recent},
+					{Key: "$sort", Value: newestFirst},
+					{Key: "$slice", Value: mongoRecentTransactions},
+				}}}},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// TransferFunds moves amount from one account to another and records both ledger entries
+// in one transaction. The balance updates only apply to the versions that were checked,
+// failing with ErrAccountVersionConflict otherwise. A debit past the balance is
+// ErrInsufficientFunds, whether the check or the validator of the accounts refuses it.
+func (s *MongoStorage) TransferFunds(ctx context.Context, fromID, toID int, amount money.Money) ([]*Transaction, error) {
+	var txns []*Transaction
+
+	err := s.withTx(ctx, func(tx *MongoStorage) error {
+		from, err := tx.GetAccountById(ctx, fromID)
+		if err != nil {
+			return err
+		}
+		to, err := tx.GetAccountById(ctx, toID)
+		if err != nil {
+			return err
+		}
+
+		// Check The Operator Restrictions And The Currency Of Both Accounts
+		if from.Frozen || to.Frozen {
+			return fmt.Errorf("account is frozen")
+		}
+		if err := checkTransferCurrency(amount, from.Currency, to.Currency); err != nil {
+			return err
+		}
+		if from.TransferLimit.IsPositive() && amount.Amount > from.TransferLimit.Amount {
+			return fmt.Errorf("amount exceeds the transfer limit of %s", from.TransferLimit)
+		}
+		fromBalance, err := from.Balance.Sub(amount)
+		if err != nil {
+			return err
+		}
+		if fromBalance.IsNegative() {
+			return fmt.Errorf("%w on account %d", ErrInsufficientFunds, fromID)
+		}
+		toBalance, err := to.Balance.Add(amount)
+		if err != nil {
+			return err
+		}
+
+		// Move The Funds, Unless An Account Changed Since It Was Read
+		now := mongoNow()
+		for _, change := range []struct {
+			acc   *Account
+			delta money.Money
+		}{{from, amount.Neg()}, {to, amount}} {
+			res, err := tx.collection(mongoAccounts).UpdateOne(tx.bind(ctx),
+				append(activeAccount(change.acc.ID), bson.E{Key: "version", Value: change.acc.Version}),
+				bson.D{
+					{Key: "$inc", Value: bson.D{{Key: "balance", Value: change.delta.Amount}, {Key: "version", Value: 1}}},
+					{Key: "$set", Value: bson.D{{Key: "updatedat", Value: now}}},
+				},
+			)
+			if err != nil {
+				return balanceError(err, change.acc.ID)
+			}
+			if res.MatchedCount == 0 {
+				return ErrAccountVersionConflict
+			}
+		}
+
+		// Record The Ledger Entries
+		debit := &Transaction{AccountID: fromID, CounterpartyID: toID, Type: TransactionTypeTransfer, Amount: amount.Neg(), BalanceAfter: fromBalance, Currency: amount.Currency}
+		credit := &Transaction{AccountID: toID, CounterpartyID: fromID, Type: TransactionTypeTransfer, Amount: amount, BalanceAfter: toBalance, Currency: amount.Currency}
+		if err := tx.CreateTransactions(ctx, []*Transaction{debit, credit}); err != nil {
+			return err
+		}
+
+		txns = []*Transaction{debit, credit}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return txns, nil
+}
+
+// DepositFunds credits an account with money received from outside the bank and records the ledger entry.
+func (s *MongoStorage) DepositFunds(ctx context.Context, deposit *Transaction) error {
+	return s.withTx(ctx, func(tx *MongoStorage) error {
+		var acc Account
+		err := tx.collection(mongoAccounts).FindOneAndUpdate(tx.bind(ctx), activeAccount(deposit.AccountID),
+			bson.D{
+				{Key: "$inc", Value: bson.D{{Key: "balance", Value: deposit.Amount.Amount}, {Key: "version", Value: 1}}},
+				{Key: "$set", Value: bson.D{{Key: "updatedat", Value: mongoNow()}}},
+			},
+			options.FindOneAndUpdate().SetProjection(accountProjection).SetReturnDocument(options.After),
+		).Decode(&acc)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("%w: %d", ErrAccountNotFound, deposit.AccountID)
+		}
+		if err != nil {
+			return balanceError(err, deposit.AccountID)
+		}
+		// Aborted Unless The Deposit Is In The Currency Of The Account
+		if err := checkDepositCurrency(deposit, acc.Currency); err != nil {
+			return err
+		}
+		acc.denominate()
+
+		deposit.Currency = acc.Currency
+		deposit.BalanceAfter = acc.Balance
+		return tx.CreateTransactions(ctx, []*Transaction{deposit})
+	})
+}
+
+// CreateTransfer records a new pending transfer and its first state change.
+func (s *MongoStorage) CreateTransfer(ctx context.Context, transfer *Transfer) error {
+	id, err := s.nextIDs(ctx, mongoTransfers, 1)
+	if err != nil {
+		return err
+	}
+
+	now := mongoNow()
+	transfer.ID = id
+	transfer.Status = TransferStatusPending
+	transfer.CreatedAt = now
+	transfer.UpdatedAt = now
+	transfer.History = []TransferStateChange{{Status: transfer.Status, At: now}}
+
+	_, err = s.collection(mongoTransfers).InsertOne(s.bind(ctx), transfer)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %s", ErrProviderReferenceTaken, transfer.ProviderReference)
+	}
+	return err
+}
+
+// UpdateTransferStatus moves a transfer to a new state and appends the change to its history.
+func (s *MongoStorage) UpdateTransferStatus(ctx context.Context, transfer *Transfer, status, reason string) error {
+	now := mongoNow()
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "status", Value: status}, {Key: "failurereason", Value: reason}, {Key: "updatedat", Value: now}}},
+		{Key: "$push", Value: bson.D{{Key: "history", Value: TransferStateChange{Status: status, Reason: reason, At: now}}}},
+	}
+
+	var stored Transfer
+	err := s.collection(mongoTransfers).FindOneAndUpdate(s.bind(ctx), bson.D{{Key: "id", Value: transfer.ID}}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: %d", ErrTransferNotFound, transfer.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	transfer.Status = stored.Status
+	transfer.FailureReason = stored.FailureReason
+	transfer.UpdatedAt = stored.UpdatedAt
+	transfer.History = stored.History
+	return nil
+}
+
+// GetTransfer returns a transfer and the history of its state changes.
+func (s *MongoStorage) GetTransfer(ctx context.Context, id int) (*Transfer, error) {
+	transfer := &Transfer{}
+	err := s.collection(mongoTransfers).FindOne(s.bind(ctx), bson.D{{Key: "id", Value: id}}).Decode(transfer)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %d", ErrTransferNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	transfer.denominate()
+	return transfer, nil
+}
+
+// GetTransfersByStatus returns the transfers in the given state, oldest first.
+func (s *MongoStorage) GetTransfersByStatus(ctx context.Context, status string) ([]*Transfer, error) {
+	cursor, err := s.collection(mongoTransfers).Find(s.bind(ctx), bson.D{{Key: "status", Value: status}},
+		options.Find().SetSort(bson.D{{Key: "id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := []*Transfer{}
+	if err := cursor.All(ctx, &transfers); err != nil {
+		return nil, err
+	}
+	for _, transfer := range transfers {
+		transfer.denominate()
+	}
+	return transfers, nil
+}
+
+// GetTransferByProviderReference returns the transfer settled by the payment gateway
+// under a reference, and the history of its state changes.
+func (s *MongoStorage) GetTransferByProviderReference(ctx context.Context, reference string) (*Transfer, error) {
+	transfer := &Transfer{}
+	err := s.collection(mongoTransfers).FindOne(s.bind(ctx), bson.D{{Key: "providerreference", Value: reference}}).Decode(transfer)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %s", ErrTransferNotFound, reference)
+	}
+	if err != nil {
+		return nil, err
+	}
+	transfer.denominate()
+	return transfer, nil
+}
+
+// SetAccountFrozen freezes or unfreezes an account.
+func (s *MongoStorage) SetAccountFrozen(ctx context.Context, id int, frozen bool) error {
+	return s.updateAccount(ctx, id, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "frozen", Value: frozen}, {Key: "updatedat", Value: mongoNow()}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	})
+}
+
+// SetAccountKYCStatus changes the identity verification status of an account.
+func (s *MongoStorage) SetAccountKYCStatus(ctx context.Context, id int, status string) error {
+	return s.updateAccount(ctx, id, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "kycstatus", Value: status}, {Key: "updatedat", Value: mongoNow()}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	})
+}
+
+// UpdateDormantAccounts marks the active accounts without transactions since inactiveSince
+// as dormant, and clears the mark of the dormant accounts with a transaction since they
+// were marked.
+func (s *MongoStorage) UpdateDormantAccounts(ctx context.Context, inactiveSince time.Time) (dormant, reactivated []int, err error) {
+	dormant, reactivated = []int{}, []int{}
+	now := mongoNow()
+
+	marked, err := s.findAccounts(ctx, bson.D{
+		{Key: "deletedat", Value: nil},
+		{Key: "dormantat", Value: bson.D{{Key: "$ne", Value: nil}}},
+	}, options.Find())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, acc := range marked {
+		n, err := s.collection(mongoTransactions).CountDocuments(s.bind(ctx), bson.D{
+			{Key: "accountid", Value: acc.ID},
+			{Key: "createdat", Value: bson.D{{Key: "$gte", Value: *acc.DormantAt}}},
+		}, options.Count().SetLimit(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		if n > 0 {
+			reactivated = append(reactivated, acc.ID)
+		}
+	}
+	if len(reactivated) > 0 {
+		if _, err := s.collection(mongoAccounts).UpdateMany(s.bind(ctx), bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: reactivated}}}}, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "dormantat", Value: nil}, {Key: "updatedat", Value: now}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var active []int
+	res := s.collection(mongoTransactions).Distinct(s.bind(ctx), "accountid", bson.D{{Key: "createdat", Value: bson.D{{Key: "$gte", Value: inactiveSince}}}})
+	if err := res.Decode(&active); err != nil {
+		return nil, nil, err
+	}
+	candidates, err := s.findAccounts(ctx, bson.D{
+		{Key: "deletedat", Value: nil},
+		{Key: "dormantat", Value: nil},
+		{Key: "createdat", Value: bson.D{{Key: "$lt", Value: inactiveSince}}},
+		{Key: "id", Value: bson.D{{Key: "$nin", Value: append(active, reactivated...)}}},
+	}, options.Find())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, acc := range candidates {
+		dormant = append(dormant, acc.ID)
+	}
+	if len(dormant) > 0 {
+		if _, err := s.collection(mongoAccounts).UpdateMany(s.bind(ctx), bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: dormant}}}}, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "dormantat", Value: now}, {Key: "updatedat", Value: now}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return dormant, reactivated, nil
+}
+
+// SetTransferLimit changes the maximum amount of a single outgoing transfer of an account.
+func (s *MongoStorage) SetTransferLimit(ctx context.Context, id int, limit money.Money) error {
+	return s.updateAccount(ctx, id, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "transferlimit", Value: limit.Amount}, {Key: "updatedat", Value: mongoNow()}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	})
+}
+
+// CreateAuditEntry appends an entry to the audit log and fills in its ID and creation time.
+func (s *MongoStorage) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	id, err := s.nextIDs(ctx, mongoAuditLog, 1)
+	if err != nil {
+		return err
+	}
+
+	entry.ID = id
+	entry.CreatedAt = mongoNow()
+
+	_, err = s.collection(mongoAuditLog).InsertOne(s.bind(ctx), entry)
+	return err
+}
+
+// GetAuditLog returns a page of the audit log, newest first, together with the total number of entries.
+func (s *MongoStorage) GetAuditLog(ctx context.Context, limit, offset int) ([]*AuditEntry, int, error) {
+	total, err := s.collection(mongoAuditLog).CountDocuments(s.bind(ctx), bson.D{})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := s.collection(mongoAuditLog).Find(s.bind(ctx), bson.D{},
+		options.Find().SetSort(bson.D{{Key: "id", Value: -1}}).SetSkip(int64(offset)).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := []*AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, 0, err
+	}
+	return entries, int(total), nil
+}
+
+// GetFeatureFlags returns the overrides of the feature flags, ordered by name.
+func (s *MongoStorage) GetFeatureFlags(ctx context.Context) ([]*FeatureFlag, error) {
+	cursor, err := s.collection(mongoFeatureFlags).Find(s.bind(ctx), bson.D{},
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := []*FeatureFlag{}
+	if err := cursor.All(ctx, &flags); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// SetFeatureFlag creates or replaces the override of a feature flag and fills in its update time.
+func (s *MongoStorage) SetFeatureFlag(ctx context.Context, flag *FeatureFlag) error {
+	flag.UpdatedAt = mongoNow()
+
+	_, err := s.collection(mongoFeatureFlags).ReplaceOne(s.bind(ctx), bson.D{{Key: "name", Value: flag.Name}}, flag,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// DeleteFeatureFlag removes the override of a feature flag.
+func (s *MongoStorage) DeleteFeatureFlag(ctx context.Context, name string) error {
+	_, err := s.collection(mongoFeatureFlags).DeleteOne(s.bind(ctx), bson.D{{Key: "name", Value: name}})
+	return err
+}
+
+// mongoMaintenanceID is the ID of the single document of the maintenance collection.
+const mongoMaintenanceID = "current"
+
+// GetMaintenance returns the maintenance under way, or nil.
+func (s *MongoStorage) GetMaintenance(ctx context.Context) (*Maintenance, error) {
+	maintenance := &Maintenance{}
+	err := s.collection(mongoMaintenance).FindOne(s.bind(ctx), bson.D{{Key: "_id", Value: mongoMaintenanceID}}).Decode(maintenance)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return maintenance, nil
+}
+
+// SetMaintenance starts a maintenance, replacing the one under way, and fills in its start time.
+func (s *MongoStorage) SetMaintenance(ctx context.Context, maintenance *Maintenance) error {
+	maintenance.StartedAt = mongoNow()
+
+	_, err := s.collection(mongoMaintenance).ReplaceOne(s.bind(ctx), bson.D{{Key: "_id", Value: mongoMaintenanceID}}, maintenance,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// ClearMaintenance ends the maintenance under way.
+func (s *MongoStorage) ClearMaintenance(ctx context.Context) error {
+	_, err := s.collection(mongoMaintenance).DeleteOne(s.bind(ctx), bson.D{{Key: "_id", Value: mongoMaintenanceID}})
+	return err
+}
+
+// GetNotificationPreferences returns the notification preferences of an account, or nil.
+func (s *MongoStorage) GetNotificationPreferences(ctx context.Context, accountID int) (*NotificationPreferences, error) {
+	prefs := &NotificationPreferences{}
+	err := s.collection(mongoNotificationPreferences).FindOne(s.bind(ctx), bson.D{{Key: "accountid", Value: accountID}}).Decode(prefs)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// SetNotificationPreferences replaces the notification preferences of an account and
+// fills in their modification time.
+func (s *MongoStorage) SetNotificationPreferences(ctx context.Context, prefs *NotificationPreferences) error {
+	prefs.UpdatedAt = mongoNow()
+
+	_, err := s.collection(mongoNotificationPreferences).ReplaceOne(s.bind(ctx), bson.D{{Key: "accountid", Value: prefs.AccountID}}, prefs,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// RegisterPushDevice stores a push device and fills in its ID and registration time,
+// replacing the device already registered with its token.
+func (s *MongoStorage) RegisterPushDevice(ctx context.Context, device *PushDevice) error {
+	var registered PushDevice
+	err := s.collection(mongoPushDevices).FindOne(s.bind(ctx), bson.D{{Key: "token", Value: device.Token}}).Decode(&registered)
+	switch {
+	case err == nil:
+		device.ID = registered.ID
+	case errors.Is(err, mongo.ErrNoDocuments):
+		if device.ID, err = s.nextIDs(ctx, mongoPushDevices, 1); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	device.CreatedAt = mongoNow()
+
+	_, err = s.collection(mongoPushDevices).ReplaceOne(s.bind(ctx), bson.D{{Key: "token", Value: device.Token}}, device,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// GetPushDevices returns the push devices of an account, oldest first.
+func (s *MongoStorage) GetPushDevices(ctx context.Context, accountID int) ([]*PushDevice, error) {
+	cursor, err := s.collection(mongoPushDevices).Find(s.bind(ctx), bson.D{{Key: "accountid", Value: accountID}},
+		options.Find().SetSort(bson.D{{Key: "id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []*PushDevice{}
+	if err := cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// DeletePushDevice removes a push device of an account.
+func (s *MongoStorage) DeletePushDevice(ctx context.Context, accountID, id int) error {
+	res, err := s.collection(mongoPushDevices).DeleteOne(s.bind(ctx), bson.D{{Key: "id", Value: id}, {Key: "accountid", Value: accountID}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("%w: %d", ErrPushDeviceNotFound, id)
+	}
+	return nil
+}
+
+// RecordGatewayEvent stores a callback of the payment gateway and fills in its reception time.
+func (s *MongoStorage) RecordGatewayEvent(ctx context.Context, event *GatewayEvent) error {
+	event.ReceivedAt = mongoNow()
+	_, err := s.collection(mongoGatewayEvents).InsertOne(s.bind(ctx), event)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %s", ErrGatewayEventDuplicate, event.ID)
+	}
+	return err
+}
+
+// CreateCardTopUp stores a new card top-up and fills in its ID and timestamps.
+func (s *MongoStorage) CreateCardTopUp(ctx context.Context, topUp *CardTopUp) error {
+	id, err := s.nextIDs(ctx, mongoCardTopUps, 1)
+	if err != nil {
+		return err
+	}
+
+	topUp.ID = id
+	topUp.CreatedAt = mongoNow()
+	topUp.UpdatedAt = topUp.CreatedAt
+	_, err = s.collection(mongoCardTopUps).InsertOne(s.bind(ctx), topUp)
+	return err
+}
+
+// GetCardTopUp returns a card top-up of an account.
+func (s *MongoStorage) GetCardTopUp(ctx context.Context, accountID, id int) (*CardTopUp, error) {
+	topUp := &CardTopUp{}
+	err := s.collection(mongoCardTopUps).FindOne(s.bind(ctx), bson.D{{Key: "id", Value: id}, {Key: "accountid", Value: accountID}}).Decode(topUp)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %d", ErrCardTopUpNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	topUp.denominate()
+	return topUp, nil
+}
+
+// GetCardTopUpByPaymentIntent returns the card top-up paid by a Stripe payment intent.
+func (s *MongoStorage) GetCardTopUpByPaymentIntent(ctx context.Context, paymentIntentID string) (*CardTopUp, error) {
+	topUp := &CardTopUp{}
+	err := s.collection(mongoCardTopUps).FindOne(s.bind(ctx), bson.D{{Key: "paymentintentid", Value: paymentIntentID}}).Decode(topUp)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %s", ErrCardTopUpNotFound, paymentIntentID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	topUp.denominate()
+	return topUp, nil
+}
+
+// UpdateCardTopUp stores the changes of a card top-up, provided it is still in the state fromStatus.
+func (s *MongoStorage) UpdateCardTopUp(ctx context.Context, topUp *CardTopUp, fromStatus string) error {
+	now := mongoNow()
+	res, err := s.collection(mongoCardTopUps).UpdateOne(s.bind(ctx), bson.D{{Key: "id", Value: topUp.ID}, {Key: "status", Value: fromStatus}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: topUp.Status},
+			{Key: "paymentintentid", Value: topUp.PaymentIntentID},
+			{Key: "chargeid", Value: topUp.ChargeID},
+			{Key: "transactionid", Value: topUp.TransactionID},
+			{Key: "failurereason", Value: topUp.FailureReason},
+			{Key: "updatedat", Value: now},
+		}}},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %d", ErrCardTopUpStatusChanged, topUp.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("%w: %d", ErrCardTopUpStatusChanged, topUp.ID)
+	}
+
+	topUp.UpdatedAt = now
+	return nil
+}
+
+// CreateKYCCheck stores a new KYC check and fills in its ID and timestamps.
+func (s *MongoStorage) CreateKYCCheck(ctx context.Context, check *KYCCheck) error {
+	id, err := s.nextIDs(ctx, mongoKYCChecks, 1)
+	if err != nil {
+		return err
+	}
+
+	check.ID = id
+	check.CreatedAt = mongoNow()
+	check.UpdatedAt = check.CreatedAt
+	_, err = s.collection(mongoKYCChecks).InsertOne(s.bind(ctx), check)
+	return err
+}
+
+// GetKYCCheck returns the latest KYC check of an account.
+func (s *MongoStorage) GetKYCCheck(ctx context.Context, accountID int) (*KYCCheck, error) {
+	return s.findKYCCheck(ctx, bson.D{{Key: "accountid", Value: accountID}}, accountID)
+}
+
+// GetKYCCheckByProviderID returns the KYC check with an ID at the provider.
+func (s *MongoStorage) GetKYCCheckByProviderID(ctx context.Context, providerCheckID string) (*KYCCheck, error) {
+	return s.findKYCCheck(ctx, bson.D{{Key: "providercheckid", Value: providerCheckID}}, providerCheckID)
+}
+
+// findKYCCheck returns the latest KYC check matching filter, or ErrKYCCheckNotFound for key.
+func (s *MongoStorage) findKYCCheck(ctx context.Context, filter bson.D, key interface{}) (*KYCCheck, error) {
+	check := &KYCCheck{}
+	err := s.collection(mongoKYCChecks).FindOne(s.bind(ctx), filter, options.FindOne().SetSort(bson.D{{Key: "id", Value: -1}})).Decode(check)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %v", ErrKYCCheckNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+// GetOpenKYCChecks returns up to limit KYC checks waiting for a result, the ones not
+// submitted to the provider first, then the least recently updated.
+func (s *MongoStorage) GetOpenKYCChecks(ctx context.Context, limit int) ([]*KYCCheck, error) {
+	checks := []*KYCCheck{}
+	// The Unsubmitted Checks Have No Provider ID, The Submitted Ones A Non-Empty One
+	for _, providerID := range []interface{}{"", bson.D{{Key: "$gt", Value: ""}}} {
+		if len(checks) >= limit {
+			break
+		}
+		filter := bson.D{
+			{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{KYCStatusPending, KYCStatusReview}}}},
+			{Key: "providercheckid", Value: providerID},
+		}
+		cursor, err := s.collection(mongoKYCChecks).Find(s.bind(ctx), filter,
+			options.Find().SetSort(bson.D{{Key: "updatedat", Value: 1}, {Key: "id", Value: 1}}).SetLimit(int64(limit-len(checks))))
+		if err != nil {
+			return nil, err
+		}
+		batch := []*KYCCheck{}
+		if err := cursor.All(ctx, &batch); err != nil {
+			return nil, err
+		}
+		checks = append(checks, batch...)
+	}
+	return checks, nil
+}
+
+// UpdateKYCCheck stores the changes of a KYC check, provided it is still in the state fromStatus.
+func (s *MongoStorage) UpdateKYCCheck(ctx context.Context, check *KYCCheck, fromStatus string) error {
+	now := mongoNow()
+	res, err := s.collection(mongoKYCChecks).UpdateOne(s.bind(ctx), bson.D{{Key: "id", Value: check.ID}, {Key: "status", Value: fromStatus}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "providercheckid", Value: check.ProviderCheckID},
+			{Key: "status", Value: check.Status},
+			{Key: "url", Value: check.URL},
+			{Key: "failurereason", Value: check.FailureReason},
+			{Key: "updatedat", Value: now},
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("%w: %d", ErrKYCCheckStatusChanged, check.ID)
+	}
+
+	check.UpdatedAt = now
+	return nil
+}
+
+// CreateConsent stores a new consent and fills in its ID and creation time.
+func (s *MongoStorage) CreateConsent(ctx context.Context, consent *Consent) error {
+	id, err := s.nextIDs(ctx, mongoConsents, 1)
+	if err != nil {
+		return err
+	}
+
+	consent.ID = id
+	consent.CreatedAt = mongoNow()
+	_, err = s.collection(mongoConsents).InsertOne(s.bind(ctx), consent)
+	return err
+}
+
+// GetConsent returns a consent by ID, whatever its account.
+func (s *MongoStorage) GetConsent(ctx context.Context, id int) (*Consent, error) {
+	consent := &Consent{}
+	err := s.collection(mongoConsents).FindOne(s.bind(ctx), bson.D{{Key: "id", Value: id}}).Decode(consent)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %d", ErrConsentNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return consent, nil
+}
+
+// GetConsents returns the consents of an account, oldest first.
+func (s *MongoStorage) GetConsents(ctx context.Context, accountID int) ([]*Consent, error) {
+	cursor, err := s.collection(mongoConsents).Find(s.bind(ctx), bson.D{{Key: "accountid", Value: accountID}},
+		options.Find().SetSort(bson.D{{Key: "id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	consents := []*Consent{}
+	if err := cursor.All(ctx, &consents); err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+// RevokeConsent revokes a consent of an account, keeping the time it was first revoked.
+func (s *MongoStorage) RevokeConsent(ctx context.Context, accountID, id int) error {
+	filter := bson.D{{Key: "id", Value: id}, {Key: "accountid", Value: accountID}}
+	res, err := s.collection(mongoConsents).UpdateOne(s.bind(ctx), append(filter, bson.E{Key: "revokedat", Value: nil}),
+		bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: mongoNow()}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	// Not Revoked Now, Either Revoked Before Or Not A Consent Of The Account
+	n, err := s.collection(mongoConsents).CountDocuments(s.bind(ctx), filter)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrConsentNotFound, id)
+	}
+	return nil
+}
+
+// CreateWebhookSecret stores a new webhook secret and fills in its ID and creation time.
+func (s *MongoStorage) CreateWebhookSecret(ctx context.Context, secret *WebhookSecret) error {
+	id, err := s.nextIDs(ctx, mongoWebhookSecrets, 1)
+	if err != nil {
+		return err
+	}
+
+	secret.ID = id
+	secret.CreatedAt = mongoNow()
+	_, err = s.collection(mongoWebhookSecrets).InsertOne(s.bind(ctx), secret)
+	return err
+}
+
+// GetWebhookSecrets returns the webhook secrets of a provider, of every provider when it
+// is empty, oldest first.
+func (s *MongoStorage) GetWebhookSecrets(ctx context.Context, provider string) ([]*WebhookSecret, error) {
+	filter := bson.D{}
+	if provider != "" {
+		filter = bson.D{{Key: "provider", Value: provider}}
+	}
+
+	cursor, err := s.collection(mongoWebhookSecrets).Find(s.bind(ctx), filter, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []*WebhookSecret{}
+	if err := cursor.All(ctx, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// ExpireWebhookSecret sets the expiry of a webhook secret, keeping an earlier one.
+func (s *MongoStorage) ExpireWebhookSecret(ctx context.Context, id int, at time.Time) (*WebhookSecret, error) {
+	secret := &WebhookSecret{}
+	err := s.collection(mongoWebhookSecrets).FindOneAndUpdate(s.bind(ctx), bson.D{{Key: "id", Value: id}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "expiresat", Value: bson.D{{Key: "$min", Value: bson.A{
+			bson.D{{Key: "$ifNull", Value: bson.A{"$expiresat", at.UTC()}}}, at.UTC(),
+		}}}}}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(secret)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %d", ErrWebhookSecretNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// AddOutboxEvents appends domain events to the outbox and fills in their IDs and creation times.
+func (s *MongoStorage) AddOutboxEvents(ctx context.Context, events []*OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	first, err := s.nextIDs(ctx, mongoOutbox, len(events))
+	if err != nil {
+		return err
+	}
+
+	now := mongoNow()
+	docs := make([]interface{}, len(events))
+	for i, event := range events {
+		event.ID = first + i
+		event.CreatedAt = now
+		docs[i] = event
+	}
+
+	_, err = s.collection(mongoOutbox).InsertMany(s.bind(ctx), docs)
+	return err
+}
+
+// GetUnpublishedOutboxEvents returns the oldest outbox events that are not published yet.
+// Unlike Postgres, MongoDB cannot skip the events claimed by another relay, so with
+// several instances an event may be published twice, which the at-least-once delivery
+// of the outbox already allows.
+func (s *MongoStorage) GetUnpublishedOutboxEvents(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	cursor, err := s.collection(mongoOutbox).Find(s.bind(ctx), bson.D{{Key: "publishedat", Value: nil}},
+		options.Find().SetSort(bson.D{{Key: "id", Value: 1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*OutboxEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// MarkOutboxEventsPublished records that outbox events have been published.
+func (s *MongoStorage) MarkOutboxEventsPublished(ctx context.Context, ids []int) error {
+	_, err := s.collection(mongoOutbox).UpdateMany(s.bind(ctx),
+		bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: ids}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "publishedat", Value: mongoNow()}}}},
+	)
+	return err
+}
+
+// GetPublishedOutboxEvents returns a batch of the published outbox events selected by
+// filter with an ID above afterID, oldest first.
+func (s *MongoStorage) GetPublishedOutboxEvents(ctx context.Context, filter OutboxEventFilter, afterID, limit int) ([]*OutboxEvent, error) {
+	query := bson.D{
+		{Key: "publishedat", Value: bson.D{{Key: "$ne", Value: nil}}},
+		{Key: "id", Value: bson.D{{Key: "$gt", Value: afterID}}},
+	}
+	if filter.AccountID != 0 {
+		query = append(query, bson.E{Key: "accountid", Value: filter.AccountID})
+	}
+	createdAt := bson.D{}
+	if !filter.CreatedAfter.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$gte", Value: filter.CreatedAfter})
+	}
+	if !filter.CreatedBefore.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$lt", Value: filter.CreatedBefore})
+	}
+	if len(createdAt) > 0 {
+		query = append(query, bson.E{Key: "createdat", Value: createdAt})
+	}
+
+	cursor, err := s.collection(mongoOutbox).Find(s.bind(ctx), query,
+		options.Find().SetSort(bson.D{{Key: "id", Value: 1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*OutboxEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// DeletePublishedOutboxEvents removes the published outbox events created before the
+// given time with an ID up to throughID.
+func (s *MongoStorage) DeletePublishedOutboxEvents(ctx context.Context, before time.Time, throughID int) (int, error) {
+	res, err := s.collection(mongoOutbox).DeleteMany(s.bind(ctx), bson.D{
+		{Key: "publishedat", Value: bson.D{{Key: "$ne", Value: nil}}},
+		{Key: "createdat", Value: bson.D{{Key: "$lt", Value: before}}},
+		{Key: "id", Value: bson.D{{Key: "$lte", Value: throughID}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
+
+// RegisterScheduledJob stores a scheduled job unless one already has its name, and fills
+// the job in with the stored one.
+func (s *MongoStorage) RegisterScheduledJob(ctx context.Context, job *ScheduledJob) error {
+	job.UpdatedAt = mongoNow()
+	return s.collection(mongoScheduledJobs).FindOneAndUpdate(s.bind(ctx), bson.D{{Key: "name", Value: job.Name}},
+		bson.D{{Key: "$setOnInsert", Value: job}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(job)
+}
+
+// GetScheduledJobs returns every scheduled job by name.
+func (s *MongoStorage) GetScheduledJobs(ctx context.Context) ([]*ScheduledJob, error) {
+	cursor, err := s.collection(mongoScheduledJobs).Find(s.bind(ctx), bson.D{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []*ScheduledJob{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetScheduledJob returns a scheduled job by name.
+func (s *MongoStorage) GetScheduledJob(ctx context.Context, name string) (*ScheduledJob, error) {
+	job := &ScheduledJob{}
+	err := s.collection(mongoScheduledJobs).FindOne(s.bind(ctx), bson.D{{Key: "name", Value: name}}).Decode(job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %s", ErrScheduledJobNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// UpdateScheduledJob stores the schedule, the enabled flag, and the next run of a
+// scheduled job, and fills in the time of the change.
+func (s *MongoStorage) UpdateScheduledJob(ctx context.Context, job *ScheduledJob) error {
+	job.UpdatedAt = mongoNow()
+	res, err := s.collection(mongoScheduledJobs).UpdateOne(s.bind(ctx), bson.D{{Key: "name", Value: job.Name}}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "schedule", Value: job.Schedule},
+			{Key: "enabled", Value: job.Enabled},
+			{Key: "nextrunat", Value: job.NextRunAt},
+			{Key: "updatedat", Value: job.UpdatedAt},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("%w: %s", ErrScheduledJobNotFound, job.Name)
+	}
+	return nil
+}
+
+// LockScheduledJob leases a scheduled job to an instance, unless another instance holds
+// an unexpired lease.
+func (s *MongoStorage) LockScheduledJob(ctx context.Context, name, owner string, now, until time.Time) (*ScheduledJob, error) {
+	job := &ScheduledJob{}
+	err := s.collection(mongoScheduledJobs).FindOneAndUpdate(s.bind(ctx), bson.D{
+		{Key: "name", Value: name},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "lockeduntil", Value: nil}},
+			bson.D{{Key: "lockeduntil", Value: bson.D{{Key: "$lt", Value: now}}}},
+			bson.D{{Key: "lockedby", Value: owner}},
+		}},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "lockedby", Value: owner}, {Key: "lockeduntil", Value: until}}},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := s.GetScheduledJob(ctx, name); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrScheduledJobLocked, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// UnlockScheduledJob releases the lease of a scheduled job after a run.
+func (s *MongoStorage) UnlockScheduledJob(ctx context.Context, name, owner string, lastRunAt, nextRunAt *time.Time) error {
+	set := bson.D{{Key: "lockedby", Value: ""}, {Key: "lockeduntil", Value: nil}}
+	if lastRunAt != nil {
+		set = append(set, bson.E{Key: "lastrunat", Value: *lastRunAt})
+	}
+	if nextRunAt != nil {
+		set = append(set, bson.E{Key: "nextrunat", Value: *nextRunAt})
+	}
+	res, err := s.collection(mongoScheduledJobs).UpdateOne(s.bind(ctx),
+		bson.D{{Key: "name", Value: name}, {Key: "lockedby", Value: owner}},
+		bson.D{{Key: "$set", Value: set}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("%w: %s", ErrScheduledJobLocked, name)
+	}
+	return nil
+}
+
+// CreateJobRun records the start of a run of a scheduled job and fills in its ID and start time.
+func (s *MongoStorage) CreateJobRun(ctx context.Context, run *JobRun) error {
+	id, err := s.nextIDs(ctx, mongoJobRuns, 1)
+	if err != nil {
+		return err
+	}
+
+	run.ID = id
+	run.StartedAt = mongoNow()
+
+	_, err = s.collection(mongoJobRuns).InsertOne(s.bind(ctx), run)
+	return err
+}
+
+// FinishJobRun records the outcome of a run of a scheduled job and fills in its end time.
+func (s *MongoStorage) FinishJobRun(ctx context.Context, run *JobRun) error {
+	finishedAt := mongoNow()
+	run.FinishedAt = &finishedAt
+	_, err := s.collection(mongoJobRuns).UpdateOne(s.bind(ctx), bson.D{{Key: "id", Value: run.ID}}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: run.Status},
+			{Key: "summary", Value: run.Summary},
+			{Key: "error", Value: run.Error},
+			{Key: "finishedat", Value: finishedAt},
+		}},
+	})
+	return err
+}
+
+// GetJobRuns returns the most recent runs of a scheduled job, newest first.
+func (s *MongoStorage) GetJobRuns(ctx context.Context, job string, limit int) ([]*JobRun, error) {
+	cursor, err := s.collection(mongoJobRuns).Find(s.bind(ctx), bson.D{{Key: "job", Value: job}},
+		options.Find().SetSort(bson.D{{Key: "id", Value: -1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []*JobRun{}
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// ReserveIdempotencyKey stores the record of a request sent with an Idempotency-Key,
+// unless the key has an unexpired record, which it returns instead.
+func (s *MongoStorage) ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	now := record.CreatedAt
+	// A Record Deleted Between The Two Queries Frees The Key, So Try Again Once
+	for attempt := 0; ; attempt++ {
+		_, err := s.collection(mongoIdempotencyKeys).ReplaceOne(s.bind(ctx), bson.D{
+			{Key: "key", Value: record.Key},
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: "expiresat", Value: bson.D{{Key: "$lte", Value: now}}}},
+				bson.D{{Key: "status", Value: 0}, {Key: "lockeduntil", Value: bson.D{{Key: "$lte", Value: now}}}},
+			}},
+		}, record, options.Replace().SetUpsert(true))
+		if err == nil {
+			return nil, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		stored := &IdempotencyRecord{}
+		err = s.collection(mongoIdempotencyKeys).FindOne(s.bind(ctx), bson.D{{Key: "key", Value: record.Key}}).Decode(stored)
+		if errors.Is(err, mongo.ErrNoDocuments) && attempt == 0 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return stored, nil
+	}
+}
+
+// CompleteIdempotencyKey stores the response of a request sent with an Idempotency-Key.
+func (s *MongoStorage) CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	res, err := s.collection(mongoIdempotencyKeys).UpdateOne(s.bind(ctx), heldIdempotencyKey(record), bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: record.Status},
+			{Key: "header", Value: record.Header},
+			{Key: "body", Value: record.Body},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes the record of a request sent with an Idempotency-Key
+// that was not answered, unless another request has taken the key over.
+func (s *MongoStorage) ReleaseIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	_, err := s.collection(mongoIdempotencyKeys).DeleteOne(s.bind(ctx), heldIdempotencyKey(record))
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes the records of the requests sent with an
+// Idempotency-Key whose response is no longer replayed.
+func (s *MongoStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	res, err := s.collection(mongoIdempotencyKeys).DeleteMany(s.bind(ctx), bson.D{
+		{Key: "expiresat", Value: bson.D{{Key: "$lte", Value: now}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
+
+// heldIdempotencyKey selects the record of a request still holding its key.
+func heldIdempotencyKey(record *IdempotencyRecord) bson.D {
+	return bson.D{{Key: "key", Value: record.Key}, {Key: "status", Value: 0}, {Key: "lockeduntil", Value: record.LockedUntil}}
+}
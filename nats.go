@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Default NATS Settings
+const (
+	defaultNATSURL            = nats.DefaultURL
+	defaultNATSSubjectPrefix  = "gobank."
+	defaultNATSPublishTimeout = 10 * time.Second
+)
+
+// natsFlushTimeout bounds how long closing the publisher waits for the buffered messages.
+const natsFlushTimeout = 5 * time.Second
+
+// NATSPublisher publishes the outbox events to NATS, on one subject per event type: the
+// subject prefix followed by the type, such as gobank.account.created. With JetStream,
+// every event is acknowledged by the stream capturing its subject and its ID is sent
+// as the message ID, so the stream drops the copies the relay publishes again. With
+// core NATS the events only reach the subscribers connected at the time.
+type NATSPublisher struct {
+	conn      *nats.Conn
+	js        jetstream.JetStream
+	prefix    string
+	timeout   time.Duration
+	transport string
+}
+
+// NewNATSPublisher connects to the configured NATS server. A server that cannot be
+// reached yet is retried in the background, the publishing failing meanwhile.
+//
+// Parameters:
+//   - cfg: The server URL, the subject prefix, the publish timeout, and whether to use JetStream.
+//
+// Returns:
+//   - *NATSPublisher: The publisher, to be closed on shutdown.
+//   - error: An error if the URL or the JetStream context is invalid.
+func NewNATSPublisher(cfg NATSConfig) (*NATSPublisher, error) {
+	conn, err := nats.Connect(cfg.URL,
+		nats.Name("gobank"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			slog.Warn("Disconnected From NATS", "error", err)
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			slog.Info("Reconnected To NATS", "server", conn.ConnectedUrlRedacted())
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &NATSPublisher{conn: conn, prefix: cfg.SubjectPrefix, timeout: cfg.PublishTimeout, transport: eventTransportNATS}
+	if cfg.JetStream {
+		if p.js, err = jetstream.New(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		p.transport = eventTransportJetStream
+	}
+
+	slog.Info("Publishing Domain Events To NATS", "url", conn.ConnectedUrlRedacted(), "subject_prefix", cfg.SubjectPrefix, "jetstream", cfg.JetStream)
+	return p, nil
+}
+
+// Publish sends a batch of events to their subjects and waits for the server to take
+// them, and with JetStream for the stream to store them. On error some events may
+// have been published already: the relay publishes the whole batch again.
+//
+// Parameters:
+//   - ctx: The context of the publishing.
+//   - events: The events to publish, oldest first.
+//
+// Returns:
+//   - error: An error if an event could not be encoded or published.
+func (p *NATSPublisher) Publish(ctx context.Context, events []*OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	if err := p.publish(ctx, events); err != nil {
+		eventPublishFailures.WithLabelValues(p.transport).Inc()
+		return err
+	}
+
+	for _, event := range events {
+		eventsPublished.WithLabelValues(p.transport, event.Type).Inc()
+	}
+	return nil
+}
+
+// publish sends the events one by one with JetStream, or all of them with core NATS
+// before flushing the connection.
+func (p *NATSPublisher) publish(ctx context.Context, events []*OutboxEvent) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		msg := nats.NewMsg(p.prefix + event.Type)
+		msg.Data = data
+		msg.Header.Set("event_id", strconv.Itoa(event.ID))
+		msg.Header.Set("event_type", event.Type)
+		msg.Header.Set("account_id", strconv.Itoa(event.AccountID))
+		msg.Header.Set("dedupe_key", event.DedupeKey())
+
+		// JetStream Would Drop A Replay In Its Duplicate Window, Consumers Dedupe It Instead
+		msgID := strconv.Itoa(event.ID)
+		if event.ReplayID != "" {
+			msg.Header.Set("replay_id", event.ReplayID)
+			msgID += "/replay/" + event.ReplayID
+		}
+
+		if p.js != nil {
+			if _, err := p.js.PublishMsg(ctx, msg, jetstream.WithMsgID(msgID)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := p.conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+
+	if p.js != nil {
+		return nil
+	}
+	return p.conn.FlushWithContext(ctx)
+}
+
+// Close flushes the buffered messages and closes the connection.
+func (p *NATSPublisher) Close() error {
+	err := p.conn.FlushTimeout(natsFlushTimeout)
+	p.conn.Close()
+	if errors.Is(err, nats.ErrConnectionClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/moabdelazem/gobank/money"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Notification Kinds, Each With A Template Per Channel It Is Sent On
+const (
+	NotificationTransferCompleted = "transfer_completed"
+	NotificationLowBalance        = "low_balance"
+	NotificationLargeTransaction  = "large_transaction"
+	NotificationOTP               = "otp"
+	NotificationSecurityAlert     = "security_alert"
+)
+
+// Notification Channels
+const (
+	notificationChannelEmail = "email"
+	notificationChannelSMS   = "sms"
+	notificationChannelPush  = "push"
+)
+
+// notificationQueueSize bounds the events waiting for their notifications; the events
+// arriving while it is full are not notified.
+const notificationQueueSize = 256
+
+// phonePattern matches a phone number in E.164 format, such as +14155550100.
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// notificationsSent and notificationFailures count the notifications delivered and
+// the ones that could not be, registered by newMetricsRegistry.
+var (
+	notificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Subsystem: "notifications", Name: "sent_total",
+		Help: "Number of notifications sent, by channel and kind.",
+	}, []string{"channel", "kind"})
+	notificationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Subsystem: "notifications", Name: "failures_total",
+		Help: "Number of notifications that could not be sent, by channel and kind.",
+	}, []string{"channel", "kind"})
+)
+
+// notificationTemplateFiles holds the notification templates, in one directory per
+// channel and one file per kind. Every template defines a "body" template, and the
+// email and push ones a "subject" template too, the title of a push notification.
+//
+//go:embed templates/email/*.tmpl templates/sms/*.tmpl templates/push/*.tmpl
+var notificationTemplateFiles embed.FS
+
+// notificationTemplates maps a channel, then a notification kind, to its templates.
+var notificationTemplates = map[string]map[string]*template.Template{
+	notificationChannelEmail: loadNotificationTemplates(notificationChannelEmail),
+	notificationChannelSMS:   loadNotificationTemplates(notificationChannelSMS),
+	notificationChannelPush:  loadNotificationTemplates(notificationChannelPush),
+}
+
+// loadNotificationTemplates parses the embedded templates of a channel.
+func loadNotificationTemplates(channel string) map[string]*template.Template {
+	dir := path.Join("templates", channel)
+	files, err := notificationTemplateFiles.ReadDir(dir)
+	if err != nil {
+		fatal("Error Reading Notification Templates", "channel", channel, "error", err)
+	}
+
+	templates := make(map[string]*template.Template, len(files))
+	for _, file := range files {
+		tmpl, err := template.ParseFS(notificationTemplateFiles, path.Join(dir, file.Name()))
+		if err != nil {
+			fatal("Error Parsing Notification Template", "channel", channel, "file", file.Name(), "error", err)
+		}
+		templates[strings.TrimSuffix(file.Name(), ".tmpl")] = tmpl
+	}
+	return templates
+}
+
+// renderNotification renders the notification of a kind on a channel.
+//
+// Parameters:
+//   - channel: The channel the notification is sent on.
+//   - kind: The notification kind, naming its template.
+//   - to: The address, phone number, or device token the notification is sent to.
+//   - data: The data of the template.
+//
+// Returns:
+//   - *Notification: The notification, its account left to the caller.
+//   - error: An error if the kind has no template on the channel or it cannot be rendered.
+func renderNotification(channel, kind, to string, data any) (*Notification, error) {
+	tmpl, ok := notificationTemplates[channel][kind]
+	if !ok {
+		return nil, fmt.Errorf("no %s template for %q", channel, kind)
+	}
+
+	var subject, body strings.Builder
+	if tmpl.Lookup("subject") != nil {
+		if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return nil, err
+	}
+
+	return &Notification{
+		Channel: channel,
+		Kind:    kind,
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimLeft(body.String(), "\n"),
+	}, nil
+}
+
+// NotificationPreferences are the notifications an account holder asked for and where
+// to send them. An account without preferences gets no email nor SMS. The thresholds
+// are in the minor units of the currency of the account, which the notifier sets once
+// it knows it, see denominate.
+type NotificationPreferences struct {
+	AccountID int    `json:"account_id"`
+	Email     string `json:"email"`
+	// TransferConfirmations emails every completed transfer, sent or received.
+	TransferConfirmations bool `json:"transfer_confirmations"`
+	// LowBalanceAlerts emails when an outgoing transfer leaves the balance below LowBalanceThreshold.
+	LowBalanceAlerts    bool        `json:"low_balance_alerts"`
+	LowBalanceThreshold money.Money `json:"low_balance_threshold"`
+	// Phone receives the SMS once verified with a one-time code, see handleVerifyPhoneOTP.
+	Phone         string `json:"phone"`
+	PhoneVerified bool   `json:"phone_verified"`
+	// LargeTransactionAlerts texts every completed transfer of at least LargeTransactionThreshold.
+	LargeTransactionAlerts    bool        `json:"large_transaction_alerts"`
+	LargeTransactionThreshold money.Money `json:"large_transaction_threshold"`
+	UpdatedAt                 time.Time   `json:"updated_at"`
+}
+
+// denominate sets the currency of the thresholds to that of the account.
+func (prefs *NotificationPreferences) denominate(currency string) {
+	prefs.LowBalanceThreshold.Currency = currency
+	prefs.LargeTransactionThreshold.Currency = currency
+}
+
+// address returns where the notifications of a channel are sent, or "" for nowhere.
+func (prefs *NotificationPreferences) address(channel string) string {
+	switch channel {
+	case notificationChannelEmail:
+		return prefs.Email
+	case notificationChannelSMS:
+		if prefs.PhoneVerified {
+			return prefs.Phone
+		}
+	}
+	return ""
+}
+
+// NotificationPreferencesRequest is the body of PUT /api/v1/account/{id}/notifications.
+type NotificationPreferencesRequest struct {
+	Email                     string      `json:"email"`
+	TransferConfirmations     bool        `json:"transfer_confirmations"`
+	LowBalanceAlerts          bool        `json:"low_balance_alerts"`
+	LowBalanceThreshold       money.Money `json:"low_balance_threshold"`
+	Phone                     string      `json:"phone"`
+	LargeTransactionAlerts    bool        `json:"large_transaction_alerts"`
+	LargeTransactionThreshold money.Money `json:"large_transaction_threshold"`
+}
+
+// Validate checks the fields of a notification preferences request.
+func (req *NotificationPreferencesRequest) Validate() []FieldError {
+	var errs []FieldError
+	if req.Email == "" && (req.TransferConfirmations || req.LowBalanceAlerts) {
+		errs = append(errs, FieldError{Field: "email", Code: CodeRequired, Message: "email is required to receive notifications"})
+	} else if req.Email != "" {
+		if addr, err := mail.ParseAddress(req.Email); err != nil || addr.Address != req.Email {
+			errs = append(errs, FieldError{Field: "email", Code: CodeInvalid, Message: "email must be a valid email address"})
+		}
+	}
+	if req.LowBalanceThreshold.IsNegative() {
+		errs = append(errs, FieldError{Field: "low_balance_threshold", Code: CodeInvalid, Message: "low_balance_threshold must not be negative"})
+	}
+	if req.Phone == "" && req.LargeTransactionAlerts {
+		errs = append(errs, FieldError{Field: "phone", Code: CodeRequired, Message: "phone is required to receive SMS alerts"})
+	} else if req.Phone != "" && !phonePattern.MatchString(req.Phone) {
+		errs = append(errs, FieldError{Field: "phone", Code: CodeInvalid, Message: "phone must be in E.164 format, such as +14155550100"})
+	}
+	if req.LargeTransactionThreshold.IsNegative() || (req.LargeTransactionAlerts && req.LargeTransactionThreshold.IsZero()) {
+		errs = append(errs, FieldError{Field: "large_transaction_threshold", Code: CodeInvalid, Message: "large_transaction_threshold must be positive"})
+	}
+	return errs
+}
+
+// Notification is a message for an account holder, ready to be sent.
+type Notification struct {
+	Channel   string
+	Kind      string
+	AccountID int
+	// To is the email address, the phone number, or the device token of the account holder.
+	To string
+	// Platform is the push platform of the device, on the push channel.
+	Platform string
+	// Subject is empty on the channels without one.
+	Subject string
+	Body    string
+}
+
+// NotificationSender delivers the notifications of a channel, see SMTPSender,
+// TwilioSender, and pushSender.
+type NotificationSender interface {
+	Send(ctx context.Context, notification *Notification) error
+}
+
+// notificationStore is the part of the store the notifier reads.
+type notificationStore interface {
+	AccountRepository
+	NotificationRepository
+	PushDeviceRepository
+}
+
+// notifier turns the domain events into the notifications the account holders asked
+// for. The events are queued and handled one at a time in the background, so a slow
+// mail server never delays the outbox relay.
+type notifier struct {
+	store notificationStore
+	// senders holds the sender of every configured channel.
+	senders map[string]NotificationSender
+	// otps holds the codes verifying the phone numbers, see sms.go.
+	otps   *otpStore
+	events chan *OutboxEvent
+}
+
+// newNotifier creates a notifier delivering through the senders of the configured
+// channels. Nothing is sent until Run is called.
+func newNotifier(store notificationStore, senders map[string]NotificationSender) *notifier {
+	return &notifier{
+		store:   store,
+		senders: senders,
+		otps:    newOTPStore(),
+		events:  make(chan *OutboxEvent, notificationQueueSize),
+	}
+}
+
+// Notify queues a published event for its notifications, without blocking: the event
+// is dropped when the queue is full.
+func (n *notifier) Notify(event *OutboxEvent) {
+	select {
+	case n.events <- event:
+	default:
+		slog.Warn("Notification Queue Full, Event Not Notified", "event_id", event.ID, "type", event.Type)
+	}
+}
+
+// Run handles the queued events until ctx is cancelled; the events still queued then
+// are not notified.
+func (n *notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-n.events:
+			n.handle(ctx, event)
+		}
+	}
+}
+
+// transferNotification is the data of the transfer_completed, low_balance, and
+// large_transaction templates.
+type transferNotification struct {
+	Account  *Account
+	Transfer TransferStatusEvent
+	// Sent tells whether the account sent the transfer, rather than received it.
+	Sent      bool
+	Threshold money.Money
+}
+
+// handle sends the notifications of an event to the account it concerns.
+func (n *notifier) handle(ctx context.Context, event *OutboxEvent) {
+	switch event.Type {
+	case EventTransferCompleted:
+		n.handleTransfer(ctx, event)
+	case EventSecurityAlert:
+		n.handleSecurityAlert(ctx, event)
+	}
+}
+
+// handleTransfer sends the notifications of a completed transfer, as the preferences
+// of the account ask: by email, a confirmation and a low balance warning when an
+// outgoing transfer left the balance below the threshold, and by SMS, an alert for a
+// transfer of at least the large transaction threshold. Every push device of the
+// account gets a confirmation.
+func (n *notifier) handleTransfer(ctx context.Context, event *OutboxEvent) {
+	prefs, err := n.store.GetNotificationPreferences(ctx, event.AccountID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error Loading Notification Preferences", "account_id", event.AccountID, "error", err)
+		return
+	}
+	if prefs == nil {
+		prefs = &NotificationPreferences{AccountID: event.AccountID}
+	}
+
+	data := transferNotification{}
+	if err := json.Unmarshal(event.Payload, &data.Transfer); err != nil {
+		slog.ErrorContext(ctx, "Invalid Transfer Event", "event_id", event.ID, "error", err)
+		return
+	}
+	data.Transfer.Amount.Currency = data.Transfer.Currency
+	data.Sent = data.Transfer.FromAccountID == event.AccountID
+
+	// The Thresholds Are In The Currency Of The Account, That Of The Transfer
+	prefs.denominate(data.Transfer.Currency)
+
+	confirm := prefs.TransferConfirmations
+	lowBalance := prefs.LowBalanceAlerts && data.Sent
+	large := prefs.LargeTransactionAlerts && data.Transfer.Amount.Amount >= prefs.LargeTransactionThreshold.Amount
+	devices := n.pushDevices(ctx, event.AccountID)
+	if !confirm && !lowBalance && !large && len(devices) == 0 {
+		return
+	}
+
+	if data.Account, err = n.store.GetAccountById(ctx, event.AccountID); err != nil {
+		slog.ErrorContext(ctx, "Error Loading The Notified Account", "account_id", event.AccountID, "error", err)
+		return
+	}
+	data.Threshold = prefs.LowBalanceThreshold
+
+	if confirm {
+		n.send(ctx, prefs, notificationChannelEmail, NotificationTransferCompleted, data)
+	}
+	if lowBalance && data.Account.Balance.Amount < prefs.LowBalanceThreshold.Amount {
+		n.send(ctx, prefs, notificationChannelEmail, NotificationLowBalance, data)
+	}
+	if large {
+		n.send(ctx, prefs, notificationChannelSMS, NotificationLargeTransaction, data)
+	}
+	n.push(ctx, devices, NotificationTransferCompleted, data, 0)
+}
+
+// send renders the notification of a kind and sends it on a channel, when the channel
+// is configured and the preferences give an address for it, logging the failures.
+func (n *notifier) send(ctx context.Context, prefs *NotificationPreferences, channel, kind string, data any) {
+	to := prefs.address(channel)
+	if to == "" || n.senders[channel] == nil {
+		return
+	}
+
+	notification, err := renderNotification(channel, kind, to, data)
+	if err == nil {
+		notification.AccountID = prefs.AccountID
+		err = n.deliver(ctx, notification)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error Sending Notification", "channel", channel, "kind", kind, "account_id", prefs.AccountID, "error", err)
+	}
+}
+
+// deliver sends a notification through the sender of its channel and counts it.
+func (n *notifier) deliver(ctx context.Context, notification *Notification) error {
+	if err := n.senders[notification.Channel].Send(ctx, notification); err != nil {
+		notificationFailures.WithLabelValues(notification.Channel, notification.Kind).Inc()
+		return err
+	}
+	notificationsSent.WithLabelValues(notification.Channel, notification.Kind).Inc()
+	return nil
+}
+
+// handleGetNotificationPreferences returns the notification preferences of an account,
+// everything off when none were saved.
+func (as *APIServer) handleGetNotificationPreferences(w http.ResponseWriter, r *http.Request) error {
+	id := getId(w, r)
+
+	prefs, err := as.store.GetNotificationPreferences(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if prefs == nil {
+		prefs = &NotificationPreferences{AccountID: id}
+	}
+
+	return WriteResponse(w, r, http.StatusOK, prefs)
+}
+
+// handleSetNotificationPreferences replaces the notification preferences of an account.
+// The phone number stays verified unless it changes, and a change of the email address
+// or the phone number raises a security alert.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the account ID and the preferences.
+//
+// Returns:
+//   - error: A validation error if the body is invalid, or the error of the store.
+func (as *APIServer) handleSetNotificationPreferences(w http.ResponseWriter, r *http.Request) error {
+	prefsReq := new(NotificationPreferencesRequest)
+	if err := bindJSON(w, r, prefsReq); err != nil {
+		return err
+	}
+
+	id := getId(w, r)
+	current, err := as.store.GetNotificationPreferences(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	prefs := &NotificationPreferences{
+		AccountID:                 id,
+		Email:                     prefsReq.Email,
+		TransferConfirmations:     prefsReq.TransferConfirmations,
+		LowBalanceAlerts:          prefsReq.LowBalanceAlerts,
+		LowBalanceThreshold:       prefsReq.LowBalanceThreshold,
+		Phone:                     prefsReq.Phone,
+		PhoneVerified:             current != nil && current.PhoneVerified && current.Phone == prefsReq.Phone,
+		LargeTransactionAlerts:    prefsReq.LargeTransactionAlerts,
+		LargeTransactionThreshold: prefsReq.LargeTransactionThreshold,
+	}
+	if current == nil {
+		current = &NotificationPreferences{}
+	}
+
+	// Store The Preferences And The Security Alert Of A Contact Change Together
+	err = as.store.WithTx(r.Context(), func(tx Storage) error {
+		if err := tx.SetNotificationPreferences(r.Context(), prefs); err != nil {
+			return err
+		}
+		if current.Email == prefs.Email && current.Phone == prefs.Phone {
+			return nil
+		}
+
+		event, err := securityAlert(id, SecurityContactChanged, 0)
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(r.Context(), []*OutboxEvent{event})
+	})
+	if err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusOK, prefs)
+}
+
+// registerNotificationRoutes registers the notification preference endpoints on the
+// /api/v1 subrouter, for the authenticated account holder.
+//
+// Routes:
+// - GET /api/v1/account/{id:[0-9]+}/notifications: Retrieves the notification preferences of an account.
+// - PUT /api/v1/account/{id:[0-9]+}/notifications: Replaces the notification preferences of an account.
+// - POST /api/v1/account/{id:[0-9]+}/notifications/phone/otp: Texts a code verifying the phone number.
+// - POST /api/v1/account/{id:[0-9]+}/notifications/phone/verify: Verifies the phone number with the code.
+func (as *APIServer) registerNotificationRoutes(subRouter *mux.Router) {
+	subRouter.HandleFunc("/account/{id:[0-9]+}/notifications", withJWTAuth(makeHTTPHandlerFunc(as.handleGetNotificationPreferences), as.store)).Methods(http.MethodGet)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/notifications", withJWTAuth(makeHTTPHandlerFunc(as.handleSetNotificationPreferences), as.store)).Methods(http.MethodPut)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/notifications/phone/otp", withJWTAuth(makeHTTPHandlerFunc(as.handleSendPhoneOTP), as.store)).Methods(http.MethodPost)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/notifications/phone/verify", withJWTAuth(makeHTTPHandlerFunc(as.handleVerifyPhoneOTP), as.store)).Methods(http.MethodPost)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Default Onfido Settings
+const (
+	defaultOnfidoAPIURL  = "https://api.eu.onfido.com"
+	defaultOnfidoTimeout = 10 * time.Second
+	// onfidoAPIVersion prefixes the paths of the API.
+	onfidoAPIVersion = "/v3.6"
+	// onfidoSignatureHeader carries the hex HMAC-SHA256 of a webhook event, keyed with the
+	// webhook token.
+	onfidoSignatureHeader = "X-SHA2-Signature"
+	// onfidoResourceWorkflowRun is the resource type of the webhook events of the runs.
+	onfidoResourceWorkflowRun = "workflow_run"
+)
+
+// onfidoStatuses maps the status of an Onfido workflow run to the KYC status it gives
+// the account; the statuses Onfido may add keep the check pending.
+var onfidoStatuses = map[string]string{
+	"awaiting_input": KYCStatusPending,
+	"processing":     KYCStatusPending,
+	"review":         KYCStatusReview,
+	"approved":       KYCStatusApproved,
+	"declined":       KYCStatusDeclined,
+	"abandoned":      KYCStatusFailed,
+	"error":          KYCStatusFailed,
+}
+
+// onfidoWorkflowRun is the part of an Onfido workflow run the checks use.
+type onfidoWorkflowRun struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Reasons explain a declined run, such as document_expired.
+	Reasons []string `json:"reasons"`
+	Error   *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+	// Link is the hosted page where the applicant uploads their documents.
+	Link struct {
+		URL string `json:"url"`
+	} `json:"link"`
+}
+
+// onfidoKYCProvider checks the documents of the applicants with Onfido: every applicant
+// runs the configured Onfido Studio workflow, which the account holder completes on the
+// hosted page of the run.
+type onfidoKYCProvider struct {
+	cfg    OnfidoConfig
+	client *http.Client
+}
+
+// newOnfidoKYCProvider creates the Onfido provider authenticated with the API token of cfg.
+func newOnfidoKYCProvider(cfg OnfidoConfig) *onfidoKYCProvider {
+	return &onfidoKYCProvider{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// Name returns onfido.
+func (p *onfidoKYCProvider) Name() string {
+	return kycProviderOnfido
+}
+
+// Submit creates the Onfido applicant of an account holder and starts the workflow run
+// checking them.
+//
+// Parameters:
+//   - ctx: The context of the requests to Onfido.
+//   - applicant: The account holder to check.
+//
+// Returns:
+//   - *KYCSubmission: The ID of the run, the link where the holder completes it, and its status.
+//   - error: ErrKYCApplicantRejected if Onfido refuses the applicant, another error if it
+//     cannot be reached.
+func (p *onfidoKYCProvider) Submit(ctx context.Context, applicant KYCApplicant) (*KYCSubmission, error) {
+	var created struct {
+		ID string `json:"id"`
+	}
+	err := p.do(ctx, http.MethodPost, "/applicants", map[string]string{
+		"first_name": applicant.FirstName,
+		"last_name":  applicant.LastName,
+	}, &created)
+	if err != nil {
+		return nil, fmt.Errorf("creating the applicant: %w", err)
+	}
+
+	run := &onfidoWorkflowRun{}
+	err = p.do(ctx, http.MethodPost, "/workflow_runs", map[string]interface{}{
+		"workflow_id":  p.cfg.WorkflowID,
+		"applicant_id": created.ID,
+		"tags":         []string{fmt.Sprintf("gobank-account-%d", applicant.AccountID)},
+	}, run)
+	if err != nil {
+		return nil, fmt.Errorf("starting the workflow run: %w", err)
+	}
+	if run.ID == "" {
+		return nil, fmt.Errorf("onfido sent a workflow run without an ID")
+	}
+
+	result := run.result()
+	return &KYCSubmission{ProviderCheckID: run.ID, URL: run.Link.URL, Status: result.Status}, nil
+}
+
+// Status fetches the workflow run of a check.
+//
+// Parameters:
+//   - ctx: The context of the request to Onfido.
+//   - providerCheckID: The ID of the run.
+//
+// Returns:
+//   - *KYCResult: The KYC status of the run, with the reasons of a decline or a failure.
+//   - error: An error if Onfido cannot be reached or does not know the run.
+func (p *onfidoKYCProvider) Status(ctx context.Context, providerCheckID string) (*KYCResult, error) {
+	run := &onfidoWorkflowRun{}
+	if err := p.do(ctx, http.MethodGet, "/workflow_runs/"+url.PathEscape(providerCheckID), nil, run); err != nil {
+		return nil, fmt.Errorf("fetching the workflow run: %w", err)
+	}
+	return run.result(), nil
+}
+
+// ParseWebhook returns the workflow run an Onfido webhook event is about, its
+// X-SHA2-Signature verified by withWebhookSignature. The status in the event is not
+// trusted: the caller fetches the run, so that events delivered out of order cannot
+// move a check backwards.
+//
+// Parameters:
+//   - body: The raw body of the request.
+//
+// Returns:
+//   - string: The ID of the run, empty for an event about another resource.
+//   - error: An error for a body that is not an event.
+func (p *onfidoKYCProvider) ParseWebhook(body []byte) (string, error) {
+	var event struct {
+		Payload struct {
+			ResourceType string `json:"resource_type"`
+			Action       string `json:"action"`
+			Object       struct {
+				ID string `json:"id"`
+			} `json:"object"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return "", err
+	}
+	if event.Payload.ResourceType != onfidoResourceWorkflowRun {
+		return "", nil
+	}
+	return event.Payload.Object.ID, nil
+}
+
+// result maps a workflow run to the KYC result of its check.
+func (run *onfidoWorkflowRun) result() *KYCResult {
+	status, known := onfidoStatuses[run.Status]
+	if !known {
+		status = KYCStatusPending
+	}
+
+	result := &KYCResult{Status: status}
+	switch {
+	case run.Error != nil && run.Error.Message != "":
+		result.Reason = run.Error.Message
+	case len(run.Reasons) > 0:
+		result.Reason = strings.Join(run.Reasons, ", ")
+	case run.Status == "abandoned":
+		result.Reason = "the applicant abandoned the check"
+	}
+	return result
+}
+
+// do sends a request to the Onfido API and decodes its response into out.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - method: The HTTP method.
+//   - path: The path, after the API version.
+//   - in: The JSON body, nil for none.
+//   - out: Where the JSON response is decoded.
+//
+// Returns:
+//   - error: ErrKYCApplicantRejected wrapped with the reason when Onfido refuses the
+//     request as invalid, another error if it cannot be reached or fails.
+func (p *onfidoKYCProvider) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(p.cfg.APIURL, "/")+onfidoAPIVersion+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token token="+p.cfg.APIToken)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody := io.LimitReader(resp.Body, 1<<20)
+	if resp.StatusCode/100 != 2 {
+		var apiErr struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(respBody).Decode(&apiErr)
+		// The Applicant Is Invalid, Sending It Again Would Not Help
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			return fmt.Errorf("%w: %s", ErrKYCApplicantRejected, apiErr.Error.Message)
+		}
+		return fmt.Errorf("onfido refused the request with status %d: %s (%s)", resp.StatusCode, apiErr.Error.Message, apiErr.Error.Type)
+	}
+
+	if err := json.NewDecoder(respBody).Decode(out); err != nil {
+		return fmt.Errorf("decoding the response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/moabdelazem/gobank/money"
+)
+
+// Consent Scopes, Each Opening One Read-Only Endpoint Of The Open Banking API
+const (
+	ConsentScopeAccounts     = "accounts"
+	ConsentScopeBalances     = "balances"
+	ConsentScopeTransactions = "transactions"
+)
+
+// consentScopes lists the scopes a consent can grant.
+var consentScopes = []string{ConsentScopeAccounts, ConsentScopeBalances, ConsentScopeTransactions}
+
+// Default Open Banking Settings
+const (
+	// defaultMaxConsentLifetime is the 90 days after which PSD2 asks the account holder
+	// to authorize an aggregator again.
+	defaultMaxConsentLifetime = 90 * 24 * time.Hour
+	consentClientNameMaxLen   = 100
+)
+
+// Consent is the permission an account holder gives an aggregator to read some of the
+// data of the account, until it expires or is revoked. The aggregator presents its
+// token to the open banking API.
+type Consent struct {
+	ID        int `json:"id"`
+	AccountID int `json:"account_id"`
+	// ClientName names the aggregator, as shown to the account holder.
+	ClientName string     `json:"client_name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// Token is the bearer token of the aggregator; it is only returned on creation and
+	// never stored.
+	Token string `json:"token,omitempty" bson:"-"`
+}
+
+// Active reports whether the consent still grants access at now.
+func (c *Consent) Active(now time.Time) bool {
+	return c.RevokedAt == nil && now.Before(c.ExpiresAt)
+}
+
+// CreateConsentRequest is the body of POST /api/v1/account/{id}/consents.
+type CreateConsentRequest struct {
+	ClientName string   `json:"client_name"`
+	Scopes     []string `json:"scopes"`
+	// ExpiresAt defaults to the longest lifetime allowed, checked by handleCreateConsent.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Validate checks the fields of a consent request.
+func (req *CreateConsentRequest) Validate() []FieldError {
+	var errs []FieldError
+	req.ClientName = strings.TrimSpace(req.ClientName)
+	if req.ClientName == "" {
+		errs = append(errs, FieldError{Field: "client_name", Code: CodeRequired, Message: "client_name is required"})
+	} else if len(req.ClientName) > consentClientNameMaxLen {
+		errs = append(errs, FieldError{Field: "client_name", Code: CodeTooLong, Message: fmt.Sprintf("client_name must be at most %d characters", consentClientNameMaxLen)})
+	}
+	if len(req.Scopes) == 0 {
+		errs = append(errs, FieldError{Field: "scopes", Code: CodeRequired, Message: "scopes is required"})
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(consentScopes, scope) {
+			errs = append(errs, FieldError{Field: "scopes", Code: CodeInvalid, Message: fmt.Sprintf("unknown scope %q, the scopes are %s", scope, strings.Join(consentScopes, ", "))})
+		}
+	}
+	return errs
+}
+
+// ConsentsResponse is the response of GET /api/v1/account/{id}/consents.
+type ConsentsResponse struct {
+	Data []*Consent `json:"data"`
+}
+
+// OpenBankingAccount is an account as shown to the aggregators.
+type OpenBankingAccount struct {
+	AccountID  int    `json:"account_id"`
+	Number     int64  `json:"number"`
+	HolderName string `json:"holder_name"`
+	// Status is enabled, or frozen while the account cannot move money.
+	Status   string    `json:"status"`
+	OpenedAt time.Time `json:"opened_at"`
+}
+
+// OpenBankingBalance is the booked balance of an account, as of the time of the request.
+type OpenBankingBalance struct {
+	AccountID int         `json:"account_id"`
+	Amount    money.Money `json:"amount"`
+	Currency  string      `json:"currency"`
+	AsOf      time.Time   `json:"as_of"`
+}
+
+// OpenBankingTransaction is a ledger entry as shown to the aggregators.
+type OpenBankingTransaction struct {
+	TransactionID int         `json:"transaction_id"`
+	Amount        money.Money `json:"amount"`
+	// CreditDebit is credit for the money received, debit for the money sent.
+	CreditDebit  string      `json:"credit_debit"`
+	BalanceAfter money.Money `json:"balance_after"`
+	Currency     string      `json:"currency"`
+	Type         string      `json:"type"`
+	Category     string      `json:"category,omitempty"`
+	Reference    string      `json:"reference,omitempty"`
+	BookedAt     time.Time   `json:"booked_at"`
+}
+
+// OpenBankingResponse is the envelope of the responses of the open banking API.
+type OpenBankingResponse struct {
+	Data interface{} `json:"data"`
+}
+
+// consentFunc is a handler of the open banking API, called with the consent of its token.
+type consentFunc func(w http.ResponseWriter, r *http.Request, consent *Consent) error
+
+// createConsentToken signs the bearer token of a consent. It carries no account number,
+// so withJWTAuth refuses it, and it expires with the consent.
+func createConsentToken(consent *Consent) (string, error) {
+	claims := jwt.MapClaims{
+		"consent_id": consent.ID,
+		"scope":      strings.Join(consent.Scopes, " "),
+		"exp":        consent.ExpiresAt.Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(JWTSecret))
+}
+
+// withConsentAuth authenticates a request of the open banking API by its consent token:
+// the consent must be active, grant scope, and, on the routes of an account, be given by
+// that account. The consent is looked up on every request, so a revoked one stops
+// working at once.
+//
+// Parameters:
+//   - scope: The scope the endpoint requires.
+//   - handler: The endpoint, called with the consent.
+//
+// Returns:
+//   - apiFunc: The endpoint, answering with a 401 for a missing, invalid, expired, or
+//     revoked consent, and a 403 for a consent without scope or an account without the
+//     open_banking feature.
+func (as *APIServer) withConsentAuth(scope string, handler consentFunc) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		invalid := NewTypedError(http.StatusUnauthorized, "consent_invalid", "the consent token is invalid, expired, or revoked")
+
+		tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return invalid
+		}
+		token, err := validateJWTToken(tokenString)
+		if err != nil || !token.Valid {
+			return invalid
+		}
+		claims, ok := token.Claims.(jwt.MapClaims)
+		consentID, isNumber := claims["consent_id"].(float64)
+		if !ok || !isNumber {
+			return invalid
+		}
+
+		consent, err := as.store.GetConsent(r.Context(), int(consentID))
+		if errors.Is(err, ErrConsentNotFound) {
+			return invalid
+		}
+		if err != nil {
+			return err
+		}
+		if !consent.Active(time.Now()) {
+			return invalid
+		}
+		if id, ok := mux.Vars(r)["id"]; ok && id != strconv.Itoa(consent.AccountID) {
+			return invalid
+		}
+		if !slices.Contains(consent.Scopes, scope) {
+			return NewTypedError(http.StatusForbidden, "consent_scope_missing", fmt.Sprintf("the consent does not grant the %s scope", scope))
+		}
+		if !as.featureFlags.Enabled(FeatureOpenBanking, consent.AccountID) {
+			return NewTypedError(http.StatusForbidden, "open_banking_disabled", "open banking is not enabled for this account")
+		}
+
+		recordRequestAccount(r.Context(), consent.AccountID)
+		return handler(w, r, consent)
+	}
+}
+
+// handleCreateConsent gives an aggregator access to some of the data of an account, and
+// returns the token it reads the data with. The other devices of the account holder are
+// alerted.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the aggregator, the scopes, and the expiry.
+//
+// Returns:
+//   - error: A validation error for an invalid request or expiry, or a 403 for an
+//     account without the open_banking feature.
+func (as *APIServer) handleCreateConsent(w http.ResponseWriter, r *http.Request) error {
+	consentReq := new(CreateConsentRequest)
+	if err := bindJSON(w, r, consentReq); err != nil {
+		return err
+	}
+
+	id := getId(w, r)
+	if !as.featureFlags.Enabled(FeatureOpenBanking, id) {
+		return NewTypedError(http.StatusForbidden, "open_banking_disabled", "open banking is not enabled for this account")
+	}
+
+	now := time.Now().UTC()
+	maxLifetime := as.config.OpenBanking.MaxConsentLifetime
+	expiresAt := now.Add(maxLifetime)
+	if consentReq.ExpiresAt != nil {
+		expiresAt = consentReq.ExpiresAt.UTC()
+		if !expiresAt.After(now) || expiresAt.After(now.Add(maxLifetime)) {
+			return newValidationError([]FieldError{{Field: "expires_at", Code: CodeInvalid, Message: fmt.Sprintf("expires_at must be in the future and at most %s away", maxLifetime)}})
+		}
+	}
+
+	// Keep The Scopes In Their Canonical Order, Once Each
+	var scopes []string
+	for _, scope := range consentScopes {
+		if slices.Contains(consentReq.Scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	consent := &Consent{AccountID: id, ClientName: consentReq.ClientName, Scopes: scopes, ExpiresAt: expiresAt.Truncate(time.Second)}
+	err := as.store.WithTx(r.Context(), func(tx Storage) error {
+		if err := tx.CreateConsent(r.Context(), consent); err != nil {
+			return err
+		}
+
+		event, err := securityAlert(id, SecurityConsentGranted, 0)
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(r.Context(), []*OutboxEvent{event})
+	})
+	if err != nil {
+		return err
+	}
+
+	if consent.Token, err = createConsentToken(consent); err != nil {
+		return err
+	}
+	return WriteResponse(w, r, http.StatusCreated, consent)
+}
+
+// handleGetConsents lists the consents of an account, revoked and expired ones included.
+func (as *APIServer) handleGetConsents(w http.ResponseWriter, r *http.Request) error {
+	consents, err := as.store.GetConsents(r.Context(), getId(w, r))
+	if err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusOK, ConsentsResponse{Data: consents})
+}
+
+// handleRevokeConsent revokes a consent of an account, its token stopping to work at once.
+func (as *APIServer) handleRevokeConsent(w http.ResponseWriter, r *http.Request) error {
+	consentID, err := strconv.Atoi(mux.Vars(r)["consent"])
+	if err != nil {
+		return NewTypedError(http.StatusBadRequest, "bad_request", "invalid consent ID")
+	}
+
+	if err := as.store.RevokeConsent(r.Context(), getId(w, r), consentID); err != nil {
+		return err
+	}
+
+	consent, err := as.store.GetConsent(r.Context(), consentID)
+	if err != nil {
+		return err
+	}
+	return WriteResponse(w, r, http.StatusOK, consent)
+}
+
+// handleOpenBankingAccounts lists the account of the consent.
+func (as *APIServer) handleOpenBankingAccounts(w http.ResponseWriter, r *http.Request, consent *Consent) error {
+	account, err := as.store.GetAccountById(r.Context(), consent.AccountID)
+	if err != nil {
+		return err
+	}
+
+	status := "enabled"
+	if account.Frozen {
+		status = "frozen"
+	}
+	return WriteResponse(w, r, http.StatusOK, OpenBankingResponse{Data: []OpenBankingAccount{{
+		AccountID:  account.ID,
+		Number:     account.Number,
+		HolderName: strings.TrimSpace(account.FirstName + " " + account.LastName),
+		Status:     status,
+		OpenedAt:   account.CreatedAt,
+	}}})
+}
+
+// handleOpenBankingBalances returns the balance of the account of the consent.
+func (as *APIServer) handleOpenBankingBalances(w http.ResponseWriter, r *http.Request, consent *Consent) error {
+	account, err := as.store.GetAccountById(r.Context(), consent.AccountID)
+	if err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusOK, OpenBankingResponse{Data: []OpenBankingBalance{{
+		AccountID: account.ID,
+		Amount:    account.Balance,
+		Currency:  account.Currency,
+		AsOf:      time.Now().UTC().Truncate(time.Second),
+	}}})
+}
+
+// handleOpenBankingTransactions returns the ledger entries of the account of the
+// consent, filtered as GET /api/v1/account/{id}/transactions is.
+func (as *APIServer) handleOpenBankingTransactions(w http.ResponseWriter, r *http.Request, consent *Consent) error {
+	filter, err := parseTransactionFilter(r)
+	if err != nil {
+		return err
+	}
+
+	txns, err := as.store.GetTransactions(r.Context(), consent.AccountID, filter)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]OpenBankingTransaction, 0, len(txns))
+	for _, t := range txns {
+		entry := OpenBankingTransaction{
+			TransactionID: t.ID,
+			Amount:        t.Amount,
+			CreditDebit:   "credit",
+			BalanceAfter:  t.BalanceAfter,
+			Currency:      t.Currency,
+			Type:          t.Type,
+			Category:      t.Category,
+			Reference:     t.Reference,
+			BookedAt:      t.CreatedAt,
+		}
+		if t.Amount.IsNegative() {
+			entry.Amount, entry.CreditDebit = t.Amount.Neg(), "debit"
+		}
+		entries = append(entries, entry)
+	}
+	return WriteResponse(w, r, http.StatusOK, OpenBankingResponse{Data: entries})
+}
+
+// registerOpenBankingRoutes registers the consent endpoints of the account holders on
+// the /api/v1 subrouter, and the read-only open banking API of the aggregators, which
+// authenticate with the token of a consent, see withConsentAuth. Both are limited to
+// the accounts with the open_banking feature.
+//
+// Routes:
+// - GET /api/v1/account/{id:[0-9]+}/consents: Lists the consents of an account.
+// - POST /api/v1/account/{id:[0-9]+}/consents: Gives an aggregator access, returning its token.
+// - DELETE /api/v1/account/{id:[0-9]+}/consents/{consent:[0-9]+}: Revokes a consent.
+// - GET /open-banking/v1/accounts: Lists the account of the consent, with the accounts scope.
+// - GET /open-banking/v1/accounts/{id:[0-9]+}/balances: Returns the balance, with the balances scope.
+// - GET /open-banking/v1/accounts/{id:[0-9]+}/transactions: Returns the ledger entries, with the transactions scope.
+func (as *APIServer) registerOpenBankingRoutes(router, subRouter *mux.Router) {
+	subRouter.HandleFunc("/account/{id:[0-9]+}/consents", withJWTAuth(makeHTTPHandlerFunc(as.handleGetConsents), as.store)).Methods(http.MethodGet)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/consents", withJWTAuth(makeHTTPHandlerFunc(as.handleCreateConsent), as.store)).Methods(http.MethodPost)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/consents/{consent:[0-9]+}", withJWTAuth(makeHTTPHandlerFunc(as.handleRevokeConsent), as.store)).Methods(http.MethodDelete)
+
+	aisRouter := router.PathPrefix("/open-banking/v1").Subrouter()
+	aisRouter.HandleFunc("/accounts", makeHTTPHandlerFunc(as.withConsentAuth(ConsentScopeAccounts, as.handleOpenBankingAccounts))).Methods(http.MethodGet)
+	aisRouter.HandleFunc("/accounts/{id:[0-9]+}/balances", makeHTTPHandlerFunc(as.withConsentAuth(ConsentScopeBalances, as.handleOpenBankingBalances))).Methods(http.MethodGet)
+	aisRouter.HandleFunc("/accounts/{id:[0-9]+}/transactions", makeHTTPHandlerFunc(as.withConsentAuth(ConsentScopeTransactions, as.handleOpenBankingTransactions))).Methods(http.MethodGet)
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Default Outbox Relay Settings
+const (
+	defaultOutboxPollInterval = time.Second
+	outboxBatchSize           = 100
+	// outboxReplayMaxEvents bounds the events re-published by one replay request; a
+	// longer replay is continued with the after_id of the previous response.
+	outboxReplayMaxEvents = 10_000
+	// AuditActionOutboxReplay records an operator re-publishing outbox events.
+	AuditActionOutboxReplay = "outbox.replay"
+)
+
+// OutboxEvent is a domain event stored in the outbox table in the same database
+// transaction as the change it describes, and published by the outbox relay once
+// that transaction is committed.
+type OutboxEvent struct {
+	ID          int             `json:"id"`
+	Type        string          `json:"type"`
+	AccountID   int             `json:"account_id"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"created_at"`
+	PublishedAt *time.Time      `json:"published_at,omitempty"`
+	// ReplayID marks the copies of the event re-published by a replay, see
+	// handleAdminReplayOutbox; it is not stored.
+	ReplayID string `json:"replay_id,omitempty" bson:"-"`
+}
+
+// DedupeKey identifies the event to its consumers, the same on every delivery of it,
+// replays included, so that they can apply it once.
+func (e *OutboxEvent) DedupeKey() string {
+	return "gobank-event-" + strconv.Itoa(e.ID)
+}
+
+// OutboxEventFilter selects the published events of a replay. Unset fields do not
+// filter, set fields are combined with AND.
+type OutboxEventFilter struct {
+	AccountID int
+	// CreatedAfter is the inclusive lower bound of the creation time.
+	CreatedAfter time.Time
+	// CreatedBefore is the exclusive upper bound of the creation time.
+	CreatedBefore time.Time
+}
+
+// matches reports whether an event is selected by the filter.
+func (f OutboxEventFilter) matches(event *OutboxEvent) bool {
+	return (f.AccountID == 0 || event.AccountID == f.AccountID) &&
+		(f.CreatedAfter.IsZero() || !event.CreatedAt.Before(f.CreatedAfter)) &&
+		(f.CreatedBefore.IsZero() || event.CreatedAt.Before(f.CreatedBefore))
+}
+
+// errOutboxNotPublished rolls back the transaction of a batch the event bus refused.
+// The error of the bus is reported apart, so the circuit breaker of the database does
+// not take a broker that cannot be reached for the database.
+var errOutboxNotPublished = errors.New("outbox events not published")
+
+// EventPublisher publishes the outbox events to an external event bus, for the systems
+// consuming them downstream. An event is published at least once.
+type EventPublisher interface {
+	// Publish publishes a batch of events, oldest first.
+	Publish(ctx context.Context, events []*OutboxEvent) error
+	// Close flushes the pending events and releases the connections.
+	Close() error
+}
+
+// Event Transports, Selected By events.transport
+const (
+	eventTransportNone      = "none"
+	eventTransportKafka     = "kafka"
+	eventTransportNATS      = "nats"
+	eventTransportJetStream = "jetstream"
+)
+
+// eventTransports lists the valid values of events.transport; JetStream is chosen with
+// events.nats.jetstream.
+var eventTransports = []string{eventTransportNone, eventTransportKafka, eventTransportNATS}
+
+// eventsPublished and eventPublishFailures count the events published to the external
+// event bus and the batches that failed, replays included, and eventsReplayed the events
+// re-published by a replay, registered by newMetricsRegistry.
+var (
+	eventsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Subsystem: "events", Name: "published_total",
+		Help: "Number of domain events published to the external event bus, by transport and event type.",
+	}, []string{"transport", "type"})
+	eventPublishFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Subsystem: "events", Name: "publish_failures_total",
+		Help: "Number of batches of domain events that could not be published, by transport.",
+	}, []string{"transport"})
+	eventsReplayed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Subsystem: "events", Name: "replayed_total",
+		Help: "Number of domain events re-published to the external event bus by a replay, by event type.",
+	}, []string{"type"})
+)
+
+// newEventPublisher creates the publisher of the configured transport, or none: the
+// events then only reach the in-process subscribers.
+//
+// Parameters:
+//   - cfg: The transport and its settings.
+//
+// Returns:
+//   - EventPublisher: The publisher, nil for the none transport.
+//   - error: An error if the publisher cannot be created.
+func newEventPublisher(cfg EventsConfig) (EventPublisher, error) {
+	switch cfg.Transport {
+	case eventTransportKafka:
+		return NewKafkaPublisher(cfg.Kafka), nil
+	case eventTransportNATS:
+		return NewNATSPublisher(cfg.NATS)
+	}
+	return nil, nil
+}
+
+// newOutboxEvent creates an outbox event for an account with the JSON encoding of data as its payload.
+//
+// Parameters:
+//   - eventType: The type of the event, such as EventAccountCreated.
+//   - accountID: The account whose subscribers receive the event.
+//   - data: The payload of the event.
+//
+// Returns:
+//   - *OutboxEvent: The event, ready to be stored with AddOutboxEvents.
+//   - error: An error if the payload cannot be encoded.
+func newOutboxEvent(eventType string, accountID int, data interface{}) (*OutboxEvent, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxEvent{Type: eventType, AccountID: accountID, Payload: payload}, nil
+}
+
+// runOutboxRelay publishes the committed outbox events to the external event bus, if
+// any, and to the event broker every outbox poll interval until ctx is cancelled.
+func (as *APIServer) runOutboxRelay(ctx context.Context) {
+	ticker := time.NewTicker(as.config.Jobs.OutboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Drain The Backlog Before Waiting Again
+		for {
+			relayed, err := as.relayOutbox(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error Relaying Outbox Events", "error", err)
+				break
+			}
+			if relayed < outboxBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// relayOutbox publishes a batch of unpublished outbox events, oldest first, and marks
+// them published in the same transaction that claimed them. An event is published at
+// least once: if publishing to the external event bus or marking fails, the batch is
+// published again on the next run.
+//
+// Parameters:
+//   - ctx: The context of the database work.
+//
+// Returns:
+//   - int: The number of events published.
+//   - error: An error if the events cannot be read or marked published.
+func (as *APIServer) relayOutbox(ctx context.Context) (int, error) {
+	var published []*OutboxEvent
+	var publishErr error
+
+	err := as.store.WithTx(ctx, func(tx Storage) error {
+		events, err := tx.GetUnpublishedOutboxEvents(ctx, outboxBatchSize)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		// A Batch The Bus Refused Stays Unpublished, Subscribers Included
+		if as.publisher != nil {
+			if publishErr = as.publisher.Publish(ctx, events); publishErr != nil {
+				return errOutboxNotPublished
+			}
+		}
+
+		ids := make([]int, 0, len(events))
+		for _, event := range events {
+			as.events.Publish(AccountEvent{
+				Type:      event.Type,
+				AccountID: event.AccountID,
+				Data:      event.Payload,
+				CreatedAt: event.CreatedAt,
+			})
+			ids = append(ids, event.ID)
+		}
+
+		published = events
+		return tx.MarkOutboxEventsPublished(ctx, ids)
+	})
+
+	if publishErr != nil {
+		return 0, fmt.Errorf("publishing the outbox events: %w", publishErr)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Notify Only The Committed Events, So A Retried Batch Is Not Notified Twice
+	if as.notifier != nil {
+		for _, event := range published {
+			as.notifier.Notify(event)
+		}
+	}
+	return len(published), nil
+}
+
+// OutboxReplayRequest is the body of POST /admin/outbox/replay, selecting the published
+// events to re-publish by account, by creation time range, or both.
+type OutboxReplayRequest struct {
+	AccountID int `json:"account_id"`
+	// From and To bound the creation time of the events, inclusive and exclusive.
+	From *time.Time `json:"from"`
+	To   *time.Time `json:"to"`
+	// AfterID continues a replay after the last event of its previous response.
+	AfterID int `json:"after_id"`
+}
+
+// Validate checks the fields of an outbox replay request.
+func (req *OutboxReplayRequest) Validate() []FieldError {
+	var errs []FieldError
+	if req.AccountID == 0 && req.From == nil && req.To == nil {
+		errs = append(errs, FieldError{Field: "account_id", Code: CodeRequired, Message: "account_id, from, or to is required, a replay of the whole outbox must be asked for with a range"})
+	}
+	if req.AccountID < 0 {
+		errs = append(errs, FieldError{Field: "account_id", Code: CodeInvalid, Message: "account_id must be positive"})
+	}
+	if req.From != nil && req.To != nil && !req.From.Before(*req.To) {
+		errs = append(errs, FieldError{Field: "to", Code: CodeInvalid, Message: "to must be after from"})
+	}
+	if req.AfterID < 0 {
+		errs = append(errs, FieldError{Field: "after_id", Code: CodeInvalid, Message: "after_id must not be negative"})
+	}
+	return errs
+}
+
+// filter returns the outbox filter of the request.
+func (req *OutboxReplayRequest) filter() OutboxEventFilter {
+	filter := OutboxEventFilter{AccountID: req.AccountID}
+	if req.From != nil {
+		filter.CreatedAfter = req.From.UTC()
+	}
+	if req.To != nil {
+		filter.CreatedBefore = req.To.UTC()
+	}
+	return filter
+}
+
+// OutboxReplayResponse is the response of POST /admin/outbox/replay.
+type OutboxReplayResponse struct {
+	// ReplayID marks the re-published copies, in their replay_id.
+	ReplayID string `json:"replay_id"`
+	Replayed int    `json:"replayed"`
+	// LastEventID is the ID of the last event re-published, the after_id continuing the
+	// replay when it is not Complete.
+	LastEventID int `json:"last_event_id,omitempty"`
+	// Complete is false when the replay stopped at outboxReplayMaxEvents.
+	Complete bool `json:"complete"`
+}
+
+// replayOutbox re-publishes the published outbox events selected by filter to the
+// external event bus, oldest first, marked with replayID. The in-process subscribers
+// and the notifications are left out, they did not miss the events.
+//
+// Parameters:
+//   - ctx: The context of the replay; cancelling it stops the replay after a batch.
+//   - filter: The events to re-publish.
+//   - afterID: The ID after which the replay starts, 0 for the first event.
+//   - replayID: The ID the copies are marked with.
+//
+// Returns:
+//   - *OutboxReplayResponse: The number of events re-published and where the replay stopped.
+//   - error: An error if the events cannot be read or published; the batches before it were published.
+func (as *APIServer) replayOutbox(ctx context.Context, filter OutboxEventFilter, afterID int, replayID string) (*OutboxReplayResponse, error) {
+	resp := &OutboxReplayResponse{ReplayID: replayID, LastEventID: afterID}
+
+	for resp.Replayed < outboxReplayMaxEvents {
+		events, err := as.store.GetPublishedOutboxEvents(ctx, filter, resp.LastEventID, min(outboxBatchSize, outboxReplayMaxEvents-resp.Replayed))
+		if err != nil {
+			return resp, err
+		}
+		if len(events) == 0 {
+			resp.Complete = true
+			return resp, nil
+		}
+
+		for _, event := range events {
+			event.ReplayID = replayID
+		}
+		if err := as.publisher.Publish(ctx, events); err != nil {
+			return resp, fmt.Errorf("publishing the outbox events: %w", err)
+		}
+
+		for _, event := range events {
+			eventsReplayed.WithLabelValues(event.Type).Inc()
+		}
+		resp.Replayed += len(events)
+		resp.LastEventID = events[len(events)-1].ID
+	}
+
+	// Tell A Replay That Ended On The Bound From One That Has More To Do
+	next, err := as.store.GetPublishedOutboxEvents(ctx, filter, resp.LastEventID, 1)
+	if err != nil {
+		return resp, err
+	}
+	resp.Complete = len(next) == 0
+	return resp, nil
+}
+
+// handleAdminReplayOutbox re-publishes the published outbox events of an account, of a
+// period, or both, to the external event bus, for consumers that lost them in an outage.
+// The copies keep the ID and the dedupe_key header of the originals, so consumers that
+// already applied an event recognize it, and carry the replay_id of the request. At most
+// outboxReplayMaxEvents are re-published per request; when the response is not complete,
+// the replay continues with its last_event_id as after_id. The replay is recorded in the
+// audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the OutboxReplayRequest.
+//
+// Returns:
+//   - error: A 400 for an invalid request, a 503 without an event bus, or the error that
+//     stopped the replay.
+func (as *APIServer) handleAdminReplayOutbox(w http.ResponseWriter, r *http.Request) error {
+	req := OutboxReplayRequest{}
+	if err := bindJSON(w, r, &req); err != nil {
+		return err
+	}
+
+	if as.publisher == nil {
+		return NewTypedError(http.StatusServiceUnavailable, "event_bus_unavailable", "no event bus is configured to replay the events to")
+	}
+
+	filter := req.filter()
+	replayID := requestIDFromContext(r.Context())
+	resp, err := as.replayOutbox(r.Context(), filter, req.AfterID, replayID)
+
+	details := map[string]interface{}{"replay_id": replayID, "account_id": filter.AccountID, "after_id": req.AfterID, "replayed": resp.Replayed, "complete": resp.Complete}
+	if !filter.CreatedAfter.IsZero() {
+		details["from"] = filter.CreatedAfter.Format(time.RFC3339)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		details["to"] = filter.CreatedBefore.Format(time.RFC3339)
+	}
+	as.audit(r, AuditActionOutboxReplay, "outbox", details)
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Outbox Replay Stopped", "replayed", resp.Replayed, "last_event_id", resp.LastEventID, "error", err)
+		return err
+	}
+	slog.InfoContext(r.Context(), "Outbox Events Replayed", "replayed", resp.Replayed, "last_event_id", resp.LastEventID, "complete", resp.Complete)
+	return WriteResponse(w, r, http.StatusOK, resp)
+}
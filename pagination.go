@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Default Pagination Settings, Overridable With PAGE_DEFAULT_LIMIT And PAGE_MAX_LIMIT
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// Page describes the position of a page inside a list response.
+// Offset paginated lists report the Offset and the Total number of items, cursor
+// paginated lists report the Cursor the page started at. Next is the URL of the
+// following page and is empty on the last page.
+type Page struct {
+	Limit  int    `json:"limit"`
+	Offset *int   `json:"offset,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+	Total  *int   `json:"total,omitempty"`
+	Next   string `json:"next,omitempty"`
+}
+
+// PageEnvelope is the response shape of every paginated list endpoint.
+type PageEnvelope struct {
+	Data interface{} `json:"data"`
+	Page Page        `json:"page"`
+}
+
+// pageLimits holds the server-side page size settings.
+type pageLimits struct {
+	defaultLimit int
+	maxLimit     int
+}
+
+// newPageLimits returns the page size settings of the server. The default limit never
+// exceeds the maximum.
+func newPageLimits(cfg ServerConfig) pageLimits {
+	limits := pageLimits{
+		defaultLimit: cfg.PageDefaultLimit,
+		maxLimit:     cfg.PageMaxLimit,
+	}
+	if limits.defaultLimit > limits.maxLimit {
+		limits.defaultLimit = limits.maxLimit
+	}
+	return limits
+}
+
+// parsePagination reads the "limit" and "offset" query parameters, applying the
+// default limit when missing and rejecting values outside the allowed range.
+//
+// Parameters:
+//   - r: *http.Request containing the pagination query parameters.
+//
+// Returns:
+//   - int: The page size.
+//   - int: The number of items to skip.
+//   - error: A TypedError if either parameter is invalid.
+func (as *APIServer) parsePagination(r *http.Request) (int, int, error) {
+	limit, offset := as.pageLimits.defaultLimit, 0
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > as.pageLimits.maxLimit {
+			return 0, 0, NewTypedError(http.StatusBadRequest, "invalid_limit", fmt.Sprintf("limit must be between 1 and %d", as.pageLimits.maxLimit))
+		}
+		limit = parsed
+	}
+
+	if value := r.URL.Query().Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, NewTypedError(http.StatusBadRequest, "invalid_offset", "offset must be a non-negative integer")
+		}
+		offset = parsed
+	}
+
+	return limit, offset, nil
+}
+
+// newOffsetPage describes a page of an offset paginated list, linking the next page
+// while items remain.
+func newOffsetPage(r *http.Request, limit, offset, total int) Page {
+	page := Page{Limit: limit, Offset: &offset, Total: &total}
+	if offset+limit < total {
+		page.Next = pageURL(r, map[string]string{
+			"limit":  strconv.Itoa(limit),
+			"offset": strconv.Itoa(offset + limit),
+		})
+	}
+	return page
+}
+
+// newCursorPage describes a page of a cursor paginated list. nextCursor is empty on the last page.
+func newCursorPage(r *http.Request, limit int, cursor, nextCursor string) Page {
+	page := Page{Limit: limit, Cursor: cursor}
+	if nextCursor != "" {
+		page.Next = pageURL(r, map[string]string{
+			"limit":  strconv.Itoa(limit),
+			"cursor": nextCursor,
+		})
+	}
+	return page
+}
+
+// pageURL returns the path and query of the request with the given query parameters replaced,
+// keeping every other parameter (such as filters) intact.
+func pageURL(r *http.Request, params map[string]string) string {
+	query := r.URL.Query()
+	for name, value := range params {
+		query.Set(name, value)
+	}
+	return r.URL.Path + "?" + query.Encode()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Default Partition Maintenance Settings
+const (
+	defaultPartitionMaintenanceInterval = 24 * time.Hour
+	defaultPartitionMonthsAhead         = 2
+)
+
+// sqlCreateTransactionsPartition creates the monthly partition of the transactions
+// table for the month of $1 unless it exists, see migration 0012.
+const sqlCreateTransactionsPartition = `SELECT create_transactions_partition($1::date)`
+
+// partitionMaintainer is implemented by stores whose transactions table is
+// partitioned by month.
+type partitionMaintainer interface {
+	EnsureTransactionPartitions(ctx context.Context, monthsAhead int) ([]string, error)
+}
+
+// EnsureTransactionPartitions creates the partitions of the transactions table for
+// the current month and the monthsAhead following months, so that new rows never
+// land in the default partition. Existing partitions are left untouched.
+//
+// Old months are not dropped automatically: to apply a retention policy, detach the
+// partition with ALTER TABLE transactions DETACH PARTITION transactions_YYYY_MM and
+// archive or drop it.
+//
+// Parameters:
+//   - ctx: The context of the database work.
+//   - monthsAhead: How many months after the current one to create partitions for.
+//
+// Returns:
+//   - []string: The names of the partitions, oldest first.
+//   - error: An error if a partition cannot be created.
+func (s *PostgresStorage) EnsureTransactionPartitions(ctx context.Context, monthsAhead int) ([]string, error) {
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	partitions := make([]string, 0, monthsAhead+1)
+	for i := 0; i <= monthsAhead; i++ {
+		var name string
+		if err := s.queryRowContext(ctx, sqlCreateTransactionsPartition, month.AddDate(0, i, 0)).Scan(&name); err != nil {
+			return partitions, err
+		}
+		partitions = append(partitions, name)
+	}
+
+	return partitions, nil
+}
+
+// runPartitionMaintenance creates the upcoming partitions of the transactions table
+// once at startup and then every partition maintenance interval until ctx is
+// cancelled, on one instance of the fleet, see runScheduledJob. The partition_months_ahead
+// setting sets how many months ahead are created. Stores without partitions, such as the
+// in-memory one, are left alone.
+func (as *APIServer) runPartitionMaintenance(ctx context.Context) {
+	maintainer, ok := unwrapStorage(as.store).(partitionMaintainer)
+	if !ok {
+		return
+	}
+
+	monthsAhead := as.config.Jobs.PartitionMonthsAhead
+	as.runScheduledJob(ctx, "partition_maintenance", as.config.Jobs.PartitionMaintenanceInterval, func(ctx context.Context) {
+		if partitions, err := maintainer.EnsureTransactionPartitions(ctx, monthsAhead); err != nil {
+			slog.ErrorContext(ctx, "Error Creating Transaction Partitions", "error", err)
+		} else {
+			slog.InfoContext(ctx, "Transaction Partitions Ready", "partitions", partitions)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gorilla/mux"
+)
+
+// registerProfilingRoutes mounts the net/http/pprof handlers under /debug/pprof on the
+// admin subrouter, so the profiles of a running server can be captured with the admin
+// token, e.g. go tool pprof -H "X-Admin-Token: ..." http://host/admin/debug/pprof/heap.
+// A CPU profile or an execution trace runs for ?seconds=N, 30 by default, which must
+// stay below HTTP_WRITE_TIMEOUT.
+//
+// Routes:
+// - GET /admin/debug/pprof/: Lists the available profiles.
+// - GET /admin/debug/pprof/cmdline: Returns the command line of the process.
+// - GET /admin/debug/pprof/profile: Captures a CPU profile.
+// - GET /admin/debug/pprof/symbol: Looks up the program counters given in the request.
+// - GET /admin/debug/pprof/trace: Captures an execution trace.
+// - GET /admin/debug/pprof/{profile}: Returns a runtime profile such as heap, goroutine, or mutex.
+func registerProfilingRoutes(admin *mux.Router) {
+	debug := admin.PathPrefix("/debug/pprof").Subrouter()
+
+	debug.HandleFunc("/", pprof.Index).Methods(http.MethodGet)
+	debug.HandleFunc("/cmdline", pprof.Cmdline).Methods(http.MethodGet)
+	debug.HandleFunc("/profile", pprof.Profile).Methods(http.MethodGet)
+	debug.HandleFunc("/symbol", pprof.Symbol).Methods(http.MethodGet, http.MethodPost)
+	debug.HandleFunc("/trace", pprof.Trace).Methods(http.MethodGet)
+
+	// pprof.Index Only Serves The Named Profiles Under /debug/pprof/, So Route Them Explicitly
+	debug.HandleFunc("/{profile}", func(w http.ResponseWriter, r *http.Request) {
+		pprof.Handler(mux.Vars(r)["profile"]).ServeHTTP(w, r)
+	}).Methods(http.MethodGet)
+}
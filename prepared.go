@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Hot Path Queries, Prepared Once At Startup
+const (
+	sqlGetAccountById       = `SELECT ` + accountColumns + ` FROM accounts WHERE id = $1 AND deleted_at IS NULL`
+	sqlLockAccount          = `SELECT frozen, transfer_limit, version, currency, balance FROM accounts WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	sqlDebitAccount         = `UPDATE accounts SET balance = balance - $1, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND version = $3 RETURNING balance`
+	sqlCreditAccount        = `UPDATE accounts SET balance = balance + $1, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND version = $3 RETURNING balance`
+	sqlInsertTransferLedger = `INSERT INTO transactions (account_id, counterparty_id, type, amount, balance_after, currency) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+)
+
+// preparedQueries lists the queries Prepare turns into prepared statements.
+var preparedQueries = []string{
+	sqlGetAccountById,
+	sqlLockAccount,
+	sqlDebitAccount,
+	sqlCreditAccount,
+	sqlInsertTransferLedger,
+}
+
+// Prepare creates the prepared statements of the hot path queries, so they are parsed
+// and planned once instead of on every request. It must run after the migrations, since
+// preparing checks the statements against the schema. Other queries still benefit from
+// the per-connection statement cache of pgx.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the preparation.
+//
+// Returns:
+//   - error: An error naming the first statement that cannot be prepared, otherwise nil.
+func (s *PostgresStorage) Prepare(ctx context.Context) error {
+	statements := make(map[string]*sql.Stmt, len(preparedQueries))
+
+	for _, query := range preparedQueries {
+		stmt, err := s.db.PrepareContext(ctx, query)
+		if err != nil {
+			for _, prepared := range statements {
+				prepared.Close()
+			}
+			return fmt.Errorf("preparing %q: %w", query, err)
+		}
+		statements[query] = stmt
+	}
+
+	s.statements = statements
+	return nil
+}
+
+// prepared returns the prepared statement of a query, bound to the running transaction
+// if there is one, or nil when the query was not prepared.
+func (s *PostgresStorage) prepared(ctx context.Context, query string) *sql.Stmt {
+	stmt := s.statements[query]
+	if stmt == nil {
+		return nil
+	}
+	if s.tx != nil {
+		return s.tx.StmtContext(ctx, stmt)
+	}
+	return stmt
+}
+
+// queryContext runs a query through its prepared statement when there is one.
+func (s *PostgresStorage) queryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if stmt := s.prepared(ctx, query); stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return s.q.QueryContext(ctx, query, args...)
+}
+
+// queryRowContext runs a single row query through its prepared statement when there is one.
+func (s *PostgresStorage) queryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if stmt := s.prepared(ctx, query); stmt != nil {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return s.q.QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Push Platforms Of The Devices
+const (
+	PushPlatformFCM  = "fcm"
+	PushPlatformAPNs = "apns"
+)
+
+// Push Device Constraints
+const (
+	pushTokenMaxLen      = 4096
+	pushDeviceNameMaxLen = 100
+)
+
+// defaultPushTimeout bounds a request to a push provider.
+const defaultPushTimeout = 10 * time.Second
+
+// errPushTokenInvalid is returned by the push providers for a token the device no
+// longer answers to, such as after the app was uninstalled. Its device is removed.
+var errPushTokenInvalid = errors.New("the push token is no longer valid")
+
+// PushDevice is a device of an account holder receiving the push notifications.
+type PushDevice struct {
+	ID        int    `json:"id"`
+	AccountID int    `json:"account_id"`
+	Platform  string `json:"platform"`
+	// Token is the registration token of FCM, or the device token of APNs.
+	Token     string    `json:"token"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RegisterPushDeviceRequest is the body of POST /api/v1/account/{id}/devices.
+type RegisterPushDeviceRequest struct {
+	Platform string `json:"platform"`
+	Token    string `json:"token"`
+	Name     string `json:"name"`
+}
+
+// Validate checks the fields of a push device registration request.
+func (req *RegisterPushDeviceRequest) Validate() []FieldError {
+	var errs []FieldError
+	if req.Platform == "" {
+		errs = append(errs, FieldError{Field: "platform", Code: CodeRequired, Message: "platform is required"})
+	} else if req.Platform != PushPlatformFCM && req.Platform != PushPlatformAPNs {
+		errs = append(errs, FieldError{Field: "platform", Code: CodeInvalid, Message: fmt.Sprintf("platform must be %q or %q", PushPlatformFCM, PushPlatformAPNs)})
+	}
+	switch {
+	case req.Token == "":
+		errs = append(errs, FieldError{Field: "token", Code: CodeRequired, Message: "token is required"})
+	case len(req.Token) > pushTokenMaxLen:
+		errs = append(errs, FieldError{Field: "token", Code: CodeTooLong, Message: fmt.Sprintf("token must be at most %d characters", pushTokenMaxLen)})
+	case req.Platform == PushPlatformAPNs && strings.Trim(strings.ToLower(req.Token), "0123456789abcdef") != "":
+		errs = append(errs, FieldError{Field: "token", Code: CodeInvalid, Message: "an APNs token must be hexadecimal"})
+	}
+	if len(req.Name) > pushDeviceNameMaxLen {
+		errs = append(errs, FieldError{Field: "name", Code: CodeTooLong, Message: fmt.Sprintf("name must be at most %d characters", pushDeviceNameMaxLen)})
+	}
+	return errs
+}
+
+// PushDevicesResponse is the response of GET /api/v1/account/{id}/devices.
+type PushDevicesResponse struct {
+	Data []*PushDevice `json:"data"`
+}
+
+// pushSender is the sender of the push channel: it hands every notification to the
+// provider of the platform of its device, see FCMSender and APNsSender.
+type pushSender struct {
+	providers map[string]NotificationSender
+}
+
+// newPushSender creates the sender of the push channel with the configured providers.
+//
+// Parameters:
+//   - cfg: The settings of FCM and APNs, each enabled by its credentials.
+//
+// Returns:
+//   - *pushSender: The sender, nil when no provider is configured.
+//   - error: An error if the credentials of a provider cannot be loaded.
+func newPushSender(cfg PushConfig) (*pushSender, error) {
+	providers := map[string]NotificationSender{}
+	if cfg.FCM.CredentialsFile != "" {
+		fcm, err := NewFCMSender(cfg.FCM, cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("loading the FCM credentials: %w", err)
+		}
+		providers[PushPlatformFCM] = fcm
+	}
+	if cfg.APNs.KeyFile != "" {
+		apns, err := NewAPNsSender(cfg.APNs, cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("loading the APNs key: %w", err)
+		}
+		providers[PushPlatformAPNs] = apns
+	}
+
+	if len(providers) == 0 {
+		return nil, nil
+	}
+	return &pushSender{providers: providers}, nil
+}
+
+// Send pushes a notification through the provider of its platform.
+func (s *pushSender) Send(ctx context.Context, notification *Notification) error {
+	provider, ok := s.providers[notification.Platform]
+	if !ok {
+		return fmt.Errorf("no push provider for the %q platform", notification.Platform)
+	}
+	return provider.Send(ctx, notification)
+}
+
+// pushDevices returns the devices of an account when push is configured, logging the
+// errors of the store.
+func (n *notifier) pushDevices(ctx context.Context, accountID int) []*PushDevice {
+	if n.senders[notificationChannelPush] == nil {
+		return nil
+	}
+
+	devices, err := n.store.GetPushDevices(ctx, accountID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error Loading The Push Devices", "account_id", accountID, "error", err)
+		return nil
+	}
+	return devices
+}
+
+// push renders the push notification of a kind and sends it to every device except
+// the one with the ID except, removing the devices whose token expired.
+func (n *notifier) push(ctx context.Context, devices []*PushDevice, kind string, data any, except int) {
+	for _, device := range devices {
+		if device.ID == except {
+			continue
+		}
+
+		notification, err := renderNotification(notificationChannelPush, kind, device.Token, data)
+		if err == nil {
+			notification.AccountID = device.AccountID
+			notification.Platform = device.Platform
+			err = n.deliver(ctx, notification)
+		}
+
+		switch {
+		case errors.Is(err, errPushTokenInvalid):
+			slog.InfoContext(ctx, "Removing A Push Device With An Invalid Token", "device_id", device.ID, "account_id", device.AccountID)
+			if err := n.store.DeletePushDevice(ctx, device.AccountID, device.ID); err != nil && !errors.Is(err, ErrPushDeviceNotFound) {
+				slog.ErrorContext(ctx, "Error Removing The Push Device", "device_id", device.ID, "error", err)
+			}
+		case err != nil:
+			slog.ErrorContext(ctx, "Error Sending Push Notification", "kind", kind, "device_id", device.ID, "error", err)
+		}
+	}
+}
+
+// securityNotification is the data of the security_alert template.
+type securityNotification struct {
+	Account *Account
+	Alert   SecurityAlertEvent
+}
+
+// handleSecurityAlert pushes a security alert to the devices of the account, except
+// the device the alert is about.
+func (n *notifier) handleSecurityAlert(ctx context.Context, event *OutboxEvent) {
+	devices := n.pushDevices(ctx, event.AccountID)
+	if len(devices) == 0 {
+		return
+	}
+
+	var data securityNotification
+	if err := json.Unmarshal(event.Payload, &data.Alert); err != nil {
+		slog.ErrorContext(ctx, "Invalid Security Alert Event", "event_id", event.ID, "error", err)
+		return
+	}
+
+	var err error
+	if data.Account, err = n.store.GetAccountById(ctx, event.AccountID); err != nil {
+		slog.ErrorContext(ctx, "Error Loading The Notified Account", "account_id", event.AccountID, "error", err)
+		return
+	}
+
+	n.push(ctx, devices, NotificationSecurityAlert, data, data.Alert.DeviceID)
+}
+
+// securityAlert creates the security.alert event of an account.
+func securityAlert(accountID int, reason string, deviceID int) (*OutboxEvent, error) {
+	return newOutboxEvent(EventSecurityAlert, accountID, SecurityAlertEvent{Reason: reason, DeviceID: deviceID, At: time.Now().UTC()})
+}
+
+// handleGetPushDevices lists the push devices of an account.
+func (as *APIServer) handleGetPushDevices(w http.ResponseWriter, r *http.Request) error {
+	devices, err := as.store.GetPushDevices(r.Context(), getId(w, r))
+	if err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusOK, PushDevicesResponse{Data: devices})
+}
+
+// handleRegisterPushDevice registers a device for the push notifications of an account,
+// alerting the other devices of the account.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the account ID and the device.
+//
+// Returns:
+//   - error: A validation error if the body is invalid, a 503 if the platform has no
+//     provider, or the error of the store.
+func (as *APIServer) handleRegisterPushDevice(w http.ResponseWriter, r *http.Request) error {
+	deviceReq := new(RegisterPushDeviceRequest)
+	if err := bindJSON(w, r, deviceReq); err != nil {
+		return err
+	}
+
+	if sender, ok := as.pushSender(); !ok || sender.providers[deviceReq.Platform] == nil {
+		return NewTypedError(http.StatusServiceUnavailable, "push_unavailable", fmt.Sprintf("push notifications are not configured for %s", deviceReq.Platform))
+	}
+
+	device := &PushDevice{
+		AccountID: getId(w, r),
+		Platform:  deviceReq.Platform,
+		Token:     deviceReq.Token,
+		Name:      deviceReq.Name,
+	}
+
+	// Store The Device And Its Security Alert Together
+	err := as.store.WithTx(r.Context(), func(tx Storage) error {
+		if err := tx.RegisterPushDevice(r.Context(), device); err != nil {
+			return err
+		}
+
+		event, err := securityAlert(device.AccountID, SecurityDeviceRegistered, device.ID)
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(r.Context(), []*OutboxEvent{event})
+	})
+	if err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusCreated, device)
+}
+
+// handleDeletePushDevice unregisters a push device of an account.
+func (as *APIServer) handleDeletePushDevice(w http.ResponseWriter, r *http.Request) error {
+	deviceID, err := strconv.Atoi(mux.Vars(r)["device"])
+	if err != nil {
+		return NewTypedError(http.StatusBadRequest, "bad_request", "invalid device ID")
+	}
+
+	if err := as.store.DeletePushDevice(r.Context(), getId(w, r), deviceID); err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusOK, map[string]int{
+		"deleted": deviceID,
+	})
+}
+
+// pushSender returns the sender of the push channel, and false when push is not configured.
+func (as *APIServer) pushSender() (*pushSender, bool) {
+	if as.notifier == nil {
+		return nil, false
+	}
+	sender, ok := as.notifier.senders[notificationChannelPush].(*pushSender)
+	return sender, ok
+}
+
+// registerPushRoutes registers the push device endpoints on the /api/v1 subrouter, for
+// the authenticated account holder.
+//
+// Routes:
+// - GET /api/v1/account/{id:[0-9]+}/devices: Lists the push devices of an account.
+// - POST /api/v1/account/{id:[0-9]+}/devices: Registers a push device.
+// - DELETE /api/v1/account/{id:[0-9]+}/devices/{device:[0-9]+}: Unregisters a push device.
+func (as *APIServer) registerPushRoutes(subRouter *mux.Router) {
+	subRouter.HandleFunc("/account/{id:[0-9]+}/devices", withJWTAuth(makeHTTPHandlerFunc(as.handleGetPushDevices), as.store)).Methods(http.MethodGet)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/devices", withJWTAuth(makeHTTPHandlerFunc(as.handleRegisterPushDevice), as.store)).Methods(http.MethodPost)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/devices/{device:[0-9]+}", withJWTAuth(makeHTTPHandlerFunc(as.handleDeletePushDevice), as.store)).Methods(http.MethodDelete)
+}
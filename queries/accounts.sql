@@ -0,0 +1,57 @@
+-- name: GetAccountByNumber :one
+SELECT sqlc.embed(accounts) FROM accounts
+WHERE number = $1 AND deleted_at IS NULL;
+
+-- name: GetAccountByExternalID :one
+SELECT sqlc.embed(accounts) FROM accounts
+WHERE external_id = sqlc.arg(external_id)::text AND deleted_at IS NULL;
+
+-- name: CreateAccount :one
+INSERT INTO accounts (
+	first_name,
+	last_name,
+	number,
+	balance,
+	currency,
+	kyc_status,
+	external_id,
+	encrypted_password
+) VALUES (
+	sqlc.arg(first_name),
+	sqlc.arg(last_name),
+	sqlc.arg(number),
+	sqlc.arg(balance),
+	sqlc.arg(currency),
+	COALESCE(NULLIF(sqlc.arg(kyc_status)::text, ''), 'not_started'),
+	NULLIF(sqlc.arg(external_id)::text, ''),
+	sqlc.arg(encrypted_password)
+)
+RETURNING id, create_at, version, updated_at, kyc_status;
+
+-- name: UpdateAccountName :one
+UPDATE accounts SET
+	first_name = $1,
+	last_name = $2,
+	version = version + 1,
+	updated_at = CURRENT_TIMESTAMP
+WHERE id = $3 AND version = $4 AND deleted_at IS NULL
+RETURNING version, updated_at;
+
+-- name: SoftDeleteAccount :execrows
+UPDATE accounts SET
+	deleted_at = CURRENT_TIMESTAMP,
+	version = version + 1,
+	updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: SetAccountFrozen :execrows
+UPDATE accounts SET frozen = $1, version = version + 1, updated_at = CURRENT_TIMESTAMP
+WHERE id = $2 AND deleted_at IS NULL;
+
+-- name: SetAccountKYCStatus :execrows
+UPDATE accounts SET kyc_status = $1, version = version + 1, updated_at = CURRENT_TIMESTAMP
+WHERE id = $2 AND deleted_at IS NULL;
+
+-- name: SetTransferLimit :execrows
+UPDATE accounts SET transfer_limit = $1, version = version + 1, updated_at = CURRENT_TIMESTAMP
+WHERE id = $2 AND deleted_at IS NULL;
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Default RabbitMQ Settings
+const (
+	defaultRabbitMQTransferQueue  = "gobank.transfers"
+	defaultRabbitMQPublishTimeout = 5 * time.Second
+)
+
+// Reconnection Delays Of The RabbitMQ Queue, Doubled After Every Failed Attempt
+const (
+	rabbitMQReconnectBackoff    = 500 * time.Millisecond
+	rabbitMQReconnectMaxBackoff = 30 * time.Second
+)
+
+// errRabbitMQDisconnected is returned by the publishing while the queue reconnects.
+var errRabbitMQDisconnected = errors.New("not connected to RabbitMQ")
+
+// transferJob is the message of an accepted asynchronous transfer. Only the ID is
+// queued: the transfer itself is read from the store when the job is consumed.
+type transferJob struct {
+	TransferID int `json:"transfer_id"`
+}
+
+// rabbitTransferQueue processes the asynchronous transfers from a durable RabbitMQ
+// queue, so the accepted ones survive a restart and are shared by every instance.
+// Every job is published with a confirm from the broker, and the jobs that cannot be
+// processed, because their message is invalid or their transfer cannot be read twice
+// in a row, are dead-lettered to the queue named after the transfer queue with a
+// ".dead" suffix. The connection is reopened in the background when it is lost.
+type rabbitTransferQueue struct {
+	cfg     RabbitMQConfig
+	workers int
+	load    func(context.Context, int) (*Transfer, error)
+	process func(*Transfer)
+
+	// mu guards publisher, replaced on every reconnection.
+	mu        sync.Mutex
+	publisher *amqp.Channel
+	stopped   bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newRabbitTransferQueue creates a queue consumed by the given number of workers. It
+// does not connect until Start is called.
+//
+// Parameters:
+//   - cfg: The broker URL, the queue name, and the publish timeout.
+//   - workers: The number of transfers processed at once, which is also the prefetch count.
+//   - load: Reads the transfer of a job.
+//   - process: Processes a pending transfer.
+//
+// Returns:
+//   - *rabbitTransferQueue: The queue.
+func newRabbitTransferQueue(cfg RabbitMQConfig, workers int, load func(context.Context, int) (*Transfer, error), process func(*Transfer)) *rabbitTransferQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &rabbitTransferQueue{
+		cfg:     cfg,
+		workers: workers,
+		load:    load,
+		process: process,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+}
+
+// Durable reports that the queued transfers survive a restart.
+func (q *rabbitTransferQueue) Durable() bool {
+	return true
+}
+
+// Start connects to the broker and starts the workers, in the background.
+func (q *rabbitTransferQueue) Start() {
+	slog.Info("Processing Async Transfers From RabbitMQ", "queue", q.cfg.TransferQueue, "workers", q.workers)
+	go q.run()
+}
+
+// run keeps the queue connected and consumed until Stop is called, reconnecting with
+// an exponential backoff when the connection fails or is lost.
+func (q *rabbitTransferQueue) run() {
+	defer close(q.done)
+
+	backoff := rabbitMQReconnectBackoff
+	for {
+		err := q.serve()
+		if q.ctx.Err() != nil {
+			return
+		}
+		slog.Error("RabbitMQ Unavailable, Reconnecting", "retry_in", backoff, "error", err)
+
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, rabbitMQReconnectMaxBackoff)
+	}
+}
+
+// serve connects to the broker, declares the queues, and consumes the jobs until the
+// connection is lost or Stop is called, waiting for the jobs in progress either way.
+func (q *rabbitTransferQueue) serve() error {
+	conn, err := amqp.DialConfig(q.cfg.URL, amqp.Config{Properties: amqp.Table{"connection_name": "gobank"}})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	publisher, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := q.declare(publisher); err != nil {
+		return err
+	}
+	if err := publisher.Confirm(false); err != nil {
+		return err
+	}
+
+	consumer, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := consumer.Qos(q.workers, 0, false); err != nil {
+		return err
+	}
+	deliveries, err := consumer.Consume(q.cfg.TransferQueue, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.publisher = publisher
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.publisher = nil
+		q.mu.Unlock()
+	}()
+	slog.Info("Connected To RabbitMQ", "queue", q.cfg.TransferQueue)
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for delivery := range deliveries {
+				q.handle(delivery)
+			}
+		}()
+	}
+
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	select {
+	case err := <-closed:
+		wg.Wait()
+		return err
+	case <-q.ctx.Done():
+		// Stop The Deliveries, Then Let The Workers Acknowledge The Jobs In Progress
+		consumer.Cancel("", false)
+		wg.Wait()
+		return nil
+	}
+}
+
+// declare creates the transfer queue and its dead-letter queue when they do not exist yet.
+func (q *rabbitTransferQueue) declare(ch *amqp.Channel) error {
+	dead := q.cfg.TransferQueue + ".dead"
+
+	if err := ch.ExchangeDeclare(dead, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(dead, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if err := ch.QueueBind(dead, "", dead, false, nil); err != nil {
+		return err
+	}
+
+	_, err := ch.QueueDeclare(q.cfg.TransferQueue, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange": dead,
+	})
+	return err
+}
+
+// handle processes the job of a delivery and acknowledges it. A transfer that is no
+// longer pending was processed before the job was redelivered and is skipped. A
+// transfer that cannot be read is retried once, then dead-lettered.
+func (q *rabbitTransferQueue) handle(delivery amqp.Delivery) {
+	var job transferJob
+	if err := json.Unmarshal(delivery.Body, &job); err != nil || job.TransferID == 0 {
+		slog.Error("Invalid Transfer Job, Dead-Lettering It", "body", string(delivery.Body), "error", err)
+		delivery.Nack(false, false)
+		return
+	}
+
+	transfer, err := q.load(q.ctx, job.TransferID)
+	if err != nil {
+		requeue := !delivery.Redelivered && !errors.Is(err, ErrTransferNotFound)
+		slog.Error("Error Loading The Transfer Of A Job", "transfer_id", job.TransferID, "requeued", requeue, "error", err)
+		delivery.Nack(false, requeue)
+		return
+	}
+
+	if transfer.Status == TransferStatusPending {
+		q.process(transfer)
+	}
+	delivery.Ack(false)
+}
+
+// Enqueue publishes the job of a transfer and waits for the broker to confirm it, for
+// at most the publish timeout. It returns false when the job could not be published,
+// the queue being disconnected or stopped.
+func (q *rabbitTransferQueue) Enqueue(transfer *Transfer) bool {
+	if err := q.publish(transfer); err != nil {
+		slog.Error("Error Queueing The Transfer", "transfer_id", transfer.ID, "error", err)
+		return false
+	}
+	return true
+}
+
+// publish sends the job of a transfer as a persistent message and waits for its confirm.
+func (q *rabbitTransferQueue) publish(transfer *Transfer) error {
+	body, err := json.Marshal(transferJob{TransferID: transfer.ID})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(q.ctx, q.cfg.PublishTimeout)
+	defer cancel()
+
+	// The Channel Is Not Safe For Concurrent Use, The Confirm Is Awaited Unlocked
+	q.mu.Lock()
+	if q.stopped || q.publisher == nil {
+		q.mu.Unlock()
+		return errRabbitMQDisconnected
+	}
+	confirm, err := q.publisher.PublishWithDeferredConfirmWithContext(ctx, "", q.cfg.TransferQueue, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    strconv.Itoa(transfer.ID),
+		Timestamp:    time.Now().UTC(),
+		Body:         body,
+	})
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return errors.New("the broker refused the transfer job")
+	}
+	return nil
+}
+
+// Stop refuses new transfers, waits for the jobs in progress, and closes the connection.
+// The jobs still queued are processed after the next start.
+func (q *rabbitTransferQueue) Stop() {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return
+	}
+	q.stopped = true
+	q.mu.Unlock()
+
+	q.cancel()
+	<-q.done
+}
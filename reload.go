@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+)
+
+// configLoader loads the configuration again from its file, the environment, and the
+// command line flags.
+type configLoader func() (*Config, error)
+
+// runConfigReload reloads the configuration on every SIGHUP until ctx is cancelled,
+// see reloadConfig. Without a loader the signal keeps its default effect.
+func (as *APIServer) runConfigReload(ctx context.Context) {
+	if as.configLoader == nil {
+		return
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			as.reloadConfig()
+		}
+	}
+}
+
+// reloadConfig loads the configuration again and applies the settings that can change
+// while the server runs: the log level and the feature flags. The connections are
+// kept, and an invalid configuration is rejected as a whole. The other settings only
+// apply after a restart, which is logged when they changed. The environment of a
+// running process is fixed, so in practice the changes come from the config file.
+func (as *APIServer) reloadConfig() {
+	cfg, err := as.configLoader()
+	if err != nil {
+		slog.Error("Error Reloading The Configuration, Keeping The Current One", "error", err)
+		return
+	}
+
+	// Validate Accepted The Level Already, A Temporary Level Set Through /admin/log-level Stays
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Logging.Level))
+	as.runtimeLevel.SetConfigured(level)
+	as.featureFlags.SetDefaults(cfg.Features)
+
+	if !reflect.DeepEqual(structuralConfig(as.config), structuralConfig(cfg)) {
+		slog.Warn("Changed Settings Other Than The Log Level And The Feature Flags Apply After A Restart")
+	}
+
+	slog.Info("Configuration Reloaded", "log_level", cfg.Logging.Level, "features", cfg.Features)
+}
+
+// structuralConfig returns a copy of cfg without the settings reloadConfig applies.
+func structuralConfig(cfg *Config) Config {
+	c := *cfg
+	c.Logging.Level = ""
+	c.Features = nil
+	return c
+}
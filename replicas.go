@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// defaultReplicaRetryAfter is how long a failing read replica is skipped before it is tried again.
+const defaultReplicaRetryAfter = 30 * time.Second
+
+// replica is a read-only copy of the primary database.
+type replica struct {
+	index int
+	pool  *pgxpool.Pool
+	db    *sql.DB
+	// downUntil is the time, in Unix nanoseconds, until which the replica is skipped.
+	downUntil atomic.Int64
+}
+
+// replicaSet spreads reads over the replicas in turn, skipping the ones that recently failed.
+type replicaSet struct {
+	replicas   []*replica
+	next       atomic.Uint64
+	retryAfter time.Duration
+}
+
+// newReplicaSet opens a connection pool to every replica listed in the replica_urls
+// setting (DB_REPLICA_URLS, separated by commas). The pools connect lazily, so a replica
+// that is down at startup is simply skipped until it answers.
+//
+// Parameters:
+//   - ctx: The context used to open the pools.
+//   - cfg: The database settings.
+//
+// Returns:
+//   - *replicaSet: The replicas, or nil when none are configured.
+//   - error: An error if a connection string cannot be parsed.
+func newReplicaSet(ctx context.Context, cfg *DatabaseConfig) (*replicaSet, error) {
+	set := &replicaSet{retryAfter: cfg.ReplicaRetryAfter}
+
+	for _, connString := range cfg.ReplicaURLs {
+		pool, err := newPool(ctx, connString, cfg)
+		if err != nil {
+			set.Close()
+			return nil, err
+		}
+
+		set.replicas = append(set.replicas, &replica{
+			index: len(set.replicas),
+			pool:  pool,
+			db:    stdlib.OpenDBFromPool(pool),
+		})
+	}
+
+	if len(set.replicas) == 0 {
+		return nil, nil
+	}
+
+	return set, nil
+}
+
+// available returns the replicas that are not marked down, starting with the next one in turn.
+func (rs *replicaSet) available() []*replica {
+	now := time.Now().UnixNano()
+	start := int(rs.next.Add(1) % uint64(len(rs.replicas)))
+
+	replicas := make([]*replica, 0, len(rs.replicas))
+	for i := range rs.replicas {
+		rep := rs.replicas[(start+i)%len(rs.replicas)]
+		if rep.downUntil.Load() <= now {
+			replicas = append(replicas, rep)
+		}
+	}
+	return replicas
+}
+
+// markDown skips a replica for the retry period after a failed query.
+func (rs *replicaSet) markDown(rep *replica, err error) {
+	if rep.downUntil.Swap(time.Now().Add(rs.retryAfter).UnixNano()) <= time.Now().UnixNano() {
+		slog.Warn("Read Replica Is Unavailable, Reading From The Primary", "replica", rep.index, "retry_in", rs.retryAfter, "error", err)
+	}
+}
+
+// Close closes the connection pools of all replicas.
+func (rs *replicaSet) Close() {
+	for _, rep := range rs.replicas {
+		rep.db.Close()
+		rep.pool.Close()
+	}
+}
+
+// readQueryContext runs a read-only query on a replica, falling back to the next replica
+// and finally to the primary when a replica fails. Inside a transaction, and when no
+// replicas are configured, the query runs where every other query runs.
+//
+// Parameters:
+//   - ctx: The context of the query.
+//   - query: The query to run.
+//   - args: The arguments of the query.
+//
+// Returns:
+//   - *sql.Rows: The rows of the result.
+//   - error: The error of the primary if the query fails everywhere.
+func (s *PostgresStorage) readQueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if s.tx == nil && s.replicas != nil {
+		for _, rep := range s.replicas.available() {
+			rows, err := rep.db.QueryContext(ctx, query, args...)
+			if err == nil {
+				return rows, nil
+			}
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			s.replicas.markDown(rep, err)
+		}
+	}
+
+	return s.queryContext(ctx, query, args...)
+}
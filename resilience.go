@@ -0,0 +1,929 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/moabdelazem/gobank/money"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Default Retry And Circuit Breaker Settings
+const (
+	defaultDBRetryAttempts    = 3
+	defaultDBRetryBackoff     = 50 * time.Millisecond
+	defaultDBBreakerThreshold = 5
+	defaultDBBreakerCooldown  = 10 * time.Second
+)
+
+// Postgres Error Codes Of The Transactions Rolled Back Because Of Concurrent Ones
+const (
+	pgCodeSerializationFailure = "40001"
+	pgCodeDeadlockDetected     = "40P01"
+)
+
+// ErrDatabaseUnavailable is returned without calling the database while the circuit
+// breaker is open.
+var ErrDatabaseUnavailable = errors.New("database unavailable")
+
+// ResilientStorage is a Storage decorator that retries the calls failing for a transient
+// reason, and stops calling a database that keeps failing: once breakerThreshold calls in
+// a row could not reach it, the calls fail at once with ErrDatabaseUnavailable, answered
+// with a 503, until a call let through after the cooldown succeeds.
+//
+// A call is retried when the database rolled it back because of a concurrent
+// transaction, or when it failed before reaching the database. The calls running a
+// callback, WithTx, StreamTransactions, and StreamLedger, and ImportRecords are never retried, and
+// the calls made inside a transaction go straight to it.
+type ResilientStorage struct {
+	next     Storage
+	attempts int
+	backoff  time.Duration
+	breaker  *circuitBreaker
+	retries  *prometheus.CounterVec
+}
+
+// NewResilientStorage wraps store with the retries and the circuit breaker of cfg.
+//
+// Parameters:
+//   - store: The storage to protect.
+//   - cfg: The database settings, for the retry attempts and backoff and the breaker threshold and cooldown.
+//
+// Returns:
+//   - *ResilientStorage: The storage; its collectors are registered by newMetricsRegistry.
+func NewResilientStorage(store Storage, cfg *DatabaseConfig) *ResilientStorage {
+	return &ResilientStorage{
+		next:     store,
+		attempts: cfg.RetryAttempts,
+		backoff:  cfg.RetryBackoff,
+		breaker:  newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "storage", Name: "retries_total",
+			Help: "Number of storage calls retried after a transient failure, by method.",
+		}, []string{"method"}),
+	}
+}
+
+// Unwrap returns the protected storage.
+func (s *ResilientStorage) Unwrap() Storage {
+	return s.next
+}
+
+// Collectors returns the retry counter and the circuit breaker metrics, for the metrics registry.
+func (s *ResilientStorage) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		s.retries,
+		s.breaker.rejections,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Subsystem: "storage", Name: "breaker_state",
+			Help: "State of the database circuit breaker: 0 closed, 1 half-open, 2 open.",
+		}, func() float64 { return float64(s.breaker.State()) }),
+	}
+}
+
+// call runs fn through the circuit breaker, retrying it with an exponential backoff
+// while it fails for a transient reason and attempts are left.
+func (s *ResilientStorage) call(ctx context.Context, method string, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = s.callOnce(fn); err == nil || attempt >= s.attempts || !retryableError(err) {
+			return err
+		}
+
+		// Full Jitter, So The Retries Of Concurrent Calls Spread Out
+		delay := time.Duration(rand.Int63n(int64(s.backoff<<(attempt-1)) + 1))
+		slog.DebugContext(ctx, "Retrying Storage Call", "method", method, "attempt", attempt, "retry_in", delay, "error", err)
+		s.retries.WithLabelValues(method).Inc()
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// callOnce runs fn through the circuit breaker, without retrying it.
+func (s *ResilientStorage) callOnce(fn func() error) error {
+	if !s.breaker.Allow() {
+		return ErrDatabaseUnavailable
+	}
+	err := fn()
+	s.breaker.Record(err)
+	return err
+}
+
+// retryableError reports whether a call failing with err can safely run again: the
+// database rolled it back because of a concurrent transaction, or it never reached
+// the database.
+func retryableError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgCodeSerializationFailure || pgErr.Code == pgCodeDeadlockDetected
+	}
+	return pgconn.SafeToRetry(err)
+}
+
+// transactionRetries counts the transactions run again by retryTx, by name, registered
+// by newMetricsRegistry.
+var transactionRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace, Subsystem: "storage", Name: "transaction_retries_total",
+	Help: "Number of transactions run again after a concurrent one rolled them back, by name.",
+}, []string{"transaction"})
+
+// retryTx runs fn in a transaction of store, and runs it again in a new transaction,
+// with the full jitter backoff of ResilientStorage, while the transaction is rolled back
+// because of a concurrent one and attempts are left. Unlike WithTx, it is meant for the
+// callbacks that only do database work, so that running them again is safe once the
+// failed attempt was rolled back.
+//
+// Parameters:
+//   - ctx: The context of the transaction; cancelling it stops the retries.
+//   - store: The storage to run the transaction on.
+//   - cfg: The database settings, for the retry attempts and backoff.
+//   - name: The name of the transaction, for the logs and the metrics.
+//   - fn: The operations to run atomically; it must reset any state a failed attempt changed.
+//
+// Returns:
+//   - error: The error of the last attempt, nil once an attempt committed.
+func retryTx(ctx context.Context, store Storage, cfg *DatabaseConfig, name string, fn func(Storage) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = store.WithTx(ctx, fn); err == nil || attempt >= cfg.RetryAttempts || !conflictError(err) {
+			return err
+		}
+
+		delay := time.Duration(rand.Int63n(int64(cfg.RetryBackoff<<(attempt-1)) + 1))
+		slog.InfoContext(ctx, "Retrying Transaction", "transaction", name, "attempt", attempt, "retry_in", delay, "error", err)
+		transactionRetries.WithLabelValues(name).Inc()
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// conflictError reports whether a transaction failing with err was rolled back because
+// of a concurrent one: Postgres could not serialize it or broke a deadlock with it, or an
+// account changed between being read and updated.
+func conflictError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgCodeSerializationFailure || pgErr.Code == pgCodeDeadlockDetected
+	}
+	return errors.Is(err, ErrAccountVersionConflict)
+}
+
+// unavailableError reports whether err means that the database could not be reached or
+// did not answer in time, as opposed to an error the database answered with.
+func unavailableError(err error) bool {
+	var pgErr *pgconn.PgError
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, context.Canceled), errors.As(err, &pgErr):
+		return false
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.As(err, &connectErr), errors.As(err, &netErr), pgconn.Timeout(err):
+		return true
+	}
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err)
+}
+
+// breakerState is the state of a circuit breaker.
+type breakerState int
+
+// Circuit Breaker States
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// circuitBreaker opens after threshold calls in a row failed to reach the database,
+// and rejects the calls while open. After cooldown it lets a single call through, which
+// closes it when the database answers and opens it again otherwise.
+type circuitBreaker struct {
+	threshold  int
+	cooldown   time.Duration
+	rejections prometheus.Counter
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// probing is set while the call let through in the half-open state runs.
+	probing bool
+	// onOpen is called when the breaker opens from closed, with the lock held, and must
+	// not block; nil for none.
+	onOpen func(failures int, cooldown time.Duration, err error)
+}
+
+// newCircuitBreaker creates a closed circuit breaker.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		rejections: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "storage", Name: "breaker_rejections_total",
+			Help: "Number of storage calls rejected by the open database circuit breaker.",
+		}),
+	}
+}
+
+// Allow reports whether a call may reach the database; every allowed call must be
+// followed by Record.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown:
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case b.state == breakerHalfOpen && !b.probing:
+		b.probing = true
+		return true
+	case b.state == breakerClosed:
+		return true
+	}
+
+	b.rejections.Inc()
+	return false
+}
+
+// Record updates the breaker with the result of an allowed call.
+func (b *circuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	switch {
+	case unavailableError(err):
+		b.failures++
+		if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+			if b.state == breakerClosed {
+				slog.Error("Database Unreachable, Failing Storage Calls Fast", "failures", b.failures, "retry_in", b.cooldown, "error", err)
+				if b.onOpen != nil {
+					b.onOpen(b.failures, b.cooldown, err)
+				}
+			}
+			b.state = breakerOpen
+			b.openedAt = time.Now()
+		}
+	case errors.Is(err, context.Canceled):
+		// The Caller Gave Up, Which Tells Nothing About The Database
+	default:
+		if b.state != breakerClosed {
+			slog.Info("Database Reachable Again")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+	}
+}
+
+// OnOpen sets the function called when the breaker opens from closed; it must not block.
+func (b *circuitBreaker) OnOpen(fn func(failures int, cooldown time.Duration, err error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onOpen = fn
+}
+
+// State returns the state of the breaker.
+func (b *circuitBreaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Ping checks the database even while the breaker is open, so the health checks see
+// it come back, and records the result with the breaker.
+func (s *ResilientStorage) Ping(ctx context.Context) error {
+	err := s.next.Ping(ctx)
+	s.breaker.Record(err)
+	return err
+}
+
+// WithTx runs the transaction through the circuit breaker, without retrying it since fn
+// may not be safe to run twice, see retryTx for the callbacks that are; the calls made
+// inside it go straight to the transaction.
+func (s *ResilientStorage) WithTx(ctx context.Context, fn func(Storage) error) error {
+	return s.callOnce(func() error {
+		return s.next.WithTx(ctx, fn)
+	})
+}
+
+// StreamTransactions runs through the circuit breaker without being retried, since fn
+// may already have seen some of the rows.
+func (s *ResilientStorage) StreamTransactions(ctx context.Context, accountID int, from, to time.Time, fn func(*Transaction) error) error {
+	return s.callOnce(func() error {
+		return s.next.StreamTransactions(ctx, accountID, from, to, fn)
+	})
+}
+
+// StreamLedger runs through the circuit breaker without being retried, since fn may
+// already have seen some of the rows.
+func (s *ResilientStorage) StreamLedger(ctx context.Context, from, to time.Time, fn func(*Transaction) error) error {
+	return s.callOnce(func() error {
+		return s.next.StreamLedger(ctx, from, to, fn)
+	})
+}
+
+// ImportRecords runs through the circuit breaker without being retried, since part of
+// the records may have been imported.
+func (s *ResilientStorage) ImportRecords(ctx context.Context, records []*ImportRecord) (errs []error, err error) {
+	err = s.callOnce(func() error {
+		errs, err = s.next.ImportRecords(ctx, records)
+		return err
+	})
+	return errs, err
+}
+
+// CreateAccount retries CreateAccount of the wrapped storage on transient failures.
+func (s *ResilientStorage) CreateAccount(ctx context.Context, account *Account) error {
+	return s.call(ctx, "CreateAccount", func() error {
+		return s.next.CreateAccount(ctx, account)
+	})
+}
+
+// CreateAccounts retries CreateAccounts of the wrapped storage on transient failures.
+func (s *ResilientStorage) CreateAccounts(ctx context.Context, accounts []*Account) error {
+	return s.call(ctx, "CreateAccounts", func() error {
+		return s.next.CreateAccounts(ctx, accounts)
+	})
+}
+
+// DeleteAccount retries DeleteAccount of the wrapped storage on transient failures.
+func (s *ResilientStorage) DeleteAccount(ctx context.Context, id int) error {
+	return s.call(ctx, "DeleteAccount", func() error {
+		return s.next.DeleteAccount(ctx, id)
+	})
+}
+
+// UpdateAccount retries UpdateAccount of the wrapped storage on transient failures.
+func (s *ResilientStorage) UpdateAccount(ctx context.Context, account *Account) error {
+	return s.call(ctx, "UpdateAccount", func() error {
+		return s.next.UpdateAccount(ctx, account)
+	})
+}
+
+// GetAccounts retries GetAccounts of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetAccounts(ctx context.Context) (accounts []*Account, err error) {
+	err = s.call(ctx, "GetAccounts", func() error {
+		accounts, err = s.next.GetAccounts(ctx)
+		return err
+	})
+	return accounts, err
+}
+
+// GetAccountsPage retries GetAccountsPage of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetAccountsPage(ctx context.Context, limit, offset int, includeDeleted bool) (accounts []*Account, total int, err error) {
+	err = s.call(ctx, "GetAccountsPage", func() error {
+		accounts, total, err = s.next.GetAccountsPage(ctx, limit, offset, includeDeleted)
+		return err
+	})
+	return accounts, total, err
+}
+
+// GetAccountById retries GetAccountById of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetAccountById(ctx context.Context, id int) (account *Account, err error) {
+	err = s.call(ctx, "GetAccountById", func() error {
+		account, err = s.next.GetAccountById(ctx, id)
+		return err
+	})
+	return account, err
+}
+
+// GetAccountByNumber retries GetAccountByNumber of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetAccountByNumber(ctx context.Context, number int64) (account *Account, err error) {
+	err = s.call(ctx, "GetAccountByNumber", func() error {
+		account, err = s.next.GetAccountByNumber(ctx, number)
+		return err
+	})
+	return account, err
+}
+
+// GetAccountByExternalID retries GetAccountByExternalID of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetAccountByExternalID(ctx context.Context, externalID string) (account *Account, err error) {
+	err = s.call(ctx, "GetAccountByExternalID", func() error {
+		account, err = s.next.GetAccountByExternalID(ctx, externalID)
+		return err
+	})
+	return account, err
+}
+
+// GetTransactions retries GetTransactions of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetTransactions(ctx context.Context, accountID int, filter *TransactionFilter) (txns []*Transaction, err error) {
+	err = s.call(ctx, "GetTransactions", func() error {
+		txns, err = s.next.GetTransactions(ctx, accountID, filter)
+		return err
+	})
+	return txns, err
+}
+
+// GetTransactionsPage retries GetTransactionsPage of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetTransactionsPage(ctx context.Context, accountID int, filter *TransactionFilter, after *TransactionCursor, limit int) (txns []*Transaction, err error) {
+	err = s.call(ctx, "GetTransactionsPage", func() error {
+		txns, err = s.next.GetTransactionsPage(ctx, accountID, filter, after, limit)
+		return err
+	})
+	return txns, err
+}
+
+// CreateTransactions retries CreateTransactions of the wrapped storage on transient failures.
+func (s *ResilientStorage) CreateTransactions(ctx context.Context, txns []*Transaction) error {
+	return s.call(ctx, "CreateTransactions", func() error {
+		return s.next.CreateTransactions(ctx, txns)
+	})
+}
+
+// GetBalanceAt retries GetBalanceAt of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetBalanceAt(ctx context.Context, accountID int, at time.Time) (balance money.Money, err error) {
+	err = s.call(ctx, "GetBalanceAt", func() error {
+		balance, err = s.next.GetBalanceAt(ctx, accountID, at)
+		return err
+	})
+	return balance, err
+}
+
+// TransferFunds retries TransferFunds of the wrapped storage on transient failures.
+func (s *ResilientStorage) TransferFunds(ctx context.Context, fromID, toID int, amount money.Money) (txns []*Transaction, err error) {
+	err = s.call(ctx, "TransferFunds", func() error {
+		txns, err = s.next.TransferFunds(ctx, fromID, toID, amount)
+		return err
+	})
+	return txns, err
+}
+
+// CreateTransfer retries CreateTransfer of the wrapped storage on transient failures.
+func (s *ResilientStorage) CreateTransfer(ctx context.Context, transfer *Transfer) error {
+	return s.call(ctx, "CreateTransfer", func() error {
+		return s.next.CreateTransfer(ctx, transfer)
+	})
+}
+
+// UpdateTransferStatus retries UpdateTransferStatus of the wrapped storage on transient failures.
+func (s *ResilientStorage) UpdateTransferStatus(ctx context.Context, transfer *Transfer, status, reason string) error {
+	return s.call(ctx, "UpdateTransferStatus", func() error {
+		return s.next.UpdateTransferStatus(ctx, transfer, status, reason)
+	})
+}
+
+// GetTransfer retries GetTransfer of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetTransfer(ctx context.Context, id int) (transfer *Transfer, err error) {
+	err = s.call(ctx, "GetTransfer", func() error {
+		transfer, err = s.next.GetTransfer(ctx, id)
+		return err
+	})
+	return transfer, err
+}
+
+// GetTransfersByStatus retries GetTransfersByStatus of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetTransfersByStatus(ctx context.Context, status string) (transfers []*Transfer, err error) {
+	err = s.call(ctx, "GetTransfersByStatus", func() error {
+		transfers, err = s.next.GetTransfersByStatus(ctx, status)
+		return err
+	})
+	return transfers, err
+}
+
+// GetTransferByProviderReference retries GetTransferByProviderReference of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetTransferByProviderReference(ctx context.Context, reference string) (transfer *Transfer, err error) {
+	err = s.call(ctx, "GetTransferByProviderReference", func() error {
+		transfer, err = s.next.GetTransferByProviderReference(ctx, reference)
+		return err
+	})
+	return transfer, err
+}
+
+// SetAccountFrozen retries SetAccountFrozen of the wrapped storage on transient failures.
+func (s *ResilientStorage) SetAccountFrozen(ctx context.Context, id int, frozen bool) error {
+	return s.call(ctx, "SetAccountFrozen", func() error {
+		return s.next.SetAccountFrozen(ctx, id, frozen)
+	})
+}
+
+// SetTransferLimit retries SetTransferLimit of the wrapped storage on transient failures.
+func (s *ResilientStorage) SetTransferLimit(ctx context.Context, id int, limit money.Money) error {
+	return s.call(ctx, "SetTransferLimit", func() error {
+		return s.next.SetTransferLimit(ctx, id, limit)
+	})
+}
+
+// CreateAuditEntry retries CreateAuditEntry of the wrapped storage on transient failures.
+func (s *ResilientStorage) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	return s.call(ctx, "CreateAuditEntry", func() error {
+		return s.next.CreateAuditEntry(ctx, entry)
+	})
+}
+
+// GetAuditLog retries GetAuditLog of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetAuditLog(ctx context.Context, limit, offset int) (entries []*AuditEntry, total int, err error) {
+	err = s.call(ctx, "GetAuditLog", func() error {
+		entries, total, err = s.next.GetAuditLog(ctx, limit, offset)
+		return err
+	})
+	return entries, total, err
+}
+
+// GetFeatureFlags retries GetFeatureFlags of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetFeatureFlags(ctx context.Context) (flags []*FeatureFlag, err error) {
+	err = s.call(ctx, "GetFeatureFlags", func() error {
+		flags, err = s.next.GetFeatureFlags(ctx)
+		return err
+	})
+	return flags, err
+}
+
+// SetFeatureFlag retries SetFeatureFlag of the wrapped storage on transient failures.
+func (s *ResilientStorage) SetFeatureFlag(ctx context.Context, flag *FeatureFlag) error {
+	return s.call(ctx, "SetFeatureFlag", func() error {
+		return s.next.SetFeatureFlag(ctx, flag)
+	})
+}
+
+// DeleteFeatureFlag retries DeleteFeatureFlag of the wrapped storage on transient failures.
+func (s *ResilientStorage) DeleteFeatureFlag(ctx context.Context, name string) error {
+	return s.call(ctx, "DeleteFeatureFlag", func() error {
+		return s.next.DeleteFeatureFlag(ctx, name)
+	})
+}
+
+// GetMaintenance retries GetMaintenance of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetMaintenance(ctx context.Context) (maintenance *Maintenance, err error) {
+	err = s.call(ctx, "GetMaintenance", func() error {
+		maintenance, err = s.next.GetMaintenance(ctx)
+		return err
+	})
+	return maintenance, err
+}
+
+// SetMaintenance retries SetMaintenance of the wrapped storage on transient failures.
+func (s *ResilientStorage) SetMaintenance(ctx context.Context, maintenance *Maintenance) error {
+	return s.call(ctx, "SetMaintenance", func() error {
+		return s.next.SetMaintenance(ctx, maintenance)
+	})
+}
+
+// ClearMaintenance retries ClearMaintenance of the wrapped storage on transient failures.
+func (s *ResilientStorage) ClearMaintenance(ctx context.Context) error {
+	return s.call(ctx, "ClearMaintenance", func() error {
+		return s.next.ClearMaintenance(ctx)
+	})
+}
+
+// GetNotificationPreferences retries GetNotificationPreferences of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetNotificationPreferences(ctx context.Context, accountID int) (prefs *NotificationPreferences, err error) {
+	err = s.call(ctx, "GetNotificationPreferences", func() error {
+		prefs, err = s.next.GetNotificationPreferences(ctx, accountID)
+		return err
+	})
+	return prefs, err
+}
+
+// SetNotificationPreferences retries SetNotificationPreferences of the wrapped storage on transient failures.
+func (s *ResilientStorage) SetNotificationPreferences(ctx context.Context, prefs *NotificationPreferences) error {
+	return s.call(ctx, "SetNotificationPreferences", func() error {
+		return s.next.SetNotificationPreferences(ctx, prefs)
+	})
+}
+
+// RegisterPushDevice retries RegisterPushDevice of the wrapped storage on transient failures.
+func (s *ResilientStorage) RegisterPushDevice(ctx context.Context, device *PushDevice) error {
+	return s.call(ctx, "RegisterPushDevice", func() error {
+		return s.next.RegisterPushDevice(ctx, device)
+	})
+}
+
+// GetPushDevices retries GetPushDevices of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetPushDevices(ctx context.Context, accountID int) (devices []*PushDevice, err error) {
+	err = s.call(ctx, "GetPushDevices", func() error {
+		devices, err = s.next.GetPushDevices(ctx, accountID)
+		return err
+	})
+	return devices, err
+}
+
+// DeletePushDevice retries DeletePushDevice of the wrapped storage on transient failures.
+func (s *ResilientStorage) DeletePushDevice(ctx context.Context, accountID, id int) error {
+	return s.call(ctx, "DeletePushDevice", func() error {
+		return s.next.DeletePushDevice(ctx, accountID, id)
+	})
+}
+
+// RecordGatewayEvent retries RecordGatewayEvent of the wrapped storage on transient failures.
+func (s *ResilientStorage) RecordGatewayEvent(ctx context.Context, event *GatewayEvent) error {
+	return s.call(ctx, "RecordGatewayEvent", func() error {
+		return s.next.RecordGatewayEvent(ctx, event)
+	})
+}
+
+// DepositFunds retries DepositFunds of the wrapped storage on transient failures.
+func (s *ResilientStorage) DepositFunds(ctx context.Context, deposit *Transaction) error {
+	return s.call(ctx, "DepositFunds", func() error {
+		return s.next.DepositFunds(ctx, deposit)
+	})
+}
+
+// CreateCardTopUp retries CreateCardTopUp of the wrapped storage on transient failures.
+func (s *ResilientStorage) CreateCardTopUp(ctx context.Context, topUp *CardTopUp) error {
+	return s.call(ctx, "CreateCardTopUp", func() error {
+		return s.next.CreateCardTopUp(ctx, topUp)
+	})
+}
+
+// GetCardTopUp retries GetCardTopUp of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetCardTopUp(ctx context.Context, accountID, id int) (topUp *CardTopUp, err error) {
+	err = s.call(ctx, "GetCardTopUp", func() error {
+		topUp, err = s.next.GetCardTopUp(ctx, accountID, id)
+		return err
+	})
+	return topUp, err
+}
+
+// GetCardTopUpByPaymentIntent retries GetCardTopUpByPaymentIntent of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetCardTopUpByPaymentIntent(ctx context.Context, paymentIntentID string) (topUp *CardTopUp, err error) {
+	err = s.call(ctx, "GetCardTopUpByPaymentIntent", func() error {
+		topUp, err = s.next.GetCardTopUpByPaymentIntent(ctx, paymentIntentID)
+		return err
+	})
+	return topUp, err
+}
+
+// UpdateCardTopUp retries UpdateCardTopUp of the wrapped storage on transient failures.
+func (s *ResilientStorage) UpdateCardTopUp(ctx context.Context, topUp *CardTopUp, fromStatus string) error {
+	return s.call(ctx, "UpdateCardTopUp", func() error {
+		return s.next.UpdateCardTopUp(ctx, topUp, fromStatus)
+	})
+}
+
+// CreateConsent retries CreateConsent of the wrapped storage on transient failures.
+func (s *ResilientStorage) CreateConsent(ctx context.Context, consent *Consent) error {
+	return s.call(ctx, "CreateConsent", func() error {
+		return s.next.CreateConsent(ctx, consent)
+	})
+}
+
+// GetConsent retries GetConsent of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetConsent(ctx context.Context, id int) (consent *Consent, err error) {
+	err = s.call(ctx, "GetConsent", func() error {
+		consent, err = s.next.GetConsent(ctx, id)
+		return err
+	})
+	return consent, err
+}
+
+// GetConsents retries GetConsents of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetConsents(ctx context.Context, accountID int) (consents []*Consent, err error) {
+	err = s.call(ctx, "GetConsents", func() error {
+		consents, err = s.next.GetConsents(ctx, accountID)
+		return err
+	})
+	return consents, err
+}
+
+// RevokeConsent retries RevokeConsent of the wrapped storage on transient failures.
+func (s *ResilientStorage) RevokeConsent(ctx context.Context, accountID, id int) error {
+	return s.call(ctx, "RevokeConsent", func() error {
+		return s.next.RevokeConsent(ctx, accountID, id)
+	})
+}
+
+// SetAccountKYCStatus retries SetAccountKYCStatus of the wrapped storage on transient failures.
+func (s *ResilientStorage) SetAccountKYCStatus(ctx context.Context, id int, status string) error {
+	return s.call(ctx, "SetAccountKYCStatus", func() error {
+		return s.next.SetAccountKYCStatus(ctx, id, status)
+	})
+}
+
+// UpdateDormantAccounts retries UpdateDormantAccounts of the wrapped storage on transient failures.
+func (s *ResilientStorage) UpdateDormantAccounts(ctx context.Context, inactiveSince time.Time) (dormant []int, reactivated []int, err error) {
+	err = s.call(ctx, "UpdateDormantAccounts", func() error {
+		dormant, reactivated, err = s.next.UpdateDormantAccounts(ctx, inactiveSince)
+		return err
+	})
+	return dormant, reactivated, err
+}
+
+// CreateKYCCheck retries CreateKYCCheck of the wrapped storage on transient failures.
+func (s *ResilientStorage) CreateKYCCheck(ctx context.Context, check *KYCCheck) error {
+	return s.call(ctx, "CreateKYCCheck", func() error {
+		return s.next.CreateKYCCheck(ctx, check)
+	})
+}
+
+// GetKYCCheck retries GetKYCCheck of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetKYCCheck(ctx context.Context, accountID int) (check *KYCCheck, err error) {
+	err = s.call(ctx, "GetKYCCheck", func() error {
+		check, err = s.next.GetKYCCheck(ctx, accountID)
+		return err
+	})
+	return check, err
+}
+
+// GetKYCCheckByProviderID retries GetKYCCheckByProviderID of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetKYCCheckByProviderID(ctx context.Context, providerCheckID string) (check *KYCCheck, err error) {
+	err = s.call(ctx, "GetKYCCheckByProviderID", func() error {
+		check, err = s.next.GetKYCCheckByProviderID(ctx, providerCheckID)
+		return err
+	})
+	return check, err
+}
+
+// GetOpenKYCChecks retries GetOpenKYCChecks of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetOpenKYCChecks(ctx context.Context, limit int) (checks []*KYCCheck, err error) {
+	err = s.call(ctx, "GetOpenKYCChecks", func() error {
+		checks, err = s.next.GetOpenKYCChecks(ctx, limit)
+		return err
+	})
+	return checks, err
+}
+
+// UpdateKYCCheck retries UpdateKYCCheck of the wrapped storage on transient failures.
+func (s *ResilientStorage) UpdateKYCCheck(ctx context.Context, check *KYCCheck, fromStatus string) error {
+	return s.call(ctx, "UpdateKYCCheck", func() error {
+		return s.next.UpdateKYCCheck(ctx, check, fromStatus)
+	})
+}
+
+// AddOutboxEvents retries AddOutboxEvents of the wrapped storage on transient failures.
+func (s *ResilientStorage) AddOutboxEvents(ctx context.Context, events []*OutboxEvent) error {
+	return s.call(ctx, "AddOutboxEvents", func() error {
+		return s.next.AddOutboxEvents(ctx, events)
+	})
+}
+
+// GetUnpublishedOutboxEvents retries GetUnpublishedOutboxEvents of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetUnpublishedOutboxEvents(ctx context.Context, limit int) (events []*OutboxEvent, err error) {
+	err = s.call(ctx, "GetUnpublishedOutboxEvents", func() error {
+		events, err = s.next.GetUnpublishedOutboxEvents(ctx, limit)
+		return err
+	})
+	return events, err
+}
+
+// MarkOutboxEventsPublished retries MarkOutboxEventsPublished of the wrapped storage on transient failures.
+func (s *ResilientStorage) MarkOutboxEventsPublished(ctx context.Context, ids []int) error {
+	return s.call(ctx, "MarkOutboxEventsPublished", func() error {
+		return s.next.MarkOutboxEventsPublished(ctx, ids)
+	})
+}
+
+// CreateWebhookSecret retries CreateWebhookSecret of the wrapped storage on transient failures.
+func (s *ResilientStorage) CreateWebhookSecret(ctx context.Context, secret *WebhookSecret) error {
+	return s.call(ctx, "CreateWebhookSecret", func() error {
+		return s.next.CreateWebhookSecret(ctx, secret)
+	})
+}
+
+// GetWebhookSecrets retries GetWebhookSecrets of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetWebhookSecrets(ctx context.Context, provider string) (secrets []*WebhookSecret, err error) {
+	err = s.call(ctx, "GetWebhookSecrets", func() error {
+		secrets, err = s.next.GetWebhookSecrets(ctx, provider)
+		return err
+	})
+	return secrets, err
+}
+
+// ExpireWebhookSecret retries ExpireWebhookSecret of the wrapped storage on transient failures.
+func (s *ResilientStorage) ExpireWebhookSecret(ctx context.Context, id int, at time.Time) (secret *WebhookSecret, err error) {
+	err = s.call(ctx, "ExpireWebhookSecret", func() error {
+		secret, err = s.next.ExpireWebhookSecret(ctx, id, at)
+		return err
+	})
+	return secret, err
+}
+
+// GetPublishedOutboxEvents retries GetPublishedOutboxEvents of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetPublishedOutboxEvents(ctx context.Context, filter OutboxEventFilter, afterID, limit int) (events []*OutboxEvent, err error) {
+	err = s.call(ctx, "GetPublishedOutboxEvents", func() error {
+		events, err = s.next.GetPublishedOutboxEvents(ctx, filter, afterID, limit)
+		return err
+	})
+	return events, err
+}
+
+// DeletePublishedOutboxEvents retries DeletePublishedOutboxEvents of the wrapped storage on transient failures.
+func (s *ResilientStorage) DeletePublishedOutboxEvents(ctx context.Context, before time.Time, throughID int) (n int, err error) {
+	err = s.call(ctx, "DeletePublishedOutboxEvents", func() error {
+		n, err = s.next.DeletePublishedOutboxEvents(ctx, before, throughID)
+		return err
+	})
+	return n, err
+}
+
+// RegisterScheduledJob retries RegisterScheduledJob of the wrapped storage on transient failures.
+func (s *ResilientStorage) RegisterScheduledJob(ctx context.Context, job *ScheduledJob) error {
+	return s.call(ctx, "RegisterScheduledJob", func() error {
+		return s.next.RegisterScheduledJob(ctx, job)
+	})
+}
+
+// GetScheduledJobs retries GetScheduledJobs of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetScheduledJobs(ctx context.Context) (jobs []*ScheduledJob, err error) {
+	err = s.call(ctx, "GetScheduledJobs", func() error {
+		jobs, err = s.next.GetScheduledJobs(ctx)
+		return err
+	})
+	return jobs, err
+}
+
+// GetScheduledJob retries GetScheduledJob of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetScheduledJob(ctx context.Context, name string) (job *ScheduledJob, err error) {
+	err = s.call(ctx, "GetScheduledJob", func() error {
+		job, err = s.next.GetScheduledJob(ctx, name)
+		return err
+	})
+	return job, err
+}
+
+// UpdateScheduledJob retries UpdateScheduledJob of the wrapped storage on transient failures.
+func (s *ResilientStorage) UpdateScheduledJob(ctx context.Context, job *ScheduledJob) error {
+	return s.call(ctx, "UpdateScheduledJob", func() error {
+		return s.next.UpdateScheduledJob(ctx, job)
+	})
+}
+
+// LockScheduledJob retries LockScheduledJob of the wrapped storage on transient failures.
+func (s *ResilientStorage) LockScheduledJob(ctx context.Context, name, owner string, now, until time.Time) (job *ScheduledJob, err error) {
+	err = s.call(ctx, "LockScheduledJob", func() error {
+		job, err = s.next.LockScheduledJob(ctx, name, owner, now, until)
+		return err
+	})
+	return job, err
+}
+
+// UnlockScheduledJob retries UnlockScheduledJob of the wrapped storage on transient failures.
+func (s *ResilientStorage) UnlockScheduledJob(ctx context.Context, name, owner string, lastRunAt, nextRunAt *time.Time) error {
+	return s.call(ctx, "UnlockScheduledJob", func() error {
+		return s.next.UnlockScheduledJob(ctx, name, owner, lastRunAt, nextRunAt)
+	})
+}
+
+// CreateJobRun retries CreateJobRun of the wrapped storage on transient failures.
+func (s *ResilientStorage) CreateJobRun(ctx context.Context, run *JobRun) error {
+	return s.call(ctx, "CreateJobRun", func() error {
+		return s.next.CreateJobRun(ctx, run)
+	})
+}
+
+// FinishJobRun retries FinishJobRun of the wrapped storage on transient failures.
+func (s *ResilientStorage) FinishJobRun(ctx context.Context, run *JobRun) error {
+	return s.call(ctx, "FinishJobRun", func() error {
+		return s.next.FinishJobRun(ctx, run)
+	})
+}
+
+// GetJobRuns retries GetJobRuns of the wrapped storage on transient failures.
+func (s *ResilientStorage) GetJobRuns(ctx context.Context, job string, limit int) (runs []*JobRun, err error) {
+	err = s.call(ctx, "GetJobRuns", func() error {
+		runs, err = s.next.GetJobRuns(ctx, job, limit)
+		return err
+	})
+	return runs, err
+}
+
+// ReserveIdempotencyKey retries ReserveIdempotencyKey of the wrapped storage on transient failures.
+func (s *ResilientStorage) ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) (stored *IdempotencyRecord, err error) {
+	err = s.call(ctx, "ReserveIdempotencyKey", func() error {
+		stored, err = s.next.ReserveIdempotencyKey(ctx, record)
+		return err
+	})
+	return stored, err
+}
+
+// CompleteIdempotencyKey retries CompleteIdempotencyKey of the wrapped storage on transient failures.
+func (s *ResilientStorage) CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	return s.call(ctx, "CompleteIdempotencyKey", func() error {
+		return s.next.CompleteIdempotencyKey(ctx, record)
+	})
+}
+
+// ReleaseIdempotencyKey retries ReleaseIdempotencyKey of the wrapped storage on transient failures.
+func (s *ResilientStorage) ReleaseIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	return s.call(ctx, "ReleaseIdempotencyKey", func() error {
+		return s.next.ReleaseIdempotencyKey(ctx, record)
+	})
+}
+
+// DeleteExpiredIdempotencyKeys retries DeleteExpiredIdempotencyKeys of the wrapped storage on transient failures.
+func (s *ResilientStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (n int, err error) {
+	err = s.call(ctx, "DeleteExpiredIdempotencyKeys", func() error {
+		n, err = s.next.DeleteExpiredIdempotencyKeys(ctx, now)
+		return err
+	})
+	return n, err
+}
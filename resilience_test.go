@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// flakyTxStorage fails its transactions with the errors in errs, one per call, then
+// runs them.
+type flakyTxStorage struct {
+	Storage
+	errs  []error
+	calls int
+}
+
+func (s *flakyTxStorage) WithTx(ctx context.Context, fn func(Storage) error) error {
+	s.calls++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return err
+	}
+	return fn(s)
+}
+
+func TestRetryTx(t *testing.T) {
+	serialization := &pgconn.PgError{Code: pgCodeSerializationFailure}
+	deadlock := &pgconn.PgError{Code: pgCodeDeadlockDetected}
+	uniqueViolation := &pgconn.PgError{Code: "23505"}
+	refused := errors.New("refused")
+
+	tests := []struct {
+		name  string
+		errs  []error
+		calls int
+		err   error
+	}{
+		{"committed", nil, 1, nil},
+		{"serialization failure then committed", []error{serialization}, 2, nil},
+		{"deadlock then committed", []error{deadlock, serialization}, 3, nil},
+		{"version conflict then committed", []error{ErrAccountVersionConflict}, 2, nil},
+		{"out of attempts", []error{serialization, serialization, serialization, serialization}, 3, serialization},
+		{"other database error", []error{uniqueViolation}, 1, uniqueViolation},
+		{"other error", []error{refused}, 1, refused},
+	}
+
+	cfg := &DatabaseConfig{RetryAttempts: 3, RetryBackoff: time.Millisecond}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &flakyTxStorage{errs: tt.errs}
+			ran := 0
+			err := retryTx(context.Background(), store, cfg, "test", func(Storage) error {
+				ran++
+				return nil
+			})
+
+			if !errors.Is(err, tt.err) {
+				t.Errorf("retryTx error = %v, want %v", err, tt.err)
+			}
+			if store.calls != tt.calls {
+				t.Errorf("retryTx ran %d transactions, want %d", store.calls, tt.calls)
+			}
+			if tt.err == nil && ran != 1 {
+				t.Errorf("retryTx ran the callback %d times, want once", ran)
+			}
+		})
+	}
+}
+
+func TestRetryTxStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	serialization := &pgconn.PgError{Code: pgCodeSerializationFailure}
+	store := &flakyTxStorage{errs: []error{serialization, serialization}}
+	cfg := &DatabaseConfig{RetryAttempts: 3, RetryBackoff: time.Hour}
+	err := retryTx(ctx, store, cfg, "test", func(Storage) error { return nil })
+
+	if !errors.Is(err, serialization) {
+		t.Errorf("retryTx error = %v, want %v", err, serialization)
+	}
+	if store.calls != 1 {
+		t.Errorf("retryTx ran %d transactions after the context was cancelled, want 1", store.calls)
+	}
+}
@@ -0,0 +1,804 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/moabdelazem/gobank/money"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+)
+
+// Scheduled Job Names
+const (
+	jobInterestAccrual     = "interest_accrual"
+	jobStatementGeneration = "statement_generation"
+	jobDormancyCheck       = "dormancy_check"
+	jobOutboxArchival      = "outbox_archival"
+)
+
+// Default Scheduler Settings
+const (
+	defaultSchedulerPollInterval = 30 * time.Second
+	defaultSchedulerLockTTL      = time.Hour
+	defaultDormancyAfter         = 365 * 24 * time.Hour
+	// maxInterestAnnualRateBps bounds jobs.interest_annual_rate_bps, 100% a year.
+	maxInterestAnnualRateBps = 10000
+	defaultJobRunsLimit      = 20
+	maxJobRunsLimit          = 100
+	outboxArchiveBatchSize   = 500
+)
+
+// Job Run Triggers
+const (
+	JobTriggerSchedule = "schedule"
+	JobTriggerManual   = "manual"
+)
+
+// Job Run Statuses
+const (
+	JobRunStatusRunning   = "running"
+	JobRunStatusSucceeded = "succeeded"
+	JobRunStatusFailed    = "failed"
+	// JobRunStatusAbandoned is shown for a run left running by an instance that stopped
+	// or lost its lease; it is not stored.
+	JobRunStatusAbandoned = "abandoned"
+)
+
+// Scheduler Audit Actions
+const (
+	AuditActionUpdateScheduledJob = "scheduled_job.update"
+	AuditActionRunScheduledJob    = "scheduled_job.run"
+)
+
+// jobRuns counts the finished runs of the scheduled jobs, registered by newMetricsRegistry.
+var jobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace, Subsystem: "scheduler", Name: "job_runs_total",
+	Help: "Number of finished scheduled job runs, by job and status.",
+}, []string{"job", "status"})
+
+// jobRunDuration times the runs of the scheduled jobs, registered by newMetricsRegistry.
+var jobRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: metricsNamespace, Subsystem: "scheduler", Name: "job_run_duration_seconds",
+	Help:    "Duration of the scheduled job runs, by job.",
+	Buckets: []float64{.1, .5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600},
+}, []string{"job"})
+
+// ScheduledJob is a job of the scheduler as stored: its cron schedule, when it runs
+// next, and the lease of the instance running it.
+type ScheduledJob struct {
+	Name string `json:"name"`
+	// Schedule is a cron expression of five fields or a descriptor such as @daily,
+	// evaluated in UTC unless it starts with CRON_TZ=.
+	Schedule  string     `json:"schedule"`
+	Enabled   bool       `json:"enabled"`
+	NextRunAt time.Time  `json:"next_run_at"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	// LockedBy is the instance holding the lease of the job until LockedUntil, empty when
+	// the job is idle.
+	LockedBy    string     `json:"locked_by,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// heldBy reports whether instance holds an unexpired lease of the job.
+func (j *ScheduledJob) heldBy(instance string, now time.Time) bool {
+	return j.LockedBy == instance && j.LockedUntil != nil && !j.LockedUntil.Before(now)
+}
+
+// JobRun is a run of a scheduled job, in the run history.
+type JobRun struct {
+	ID      int    `json:"id"`
+	Job     string `json:"job"`
+	Trigger string `json:"trigger"`
+	Status  string `json:"status"`
+	// Instance is the instance that ran the job.
+	Instance string `json:"instance"`
+	// Summary tells what the run did, such as the number of accounts credited.
+	Summary    string     `json:"summary,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ScheduledJobView is a scheduled job as listed by the admin API, with its latest run,
+// or with its recent runs when a single job is retrieved.
+type ScheduledJobView struct {
+	*ScheduledJob
+	LastRun *JobRun   `json:"last_run,omitempty"`
+	Runs    []*JobRun `json:"runs,omitempty"`
+}
+
+// UpdateScheduledJobRequest is the body of PUT /admin/jobs/{name}. Only the fields that
+// are set are changed.
+type UpdateScheduledJobRequest struct {
+	Schedule *string `json:"schedule"`
+	Enabled  *bool   `json:"enabled"`
+}
+
+// Validate checks that the request changes something and that the schedule parses.
+func (req *UpdateScheduledJobRequest) Validate() []FieldError {
+	var errs []FieldError
+	if req.Schedule == nil && req.Enabled == nil {
+		errs = append(errs, FieldError{Field: "schedule", Code: CodeRequired, Message: "schedule or enabled is required"})
+	}
+	if req.Schedule != nil {
+		if _, err := cron.ParseStandard(*req.Schedule); err != nil {
+			errs = append(errs, FieldError{Field: "schedule", Code: CodeInvalid, Message: fmt.Sprintf("schedule must be a cron expression: %v", err)})
+		}
+	}
+	return errs
+}
+
+// AccountDormancyEvent is the payload of the account.dormant and account.reactivated events.
+type AccountDormancyEvent struct {
+	AccountID int       `json:"account_id"`
+	At        time.Time `json:"at"`
+}
+
+// schedulerJob is a job registered with the scheduler.
+type schedulerJob struct {
+	name string
+	// schedule is the cron expression the job is stored with the first time; an
+	// administrator may change it afterwards.
+	schedule string
+	// run does the work of the job and tells what it did.
+	run func(ctx context.Context) (summary string, err error)
+}
+
+// scheduler runs the registered jobs on their cron schedules. The jobs are stored, so
+// that their schedule can be changed at runtime and their runs are kept, and every run
+// takes the lease of the job in the store, so that a job runs on one instance of the
+// fleet at a time, whatever the backend. The lease lasts jobs.scheduler_lock_ttl, which
+// also bounds the run: an instance stopping mid-run leaves the job to the others once it
+// has run out.
+type scheduler struct {
+	store    Storage
+	instance string
+	poll     time.Duration
+	lockTTL  time.Duration
+	jobs     []*schedulerJob
+
+	mu sync.Mutex
+	// ctx is the context of Run, which the runs are bound to; nil until Run starts.
+	ctx context.Context
+	// registered tells whether the jobs have been stored, which Run retries until done.
+	registered bool
+	// running holds the jobs running on this instance.
+	running map[string]bool
+}
+
+// newScheduler creates the scheduler of the jobs, which Run starts.
+func newScheduler(cfg JobsConfig, store Storage, jobs []*schedulerJob) *scheduler {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "gobank"
+	}
+	return &scheduler{
+		store:    store,
+		instance: fmt.Sprintf("%s-%d", host, os.Getpid()),
+		poll:     cfg.SchedulerPollInterval,
+		lockTTL:  cfg.SchedulerLockTTL,
+		jobs:     jobs,
+		running:  map[string]bool{},
+	}
+}
+
+// job returns the registered job with the given name, or nil.
+func (s *scheduler) job(name string) *schedulerJob {
+	for _, job := range s.jobs {
+		if job.name == name {
+			return job
+		}
+	}
+	return nil
+}
+
+// Run stores the registered jobs and then starts the ones due every poll interval,
+// until ctx is cancelled.
+func (s *scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(s.poll)
+	defer ticker.Stop()
+
+	for {
+		if s.register(ctx) {
+			s.startDue(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// register stores the jobs unless it has done so already, and reports whether they are stored.
+func (s *scheduler) register(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.registered {
+		return true
+	}
+
+	now := time.Now().UTC()
+	for _, job := range s.jobs {
+		schedule, err := cron.ParseStandard(job.schedule)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid Job Schedule", "job", job.name, "schedule", job.schedule, "error", err)
+			continue
+		}
+		stored := &ScheduledJob{Name: job.name, Schedule: job.schedule, Enabled: true, NextRunAt: schedule.Next(now)}
+		if err := s.store.RegisterScheduledJob(ctx, stored); err != nil {
+			slog.ErrorContext(ctx, "Error Registering The Scheduled Jobs", "error", err)
+			return false
+		}
+	}
+	s.registered = true
+	return true
+}
+
+// startDue starts the enabled jobs whose next run has come.
+func (s *scheduler) startDue(ctx context.Context) {
+	stored, err := s.store.GetScheduledJobs(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error Loading The Scheduled Jobs", "error", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, job := range stored {
+		if !job.Enabled || job.NextRunAt.After(now) || s.job(job.Name) == nil {
+			continue
+		}
+		if _, err := s.start(ctx, job.Name, JobTriggerSchedule); err != nil && !errors.Is(err, ErrScheduledJobLocked) {
+			slog.ErrorContext(ctx, "Error Starting The Scheduled Job", "job", job.Name, "error", err)
+		}
+	}
+}
+
+// start takes the lease of a job and runs it in the background. A scheduled run is
+// skipped, returning a nil run, when another instance ran the job since it was found due.
+//
+// Parameters:
+//   - ctx: The context of the store calls taking the lease.
+//   - name: The name of the job.
+//   - trigger: JobTriggerSchedule or JobTriggerManual.
+//
+// Returns:
+//   - *JobRun: The run started.
+//   - error: ErrScheduledJobNotFound if the job is not registered, ErrScheduledJobLocked
+//     if it is running, otherwise the error of the store.
+func (s *scheduler) start(ctx context.Context, name, trigger string) (*JobRun, error) {
+	job := s.job(name)
+	if job == nil {
+		return nil, fmt.Errorf("%w: %s", ErrScheduledJobNotFound, name)
+	}
+
+	s.mu.Lock()
+	if s.running[name] {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrScheduledJobLocked, name)
+	}
+	s.running[name] = true
+	base := s.ctx
+	s.mu.Unlock()
+
+	started := false
+	defer func() {
+		if !started {
+			s.finished(name)
+		}
+	}()
+
+	now := time.Now().UTC()
+	stored, err := s.store.LockScheduledJob(ctx, name, s.instance, now, now.Add(s.lockTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	// Another Instance May Have Run It Between The Poll And The Lease
+	if trigger == JobTriggerSchedule && (!stored.Enabled || stored.NextRunAt.After(now)) {
+		if err := s.store.UnlockScheduledJob(ctx, name, s.instance, nil, nil); err != nil {
+			slog.WarnContext(ctx, "Error Releasing The Job Lease", "job", name, "error", err)
+		}
+		return nil, nil
+	}
+
+	run := &JobRun{Job: name, Trigger: trigger, Status: JobRunStatusRunning, Instance: s.instance}
+	if err := s.store.CreateJobRun(ctx, run); err != nil {
+		if err := s.store.UnlockScheduledJob(ctx, name, s.instance, nil, nil); err != nil {
+			slog.WarnContext(ctx, "Error Releasing The Job Lease", "job", name, "error", err)
+		}
+		return nil, err
+	}
+
+	if base == nil {
+		base = context.WithoutCancel(ctx)
+	}
+	started = true
+	go s.execute(base, job, stored, run)
+	return run, nil
+}
+
+// execute runs a job under a deadline of the lease, records the outcome of the run,
+// and releases the lease, scheduling the next run after a scheduled one.
+func (s *scheduler) execute(ctx context.Context, job *schedulerJob, stored *ScheduledJob, run *JobRun) {
+	defer s.finished(job.name)
+
+	ctx = withLogAttrs(ctx, slog.String("job", job.name), slog.Int("job_run_id", run.ID))
+	runCtx, cancel := context.WithTimeout(ctx, s.lockTTL)
+	defer cancel()
+
+	slog.InfoContext(ctx, "Scheduled Job Started", "trigger", run.Trigger)
+	summary, err := job.run(runCtx)
+	duration := time.Since(run.StartedAt)
+
+	run.Status, run.Summary = JobRunStatusSucceeded, summary
+	if err != nil {
+		run.Status, run.Error = JobRunStatusFailed, err.Error()
+		slog.ErrorContext(ctx, "Scheduled Job Failed", "duration", duration, "summary", summary, "error", err)
+	} else {
+		slog.InfoContext(ctx, "Scheduled Job Succeeded", "duration", duration, "summary", summary)
+	}
+	jobRuns.WithLabelValues(job.name, run.Status).Inc()
+	jobRunDuration.WithLabelValues(job.name).Observe(duration.Seconds())
+
+	// Record The Outcome Even When The Server Is Stopping
+	ctx = context.WithoutCancel(ctx)
+	if err := s.store.FinishJobRun(ctx, run); err != nil {
+		slog.ErrorContext(ctx, "Error Recording The Job Run", "error", err)
+	}
+
+	var next *time.Time
+	if run.Trigger == JobTriggerSchedule {
+		next = nextRun(stored.Schedule, job.schedule, time.Now().UTC())
+	}
+	if err := s.store.UnlockScheduledJob(ctx, job.name, s.instance, &run.StartedAt, next); err != nil {
+		slog.WarnContext(ctx, "Error Releasing The Job Lease", "error", err)
+	}
+}
+
+// finished marks a job as no longer running on this instance.
+func (s *scheduler) finished(name string) {
+	s.mu.Lock()
+	delete(s.running, name)
+	s.mu.Unlock()
+}
+
+// nextRun returns the first time after now of the stored schedule of a job, or of its
+// default schedule when the stored one does not parse.
+func nextRun(schedule, fallback string, now time.Time) *time.Time {
+	parsed, err := cron.ParseStandard(schedule)
+	if err != nil {
+		parsed, _ = cron.ParseStandard(fallback)
+	}
+	next := parsed.Next(now)
+	return &next
+}
+
+// scheduledJobs returns the jobs of the scheduler enabled by the configuration:
+// the interest accrual with jobs.interest_annual_rate_bps, the statement generation and
+// the outbox archival with the document storage, the latter with jobs.outbox_retention
+// too, and the dormancy check with jobs.dormancy_after.
+func (as *APIServer) scheduledJobs() []*schedulerJob {
+	cfg := as.config.Jobs
+	jobs := []*schedulerJob{}
+	if cfg.InterestAnnualRateBps > 0 {
+		jobs = append(jobs, &schedulerJob{name: jobInterestAccrual, schedule: "0 1 1 * *", run: as.runInterestAccrual})
+	}
+	if as.documents != nil {
+		jobs = append(jobs, &schedulerJob{name: jobStatementGeneration, schedule: "0 3 1 * *", run: as.runStatementGeneration})
+	}
+	if cfg.DormancyAfter > 0 {
+		jobs = append(jobs, &schedulerJob{name: jobDormancyCheck, schedule: "0 4 * * *", run: as.runDormancyCheck})
+	}
+	if cfg.OutboxRetention > 0 && as.documents != nil {
+		jobs = append(jobs, &schedulerJob{name: jobOutboxArchival, schedule: "0 5 * * 0", run: as.runOutboxArchival})
+	}
+	return jobs
+}
+
+// previousPeriod returns the statement period of the month before the one of now, and its bounds.
+func previousPeriod(now time.Time) (period string, start, end time.Time) {
+	end = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	start = end.AddDate(0, -1, 0)
+	return start.Format(statementPeriodLayout), start, end
+}
+
+// runInterestAccrual credits every account with the interest of the previous month on
+// its closing balance, at jobs.interest_annual_rate_bps a year for the days of the
+// month, rounded down to the minor unit. An account credited for the month already is
+// skipped, so the job can be run again after a failure.
+func (as *APIServer) runInterestAccrual(ctx context.Context) (string, error) {
+	period, start, end := previousPeriod(time.Now().UTC())
+	reference := "interest-" + period
+	days := int64(end.Sub(start).Hours() / 24)
+
+	accounts, err := as.store.GetAccounts(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// The Interest Credited In Each Currency
+	credited, totals := 0, map[string]money.Money{}
+	for _, acc := range accounts {
+		if !acc.CreatedAt.Before(end) {
+			continue
+		}
+		closing, err := as.store.GetBalanceAt(ctx, acc.ID, end)
+		if err != nil {
+			return fmt.Sprintf("%d accounts credited", credited), err
+		}
+		interest, err := accruedInterest(closing, as.config.Jobs.InterestAnnualRateBps, days)
+		if err != nil {
+			return fmt.Sprintf("%d accounts credited", credited), err
+		}
+		if !interest.IsPositive() {
+			continue
+		}
+
+		existing, err := as.store.GetTransactions(ctx, acc.ID, &TransactionFilter{Types: []string{TransactionTypeInterest}, CreatedAfter: end})
+		if err != nil {
+			return fmt.Sprintf("%d accounts credited", credited), err
+		}
+		if hasReference(existing, reference) {
+			continue
+		}
+
+		deposit := &Transaction{AccountID: acc.ID, Type: TransactionTypeInterest, Amount: interest, Reference: reference}
+		err = as.store.WithTx(ctx, func(tx Storage) error {
+			if err := tx.DepositFunds(ctx, deposit); err != nil {
+				return err
+			}
+			event, err := newOutboxEvent(EventInterestCredited, acc.ID, deposit)
+			if err != nil {
+				return err
+			}
+			return tx.AddOutboxEvents(ctx, []*OutboxEvent{event})
+		})
+		if err != nil {
+			return fmt.Sprintf("%d accounts credited", credited), err
+		}
+		as.publishTransactions([]*Transaction{deposit})
+		credited++
+		total, ok := totals[acc.Currency]
+		if !ok {
+			total = money.Zero(acc.Currency)
+		}
+		if totals[acc.Currency], err = total.Add(interest); err != nil {
+			return fmt.Sprintf("%d accounts credited", credited), err
+		}
+	}
+
+	sums := make([]string, 0, len(totals))
+	for _, currency := range slices.Sorted(maps.Keys(totals)) {
+		sums = append(sums, totals[currency].String())
+	}
+	if len(sums) == 0 {
+		sums = append(sums, "nothing")
+	}
+	return fmt.Sprintf("%d accounts credited %s in interest for %s", credited, strings.Join(sums, ", "), period), nil
+}
+
+// accruedInterest returns the simple interest of a balance for a number of days at an
+// annual rate in basis points, on a 365 day year, rounded down to the minor unit. A
+// balance that is not positive accrues none.
+//
+// Parameters:
+//   - balance: The balance the interest is accrued on.
+//   - rateBps: The annual rate, in basis points.
+//   - days: The number of days of the period.
+//
+// Returns:
+//   - money.Money: The interest, in the currency of the balance.
+//   - error: money.ErrOverflow if the interest is out of range, otherwise nil.
+func accruedInterest(balance money.Money, rateBps int, days int64) (money.Money, error) {
+	if !balance.IsPositive() {
+		return money.Zero(balance.Currency), nil
+	}
+	return balance.MulRat(big.NewRat(int64(rateBps)*days, 10000*365), money.RoundDown)
+}
+
+// hasReference reports whether one of the ledger entries has the reference.
+func hasReference(txns []*Transaction, reference string) bool {
+	for _, t := range txns {
+		if t.Reference == reference {
+			return true
+		}
+	}
+	return false
+}
+
+// runStatementGeneration stores the PDF statement of the previous month of every
+// account opened before the month ended in the document storage. The statements stored
+// already are kept, see storeStatementPDF; a failed account does not stop the others.
+func (as *APIServer) runStatementGeneration(ctx context.Context) (string, error) {
+	period, _, end := previousPeriod(time.Now().UTC())
+
+	accounts, err := as.store.GetAccounts(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	stored, failed := 0, 0
+	var firstErr error
+	for _, acc := range accounts {
+		if ctx.Err() != nil {
+			return fmt.Sprintf("%d statements stored for %s", stored, period), ctx.Err()
+		}
+		if !acc.CreatedAt.Before(end) {
+			continue
+		}
+		stmt, err := as.buildStatement(ctx, acc.ID, period)
+		if err == nil {
+			_, err = as.storeStatementPDF(ctx, stmt)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error Generating The Statement", "account_id", acc.ID, "period", period, "error", err)
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		stored++
+	}
+
+	summary := fmt.Sprintf("%d statements stored for %s", stored, period)
+	if failed > 0 {
+		return summary, fmt.Errorf("%d statements failed, the first with: %w", failed, firstErr)
+	}
+	return summary, nil
+}
+
+// runDormancyCheck marks the accounts without transactions for jobs.dormancy_after as
+// dormant, and clears the mark of the dormant accounts used since, publishing an
+// account.dormant or account.reactivated event for each.
+func (as *APIServer) runDormancyCheck(ctx context.Context) (string, error) {
+	now := time.Now().UTC()
+
+	var dormant, reactivated []int
+	err := as.store.WithTx(ctx, func(tx Storage) error {
+		var err error
+		if dormant, reactivated, err = tx.UpdateDormantAccounts(ctx, now.Add(-as.config.Jobs.DormancyAfter)); err != nil {
+			return err
+		}
+
+		events := make([]*OutboxEvent, 0, len(dormant)+len(reactivated))
+		for eventType, ids := range map[string][]int{EventAccountDormant: dormant, EventAccountReactivated: reactivated} {
+			for _, id := range ids {
+				event, err := newOutboxEvent(eventType, id, &AccountDormancyEvent{AccountID: id, At: now})
+				if err != nil {
+					return err
+				}
+				events = append(events, event)
+			}
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		return tx.AddOutboxEvents(ctx, events)
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d accounts dormant, %d reactivated", len(dormant), len(reactivated)), nil
+}
+
+// runOutboxArchival moves the published outbox events older than jobs.outbox_retention
+// to the document storage, as one JSON event per line, and then removes them from the
+// store. Archived events can no longer be replayed.
+func (as *APIServer) runOutboxArchival(ctx context.Context) (string, error) {
+	cutoff := time.Now().UTC().Add(-as.config.Jobs.OutboxRetention)
+	filter := OutboxEventFilter{CreatedBefore: cutoff}
+
+	batch, err := as.store.GetPublishedOutboxEvents(ctx, filter, 0, outboxArchiveBatchSize)
+	if err != nil {
+		return "", err
+	}
+	if len(batch) == 0 {
+		return "no events to archive", nil
+	}
+
+	key := fmt.Sprintf("archives/outbox/%s-%d.jsonl", cutoff.Format("20060102T150405Z"), batch[0].ID)
+	doc := DocumentInfo{ContentType: "application/x-ndjson", ContentDisposition: fmt.Sprintf("attachment; filename=%q", "outbox-"+strconv.Itoa(batch[0].ID)+".jsonl")}
+
+	archived, lastID := 0, 0
+	_, err = as.storeDocument(ctx, key, doc, false, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for len(batch) > 0 {
+			for _, event := range batch {
+				if err := enc.Encode(event); err != nil {
+					return err
+				}
+				lastID = event.ID
+				archived++
+			}
+			if len(batch) < outboxArchiveBatchSize {
+				return nil
+			}
+			var err error
+			if batch, err = as.store.GetPublishedOutboxEvents(ctx, filter, lastID, outboxArchiveBatchSize); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	deleted, err := as.store.DeletePublishedOutboxEvents(ctx, cutoff, lastID)
+	if err != nil {
+		return fmt.Sprintf("%d events archived to %s", archived, key), err
+	}
+	return fmt.Sprintf("%d events archived to %s, %d removed", archived, key, deleted), nil
+}
+
+// jobView returns a stored job with its runs, showing the runs left running by an
+// instance that no longer holds the lease as abandoned.
+func jobView(job *ScheduledJob, runs []*JobRun, now time.Time) *ScheduledJobView {
+	for _, run := range runs {
+		if run.Status == JobRunStatusRunning && !job.heldBy(run.Instance, now) {
+			run.Status = JobRunStatusAbandoned
+		}
+	}
+	return &ScheduledJobView{ScheduledJob: job, Runs: runs}
+}
+
+// handleAdminListJobs lists the scheduled jobs, each with its latest run.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request of the listing.
+//
+// Returns:
+//   - error: The error of the store.
+func (as *APIServer) handleAdminListJobs(w http.ResponseWriter, r *http.Request) error {
+	jobs, err := as.store.GetScheduledJobs(r.Context())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	views := make([]*ScheduledJobView, 0, len(jobs))
+	for _, job := range jobs {
+		runs, err := as.store.GetJobRuns(r.Context(), job.Name, 1)
+		if err != nil {
+			return err
+		}
+		view := jobView(job, runs, now)
+		if len(view.Runs) > 0 {
+			view.LastRun, view.Runs = view.Runs[0], nil
+		}
+		views = append(views, view)
+	}
+	return WriteResponse(w, r, http.StatusOK, views)
+}
+
+// handleAdminGetJob retrieves a scheduled job with its most recent runs, as many as the
+// "limit" query parameter asks for, 20 by default and at most 100.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the name of the job and the optional limit.
+//
+// Returns:
+//   - error: A 400 for an invalid limit, a 404 if no job has the name, or the error of the store.
+func (as *APIServer) handleAdminGetJob(w http.ResponseWriter, r *http.Request) error {
+	limit := defaultJobRunsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxJobRunsLimit {
+			return NewTypedError(http.StatusBadRequest, "bad_request", fmt.Sprintf("limit must be between 1 and %d", maxJobRunsLimit))
+		}
+		limit = parsed
+	}
+
+	job, err := as.store.GetScheduledJob(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		return err
+	}
+	runs, err := as.store.GetJobRuns(r.Context(), job.Name, limit)
+	if err != nil {
+		return err
+	}
+	return WriteResponse(w, r, http.StatusOK, jobView(job, runs, time.Now().UTC()))
+}
+
+// handleAdminUpdateJob changes the schedule of a scheduled job or enables or disables
+// it. A new schedule, or enabling the job, moves its next run to the next time of the
+// schedule. The change is recorded in the audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the name of the job and the UpdateScheduledJobRequest.
+//
+// Returns:
+//   - error: A 400 for an invalid request, a 404 if no job has the name, or the error of the store.
+func (as *APIServer) handleAdminUpdateJob(w http.ResponseWriter, r *http.Request) error {
+	req := UpdateScheduledJobRequest{}
+	if err := bindJSON(w, r, &req); err != nil {
+		return err
+	}
+
+	job, err := as.store.GetScheduledJob(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		return err
+	}
+
+	reschedule := false
+	if req.Schedule != nil && *req.Schedule != job.Schedule {
+		job.Schedule, reschedule = *req.Schedule, true
+	}
+	if req.Enabled != nil && *req.Enabled != job.Enabled {
+		job.Enabled, reschedule = *req.Enabled, reschedule || *req.Enabled
+	}
+	if reschedule {
+		// Validate Parsed The Schedule Already
+		schedule, _ := cron.ParseStandard(job.Schedule)
+		job.NextRunAt = schedule.Next(time.Now().UTC())
+	}
+	if err := as.store.UpdateScheduledJob(r.Context(), job); err != nil {
+		return err
+	}
+
+	as.audit(r, AuditActionUpdateScheduledJob, "job:"+job.Name, map[string]interface{}{"schedule": job.Schedule, "enabled": job.Enabled})
+	slog.InfoContext(r.Context(), "Scheduled Job Updated", "job", job.Name, "schedule", job.Schedule, "enabled", job.Enabled, "next_run_at", job.NextRunAt)
+	return WriteResponse(w, r, http.StatusOK, job)
+}
+
+// handleAdminRunJob starts a run of a scheduled job at once, in the background, on this
+// instance, whether the job is enabled or not; its next scheduled run is unchanged. The
+// run is recorded in the audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the name of the job.
+//
+// Returns:
+//   - error: A 404 if the job is not registered on this instance, a 409 if it is
+//     running, or the error of the store.
+func (as *APIServer) handleAdminRunJob(w http.ResponseWriter, r *http.Request) error {
+	run, err := as.scheduler.start(r.Context(), mux.Vars(r)["name"], JobTriggerManual)
+	if err != nil {
+		return err
+	}
+
+	as.audit(r, AuditActionRunScheduledJob, "job:"+run.Job, map[string]interface{}{"run_id": run.ID})
+	return WriteResponse(w, r, http.StatusAccepted, run)
+}
+
+// registerSchedulerRoutes registers the endpoints inspecting and triggering the
+// scheduled jobs on the admin subrouter.
+//
+// Routes:
+// - GET /admin/jobs: Lists the scheduled jobs with their latest run.
+// - GET /admin/jobs/{name}: Retrieves a scheduled job with its recent runs, ?limit= of them.
+// - PUT /admin/jobs/{name}: Changes the schedule of a job, or enables or disables it.
+// - POST /admin/jobs/{name}/run: Runs a job at once, in the background.
+func (as *APIServer) registerSchedulerRoutes(admin *mux.Router) {
+	admin.HandleFunc("/jobs", makeHTTPHandlerFunc(as.handleAdminListJobs)).Methods(http.MethodGet)
+	admin.HandleFunc("/jobs/{name:[a-z_]+}", makeHTTPHandlerFunc(as.handleAdminGetJob)).Methods(http.MethodGet)
+	admin.HandleFunc("/jobs/{name:[a-z_]+}", makeHTTPHandlerFunc(as.handleAdminUpdateJob)).Methods(http.MethodPut)
+	admin.HandleFunc("/jobs/{name:[a-z_]+}/run", makeHTTPHandlerFunc(as.handleAdminRunJob)).Methods(http.MethodPost)
+}
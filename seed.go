@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/moabdelazem/gobank/money"
+)
+
+// Default Seed Settings
+const (
+	defaultSeedAccounts  = 25
+	defaultSeedDays      = 90
+	defaultSeedTransfers = 10
+)
+
+// Seed Amounts, In The Smallest Currency Unit
+const (
+	seedMinOpeningBalance = 50_000
+	seedMaxOpeningBalance = 2_500_000
+	seedMinTransfer       = 100
+)
+
+// seedAccountNumbers is the size of the account number space, see NewAccount.
+const seedAccountNumbers = 10000
+
+// seedFirstNames and seedLastNames are combined into the names of the seeded accounts.
+var (
+	seedFirstNames = []string{"Amira", "Ben", "Carla", "Daniel", "Elena", "Farid", "Grace", "Hassan", "Ines", "Jonas", "Karima", "Liam", "Maya", "Nour", "Omar", "Paula", "Quentin", "Rana", "Samuel", "Tara", "Youssef", "Zoe"}
+	seedLastNames  = []string{"Abdelaziz", "Becker", "Costa", "Dubois", "Eriksen", "Fahmy", "Garcia", "Haddad", "Ivanova", "Jensen", "Khalil", "Lopez", "Mansour", "Novak", "Okafor", "Petrov", "Rossi", "Saleh", "Tanaka", "Weber"}
+)
+
+// seedCategories are the categories of the seeded transfers, the empty one included.
+var seedCategories = []string{"", "groceries", "rent", "salary", "utilities", "dining", "travel", "shopping", "health"}
+
+// SeedOptions sets the amount of fake data generated by seedStore.
+type SeedOptions struct {
+	// Accounts is the number of accounts to create.
+	Accounts int
+	// Days is how far back the generated history reaches.
+	Days int
+	// TransfersPerDay is the average number of transfers between the accounts per day.
+	TransfersPerDay int
+	// RandSeed makes the generated data reproducible; 0 picks a random one.
+	RandSeed int64
+	// Currency is the currency of the accounts.
+	Currency string
+}
+
+// defaultSeedOptions are the options of the -seed flag and the defaults of "gobank seed",
+// in the currency the accounts default to.
+var defaultSeedOptions = SeedOptions{Accounts: defaultSeedAccounts, Days: defaultSeedDays, TransfersPerDay: defaultSeedTransfers, Currency: defaultAccountCurrency}
+
+// seedOptions returns defaultSeedOptions in the default currency of the accounts.
+func (c *Config) seedOptions() SeedOptions {
+	opts := defaultSeedOptions
+	opts.Currency = c.Accounts.DefaultCurrency
+	return opts
+}
+
+// SeedStats counts the rows created by seedStore.
+type SeedStats struct {
+	Accounts     int `json:"accounts"`
+	Transactions int `json:"transactions"`
+}
+
+// seedEntry is a generated ledger entry whose account IDs are only known once the
+// accounts are stored.
+type seedEntry struct {
+	account      *Account
+	counterparty *Account
+	txn          *Transaction
+}
+
+// seedStore fills the store with fake accounts and a consistent transaction history:
+// every account opens with a deposit, and random transfers between the accounts follow
+// over the past opts.Days days. The balance stored on each account is the balance
+// after its last ledger entry, so the history adds up. Everything is stored in one
+// transaction; when a drawn account number is taken the accounts are drawn again.
+//
+// Parameters:
+//   - ctx: The context of the database work.
+//   - store: The store to fill.
+//   - opts: The amount of data to generate.
+//
+// Returns:
+//   - *SeedStats: The number of accounts and ledger entries created.
+//   - error: An error if the data cannot be stored.
+func seedStore(ctx context.Context, store Storage, opts SeedOptions) (*SeedStats, error) {
+	if opts.Accounts < 2 || opts.Accounts > seedAccountNumbers {
+		return nil, fmt.Errorf("accounts must be between 2 and %d", seedAccountNumbers)
+	}
+	if opts.Days < 1 || opts.TransfersPerDay < 0 {
+		return nil, fmt.Errorf("days must be at least 1 and transfers must not be negative")
+	}
+	if !money.IsCurrencyCode(opts.Currency) {
+		return nil, fmt.Errorf("currency must be an uppercase ISO 4217 currency code, such as USD")
+	}
+
+	randSeed := opts.RandSeed
+	if randSeed == 0 {
+		randSeed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(randSeed))
+
+	for attempt := 1; ; attempt++ {
+		accounts, entries := generateSeedData(rng, opts)
+
+		err := store.WithTx(ctx, func(tx Storage) error {
+			if err := tx.CreateAccounts(ctx, accounts); err != nil {
+				return err
+			}
+
+			txns := make([]*Transaction, 0, len(entries))
+			for _, entry := range entries {
+				entry.txn.AccountID = entry.account.ID
+				if entry.counterparty != nil {
+					entry.txn.CounterpartyID = entry.counterparty.ID
+				}
+				txns = append(txns, entry.txn)
+			}
+
+			return tx.CreateTransactions(ctx, txns)
+		})
+
+		if err == nil {
+			return &SeedStats{Accounts: len(accounts), Transactions: len(entries)}, nil
+		}
+		if !errors.Is(err, ErrAccountNumberTaken) || attempt == createAccountAttempts {
+			return nil, err
+		}
+	}
+}
+
+// generateSeedData draws the accounts and their ledger entries, oldest first.
+//
+// Parameters:
+//   - rng: The source of the random data.
+//   - opts: The amount of data to generate.
+//
+// Returns:
+//   - []*Account: The accounts, carrying their final balances.
+//   - []*seedEntry: The ledger entries of the accounts in chronological order.
+func generateSeedData(rng *rand.Rand, opts SeedOptions) ([]*Account, []*seedEntry) {
+	start := time.Now().UTC().AddDate(0, 0, -opts.Days).Truncate(time.Hour)
+	elapsed := time.Since(start)
+
+	accounts := make([]*Account, opts.Accounts)
+	entries := make([]*seedEntry, 0, opts.Accounts+2*opts.Days*opts.TransfersPerDay)
+
+	numbers := rng.Perm(seedAccountNumbers)
+	for i := range accounts {
+		acc := NewAccount(seedFirstNames[rng.Intn(len(seedFirstNames))], seedLastNames[rng.Intn(len(seedLastNames))], opts.Currency)
+		acc.Number = int64(numbers[i])
+		// Accounts Open During The First Tenth Of The History
+		acc.CreatedAt = start.Add(time.Duration(rng.Int63n(int64(elapsed/10) + 1)))
+		acc.Balance.Amount = seedMinOpeningBalance + rng.Int63n(seedMaxOpeningBalance-seedMinOpeningBalance)
+		accounts[i] = acc
+
+		entries = append(entries, &seedEntry{
+			account: acc,
+			txn:     &Transaction{Type: TransactionTypeDeposit, Amount: acc.Balance, BalanceAfter: acc.Balance, Currency: acc.Currency, CreatedAt: acc.CreatedAt},
+		})
+	}
+
+	// Draw The Transfer Times First, So The Balances Are Applied In Order
+	times := make([]time.Time, opts.Days*opts.TransfersPerDay)
+	for i := range times {
+		times[i] = start.Add(elapsed/10 + time.Duration(rng.Int63n(int64(elapsed*9/10)+1)))
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	for _, at := range times {
+		from := accounts[rng.Intn(len(accounts))]
+		to := accounts[rng.Intn(len(accounts))]
+		if from == to || from.Balance.Amount < 2*seedMinTransfer {
+			continue
+		}
+
+		// Move Up To A Fifth Of The Balance
+		amount := money.New(seedMinTransfer+rng.Int63n(from.Balance.Amount/5+1), opts.Currency)
+		category := seedCategories[rng.Intn(len(seedCategories))]
+
+		from.Balance.Amount -= amount.Amount
+		to.Balance.Amount += amount.Amount
+
+		entries = append(entries,
+			&seedEntry{account: from, counterparty: to, txn: &Transaction{Type: TransactionTypeTransfer, Amount: amount.Neg(), BalanceAfter: from.Balance, Currency: opts.Currency, CreatedAt: at, Category: category}},
+			&seedEntry{account: to, counterparty: from, txn: &Transaction{Type: TransactionTypeTransfer, Amount: amount, BalanceAfter: to.Balance, Currency: opts.Currency, CreatedAt: at, Category: category}},
+		)
+	}
+
+	return accounts, entries
+}
+
+// runSeedCommand runs "gobank seed".
+//
+// Usage:
+//   - gobank seed [-accounts n] [-days n] [-transfers n] [-rand-seed n] [-currency code]
+//
+// Parameters:
+//   - store: The store to fill.
+//   - opts: The defaults of the flags.
+//   - args: The arguments following the command.
+//
+// Returns:
+//   - error: An error if the arguments are invalid or the data cannot be stored.
+func runSeedCommand(store Storage, opts SeedOptions, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	flags.IntVar(&opts.Accounts, "accounts", opts.Accounts, "Number of accounts to create")
+	flags.IntVar(&opts.Days, "days", opts.Days, "Number of days of transaction history")
+	flags.IntVar(&opts.TransfersPerDay, "transfers", opts.TransfersPerDay, "Average number of transfers per day")
+	flags.Int64Var(&opts.RandSeed, "rand-seed", 0, "Seed of the generated data, for reproducible runs")
+	flags.StringVar(&opts.Currency, "currency", opts.Currency, "Currency of the accounts")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return seed(store, opts)
+}
+
+// seed fills the store and logs what was created.
+func seed(store Storage, opts SeedOptions) error {
+	stats, err := seedStore(context.Background(), store, opts)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Seeded The Store", "accounts", stats.Accounts, "transactions", stats.Transactions)
+	return nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/moabdelazem/gobank/internal/settlementpb"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Default Settlement Settings
+const (
+	defaultSettlementTimeout       = 10 * time.Second
+	defaultSettlementRetryAttempts = 3
+	defaultSettlementRetryBackoff  = 200 * time.Millisecond
+	// settlementIdempotencyMetadata carries the idempotency key of a Settle call, next to
+	// the one in the request, for the proxies in front of the service.
+	settlementIdempotencyMetadata = "idempotency-key"
+)
+
+// Settlement Results, Counted By settlementRequests
+const (
+	SettlementResultSettled  = "settled"
+	SettlementResultRejected = "rejected"
+	SettlementResultPending  = "pending"
+	SettlementResultError    = "error"
+)
+
+// errSettlementUnknown is returned by Settle when no attempt got an answer, so the
+// transfer may or may not have been settled.
+var errSettlementUnknown = errors.New("the outcome of the settlement is unknown")
+
+// settlementRequests counts the Settle calls, registered by newMetricsRegistry.
+var settlementRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace, Subsystem: "settlement", Name: "requests_total",
+	Help: "Number of transfers sent to the settlement service, by result.",
+}, []string{"result"})
+
+// settlementRetries counts the Settle attempts retried after a transient failure,
+// registered by newMetricsRegistry.
+var settlementRetries = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metricsNamespace, Subsystem: "settlement", Name: "retries_total",
+	Help: "Number of settlement attempts retried after a transient failure.",
+})
+
+// settlementRetryable lists the status codes of the attempts worth retrying: the
+// service was unreachable, overloaded, or too slow to answer.
+var settlementRetryable = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+}
+
+// SettlementClient settles the external transfers with the settlement service of the
+// interbank network, over gRPC authenticated on both sides with TLS certificates.
+type SettlementClient struct {
+	cfg    SettlementConfig
+	conn   *grpc.ClientConn
+	client settlementpb.SettlementServiceClient
+}
+
+// NewSettlementClient creates the client of the settlement service at cfg.Endpoint.
+// The connection is opened on the first call and reopened whenever it breaks.
+//
+// Parameters:
+//   - cfg: The settlement settings, with the endpoint, the certificates, and the retries.
+//
+// Returns:
+//   - *SettlementClient: The client, to Close on shutdown.
+//   - error: An error if the certificates cannot be loaded.
+func NewSettlementClient(cfg SettlementConfig) (*SettlementClient, error) {
+	creds, err := settlementCredentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.NewClient(cfg.Endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &SettlementClient{cfg: cfg, conn: conn, client: settlementpb.NewSettlementServiceClient(conn)}, nil
+}
+
+// settlementCredentials returns the transport credentials of the settlement service:
+// the client certificate of gobank, verified by the service, and the CA the certificate
+// of the service is verified against, the system roots by default.
+func settlementCredentials(cfg SettlementConfig) (credentials.TransportCredentials, error) {
+	if cfg.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: cfg.ServerName}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading the client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the CA: %w", err)
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.CAFile)
+		}
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+// settlementIdempotencyKey returns the idempotency key of the settlement of a transfer,
+// the same on every attempt and after a restart, so the service settles it once.
+func settlementIdempotencyKey(transferID int) string {
+	return "gobank-transfer-" + strconv.Itoa(transferID)
+}
+
+// Settle sends a transfer to the settlement service. The attempts failing transiently
+// are retried with an exponential backoff, all under the idempotency key of the
+// transfer, so that an attempt that was applied but not answered is not applied again.
+//
+// Parameters:
+//   - ctx: The context of the calls; every attempt is also bounded by the timeout.
+//   - req: The transfer to settle, with its idempotency key.
+//
+// Returns:
+//   - *settlementpb.SettleResponse: The outcome: settled, rejected, or pending.
+//   - error: errSettlementUnknown, wrapped with the last failure, when every attempt
+//     failed transiently, or the failure the service answered with.
+func (c *SettlementClient) Settle(ctx context.Context, req *settlementpb.SettleRequest) (*settlementpb.SettleResponse, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, settlementIdempotencyMetadata, req.GetIdempotencyKey())
+
+	var err error
+	for attempt := 1; attempt <= c.cfg.RetryAttempts; attempt++ {
+		if attempt > 1 {
+			settlementRetries.Inc()
+			// Full Jitter, So The Retries Of The Concurrent Workers Spread Out
+			delay := time.Duration(rand.Int63n(int64(c.cfg.RetryBackoff<<(attempt-2)) + 1))
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("%w: %w", errSettlementUnknown, err)
+			case <-time.After(delay):
+			}
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+		var resp *settlementpb.SettleResponse
+		resp, err = c.client.Settle(attemptCtx, req)
+		cancel()
+
+		if err == nil {
+			return resp, nil
+		}
+		if !settlementRetryable[status.Code(err)] {
+			return nil, err
+		}
+		slog.WarnContext(ctx, "Settlement Attempt Failed", "attempt", attempt, "error", err)
+	}
+	return nil, fmt.Errorf("%w: %w", errSettlementUnknown, err)
+}
+
+// Close closes the connection to the settlement service.
+func (c *SettlementClient) Close() error {
+	return c.conn.Close()
+}
+
+// settleTransfer settles an external transfer, marked processing by the worker, with
+// the settlement service: a settled transfer is completed, moving its funds in the
+// ledger, and a rejected one is failed with the reason. A pending one stays processing
+// until the payment gateway calls back with the outcome under its provider reference,
+// see handleGatewayCallback, and so does one whose outcome is unknown, which raises a
+// reconciliation_mismatch alert for an operator to check it with the service.
+//
+// Parameters:
+//   - ctx: The context of the work, carrying the logging attributes of the transfer.
+//   - transfer: The processing transfer; its status and history are updated in place.
+func (as *APIServer) settleTransfer(ctx context.Context, transfer *Transfer) {
+	if as.settlement == nil {
+		slog.WarnContext(ctx, "No Settlement Service, Transfer Left To The Gateway Callbacks")
+		return
+	}
+
+	req, err := as.newSettleRequest(ctx, transfer)
+	if err == nil {
+		var resp *settlementpb.SettleResponse
+		if resp, err = as.settlement.Settle(ctx, req); err == nil {
+			as.applySettlement(ctx, transfer, resp)
+			return
+		}
+	}
+
+	settlementRequests.WithLabelValues(SettlementResultError).Inc()
+	if errors.Is(err, errSettlementUnknown) {
+		slog.ErrorContext(ctx, "Settlement Outcome Unknown, Transfer Left Processing", "error", err)
+		as.alertReconciliationMismatch("Settlement Service Did Not Answer, The Transfer May Be Settled",
+			"transfer_id", strconv.Itoa(transfer.ID), "provider_reference", transfer.ProviderReference, "error", err.Error())
+		return
+	}
+
+	// The Service Refused The Request, Or The Accounts Are Gone, Nothing Was Settled
+	slog.WarnContext(ctx, "Transfer Could Not Be Settled", "error", err)
+	if err := as.store.UpdateTransferStatus(ctx, transfer, TransferStatusFailed, fmt.Sprintf("the transfer could not be settled: %s", err)); err != nil {
+		slog.ErrorContext(ctx, "Error Failing Transfer", "error", err)
+		return
+	}
+	as.publishTransferStatus(transfer)
+}
+
+// newSettleRequest returns the settlement request of a transfer, between the numbers
+// of its accounts.
+func (as *APIServer) newSettleRequest(ctx context.Context, transfer *Transfer) (*settlementpb.SettleRequest, error) {
+	from, err := as.store.GetAccountById(ctx, transfer.FromAccountID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := as.store.GetAccountById(ctx, transfer.ToAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &settlementpb.SettleRequest{
+		IdempotencyKey:  settlementIdempotencyKey(transfer.ID),
+		TransferId:      int64(transfer.ID),
+		DebtorAccount:   from.Number,
+		CreditorAccount: to.Number,
+		Amount:          transfer.Amount.Amount,
+		Reference:       transfer.ProviderReference,
+	}, nil
+}
+
+// applySettlement applies the outcome of the settlement service to a transfer.
+//
+// Parameters:
+//   - ctx: The context of the database work.
+//   - transfer: The processing transfer; its status and history are updated in place.
+//   - resp: The answer of the service.
+func (as *APIServer) applySettlement(ctx context.Context, transfer *Transfer, resp *settlementpb.SettleResponse) {
+	ctx = withLogAttrs(ctx, slog.String("settlement_id", resp.GetSettlementId()))
+
+	switch resp.GetStatus() {
+	case settlementpb.SettlementStatus_SETTLEMENT_STATUS_SETTLED:
+		settlementRequests.WithLabelValues(SettlementResultSettled).Inc()
+		if err := as.executeTransfer(ctx, transfer); err != nil {
+			slog.ErrorContext(ctx, "Settled Transfer Could Not Be Completed", "error", err)
+			as.alertReconciliationMismatch("Settlement Service Settled A Transfer The Ledger Could Not Complete",
+				"transfer_id", strconv.Itoa(transfer.ID), "settlement_id", resp.GetSettlementId(), "error", err.Error())
+		}
+
+	case settlementpb.SettlementStatus_SETTLEMENT_STATUS_REJECTED:
+		settlementRequests.WithLabelValues(SettlementResultRejected).Inc()
+		reason := resp.GetReason()
+		if reason == "" {
+			reason = "the settlement service rejected the transfer"
+		}
+		if err := as.store.UpdateTransferStatus(ctx, transfer, TransferStatusFailed, reason); err != nil {
+			slog.ErrorContext(ctx, "Error Failing Transfer", "error", err)
+			return
+		}
+		as.publishTransferStatus(transfer)
+
+	case settlementpb.SettlementStatus_SETTLEMENT_STATUS_PENDING:
+		settlementRequests.WithLabelValues(SettlementResultPending).Inc()
+		slog.InfoContext(ctx, "Settlement Pending, Transfer Left To The Gateway Callbacks")
+
+	default:
+		settlementRequests.WithLabelValues(SettlementResultError).Inc()
+		slog.ErrorContext(ctx, "Settlement Service Answered An Unknown Status, Transfer Left Processing", "status", resp.GetStatus().String())
+		as.alertReconciliationMismatch("Settlement Service Answered An Unknown Status",
+			"transfer_id", strconv.Itoa(transfer.ID), "settlement_id", resp.GetSettlementId(), "status", resp.GetStatus().String())
+	}
+}
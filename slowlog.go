@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultSlowRequestThreshold is the duration from which a request is logged as slow.
+const defaultSlowRequestThreshold = time.Second
+
+// defaultSlowQueryThreshold is the duration from which a SQL statement is logged as slow.
+const defaultSlowQueryThreshold = 100 * time.Millisecond
+
+// slowRequests counts the requests slower than the slow_request_threshold setting,
+// registered by newMetricsRegistry.
+var slowRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace, Subsystem: "http", Name: "slow_requests_total",
+	Help: "Number of HTTP requests slower than the slow request threshold, by method and route.",
+}, []string{"method", "route"})
+
+// hashAccountID returns a stable pseudonym of an account ID, so slow requests of the
+// same account can be grouped without the logs revealing which account it is. It is
+// keyed with the JWT secret so the IDs cannot be recovered by hashing them all.
+func hashAccountID(accountID int) string {
+	mac := hmac.New(sha256.New, []byte(JWTSecret))
+	mac.Write([]byte(strconv.Itoa(accountID)))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// withSlowRequestLogging logs and counts the requests taking threshold or longer, with
+// their route, status, duration, and the hashed ID of the authenticated account.
+//
+// Parameters:
+//   - threshold: The duration from which a request is slow.
+//
+// Returns:
+//   - func(http.Handler) http.Handler: The middleware, for the router.
+func withSlowRequestLogging(threshold time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			aw := &accessLogWriter{ResponseWriter: w}
+
+			start := time.Now()
+			next.ServeHTTP(aw, r)
+			elapsed := time.Since(start)
+
+			if elapsed < threshold {
+				return
+			}
+
+			status := aw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			// The Method And The Route Come With The Context
+			attrs := []any{"status", status, "duration", elapsed}
+			if actor := requestActorFromContext(r.Context()); actor != nil && actor.accountID != 0 {
+				attrs = append(attrs, "account_hash", hashAccountID(actor.accountID))
+			}
+			slowRequests.WithLabelValues(r.Method, routeTemplate(r)).Inc()
+			slog.WarnContext(r.Context(), "Slow Request", attrs...)
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default SMS Settings
+const (
+	defaultSMSRateLimit  = 5
+	defaultSMSRateWindow = time.Hour
+	defaultTwilioAPIURL  = "https://api.twilio.com"
+	defaultTwilioTimeout = 10 * time.Second
+)
+
+// smsCountryUnlisted is the key of SMSConfig.Countries switching the unlisted countries.
+const smsCountryUnlisted = "*"
+
+// smsCallingCodeMaxDigits is the longest calling code of SMSConfig.Countries, the four
+// digits of the North American areas with their own rules, such as 1876 for Jamaica.
+const smsCallingCodeMaxDigits = 4
+
+// One-Time Codes Verifying The Phone Numbers
+const (
+	otpDigits      = 6
+	otpTTL         = 5 * time.Minute
+	otpMaxAttempts = 5
+)
+
+// errSMSCountryDisabled is returned when texting a number of a disabled country.
+var errSMSCountryDisabled = errors.New("SMS are disabled for the country of the phone number")
+
+// SMSRateLimitError is returned when a phone number received its SMS quota for the window.
+type SMSRateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *SMSRateLimitError) Error() string {
+	return fmt.Sprintf("too many SMS sent to the phone number, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// smsGate guards the SMS provider: it refuses the numbers of the disabled countries
+// and the numbers that received RateLimit SMS in the last RateWindow.
+type smsGate struct {
+	next      NotificationSender
+	countries map[string]bool
+	limiter   *smsRateLimiter
+}
+
+// newSMSSender creates the sender of the SMS channel: Twilio, behind the country
+// switches and the rate limit.
+func newSMSSender(cfg SMSConfig) NotificationSender {
+	return &smsGate{
+		next:      NewTwilioSender(cfg.Twilio),
+		countries: cfg.Countries,
+		limiter:   newSMSRateLimiter(cfg.RateLimit, cfg.RateWindow),
+	}
+}
+
+// Send texts a notification unless its country is disabled or its number is rate limited.
+func (g *smsGate) Send(ctx context.Context, notification *Notification) error {
+	if !smsCountryEnabled(g.countries, notification.To) {
+		return errSMSCountryDisabled
+	}
+	if wait := g.limiter.take(notification.To, time.Now()); wait > 0 {
+		return &SMSRateLimitError{RetryAfter: wait}
+	}
+	return g.next.Send(ctx, notification)
+}
+
+// smsCountryEnabled tells whether a phone number in E.164 format may be texted. The
+// longest calling code of countries the number starts with decides, then the "*"
+// entry; every country is enabled by default.
+func smsCountryEnabled(countries map[string]bool, phone string) bool {
+	digits := strings.TrimPrefix(phone, "+")
+	for size := min(smsCallingCodeMaxDigits, len(digits)); size > 0; size-- {
+		if enabled, ok := countries[digits[:size]]; ok {
+			return enabled
+		}
+	}
+	if enabled, ok := countries[smsCountryUnlisted]; ok {
+		return enabled
+	}
+	return true
+}
+
+// smsRateLimiter counts the SMS sent to every phone number over a sliding window.
+// A number is forgotten once its window is empty, when it is next texted.
+type smsRateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	sent   map[string][]time.Time
+}
+
+// newSMSRateLimiter creates a limiter allowing limit SMS per number and window.
+func newSMSRateLimiter(limit int, window time.Duration) *smsRateLimiter {
+	return &smsRateLimiter{limit: limit, window: window, sent: make(map[string][]time.Time)}
+}
+
+// take records an SMS to a number and returns 0, or returns how long to wait when the
+// number is over its limit, recording nothing.
+func (l *smsRateLimiter) take(phone string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Drop The SMS Older Than The Window
+	sent := l.sent[phone]
+	for len(sent) > 0 && now.Sub(sent[0]) >= l.window {
+		sent = sent[1:]
+	}
+
+	if len(sent) >= l.limit {
+		l.sent[phone] = sent
+		return sent[0].Add(l.window).Sub(now)
+	}
+	l.sent[phone] = append(sent, now)
+	return 0
+}
+
+// TwilioSender texts the notifications through the Messages API of Twilio.
+type TwilioSender struct {
+	cfg    TwilioConfig
+	client *http.Client
+}
+
+// NewTwilioSender creates a sender for the configured Twilio account.
+func NewTwilioSender(cfg TwilioConfig) *TwilioSender {
+	return &TwilioSender{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// Send creates the message of a notification with Twilio, which delivers it later.
+//
+// Parameters:
+//   - ctx: The context of the request to Twilio.
+//   - notification: The notification, with its phone number and body.
+//
+// Returns:
+//   - error: An error if Twilio cannot be reached or refuses the message.
+func (s *TwilioSender) Send(ctx context.Context, notification *Notification) error {
+	form := url.Values{"To": {notification.To}, "Body": {notification.Body}}
+	if strings.HasPrefix(s.cfg.From, "MG") {
+		form.Set("MessagingServiceSid", s.cfg.From)
+	} else {
+		form.Set("From", s.cfg.From)
+	}
+
+	endpoint := s.cfg.APIURL + "/2010-04-01/Accounts/" + url.PathEscape(s.cfg.AccountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.cfg.AccountSID, s.cfg.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		var apiErr struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+		return fmt.Errorf("twilio refused the message with status %d: %s (code %d)", resp.StatusCode, apiErr.Message, apiErr.Code)
+	}
+	return nil
+}
+
+// otpCode is a code sent to verify a phone number, stored hashed.
+type otpCode struct {
+	phone     string
+	hash      [sha256.Size]byte
+	expiresAt time.Time
+	attempts  int
+}
+
+// otpStore keeps the pending one-time codes in memory, one per account: a new code
+// replaces the previous one.
+type otpStore struct {
+	mu    sync.Mutex
+	codes map[int]*otpCode
+}
+
+// newOTPStore creates an empty otpStore.
+func newOTPStore() *otpStore {
+	return &otpStore{codes: make(map[int]*otpCode)}
+}
+
+// issue draws the code of an account for a phone number and returns it with its expiry.
+func (s *otpStore) issue(accountID int, phone string) (string, time.Time, error) {
+	n, err := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(10), big.NewInt(otpDigits), nil))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	code := fmt.Sprintf("%0*d", otpDigits, n.Int64())
+	expiresAt := time.Now().Add(otpTTL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Forget The Expired Codes Of The Other Accounts
+	for id, pending := range s.codes {
+		if time.Now().After(pending.expiresAt) {
+			delete(s.codes, id)
+		}
+	}
+	s.codes[accountID] = &otpCode{phone: phone, hash: sha256.Sum256([]byte(code)), expiresAt: expiresAt}
+	return code, expiresAt, nil
+}
+
+// revoke forgets the code of an account.
+func (s *otpStore) revoke(accountID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.codes, accountID)
+}
+
+// verify tells whether code is the unexpired code of an account for a phone number,
+// consuming it on success. A code is forgotten after otpMaxAttempts wrong guesses.
+func (s *otpStore) verify(accountID int, phone, code string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, ok := s.codes[accountID]
+	if !ok {
+		return false
+	}
+	if pending.phone != phone || time.Now().After(pending.expiresAt) {
+		delete(s.codes, accountID)
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(code))
+	if subtle.ConstantTimeCompare(hash[:], pending.hash[:]) != 1 {
+		if pending.attempts++; pending.attempts >= otpMaxAttempts {
+			delete(s.codes, accountID)
+		}
+		return false
+	}
+
+	delete(s.codes, accountID)
+	return true
+}
+
+// otpNotification is the data of the otp template.
+type otpNotification struct {
+	Code    string
+	Minutes int
+}
+
+// PhoneOTPResponse is the response of POST /api/v1/account/{id}/notifications/phone/otp.
+type PhoneOTPResponse struct {
+	Phone     string    `json:"phone"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PhoneVerifyRequest is the body of POST /api/v1/account/{id}/notifications/phone/verify.
+type PhoneVerifyRequest struct {
+	Code string `json:"code"`
+}
+
+// Validate checks the fields of a phone verification request.
+func (req *PhoneVerifyRequest) Validate() []FieldError {
+	var errs []FieldError
+	if req.Code == "" {
+		errs = append(errs, FieldError{Field: "code", Code: CodeRequired, Message: "code is required"})
+	} else if len(req.Code) != otpDigits || strings.Trim(req.Code, "0123456789") != "" {
+		errs = append(errs, FieldError{Field: "code", Code: CodeInvalid, Message: fmt.Sprintf("code must be %d digits", otpDigits)})
+	}
+	return errs
+}
+
+// smsSender returns the sender of the SMS channel, nil when SMS are not configured.
+func (as *APIServer) smsSender() NotificationSender {
+	if as.notifier == nil {
+		return nil
+	}
+	return as.notifier.senders[notificationChannelSMS]
+}
+
+// phoneToVerify returns the notification preferences of an account, refusing the
+// request when SMS are not configured or the preferences have no phone number.
+func (as *APIServer) phoneToVerify(r *http.Request, accountID int) (*NotificationPreferences, error) {
+	if as.smsSender() == nil {
+		return nil, NewTypedError(http.StatusServiceUnavailable, "sms_unavailable", "SMS are not configured")
+	}
+
+	prefs, err := as.store.GetNotificationPreferences(r.Context(), accountID)
+	if err != nil {
+		return nil, err
+	}
+	if prefs == nil || prefs.Phone == "" {
+		return nil, NewTypedError(http.StatusConflict, "phone_missing", "no phone number is set in the notification preferences")
+	}
+	return prefs, nil
+}
+
+// handleSendPhoneOTP texts a one-time code to the phone number of the notification
+// preferences, replacing the previous code.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the account ID.
+//
+// Returns:
+//   - error: A 503 without SMS, a 409 without a phone number, a 422 for a disabled
+//     country, a 429 with a Retry-After header when rate limited, or the error of the provider.
+func (as *APIServer) handleSendPhoneOTP(w http.ResponseWriter, r *http.Request) error {
+	id := getId(w, r)
+	prefs, err := as.phoneToVerify(r, id)
+	if err != nil {
+		return err
+	}
+
+	code, expiresAt, err := as.notifier.otps.issue(id, prefs.Phone)
+	if err != nil {
+		return err
+	}
+	notification, err := renderNotification(notificationChannelSMS, NotificationOTP, prefs.Phone, otpNotification{Code: code, Minutes: int(otpTTL / time.Minute)})
+	if err != nil {
+		return err
+	}
+	notification.AccountID = id
+
+	if err := as.notifier.deliver(r.Context(), notification); err != nil {
+		as.notifier.otps.revoke(id)
+
+		var limited *SMSRateLimitError
+		switch {
+		case errors.As(err, &limited):
+			w.Header().Set("Retry-After", strconv.Itoa(int(limited.RetryAfter.Round(time.Second)/time.Second)))
+			return NewTypedError(http.StatusTooManyRequests, "sms_rate_limited", "too many SMS sent to the phone number, retry later")
+		case errors.Is(err, errSMSCountryDisabled):
+			return NewTypedError(http.StatusUnprocessableEntity, "sms_country_disabled", "SMS cannot be sent to the country of the phone number")
+		}
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusAccepted, PhoneOTPResponse{Phone: prefs.Phone, ExpiresAt: expiresAt.UTC()})
+}
+
+// handleVerifyPhoneOTP verifies the phone number of the notification preferences with
+// the code texted to it, enabling the SMS alerts.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the account ID and the code.
+//
+// Returns:
+//   - error: A 422 if the code is wrong or expired, or the error of the store.
+func (as *APIServer) handleVerifyPhoneOTP(w http.ResponseWriter, r *http.Request) error {
+	verifyReq := new(PhoneVerifyRequest)
+	if err := bindJSON(w, r, verifyReq); err != nil {
+		return err
+	}
+
+	id := getId(w, r)
+	prefs, err := as.phoneToVerify(r, id)
+	if err != nil {
+		return err
+	}
+	if !as.notifier.otps.verify(id, prefs.Phone, verifyReq.Code) {
+		return NewTypedError(http.StatusUnprocessableEntity, "otp_invalid", "the code is wrong or expired")
+	}
+
+	prefs.PhoneVerified = true
+	if err := as.store.SetNotificationPreferences(r.Context(), prefs); err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusOK, prefs)
+}
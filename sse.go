@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sseHeartbeatInterval is how often a comment line is sent to keep idle
+// connections (and the proxies in front of them) open.
+const sseHeartbeatInterval = 15 * time.Second
+
+// streamWriteWait bounds the writes of the responses held open past the write timeout
+// of the server, the event streams and the long polls; a client that stops reading is
+// dropped after it.
+const streamWriteWait = 10 * time.Second
+
+// handleAccountEvents streams the activity of an account (balance changes and
+// new transactions) to the client as Server-Sent Events until the client disconnects.
+//
+// Parameters:
+//   - w: http.ResponseWriter to stream the events to.
+//   - r: *http.Request containing the account ID in the URL.
+//
+// Returns:
+//   - error: An error if the response writer does not support streaming, otherwise nil.
+func (as *APIServer) handleAccountEvents(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming is not supported")
+	}
+
+	// Get The ID From The URL
+	id := getId(w, r)
+
+	// Subscribe To The Account Events
+	events := as.events.Subscribe(id)
+	defer as.events.Unsubscribe(id, events)
+
+	// Give Every Write Its Own Deadline Instead Of The Server Write Timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(streamWriteWait))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			rc.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := writeSSEEvent(w, event); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSEEvent writes a single account event in the Server-Sent Events wire format.
+func writeSSEEvent(w http.ResponseWriter, event AccountEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
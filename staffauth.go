@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Staff Roles, Mapped From The Groups Of The Directory
+const (
+	// RoleAdmin reaches every /admin endpoint.
+	RoleAdmin = "admin"
+	// RoleTeller reaches the /admin endpoints serving the account holders, such as
+	// listing and freezing accounts.
+	RoleTeller = "teller"
+)
+
+// staffRoles lists the staff roles, for the validation of the group mappings.
+var staffRoles = []string{RoleAdmin, RoleTeller}
+
+// Staff Auth Providers
+const (
+	staffAuthProviderLDAP = "ldap"
+)
+
+// staffAuthProviders lists the valid values of auth.staff.provider; none when empty.
+var staffAuthProviders = []string{staffAuthProviderLDAP}
+
+// Default Staff Auth Settings
+const (
+	defaultStaffTokenExpiry = 8 * time.Hour
+	// staffTokenAudience marks the staff tokens, so that no other token of JWT_SECRET,
+	// such as an account or consent token, passes for one.
+	staffTokenAudience = "gobank-staff"
+	// AuditActionStaffLogin records a staff member logging in through the provider.
+	AuditActionStaffLogin = "staff.login"
+)
+
+// ErrInvalidCredentials is returned by an AuthProvider for an unknown username or a
+// wrong password, without telling which.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// errAdminDisabled is returned by authenticateStaff when neither the admin token nor a
+// staff auth provider is configured.
+var errAdminDisabled = errors.New("admin endpoints are disabled")
+
+// staffLogins counts the staff logins, registered by newMetricsRegistry.
+var staffLogins = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace, Subsystem: "staff", Name: "logins_total",
+	Help: "Number of staff logins, by provider and result.",
+}, []string{"provider", "result"})
+
+// AuthProvider authenticates the staff, the tellers and the admins, against a directory
+// of the bank, the plug-in point of auth.staff.provider. The account holders keep
+// authenticating locally, with their account tokens.
+type AuthProvider interface {
+	// Name names the provider in the logs and the metrics, such as ldap.
+	Name() string
+	// Authenticate checks the password of a staff member and returns their identity, with
+	// the roles of their groups; ErrInvalidCredentials when the username or password is wrong.
+	Authenticate(ctx context.Context, username, password string) (*StaffIdentity, error)
+}
+
+// newAuthProvider creates the staff auth provider of cfg.
+//
+// Parameters:
+//   - cfg: The staff auth settings, with the provider and its settings.
+//
+// Returns:
+//   - AuthProvider: The provider, nil when none is configured.
+//   - error: An error if the provider cannot be created.
+func newAuthProvider(cfg StaffAuthConfig) (AuthProvider, error) {
+	switch cfg.Provider {
+	case staffAuthProviderLDAP:
+		return newLDAPAuthProvider(cfg.LDAP), nil
+	}
+	return nil, nil
+}
+
+// StaffIdentity is an authenticated staff member.
+type StaffIdentity struct {
+	Username string   `json:"username"`
+	Roles    []string `json:"roles"`
+}
+
+// HasRole reports whether the staff member has role.
+func (s *StaffIdentity) HasRole(role string) bool {
+	return slices.Contains(s.Roles, role)
+}
+
+type staffIdentityKey struct{}
+
+// staffFromContext returns the staff member of an authenticated admin request, nil for none.
+func staffFromContext(ctx context.Context) *StaffIdentity {
+	staff, _ := ctx.Value(staffIdentityKey{}).(*StaffIdentity)
+	return staff
+}
+
+// StaffLoginRequest is the body of POST /admin/login.
+type StaffLoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Validate checks the fields of a staff login request.
+func (req *StaffLoginRequest) Validate() []FieldError {
+	var errs []FieldError
+	switch {
+	case strings.TrimSpace(req.Username) == "":
+		errs = append(errs, FieldError{Field: "username", Code: CodeRequired, Message: "username is required"})
+	case len(req.Username) > 256:
+		errs = append(errs, FieldError{Field: "username", Code: CodeTooLong, Message: "username must be at most 256 characters"})
+	}
+	if req.Password == "" {
+		errs = append(errs, FieldError{Field: "password", Code: CodeRequired, Message: "password is required"})
+	}
+	return errs
+}
+
+// StaffLoginResponse is the body of a successful staff login.
+type StaffLoginResponse struct {
+	// Token authenticates the admin requests, in the Authorization header as Bearer <token>.
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	StaffIdentity
+}
+
+// createStaffToken signs the token of a staff member, which carries their roles until it
+// expires; a change of their groups applies from their next login.
+func createStaffToken(staff *StaffIdentity, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"staff": staff.Username,
+		"roles": staff.Roles,
+		"aud":   staffTokenAudience,
+		"exp":   expiresAt.Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(JWTSecret))
+}
+
+// parseStaffToken returns the staff member of a staff token.
+//
+// Parameters:
+//   - tokenString: The token, without the Bearer prefix.
+//
+// Returns:
+//   - *StaffIdentity: The staff member, with the roles of the token.
+//   - error: An error if the token is invalid, expired, or not a staff token.
+func parseStaffToken(tokenString string) (*StaffIdentity, error) {
+	token, err := validateJWTToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	audience, err := claims.GetAudience()
+	if err != nil || !slices.Contains(audience, staffTokenAudience) {
+		return nil, fmt.Errorf("not a staff token")
+	}
+	// The Expiry Was Checked By The Parser, But Only When The Claim Is Set
+	if expiresAt, err := claims.GetExpirationTime(); err != nil || expiresAt == nil {
+		return nil, fmt.Errorf("the staff token does not expire")
+	}
+
+	username, _ := claims["staff"].(string)
+	if username == "" {
+		return nil, fmt.Errorf("the staff token names no staff member")
+	}
+	staff := &StaffIdentity{Username: username}
+	rawRoles, _ := claims["roles"].([]interface{})
+	for _, raw := range rawRoles {
+		if role, ok := raw.(string); ok {
+			staff.Roles = append(staff.Roles, role)
+		}
+	}
+	return staff, nil
+}
+
+// authenticateStaff returns the staff member of an admin request: the holder of the
+// static admin token (ADMIN_TOKEN) in X-Admin-Token is an admin named by X-Admin-Actor,
+// and the bearer of a staff token has the roles it carries.
+//
+// Returns:
+//   - *StaffIdentity: The staff member.
+//   - error: errAdminDisabled when neither way is configured, another error for a missing
+//     or invalid credential.
+func (as *APIServer) authenticateStaff(r *http.Request) (*StaffIdentity, error) {
+	adminToken := as.config.Auth.AdminToken
+	if adminToken == "" && as.staffAuth == nil {
+		return nil, errAdminDisabled
+	}
+
+	if token := r.Header.Get("X-Admin-Token"); token != "" || as.staffAuth == nil {
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			return nil, fmt.Errorf("invalid admin token")
+		}
+		return &StaffIdentity{Username: adminActor(r), Roles: []string{RoleAdmin}}, nil
+	}
+
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, fmt.Errorf("missing staff token")
+	}
+	return parseStaffToken(tokenString)
+}
+
+// handleStaffLogin authenticates a staff member with the configured provider and returns
+// the staff token of their roles. The login is recorded in the audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the username and password.
+//
+// Returns:
+//   - error: A 401 for a wrong username or password, a 403 for a staff member without a
+//     role, or a 503 if the directory cannot be reached.
+func (as *APIServer) handleStaffLogin(w http.ResponseWriter, r *http.Request) error {
+	req := StaffLoginRequest{}
+	if err := bindJSON(w, r, &req); err != nil {
+		return err
+	}
+
+	provider := as.staffAuth.Name()
+	staff, err := as.staffAuth.Authenticate(r.Context(), req.Username, req.Password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		staffLogins.WithLabelValues(provider, "invalid_credentials").Inc()
+		slog.WarnContext(r.Context(), "Rejected Staff Login", "provider", provider, "username", req.Username)
+		return NewTypedError(http.StatusUnauthorized, "invalid_credentials", "invalid username or password")
+	}
+	if err != nil {
+		staffLogins.WithLabelValues(provider, "error").Inc()
+		slog.ErrorContext(r.Context(), "Staff Login Failed", "provider", provider, "username", req.Username, "error", err)
+		return NewTypedError(http.StatusServiceUnavailable, "staff_auth_unavailable", "the staff directory is unavailable, retry later")
+	}
+	if len(staff.Roles) == 0 {
+		staffLogins.WithLabelValues(provider, "no_role").Inc()
+		slog.WarnContext(r.Context(), "Staff Login Without A Role", "provider", provider, "username", staff.Username)
+		return NewTypedError(http.StatusForbidden, "staff_role_missing", "none of your groups grants a staff role")
+	}
+
+	expiresAt := time.Now().UTC().Add(as.config.Auth.Staff.TokenExpiry).Truncate(time.Second)
+	token, err := createStaffToken(staff, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	staffLogins.WithLabelValues(provider, "success").Inc()
+	r = r.WithContext(context.WithValue(r.Context(), staffIdentityKey{}, staff))
+	as.audit(r, AuditActionStaffLogin, "staff:"+staff.Username, map[string]interface{}{"provider": provider, "roles": staff.Roles})
+
+	return WriteResponse(w, r, http.StatusOK, StaffLoginResponse{Token: token, ExpiresAt: expiresAt, StaffIdentity: *staff})
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/gorilla/mux"
+	"github.com/moabdelazem/gobank/money"
+)
+
+// statementPeriodLayout is the layout of the {period} URL variable (year and month).
+const statementPeriodLayout = "2006-01"
+
+// Statement summarizes the activity of an account over one calendar month.
+type Statement struct {
+	AccountID      int            `json:"account_id"`
+	AccountNumber  int64          `json:"account_number"`
+	AccountHolder  string         `json:"account_holder"`
+	Period         string         `json:"period"`
+	PeriodStart    time.Time      `json:"period_start"`
+	PeriodEnd      time.Time      `json:"period_end"`
+	Currency       string         `json:"currency"`
+	OpeningBalance money.Money    `json:"opening_balance"`
+	ClosingBalance money.Money    `json:"closing_balance"`
+	TotalCredits   money.Money    `json:"total_credits"`
+	TotalDebits    money.Money    `json:"total_debits"`
+	Transactions   []*Transaction `json:"transactions"`
+	GeneratedAt    time.Time      `json:"generated_at"`
+	// PDF links to the PDF of the statement in the document storage, when configured.
+	PDF *DocumentLink `json:"pdf,omitempty"`
+}
+
+// buildStatement generates the statement of an account for the given month.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - id: The ID of the account.
+//   - period: The month in YYYY-MM format.
+//
+// Returns:
+//   - *Statement: The generated statement.
+//   - error: An error if the period is invalid, lies in the future, or the data cannot be read.
+func (as *APIServer) buildStatement(ctx context.Context, id int, period string) (*Statement, error) {
+	start, err := time.Parse(statementPeriodLayout, period)
+	if err != nil {
+		return nil, fmt.Errorf("invalid period: %s", period)
+	}
+	end := start.AddDate(0, 1, 0)
+
+	if start.After(time.Now().UTC()) {
+		return nil, fmt.Errorf("period %s has not started yet", period)
+	}
+
+	acc, err := as.store.GetAccountById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	opening, err := as.store.GetBalanceAt(ctx, id, start)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &Statement{
+		AccountID:      acc.ID,
+		AccountNumber:  acc.Number,
+		AccountHolder:  acc.FirstName + " " + acc.LastName,
+		Period:         period,
+		PeriodStart:    start,
+		PeriodEnd:      end,
+		Currency:       acc.Currency,
+		OpeningBalance: opening,
+		ClosingBalance: opening,
+		TotalCredits:   money.Zero(acc.Currency),
+		TotalDebits:    money.Zero(acc.Currency),
+		Transactions:   []*Transaction{},
+		GeneratedAt:    time.Now().UTC(),
+	}
+
+	err = as.store.StreamTransactions(ctx, id, start, end, func(t *Transaction) error {
+		stmt.Transactions = append(stmt.Transactions, t)
+		stmt.ClosingBalance = t.BalanceAfter
+
+		var err error
+		if !t.Amount.IsNegative() {
+			stmt.TotalCredits, err = stmt.TotalCredits.Add(t.Amount)
+		} else {
+			stmt.TotalDebits, err = stmt.TotalDebits.Sub(t.Amount)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+// isClosed reports whether the statement period is over, meaning its content can no longer change.
+func (stmt *Statement) isClosed() bool {
+	return !time.Now().UTC().Before(stmt.PeriodEnd)
+}
+
+// lastModified returns the time the statement content last changed: the end of the
+// period once it is closed, otherwise the time of its latest transaction.
+func (stmt *Statement) lastModified() time.Time {
+	if stmt.isClosed() {
+		return stmt.PeriodEnd
+	}
+	if n := len(stmt.Transactions); n > 0 {
+		return stmt.Transactions[n-1].CreatedAt
+	}
+	return stmt.PeriodStart
+}
+
+// statementETag derives the entity tag of a statement from its content, ignoring the
+// generation time. The representation (e.g. "pdf") is part of the tag, since the
+// PDF and the structured statement are different byte sequences.
+func statementETag(stmt *Statement, representation string) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d|%s|%s|%d|%d|%d", stmt.AccountID, stmt.Period, stmt.AccountHolder, stmt.OpeningBalance.Amount, stmt.ClosingBalance.Amount, len(stmt.Transactions))
+	if n := len(stmt.Transactions); n > 0 {
+		fmt.Fprintf(h, "|%d", stmt.Transactions[n-1].ID)
+	}
+	return fmt.Sprintf("\"%x-%s\"", h.Sum64(), representation)
+}
+
+// setStatementCacheHeaders lets clients and proxies cache statements of closed periods,
+// while statements of the running month are always revalidated.
+func setStatementCacheHeaders(w http.ResponseWriter, stmt *Statement) {
+	if stmt.isClosed() {
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+}
+
+// handleGetStatement handles the HTTP request to retrieve the monthly statement of an account.
+// Conditional requests are answered with 304 Not Modified while the statement is unchanged.
+// With a document storage, the statement links to its PDF there; since the link expires,
+// the response is then never reused.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the account ID and the period.
+//
+// Returns:
+//   - error: An error if the statement cannot be generated, otherwise nil.
+func (as *APIServer) handleGetStatement(w http.ResponseWriter, r *http.Request) error {
+	// Get The ID From The URL
+	id := getId(w, r)
+
+	stmt, err := as.buildStatement(r.Context(), id, mux.Vars(r)["period"])
+	if err != nil {
+		return err
+	}
+
+	if as.documents != nil {
+		if stmt.PDF, err = as.storeStatementPDF(r.Context(), stmt); err != nil {
+			return err
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+		return WriteResponse(w, r, http.StatusOK, stmt)
+	}
+
+	setStatementCacheHeaders(w, stmt)
+	if checkNotModified(w, r, statementETag(stmt, "data"), stmt.lastModified()) {
+		return nil
+	}
+
+	return WriteResponse(w, r, http.StatusOK, stmt)
+}
+
+// handleGetStatementPDF handles the HTTP request to download the monthly statement of an account as PDF.
+// With a document storage, the client is redirected to the PDF there with a 303 See Other.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the PDF to.
+//   - r: *http.Request containing the account ID and the period.
+//
+// Returns:
+//   - error: An error if the statement cannot be generated, rendered, or stored, otherwise nil.
+func (as *APIServer) handleGetStatementPDF(w http.ResponseWriter, r *http.Request) error {
+	// Get The ID From The URL
+	id := getId(w, r)
+
+	stmt, err := as.buildStatement(r.Context(), id, mux.Vars(r)["period"])
+	if err != nil {
+		return err
+	}
+
+	// Skip Rendering When The Client Already Has The Document
+	setStatementCacheHeaders(w, stmt)
+	if checkNotModified(w, r, statementETag(stmt, "pdf"), stmt.lastModified()) {
+		return nil
+	}
+
+	if as.documents != nil {
+		link, err := as.storeStatementPDF(r.Context(), stmt)
+		if err != nil {
+			return err
+		}
+		return redirectToDocument(w, r, link)
+	}
+
+	pdf, err := renderStatementPDF(stmt)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", statementPDFDisposition(stmt))
+	w.Header().Set("Content-Length", strconv.Itoa(len(pdf)))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(pdf)
+	return err
+}
+
+// storeStatementPDF stores the PDF of a statement in the document storage, unless it is
+// there already, and returns a link to it. The PDF is kept under the entity tag of the
+// statement, so it is rendered again only once the statement changes.
+func (as *APIServer) storeStatementPDF(ctx context.Context, stmt *Statement) (*DocumentLink, error) {
+	key := fmt.Sprintf("statements/%d/%s-%s.pdf", stmt.AccountID, stmt.Period, strings.Trim(statementETag(stmt, "pdf"), "\""))
+	doc := DocumentInfo{ContentType: "application/pdf", ContentDisposition: statementPDFDisposition(stmt)}
+
+	return as.storeDocument(ctx, key, doc, true, func(w io.Writer) error {
+		pdf, err := renderStatementPDF(stmt)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(pdf)
+		return err
+	})
+}
+
+// statementPDFDisposition names the PDF file of a statement.
+func statementPDFDisposition(stmt *Statement) string {
+	return fmt.Sprintf("inline; filename=\"statement-%d-%s.pdf\"", stmt.AccountNumber, stmt.Period)
+}
+
+// renderStatementPDF renders the statement as an A4 PDF document.
+//
+// Parameters:
+//   - stmt: The statement to render.
+//
+// Returns:
+//   - []byte: The PDF document.
+//   - error: An error if rendering fails.
+func renderStatementPDF(stmt *Statement) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(fmt.Sprintf("Statement %s", stmt.Period), true)
+	pdf.AddPage()
+
+	// Header
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.Cell(0, 10, "GoBank Account Statement")
+	pdf.Ln(12)
+
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.Cell(0, 6, fmt.Sprintf("Account holder: %s", stmt.AccountHolder))
+	pdf.Ln(6)
+	pdf.Cell(0, 6, fmt.Sprintf("Account number: %d", stmt.AccountNumber))
+	pdf.Ln(6)
+	pdf.Cell(0, 6, fmt.Sprintf("Period: %s to %s", stmt.PeriodStart.Format(time.DateOnly), stmt.PeriodEnd.AddDate(0, 0, -1).Format(time.DateOnly)))
+	pdf.Ln(10)
+
+	// Summary
+	pdf.SetFont("Helvetica", "B", 11)
+	for _, row := range [][2]string{
+		{"Currency", stmt.Currency},
+		{"Opening balance", stmt.OpeningBalance.Decimal()},
+		{"Total credits", stmt.TotalCredits.Decimal()},
+		{"Total debits", stmt.TotalDebits.Decimal()},
+		{"Closing balance", stmt.ClosingBalance.Decimal()},
+	} {
+		pdf.CellFormat(60, 6, row[0], "", 0, "L", false, 0, "")
+		pdf.CellFormat(40, 6, row[1], "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	// Transactions Table
+	widths := []float64{45, 35, 35, 35, 40}
+	pdf.SetFillColor(230, 230, 230)
+	for i, title := range []string{"Date", "Type", "Counterparty", "Amount", "Balance"} {
+		pdf.CellFormat(widths[i], 7, title, "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, t := range stmt.Transactions {
+		pdf.CellFormat(widths[0], 6, t.CreatedAt.UTC().Format("2006-01-02 15:04"), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 6, t.Type, "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[2], 6, strconv.Itoa(t.CounterpartyID), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 6, t.Amount.Decimal(), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[4], 6, t.BalanceAfter.Decimal(), "1", 1, "R", false, 0, "")
+	}
+
+	if len(stmt.Transactions) == 0 {
+		pdf.CellFormat(190, 6, "No transactions in this period.", "1", 1, "C", false, 0, "")
+	}
+
+	pdf.Ln(6)
+	pdf.SetFont("Helvetica", "I", 8)
+	pdf.Cell(0, 5, fmt.Sprintf("Generated at %s", stmt.GeneratedAt.Format(time.RFC3339)))
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
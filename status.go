@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Build Information, Overridden At Build Time With
+//
+//	go build -ldflags "-X main.Version=1.2.3 -X main.Commit=$(git rev-parse HEAD) -X main.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// BuildInfo is the response body of GET /version.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// ServiceStatus is the response body of GET /api/v1/status.
+type ServiceStatus struct {
+	Service  string          `json:"service"`
+	Version  string          `json:"version"`
+	Commit   string          `json:"commit"`
+	Built    string          `json:"build_date"`
+	Uptime   string          `json:"uptime"`
+	Started  time.Time       `json:"started_at"`
+	Features map[string]bool `json:"features"`
+	// SchemaVersion is the newest applied migration, left out for stores without migrations.
+	SchemaVersion *int `json:"schema_version,omitempty"`
+	// Maintenance is the maintenance under way, left out when there is none.
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+}
+
+// buildCommit returns the commit the binary was built from, preferring the ldflags value
+// and falling back to the VCS information embedded by the Go toolchain.
+func buildCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+
+	return "unknown"
+}
+
+// buildDate returns when the binary was built, preferring the ldflags value and falling
+// back to the time of the commit embedded by the Go toolchain.
+func buildDate() string {
+	if BuildDate != "" {
+		return BuildDate
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.time" {
+				return setting.Value
+			}
+		}
+	}
+
+	return "unknown"
+}
+
+// buildInfo returns the build information of the running binary.
+func buildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		Commit:    buildCommit(),
+		BuildDate: buildDate(),
+		GoVersion: runtime.Version(),
+	}
+}
+
+// handleVersion handles the HTTP request for the build information of the running
+// binary. Unlike /api/v1/status it never touches the database.
+func (as *APIServer) handleVersion(w http.ResponseWriter, r *http.Request) error {
+	return WriteResponse(w, r, http.StatusOK, buildInfo())
+}
+
+// features reports which optional capabilities this instance offers.
+func (as *APIServer) features() map[string]bool {
+	return map[string]bool{
+		"api_v2":           true,
+		"async_transfers":  true,
+		"sse_events":       true,
+		"websocket":        true,
+		"csv_export":       true,
+		"pdf_statements":   true,
+		"batch_requests":   true,
+		"admin_api":        as.config.Auth.AdminToken != "" || as.staffAuth != nil,
+		"staff_login":      as.staffAuth != nil,
+		"content_encoding": true,
+		"exchange_rates":   as.rates != nil,
+		"document_storage": as.documents != nil,
+		"card_top_ups":     as.stripe != nil,
+		"slack_alerts":     as.alerts != nil,
+		"kyc":              as.kyc != nil,
+		"settlement":       as.settlement != nil,
+		"webhook_secrets":  as.webhookKeys != nil,
+		"iso20022":         as.config.ISO20022.BIC != "",
+		"interest_accrual": as.config.Jobs.InterestAnnualRateBps > 0,
+		"open_banking":     true,
+	}
+}
+
+// handleStatus handles the HTTP request for the public service metadata: version,
+// build commit, uptime, the feature availability flags, the schema version of
+// the database when the store has one, and the maintenance under way.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request received from the client.
+//
+// Returns:
+//   - error: An error if writing the response fails, otherwise nil.
+func (as *APIServer) handleStatus(w http.ResponseWriter, r *http.Request) error {
+	status := ServiceStatus{
+		Service:     "gobank",
+		Version:     Version,
+		Commit:      buildCommit(),
+		Built:       buildDate(),
+		Uptime:      time.Since(as.startedAt).Round(time.Second).String(),
+		Started:     as.startedAt,
+		Features:    as.features(),
+		Maintenance: as.maintenance.Current(),
+	}
+
+	// A Database That Cannot Answer Leaves The Version Out
+	if versioner, ok := unwrapStorage(as.store).(schemaVersioner); ok {
+		if version, err := versioner.SchemaVersion(r.Context()); err == nil {
+			status.SchemaVersion = &version
+		}
+	}
+
+	return WriteResponse(w, r, http.StatusOK, status)
+}
@@ -1,99 +1,579 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/moabdelazem/gobank/internal/db"
+	"github.com/moabdelazem/gobank/money"
 )
 
+// ErrAccountVersionConflict is returned when an account was modified (or removed)
+// since the version the caller based its update on.
+var ErrAccountVersionConflict = errors.New("account was modified by another request")
+
+// ErrAccountNotFound is returned when no active account has the requested ID or number.
+var ErrAccountNotFound = errors.New("account not found")
+
+// ErrInsufficientFunds is returned when a debit would leave the balance of an account
+// below zero.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// ErrTransferNotFound is returned when no transfer has the requested ID.
+var ErrTransferNotFound = errors.New("transfer not found")
+
+// ErrPushDeviceNotFound is returned when an account has no push device with the requested ID.
+var ErrPushDeviceNotFound = errors.New("push device not found")
+
+// ErrConsentNotFound is returned when an account has no open banking consent with the requested ID.
+var ErrConsentNotFound = errors.New("consent not found")
+
+// ErrWebhookSecretNotFound is returned when no webhook secret has the requested ID.
+var ErrWebhookSecretNotFound = errors.New("webhook secret not found")
+
+// ErrScheduledJobNotFound is returned when no scheduled job has the requested name.
+var ErrScheduledJobNotFound = errors.New("scheduled job not found")
+
+// ErrScheduledJobLocked is returned when a scheduled job is being run by another instance,
+// or when the lease of the instance running it has been taken over.
+var ErrScheduledJobLocked = errors.New("scheduled job is running")
+
+// ErrIdempotencyKeyLost is returned when the response of a request sent with an
+// Idempotency-Key is stored after another request has taken the key over.
+var ErrIdempotencyKeyLost = errors.New("idempotency key was taken over by another request")
+
+// ErrKYCCheckNotFound is returned when an account has no KYC check, or no check has the
+// requested provider ID.
+var ErrKYCCheckNotFound = errors.New("kyc check not found")
+
+// ErrKYCCheckStatusChanged is returned when a KYC check is updated from a state it is no longer in.
+var ErrKYCCheckStatusChanged = errors.New("kyc check is no longer in the expected state")
+
+// ErrAccountNumberTaken is returned when an account is stored with the number of another account.
+var ErrAccountNumberTaken = errors.New("account number is already taken")
+
+// ErrExternalIDTaken is returned when an account is stored with the external ID of another account.
+var ErrExternalIDTaken = errors.New("external id is already taken")
+
+// ErrProviderReferenceTaken is returned when a transfer is stored with the provider reference of another transfer.
+var ErrProviderReferenceTaken = errors.New("provider reference is already taken")
+
+// ErrGatewayEventDuplicate is returned when a gateway callback was already received.
+var ErrGatewayEventDuplicate = errors.New("gateway event was already received")
+
+// ErrCardTopUpNotFound is returned when an account has no card top-up with the requested ID,
+// or no top-up has the requested payment intent.
+var ErrCardTopUpNotFound = errors.New("card top-up not found")
+
+// ErrCardTopUpStatusChanged is returned when a card top-up is updated from a state it is no longer in.
+var ErrCardTopUpStatusChanged = errors.New("card top-up is no longer in the expected state")
+
+// constraintCardTopUpChargeKey credits a Stripe charge once, see
+// migrations/0020_create_card_top_ups.up.sql.
+const constraintCardTopUpChargeKey = "card_top_ups_charge_id_key"
+
+// constraintTransferProviderReferenceKey keeps the provider references unique, see
+// migrations/0019_create_gateway_events.up.sql.
+const constraintTransferProviderReferenceKey = "transfers_provider_reference_key"
+
+// Account Constraints, See migrations/0010_add_account_constraints.up.sql
+const (
+	constraintAccountNumberKey          = "accounts_number_key"
+	constraintAccountBalanceNonNegative = "accounts_balance_non_negative"
+)
+
+// constraintAccountExternalIDKey keeps the external IDs unique, see
+// migrations/0028_add_account_external_id.up.sql.
+const constraintAccountExternalIDKey = "accounts_external_id_key"
+
+// AccountRepository stores the accounts.
+type AccountRepository interface {
+	CreateAccount(context.Context, *Account) error
+	CreateAccounts(context.Context, []*Account) error
+	DeleteAccount(context.Context, int) error
+	UpdateAccount(context.Context, *Account) error
+	GetAccounts(context.Context) ([]*Account, error)
+	GetAccountsPage(ctx context.Context, limit, offset int, includeDeleted bool) ([]*Account, int, error)
+	GetAccountById(context.Context, int) (*Account, error)
+	GetAccountByExternalID(ctx context.Context, externalID string) (*Account, error)
+	SetAccountFrozen(ctx context.Context, id int, frozen bool) error
+	SetTransferLimit(ctx context.Context, id int, limit money.Money) error
+	SetAccountKYCStatus(ctx context.Context, id int, status string) error
+	// UpdateDormantAccounts marks the accounts without transactions since inactiveSince as
+	// dormant, and clears the mark of the dormant accounts with newer transactions.
+	UpdateDormantAccounts(ctx context.Context, inactiveSince time.Time) (dormant, reactivated []int, err error)
+}
+
+// UserRepository looks up the users of the API, the account holders. They log in as
+// their account, with its number and password, and get a token naming the number, so
+// the users are the accounts; handleLogin and withJWTAuth depend on this repository only.
+type UserRepository interface {
+	// GetAccountByNumber returns the account of the number of a login or of a token.
+	GetAccountByNumber(context.Context, int64) (*Account, error)
+	// GetAccountById returns the account of a route, which the token must be that of.
+	GetAccountById(context.Context, int) (*Account, error)
+}
+
+// TransactionRepository stores the ledger entries of the accounts.
+type TransactionRepository interface {
+	GetTransactions(ctx context.Context, accountID int, filter *TransactionFilter) ([]*Transaction, error)
+	GetTransactionsPage(ctx context.Context, accountID int, filter *TransactionFilter, after *TransactionCursor, limit int) ([]*Transaction, error)
+	CreateTransactions(context.Context, []*Transaction) error
+	GetBalanceAt(ctx context.Context, accountID int, at time.Time) (money.Money, error)
+	StreamTransactions(ctx context.Context, accountID int, from, to time.Time, fn func(*Transaction) error) error
+	// StreamLedger streams the entries of every account, for the journal export.
+	StreamLedger(ctx context.Context, from, to time.Time, fn func(*Transaction) error) error
+}
+
+// TransferRepository moves money between accounts and tracks the transfers.
+type TransferRepository interface {
+	TransferFunds(ctx context.Context, fromID, toID int, amount money.Money) ([]*Transaction, error)
+	CreateTransfer(context.Context, *Transfer) error
+	UpdateTransferStatus(ctx context.Context, transfer *Transfer, status, reason string) error
+	GetTransfer(context.Context, int) (*Transfer, error)
+	GetTransfersByStatus(ctx context.Context, status string) ([]*Transfer, error)
+	GetTransferByProviderReference(ctx context.Context, reference string) (*Transfer, error)
+	// DepositFunds credits an account with money received from outside the bank, recording
+	// the ledger entry deposit, whose ID, balance, and time are filled in.
+	DepositFunds(ctx context.Context, deposit *Transaction) error
+}
+
+// AuditRepository stores the audit log of the operator actions.
+type AuditRepository interface {
+	CreateAuditEntry(context.Context, *AuditEntry) error
+	GetAuditLog(ctx context.Context, limit, offset int) ([]*AuditEntry, int, error)
+}
+
+// FeatureFlagRepository stores the overrides of the feature flags, see features.go.
+type FeatureFlagRepository interface {
+	GetFeatureFlags(context.Context) ([]*FeatureFlag, error)
+	SetFeatureFlag(context.Context, *FeatureFlag) error
+	DeleteFeatureFlag(ctx context.Context, name string) error
+}
+
+// MaintenanceRepository stores the maintenance mode, see maintenance.go.
+type MaintenanceRepository interface {
+	// GetMaintenance returns the maintenance under way, or nil.
+	GetMaintenance(context.Context) (*Maintenance, error)
+	SetMaintenance(context.Context, *Maintenance) error
+	ClearMaintenance(context.Context) error
+}
+
+// NotificationRepository stores the notification preferences of the accounts, see notifications.go.
+type NotificationRepository interface {
+	// GetNotificationPreferences returns the preferences of an account, or nil when none were saved.
+	GetNotificationPreferences(ctx context.Context, accountID int) (*NotificationPreferences, error)
+	SetNotificationPreferences(context.Context, *NotificationPreferences) error
+}
+
+// PushDeviceRepository stores the devices the push notifications are sent to, see push.go.
+type PushDeviceRepository interface {
+	// RegisterPushDevice stores a device, moving its token from the account it was registered to before.
+	RegisterPushDevice(context.Context, *PushDevice) error
+	GetPushDevices(ctx context.Context, accountID int) ([]*PushDevice, error)
+	DeletePushDevice(ctx context.Context, accountID, id int) error
+}
+
+// GatewayEventRepository records the callbacks of the payment gateway, see gateway.go.
+type GatewayEventRepository interface {
+	// RecordGatewayEvent stores a callback, or returns ErrGatewayEventDuplicate if it was already received.
+	RecordGatewayEvent(context.Context, *GatewayEvent) error
+}
+
+// CardTopUpRepository stores the card payments funding the accounts, see topups.go.
+type CardTopUpRepository interface {
+	CreateCardTopUp(context.Context, *CardTopUp) error
+	GetCardTopUp(ctx context.Context, accountID, id int) (*CardTopUp, error)
+	GetCardTopUpByPaymentIntent(ctx context.Context, paymentIntentID string) (*CardTopUp, error)
+	// UpdateCardTopUp stores the changes of a top-up, or returns ErrCardTopUpStatusChanged
+	// if it is no longer in the state fromStatus.
+	UpdateCardTopUp(ctx context.Context, topUp *CardTopUp, fromStatus string) error
+}
+
+// ConsentRepository stores the consents of the account holders to the open banking
+// aggregators, see openbanking.go.
+type ConsentRepository interface {
+	CreateConsent(context.Context, *Consent) error
+	// GetConsent returns a consent of any account, for the token presenting it.
+	GetConsent(ctx context.Context, id int) (*Consent, error)
+	GetConsents(ctx context.Context, accountID int) ([]*Consent, error)
+	// RevokeConsent revokes a consent of an account; revoking it again changes nothing.
+	RevokeConsent(ctx context.Context, accountID, id int) error
+}
+
+// KYCRepository stores the identity checks of the account holders at the KYC provider,
+// see kyc.go.
+type KYCRepository interface {
+	CreateKYCCheck(context.Context, *KYCCheck) error
+	// GetKYCCheck returns the latest check of an account.
+	GetKYCCheck(ctx context.Context, accountID int) (*KYCCheck, error)
+	GetKYCCheckByProviderID(ctx context.Context, providerCheckID string) (*KYCCheck, error)
+	// GetOpenKYCChecks returns up to limit checks without a result, the ones not submitted
+	// to the provider first, then the least recently updated.
+	GetOpenKYCChecks(ctx context.Context, limit int) ([]*KYCCheck, error)
+	// UpdateKYCCheck stores the changes of a check, or returns ErrKYCCheckStatusChanged
+	// if it is no longer in the state fromStatus.
+	UpdateKYCCheck(ctx context.Context, check *KYCCheck, fromStatus string) error
+}
+
+// WebhookSecretRepository stores the encrypted signing secrets of the inbound webhooks,
+// see webhooks.go.
+type WebhookSecretRepository interface {
+	CreateWebhookSecret(context.Context, *WebhookSecret) error
+	// GetWebhookSecrets returns the secrets of a provider, of every provider when it is
+	// empty, expired ones included.
+	GetWebhookSecrets(ctx context.Context, provider string) ([]*WebhookSecret, error)
+	// ExpireWebhookSecret makes a secret expire at the given time, or at the earlier one
+	// it was already set to expire at.
+	ExpireWebhookSecret(ctx context.Context, id int, at time.Time) (*WebhookSecret, error)
+}
+
+// OutboxRepository stores the domain events waiting to be published, see outbox.go.
+type OutboxRepository interface {
+	AddOutboxEvents(ctx context.Context, events []*OutboxEvent) error
+	GetUnpublishedOutboxEvents(ctx context.Context, limit int) ([]*OutboxEvent, error)
+	MarkOutboxEventsPublished(ctx context.Context, ids []int) error
+	GetPublishedOutboxEvents(ctx context.Context, filter OutboxEventFilter, afterID, limit int) ([]*OutboxEvent, error)
+	// DeletePublishedOutboxEvents removes the published events created before the given
+	// time with an ID up to throughID, once they have been archived.
+	DeletePublishedOutboxEvents(ctx context.Context, before time.Time, throughID int) (int, error)
+}
+
+// SchedulerRepository stores the jobs of the scheduler and their run history, see scheduler.go.
+type SchedulerRepository interface {
+	// RegisterScheduledJob stores a job unless it already exists, and fills it in with
+	// the stored one, which keeps the schedule an administrator has set.
+	RegisterScheduledJob(context.Context, *ScheduledJob) error
+	GetScheduledJobs(context.Context) ([]*ScheduledJob, error)
+	GetScheduledJob(ctx context.Context, name string) (*ScheduledJob, error)
+	// UpdateScheduledJob stores the schedule, the enabled flag, and the next run of a job.
+	UpdateScheduledJob(context.Context, *ScheduledJob) error
+	// LockScheduledJob leases a job to owner until the given time, or returns
+	// ErrScheduledJobLocked while another owner holds an unexpired lease.
+	LockScheduledJob(ctx context.Context, name, owner string, now, until time.Time) (*ScheduledJob, error)
+	// UnlockScheduledJob releases the lease of owner, recording the last run and the
+	// next one when they are set.
+	UnlockScheduledJob(ctx context.Context, name, owner string, lastRunAt, nextRunAt *time.Time) error
+	CreateJobRun(context.Context, *JobRun) error
+	FinishJobRun(context.Context, *JobRun) error
+	// GetJobRuns returns up to limit runs of a job, newest first.
+	GetJobRuns(ctx context.Context, job string, limit int) ([]*JobRun, error)
+}
+
+// IdempotencyRepository stores the requests sent with an Idempotency-Key and their
+// responses, see idempotency.go.
+type IdempotencyRepository interface {
+	// ReserveIdempotencyKey stores a record whose request is in progress, and returns
+	// nil, unless the key has an unexpired record, which it returns instead: one still
+	// in progress until its LockedUntil, or its response. Expired records and requests
+	// past their LockedUntil are taken over.
+	ReserveIdempotencyKey(context.Context, *IdempotencyRecord) (*IdempotencyRecord, error)
+	// CompleteIdempotencyKey stores the response of a record ReserveIdempotencyKey stored.
+	CompleteIdempotencyKey(context.Context, *IdempotencyRecord) error
+	// ReleaseIdempotencyKey removes a record ReserveIdempotencyKey stored, while it is
+	// in progress, so that the request can be retried.
+	ReleaseIdempotencyKey(context.Context, *IdempotencyRecord) error
+	// DeleteExpiredIdempotencyKeys removes the records expired by now and returns their number.
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error)
+}
+
 // Storage interface
 // Represents a storage interface that defines the methods for interacting with the database.
+// It composes the repositories of one backend, so that they share its transactions:
+// code needing only part of the store should depend on the matching repository.
 type Storage interface {
-	CreateAccount(*Account) error
-	DeleteAccount(int) error
-	UpdateAccount(*Account) error
-	GetAccounts() ([]*Account, error)
-	GetAccountById(int) (*Account, error)
+	AccountRepository
+	UserRepository
+	TransactionRepository
+	TransferRepository
+	AuditRepository
+	FeatureFlagRepository
+	MaintenanceRepository
+	NotificationRepository
+	PushDeviceRepository
+	GatewayEventRepository
+	CardTopUpRepository
+	ConsentRepository
+	KYCRepository
+	WebhookSecretRepository
+	OutboxRepository
+	SchedulerRepository
+	IdempotencyRepository
+
+	Ping(context.Context) error
+	// ImportRecords spans the accounts and transactions of a bulk import.
+	ImportRecords(ctx context.Context, records []*ImportRecord) ([]error, error)
+	WithTx(ctx context.Context, fn func(Storage) error) error
 }
 
 // PostgresStorage struct
 // Represents a PostgreSQL storage implementation.
 type PostgresStorage struct {
-	db *sql.DB
+	pool *pgxpool.Pool
+	db   *sql.DB
+	// q runs the queries: the database itself, or tx inside WithTx.
+	q  dbtx
+	tx *sql.Tx
+	// statements holds the prepared hot path queries, see Prepare.
+	statements map[string]*sql.Stmt
+	// replicas serve the account reads, nil without read replicas.
+	replicas *replicaSet
+}
+
+// dbtx is the query interface shared by *sql.DB and *sql.Tx, and the db.DBTX the
+// generated queries run on.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// queries returns the queries generated by sqlc from queries/*.sql, run on s.q, so
+// inside WithTx on the transaction.
+func (s *PostgresStorage) queries() *db.Queries {
+	return db.New(s.q)
+}
+
+// Default Connection Pool Settings
+const (
+	defaultDBMaxConns        = 10
+	defaultDBMaxConnIdleTime = 5 * time.Minute
+	defaultDBMaxConnLifetime = time.Hour
+)
+
+// Default Startup Connection Retry Settings
+const (
+	defaultDBConnectTimeout    = 30 * time.Second
+	defaultDBConnectBackoff    = 500 * time.Millisecond
+	defaultDBConnectMaxBackoff = 5 * time.Second
+)
+
+// loadDotEnv loads the variables of a .env file in the working directory into the
+// environment when the file exists. Variables already set in the environment win
+// over the file, and a missing file is not an error: the configuration can come
+// from the plain environment alone.
+//
+// Returns:
+//   - error: An error if the .env file exists but cannot be read or parsed.
+func loadDotEnv() error {
+	if _, err := os.Stat(".env"); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return godotenv.Load()
 }
 
-// NewPostgresStorage initializes a new PostgresStorage instance by loading
-// environment variables from a .env file and establishing a connection to
-// the PostgreSQL database using the connection string specified in the DB_URL
-// environment variable. It returns a pointer to the PostgresStorage instance
-// and an error if any occurs during the process.
+// NewPostgresStorage initializes a new PostgresStorage instance by opening a pgx
+// connection pool to the PostgreSQL database at the connection string of cfg, see
+// DatabaseConfig.ConnString. The pool is tuned with the max_conns, max_conn_idle_time,
+// max_conn_lifetime, and statement_timeout settings (no statement timeout is applied
+// when it is 0). The read replicas listed in replica_urls get pools with the same
+// settings and serve the account reads, see readQueryContext. A primary that is not
+// up yet is retried with exponential backoff, see waitForDatabase.
+// It returns a pointer to the PostgresStorage instance and an error if any occurs during the process.
+//
+// Parameters:
+//   - cfg: The database settings.
 //
 // Returns:
 //   - *PostgresStorage: A pointer to the initialized PostgresStorage instance.
-//   - error: An error if there is an issue loading the .env file, opening the
-//     database connection, or pinging the database.
-func NewPostgresStorage() (*PostgresStorage, error) {
-	err := godotenv.Load()
+//   - error: An error if no database is configured, or there is an issue parsing the
+//     connection string, opening the pool, or pinging the database.
+func NewPostgresStorage(cfg *DatabaseConfig) (*PostgresStorage, error) {
+	ctx := context.Background()
+
+	connString, err := cfg.ConnString()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		return nil, err
 	}
 
-	connString := os.Getenv("DB_URL")
-
-	db, err := sql.Open("postgres", connString)
-
+	pool, err := newPool(ctx, connString, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	// Test The Connection
-	if err := db.Ping(); err != nil {
+	// Wait For The Database To Accept Connections
+	if err := waitForDatabase(ctx, pool, cfg); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	replicas, err := newReplicaSet(ctx, cfg)
+	if err != nil {
+		pool.Close()
 		return nil, err
 	}
 
+	db := stdlib.OpenDBFromPool(pool)
+
 	return &PostgresStorage{
-		db: db,
+		pool:     pool,
+		db:       db,
+		q:        db,
+		replicas: replicas,
 	}, nil
 }
 
-// Init initializes the PostgresStorage by creating the accounts table if it does not already exist.
-// The table includes columns for id, first_name, last_name, number, balance, and create_at.
-// If there is an error during table creation, the function logs a fatal error.
-func (s *PostgresStorage) Init() {
-	// Create The Table
-	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS accounts (
-		id SERIAL PRIMARY KEY,
-		first_name TEXT,
-		last_name TEXT,
-		number BIGINT,
-		balance BIGINT,
-		create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`)
+// waitForDatabase pings the database until it answers, waiting connect_backoff after
+// the first failed attempt and doubling the wait after every further one, up to
+// connect_max_backoff. It gives up when the next attempt would start more than
+// connect_timeout after the first one.
+//
+// Parameters:
+//   - ctx: The context of the attempts; cancelling it stops the retries.
+//   - pool: The connection pool of the database.
+//   - cfg: The database settings.
+//
+// Returns:
+//   - error: The error of the last attempt if the database did not answer in time, otherwise nil.
+func waitForDatabase(ctx context.Context, pool *pgxpool.Pool, cfg *DatabaseConfig) error {
+	timeout, backoff, maxBackoff := cfg.ConnectTimeout, cfg.ConnectBackoff, cfg.ConnectMaxBackoff
+
+	deadline := time.Now().Add(timeout)
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithDeadline(ctx, deadline)
+		err := pool.Ping(attemptCtx)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				slog.InfoContext(ctx, "Database Connected", "attempt", attempt)
+			}
+			return nil
+		}
+
+		// Give Up When The Next Attempt Would Start Past The Deadline
+		if backoff >= time.Until(deadline) {
+			slog.ErrorContext(ctx, "Database Unavailable, Giving Up", "attempt", attempt, "timeout", timeout, "error", err)
+			return fmt.Errorf("database not reachable after %d attempts in %s: %w", attempt, timeout, err)
+		}
+
+		slog.WarnContext(ctx, "Database Unavailable, Retrying", "attempt", attempt, "retry_in", backoff, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// newPool opens a connection pool configured with the pool settings of cfg.
+//
+// Parameters:
+//   - ctx: The context used to open the pool.
+//   - connString: The connection string of the database.
+//   - cfg: The database settings.
+//
+// Returns:
+//   - *pgxpool.Pool: The connection pool.
+//   - error: An error if the connection string cannot be parsed or the pool cannot be created.
+func newPool(ctx context.Context, connString string, cfg *DatabaseConfig) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure The Connection Pool
+	poolConfig.MaxConns = int32(cfg.MaxConns)
+	poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	if cfg.StatementTimeout > 0 {
+		poolConfig.ConnConfig.RuntimeParams["statement_timeout"] = fmt.Sprint(cfg.StatementTimeout.Milliseconds())
+	}
+
+	// Trace Every Query, Logging The Slow Ones
+	poolConfig.ConnConfig.Tracer = pgxTracer{slowThreshold: cfg.SlowQueryThreshold}
+
+	return pgxpool.NewWithConfig(ctx, poolConfig)
+}
+
+// PoolStats returns the current statistics of the connection pool.
+func (s *PostgresStorage) PoolStats() *pgxpool.Stat {
+	return s.pool.Stat()
+}
+
+// WithTx runs fn with a Storage whose methods all take part in one database transaction.
+// The transaction is committed when fn returns nil and rolled back when it returns an
+// error or panics. Calling WithTx on the Storage passed to fn joins the running transaction.
+//
+// Parameters:
+//   - ctx: The context of the transaction; cancelling it rolls the transaction back.
+//   - fn: The operations to run atomically.
+//
+// Returns:
+//   - error: The error returned by fn, or an error if the transaction cannot be started or committed.
+func (s *PostgresStorage) WithTx(ctx context.Context, fn func(Storage) error) error {
+	return s.withTx(ctx, func(tx *PostgresStorage) error {
+		return fn(tx)
+	})
+}
+
+// withTx is WithTx for the methods of PostgresStorage that need a transaction themselves.
+func (s *PostgresStorage) withTx(ctx context.Context, fn func(tx *PostgresStorage) error) error {
+	// Join The Running Transaction
+	if s.tx != nil {
+		return fn(s)
+	}
 
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Fatalf("Error Creating Table: %s", err)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&PostgresStorage{pool: s.pool, db: s.db, q: tx, tx: tx, statements: s.statements}); err != nil {
+		tx.Rollback()
+		return err
 	}
+
+	return tx.Commit()
+}
+
+// Ping verifies that the database is still reachable.
+func (s *PostgresStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
 
 // GetAccounts retrieves all accounts from the Postgres database.
-// It executes a SQL query to select all records from the 'accounts' table,
+// It executes a SQL query to select all active records from the 'accounts' table,
 // scans each row into an Account struct, and returns a slice of Account pointers.
 // If an error occurs during the query or scanning process, it returns the error.
 //
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//
 // Returns:
 //   - []*Account: A slice of pointers to Account structs representing the accounts.
 //   - error: An error object if an error occurs, otherwise nil.
-func (s *PostgresStorage) GetAccounts() ([]*Account, error) {
+func (s *PostgresStorage) GetAccounts(ctx context.Context) ([]*Account, error) {
 	// Query The Database
-	rows, err := s.db.Query(`SELECT * FROM accounts`)
+	rows, err := s.readQueryContext(ctx, `SELECT `+accountColumns+` FROM accounts WHERE deleted_at IS NULL`)
 
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	accounts := []*Account{}
 	for rows.Next() {
@@ -104,53 +584,198 @@ func (s *PostgresStorage) GetAccounts() ([]*Account, error) {
 		accounts = append(accounts, account)
 	}
 
-	return accounts, nil
+	return accounts, rows.Err()
+}
+
+// GetAccountsPage retrieves a single page of accounts ordered by ID together with
+// the total number of accounts, so callers can build pagination metadata.
+// Deleted accounts are left out unless includeDeleted is set.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - limit: The maximum number of accounts to return.
+//   - offset: The number of accounts to skip.
+//   - includeDeleted: Whether soft-deleted accounts are listed too, for admin queries.
+//
+// Returns:
+//   - []*Account: A slice of pointers to Account structs on the requested page.
+//   - int: The total number of accounts.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetAccountsPage(ctx context.Context, limit, offset int, includeDeleted bool) ([]*Account, int, error) {
+	where := ` WHERE deleted_at IS NULL`
+	if includeDeleted {
+		where = ""
+	}
+
+	var total int
+	if err := s.q.QueryRowContext(ctx, `SELECT COUNT(*) FROM accounts`+where).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.q.QueryContext(ctx, `SELECT `+accountColumns+` FROM accounts`+where+` ORDER BY id LIMIT $1 OFFSET $2`, limit, offset)
+
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, total, rows.Err()
 }
 
 // CreateAccount inserts a new account record into the accounts table in the database.
-// It takes an Account struct as input and returns an error if the insertion fails.
+// It takes an Account struct as input, fills in the generated ID, creation and modification times, and version,
+// and returns an error if the insertion fails.
 //
 // Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
 //   - account: A pointer to an Account struct containing the account details to be inserted.
 //
 // Returns:
 //   - error: An error object if the insertion fails, otherwise nil.
-func (s *PostgresStorage) CreateAccount(account *Account) error {
-	_, err := s.db.Exec(`INSERT INTO accounts (
-	first_name,
-	last_name,
-	number,
-	balance
-	) VALUES ($1, $2, $3, $4)`, account.FirstName, account.LastName, account.Number, account.Balance)
+func (s *PostgresStorage) CreateAccount(ctx context.Context, account *Account) error {
+	created, err := s.queries().CreateAccount(ctx, db.CreateAccountParams{
+		FirstName:         account.FirstName,
+		LastName:          account.LastName,
+		Number:            account.Number,
+		Balance:           account.Balance.Amount,
+		Currency:          account.Currency,
+		KycStatus:         account.KYCStatus,
+		ExternalID:        account.ExternalID,
+		EncryptedPassword: account.EncryptedPassword,
+	})
 
 	if err != nil {
-		return err
+		return constraintError(err)
 	}
 
+	account.ID = int(created.ID)
+	account.CreatedAt = created.CreateAt
+	account.Version = int(created.Version)
+	account.UpdatedAt = created.UpdatedAt
+	account.KYCStatus = created.KycStatus
 	return nil
 }
 
-// DeleteAccount deletes an account from the database based on the provided account ID.
+// insertBatchRows is the maximum number of rows of one multi-row INSERT, which keeps
+// the statements well below the 65535 parameters Postgres accepts.
+const insertBatchRows = 1000
+
+// CreateAccounts inserts several accounts with multi-row INSERT statements instead of one
+// round trip per account, filling in their generated IDs, timestamps, and versions.
+// Accounts without a creation time are created now, and those without a KYC status
+// start not_started, as CreateAccount does. Either all accounts are stored or none.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accounts: The accounts to insert.
+//
+// Returns:
+//   - error: ErrAccountNumberTaken if a number is taken, ErrExternalIDTaken if an external
+//     ID is, another error object if the insertion fails, otherwise nil.
+func (s *PostgresStorage) CreateAccounts(ctx context.Context, accounts []*Account) error {
+	return s.withTx(ctx, func(tx *PostgresStorage) error {
+		for start := 0; start < len(accounts); start += insertBatchRows {
+			chunk := accounts[start:min(start+insertBatchRows, len(accounts))]
+
+			values := make([]string, 0, len(chunk))
+			args := make([]interface{}, 0, len(chunk)*8)
+			for _, acc := range chunk {
+				n := len(args)
+				values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, COALESCE(NULLIF($%d, ''), 'not_started'), NULLIF($%d, ''), COALESCE($%d::timestamp, CURRENT_TIMESTAMP))", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+				args = append(args, acc.FirstName, acc.LastName, acc.Number, acc.Balance, acc.Currency, acc.KYCStatus, acc.ExternalID, nullTime(acc.CreatedAt))
+			}
+
+			// The Rows Come Back In The Order Of The VALUES List
+			rows, err := tx.q.QueryContext(ctx, `INSERT INTO accounts (
+			first_name,
+			last_name,
+			number,
+			balance,
+			currency,
+			kyc_status,
+			external_id,
+			create_at
+			) VALUES `+strings.Join(values, ", ")+` RETURNING id, create_at, version, updated_at, kyc_status`, args...)
+			if err != nil {
+				return constraintError(err)
+			}
+
+			for _, acc := range chunk {
+				if !rows.Next() {
+					break
+				}
+				if err := rows.Scan(&acc.ID, &acc.CreatedAt, &acc.Version, &acc.UpdatedAt, &acc.KYCStatus); err != nil {
+					rows.Close()
+					return err
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return constraintError(err)
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteAccount soft-deletes an account based on the provided account ID by setting its
+// deleted_at time; the row and its ledger are kept, but reads no longer return the account.
 // It returns an error if the account is not found or if there is an issue with the database query.
 //
 // Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
 //   - id: The ID of the account to be deleted.
 //
 // Returns:
-//   - error: An error object if the account is not found or if there is a database query issue, otherwise nil.
-func (s *PostgresStorage) DeleteAccount(id int) error {
-	_, err := s.db.Query(`DELETE FROM accounts WHERE id = $1`, id)
-
+//   - error: ErrAccountNotFound if no active account has the ID, another error if the query fails, otherwise nil.
+func (s *PostgresStorage) DeleteAccount(ctx context.Context, id int) error {
+	n, err := s.queries().SoftDeleteAccount(ctx, int32(id))
 	if err != nil {
-		return fmt.Errorf("account %d not found", id)
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
 	}
 
 	return nil
 }
 
-// TODO: Implement UpdateAccount method
-// UpdateAccount updates an existing account in the database with the provided account details.
-func (s *PostgresStorage) UpdateAccount(account *Account) error {
+// UpdateAccount updates the name of an existing account in the database with the provided account details.
+// The update only applies if the stored version still matches account.Version; on success the
+// version and modification time are written back into account.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - account: A pointer to an Account struct containing the new details and the expected version.
+//
+// Returns:
+//   - error: ErrAccountVersionConflict if the account changed or does not exist, otherwise nil.
+func (s *PostgresStorage) UpdateAccount(ctx context.Context, account *Account) error {
+	updated, err := s.queries().UpdateAccountName(ctx, db.UpdateAccountNameParams{
+		FirstName: account.FirstName,
+		LastName:  account.LastName,
+		ID:        int32(account.ID),
+		Version:   int32(account.Version),
+	})
+
+	if err == sql.ErrNoRows {
+		return ErrAccountVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	account.Version = int(updated.Version)
+	account.UpdatedAt = updated.UpdatedAt
 	return nil
 }
 
@@ -159,39 +784,2352 @@ func (s *PostgresStorage) UpdateAccount(account *Account) error {
 // and returns a pointer to the Account struct if the account is found. If the account is not found, it returns an error.
 //
 // Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
 //   - id: The ID of the account to retrieve.
 //
 // Returns:
 //   - *Account: A pointer to the Account struct representing the account.
 //   - error: An error object if the account is not found, otherwise nil.
-func (s *PostgresStorage) GetAccountById(id int) (*Account, error) {
-	rows, err := s.db.Query(`SELECT * FROM accounts WHERE id = $1`, id)
+func (s *PostgresStorage) GetAccountById(ctx context.Context, id int) (*Account, error) {
+	rows, err := s.readQueryContext(ctx, sqlGetAccountById, id)
 
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	for rows.Next() {
+	if rows.Next() {
 		return scanIntoAccount(rows)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	return nil, fmt.Errorf("account %d not found", id)
+	return nil, fmt.Errorf("%w: %d", ErrAccountNotFound, id)
 }
 
-// scanIntoAccount scans the current row of the provided SQL rows object into an Account struct.
-// It returns a pointer to the Account struct and an error if the scanning process fails.
+// GetAccountByNumber retrieves an account from the database based on the provided account number.
 //
 // Parameters:
-//   - row: A pointer to the SQL rows object representing the current row.
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - number: The account number to look up.
 //
 // Returns:
-//   - *Account: A pointer to the Account struct containing the scanned data.
-//   - error: An error object if the scanning process fails, otherwise nil.
-func scanIntoAccount(row *sql.Rows) (*Account, error) {
-	account := &Account{}
-	if err := row.Scan(&account.ID, &account.FirstName, &account.LastName, &account.Number, &account.Balance, &account.CreatedAt); err != nil {
+//   - *Account: A pointer to the Account struct representing the account.
+//   - error: An error object if the account is not found, otherwise nil.
+func (s *PostgresStorage) GetAccountByNumber(ctx context.Context, number int64) (*Account, error) {
+	row, err := s.queries().GetAccountByNumber(ctx, number)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: number %d", ErrAccountNotFound, number)
+	}
+	if err != nil {
 		return nil, err
 	}
-	return account, nil
 
+	return accountFromModel(row.Account), nil
+}
+
+// GetAccountByExternalID retrieves the active account created under an external ID.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - externalID: The reference the client created the account with.
+//
+// Returns:
+//   - *Account: A pointer to the Account struct representing the account.
+//   - error: ErrAccountNotFound if no active account has the external ID, another error if the query fails.
+func (s *PostgresStorage) GetAccountByExternalID(ctx context.Context, externalID string) (*Account, error) {
+	row, err := s.queries().GetAccountByExternalID(ctx, externalID)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: external id %s", ErrAccountNotFound, externalID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return accountFromModel(row.Account), nil
+}
+
+// accountColumns is the column list matched by scanIntoAccount.
+const accountColumns = `id, first_name, last_name, number, balance, create_at, version, frozen, transfer_limit, updated_at, deleted_at, kyc_status, dormant_at, currency, external_id, encrypted_password`
+
+// transactionColumns is the column list matched by scanIntoTransaction.
+const transactionColumns = `id, account_id, counterparty_id, type, amount, balance_after, created_at, category, reference, currency`
+
+// GetTransactions retrieves the ledger entries of an account that match the filter, newest first.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accountID: The ID of the account whose transactions are retrieved.
+//   - filter: The conditions the transactions must meet, or nil for all transactions.
+//
+// Returns:
+//   - []*Transaction: A slice of pointers to Transaction structs.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetTransactions(ctx context.Context, accountID int, filter *TransactionFilter) ([]*Transaction, error) {
+	conditions, args := transactionFilterSQL(filter, []interface{}{accountID})
+
+	rows, err := s.q.QueryContext(ctx, `SELECT `+transactionColumns+`
+	FROM transactions WHERE account_id = $1`+conditions+`
+	ORDER BY created_at DESC, id DESC`, args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	txns := []*Transaction{}
+	for rows.Next() {
+		t, err := scanIntoTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		txns = append(txns, t)
+	}
+
+	return txns, rows.Err()
+}
+
+// GetTransactionsPage retrieves up to limit ledger entries of an account that match the
+// filter, newest first, starting right after the given cursor. It uses keyset pagination
+// on (created_at, id), so the cost of a page does not grow with its depth in the history.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accountID: The ID of the account whose transactions are retrieved.
+//   - filter: The conditions the transactions must meet, or nil for all transactions.
+//   - after: The position of the last transaction of the previous page, or nil for the first page.
+//   - limit: The maximum number of transactions to return.
+//
+// Returns:
+//   - []*Transaction: A slice of pointers to Transaction structs.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetTransactionsPage(ctx context.Context, accountID int, filter *TransactionFilter, after *TransactionCursor, limit int) ([]*Transaction, error) {
+	conditions, args := transactionFilterSQL(filter, []interface{}{accountID})
+
+	if after != nil {
+		args = append(args, after.CreatedAt, after.ID)
+		conditions += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	args = append(args, limit)
+
+	rows, err := s.q.QueryContext(ctx, `SELECT `+transactionColumns+`
+	FROM transactions WHERE account_id = $1`+conditions+`
+	ORDER BY created_at DESC, id DESC LIMIT $`+fmt.Sprint(len(args)), args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	txns := []*Transaction{}
+	for rows.Next() {
+		t, err := scanIntoTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		txns = append(txns, t)
+	}
+
+	return txns, rows.Err()
+}
+
+// transactionFilterSQL turns a filter into conditions to append to a WHERE clause.
+// Values are never interpolated into the SQL: each one becomes a placeholder numbered
+// after the arguments already present.
+//
+// Parameters:
+//   - filter: The filter to translate, or nil.
+//   - args: The arguments of the query so far.
+//
+// Returns:
+//   - string: The conditions, each starting with " AND ", or "" when nothing is filtered.
+//   - []interface{}: args extended with the values of the conditions.
+func transactionFilterSQL(filter *TransactionFilter, args []interface{}) (string, []interface{}) {
+	if filter == nil {
+		return "", args
+	}
+
+	var sb strings.Builder
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		fmt.Fprintf(&sb, " AND "+condition, len(args))
+	}
+
+	if filter.AmountGte != nil {
+		add("amount >= $%d", *filter.AmountGte)
+	}
+	if filter.AmountLte != nil {
+		add("amount <= $%d", *filter.AmountLte)
+	}
+	if len(filter.Types) > 0 {
+		add("type = ANY($%d)", filter.Types)
+	}
+	if len(filter.Categories) > 0 {
+		add("category = ANY($%d)", filter.Categories)
+	}
+	if filter.CounterpartyID != nil {
+		add("counterparty_id = $%d", *filter.CounterpartyID)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		add("created_at >= $%d", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		add("created_at < $%d", filter.CreatedBefore)
+	}
+
+	return sb.String(), args
+}
+
+// CreateTransactions inserts several ledger entries with multi-row INSERT statements
+// instead of one round trip per entry, filling in their IDs and, when missing, creation
+// times. Either all entries are stored or none.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - txns: The ledger entries to insert.
+//
+// Returns:
+//   - error: An error object if the insertion fails, otherwise nil.
+func (s *PostgresStorage) CreateTransactions(ctx context.Context, txns []*Transaction) error {
+	return s.withTx(ctx, func(tx *PostgresStorage) error {
+		for start := 0; start < len(txns); start += insertBatchRows {
+			chunk := txns[start:min(start+insertBatchRows, len(txns))]
+
+			values := make([]string, 0, len(chunk))
+			args := make([]interface{}, 0, len(chunk)*8)
+			for _, t := range chunk {
+				n := len(args)
+				values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, COALESCE($%d::timestamp, CURRENT_TIMESTAMP), $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+				args = append(args, t.AccountID, t.CounterpartyID, t.Type, t.Amount, t.BalanceAfter, t.Currency, nullTime(t.CreatedAt), t.Category)
+			}
+
+			// The Rows Come Back In The Order Of The VALUES List
+			rows, err := tx.q.QueryContext(ctx, `INSERT INTO transactions (
+			account_id,
+			counterparty_id,
+			type,
+			amount,
+			balance_after,
+			currency,
+			created_at,
+			category
+			) VALUES `+strings.Join(values, ", ")+` RETURNING id, created_at`, args...)
+			if err != nil {
+				return err
+			}
+
+			for _, t := range chunk {
+				if !rows.Next() {
+					break
+				}
+				if err := rows.Scan(&t.ID, &t.CreatedAt); err != nil {
+					rows.Close()
+					return err
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// nullTime returns nil for the zero time, so the column default applies, and t otherwise.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// GetBalanceAt returns the balance an account had right before the given time,
+// taken from the last ledger entry created before it, in the currency of the account.
+// Accounts without earlier activity have a balance of zero.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accountID: The ID of the account.
+//   - at: The point in time to compute the balance for.
+//
+// Returns:
+//   - money.Money: The balance of the account at the given time.
+//   - error: ErrAccountNotFound if no active account has the ID, otherwise the error of the query.
+func (s *PostgresStorage) GetBalanceAt(ctx context.Context, accountID int, at time.Time) (money.Money, error) {
+	var balance money.Money
+	err := s.q.QueryRowContext(ctx, `SELECT a.currency, COALESCE((SELECT t.balance_after FROM transactions t
+	WHERE t.account_id = a.id AND t.created_at < $2
+	ORDER BY t.created_at DESC, t.id DESC LIMIT 1), 0)
+	FROM accounts a WHERE a.id = $1 AND a.deleted_at IS NULL`, accountID, at).Scan(&balance.Currency, &balance)
+
+	if err == sql.ErrNoRows {
+		return money.Money{}, fmt.Errorf("%w: %d", ErrAccountNotFound, accountID)
+	}
+
+	return balance, err
+}
+
+// StreamTransactions calls fn for every ledger entry of an account created in the
+// [from, to) range, oldest first, without loading the whole history into memory.
+// A zero from or to leaves that side of the range open. Iteration stops at the first error returned by fn.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accountID: The ID of the account whose transactions are streamed.
+//   - from: The inclusive lower bound of the creation time, or the zero time.
+//   - to: The exclusive upper bound of the creation time, or the zero time.
+//   - fn: The callback invoked for each transaction.
+//
+// Returns:
+//   - error: An error object if the query, the scan, or fn fails, otherwise nil.
+func (s *PostgresStorage) StreamTransactions(ctx context.Context, accountID int, from, to time.Time, fn func(*Transaction) error) error {
+	var fromArg, toArg interface{}
+	if !from.IsZero() {
+		fromArg = from
+	}
+	if !to.IsZero() {
+		toArg = to
+	}
+
+	rows, err := s.q.QueryContext(ctx, `SELECT `+transactionColumns+`
+	FROM transactions
+	WHERE account_id = $1
+	AND ($2::timestamp IS NULL OR created_at >= $2)
+	AND ($3::timestamp IS NULL OR created_at < $3)
+	ORDER BY created_at, id`, accountID, fromArg, toArg)
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanIntoTransaction(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// StreamLedger calls fn for every ledger entry of every account created in the
+// [from, to) range, oldest first, without loading the ledger into memory. Iteration
+// stops at the first error returned by fn.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - from: The inclusive lower bound of the creation time.
+//   - to: The exclusive upper bound of the creation time.
+//   - fn: The callback invoked for each transaction.
+//
+// Returns:
+//   - error: An error object if the query, the scan, or fn fails, otherwise nil.
+func (s *PostgresStorage) StreamLedger(ctx context.Context, from, to time.Time, fn func(*Transaction) error) error {
+	rows, err := s.q.QueryContext(ctx, `SELECT `+transactionColumns+`
+	FROM transactions
+	WHERE created_at >= $1 AND created_at < $2
+	ORDER BY created_at, id`, from, to)
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanIntoTransaction(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// TransferFunds moves the given amount from one account to another inside a single
+// database transaction and records a ledger entry on both accounts.
+// The transfer is rolled back if either account does not exist, is frozen, or is in another
+// currency than the amount, the amount exceeds the transfer limit of the source account, or
+// the source account does not have enough balance. The balance is checked once the accounts
+// are locked, and the accounts_balance_non_negative constraint refuses it again should a
+// debit get past the check.
+// Both accounts are locked with SELECT ... FOR UPDATE, lowest ID first, before any balance is
+// computed, so concurrent transfers on the same accounts run one after the other. The balance
+// updates also only apply to the locked versions, failing with ErrAccountVersionConflict otherwise.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - fromID: The ID of the account to debit.
+//   - toID: The ID of the account to credit.
+//   - amount: The amount to move, must be positive and in the currency of both accounts.
+//
+// Returns:
+//   - []*Transaction: The debit and credit ledger entries, in that order.
+//   - error: ErrInsufficientFunds if the source account does not have the amount,
+//     ErrAccountVersionConflict if an account changed concurrently, money.ErrCurrencyMismatch
+//     if an account is in another currency, money.ErrOverflow if a balance would go out of
+//     range, another error object if the transfer fails, otherwise nil.
+func (s *PostgresStorage) TransferFunds(ctx context.Context, fromID, toID int, amount money.Money) ([]*Transaction, error) {
+	var txns []*Transaction
+
+	err := s.withTx(ctx, func(tx *PostgresStorage) error {
+		// Lock Both Accounts, Lowest ID First So Opposite Transfers Cannot Deadlock
+		var (
+			fromFrozen, toFrozen     bool
+			transferLimit, available int64
+			fromVersion, toVersion   int
+			fromCurrency, toCurrency string
+		)
+		locks := []struct {
+			id       int
+			frozen   *bool
+			limit    *int64
+			version  *int
+			currency *string
+			balance  *int64
+		}{
+			{fromID, &fromFrozen, &transferLimit, &fromVersion, &fromCurrency, &available},
+			{toID, &toFrozen, new(int64), &toVersion, &toCurrency, new(int64)},
+		}
+		if toID < fromID {
+			locks[0], locks[1] = locks[1], locks[0]
+		}
+		for _, lock := range locks {
+			err := tx.queryRowContext(ctx, sqlLockAccount, lock.id).Scan(lock.frozen, lock.limit, lock.version, lock.currency, lock.balance)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("%w: %d", ErrAccountNotFound, lock.id)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		// Check The Operator Restrictions And The Currency Of Both Accounts
+		if fromFrozen || toFrozen {
+			return fmt.Errorf("account is frozen")
+		}
+		if err := checkTransferCurrency(amount, fromCurrency, toCurrency); err != nil {
+			return err
+		}
+		if transferLimit > 0 && amount.Amount > transferLimit {
+			return fmt.Errorf("amount exceeds the transfer limit of %s", money.New(transferLimit, fromCurrency))
+		}
+		if amount.Amount > available {
+			return fmt.Errorf("%w on account %d", ErrInsufficientFunds, fromID)
+		}
+
+		// Debit The Source Account, Unless It Changed Since It Was Read
+		fromBalance := money.Zero(amount.Currency)
+		err := tx.queryRowContext(ctx, sqlDebitAccount, amount, fromID, fromVersion).Scan(&fromBalance)
+		if err == sql.ErrNoRows {
+			return ErrAccountVersionConflict
+		}
+		if violatesConstraint(err, constraintAccountBalanceNonNegative) {
+			return fmt.Errorf("%w on account %d", ErrInsufficientFunds, fromID)
+		}
+		if err != nil {
+			return constraintError(err)
+		}
+
+		// Credit The Destination Account, Unless It Changed Since It Was Read
+		toBalance := money.Zero(amount.Currency)
+		err = tx.queryRowContext(ctx, sqlCreditAccount, amount, toID, toVersion).Scan(&toBalance)
+		if err == sql.ErrNoRows {
+			return ErrAccountVersionConflict
+		}
+		if err != nil {
+			return constraintError(err)
+		}
+
+		// Record The Ledger Entries
+		debit := &Transaction{AccountID: fromID, CounterpartyID: toID, Type: TransactionTypeTransfer, Amount: amount.Neg(), BalanceAfter: fromBalance, Currency: amount.Currency}
+		credit := &Transaction{AccountID: toID, CounterpartyID: fromID, Type: TransactionTypeTransfer, Amount: amount, BalanceAfter: toBalance, Currency: amount.Currency}
+
+		for _, t := range []*Transaction{debit, credit} {
+			err := tx.queryRowContext(ctx, sqlInsertTransferLedger, t.AccountID, t.CounterpartyID, t.Type, t.Amount, t.BalanceAfter, t.Currency).Scan(&t.ID, &t.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		txns = []*Transaction{debit, credit}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return txns, nil
+}
+
+// checkTransferCurrency returns money.ErrCurrencyMismatch unless the accounts a transfer
+// moves amount between, in the given currencies, are both in the currency of amount.
+func checkTransferCurrency(amount money.Money, from, to string) error {
+	if from != amount.Currency || to != amount.Currency {
+		return fmt.Errorf("%w: cannot move %s from an account in %s to one in %s", money.ErrCurrencyMismatch, amount.Currency, from, to)
+	}
+	return nil
+}
+
+// checkDepositCurrency returns money.ErrCurrencyMismatch unless a deposit is in the
+// currency of its account.
+func checkDepositCurrency(deposit *Transaction, account string) error {
+	if deposit.Amount.Currency != account {
+		return fmt.Errorf("%w: account %d is in %s, not %s", money.ErrCurrencyMismatch, deposit.AccountID, account, deposit.Amount.Currency)
+	}
+	return nil
+}
+
+// DepositFunds credits an account with money received from outside the bank, such as a
+// card payment, and records the ledger entry, in one transaction.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - deposit: The ledger entry, with the account, type, amount, and reference set; its
+//     ID, currency, balance after, and creation time are filled in.
+//
+// Returns:
+//   - error: ErrAccountNotFound if the account does not exist or was deleted,
+//     money.ErrCurrencyMismatch if the amount is in another currency than the account,
+//     money.ErrOverflow if the balance would go out of range, an error object if the
+//     update fails, otherwise nil.
+func (s *PostgresStorage) DepositFunds(ctx context.Context, deposit *Transaction) error {
+	return s.withTx(ctx, func(tx *PostgresStorage) error {
+		var currency string
+		err := tx.q.QueryRowContext(ctx, `UPDATE accounts SET balance = balance + $1, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND deleted_at IS NULL RETURNING balance, currency`, deposit.Amount, deposit.AccountID).Scan(&deposit.BalanceAfter, &currency)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %d", ErrAccountNotFound, deposit.AccountID)
+		}
+		if err != nil {
+			return constraintError(err)
+		}
+		// Rolled Back Unless The Deposit Is In The Currency Of The Account
+		if err := checkDepositCurrency(deposit, currency); err != nil {
+			return err
+		}
+		deposit.Currency = currency
+		deposit.denominate()
+
+		return tx.q.QueryRowContext(ctx, `INSERT INTO transactions (
+		account_id,
+		counterparty_id,
+		type,
+		amount,
+		balance_after,
+		currency,
+		category,
+		reference
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`, deposit.AccountID, deposit.CounterpartyID, deposit.Type, deposit.Amount, deposit.BalanceAfter, deposit.Currency, deposit.Category, deposit.Reference).Scan(&deposit.ID, &deposit.CreatedAt)
+	})
+}
+
+// CreateTransfer records a new pending transfer and its first state change.
+// The generated ID, status, timestamps, and history are written back into transfer.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - transfer: The transfer to record, with the accounts and the amount set.
+//
+// Returns:
+//   - error: ErrProviderReferenceTaken if another transfer has the provider reference,
+//     an error object if the insertion fails, otherwise nil.
+func (s *PostgresStorage) CreateTransfer(ctx context.Context, transfer *Transfer) error {
+	return s.withTx(ctx, func(tx *PostgresStorage) error {
+		transfer.Status = TransferStatusPending
+
+		err := tx.q.QueryRowContext(ctx, `INSERT INTO transfers (
+		from_account_id,
+		to_account_id,
+		amount,
+		currency,
+		status,
+		request_id,
+		provider_reference
+		) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')) RETURNING id, created_at, updated_at`, transfer.FromAccountID, transfer.ToAccountID, transfer.Amount, transfer.Currency, transfer.Status, transfer.RequestID, transfer.ProviderReference).Scan(&transfer.ID, &transfer.CreatedAt, &transfer.UpdatedAt)
+		if violatesConstraint(err, constraintTransferProviderReferenceKey) {
+			return fmt.Errorf("%w: %s", ErrProviderReferenceTaken, transfer.ProviderReference)
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.q.ExecContext(ctx, `INSERT INTO transfer_state_changes (transfer_id, status, created_at) VALUES ($1, $2, $3)`, transfer.ID, transfer.Status, transfer.CreatedAt); err != nil {
+			return err
+		}
+
+		transfer.History = []TransferStateChange{{Status: transfer.Status, At: transfer.CreatedAt}}
+
+		return nil
+	})
+}
+
+// UpdateTransferStatus moves a transfer to a new state and appends the change to its history.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - transfer: The transfer to update; its status, reason, timestamps, and history are updated in place.
+//   - status: The new status.
+//   - reason: The failure reason, empty unless the transfer failed.
+//
+// Returns:
+//   - error: An error object if the transfer does not exist or the update fails, otherwise nil.
+func (s *PostgresStorage) UpdateTransferStatus(ctx context.Context, transfer *Transfer, status, reason string) error {
+	var updatedAt time.Time
+
+	err := s.withTx(ctx, func(tx *PostgresStorage) error {
+		err := tx.q.QueryRowContext(ctx, `UPDATE transfers SET status = $1, failure_reason = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 RETURNING updated_at`, status, reason, transfer.ID).Scan(&updatedAt)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %d", ErrTransferNotFound, transfer.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.q.ExecContext(ctx, `INSERT INTO transfer_state_changes (transfer_id, status, reason, created_at) VALUES ($1, $2, $3, $4)`, transfer.ID, status, reason, updatedAt)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	transfer.Status = status
+	transfer.FailureReason = reason
+	transfer.UpdatedAt = updatedAt
+	transfer.History = append(transfer.History, TransferStateChange{Status: status, Reason: reason, At: updatedAt})
+
+	return nil
+}
+
+// GetTransfer retrieves a transfer and the history of its state changes, oldest first.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - id: The ID of the transfer.
+//
+// Returns:
+//   - *Transfer: A pointer to the Transfer struct.
+//   - error: An error object if the transfer is not found, otherwise nil.
+func (s *PostgresStorage) GetTransfer(ctx context.Context, id int) (*Transfer, error) {
+	transfer := &Transfer{}
+	err := s.q.QueryRowContext(ctx, `SELECT id, from_account_id, to_account_id, amount, currency, status, failure_reason, request_id, COALESCE(provider_reference, ''), created_at, updated_at
+	FROM transfers WHERE id = $1`, id).Scan(&transfer.ID, &transfer.FromAccountID, &transfer.ToAccountID, &transfer.Amount, &transfer.Currency, &transfer.Status, &transfer.FailureReason, &transfer.RequestID, &transfer.ProviderReference, &transfer.CreatedAt, &transfer.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrTransferNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	transfer.denominate()
+
+	rows, err := s.q.QueryContext(ctx, `SELECT status, reason, created_at FROM transfer_state_changes
+	WHERE transfer_id = $1 ORDER BY created_at, id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfer.History = []TransferStateChange{}
+	for rows.Next() {
+		change := TransferStateChange{}
+		if err := rows.Scan(&change.Status, &change.Reason, &change.At); err != nil {
+			return nil, err
+		}
+		transfer.History = append(transfer.History, change)
+	}
+
+	return transfer, rows.Err()
+}
+
+// GetTransfersByStatus retrieves the transfers currently in the given state, oldest first.
+// The history of the returned transfers is not loaded.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - status: The transfer status to filter on.
+//
+// Returns:
+//   - []*Transfer: A slice of pointers to Transfer structs.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetTransfersByStatus(ctx context.Context, status string) ([]*Transfer, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT id, from_account_id, to_account_id, amount, currency, status, failure_reason, request_id, COALESCE(provider_reference, ''), created_at, updated_at
+	FROM transfers WHERE status = $1 ORDER BY created_at, id`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []*Transfer{}
+	for rows.Next() {
+		transfer := &Transfer{}
+		if err := rows.Scan(&transfer.ID, &transfer.FromAccountID, &transfer.ToAccountID, &transfer.Amount, &transfer.Currency, &transfer.Status, &transfer.FailureReason, &transfer.RequestID, &transfer.ProviderReference, &transfer.CreatedAt, &transfer.UpdatedAt); err != nil {
+			return nil, err
+		}
+		transfer.denominate()
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, rows.Err()
+}
+
+// GetTransferByProviderReference retrieves the transfer settled by the payment gateway
+// under a reference, and the history of its state changes.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - reference: The reference of the transfer at the gateway.
+//
+// Returns:
+//   - *Transfer: A pointer to the Transfer struct.
+//   - error: An error object if no transfer has the reference, otherwise nil.
+func (s *PostgresStorage) GetTransferByProviderReference(ctx context.Context, reference string) (*Transfer, error) {
+	var id int
+	err := s.q.QueryRowContext(ctx, `SELECT id FROM transfers WHERE provider_reference = $1`, reference).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrTransferNotFound, reference)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetTransfer(ctx, id)
+}
+
+// SetAccountFrozen freezes or unfreezes an account.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - id: The ID of the account.
+//   - frozen: Whether the account is frozen.
+//
+// Returns:
+//   - error: An error object if the account is not found or the update fails, otherwise nil.
+func (s *PostgresStorage) SetAccountFrozen(ctx context.Context, id int, frozen bool) error {
+	n, err := s.queries().SetAccountFrozen(ctx, db.SetAccountFrozenParams{Frozen: frozen, ID: int32(id)})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+	}
+
+	return nil
+}
+
+// SetAccountKYCStatus changes the identity verification status of an account.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - id: The ID of the account.
+//   - status: The status, one of the KYCStatus constants.
+//
+// Returns:
+//   - error: An error object if the account is not found or the update fails, otherwise nil.
+func (s *PostgresStorage) SetAccountKYCStatus(ctx context.Context, id int, status string) error {
+	n, err := s.queries().SetAccountKYCStatus(ctx, db.SetAccountKYCStatusParams{KycStatus: status, ID: int32(id)})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+	}
+
+	return nil
+}
+
+// UpdateDormantAccounts marks the active accounts without transactions since inactiveSince
+// as dormant, and clears the mark of the dormant accounts with a transaction since they
+// were marked. Accounts opened after inactiveSince are never dormant.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - inactiveSince: The start of the period without transactions of a dormant account.
+//
+// Returns:
+//   - dormant: The IDs of the accounts marked dormant.
+//   - reactivated: The IDs of the accounts no longer dormant.
+//   - err: An error object if an update fails, otherwise nil.
+func (s *PostgresStorage) UpdateDormantAccounts(ctx context.Context, inactiveSince time.Time) (dormant, reactivated []int, err error) {
+	reactivated, err = queryIDs(ctx, s.q, `UPDATE accounts a SET dormant_at = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE dormant_at IS NOT NULL AND deleted_at IS NULL
+	AND EXISTS (SELECT 1 FROM transactions t WHERE t.account_id = a.id AND t.created_at >= a.dormant_at)
+	RETURNING id`)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dormant, err = queryIDs(ctx, s.q, `UPDATE accounts a SET dormant_at = CURRENT_TIMESTAMP, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE dormant_at IS NULL AND deleted_at IS NULL AND create_at < $1
+	AND NOT EXISTS (SELECT 1 FROM transactions t WHERE t.account_id = a.id AND t.created_at >= $1)
+	RETURNING id`, inactiveSince)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dormant, reactivated, nil
+}
+
+// queryIDs runs a query returning a single integer column and collects its values.
+func queryIDs(ctx context.Context, q dbtx, query string, args ...any) ([]int, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetTransferLimit changes the maximum amount of a single outgoing transfer of an account.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - id: The ID of the account.
+//   - limit: The new limit in the currency of the account, 0 for unlimited.
+//
+// Returns:
+//   - error: An error object if the account is not found or the update fails, otherwise nil.
+func (s *PostgresStorage) SetTransferLimit(ctx context.Context, id int, limit money.Money) error {
+	n, err := s.queries().SetTransferLimit(ctx, db.SetTransferLimitParams{TransferLimit: limit.Amount, ID: int32(id)})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+	}
+
+	return nil
+}
+
+// CreateAuditEntry appends an entry to the audit log, filling in its ID and creation time.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - entry: The audit entry to record.
+//
+// Returns:
+//   - error: An error object if the insertion fails, otherwise nil.
+func (s *PostgresStorage) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	return s.q.QueryRowContext(ctx, `INSERT INTO audit_log (
+	actor,
+	action,
+	target,
+	details
+	) VALUES ($1, $2, $3, $4) RETURNING id, created_at`, entry.Actor, entry.Action, entry.Target, entry.Details).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// GetAuditLog retrieves a page of the audit log, newest first, together with the total number of entries.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - limit: The maximum number of entries to return.
+//   - offset: The number of entries to skip.
+//
+// Returns:
+//   - []*AuditEntry: A slice of pointers to AuditEntry structs.
+//   - int: The total number of entries.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetAuditLog(ctx context.Context, limit, offset int) ([]*AuditEntry, int, error) {
+	var total int
+	if err := s.q.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log`).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.q.QueryContext(ctx, `SELECT id, actor, action, target, details, created_at FROM audit_log
+	ORDER BY created_at DESC, id DESC LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		entry := &AuditEntry{}
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.Target, &entry.Details, &entry.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, total, rows.Err()
+}
+
+// GetFeatureFlags retrieves the overrides of the feature flags.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//
+// Returns:
+//   - []*FeatureFlag: A slice of pointers to FeatureFlag structs.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetFeatureFlags(ctx context.Context) ([]*FeatureFlag, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT name, enabled, rollout_percent, updated_at FROM feature_flags ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := []*FeatureFlag{}
+	for rows.Next() {
+		flag := &FeatureFlag{}
+		if err := rows.Scan(&flag.Name, &flag.Enabled, &flag.RolloutPercent, &flag.UpdatedAt); err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+
+	return flags, rows.Err()
+}
+
+// SetFeatureFlag creates or replaces the override of a feature flag, filling in its update time.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - flag: The override to store.
+//
+// Returns:
+//   - error: An error object if the upsert fails, otherwise nil.
+func (s *PostgresStorage) SetFeatureFlag(ctx context.Context, flag *FeatureFlag) error {
+	return s.q.QueryRowContext(ctx, `INSERT INTO feature_flags (
+	name,
+	enabled,
+	rollout_percent
+	) VALUES ($1, $2, $3)
+	ON CONFLICT (name) DO UPDATE SET enabled = EXCLUDED.enabled, rollout_percent = EXCLUDED.rollout_percent, updated_at = CURRENT_TIMESTAMP
+	RETURNING updated_at`, flag.Name, flag.Enabled, flag.RolloutPercent).Scan(&flag.UpdatedAt)
+}
+
+// DeleteFeatureFlag removes the override of a feature flag. Removing a missing override is not an error.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - name: The name of the flag.
+//
+// Returns:
+//   - error: An error object if the deletion fails, otherwise nil.
+func (s *PostgresStorage) DeleteFeatureFlag(ctx context.Context, name string) error {
+	_, err := s.q.ExecContext(ctx, `DELETE FROM feature_flags WHERE name = $1`, name)
+	return err
+}
+
+// GetMaintenance retrieves the maintenance under way.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//
+// Returns:
+//   - *Maintenance: The maintenance, or nil when the API is not under maintenance.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetMaintenance(ctx context.Context) (*Maintenance, error) {
+	maintenance := &Maintenance{}
+	err := s.q.QueryRowContext(ctx, `SELECT message, ends_at, started_by, started_at FROM maintenance`).
+		Scan(&maintenance.Message, &maintenance.EndsAt, &maintenance.StartedBy, &maintenance.StartedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return maintenance, nil
+}
+
+// SetMaintenance starts a maintenance, replacing the one under way, and fills in its start time.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - maintenance: The maintenance to store.
+//
+// Returns:
+//   - error: An error object if the upsert fails, otherwise nil.
+func (s *PostgresStorage) SetMaintenance(ctx context.Context, maintenance *Maintenance) error {
+	return s.q.QueryRowContext(ctx, `INSERT INTO maintenance (
+	message,
+	ends_at,
+	started_by
+	) VALUES ($1, $2, $3)
+	ON CONFLICT (id) DO UPDATE SET message = EXCLUDED.message, ends_at = EXCLUDED.ends_at, started_by = EXCLUDED.started_by, started_at = CURRENT_TIMESTAMP
+	RETURNING started_at`, maintenance.Message, maintenance.EndsAt, maintenance.StartedBy).Scan(&maintenance.StartedAt)
+}
+
+// ClearMaintenance ends the maintenance under way. Ending no maintenance is not an error.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//
+// Returns:
+//   - error: An error object if the deletion fails, otherwise nil.
+func (s *PostgresStorage) ClearMaintenance(ctx context.Context) error {
+	_, err := s.q.ExecContext(ctx, `DELETE FROM maintenance`)
+	return err
+}
+
+// GetNotificationPreferences retrieves the notification preferences of an account.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accountID: The ID of the account.
+//
+// Returns:
+//   - *NotificationPreferences: The preferences, or nil when the account has none.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetNotificationPreferences(ctx context.Context, accountID int) (*NotificationPreferences, error) {
+	prefs := &NotificationPreferences{AccountID: accountID}
+	err := s.q.QueryRowContext(ctx, `SELECT email, transfer_confirmations, low_balance_alerts, low_balance_threshold,
+	phone, phone_verified, large_transaction_alerts, large_transaction_threshold, updated_at
+	FROM notification_preferences WHERE account_id = $1`, accountID).
+		Scan(&prefs.Email, &prefs.TransferConfirmations, &prefs.LowBalanceAlerts, &prefs.LowBalanceThreshold,
+			&prefs.Phone, &prefs.PhoneVerified, &prefs.LargeTransactionAlerts, &prefs.LargeTransactionThreshold, &prefs.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// SetNotificationPreferences replaces the notification preferences of an account and
+// fills in their modification time.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - prefs: The preferences to store.
+//
+// Returns:
+//   - error: An error object if the upsert fails, otherwise nil.
+func (s *PostgresStorage) SetNotificationPreferences(ctx context.Context, prefs *NotificationPreferences) error {
+	return s.q.QueryRowContext(ctx, `INSERT INTO notification_preferences (
+	account_id,
+	email,
+	transfer_confirmations,
+	low_balance_alerts,
+	low_balance_threshold,
+	phone,
+	phone_verified,
+	large_transaction_alerts,
+	large_transaction_threshold
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	ON CONFLICT (account_id) DO UPDATE SET email = EXCLUDED.email, transfer_confirmations = EXCLUDED.transfer_confirmations,
+	low_balance_alerts = EXCLUDED.low_balance_alerts, low_balance_threshold = EXCLUDED.low_balance_threshold,
+	phone = EXCLUDED.phone, phone_verified = EXCLUDED.phone_verified,
+	large_transaction_alerts = EXCLUDED.large_transaction_alerts, large_transaction_threshold = EXCLUDED.large_transaction_threshold,
+	updated_at = CURRENT_TIMESTAMP
+	RETURNING updated_at`, prefs.AccountID, prefs.Email, prefs.TransferConfirmations, prefs.LowBalanceAlerts, prefs.LowBalanceThreshold,
+		prefs.Phone, prefs.PhoneVerified, prefs.LargeTransactionAlerts, prefs.LargeTransactionThreshold).Scan(&prefs.UpdatedAt)
+}
+
+// RegisterPushDevice stores a push device and fills in its ID and registration time.
+// A token already registered, by this account or another one, is moved to the device.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - device: The device to store.
+//
+// Returns:
+//   - error: An error object if the upsert fails, otherwise nil.
+func (s *PostgresStorage) RegisterPushDevice(ctx context.Context, device *PushDevice) error {
+	return s.q.QueryRowContext(ctx, `INSERT INTO push_devices (
+	account_id,
+	platform,
+	token,
+	name
+	) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (token) DO UPDATE SET account_id = EXCLUDED.account_id, platform = EXCLUDED.platform, name = EXCLUDED.name, created_at = CURRENT_TIMESTAMP
+	RETURNING id, created_at`, device.AccountID, device.Platform, device.Token, device.Name).Scan(&device.ID, &device.CreatedAt)
+}
+
+// GetPushDevices retrieves the push devices of an account, oldest first.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accountID: The ID of the account.
+//
+// Returns:
+//   - []*PushDevice: The devices, empty when the account has none.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetPushDevices(ctx context.Context, accountID int) ([]*PushDevice, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT id, account_id, platform, token, name, created_at
+	FROM push_devices WHERE account_id = $1 ORDER BY id`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []*PushDevice{}
+	for rows.Next() {
+		device := &PushDevice{}
+		if err := rows.Scan(&device.ID, &device.AccountID, &device.Platform, &device.Token, &device.Name, &device.CreatedAt); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// DeletePushDevice removes a push device of an account.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accountID: The ID of the account the device is registered to.
+//   - id: The ID of the device.
+//
+// Returns:
+//   - error: ErrPushDeviceNotFound if the account has no such device, otherwise the error of the deletion.
+func (s *PostgresStorage) DeletePushDevice(ctx context.Context, accountID, id int) error {
+	res, err := s.q.ExecContext(ctx, `DELETE FROM push_devices WHERE id = $1 AND account_id = $2`, id, accountID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrPushDeviceNotFound, id)
+	}
+	return nil
+}
+
+// RecordGatewayEvent stores a callback of the payment gateway and fills in its
+// reception time. Inside WithTx, a concurrent delivery of the same callback waits for
+// the first one to commit or roll back.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - event: The callback, with its gateway ID.
+//
+// Returns:
+//   - error: ErrGatewayEventDuplicate if the callback was already received, an error
+//     object if the insertion fails, otherwise nil.
+func (s *PostgresStorage) RecordGatewayEvent(ctx context.Context, event *GatewayEvent) error {
+	err := s.q.QueryRowContext(ctx, `INSERT INTO gateway_events (id, type, transfer_id) VALUES ($1, $2, $3)
+	ON CONFLICT (id) DO NOTHING RETURNING received_at`, event.ID, event.Type, event.TransferID).Scan(&event.ReceivedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrGatewayEventDuplicate, event.ID)
+	}
+	return err
+}
+
+// cardTopUpColumns is the column list scanned by getCardTopUp.
+const cardTopUpColumns = `id, account_id, amount, currency, status, COALESCE(payment_intent_id, ''), COALESCE(charge_id, ''),
+	COALESCE(transaction_id, 0), failure_reason, created_at, updated_at`
+
+// CreateCardTopUp stores a new card top-up and fills in its ID and timestamps.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - topUp: The top-up, with the account, amount, currency, and status set.
+//
+// Returns:
+//   - error: An error object if the insertion fails, otherwise nil.
+func (s *PostgresStorage) CreateCardTopUp(ctx context.Context, topUp *CardTopUp) error {
+	return s.q.QueryRowContext(ctx, `INSERT INTO card_top_ups (
+	account_id,
+	amount,
+	currency,
+	status,
+	payment_intent_id
+	) VALUES ($1, $2, $3, $4, NULLIF($5, '')) RETURNING id, created_at, updated_at`, topUp.AccountID, topUp.Amount, topUp.Currency, topUp.Status, topUp.PaymentIntentID).Scan(&topUp.ID, &topUp.CreatedAt, &topUp.UpdatedAt)
+}
+
+// GetCardTopUp retrieves a card top-up of an account.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accountID: The ID of the account the top-up funds.
+//   - id: The ID of the top-up.
+//
+// Returns:
+//   - *CardTopUp: The top-up.
+//   - error: ErrCardTopUpNotFound if the account has no such top-up, otherwise the error of the query.
+func (s *PostgresStorage) GetCardTopUp(ctx context.Context, accountID, id int) (*CardTopUp, error) {
+	return s.getCardTopUp(ctx, `id = $1 AND account_id = $2`, id, accountID)
+}
+
+// GetCardTopUpByPaymentIntent retrieves the card top-up paid by a Stripe payment intent.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - paymentIntentID: The ID of the payment intent, such as pi_123.
+//
+// Returns:
+//   - *CardTopUp: The top-up.
+//   - error: ErrCardTopUpNotFound if no top-up has the payment intent, otherwise the error of the query.
+func (s *PostgresStorage) GetCardTopUpByPaymentIntent(ctx context.Context, paymentIntentID string) (*CardTopUp, error) {
+	return s.getCardTopUp(ctx, `payment_intent_id = $1`, paymentIntentID)
+}
+
+// getCardTopUp retrieves the card top-up matching the condition.
+func (s *PostgresStorage) getCardTopUp(ctx context.Context, condition string, args ...interface{}) (*CardTopUp, error) {
+	topUp := &CardTopUp{}
+	err := s.q.QueryRowContext(ctx, `SELECT `+cardTopUpColumns+` FROM card_top_ups WHERE `+condition, args...).Scan(
+		&topUp.ID, &topUp.AccountID, &topUp.Amount, &topUp.Currency, &topUp.Status, &topUp.PaymentIntentID, &topUp.ChargeID,
+		&topUp.TransactionID, &topUp.FailureReason, &topUp.CreatedAt, &topUp.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %v", ErrCardTopUpNotFound, args[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	topUp.denominate()
+	return topUp, nil
+}
+
+// UpdateCardTopUp stores the status, payment intent, charge, ledger entry, and failure
+// reason of a card top-up, provided it is still in the state fromStatus. Inside WithTx,
+// a concurrent update of the same top-up waits for the first one to commit or roll back.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - topUp: The top-up with its changes; its update time is filled in.
+//   - fromStatus: The state the top-up must be in.
+//
+// Returns:
+//   - error: ErrCardTopUpStatusChanged if the top-up left fromStatus, or if its charge
+//     already credited another top-up, an error object if the update fails, otherwise nil.
+func (s *PostgresStorage) UpdateCardTopUp(ctx context.Context, topUp *CardTopUp, fromStatus string) error {
+	err := s.q.QueryRowContext(ctx, `UPDATE card_top_ups SET status = $1, payment_intent_id = NULLIF($2, ''), charge_id = NULLIF($3, ''),
+	transaction_id = NULLIF($4, 0), failure_reason = $5, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $6 AND status = $7 RETURNING updated_at`, topUp.Status, topUp.PaymentIntentID, topUp.ChargeID, topUp.TransactionID, topUp.FailureReason,
+		topUp.ID, fromStatus).Scan(&topUp.UpdatedAt)
+	if err == sql.ErrNoRows || violatesConstraint(err, constraintCardTopUpChargeKey) {
+		return fmt.Errorf("%w: %d", ErrCardTopUpStatusChanged, topUp.ID)
+	}
+	return err
+}
+
+// consentColumns is the column list scanned by scanIntoConsent.
+const consentColumns = `id, account_id, client_name, scopes, expires_at, revoked_at, created_at`
+
+// CreateConsent stores a new consent and fills in its ID and creation time.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - consent: The consent, with the account, client name, scopes, and expiry set.
+//
+// Returns:
+//   - error: An error object if the insertion fails, otherwise nil.
+func (s *PostgresStorage) CreateConsent(ctx context.Context, consent *Consent) error {
+	return s.q.QueryRowContext(ctx, `INSERT INTO consents (
+	account_id,
+	client_name,
+	scopes,
+	expires_at
+	) VALUES ($1, $2, $3, $4) RETURNING id, created_at`, consent.AccountID, consent.ClientName, strings.Join(consent.Scopes, ","), consent.ExpiresAt).Scan(&consent.ID, &consent.CreatedAt)
+}
+
+// GetConsent retrieves a consent by ID, whatever its account.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - id: The ID of the consent.
+//
+// Returns:
+//   - *Consent: The consent, revoked and expired ones included.
+//   - error: ErrConsentNotFound if no consent has the ID, otherwise the error of the query.
+func (s *PostgresStorage) GetConsent(ctx context.Context, id int) (*Consent, error) {
+	consent, err := scanIntoConsent(s.q.QueryRowContext(ctx, `SELECT `+consentColumns+` FROM consents WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrConsentNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return consent, nil
+}
+
+// GetConsents retrieves the consents of an account, oldest first.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accountID: The ID of the account.
+//
+// Returns:
+//   - []*Consent: The consents, revoked and expired ones included, empty when the account has none.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetConsents(ctx context.Context, accountID int) ([]*Consent, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT `+consentColumns+` FROM consents WHERE account_id = $1 ORDER BY id`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	consents := []*Consent{}
+	for rows.Next() {
+		consent, err := scanIntoConsent(rows)
+		if err != nil {
+			return nil, err
+		}
+		consents = append(consents, consent)
+	}
+	return consents, rows.Err()
+}
+
+// RevokeConsent revokes a consent of an account, keeping the time it was first revoked.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accountID: The ID of the account that gave the consent.
+//   - id: The ID of the consent.
+//
+// Returns:
+//   - error: ErrConsentNotFound if the account has no such consent, otherwise the error of the update.
+func (s *PostgresStorage) RevokeConsent(ctx context.Context, accountID, id int) error {
+	res, err := s.q.ExecContext(ctx, `UPDATE consents SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE id = $1 AND account_id = $2`, id, accountID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrConsentNotFound, id)
+	}
+	return nil
+}
+
+// webhookSecretColumns is the column list scanned by scanIntoWebhookSecret.
+const webhookSecretColumns = `id, provider, sealed, fingerprint, created_at, expires_at`
+
+// CreateWebhookSecret stores a new webhook secret and fills in its ID and creation time.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - secret: The secret, with the provider, the sealed secret, and its fingerprint set.
+//
+// Returns:
+//   - error: An error object if the insertion fails, otherwise nil.
+func (s *PostgresStorage) CreateWebhookSecret(ctx context.Context, secret *WebhookSecret) error {
+	return s.q.QueryRowContext(ctx, `INSERT INTO webhook_secrets (
+	provider,
+	sealed,
+	fingerprint,
+	expires_at
+	) VALUES ($1, $2, $3, $4) RETURNING id, created_at`, secret.Provider, secret.Sealed, secret.Fingerprint, secret.ExpiresAt).Scan(&secret.ID, &secret.CreatedAt)
+}
+
+// GetWebhookSecrets retrieves the webhook secrets of a provider, oldest first.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - provider: The provider, empty for every provider.
+//
+// Returns:
+//   - []*WebhookSecret: The secrets, expired ones included, empty when there are none.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetWebhookSecrets(ctx context.Context, provider string) ([]*WebhookSecret, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT `+webhookSecretColumns+` FROM webhook_secrets
+	WHERE ($1 = '' OR provider = $1) ORDER BY id`, provider)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	secrets := []*WebhookSecret{}
+	for rows.Next() {
+		secret, err := scanIntoWebhookSecret(rows)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, rows.Err()
+}
+
+// ExpireWebhookSecret sets the expiry of a webhook secret, keeping an earlier one.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - id: The ID of the secret.
+//   - at: The time the secret stops verifying the webhooks.
+//
+// Returns:
+//   - *WebhookSecret: The secret, with its expiry.
+//   - error: ErrWebhookSecretNotFound if no secret has the ID, otherwise the error of the update.
+func (s *PostgresStorage) ExpireWebhookSecret(ctx context.Context, id int, at time.Time) (*WebhookSecret, error) {
+	secret, err := scanIntoWebhookSecret(s.q.QueryRowContext(ctx, `UPDATE webhook_secrets SET expires_at = LEAST(COALESCE(expires_at, $2), $2)
+	WHERE id = $1 RETURNING `+webhookSecretColumns, id, at))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrWebhookSecretNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// scanIntoWebhookSecret scans a row of webhookSecretColumns.
+func scanIntoWebhookSecret(row interface{ Scan(...any) error }) (*WebhookSecret, error) {
+	secret := &WebhookSecret{}
+	if err := row.Scan(&secret.ID, &secret.Provider, &secret.Sealed, &secret.Fingerprint, &secret.CreatedAt, &secret.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// scanIntoConsent scans a row of consentColumns, splitting the stored scope list.
+func scanIntoConsent(row interface{ Scan(...any) error }) (*Consent, error) {
+	consent := &Consent{}
+	var scopes string
+	if err := row.Scan(&consent.ID, &consent.AccountID, &consent.ClientName, &scopes, &consent.ExpiresAt, &consent.RevokedAt, &consent.CreatedAt); err != nil {
+		return nil, err
+	}
+	consent.Scopes = strings.Split(scopes, ",")
+	return consent, nil
+}
+
+// kycCheckColumns is the column list scanned by scanIntoKYCCheck.
+const kycCheckColumns = `id, account_id, provider, COALESCE(provider_check_id, ''), status, url, failure_reason, created_at, updated_at`
+
+// CreateKYCCheck stores a new KYC check and fills in its ID and timestamps.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - check: The check, with the account, provider, and status set.
+//
+// Returns:
+//   - error: An error object if the insertion fails, otherwise nil.
+func (s *PostgresStorage) CreateKYCCheck(ctx context.Context, check *KYCCheck) error {
+	return s.q.QueryRowContext(ctx, `INSERT INTO kyc_checks (
+	account_id,
+	provider,
+	provider_check_id,
+	status
+	) VALUES ($1, $2, NULLIF($3, ''), $4) RETURNING id, created_at, updated_at`, check.AccountID, check.Provider, check.ProviderCheckID, check.Status).Scan(&check.ID, &check.CreatedAt, &check.UpdatedAt)
+}
+
+// GetKYCCheck retrieves the latest KYC check of an account.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - accountID: The ID of the account.
+//
+// Returns:
+//   - *KYCCheck: The check.
+//   - error: ErrKYCCheckNotFound if the account has no check, otherwise the error of the query.
+func (s *PostgresStorage) GetKYCCheck(ctx context.Context, accountID int) (*KYCCheck, error) {
+	return s.getKYCCheck(ctx, `account_id = $1 ORDER BY id DESC LIMIT 1`, accountID)
+}
+
+// GetKYCCheckByProviderID retrieves the KYC check with an ID at the provider.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - providerCheckID: The ID of the check at the provider.
+//
+// Returns:
+//   - *KYCCheck: The check.
+//   - error: ErrKYCCheckNotFound if no check has the ID, otherwise the error of the query.
+func (s *PostgresStorage) GetKYCCheckByProviderID(ctx context.Context, providerCheckID string) (*KYCCheck, error) {
+	return s.getKYCCheck(ctx, `provider_check_id = $1`, providerCheckID)
+}
+
+// getKYCCheck retrieves the KYC check matching the condition.
+func (s *PostgresStorage) getKYCCheck(ctx context.Context, condition string, args ...interface{}) (*KYCCheck, error) {
+	check, err := scanIntoKYCCheck(s.q.QueryRowContext(ctx, `SELECT `+kycCheckColumns+` FROM kyc_checks WHERE `+condition, args...))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %v", ErrKYCCheckNotFound, args[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+// GetOpenKYCChecks retrieves the KYC checks still waiting for a result, the ones not
+// submitted to the provider first, then the least recently updated.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - limit: The maximum number of checks to return.
+//
+// Returns:
+//   - []*KYCCheck: The checks, empty when none is open.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetOpenKYCChecks(ctx context.Context, limit int) ([]*KYCCheck, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT `+kycCheckColumns+` FROM kyc_checks WHERE status IN ($1, $2)
+	ORDER BY provider_check_id IS NOT NULL, updated_at, id LIMIT $3`, KYCStatusPending, KYCStatusReview, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checks := []*KYCCheck{}
+	for rows.Next() {
+		check, err := scanIntoKYCCheck(rows)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return checks, rows.Err()
+}
+
+// UpdateKYCCheck stores the provider ID, status, URL, and failure reason of a KYC check,
+// provided it is still in the state fromStatus, and advances its update time. Inside
+// WithTx, a concurrent update of the same check waits for the first one to commit or
+// roll back.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - check: The check with its changes; its update time is filled in.
+//   - fromStatus: The state the check must be in.
+//
+// Returns:
+//   - error: ErrKYCCheckStatusChanged if the check left fromStatus, an error object if
+//     the update fails, otherwise nil.
+func (s *PostgresStorage) UpdateKYCCheck(ctx context.Context, check *KYCCheck, fromStatus string) error {
+	err := s.q.QueryRowContext(ctx, `UPDATE kyc_checks SET provider_check_id = NULLIF($1, ''), status = $2, url = $3, failure_reason = $4,
+	updated_at = CURRENT_TIMESTAMP WHERE id = $5 AND status = $6 RETURNING updated_at`, check.ProviderCheckID, check.Status, check.URL, check.FailureReason,
+		check.ID, fromStatus).Scan(&check.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %d", ErrKYCCheckStatusChanged, check.ID)
+	}
+	return err
+}
+
+// scanIntoKYCCheck scans a row of kycCheckColumns.
+func scanIntoKYCCheck(row interface{ Scan(...any) error }) (*KYCCheck, error) {
+	check := &KYCCheck{}
+	if err := row.Scan(&check.ID, &check.AccountID, &check.Provider, &check.ProviderCheckID, &check.Status, &check.URL, &check.FailureReason,
+		&check.CreatedAt, &check.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+// AddOutboxEvents stores domain events in the outbox, filling in their IDs and creation
+// times. Called inside WithTx, the events are only published if the transaction commits.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - events: The events to store.
+//
+// Returns:
+//   - error: An error object if an insertion fails, otherwise nil.
+func (s *PostgresStorage) AddOutboxEvents(ctx context.Context, events []*OutboxEvent) error {
+	for _, event := range events {
+		err := s.q.QueryRowContext(ctx, `INSERT INTO outbox (
+		type,
+		account_id,
+		payload
+		) VALUES ($1, $2, $3) RETURNING id, created_at`, event.Type, event.AccountID, string(event.Payload)).Scan(&event.ID, &event.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetUnpublishedOutboxEvents retrieves the oldest outbox events that are not published yet.
+// Inside a transaction the events are locked until it ends, and events locked by another
+// relay are skipped, so concurrent relays never publish the same batch.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - limit: The maximum number of events to return.
+//
+// Returns:
+//   - []*OutboxEvent: The events, oldest first.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetUnpublishedOutboxEvents(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT id, type, account_id, payload, created_at FROM outbox
+	WHERE published_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*OutboxEvent{}
+	for rows.Next() {
+		event := &OutboxEvent{}
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.Type, &event.AccountID, &payload, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// MarkOutboxEventsPublished records that outbox events have been published.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - ids: The IDs of the published events.
+//
+// Returns:
+//   - error: An error object if the update fails, otherwise nil.
+func (s *PostgresStorage) MarkOutboxEventsPublished(ctx context.Context, ids []int) error {
+	_, err := s.q.ExecContext(ctx, `UPDATE outbox SET published_at = CURRENT_TIMESTAMP WHERE id = ANY($1)`, ids)
+	return err
+}
+
+// GetPublishedOutboxEvents retrieves a batch of the published outbox events selected by
+// filter, in ID order, for a replay to read the events page by page.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - filter: The account and the creation time range of the events.
+//   - afterID: The ID of the last event of the previous batch, 0 for the first batch.
+//   - limit: The maximum number of events to return.
+//
+// Returns:
+//   - []*OutboxEvent: The events, oldest first.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetPublishedOutboxEvents(ctx context.Context, filter OutboxEventFilter, afterID, limit int) ([]*OutboxEvent, error) {
+	var fromArg, toArg interface{}
+	if !filter.CreatedAfter.IsZero() {
+		fromArg = filter.CreatedAfter
+	}
+	if !filter.CreatedBefore.IsZero() {
+		toArg = filter.CreatedBefore
+	}
+
+	rows, err := s.q.QueryContext(ctx, `SELECT id, type, account_id, payload, created_at, published_at FROM outbox
+	WHERE published_at IS NOT NULL AND id > $1
+	AND ($2 = 0 OR account_id = $2)
+	AND ($3::timestamp IS NULL OR created_at >= $3)
+	AND ($4::timestamp IS NULL OR created_at < $4)
+	ORDER BY id LIMIT $5`, afterID, filter.AccountID, fromArg, toArg, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*OutboxEvent{}
+	for rows.Next() {
+		event := &OutboxEvent{}
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.Type, &event.AccountID, &payload, &event.CreatedAt, &event.PublishedAt); err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// DeletePublishedOutboxEvents removes published outbox events, once the archival job has
+// stored them elsewhere.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - before: The exclusive upper bound of the creation time of the events.
+//   - throughID: The ID of the last archived event.
+//
+// Returns:
+//   - int: The number of events removed.
+//   - error: An error object if the deletion fails, otherwise nil.
+func (s *PostgresStorage) DeletePublishedOutboxEvents(ctx context.Context, before time.Time, throughID int) (int, error) {
+	res, err := s.q.ExecContext(ctx, `DELETE FROM outbox WHERE published_at IS NOT NULL AND created_at < $1 AND id <= $2`, before, throughID)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// scheduledJobColumns is the column list scanned by scanIntoScheduledJob.
+const scheduledJobColumns = `name, schedule, enabled, next_run_at, last_run_at, locked_by, locked_until, updated_at`
+
+// jobRunColumns is the column list scanned by scanIntoJobRun.
+const jobRunColumns = `id, job, trigger, status, instance, summary, error, started_at, finished_at`
+
+// RegisterScheduledJob stores a scheduled job unless one already has its name, and fills
+// the job in with the stored one.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - job: The job, with its name, default schedule, and first run set.
+//
+// Returns:
+//   - error: An error object if the insertion or the lookup fails, otherwise nil.
+func (s *PostgresStorage) RegisterScheduledJob(ctx context.Context, job *ScheduledJob) error {
+	if _, err := s.q.ExecContext(ctx, `INSERT INTO scheduled_jobs (
+	name,
+	schedule,
+	enabled,
+	next_run_at
+	) VALUES ($1, $2, $3, $4) ON CONFLICT (name) DO NOTHING`, job.Name, job.Schedule, job.Enabled, job.NextRunAt); err != nil {
+		return err
+	}
+
+	stored, err := s.GetScheduledJob(ctx, job.Name)
+	if err != nil {
+		return err
+	}
+	*job = *stored
+	return nil
+}
+
+// GetScheduledJobs retrieves every scheduled job by name.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//
+// Returns:
+//   - []*ScheduledJob: The jobs, empty when there are none.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetScheduledJobs(ctx context.Context) ([]*ScheduledJob, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT `+scheduledJobColumns+` FROM scheduled_jobs ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*ScheduledJob{}
+	for rows.Next() {
+		job, err := scanIntoScheduledJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// GetScheduledJob retrieves a scheduled job by name.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - name: The name of the job.
+//
+// Returns:
+//   - *ScheduledJob: The job.
+//   - error: ErrScheduledJobNotFound if no job has the name, otherwise the error of the query.
+func (s *PostgresStorage) GetScheduledJob(ctx context.Context, name string) (*ScheduledJob, error) {
+	job, err := scanIntoScheduledJob(s.q.QueryRowContext(ctx, `SELECT `+scheduledJobColumns+` FROM scheduled_jobs WHERE name = $1`, name))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrScheduledJobNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// UpdateScheduledJob stores the schedule, the enabled flag, and the next run of a
+// scheduled job, and fills in the time of the change.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - job: The job with its new settings.
+//
+// Returns:
+//   - error: ErrScheduledJobNotFound if no job has the name, otherwise the error of the update.
+func (s *PostgresStorage) UpdateScheduledJob(ctx context.Context, job *ScheduledJob) error {
+	err := s.q.QueryRowContext(ctx, `UPDATE scheduled_jobs SET schedule = $2, enabled = $3, next_run_at = $4, updated_at = CURRENT_TIMESTAMP
+	WHERE name = $1 RETURNING updated_at`, job.Name, job.Schedule, job.Enabled, job.NextRunAt).Scan(&job.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrScheduledJobNotFound, job.Name)
+	}
+	return err
+}
+
+// LockScheduledJob leases a scheduled job to an instance. The lease is granted when the
+// job is not locked, when the lease of its holder has run out, or when owner already
+// holds it, which extends it.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - name: The name of the job.
+//   - owner: The instance taking the lease.
+//   - now: The current time, against which the lease of the holder is checked.
+//   - until: The end of the lease.
+//
+// Returns:
+//   - *ScheduledJob: The job, locked by owner.
+//   - error: ErrScheduledJobLocked if another instance holds the lease, ErrScheduledJobNotFound
+//     if no job has the name, otherwise the error of the update.
+func (s *PostgresStorage) LockScheduledJob(ctx context.Context, name, owner string, now, until time.Time) (*ScheduledJob, error) {
+	job, err := scanIntoScheduledJob(s.q.QueryRowContext(ctx, `UPDATE scheduled_jobs SET locked_by = $2, locked_until = $4
+	WHERE name = $1 AND (locked_until IS NULL OR locked_until < $3 OR locked_by = $2)
+	RETURNING `+scheduledJobColumns, name, owner, now, until))
+	if err == sql.ErrNoRows {
+		if _, err := s.GetScheduledJob(ctx, name); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrScheduledJobLocked, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// UnlockScheduledJob releases the lease of a scheduled job after a run.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - name: The name of the job.
+//   - owner: The instance holding the lease.
+//   - lastRunAt: The start of the run, nil to keep the stored one.
+//   - nextRunAt: The next time the job is due, nil to keep the stored one.
+//
+// Returns:
+//   - error: ErrScheduledJobLocked if owner no longer holds the lease, otherwise the error of the update.
+func (s *PostgresStorage) UnlockScheduledJob(ctx context.Context, name, owner string, lastRunAt, nextRunAt *time.Time) error {
+	res, err := s.q.ExecContext(ctx, `UPDATE scheduled_jobs SET locked_by = '', locked_until = NULL, last_run_at = COALESCE($3, last_run_at), next_run_at = COALESCE($4, next_run_at)
+	WHERE name = $1 AND locked_by = $2`, name, owner, lastRunAt, nextRunAt)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrScheduledJobLocked, name)
+	}
+	return nil
+}
+
+// CreateJobRun records the start of a run of a scheduled job and fills in its ID and start time.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - run: The run, with the job, the trigger, the status, and the instance set.
+//
+// Returns:
+//   - error: An error object if the insertion fails, otherwise nil.
+func (s *PostgresStorage) CreateJobRun(ctx context.Context, run *JobRun) error {
+	return s.q.QueryRowContext(ctx, `INSERT INTO job_runs (
+	job,
+	trigger,
+	status,
+	instance
+	) VALUES ($1, $2, $3, $4) RETURNING id, started_at`, run.Job, run.Trigger, run.Status, run.Instance).Scan(&run.ID, &run.StartedAt)
+}
+
+// FinishJobRun records the outcome of a run of a scheduled job and fills in its end time.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - run: The run, with its final status, summary, and error set.
+//
+// Returns:
+//   - error: An error object if the update fails, otherwise nil.
+func (s *PostgresStorage) FinishJobRun(ctx context.Context, run *JobRun) error {
+	return s.q.QueryRowContext(ctx, `UPDATE job_runs SET status = $2, summary = $3, error = $4, finished_at = CURRENT_TIMESTAMP
+	WHERE id = $1 RETURNING finished_at`, run.ID, run.Status, run.Summary, run.Error).Scan(&run.FinishedAt)
+}
+
+// GetJobRuns retrieves the most recent runs of a scheduled job.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - job: The name of the job.
+//   - limit: The maximum number of runs to return.
+//
+// Returns:
+//   - []*JobRun: The runs, newest first.
+//   - error: An error object if an error occurs, otherwise nil.
+func (s *PostgresStorage) GetJobRuns(ctx context.Context, job string, limit int) ([]*JobRun, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT `+jobRunColumns+` FROM job_runs WHERE job = $1 ORDER BY id DESC LIMIT $2`, job, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*JobRun{}
+	for rows.Next() {
+		run, err := scanIntoJobRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// scanIntoScheduledJob scans a row of scheduledJobColumns.
+func scanIntoScheduledJob(row interface{ Scan(...any) error }) (*ScheduledJob, error) {
+	job := &ScheduledJob{}
+	if err := row.Scan(&job.Name, &job.Schedule, &job.Enabled, &job.NextRunAt, &job.LastRunAt, &job.LockedBy, &job.LockedUntil, &job.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// scanIntoJobRun scans a row of jobRunColumns.
+func scanIntoJobRun(row interface{ Scan(...any) error }) (*JobRun, error) {
+	run := &JobRun{}
+	if err := row.Scan(&run.ID, &run.Job, &run.Trigger, &run.Status, &run.Instance, &run.Summary, &run.Error, &run.StartedAt, &run.FinishedAt); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// idempotencyRecordColumns is the column list scanned by scanIntoIdempotencyRecord.
+const idempotencyRecordColumns = `key, request_hash, status, header, body, locked_until, expires_at, created_at`
+
+// ReserveIdempotencyKey stores the record of a request sent with an Idempotency-Key,
+// in progress until its LockedUntil. A record of the key that has expired, or whose
+// request is in progress past its LockedUntil, is replaced; any other is returned.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - record: The record, with its key, request hash, and times set; CreatedAt is
+//     the current time, against which the stored record is checked.
+//
+// Returns:
+//   - *IdempotencyRecord: The stored record of the key, nil when record was stored.
+//   - error: An error object if the insertion fails, otherwise nil.
+func (s *PostgresStorage) ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	// A Record Deleted Between The Two Queries Frees The Key, So Try Again Once
+	for attempt := 0; ; attempt++ {
+		var key string
+		err := s.q.QueryRowContext(ctx, `INSERT INTO idempotency_keys (
+	key,
+	request_hash,
+	locked_until,
+	expires_at,
+	created_at
+	) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status = 0, header = '{}', body = '',
+	locked_until = EXCLUDED.locked_until, expires_at = EXCLUDED.expires_at, created_at = EXCLUDED.created_at
+	WHERE idempotency_keys.expires_at <= EXCLUDED.created_at OR (idempotency_keys.status = 0 AND idempotency_keys.locked_until <= EXCLUDED.created_at)
+	RETURNING key`, record.Key, record.RequestHash, record.LockedUntil, record.ExpiresAt, record.CreatedAt).Scan(&key)
+		if err == nil {
+			return nil, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		stored, err := scanIntoIdempotencyRecord(s.q.QueryRowContext(ctx, `SELECT `+idempotencyRecordColumns+` FROM idempotency_keys WHERE key = $1`, record.Key))
+		if err == sql.ErrNoRows && attempt == 0 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return stored, nil
+	}
+}
+
+// CompleteIdempotencyKey stores the response of a request sent with an Idempotency-Key.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - record: The record ReserveIdempotencyKey stored, with the status, header, and body
+//     of the response set.
+//
+// Returns:
+//   - error: ErrIdempotencyKeyLost if another request has taken the key over, otherwise
+//     the error of the update.
+func (s *PostgresStorage) CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.q.ExecContext(ctx, `UPDATE idempotency_keys SET status = $3, header = $4, body = $5
+	WHERE key = $1 AND status = 0 AND locked_until = $2`, record.Key, record.LockedUntil, record.Status, string(header), record.Body)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes the record of a request sent with an Idempotency-Key
+// that was not answered, unless another request has taken the key over.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - record: The record ReserveIdempotencyKey stored.
+//
+// Returns:
+//   - error: An error object if the deletion fails, otherwise nil.
+func (s *PostgresStorage) ReleaseIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	_, err := s.q.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND status = 0 AND locked_until = $2`, record.Key, record.LockedUntil)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes the records of the requests sent with an
+// Idempotency-Key whose response is no longer replayed.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - now: The current time.
+//
+// Returns:
+//   - int: The number of records removed.
+//   - error: An error object if the deletion fails, otherwise nil.
+func (s *PostgresStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	res, err := s.q.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// scanIntoIdempotencyRecord scans a row of idempotencyRecordColumns.
+func scanIntoIdempotencyRecord(row interface{ Scan(...any) error }) (*IdempotencyRecord, error) {
+	record := &IdempotencyRecord{}
+	var header []byte
+	if err := row.Scan(&record.Key, &record.RequestHash, &record.Status, &header, &record.Body, &record.LockedUntil, &record.ExpiresAt, &record.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(header, &record.Header); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// ImportRecords inserts a batch of imported accounts and transactions in a single
+// database transaction. The batch is first inserted with multi-row inserts; if any
+// record fails, it is retried one record at a time, each inside its own savepoint, so
+// a record that violates the data (e.g. a transaction for an unknown account) is
+// rolled back and reported without aborting the rest of the batch.
+//
+// Parameters:
+//   - ctx: The context of the operation; cancelling it aborts the database work.
+//   - records: The validated records to insert, in order.
+//
+// Returns:
+//   - []error: One entry per record, nil when the record was inserted.
+//   - error: An error object if the batch transaction itself fails, otherwise nil.
+func (s *PostgresStorage) ImportRecords(ctx context.Context, records []*ImportRecord) ([]error, error) {
+	errs := make([]error, len(records))
+
+	err := s.withTx(ctx, func(tx *PostgresStorage) error {
+		// Insert The Whole Batch At Once When All Records Are Valid
+		if _, err := tx.q.ExecContext(ctx, `SAVEPOINT import_batch`); err != nil {
+			return err
+		}
+		if err := tx.importBatch(ctx, records); err == nil {
+			_, err := tx.q.ExecContext(ctx, `RELEASE SAVEPOINT import_batch`)
+			return err
+		}
+		if _, err := tx.q.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_batch`); err != nil {
+			return err
+		}
+
+		// Otherwise Insert One Record At A Time To Report The Failing Ones
+		for i, rec := range records {
+			if _, err := tx.q.ExecContext(ctx, `SAVEPOINT import_record`); err != nil {
+				return err
+			}
+
+			errs[i] = importRecord(ctx, tx.q, rec)
+
+			if errs[i] != nil {
+				if _, err := tx.q.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_record`); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if _, err := tx.q.ExecContext(ctx, `RELEASE SAVEPOINT import_record`); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+// importBatch inserts a batch of import records with multi-row inserts: first all the
+// accounts, then all the transactions, whose account numbers are resolved in one query.
+//
+// Parameters:
+//   - ctx: The context of the operation.
+//   - records: The records to insert.
+//
+// Returns:
+//   - error: An error if any record cannot be stored; the caller then rolls the batch back.
+func (tx *PostgresStorage) importBatch(ctx context.Context, records []*ImportRecord) error {
+	var (
+		accounts  []*Account
+		txRecords []*ImportRecord
+		numbers   []int64
+	)
+	for _, rec := range records {
+		if rec.Kind == ImportKindAccount {
+			createdAt := rec.CreatedAt
+			if createdAt.IsZero() {
+				createdAt = time.Now().UTC()
+			}
+			accounts = append(accounts, &Account{FirstName: rec.FirstName, LastName: rec.LastName, Number: rec.Number, Balance: money.New(rec.Balance.Amount, rec.Currency), Currency: rec.Currency, CreatedAt: createdAt})
+			continue
+		}
+
+		txRecords = append(txRecords, rec)
+		numbers = append(numbers, rec.AccountNumber)
+		if rec.CounterpartyNumber != 0 {
+			numbers = append(numbers, rec.CounterpartyNumber)
+		}
+	}
+
+	if err := tx.CreateAccounts(ctx, accounts); err != nil {
+		return err
+	}
+	if len(txRecords) == 0 {
+		return nil
+	}
+
+	// Resolve The Account Numbers Of All Transactions At Once
+	rows, err := tx.q.QueryContext(ctx, `SELECT number, id, currency FROM accounts WHERE number = ANY($1) AND deleted_at IS NULL`, numbers)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ids := map[int64]int{}
+	currencies := map[int64]string{}
+	for rows.Next() {
+		var (
+			number   int64
+			id       int
+			currency string
+		)
+		if err := rows.Scan(&number, &id, &currency); err != nil {
+			return err
+		}
+		ids[number], currencies[number] = id, currency
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	txns := make([]*Transaction, 0, len(txRecords))
+	for _, rec := range txRecords {
+		accountID, ok := ids[rec.AccountNumber]
+		if !ok {
+			return fmt.Errorf("account with number %d not found", rec.AccountNumber)
+		}
+		counterpartyID, ok := ids[rec.CounterpartyNumber]
+		if !ok && rec.CounterpartyNumber != 0 {
+			return fmt.Errorf("counterparty with number %d not found", rec.CounterpartyNumber)
+		}
+		currency, err := rec.entryCurrency(currencies[rec.AccountNumber])
+		if err != nil {
+			return err
+		}
+
+		txns = append(txns, &Transaction{
+			AccountID:      accountID,
+			CounterpartyID: counterpartyID,
+			Type:           rec.Type,
+			Amount:         money.New(rec.Amount.Amount, currency),
+			BalanceAfter:   money.New(rec.BalanceAfter.Amount, currency),
+			Currency:       currency,
+			CreatedAt:      rec.CreatedAt,
+			Category:       rec.Category,
+		})
+	}
+
+	return tx.CreateTransactions(ctx, txns)
+}
+
+// importRecord inserts a single imported record using the given transaction.
+func importRecord(ctx context.Context, tx dbtx, rec *ImportRecord) error {
+	createdAt := rec.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+
+	if rec.Kind == ImportKindAccount {
+		_, err := tx.ExecContext(ctx, `INSERT INTO accounts (
+		first_name,
+		last_name,
+		number,
+		balance,
+		currency,
+		create_at
+		) VALUES ($1, $2, $3, $4, $5, $6)`, rec.FirstName, rec.LastName, rec.Number, rec.Balance, rec.Currency, createdAt)
+		return constraintError(err)
+	}
+
+	var (
+		accountID       int
+		accountCurrency string
+	)
+	err := tx.QueryRowContext(ctx, `SELECT id, currency FROM accounts WHERE number = $1 AND deleted_at IS NULL`, rec.AccountNumber).Scan(&accountID, &accountCurrency)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("account with number %d not found", rec.AccountNumber)
+	}
+	if err != nil {
+		return err
+	}
+	currency, err := rec.entryCurrency(accountCurrency)
+	if err != nil {
+		return err
+	}
+
+	var counterpartyID int
+	if rec.CounterpartyNumber != 0 {
+		err := tx.QueryRowContext(ctx, `SELECT id FROM accounts WHERE number = $1 AND deleted_at IS NULL`, rec.CounterpartyNumber).Scan(&counterpartyID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("counterparty with number %d not found", rec.CounterpartyNumber)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO transactions (
+	account_id,
+	counterparty_id,
+	type,
+	amount,
+	balance_after,
+	currency,
+	created_at,
+	category
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, accountID, counterpartyID, rec.Type, rec.Amount, rec.BalanceAfter, currency, createdAt, rec.Category)
+	return err
+}
+
+// scanIntoAccount scans the current row of the provided SQL rows object, selected with
+// accountColumns, into an Account struct. It returns a pointer to the Account struct and an error if the scanning process fails.
+//
+// Parameters:
+//   - row: A pointer to the SQL rows object representing the current row.
+//
+// Returns:
+//   - *Account: A pointer to the Account struct containing the scanned data.
+//   - error: An error object if the scanning process fails, otherwise nil.
+func scanIntoAccount(row *sql.Rows) (*Account, error) {
+	account := &Account{}
+	var externalID sql.NullString
+	if err := row.Scan(&account.ID, &account.FirstName, &account.LastName, &account.Number, &account.Balance, &account.CreatedAt, &account.Version, &account.Frozen, &account.TransferLimit, &account.UpdatedAt, &account.DeletedAt, &account.KYCStatus, &account.DormantAt, &account.Currency, &externalID, &account.EncryptedPassword); err != nil {
+		return nil, err
+	}
+	account.ExternalID = externalID.String
+	account.denominate()
+	return account, nil
+
+}
+
+// accountFromModel converts an account read by the generated queries.
+func accountFromModel(m db.Account) *Account {
+	account := &Account{
+		ID:                int(m.ID),
+		FirstName:         m.FirstName,
+		LastName:          m.LastName,
+		Number:            m.Number,
+		Balance:           money.Money{Amount: m.Balance},
+		CreatedAt:         m.CreateAt,
+		Version:           int(m.Version),
+		Frozen:            m.Frozen,
+		TransferLimit:     money.Money{Amount: m.TransferLimit},
+		UpdatedAt:         m.UpdatedAt,
+		KYCStatus:         m.KycStatus,
+		Currency:          m.Currency,
+		ExternalID:        m.ExternalID.String,
+		EncryptedPassword: m.EncryptedPassword,
+	}
+	if m.DeletedAt.Valid {
+		account.DeletedAt = &m.DeletedAt.Time
+	}
+	if m.DormantAt.Valid {
+		account.DormantAt = &m.DormantAt.Time
+	}
+	account.denominate()
+	return account
+}
+
+// violatesConstraint reports whether err is a Postgres error raised by the named constraint.
+func violatesConstraint(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.ConstraintName == constraint
+}
+
+// constraintError translates the violations of the schema constraints into errors the
+// handlers can report, and returns every other error unchanged.
+//
+// Parameters:
+//   - err: The error returned by a query.
+//
+// Returns:
+//   - error: ErrAccountNumberTaken, ErrExternalIDTaken, ErrInsufficientFunds for a negative balance, a
+//     description of the violated constraint, money.ErrOverflow for a number out of
+//     range, or err itself.
+func constraintError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch {
+	case pgErr.ConstraintName == constraintAccountNumberKey:
+		return ErrAccountNumberTaken
+	case pgErr.ConstraintName == constraintAccountExternalIDKey:
+		return ErrExternalIDTaken
+	case pgErr.ConstraintName == constraintAccountBalanceNonNegative:
+		return fmt.Errorf("%w: balance must not be negative", ErrInsufficientFunds)
+	case pgErr.Code == "23502":
+		// A NOT NULL Column Was Left Empty
+		return fmt.Errorf("%s is required", pgErr.ColumnName)
+	case pgErr.Code == "22003":
+		// A BIGINT Balance Went Out Of Range
+		return fmt.Errorf("%w: %s", money.ErrOverflow, pgErr.Message)
+	}
+
+	return err
+}
+
+// scanIntoTransaction scans the current row, selected with transactionColumns, into a Transaction struct.
+//
+// Parameters:
+//   - row: A pointer to the SQL rows object representing the current row.
+//
+// Returns:
+//   - *Transaction: A pointer to the Transaction struct containing the scanned data.
+//   - error: An error object if the scanning process fails, otherwise nil.
+func scanIntoTransaction(row *sql.Rows) (*Transaction, error) {
+	t := &Transaction{}
+	if err := row.Scan(&t.ID, &t.AccountID, &t.CounterpartyID, &t.Type, &t.Amount, &t.BalanceAfter, &t.CreatedAt, &t.Category, &t.Reference, &t.Currency); err != nil {
+		return nil, err
+	}
+	t.denominate()
+	return t, nil
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// explainPlan is a node of the JSON plan of EXPLAIN (FORMAT JSON).
+type explainPlan struct {
+	NodeType     string        `json:"Node Type"`
+	RelationName string        `json:"Relation Name"`
+	IndexName    string        `json:"Index Name"`
+	Plans        []explainPlan `json:"Plans"`
+}
+
+// seqScans returns the tables the plan scans sequentially.
+func (p *explainPlan) seqScans() []string {
+	var tables []string
+	if p.NodeType == "Seq Scan" {
+		tables = append(tables, p.RelationName)
+	}
+	for i := range p.Plans {
+		tables = append(tables, p.Plans[i].seqScans()...)
+	}
+	return tables
+}
+
+// TestQueryPlansUseIndexes checks that the lookups of the accounts and the ledger run on
+// the indexes of migrations/0011_add_query_indexes.up.sql. It needs a scratch Postgres
+// database in TEST_DB_URL, which it migrates, and is skipped without one; "make explain"
+// runs it against DB_URL. Sequential scans are disabled for the plans, so that Postgres
+// picks an index whenever one serves the query, however few rows the tables hold.
+func TestQueryPlansUseIndexes(t *testing.T) {
+	store := newTestPostgresStorage(t)
+
+	queries := []struct {
+		name  string
+		query string
+		args  []interface{}
+	}{
+		{"accounts by number", `SELECT ` + accountColumns + ` FROM accounts WHERE number = $1 AND deleted_at IS NULL`, []interface{}{int64(1234)}},
+		{"accounts by last name", `SELECT ` + accountColumns + ` FROM accounts WHERE last_name = $1 AND deleted_at IS NULL`, []interface{}{"Doe"}},
+		{"transactions of account", `SELECT ` + transactionColumns + ` FROM transactions WHERE account_id = $1
+		ORDER BY created_at DESC, id DESC LIMIT $2`, []interface{}{1, 20}},
+		{"balance at a point", `SELECT balance_after FROM transactions WHERE account_id = $1 AND created_at < $2
+		ORDER BY created_at DESC, id DESC LIMIT 1`, []interface{}{1, time.Now().UTC()}},
+	}
+
+	ctx := context.Background()
+	for _, tt := range queries {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := store.db.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatalf("starting a transaction: %v", err)
+			}
+			defer tx.Rollback()
+
+			if _, err := tx.ExecContext(ctx, `SET LOCAL enable_seqscan = off`); err != nil {
+				t.Fatalf("disabling the sequential scans: %v", err)
+			}
+
+			var output []byte
+			if err := tx.QueryRowContext(ctx, `EXPLAIN (FORMAT JSON) `+tt.query, tt.args...).Scan(&output); err != nil {
+				t.Fatalf("explaining the query: %v", err)
+			}
+
+			var plans []struct {
+				Plan explainPlan `json:"Plan"`
+			}
+			if err := json.Unmarshal(output, &plans); err != nil || len(plans) != 1 {
+				t.Fatalf("decoding the plan %s: %v", output, err)
+			}
+			if tables := plans[0].Plan.seqScans(); len(tables) > 0 {
+				t.Errorf("the plan scans %v sequentially: %s", tables, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/moabdelazem/gobank/money"
+)
+
+// newTestPostgresStorage connects to the scratch Postgres database in TEST_DB_URL and
+// migrates it, or skips the test without one.
+func newTestPostgresStorage(t *testing.T) *PostgresStorage {
+	t.Helper()
+
+	url := os.Getenv("TEST_DB_URL")
+	if url == "" {
+		t.Skip("TEST_DB_URL is not set")
+	}
+
+	cfg := defaultConfig().Database
+	cfg.URL = url
+	store, err := NewPostgresStorage(&cfg)
+	if err != nil {
+		t.Fatalf("connecting to the database: %v", err)
+	}
+	t.Cleanup(store.pool.Close)
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("migrating the database: %v", err)
+	}
+	return store
+}
+
+// createTestAccount opens an account in currency holding balance minor units.
+func createTestAccount(t *testing.T, store *PostgresStorage, currency string, balance int64) *Account {
+	t.Helper()
+
+	ctx := context.Background()
+	acc := NewAccount("Test", "Holder", currency)
+	if err := store.CreateAccount(ctx, acc); err != nil {
+		t.Fatalf("creating an account: %v", err)
+	}
+	if balance > 0 {
+		deposit := &Transaction{AccountID: acc.ID, Type: TransactionTypeDeposit, Amount: money.New(balance, currency)}
+		if err := store.DepositFunds(ctx, deposit); err != nil {
+			t.Fatalf("depositing on account %d: %v", acc.ID, err)
+		}
+	}
+	return acc
+}
+
+// balanceOf returns the balance of an account, in minor units.
+func balanceOf(t *testing.T, store *PostgresStorage, id int) int64 {
+	t.Helper()
+
+	acc, err := store.GetAccountById(context.Background(), id)
+	if err != nil {
+		t.Fatalf("reading account %d: %v", id, err)
+	}
+	return acc.Balance.Amount
+}
+
+func TestPostgresTransferFunds(t *testing.T) {
+	store := newTestPostgresStorage(t)
+	from := createTestAccount(t, store, "USD", 1000)
+	to := createTestAccount(t, store, "USD", 500)
+
+	txns, err := store.TransferFunds(context.Background(), from.ID, to.ID, money.New(300, "USD"))
+	if err != nil {
+		t.Fatalf("TransferFunds: %v", err)
+	}
+
+	if len(txns) != 2 {
+		t.Fatalf("TransferFunds recorded %d entries, want 2", len(txns))
+	}
+	debit, credit := txns[0], txns[1]
+	if debit.AccountID != from.ID || debit.Amount != money.New(-300, "USD") || debit.BalanceAfter != money.New(700, "USD") {
+		t.Errorf("debit = %+v, want -300 leaving 700 on account %d", debit, from.ID)
+	}
+	if credit.AccountID != to.ID || credit.Amount != money.New(300, "USD") || credit.BalanceAfter != money.New(800, "USD") {
+		t.Errorf("credit = %+v, want 300 making 800 on account %d", credit, to.ID)
+	}
+
+	if got := balanceOf(t, store, from.ID); got != 700 {
+		t.Errorf("balance of the source = %d, want 700", got)
+	}
+	if got := balanceOf(t, store, to.ID); got != 800 {
+		t.Errorf("balance of the destination = %d, want 800", got)
+	}
+}
+
+func TestPostgresTransferFundsRefused(t *testing.T) {
+	store := newTestPostgresStorage(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// setup opens the accounts and returns them with the amount to move.
+		setup func(t *testing.T) (from, to *Account, amount money.Money)
+		err   error
+	}{
+		{"frozen source", func(t *testing.T) (*Account, *Account, money.Money) {
+			from, to := createTestAccount(t, store, "USD", 1000), createTestAccount(t, store, "USD", 0)
+			if err := store.SetAccountFrozen(ctx, from.ID, true); err != nil {
+				t.Fatalf("freezing the source: %v", err)
+			}
+			return from, to, money.New(100, "USD")
+		}, nil},
+		{"frozen destination", func(t *testing.T) (*Account, *Account, money.Money) {
+			from, to := createTestAccount(t, store, "USD", 1000), createTestAccount(t, store, "USD", 0)
+			if err := store.SetAccountFrozen(ctx, to.ID, true); err != nil {
+				t.Fatalf("freezing the destination: %v", err)
+			}
+			return from, to, money.New(100, "USD")
+		}, nil},
+		{"destination in another currency", func(t *testing.T) (*Account, *Account, money.Money) {
+			return createTestAccount(t, store, "USD", 1000), createTestAccount(t, store, "EUR", 0), money.New(100, "USD")
+		}, money.ErrCurrencyMismatch},
+		{"amount in another currency", func(t *testing.T) (*Account, *Account, money.Money) {
+			return createTestAccount(t, store, "USD", 1000), createTestAccount(t, store, "USD", 0), money.New(100, "EUR")
+		}, money.ErrCurrencyMismatch},
+		{"above the transfer limit", func(t *testing.T) (*Account, *Account, money.Money) {
+			from, to := createTestAccount(t, store, "USD", 1000), createTestAccount(t, store, "USD", 0)
+			if err := store.SetTransferLimit(ctx, from.ID, money.New(50, "USD")); err != nil {
+				t.Fatalf("limiting the source: %v", err)
+			}
+			return from, to, money.New(100, "USD")
+		}, nil},
+		{"deleted destination", func(t *testing.T) (*Account, *Account, money.Money) {
+			from, to := createTestAccount(t, store, "USD", 1000), createTestAccount(t, store, "USD", 0)
+			if err := store.DeleteAccount(ctx, to.ID); err != nil {
+				t.Fatalf("deleting the destination: %v", err)
+			}
+			return from, to, money.New(100, "USD")
+		}, ErrAccountNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, amount := tt.setup(t)
+
+			_, err := store.TransferFunds(ctx, from.ID, to.ID, amount)
+			if err == nil {
+				t.Fatalf("TransferFunds succeeded, want it refused")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("TransferFunds error = %v, want %v", err, tt.err)
+			}
+
+			if got := balanceOf(t, store, from.ID); got != 1000 {
+				t.Errorf("balance of the source = %d, want 1000", got)
+			}
+			if tt.err != ErrAccountNotFound {
+				if got := balanceOf(t, store, to.ID); got != 0 {
+					t.Errorf("balance of the destination = %d, want 0", got)
+				}
+			}
+		})
+	}
+}
+
+func TestPostgresTransferFundsRefusesOverdraft(t *testing.T) {
+	store := newTestPostgresStorage(t)
+	from := createTestAccount(t, store, "USD", 100)
+	to := createTestAccount(t, store, "USD", 50)
+
+	txns, err := store.TransferFunds(context.Background(), from.ID, to.ID, money.New(101, "USD"))
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("TransferFunds past the balance error = %v, want %v", err, ErrInsufficientFunds)
+	}
+	if txns != nil {
+		t.Errorf("TransferFunds past the balance recorded %v, want no entries", txns)
+	}
+
+	if got := balanceOf(t, store, from.ID); got != 100 {
+		t.Errorf("balance of the source = %d, want 100", got)
+	}
+	if got := balanceOf(t, store, to.ID); got != 50 {
+		t.Errorf("balance of the destination = %d, want 50", got)
+	}
+}
+
+func TestPostgresBalanceConstraintIsInsufficientFunds(t *testing.T) {
+	store := newTestPostgresStorage(t)
+	ctx := context.Background()
+	acc := createTestAccount(t, store, "USD", 100)
+	acc, err := store.GetAccountById(ctx, acc.ID)
+	if err != nil {
+		t.Fatalf("reading the account: %v", err)
+	}
+
+	// Debit Past The Balance Behind The Back Of The Check Of TransferFunds
+	var balance int64
+	err = store.db.QueryRowContext(ctx, sqlDebitAccount, 101, acc.ID, acc.Version).Scan(&balance)
+	if !violatesConstraint(err, constraintAccountBalanceNonNegative) {
+		t.Fatalf("debit past the balance error = %v, want a violation of %s", err, constraintAccountBalanceNonNegative)
+	}
+	if got := constraintError(err); !errors.Is(got, ErrInsufficientFunds) {
+		t.Errorf("constraintError(%v) = %v, want %v", err, got, ErrInsufficientFunds)
+	}
+	if got := balanceOf(t, store, acc.ID); got != 100 {
+		t.Errorf("balance = %d, want 100", got)
+	}
+}
+
+func TestPostgresOppositeTransfersDoNotDeadlock(t *testing.T) {
+	store := newTestPostgresStorage(t)
+	a := createTestAccount(t, store, "USD", 10000)
+	b := createTestAccount(t, store, "USD", 10000)
+
+	const transfers = 25
+	start := make(chan struct{})
+	errs := make(chan error, 2*transfers)
+	var wg sync.WaitGroup
+	for _, pair := range [][2]int{{a.ID, b.ID}, {b.ID, a.ID}} {
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			<-start
+			for i := 0; i < transfers; i++ {
+				if _, err := store.TransferFunds(context.Background(), from, to, money.New(10, "USD")); err != nil {
+					errs <- err
+				}
+			}
+		}(pair[0], pair[1])
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("TransferFunds: %v", err)
+	}
+	if got := balanceOf(t, store, a.ID); got != 10000 {
+		t.Errorf("balance of account %d = %d, want 10000", a.ID, got)
+	}
+	if got := balanceOf(t, store, b.ID); got != 10000 {
+		t.Errorf("balance of account %d = %d, want 10000", b.ID, got)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default Stripe Settings
+const (
+	defaultStripeAPIURL           = "https://api.stripe.com"
+	defaultStripeTimeout          = 10 * time.Second
+	defaultStripeWebhookTolerance = 5 * time.Minute
+	defaultStripeCurrency         = "usd"
+	// defaultStripeMinAmount is the smallest charge Stripe accepts in US dollars, 50 cents.
+	defaultStripeMinAmount = 50
+	defaultStripeMaxAmount = 1_000_000
+	// stripeSignatureHeader carries the time and the signatures of an event, as
+	// t=<unix time>,v1=<hex HMAC-SHA256>, the timestamped scheme of webhooks.go.
+	stripeSignatureHeader = "Stripe-Signature"
+)
+
+// StripePaymentIntent is the part of a Stripe payment intent the top-ups use.
+type StripePaymentIntent struct {
+	ID       string `json:"id"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Status   string `json:"status"`
+	// ClientSecret lets the app of the account holder confirm the payment with Stripe.js.
+	ClientSecret string `json:"client_secret"`
+	// LatestCharge is the ID of the charge that paid the intent, once it succeeded.
+	LatestCharge     string            `json:"latest_charge"`
+	Metadata         map[string]string `json:"metadata"`
+	LastPaymentError *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"last_payment_error"`
+	CancellationReason string `json:"cancellation_reason"`
+}
+
+// StripeClient creates the payment intents of the card top-ups through the REST API of Stripe.
+type StripeClient struct {
+	cfg    StripeConfig
+	client *http.Client
+}
+
+// NewStripeClient creates a client authenticated with the secret key of cfg.
+func NewStripeClient(cfg StripeConfig) *StripeClient {
+	return &StripeClient{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// CreatePaymentIntent creates the payment intent paying a card top-up. The ID of the
+// top-up is the idempotency key, so a retried request returns the same intent.
+//
+// Parameters:
+//   - ctx: The context of the request to Stripe.
+//   - topUp: The stored top-up, with its ID, account, amount, and currency.
+//
+// Returns:
+//   - *StripePaymentIntent: The intent, with the client secret to confirm it with.
+//   - error: An error if Stripe cannot be reached or refuses the intent.
+func (c *StripeClient) CreatePaymentIntent(ctx context.Context, topUp *CardTopUp) (*StripePaymentIntent, error) {
+	form := url.Values{
+		"amount":                             {strconv.FormatInt(topUp.Amount.Amount, 10)},
+		"currency":                           {topUp.Currency},
+		"automatic_payment_methods[enabled]": {"true"},
+		"description":                        {fmt.Sprintf("GoBank top-up %d of account %d", topUp.ID, topUp.AccountID)},
+		"metadata[account_id]":               {strconv.Itoa(topUp.AccountID)},
+		"metadata[top_up_id]":                {strconv.Itoa(topUp.ID)},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.APIURL+"/v1/payment_intents", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.cfg.SecretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Idempotency-Key", fmt.Sprintf("gobank-card-top-up-%d", topUp.ID))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body := io.LimitReader(resp.Body, 1<<20)
+	if resp.StatusCode/100 != 2 {
+		var apiErr struct {
+			Error struct {
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(body).Decode(&apiErr)
+		return nil, fmt.Errorf("stripe refused the payment intent with status %d: %s (%s %s)", resp.StatusCode, apiErr.Error.Message, apiErr.Error.Type, apiErr.Error.Code)
+	}
+
+	intent := &StripePaymentIntent{}
+	if err := json.NewDecoder(body).Decode(intent); err != nil {
+		return nil, fmt.Errorf("decoding the payment intent: %w", err)
+	}
+	if intent.ID == "" || intent.ClientSecret == "" {
+		return nil, fmt.Errorf("stripe sent a payment intent without an ID or client secret")
+	}
+	return intent, nil
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/moabdelazem/gobank/money"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Card Top-Up Statuses
+const (
+	// CardTopUpStatusPending is a top-up waiting for its card payment, also after a
+	// declined card, which the account holder may retry with the same payment intent.
+	CardTopUpStatusPending   = "pending"
+	CardTopUpStatusSucceeded = "succeeded"
+	CardTopUpStatusCanceled  = "canceled"
+)
+
+// Stripe Event Types Handled By The Webhook
+const (
+	StripeEventPaymentSucceeded = "payment_intent.succeeded"
+	StripeEventPaymentFailed    = "payment_intent.payment_failed"
+	StripeEventPaymentCanceled  = "payment_intent.canceled"
+)
+
+// stripeWebhookMaxSize bounds the events of the webhook, which carry one payment intent.
+const stripeWebhookMaxSize = 64 << 10
+
+// stripeWebhooks counts the events of the Stripe webhook, registered by newMetricsRegistry.
+var stripeWebhooks = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace, Subsystem: "stripe", Name: "webhooks_total",
+	Help: "Number of Stripe webhook events received, by type and result.",
+}, []string{"type", "result"})
+
+// CardTopUp is a payment by card funding an account, paid through a Stripe payment
+// intent and credited once, by the webhook of its successful charge.
+type CardTopUp struct {
+	ID        int         `json:"id"`
+	AccountID int         `json:"account_id"`
+	Amount    money.Money `json:"amount"`
+	// Currency is the lowercase currency code of Stripe, such as usd.
+	Currency string `json:"currency"`
+	Status   string `json:"status"`
+	// PaymentIntentID is the Stripe payment intent paying the top-up, pi_....
+	PaymentIntentID string `json:"payment_intent_id,omitempty"`
+	// ClientSecret confirms the payment intent with Stripe.js; it is only returned on
+	// creation and never stored.
+	ClientSecret string `json:"client_secret,omitempty" bson:"-"`
+	// ChargeID is the Stripe charge that paid the top-up, the reference of its ledger entry.
+	ChargeID      string `json:"charge_id,omitempty"`
+	TransactionID int    `json:"transaction_id,omitempty"`
+	// FailureReason explains the last declined payment, or the cancellation.
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// denominate sets the currency of the amount of the top-up to that of the top-up, once
+// it is read from storage.
+func (t *CardTopUp) denominate() {
+	t.Amount.Currency = strings.ToUpper(t.Currency)
+}
+
+// CardTopUpRequest is the body of POST /api/v1/account/{id}/top-ups.
+type CardTopUpRequest struct {
+	// Amount is in the smallest unit of the currency of the cards, such as cents, which
+	// the handler sets.
+	Amount money.Money `json:"amount"`
+}
+
+// Validate checks the fields of a top-up request; the configured bounds of the amount
+// are checked by handleCreateCardTopUp.
+func (req *CardTopUpRequest) Validate() []FieldError {
+	if !req.Amount.IsPositive() {
+		return []FieldError{{Field: "amount", Code: CodeRequired, Message: "amount must be positive"}}
+	}
+	return nil
+}
+
+// StripeEvent is a webhook event of Stripe; the payment_intent events carry the intent.
+type StripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object StripePaymentIntent `json:"object"`
+	} `json:"data"`
+}
+
+// StripeWebhookResponse is the response of POST /webhooks/stripe.
+type StripeWebhookResponse struct {
+	Result  string `json:"result"`
+	TopUpID int    `json:"top_up_id,omitempty"`
+	Status  string `json:"status,omitempty"`
+}
+
+// handleCreateCardTopUp starts a card top-up: it stores the pending top-up, creates the
+// Stripe payment intent paying it, and returns the client secret the app confirms the
+// payment with. The account is credited later, by the webhook.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the amount.
+//
+// Returns:
+//   - error: A validation error for an amount out of bounds, a 403 for a frozen account,
+//     a 422 for an account in another currency than the cards, or a 503 if Stripe cannot
+//     create the payment intent.
+func (as *APIServer) handleCreateCardTopUp(w http.ResponseWriter, r *http.Request) error {
+	topUpReq := new(CardTopUpRequest)
+	if err := bindJSON(w, r, topUpReq); err != nil {
+		return err
+	}
+
+	cfg := as.config.Stripe
+	if topUpReq.Amount.Amount < cfg.MinAmount || topUpReq.Amount.Amount > cfg.MaxAmount {
+		return newValidationError([]FieldError{{Field: "amount", Code: CodeInvalid, Message: fmt.Sprintf("amount must be between %d and %d", cfg.MinAmount, cfg.MaxAmount)}})
+	}
+
+	account, err := as.store.GetAccountById(r.Context(), getId(w, r))
+	if err != nil {
+		return err
+	}
+	if account.Frozen {
+		return NewTypedError(http.StatusForbidden, "account_frozen", "the account is frozen")
+	}
+	if strings.ToUpper(cfg.Currency) != account.Currency {
+		return NewTypedError(http.StatusUnprocessableEntity, "currency_mismatch", fmt.Sprintf("cards are charged in %s, the account holds %s", strings.ToUpper(cfg.Currency), account.Currency))
+	}
+
+	topUpReq.Amount.Currency = account.Currency
+	topUp := &CardTopUp{AccountID: account.ID, Amount: topUpReq.Amount, Currency: cfg.Currency, Status: CardTopUpStatusPending}
+	if err := as.store.CreateCardTopUp(r.Context(), topUp); err != nil {
+		return err
+	}
+
+	// The Request Is Not Retried; The Client Retries With A New Top-Up
+	ctx := context.WithoutCancel(r.Context())
+	intent, err := as.stripe.CreatePaymentIntent(ctx, topUp)
+	if err != nil {
+		slog.ErrorContext(ctx, "Stripe Payment Intent Could Not Be Created", "top_up_id", topUp.ID, "error", err)
+		topUp.Status, topUp.FailureReason = CardTopUpStatusCanceled, "the payment could not be started"
+		if err := as.store.UpdateCardTopUp(ctx, topUp, CardTopUpStatusPending); err != nil {
+			slog.ErrorContext(ctx, "Failed Card Top-Up Could Not Be Canceled", "top_up_id", topUp.ID, "error", err)
+		}
+		return NewTypedError(http.StatusServiceUnavailable, "card_payments_unavailable", "card payments are unavailable, retry later")
+	}
+
+	topUp.PaymentIntentID = intent.ID
+	if err := as.store.UpdateCardTopUp(ctx, topUp, CardTopUpStatusPending); err != nil {
+		return err
+	}
+
+	topUp.ClientSecret = intent.ClientSecret
+	return WriteResponse(w, r, http.StatusCreated, topUp)
+}
+
+// handleGetCardTopUp returns a card top-up of an account, for the app to follow it
+// after confirming the payment.
+func (as *APIServer) handleGetCardTopUp(w http.ResponseWriter, r *http.Request) error {
+	topUpID, err := strconv.Atoi(mux.Vars(r)["top_up"])
+	if err != nil {
+		return NewTypedError(http.StatusBadRequest, "bad_request", "invalid top-up ID")
+	}
+
+	topUp, err := as.store.GetCardTopUp(r.Context(), getId(w, r), topUpID)
+	if err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusOK, topUp)
+}
+
+// handleStripeWebhook applies a payment_intent event of Stripe to the top-up of its
+// intent: payment_intent.succeeded credits the account with a ledger entry referencing
+// the charge and completes the top-up, payment_intent.payment_failed records why the
+// card was declined, and payment_intent.canceled cancels the top-up. Stripe delivers an
+// event at least once, so the credit and the transition are stored together, and an
+// event delivered again is acknowledged as a duplicate without effect.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the event, verified by withWebhookSignature.
+//
+// Returns:
+//   - error: A 404 if no top-up has the payment
+//     intent of a GoBank top-up, a 409 if the intent does not match its top-up, or the
+//     error of the store, for Stripe to deliver the event again.
+func (as *APIServer) handleStripeWebhook(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unknown Fields Are Allowed, Stripe Adds Some With Every API Version
+	event := new(StripeEvent)
+	if err := json.Unmarshal(body, event); err != nil {
+		return NewTypedError(http.StatusBadRequest, "invalid_json", fmt.Sprintf("invalid request body: %s", err))
+	}
+
+	intent := &event.Data.Object
+	switch event.Type {
+	case StripeEventPaymentSucceeded, StripeEventPaymentFailed, StripeEventPaymentCanceled:
+	default:
+		stripeWebhooks.WithLabelValues(event.Type, GatewayCallbackIgnored).Inc()
+		return WriteResponse(w, r, http.StatusOK, StripeWebhookResponse{Result: GatewayCallbackIgnored})
+	}
+	if intent.ID == "" {
+		return newValidationError([]FieldError{{Field: "data.object.id", Code: CodeRequired, Message: "the event carries no payment intent"}})
+	}
+
+	topUp, err := as.store.GetCardTopUpByPaymentIntent(r.Context(), intent.ID)
+	if errors.Is(err, ErrCardTopUpNotFound) && intent.Metadata["top_up_id"] == "" {
+		// A Payment Of The Stripe Account That Is Not A Top-Up
+		stripeWebhooks.WithLabelValues(event.Type, GatewayCallbackIgnored).Inc()
+		return WriteResponse(w, r, http.StatusOK, StripeWebhookResponse{Result: GatewayCallbackIgnored})
+	}
+	if err != nil {
+		return err
+	}
+	if intent.Amount != topUp.Amount.Amount || intent.Currency != topUp.Currency {
+		slog.ErrorContext(r.Context(), "Stripe Payment Intent Does Not Match Its Top-Up", "top_up_id", topUp.ID, "payment_intent_id", intent.ID,
+			"amount", intent.Amount, "currency", intent.Currency)
+		as.alertReconciliationMismatch("Stripe Payment Intent Does Not Match Its Top-Up", "top_up_id", strconv.Itoa(topUp.ID), "payment_intent_id", intent.ID,
+			"intent", fmt.Sprintf("%d %s", intent.Amount, intent.Currency), "top_up", fmt.Sprintf("%d %s", topUp.Amount.Amount, topUp.Currency))
+		return NewTypedError(http.StatusConflict, "card_top_up_mismatch", "the payment intent does not match its top-up")
+	}
+
+	result := GatewayCallbackProcessed
+	err = as.applyStripeEvent(context.WithoutCancel(r.Context()), topUp, event)
+	if errors.Is(err, ErrCardTopUpStatusChanged) {
+		result = GatewayCallbackDuplicate
+	} else if err != nil {
+		return err
+	}
+
+	stripeWebhooks.WithLabelValues(event.Type, result).Inc()
+	return WriteResponse(w, r, http.StatusOK, StripeWebhookResponse{Result: result, TopUpID: topUp.ID, Status: topUp.Status})
+}
+
+// applyStripeEvent moves a pending top-up as a payment_intent event says, in one
+// transaction. A succeeded payment credits the account and publishes a
+// card_top_up.succeeded event through the outbox.
+//
+// Parameters:
+//   - ctx: The context of the database work, which should outlive the webhook request.
+//   - topUp: The top-up of the payment intent; it is reloaded and updated in place.
+//   - event: The verified event.
+//
+// Returns:
+//   - error: ErrCardTopUpStatusChanged if the top-up is no longer pending, as when the
+//     event was already applied, or the error of the store.
+func (as *APIServer) applyStripeEvent(ctx context.Context, topUp *CardTopUp, event *StripeEvent) error {
+	intent := &event.Data.Object
+	var deposit *Transaction
+	var updated CardTopUp
+
+	err := as.store.WithTx(ctx, func(tx Storage) error {
+		// Reload The Top-Up, Another Delivery May Have Moved It Meanwhile
+		current, err := tx.GetCardTopUpByPaymentIntent(ctx, intent.ID)
+		if err != nil {
+			return err
+		}
+		*topUp = *current
+		if topUp.Status != CardTopUpStatusPending {
+			return fmt.Errorf("%w: %d", ErrCardTopUpStatusChanged, topUp.ID)
+		}
+
+		// Change A Copy, The Top-Up Stays As Stored If The Transaction Rolls Back
+		updated = *current
+		switch event.Type {
+		case StripeEventPaymentFailed:
+			updated.FailureReason = "the card payment failed"
+			if intent.LastPaymentError != nil && intent.LastPaymentError.Message != "" {
+				updated.FailureReason = intent.LastPaymentError.Message
+			}
+			return tx.UpdateCardTopUp(ctx, &updated, CardTopUpStatusPending)
+		case StripeEventPaymentCanceled:
+			updated.Status, updated.FailureReason = CardTopUpStatusCanceled, "the payment was canceled"
+			if intent.CancellationReason != "" {
+				updated.FailureReason = "the payment was canceled: " + intent.CancellationReason
+			}
+			return tx.UpdateCardTopUp(ctx, &updated, CardTopUpStatusPending)
+		}
+
+		// The Charge Is The Reference, Credited Once Across All The Top-Ups
+		charge := intent.LatestCharge
+		if charge == "" {
+			charge = intent.ID
+		}
+		deposit = &Transaction{AccountID: updated.AccountID, Type: TransactionTypeCardTopUp, Amount: updated.Amount, Reference: charge}
+		if err := tx.DepositFunds(ctx, deposit); err != nil {
+			return err
+		}
+
+		updated.Status, updated.ChargeID, updated.TransactionID, updated.FailureReason = CardTopUpStatusSucceeded, charge, deposit.ID, ""
+		if err := tx.UpdateCardTopUp(ctx, &updated, CardTopUpStatusPending); err != nil {
+			return err
+		}
+
+		outboxEvent, err := newOutboxEvent(EventCardTopUpSucceeded, updated.AccountID, &updated)
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(ctx, []*OutboxEvent{outboxEvent})
+	})
+	if err != nil {
+		return err
+	}
+
+	*topUp = updated
+	if deposit != nil {
+		as.publishTransactions([]*Transaction{deposit})
+	}
+	return nil
+}
+
+// registerCardTopUpRoutes registers the card top-up endpoints and the Stripe webhook,
+// enabled by stripe.secret_key. The webhook authenticates with its signature, and
+// moving money it is refused with a 503 while the server shuts down, as is the creation
+// of a top-up.
+//
+// Routes:
+// - POST /api/v1/account/{id:[0-9]+}/top-ups: Starts a card top-up, returning the client secret of its payment intent.
+// - GET /api/v1/account/{id:[0-9]+}/top-ups/{top_up:[0-9]+}: Retrieves a card top-up of an account.
+// - POST /webhooks/stripe: Applies a payment_intent event of Stripe to its top-up.
+func (as *APIServer) registerCardTopUpRoutes(router, subRouter *mux.Router) {
+	if as.stripe == nil {
+		return
+	}
+
+	subRouter.HandleFunc("/account/{id:[0-9]+}/top-ups", withJWTAuth(as.withIdempotency(makeHTTPHandlerFunc(as.withMoneyMovement(as.handleCreateCardTopUp))), as.store)).Methods(http.MethodPost)
+	subRouter.HandleFunc("/account/{id:[0-9]+}/top-ups/{top_up:[0-9]+}", withJWTAuth(makeHTTPHandlerFunc(as.handleGetCardTopUp), as.store)).Methods(http.MethodGet)
+	router.HandleFunc("/webhooks/stripe", makeHTTPHandlerFunc(as.withMoneyMovement(as.withWebhookSignature(WebhookProviderStripe, as.handleStripeWebhook)))).Methods(http.MethodPost)
+}
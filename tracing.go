@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans started by the server.
+const tracerName = "github.com/moabdelazem/gobank"
+
+// tracingShutdownTimeout bounds the flush of the buffered spans on exit.
+const tracingShutdownTimeout = 5 * time.Second
+
+// tracer starts the spans of the server. It is a no-op until setupTracing installs
+// an exporting provider.
+var tracer = otel.Tracer(tracerName)
+
+// setupTracing installs the W3C trace context and baggage propagators, so the trace of
+// an incoming request is continued, and, when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, a tracer provider exporting the spans over
+// OTLP/HTTP. The exporter and the sampler are configured with the standard OTEL_*
+// variables; the service is named after OTEL_SERVICE_NAME, "gobank" by default.
+//
+// Parameters:
+//   - ctx: The context used to create the exporter.
+//
+// Returns:
+//   - func(): Flushes the buffered spans; to be called before the process exits.
+//   - error: An error if the exporter cannot be created.
+func setupTracing(ctx context.Context) (func(), error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func() {}, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(envString("OTEL_SERVICE_NAME", "gobank")),
+		semconv.ServiceVersion(Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	slog.Info("Exporting Traces Over OTLP")
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+
+		if err := provider.Shutdown(ctx); err != nil {
+			slog.Error("Error Flushing Traces", "error", err)
+		}
+	}, nil
+}
+
+// withTracing starts a server span for every request served by next, continuing the
+// trace of the traceparent header when one is sent. The probes and the metrics scrapes
+// are not traced.
+func withTracing(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.request",
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return r.URL.Path != "/healthz" && r.URL.Path != "/readyz" && r.URL.Path != "/metrics"
+		}),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
+
+// withRouteSpan names the span of the request after the matched route, such as
+// "GET /api/v1/account/{id:[0-9]+}", and records the route on it.
+func withRouteSpan(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// endSpan ends a span, marking it failed when err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// maxLoggedStatementLength bounds the SQL text of a slow query in the logs.
+const maxLoggedStatementLength = 500
+
+// slowQueries counts the statements slower than the slow_query_threshold setting. It is
+// shared by the primary and the replica pools and registered by newMetricsRegistry.
+var slowQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace, Subsystem: "db", Name: "slow_queries_total",
+	Help: "Number of SQL statements slower than the slow query threshold, by operation.",
+}, []string{"operation"})
+
+// pgxTracer starts a client span for every query run on the Postgres pools, named
+// after the SQL operation, and logs and counts the queries slower than slowThreshold.
+type pgxTracer struct {
+	slowThreshold time.Duration
+}
+
+// queryTraceKey is the context key of the statement and the start time of a query.
+type queryTraceKey struct{}
+
+// queryTrace is what TraceQueryEnd needs to know about the query it ends.
+type queryTrace struct {
+	sql   string
+	start time.Time
+}
+
+// TraceQueryStart starts the span of a query.
+func (t pgxTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, sqlOperation(data.SQL), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemPostgreSQL,
+		semconv.DBOperationName(sqlOperation(data.SQL)),
+		semconv.DBQueryText(data.SQL),
+	))
+	return context.WithValue(ctx, queryTraceKey{}, queryTrace{sql: data.SQL, start: time.Now()})
+}
+
+// TraceQueryEnd ends the span of a query with the number of rows it affected, and logs
+// the query when it was slow.
+func (t pgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	endSpan(span, data.Err)
+
+	query, ok := ctx.Value(queryTraceKey{}).(queryTrace)
+	if !ok || t.slowThreshold <= 0 {
+		return
+	}
+	if elapsed := time.Since(query.start); elapsed >= t.slowThreshold {
+		operation := sqlOperation(query.sql)
+		slowQueries.WithLabelValues(operation).Inc()
+
+		statement := strings.Join(strings.Fields(query.sql), " ")
+		if len(statement) > maxLoggedStatementLength {
+			statement = statement[:maxLoggedStatementLength] + "..."
+		}
+		slog.WarnContext(ctx, "Slow Query", "operation", operation, "duration", elapsed,
+			"rows", data.CommandTag.RowsAffected(), "statement", statement, "error", data.Err)
+	}
+}
+
+// sqlOperation returns the leading keyword of a statement, such as SELECT.
+func sqlOperation(sql string) string {
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		return strings.ToUpper(fields[0])
+	}
+	return "SQL"
+}
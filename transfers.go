@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxTransferWait bounds the ?wait= long-poll duration of GET /transfer/{transfer}.
+const maxTransferWait = 60 * time.Second
+
+// handleTransfer handles the transfer request by decoding the JSON payload
+// from the request body into a TransferRequest struct, recording a pending transfer,
+// moving the funds between the two accounts and publishing the resulting activity
+// to the account subscribers. The final transfer is written back with an HTTP status of 200 OK.
+//
+// Transfers of at least asyncTransferThreshold, or requests sent with the
+// "Prefer: respond-async" header, are only accepted: the pending transfer is returned
+// with 202 Accepted and a Location header to poll, and a worker moves the funds.
+// The request must carry the JWT token of the source account, see withJWTAuth.
+// Transfers with a provider_reference are accepted the same way: a worker settles them
+// with the settlement service when one is configured, see settleTransfer, and otherwise
+// they are held until the payment gateway settling them calls back, see
+// handleGatewayCallback.
+//
+// Parameters:
+// - w: http.ResponseWriter to write the response.
+// - r: *http.Request containing the transfer request.
+//
+// Returns:
+// - error: An error if the JSON decoding fails or the transfer cannot be completed.
+func (as *APIServer) handleTransfer(w http.ResponseWriter, r *http.Request) error {
+	transferReq := TransferRequest{}
+
+	if err := bindJSON(w, r, &transferReq); err != nil {
+		return err
+	}
+
+	// Only The Holder Of The Source Account Sends Its Money
+	if account := authAccountFromContext(r.Context()); account == nil || account.ID != transferReq.FromAccountID {
+		return NewTypedError(http.StatusForbidden, "transfer_forbidden", "transfers can only be sent from the account of the token")
+	}
+
+	if transferReq.ProviderReference != "" {
+		if !as.featureFlags.Enabled(FeatureExternalTransfers, transferReq.FromAccountID) {
+			return NewTypedError(http.StatusForbidden, "external_transfers_disabled", "external transfers are not enabled for this account")
+		}
+		if !as.webhookEnabled(WebhookProviderGateway) && as.settlement == nil {
+			return NewTypedError(http.StatusServiceUnavailable, "gateway_unavailable", "no payment gateway or settlement service is configured")
+		}
+	}
+
+	// The Amount Is In The Currency Of The Source Account
+	from, err := as.store.GetAccountById(r.Context(), transferReq.FromAccountID)
+	if err != nil {
+		return err
+	}
+	if transferReq.Currency != "" && transferReq.Currency != from.Currency {
+		return NewTypedError(http.StatusUnprocessableEntity, "currency_mismatch", fmt.Sprintf("account %d holds %s, not %s", from.ID, from.Currency, transferReq.Currency))
+	}
+	transferReq.Amount.Currency = from.Currency
+
+	// Record The Transfer Before Moving Any Money
+	transfer := &Transfer{
+		FromAccountID:     transferReq.FromAccountID,
+		ToAccountID:       transferReq.ToAccountID,
+		Amount:            transferReq.Amount,
+		Currency:          from.Currency,
+		RequestID:         requestIDFromContext(r.Context()),
+		ProviderReference: transferReq.ProviderReference,
+	}
+
+	if err := as.store.CreateTransfer(r.Context(), transfer); err != nil {
+		return err
+	}
+	as.publishTransferStatus(transfer)
+
+	w.Header().Set("Location", fmt.Sprintf("%s/%d", linksFor(r).transfer, transfer.ID))
+
+	// The Payment Gateway Settles The Transfer Through Its Callbacks
+	if transfer.ProviderReference != "" && as.settlement == nil {
+		return WriteResponse(w, r, http.StatusAccepted, newTransferResource(r, transfer))
+	}
+
+	// External Transfers, Large Ones, Or Clients Asking For It, Are Processed In The Background
+	if transfer.ProviderReference != "" || as.isAsyncTransfer(r, transfer) {
+		if !as.transfers.Enqueue(transfer) {
+			if err := as.store.UpdateTransferStatus(context.WithoutCancel(r.Context()), transfer, TransferStatusFailed, "transfer queue is full"); err != nil {
+				return err
+			}
+			as.publishTransferStatus(transfer)
+			return NewTypedError(http.StatusServiceUnavailable, "queue_full", "too many transfers in progress, retry later")
+		}
+
+		return WriteResponse(w, r, http.StatusAccepted, newTransferResource(r, transfer))
+	}
+
+	// A Client Going Away Must Not Leave The Transfer Half Done
+	if err := as.executeTransfer(context.WithoutCancel(r.Context()), transfer); err != nil {
+		return err
+	}
+
+	return WriteResponse(w, r, http.StatusOK, newTransferResource(r, transfer))
+}
+
+// isAsyncTransfer reports whether the transfer should be processed in the background.
+func (as *APIServer) isAsyncTransfer(r *http.Request, transfer *Transfer) bool {
+	if transfer.Amount.Amount >= as.asyncTransferThreshold {
+		return true
+	}
+
+	for _, prefer := range r.Header.Values("Prefer") {
+		for _, token := range strings.Split(prefer, ",") {
+			if strings.TrimSpace(token) == "respond-async" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// executeTransfer moves the funds of a pending transfer, records its final state
+// (completed or failed with the reason), and notifies the subscribers of both accounts.
+// The funds, the completed state, and the transfer.completed outbox events of both accounts
+// are written in one database transaction, so a transfer can never be left with its money
+// moved but its state unrecorded, nor announced as completed when it was rolled back.
+// A transaction rolled back because of a concurrent one is run again, up to the retry attempts
+// of the database settings, so the client only sees the conflicts that keep happening.
+//
+// Parameters:
+//   - ctx: The context of the database work, which should outlive the client request.
+//   - transfer: The pending transfer; its status and history are updated in place.
+//
+// Returns:
+//   - error: The reason of the failure if the funds could not be moved, otherwise nil.
+func (as *APIServer) executeTransfer(ctx context.Context, transfer *Transfer) error {
+	var txns []*Transaction
+	before := *transfer
+
+	transferErr := retryTx(ctx, as.store, &as.config.Database, "transfer", func(tx Storage) error {
+		// Forget The State Of An Attempt That Was Rolled Back
+		*transfer = before
+
+		var err error
+		txns, err = completeTransfer(ctx, tx, transfer)
+		return err
+	})
+
+	if transferErr != nil {
+		*transfer = before
+
+		if err := as.store.UpdateTransferStatus(ctx, transfer, TransferStatusFailed, transferErr.Error()); err != nil {
+			return err
+		}
+	}
+	as.publishTransferStatus(transfer)
+
+	if transferErr != nil {
+		return transferErr
+	}
+
+	as.publishTransactions(txns)
+	return nil
+}
+
+// completeTransfer moves the funds of a transfer, records it as completed, and adds the
+// transfer.completed outbox events of both accounts, within the transaction tx.
+//
+// Parameters:
+//   - ctx: The context of the database work.
+//   - tx: The storage of the running transaction.
+//   - transfer: The transfer; its status and history are updated in place.
+//
+// Returns:
+//   - []*Transaction: The debit and the credit of the transfer.
+//   - error: The reason the funds could not be moved, or the error of the store.
+func completeTransfer(ctx context.Context, tx Storage, transfer *Transfer) ([]*Transaction, error) {
+	txns, err := tx.TransferFunds(ctx, transfer.FromAccountID, transfer.ToAccountID, transfer.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.UpdateTransferStatus(ctx, transfer, TransferStatusCompleted, ""); err != nil {
+		return nil, err
+	}
+
+	events := make([]*OutboxEvent, 0, 2)
+	for _, accountID := range []int{transfer.FromAccountID, transfer.ToAccountID} {
+		event, err := newOutboxEvent(EventTransferCompleted, accountID, newTransferStatusEvent(transfer))
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return txns, tx.AddOutboxEvents(ctx, events)
+}
+
+// publishTransactions notifies the subscribers of both accounts of the ledger entries of a transfer.
+func (as *APIServer) publishTransactions(txns []*Transaction) {
+	for _, t := range txns {
+		as.events.Publish(AccountEvent{Type: EventTransactionCreated, AccountID: t.AccountID, Data: t})
+		as.events.Publish(AccountEvent{Type: EventBalanceChanged, AccountID: t.AccountID, Data: map[string]int64{
+			"balance": t.BalanceAfter.Amount,
+		}})
+	}
+}
+
+// newTransferStatusEvent returns the current state of a transfer as an event payload.
+func newTransferStatusEvent(transfer *Transfer) TransferStatusEvent {
+	return TransferStatusEvent{
+		TransferID:    transfer.ID,
+		FromAccountID: transfer.FromAccountID,
+		ToAccountID:   transfer.ToAccountID,
+		Amount:        transfer.Amount,
+		Currency:      transfer.Currency,
+		Status:        transfer.Status,
+		Reason:        transfer.FailureReason,
+	}
+}
+
+// publishTransferStatus notifies the subscribers of the accounts involved in a transfer
+// of its current state. The receiving account only learns about completed transfers.
+func (as *APIServer) publishTransferStatus(transfer *Transfer) {
+	status := newTransferStatusEvent(transfer)
+
+	as.events.Publish(AccountEvent{Type: EventTransferStatus, AccountID: transfer.FromAccountID, Data: status})
+
+	if transfer.Status == TransferStatusCompleted {
+		as.events.Publish(AccountEvent{Type: EventTransferStatus, AccountID: transfer.ToAccountID, Data: status})
+	}
+}
+
+// handleGetTransfer handles the HTTP request to retrieve the current state of a transfer,
+// the time of each of its state changes, and the failure reason if any.
+// With ?wait=<duration> (e.g. 30s, at most maxTransferWait) the request is held until the
+// transfer leaves its current state or the wait elapses, whichever comes first.
+// Only the holders of the two accounts of the transfer see it: for the token of any other
+// account the transfer is not found, so the sequential IDs do not reveal the others.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the transfer ID and the optional wait.
+//
+// Returns:
+//   - error: An error if the wait is invalid or the transfer cannot be found, otherwise nil.
+func (as *APIServer) handleGetTransfer(w http.ResponseWriter, r *http.Request) error {
+	var wait time.Duration
+	if value := r.URL.Query().Get("wait"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 || parsed > maxTransferWait {
+			return fmt.Errorf("wait must be a duration between 0s and %s", maxTransferWait)
+		}
+		wait = parsed
+	}
+
+	// Get The ID From The URL
+	id, err := strconv.Atoi(mux.Vars(r)["transfer"])
+	if err != nil {
+		return NewTypedError(http.StatusBadRequest, "bad_request", "invalid transfer ID")
+	}
+
+	transfer, err := as.store.GetTransfer(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if account := authAccountFromContext(r.Context()); account == nil || (account.ID != transfer.FromAccountID && account.ID != transfer.ToAccountID) {
+		return fmt.Errorf("%w: %d", ErrTransferNotFound, id)
+	}
+
+	if wait > 0 && !transfer.IsFinal() {
+		// The Long Poll May Outlast The Server Write Timeout
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + streamWriteWait))
+
+		if transfer, err = as.waitForTransferChange(r, transfer, wait); err != nil {
+			return err
+		}
+	}
+
+	return WriteResponse(w, r, http.StatusOK, newTransferResource(r, transfer))
+}
+
+// waitForTransferChange blocks until a status event for the transfer is published,
+// the wait elapses, or the client goes away, then returns the latest stored state.
+func (as *APIServer) waitForTransferChange(r *http.Request, transfer *Transfer, wait time.Duration) (*Transfer, error) {
+	events := as.events.Subscribe(transfer.FromAccountID)
+	defer as.events.Unsubscribe(transfer.FromAccountID, events)
+
+	// Re-Read After Subscribing So A Change In Between Is Not Missed
+	latest, err := as.store.GetTransfer(r.Context(), transfer.ID)
+	if err != nil || latest.Status != transfer.Status {
+		return latest, err
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return latest, nil
+		case <-timer.C:
+			return latest, nil
+		case event, ok := <-events:
+			if !ok {
+				return latest, nil
+			}
+			status, isStatus := event.Data.(TransferStatusEvent)
+			if event.Type == EventTransferStatus && isStatus && status.TransferID == transfer.ID && status.Status != transfer.Status {
+				return as.store.GetTransfer(r.Context(), transfer.ID)
+			}
+		}
+	}
+}
@@ -1,33 +1,222 @@
 package main
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"math/big"
 	"time"
+
+	"github.com/moabdelazem/gobank/money"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type TransferRequest struct {
-	ToAccountID int   `json:"to_account_id"`
-	Amount      int64 `json:"amount"`
+	FromAccountID int `json:"from_account_id"`
+	ToAccountID   int `json:"to_account_id"`
+	// Amount is in the minor units of the currency of the source account, which the
+	// handlers set once they have loaded it.
+	Amount money.Money `json:"amount"`
+	// Currency is the currency the client means the amount in; when set, the transfer is
+	// refused unless it is that of both accounts.
+	Currency string `json:"currency,omitempty"`
+	// ProviderReference is the reference of the transfer at the payment gateway settling
+	// it, which needs the external_transfers feature.
+	ProviderReference string `json:"provider_reference,omitempty"`
 }
 
 type CreateAccountRequest struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
+	// Currency is the currency the account holds its balance in, accounts.default_currency
+	// when empty.
+	Currency string `json:"currency,omitempty"`
+	// ExternalID is the reference of the customer in the systems of the client. A create
+	// repeated with the same one returns the account it created instead of opening another.
+	ExternalID string `json:"external_id,omitempty"`
+	// Password is what the holder logs in with, see handleLogin; only its bcrypt hash is stored.
+	Password string `json:"password"`
 }
 
 type Account struct {
-	ID        int       `json:"id"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Number    int64     `json:"number"`
-	Balance   int64     `json:"balance"`
+	ID        int         `json:"id"`
+	FirstName string      `json:"first_name"`
+	LastName  string      `json:"last_name"`
+	Number    int64       `json:"number"`
+	Balance   money.Money `json:"balance"`
+	// Currency is the uppercase ISO 4217 code of the balance and of every entry of the
+	// account, fixed when it is opened.
+	Currency  string    `json:"currency"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int       `json:"version"`
+	// Frozen accounts can neither send nor receive transfers.
+	Frozen bool `json:"frozen"`
+	// TransferLimit is the maximum amount of a single outgoing transfer, 0 means unlimited.
+	TransferLimit money.Money `json:"transfer_limit"`
+	// UpdatedAt is the time of the last change, advanced together with Version.
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is the time the account was deleted, nil while it is active.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// KYCStatus is the identity verification of the holder, driven by their KYC check,
+	// see kyc.go; not_started for the accounts never checked.
+	KYCStatus string `json:"kyc_status"`
+	// DormantAt is the time the dormancy check found the account without activity, nil
+	// while it is in use, see scheduler.go.
+	DormantAt *time.Time `json:"dormant_at,omitempty"`
+	// ExternalID is the reference the client created the account under, unique among all
+	// accounts, deleted ones included; empty when it gave none.
+	ExternalID string `json:"external_id,omitempty"`
+	// EncryptedPassword is the bcrypt hash of the password of the holder, empty for the
+	// accounts opened without one, such as the imported ones, which cannot log in.
+	EncryptedPassword string `json:"-"`
+}
+
+// LoginRequest is the body of POST /login, with which the holder of an account gets
+// the token of the account.
+type LoginRequest struct {
+	Number   int64  `json:"number"`
+	Password string `json:"password"`
+}
+
+// LoginResponse is the body of a successful login.
+type LoginResponse struct {
+	Number int64 `json:"number"`
+	// Token authenticates the requests of the account, in the Authorization header as Bearer <token>.
+	Token string `json:"token"`
+}
+
+// SetPassword stores the bcrypt hash of the password of the holder in the account.
+func (a *Account) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	a.EncryptedPassword = string(hash)
+	return nil
+}
+
+// ValidPassword reports whether password is that of the holder, never for an account
+// without one.
+func (a *Account) ValidPassword(password string) bool {
+	return a.EncryptedPassword != "" && bcrypt.CompareHashAndPassword([]byte(a.EncryptedPassword), []byte(password)) == nil
+}
+
+// UpdateAccountRequest is the body of a PATCH request on an account.
+// Only the fields that are set are changed.
+type UpdateAccountRequest struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+}
+
+// denominate sets the currency of the amounts of the account to that of the account,
+// once they are read from storage, which only stores the currency once.
+func (a *Account) denominate() {
+	a.Balance.Currency = a.Currency
+	a.TransferLimit.Currency = a.Currency
+}
+
+// Transaction represents a single ledger entry on an account.
+// Amount is negative for debits and positive for credits.
+type Transaction struct {
+	ID             int         `json:"id"`
+	AccountID      int         `json:"account_id"`
+	CounterpartyID int         `json:"counterparty_id"`
+	Type           string      `json:"type"`
+	Amount         money.Money `json:"amount"`
+	BalanceAfter   money.Money `json:"balance_after"`
+	// Currency is that of the account of the entry.
+	Currency  string    `json:"currency"`
 	CreatedAt time.Time `json:"created_at"`
+	Category  string    `json:"category,omitempty"`
+	// Reference is the external payment behind the entry, such as the Stripe charge of a card top-up.
+	Reference string `json:"reference,omitempty"`
+}
+
+// denominate sets the currency of the amounts of the entry to that of the entry, once
+// they are read from storage.
+func (t *Transaction) denominate() {
+	t.Amount.Currency = t.Currency
+	t.BalanceAfter.Currency = t.Currency
+}
+
+// Transaction Types
+const (
+	TransactionTypeTransfer = "transfer"
+	// TransactionTypeDeposit is an opening deposit without a counterparty, as created by the seed command.
+	TransactionTypeDeposit = "deposit"
+	// TransactionTypeCardTopUp is a deposit paid by card through Stripe, see topups.go.
+	TransactionTypeCardTopUp = "card_top_up"
+	// TransactionTypeInterest is the monthly interest credited by the interest accrual job, see scheduler.go.
+	TransactionTypeInterest = "interest"
+)
+
+// Transfer Statuses
+const (
+	TransferStatusPending    = "pending"
+	TransferStatusProcessing = "processing"
+	TransferStatusCompleted  = "completed"
+	TransferStatusFailed     = "failed"
+)
+
+// Transfer is a request to move money between two accounts, tracked through its states.
+type Transfer struct {
+	ID            int         `json:"id"`
+	FromAccountID int         `json:"from_account_id"`
+	ToAccountID   int         `json:"to_account_id"`
+	Amount        money.Money `json:"amount"`
+	// Currency is that of both accounts.
+	Currency      string `json:"currency"`
+	Status        string `json:"status"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	RequestID     string `json:"request_id,omitempty"`
+	// ProviderReference is the reference of the transfer at the payment gateway settling it.
+	ProviderReference string                `json:"provider_reference,omitempty"`
+	CreatedAt         time.Time             `json:"created_at"`
+	UpdatedAt         time.Time             `json:"updated_at"`
+	History           []TransferStateChange `json:"history"`
+}
+
+// TransferStateChange records when a transfer entered a state, and why for failures.
+type TransferStateChange struct {
+	Status string    `json:"status"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// denominate sets the currency of the amount of the transfer to that of the transfer,
+// once it is read from storage.
+func (t *Transfer) denominate() {
+	t.Amount.Currency = t.Currency
+}
+
+// IsFinal reports whether the transfer reached a state it can no longer leave.
+func (t *Transfer) IsFinal() bool {
+	return t.Status == TransferStatusCompleted || t.Status == TransferStatusFailed
+}
+
+// Account numbers are the 10 digit numbers, drawn at random so that they can neither
+// be guessed from one another nor collide but for a retry in a few billion.
+const (
+	accountNumberMin = 1_000_000_000
+	accountNumberMax = 9_999_999_999
+)
+
+// newAccountNumber draws the number of a new account from the system random source.
+func newAccountNumber() int64 {
+	n, err := rand.Int(rand.Reader, big.NewInt(accountNumberMax-accountNumberMin+1))
+	if err != nil {
+		// The System Random Source Only Fails When The Platform Is Broken
+		panic(err)
+	}
+	return accountNumberMin + n.Int64()
 }
 
-func NewAccount(firstName, lastName string) *Account {
+func NewAccount(firstName, lastName, currency string) *Account {
 	return &Account{
-		FirstName: firstName,
-		LastName:  lastName,
-		Number:    int64(rand.Intn(10000)),
+		FirstName:     firstName,
+		LastName:      lastName,
+		Number:        newAccountNumber(),
+		Balance:       money.Zero(currency),
+		Currency:      currency,
+		TransferLimit: money.Zero(currency),
+		KYCStatus:     KYCStatusNotStarted,
 	}
 }
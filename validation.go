@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/moabdelazem/gobank/money"
+)
+
+// Validation Error Codes
+const (
+	CodeRequired = "required"
+	CodeTooLong  = "too_long"
+	CodeInvalid  = "invalid"
+)
+
+// maxNameLength is the maximum length of first and last names.
+const maxNameLength = 100
+
+// maxExternalIDLength is the maximum length of the external ID of an account.
+const maxExternalIDLength = 100
+
+// Account passwords are hashed with bcrypt, which reads no more than 72 bytes of them.
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// maxJSONBodySize is the largest JSON request body bindJSON reads.
+const maxJSONBodySize = 1 << 20
+
+// FieldError describes why a single field of a request body is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// validatable is implemented by request DTOs that can check their own content.
+type validatable interface {
+	Validate() []FieldError
+}
+
+// bindJSON decodes the JSON request body into dst and, when dst implements validatable,
+// validates it. The body must hold a single JSON value of at most maxJSONBodySize bytes
+// with no fields dst does not have, so a misspelled field is reported instead of being
+// ignored. Validation failures are returned as a 422 TypedError listing every invalid field.
+//
+// Parameters:
+//   - w: http.ResponseWriter of the request, told to close the connection after a body too large.
+//   - r: *http.Request whose body is decoded.
+//   - dst: A pointer to the DTO to decode into.
+//
+// Returns:
+//   - error: A TypedError with 413 if the body is too large, with 400 if it is not valid
+//     JSON or has unknown fields, with 422 if the DTO is invalid, otherwise nil.
+func bindJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	body := http.MaxBytesReader(w, r.Body, maxJSONBodySize)
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil && dec.More() {
+		err = errors.New("unexpected data after the JSON value")
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return NewTypedError(http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("request body must be at most %d bytes", tooLarge.Limit))
+	}
+	if err != nil {
+		return NewTypedError(http.StatusBadRequest, "invalid_json", fmt.Sprintf("invalid request body: %s", err))
+	}
+
+	if v, ok := dst.(validatable); ok {
+		if fieldErrs := v.Validate(); len(fieldErrs) > 0 {
+			return newValidationError(fieldErrs)
+		}
+	}
+
+	return nil
+}
+
+// newValidationError creates the 422 TypedError reporting the given invalid fields.
+func newValidationError(fieldErrs []FieldError) *TypedError {
+	typedErr := NewTypedError(http.StatusUnprocessableEntity, "validation_failed", "request validation failed")
+	typedErr.Fields = fieldErrs
+	return typedErr
+}
+
+// validateName checks a required person name field.
+func validateName(field, value string) []FieldError {
+	if strings.TrimSpace(value) == "" {
+		return []FieldError{{Field: field, Code: CodeRequired, Message: fmt.Sprintf("%s is required", field)}}
+	}
+	if len(value) > maxNameLength {
+		return []FieldError{{Field: field, Code: CodeTooLong, Message: fmt.Sprintf("%s must be at most %d characters", field, maxNameLength)}}
+	}
+	return nil
+}
+
+// validateCurrency checks an optional currency code field.
+func validateCurrency(field, value string) []FieldError {
+	if value != "" && !money.IsCurrencyCode(value) {
+		return []FieldError{{Field: field, Code: CodeInvalid, Message: fmt.Sprintf("%s must be an uppercase ISO 4217 currency code, such as USD", field)}}
+	}
+	return nil
+}
+
+// validateExternalID checks an optional client reference field.
+func validateExternalID(field, value string) []FieldError {
+	if len(value) > maxExternalIDLength {
+		return []FieldError{{Field: field, Code: CodeTooLong, Message: fmt.Sprintf("%s must be at most %d characters", field, maxExternalIDLength)}}
+	}
+	if strings.TrimSpace(value) != value {
+		return []FieldError{{Field: field, Code: CodeInvalid, Message: fmt.Sprintf("%s must not start or end with spaces", field)}}
+	}
+	return nil
+}
+
+// validatePassword checks the required password of a new account.
+func validatePassword(field, value string) []FieldError {
+	switch {
+	case value == "":
+		return []FieldError{{Field: field, Code: CodeRequired, Message: fmt.Sprintf("%s is required", field)}}
+	case len(value) < minPasswordLength:
+		return []FieldError{{Field: field, Code: CodeInvalid, Message: fmt.Sprintf("%s must be at least %d characters", field, minPasswordLength)}}
+	case len(value) > maxPasswordLength:
+		return []FieldError{{Field: field, Code: CodeTooLong, Message: fmt.Sprintf("%s must be at most %d bytes", field, maxPasswordLength)}}
+	}
+	return nil
+}
+
+// Validate checks the fields of an account creation request.
+func (req *CreateAccountRequest) Validate() []FieldError {
+	errs := validateName("first_name", req.FirstName)
+	errs = append(errs, validateName("last_name", req.LastName)...)
+	errs = append(errs, validateCurrency("currency", req.Currency)...)
+	errs = append(errs, validateExternalID("external_id", req.ExternalID)...)
+	return append(errs, validatePassword("password", req.Password)...)
+}
+
+// Validate checks the fields of a login request.
+func (req *LoginRequest) Validate() []FieldError {
+	errs := []FieldError{}
+	if req.Number <= 0 {
+		errs = append(errs, FieldError{Field: "number", Code: CodeRequired, Message: "number is required"})
+	}
+	if req.Password == "" {
+		errs = append(errs, FieldError{Field: "password", Code: CodeRequired, Message: "password is required"})
+	}
+	return errs
+}
+
+// Validate checks the fields of an account update request. Only the fields present
+// in the body are checked.
+func (req *UpdateAccountRequest) Validate() []FieldError {
+	errs := []FieldError{}
+	if req.FirstName != nil {
+		errs = append(errs, validateName("first_name", *req.FirstName)...)
+	}
+	if req.LastName != nil {
+		errs = append(errs, validateName("last_name", *req.LastName)...)
+	}
+	return errs
+}
+
+// Validate checks the fields of a transfer request.
+func (req *TransferRequest) Validate() []FieldError {
+	errs := []FieldError{}
+
+	if req.FromAccountID <= 0 {
+		errs = append(errs, FieldError{Field: "from_account_id", Code: CodeRequired, Message: "from_account_id is required"})
+	}
+	if req.ToAccountID <= 0 {
+		errs = append(errs, FieldError{Field: "to_account_id", Code: CodeRequired, Message: "to_account_id is required"})
+	} else if req.ToAccountID == req.FromAccountID {
+		errs = append(errs, FieldError{Field: "to_account_id", Code: CodeInvalid, Message: "cannot transfer to the same account"})
+	}
+	if !req.Amount.IsPositive() {
+		errs = append(errs, FieldError{Field: "amount", Code: CodeInvalid, Message: "amount must be positive"})
+	}
+	errs = append(errs, validateCurrency("currency", req.Currency)...)
+	if len(req.ProviderReference) > providerReferenceMaxLen {
+		errs = append(errs, FieldError{Field: "provider_reference", Code: CodeTooLong, Message: fmt.Sprintf("provider_reference must be at most %d characters", providerReferenceMaxLen)})
+	}
+
+	return errs
+}
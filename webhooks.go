@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Webhook Providers, The Third Parties Calling The Inbound Webhook Routes
+const (
+	WebhookProviderGateway = "gateway"
+	WebhookProviderStripe  = "stripe"
+	WebhookProviderOnfido  = kycProviderOnfido
+)
+
+// webhookProviders lists the providers the secrets can be stored for.
+var webhookProviders = []string{WebhookProviderGateway, WebhookProviderStripe, WebhookProviderOnfido}
+
+// Webhook Signature Schemes
+const (
+	// webhookSchemeTimestamped signs the signing time and the body, in a header of
+	// t=<unix time>,v1=<hex HMAC-SHA256 of "<time>.<body>">, with one v1 per secret while
+	// the sender rotates; the payment gateway and Stripe use it.
+	webhookSchemeTimestamped = "timestamped"
+	// webhookSchemeBody signs the body alone, in a header of its hex HMAC-SHA256; Onfido
+	// uses it.
+	webhookSchemeBody = "body"
+)
+
+// Webhook Verification Results, Counted By webhookVerifications
+const (
+	webhookResultVerified = "verified"
+	webhookResultRejected = "rejected"
+)
+
+// Webhook Secret Constraints
+const (
+	webhookEncryptionKeySize = 32
+	webhookSecretMaxLen      = 255
+	// webhookSecretGeneratedSize is the number of random bytes of a generated secret.
+	webhookSecretGeneratedSize = 32
+	// webhookSecretMaxGrace bounds how long an expiring secret keeps verifying the
+	// webhooks, the time for the provider to switch to the new one.
+	webhookSecretMaxGrace = 7 * 24 * time.Hour
+)
+
+// Webhook Secret Audit Actions
+const (
+	AuditActionCreateWebhookSecret = "webhook_secret.create"
+	AuditActionExpireWebhookSecret = "webhook_secret.expire"
+)
+
+// errWebhookSignature is returned for a webhook that was not signed with a secret of
+// its provider, or that was signed too long ago.
+var errWebhookSignature = errors.New("invalid webhook signature")
+
+// webhookVerifications counts the signatures of the inbound webhooks checked, registered
+// by newMetricsRegistry.
+var webhookVerifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace, Subsystem: "webhooks", Name: "verifications_total",
+	Help: "Number of inbound webhook signatures checked, by provider and result.",
+}, []string{"provider", "result"})
+
+// WebhookSecret is a signing secret of the webhooks of a provider, stored encrypted.
+// The secrets of a provider are tried together with the one of its configuration, so
+// that a secret can be rotated without rejecting the webhooks signed with the old one.
+type WebhookSecret struct {
+	ID       int    `json:"id"`
+	Provider string `json:"provider"`
+	// Sealed is the secret encrypted with webhooks.encryption_key, never returned.
+	Sealed []byte `json:"-"`
+	// Fingerprint is the beginning of the SHA-256 of the secret, to tell the secrets apart.
+	Fingerprint string     `json:"fingerprint"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// Secret is the plain secret, returned once when the secret is created; it is not stored.
+	Secret string `json:"secret,omitempty" bson:"-"`
+}
+
+// Active reports whether the secret still verifies the webhooks at now.
+func (s *WebhookSecret) Active(now time.Time) bool {
+	return s.ExpiresAt == nil || now.Before(*s.ExpiresAt)
+}
+
+// webhookFingerprint returns the fingerprint of a secret.
+func webhookFingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+// CreateWebhookSecretRequest is the body of POST /admin/webhooks/secrets.
+type CreateWebhookSecretRequest struct {
+	Provider string `json:"provider"`
+	// Secret is the secret issued by the provider, such as the whsec_... of Stripe; a
+	// random one is generated when it is empty, to configure at the provider.
+	Secret string `json:"secret"`
+}
+
+// Validate checks the provider and the length of the secret.
+func (req *CreateWebhookSecretRequest) Validate() []FieldError {
+	var errs []FieldError
+	if req.Provider == "" {
+		errs = append(errs, FieldError{Field: "provider", Code: CodeRequired, Message: "provider is required"})
+	} else if !slices.Contains(webhookProviders, req.Provider) {
+		errs = append(errs, FieldError{Field: "provider", Code: CodeInvalid, Message: fmt.Sprintf("provider must be one of %s", strings.Join(webhookProviders, ", "))})
+	}
+	if len(req.Secret) > webhookSecretMaxLen {
+		errs = append(errs, FieldError{Field: "secret", Code: CodeTooLong, Message: fmt.Sprintf("secret must be at most %d characters", webhookSecretMaxLen)})
+	}
+	return errs
+}
+
+// webhookKeyring encrypts the stored webhook secrets with AES-256-GCM, under the key
+// of webhooks.encryption_key. The provider is authenticated with the secret, so that a
+// sealed secret copied to another provider does not open.
+type webhookKeyring struct {
+	aead cipher.AEAD
+}
+
+// parseWebhookEncryptionKey decodes webhooks.encryption_key, the base64 of 32 bytes.
+func parseWebhookEncryptionKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("the key is not base64: %w", err)
+	}
+	if len(key) != webhookEncryptionKeySize {
+		return nil, fmt.Errorf("the key is %d bytes, it must be %d", len(key), webhookEncryptionKeySize)
+	}
+	return key, nil
+}
+
+// newWebhookKeyring creates the keyring of the stored webhook secrets.
+//
+// Parameters:
+//   - encoded: The base64 encryption key, webhooks.encryption_key.
+//
+// Returns:
+//   - *webhookKeyring: The keyring, nil when the key is empty.
+//   - error: An error if the key is not the base64 of 32 bytes.
+func newWebhookKeyring(encoded string) (*webhookKeyring, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+
+	key, err := parseWebhookEncryptionKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookKeyring{aead: aead}, nil
+}
+
+// seal encrypts the secret of a provider, as the nonce followed by the ciphertext.
+func (k *webhookKeyring) seal(provider, secret string) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(secret)+k.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(nonce, nonce, []byte(secret), []byte(provider)), nil
+}
+
+// open decrypts a stored secret.
+func (k *webhookKeyring) open(secret *WebhookSecret) (string, error) {
+	if len(secret.Sealed) < k.aead.NonceSize() {
+		return "", errors.New("the sealed secret is truncated")
+	}
+
+	nonce, ciphertext := secret.Sealed[:k.aead.NonceSize()], secret.Sealed[k.aead.NonceSize():]
+	plain, err := k.aead.Open(nil, nonce, ciphertext, []byte(secret.Provider))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// webhookSpec describes how the webhooks of a provider are signed.
+type webhookSpec struct {
+	// header carries the signature.
+	header string
+	scheme string
+	// tolerance is how far the signing time may be from now, for the timestamped scheme.
+	tolerance time.Duration
+	// maxSize bounds the body of a webhook.
+	maxSize int64
+	// secret is the secret of the configuration, tried before the stored ones; empty
+	// when the provider only has stored secrets.
+	secret string
+}
+
+// webhookSpec returns how the webhooks of a provider are signed, and false for an
+// unknown provider.
+func (as *APIServer) webhookSpec(provider string) (webhookSpec, bool) {
+	switch provider {
+	case WebhookProviderGateway:
+		cfg := as.config.Gateway
+		return webhookSpec{header: gatewaySignatureHeader, scheme: webhookSchemeTimestamped, tolerance: cfg.WebhookTolerance, maxSize: gatewayCallbackMaxSize, secret: cfg.WebhookSecret}, true
+	case WebhookProviderStripe:
+		cfg := as.config.Stripe
+		return webhookSpec{header: stripeSignatureHeader, scheme: webhookSchemeTimestamped, tolerance: cfg.WebhookTolerance, maxSize: stripeWebhookMaxSize, secret: cfg.WebhookSecret}, true
+	case WebhookProviderOnfido:
+		return webhookSpec{header: onfidoSignatureHeader, scheme: webhookSchemeBody, maxSize: kycWebhookMaxSize, secret: as.config.KYC.Onfido.WebhookToken}, true
+	default:
+		return webhookSpec{}, false
+	}
+}
+
+// webhookEnabled reports whether the webhooks of a provider can be verified: with the
+// secret of its configuration, or with the stored secrets once the encryption key is set.
+func (as *APIServer) webhookEnabled(provider string) bool {
+	spec, known := as.webhookSpec(provider)
+	return known && (spec.secret != "" || as.webhookKeys != nil)
+}
+
+// verifyTimestampedSignature checks a signature header of the timestamped scheme
+// against the body of a webhook.
+//
+// Parameters:
+//   - header: The value of the signature header, t=<unix time>,v1=<signature>.
+//   - body: The raw body of the webhook.
+//   - secrets: The secrets the webhook may be signed with.
+//   - tolerance: How far the signing time may be from now, against replays.
+//   - now: The current time.
+//
+// Returns:
+//   - int: The index of the secret a signature matches.
+//   - error: errWebhookSignature, wrapped with the reason, if the webhook cannot be trusted.
+func verifyTimestampedSignature(header string, body []byte, secrets []string, tolerance time.Duration, now time.Time) (int, error) {
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			if signature, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, signature)
+			}
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return -1, fmt.Errorf("%w: the header must be t=<unix time>,v1=<signature>", errWebhookSignature)
+	}
+	if signedAt := time.Unix(unix, 0); signedAt.Before(now.Add(-tolerance)) || signedAt.After(now.Add(tolerance)) {
+		return -1, fmt.Errorf("%w: signed at %s, outside the tolerance of %s", errWebhookSignature, signedAt.UTC().Format(time.RFC3339), tolerance)
+	}
+
+	for i, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp))
+		mac.Write([]byte("."))
+		mac.Write(body)
+		expected := mac.Sum(nil)
+
+		for _, signature := range signatures {
+			if hmac.Equal(signature, expected) {
+				return i, nil
+			}
+		}
+	}
+	return -1, fmt.Errorf("%w: no signature matches", errWebhookSignature)
+}
+
+// verifyBodySignature checks a signature header of the body scheme, the hex HMAC of
+// the body, against the body of a webhook.
+//
+// Returns:
+//   - int: The index of the secret the signature matches.
+//   - error: errWebhookSignature, wrapped with the reason, if the webhook cannot be trusted.
+func verifyBodySignature(header string, body []byte, secrets []string) (int, error) {
+	signature, err := hex.DecodeString(header)
+	if err != nil || len(signature) == 0 {
+		return -1, fmt.Errorf("%w: the header must be the hex signature of the body", errWebhookSignature)
+	}
+
+	for i, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal(signature, mac.Sum(nil)) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: the signature does not match", errWebhookSignature)
+}
+
+// verify checks the signature header of a webhook with the scheme of the spec.
+func (spec webhookSpec) verify(header string, body []byte, secrets []string, now time.Time) (int, error) {
+	if len(secrets) == 0 {
+		return -1, fmt.Errorf("%w: no secret is configured", errWebhookSignature)
+	}
+	if spec.scheme == webhookSchemeBody {
+		return verifyBodySignature(header, body, secrets)
+	}
+	return verifyTimestampedSignature(header, body, secrets, spec.tolerance, now)
+}
+
+// activeWebhookSecrets returns the stored secrets of a provider that have not expired,
+// decrypted. A secret that does not open, sealed under another key, is skipped.
+func (as *APIServer) activeWebhookSecrets(ctx context.Context, provider string, now time.Time) ([]*WebhookSecret, error) {
+	if as.webhookKeys == nil {
+		return nil, nil
+	}
+
+	stored, err := as.store.GetWebhookSecrets(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	var active []*WebhookSecret
+	for _, secret := range stored {
+		if !secret.Active(now) {
+			continue
+		}
+		if secret.Secret, err = as.webhookKeys.open(secret); err != nil {
+			slog.ErrorContext(ctx, "Webhook Secret Does Not Open, Skipped", "provider", provider, "secret_id", secret.ID, "error", err)
+			continue
+		}
+		active = append(active, secret)
+	}
+	return active, nil
+}
+
+// verifyWebhook checks the signature of a webhook against the secret of the
+// configuration of its provider first, then against its active stored secrets, so
+// that the store is not read while the configured secret is in use.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - provider: The provider the webhook claims to come from.
+//   - spec: How the provider signs its webhooks.
+//   - header: The value of the signature header.
+//   - body: The raw body of the webhook.
+//
+// Returns:
+//   - error: errWebhookSignature, wrapped with the reason, if the webhook cannot be
+//     trusted, or the error of the store.
+func (as *APIServer) verifyWebhook(ctx context.Context, provider string, spec webhookSpec, header string, body []byte) error {
+	now := time.Now()
+	var configErr error
+	if spec.secret != "" {
+		if _, configErr = spec.verify(header, body, []string{spec.secret}, now); configErr == nil {
+			return nil
+		}
+		if as.webhookKeys == nil {
+			return configErr
+		}
+	}
+
+	stored, err := as.activeWebhookSecrets(ctx, provider, now)
+	if err != nil {
+		return err
+	}
+	if len(stored) == 0 && configErr != nil {
+		return configErr
+	}
+
+	secrets := make([]string, len(stored))
+	for i, secret := range stored {
+		secrets[i] = secret.Secret
+	}
+	matched, err := spec.verify(header, body, secrets, now)
+	if err != nil {
+		return err
+	}
+	slog.DebugContext(ctx, "Webhook Verified With A Stored Secret", "provider", provider, "secret_id", stored[matched].ID, "fingerprint", stored[matched].Fingerprint)
+	return nil
+}
+
+// withWebhookSignature verifies the signature of the inbound webhooks of a provider
+// before next: the body is read, bounded by the size of the webhooks of the provider,
+// checked against its secrets, and handed to next as the request body, to read whole.
+//
+// Parameters:
+//   - provider: The provider calling the route, such as gateway.
+//   - next: The handler of the verified webhooks.
+//
+// Returns:
+//   - apiFunc: The handler answering a 413 for a body too large, a 401 for a signature
+//     that does not verify, and a 500 when the stored secrets cannot be read, for the
+//     provider to deliver the webhook again.
+func (as *APIServer) withWebhookSignature(provider string, next apiFunc) apiFunc {
+	spec, known := as.webhookSpec(provider)
+	if !known {
+		panic(fmt.Sprintf("no webhook signature spec for provider %q", provider))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) error {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, spec.maxSize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return NewTypedError(http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("request body must be at most %d bytes", tooLarge.Limit))
+		}
+		if err != nil {
+			return err
+		}
+
+		err = as.verifyWebhook(r.Context(), provider, spec, r.Header.Get(spec.header), body)
+		if errors.Is(err, errWebhookSignature) {
+			webhookVerifications.WithLabelValues(provider, webhookResultRejected).Inc()
+			slog.WarnContext(r.Context(), "Rejected Webhook", "provider", provider, "error", err)
+			return NewTypedError(http.StatusUnauthorized, "invalid_signature", fmt.Sprintf("invalid %s webhook signature", provider))
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error Loading Webhook Secrets", "provider", provider, "error", err)
+			return err
+		}
+		webhookVerifications.WithLabelValues(provider, webhookResultVerified).Inc()
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		return next(w, r)
+	}
+}
+
+// handleAdminListWebhookSecrets lists the stored webhook secrets, of every provider or
+// of the one in the "provider" query parameter, expired ones included, without the
+// secrets themselves.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the optional provider query parameter.
+//
+// Returns:
+//   - error: A 400 for an unknown provider, or the error of the store.
+func (as *APIServer) handleAdminListWebhookSecrets(w http.ResponseWriter, r *http.Request) error {
+	provider := r.URL.Query().Get("provider")
+	if provider != "" && !slices.Contains(webhookProviders, provider) {
+		return NewTypedError(http.StatusBadRequest, "bad_request", fmt.Sprintf("provider must be one of %s", strings.Join(webhookProviders, ", ")))
+	}
+
+	secrets, err := as.store.GetWebhookSecrets(r.Context(), provider)
+	if err != nil {
+		return err
+	}
+	return WriteResponse(w, r, http.StatusOK, secrets)
+}
+
+// handleAdminCreateWebhookSecret stores a new webhook secret of a provider, which
+// verifies its webhooks next to the existing ones; the secret issued by the provider is
+// given in the request, or a random one is generated and returned, this once, to
+// configure at the provider. The creation is recorded in the audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the CreateWebhookSecretRequest.
+//
+// Returns:
+//   - error: A 400 for an invalid request, or the error of the store.
+func (as *APIServer) handleAdminCreateWebhookSecret(w http.ResponseWriter, r *http.Request) error {
+	req := CreateWebhookSecretRequest{}
+	if err := bindJSON(w, r, &req); err != nil {
+		return err
+	}
+
+	if req.Secret == "" {
+		random := make([]byte, webhookSecretGeneratedSize)
+		if _, err := rand.Read(random); err != nil {
+			return err
+		}
+		req.Secret = hex.EncodeToString(random)
+	}
+
+	sealed, err := as.webhookKeys.seal(req.Provider, req.Secret)
+	if err != nil {
+		return err
+	}
+	secret := &WebhookSecret{Provider: req.Provider, Sealed: sealed, Fingerprint: webhookFingerprint(req.Secret)}
+	if err := as.store.CreateWebhookSecret(r.Context(), secret); err != nil {
+		return err
+	}
+
+	as.audit(r, AuditActionCreateWebhookSecret, strconv.Itoa(secret.ID), map[string]interface{}{"provider": secret.Provider, "fingerprint": secret.Fingerprint})
+	slog.InfoContext(r.Context(), "Webhook Secret Created", "provider", secret.Provider, "secret_id", secret.ID, "fingerprint", secret.Fingerprint)
+
+	secret.Secret = req.Secret
+	return WriteResponse(w, r, http.StatusCreated, secret)
+}
+
+// handleAdminExpireWebhookSecret makes a stored webhook secret expire, at once or after
+// the grace period of the "grace" query parameter, such as 24h, for the provider to switch
+// to the new secret in the meantime. An expiry already set earlier is kept. The expiry
+// is recorded in the audit log.
+//
+// Parameters:
+//   - w: http.ResponseWriter to write the response.
+//   - r: *http.Request containing the ID of the secret and the optional grace period.
+//
+// Returns:
+//   - error: A 400 for an invalid grace period, a 404 if no secret has the ID, or the
+//     error of the store.
+func (as *APIServer) handleAdminExpireWebhookSecret(w http.ResponseWriter, r *http.Request) error {
+	var grace time.Duration
+	if value := r.URL.Query().Get("grace"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 || parsed > webhookSecretMaxGrace {
+			return NewTypedError(http.StatusBadRequest, "bad_request", fmt.Sprintf("grace must be a duration between 0s and %s", webhookSecretMaxGrace))
+		}
+		grace = parsed
+	}
+
+	// Get The ID From The URL
+	id := getId(w, r)
+
+	secret, err := as.store.ExpireWebhookSecret(r.Context(), id, time.Now().Add(grace))
+	if err != nil {
+		return err
+	}
+
+	as.audit(r, AuditActionExpireWebhookSecret, strconv.Itoa(secret.ID), map[string]interface{}{"provider": secret.Provider, "fingerprint": secret.Fingerprint, "expires_at": secret.ExpiresAt.Format(time.RFC3339)})
+	slog.InfoContext(r.Context(), "Webhook Secret Expiring", "provider", secret.Provider, "secret_id", secret.ID, "expires_at", secret.ExpiresAt)
+	return WriteResponse(w, r, http.StatusOK, secret)
+}
+
+// registerWebhookSecretRoutes registers the endpoints managing the stored webhook
+// secrets on the admin subrouter, enabled by webhooks.encryption_key. A secret is
+// rotated by adding the new one, and expiring the old one once the provider signs with
+// the new one, or with a grace period covering the switch.
+//
+// Routes:
+// - GET /admin/webhooks/secrets: Lists the stored secrets, of one provider with ?provider=.
+// - POST /admin/webhooks/secrets: Stores a new secret of a provider, generated when none is given.
+// - DELETE /admin/webhooks/secrets/{id:[0-9]+}: Expires a secret, after ?grace= when given.
+func (as *APIServer) registerWebhookSecretRoutes(admin *mux.Router) {
+	if as.webhookKeys == nil {
+		return
+	}
+
+	admin.HandleFunc("/webhooks/secrets", makeHTTPHandlerFunc(as.handleAdminListWebhookSecrets)).Methods(http.MethodGet)
+	admin.HandleFunc("/webhooks/secrets", makeHTTPHandlerFunc(as.handleAdminCreateWebhookSecret)).Methods(http.MethodPost)
+	admin.HandleFunc("/webhooks/secrets/{id:[0-9]+}", makeHTTPHandlerFunc(as.handleAdminExpireWebhookSecret)).Methods(http.MethodDelete)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// Default Async Transfer Settings
+const (
+	defaultTransferWorkers   = 4
+	defaultTransferQueueSize = 100
+	defaultAsyncThreshold    = 1_000_000
+)
+
+// transferQueue hands the accepted asynchronous transfers to the workers processing
+// them: the in-process transferWorkerPool, or the rabbitTransferQueue when RabbitMQ
+// is configured.
+type transferQueue interface {
+	// Start launches the workers.
+	Start()
+	// Enqueue hands a transfer to the workers, returning false if it cannot be accepted.
+	Enqueue(*Transfer) bool
+	// Stop refuses new transfers and waits for the ones in progress.
+	Stop()
+	// Durable reports whether the queued transfers survive a restart, so the pending
+	// ones need not be resumed.
+	Durable() bool
+}
+
+// transferWorkerPool processes accepted asynchronous transfers in the background
+// with a fixed number of workers reading from a bounded queue.
+type transferWorkerPool struct {
+	jobs    chan *Transfer
+	size    int
+	process func(*Transfer)
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// newTransferWorkerPool creates a worker pool with the given number of workers and queue size.
+// The pool does not process anything until Start is called.
+func newTransferWorkerPool(size, queueSize int, process func(*Transfer)) *transferWorkerPool {
+	return &transferWorkerPool{
+		jobs:    make(chan *Transfer, queueSize),
+		size:    size,
+		process: process,
+	}
+}
+
+// Durable reports that the queued transfers are lost on restart.
+func (p *transferWorkerPool) Durable() bool {
+	return false
+}
+
+// Start launches the workers.
+func (p *transferWorkerPool) Start() {
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for transfer := range p.jobs {
+				p.process(transfer)
+			}
+		}()
+	}
+}
+
+// Enqueue hands a transfer to the workers. It returns false without blocking when
+// the queue is full or the pool is stopping.
+func (p *transferWorkerPool) Enqueue(transfer *Transfer) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.stopped {
+		return false
+	}
+
+	select {
+	case p.jobs <- transfer:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop refuses new transfers and waits until the queued ones are processed.
+func (p *transferWorkerPool) Stop() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
+	close(p.jobs)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+// processAsyncTransfer is run by the transfer workers for every accepted transfer. The
+// external transfers, queued when a settlement service is configured, are settled with
+// it first, see settleTransfer.
+func (as *APIServer) processAsyncTransfer(transfer *Transfer) {
+	// Log Under The ID Of The Request That Created The Transfer
+	ctx := withLogAttrs(context.Background(), slog.String("request_id", transfer.RequestID), slog.Int("transfer_id", transfer.ID))
+
+	if err := as.store.UpdateTransferStatus(ctx, transfer, TransferStatusProcessing, ""); err != nil {
+		slog.ErrorContext(ctx, "Error Processing Transfer", "error", err)
+		return
+	}
+	as.publishTransferStatus(transfer)
+
+	// The Money Of An External Transfer Moves Once The Settlement Service Settles It
+	if transfer.ProviderReference != "" {
+		as.settleTransfer(ctx, transfer)
+		return
+	}
+
+	if err := as.executeTransfer(ctx, transfer); err != nil {
+		slog.WarnContext(ctx, "Transfer Failed", "error", err)
+	}
+}
+
+// resumePendingTransfers enqueues the transfers that were accepted but not yet picked
+// up by a worker when the server last stopped. A durable queue still holds them, and
+// enqueuing them again would process them twice. Without a settlement service, the
+// external transfers are left to the callbacks of the payment gateway.
+func (as *APIServer) resumePendingTransfers(ctx context.Context) {
+	if as.transfers.Durable() {
+		return
+	}
+
+	pending, err := as.store.GetTransfersByStatus(ctx, TransferStatusPending)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error Loading Pending Transfers", "error", err)
+		return
+	}
+
+	for _, transfer := range pending {
+		if transfer.ProviderReference != "" && as.settlement == nil {
+			continue
+		}
+		if !as.transfers.Enqueue(transfer) {
+			slog.WarnContext(ctx, "Transfer Queue Full, Transfer Stays Pending", "transfer_id", transfer.ID)
+		}
+	}
+}
+
+// envString reads a string from the environment, falling back to def when the
+// variable is unset or empty.
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
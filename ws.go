@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket Connection Settings
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = (wsPongWait * 9) / 10
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// handleWebSocket upgrades the connection of the account authenticated by withJWTAuth
+// to a WebSocket that receives live balance and transfer status updates for it.
+//
+// Parameters:
+//   - w: http.ResponseWriter used to upgrade the connection.
+//   - r: *http.Request containing the handshake.
+func (as *APIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	account := authAccountFromContext(r.Context())
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The Upgrader Already Replied With An HTTP Error
+		return
+	}
+	defer conn.Close()
+
+	// Subscribe To The Account Events
+	events := as.events.Subscribe(account.ID)
+	defer as.events.Unsubscribe(account.ID, events)
+
+	// Read Pump: Keep The Connection Alive And Detect Disconnects
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	// Write Pump: Push The Account Events To The Client
+	for {
+		select {
+		case <-done:
+			return
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(event); err != nil {
+				slog.WarnContext(r.Context(), "WebSocket Write Failed", "account_id", account.ID, "error", err)
+				return
+			}
+		}
+	}
+}
+
+// withWebSocketToken passes the "token" query parameter of a handshake on to withJWTAuth
+// as its Authorization header, for the browser clients, which cannot set headers on the
+// handshake. A request with an Authorization header keeps it.
+func withWebSocketToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("token"); token != "" && r.Header.Get("Authorization") == "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}